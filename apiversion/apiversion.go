@@ -0,0 +1,269 @@
+// Package apiversion mounts the API under versioned path prefixes
+// (/v1/products, /v2/carts, ...). Each version may rewrite the JSON
+// request and response bodies to and from the form the handlers use,
+// so handlers only implement the current representation.
+package apiversion
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/imariom/products-api/metrics"
+)
+
+// maxBodySize limits the request bodies read to be transformed.
+const maxBodySize = 1 << 20
+
+// Header names set on the responses of versioned requests.
+const (
+	VersionHeader     = "API-Version"
+	DeprecationHeader = "Deprecation"
+	SunsetHeader      = "Sunset"
+)
+
+var versionRequests = metrics.NewCounterVec("http_api_version_requests_total",
+	"Number of API requests by version, and whether the version was implied by an unversioned path.",
+	"version", "implied")
+
+// BodyFunc rewrites a JSON body.
+type BodyFunc func(body []byte) ([]byte, error)
+
+// Transform rewrites the JSON bodies of the requests under a path prefix.
+// Request converts bodies received from clients to the form of the
+// handlers, Response converts the bodies of successful responses back
+// to the form of the version. Either may be nil.
+type Transform struct {
+	Prefix   string
+	Request  BodyFunc
+	Response BodyFunc
+}
+
+// Version is a version of the API served under /<Name>.
+type Version struct {
+	Name string
+
+	// Deprecated is the date the version was deprecated, zero if it
+	// is not. Responses of deprecated versions carry the Deprecation
+	// header, and the Sunset header if Sunset is set too.
+	Deprecated time.Time
+	Sunset     time.Time
+
+	Transforms []Transform
+}
+
+// transform returns the transform applying to path, if any.
+func (v *Version) transform(path string) *Transform {
+	for i := range v.Transforms {
+		t := &v.Transforms[i]
+		if path == t.Prefix || strings.HasPrefix(path, t.Prefix+"/") {
+			return t
+		}
+	}
+
+	return nil
+}
+
+// Options configures a Router.
+type Options struct {
+	Versions []*Version
+
+	// Default is the name of the version served on unversioned paths.
+	Default string
+
+	// Prefixes are the paths of the versioned resources, e.g.
+	// "/products". Other paths (health checks, metrics) are served
+	// unversioned only.
+	Prefixes []string
+}
+
+// Router strips the version prefix of requests and serves them with
+// the handler, applying the transforms of the version.
+type Router struct {
+	next     http.Handler
+	opts     Options
+	versions map[string]*Version
+	def      *Version
+}
+
+var versionRe = regexp.MustCompile(`^/(v\d+)(/.*)?$`)
+
+// NewRouter returns a router serving the versions in opts with next.
+func NewRouter(next http.Handler, opts Options) (*Router, error) {
+	rt := &Router{next: next, opts: opts, versions: make(map[string]*Version)}
+
+	for _, v := range opts.Versions {
+		if !versionRe.MatchString("/" + v.Name) {
+			return nil, fmt.Errorf("invalid API version name %q", v.Name)
+		}
+		rt.versions[v.Name] = v
+	}
+
+	rt.def = rt.versions[opts.Default]
+	if rt.def == nil {
+		return nil, fmt.Errorf("unknown default API version %q", opts.Default)
+	}
+
+	return rt, nil
+}
+
+type versionKey struct{}
+
+// FromContext returns the API version of the request with ctx.
+func FromContext(ctx context.Context) string {
+	name, _ := ctx.Value(versionKey{}).(string)
+	return name
+}
+
+func (rt *Router) versioned(path string) bool {
+	for _, p := range rt.opts.Prefixes {
+		// custom methods such as /products:import are versioned too
+		if path == p || strings.HasPrefix(path, p+"/") || strings.HasPrefix(path, p+":") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ServeHTTP implements http.Handler.
+func (rt *Router) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	path := r.URL.Path
+	v := rt.def
+	implied := true
+
+	if m := versionRe.FindStringSubmatch(path); m != nil {
+		v = rt.versions[m[1]]
+		if v == nil {
+			http.Error(rw, fmt.Sprintf("unknown API version '%s'", m[1]), http.StatusNotFound)
+			return
+		}
+		path = m[2]
+		implied = false
+	}
+
+	if !rt.versioned(path) {
+		if !implied {
+			http.NotFound(rw, r)
+			return
+		}
+
+		rt.next.ServeHTTP(rw, r)
+		return
+	}
+
+	versionRequests.WithLabelValues(v.Name, strconv.FormatBool(implied)).Inc()
+
+	rw.Header().Set(VersionHeader, v.Name)
+	if !v.Deprecated.IsZero() {
+		rw.Header().Set(DeprecationHeader, fmt.Sprintf("@%d", v.Deprecated.Unix()))
+		if !v.Sunset.IsZero() {
+			rw.Header().Set(SunsetHeader, v.Sunset.UTC().Format(http.TimeFormat))
+		}
+	}
+
+	// requests must not be modified by handlers, so work on a copy
+	r2 := r.WithContext(context.WithValue(r.Context(), versionKey{}, v.Name))
+	r2.URL = new(url.URL)
+	*r2.URL = *r.URL
+	r2.URL.Path = path
+	r2.URL.RawPath = ""
+
+	t := v.transform(path)
+	if t == nil {
+		rt.next.ServeHTTP(rw, r2)
+		return
+	}
+
+	if t.Request != nil && isJSON(r.Header.Get("Content-Type"), true) && r.Body != nil && r.Body != http.NoBody {
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxBodySize))
+		if err != nil {
+			http.Error(rw, "failed to read request body", http.StatusBadRequest)
+			return
+		}
+
+		if len(body) > 0 {
+			if body, err = t.Request(body); err != nil {
+				http.Error(rw, fmt.Sprintf("invalid %s payload", v.Name), http.StatusBadRequest)
+				return
+			}
+		}
+
+		r2.Body = io.NopCloser(bytes.NewReader(body))
+		r2.ContentLength = int64(len(body))
+	}
+
+	if t.Response == nil {
+		rt.next.ServeHTTP(rw, r2)
+		return
+	}
+
+	buf := &bufferedWriter{header: rw.Header()}
+	rt.next.ServeHTTP(buf, r2)
+
+	body := buf.body.Bytes()
+	if buf.Status() < http.StatusMultipleChoices && len(body) > 0 && isJSON(rw.Header().Get("Content-Type"), false) {
+		var err error
+		if body, err = t.Response(body); err != nil {
+			http.Error(rw, "failed to convert response", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	rw.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	rw.WriteHeader(buf.Status())
+	rw.Write(body)
+}
+
+// isJSON reports whether a Content-Type is JSON, the only format the
+// transforms apply to. Other formats are served as they are.
+func isJSON(contentType string, orEmpty bool) bool {
+	if contentType == "" {
+		return orEmpty
+	}
+
+	mt, _, err := mime.ParseMediaType(contentType)
+	return err == nil && mt == "application/json"
+}
+
+// bufferedWriter holds a response so it can be transformed before it
+// is sent.
+type bufferedWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *bufferedWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *bufferedWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+}
+
+func (w *bufferedWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+
+	return w.body.Write(b)
+}
+
+func (w *bufferedWriter) Status() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+
+	return w.status
+}
@@ -0,0 +1,64 @@
+package apiversion
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// RenameFields returns a BodyFunc renaming the fields of a JSON object,
+// or of the objects in a JSON array, according to names (old: new).
+// Nested objects are left untouched.
+func RenameFields(names map[string]string) BodyFunc {
+	return func(body []byte) ([]byte, error) {
+		dec := json.NewDecoder(bytes.NewReader(body))
+		dec.UseNumber() // keep large IDs exact
+
+		var v interface{}
+		if err := dec.Decode(&v); err != nil {
+			return nil, err
+		}
+
+		switch v := v.(type) {
+		case map[string]interface{}:
+			rename(v, names)
+		case []interface{}:
+			for _, e := range v {
+				if obj, ok := e.(map[string]interface{}); ok {
+					rename(obj, names)
+				}
+			}
+		}
+
+		b, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+
+		return append(b, '\n'), nil
+	}
+}
+
+func rename(obj map[string]interface{}, names map[string]string) {
+	// collect first, so chained renames (a: b, b: c) are not applied twice
+	renamed := make(map[string]interface{})
+	for from, to := range names {
+		if val, ok := obj[from]; ok {
+			delete(obj, from)
+			renamed[to] = val
+		}
+	}
+
+	for k, val := range renamed {
+		obj[k] = val
+	}
+}
+
+// Invert returns the reverse mapping of names.
+func Invert(names map[string]string) map[string]string {
+	inv := make(map[string]string, len(names))
+	for from, to := range names {
+		inv[to] = from
+	}
+
+	return inv
+}
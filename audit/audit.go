@@ -0,0 +1,493 @@
+// Package audit implements the append-only log of the changes made to
+// the data stores. Every entry records who made a change, in which
+// request, and the fields it changed, and is chained to the previous
+// entry by its hash so that altering or removing entries is detected.
+// The last entry is anchored apart from the log, so that removing the
+// last entries is detected too.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/imariom/products-api/atomicfile"
+	"github.com/imariom/products-api/data"
+	"github.com/imariom/products-api/logging"
+)
+
+// GenesisHash is the previous hash of the first entry of a log.
+const GenesisHash = "0000000000000000000000000000000000000000000000000000000000000000"
+
+// DefaultMaxEntries is the number of entries kept by the logs only kept
+// in memory, unless configured otherwise.
+const DefaultMaxEntries = 10000
+
+// anchorInterval is the minimum time between two writes of the anchor
+// of a log, besides the one when it is closed.
+const anchorInterval = time.Second
+
+// Redacted replaces the values of the redacted fields in the diffs.
+const Redacted = `"[redacted]"`
+
+// redactedFields are the fields whose values are never logged, only
+// that they changed.
+var redactedFields = map[string]bool{"password": true}
+
+// FieldDiff is the value of a field before and after a change. Before
+// is omitted for the fields set by a creation, After for the fields
+// cleared by a deletion.
+type FieldDiff struct {
+	Before json.RawMessage `json:"before,omitempty"`
+	After  json.RawMessage `json:"after,omitempty"`
+}
+
+// Entry is a change recorded in the log.
+type Entry struct {
+	Seq       uint64               `json:"seq"`
+	Time      time.Time            `json:"time"`
+	Actor     string               `json:"actor"`
+	RequestID string               `json:"request_id,omitempty"`
+	Resource  string               `json:"resource"`
+	ID        uint64               `json:"id"`
+	Operation string               `json:"operation"`
+	Diff      map[string]FieldDiff `json:"diff"`
+
+	// PrevHash is the hash of the previous entry, and Hash the SHA-256
+	// of PrevHash followed by the JSON encoding of the entry without
+	// its hash.
+	PrevHash string `json:"prev_hash"`
+	Hash     string `json:"hash"`
+}
+
+// hash computes the hash of e.
+func (e *Entry) hash() string {
+	tmp := *e
+	tmp.Hash = ""
+	b, _ := json.Marshal(&tmp)
+
+	h := sha256.New()
+	h.Write([]byte(e.PrevHash))
+	h.Write(b)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Filter selects entries of the log. Zero fields select every entry.
+type Filter struct {
+	Resource  string
+	ID        *uint64
+	Actor     string
+	Operation string
+	Since     time.Time
+	Until     time.Time
+	Limit     int
+	Offset    int
+}
+
+func (f *Filter) matches(e *Entry) bool {
+	return (f.Resource == "" || e.Resource == f.Resource) &&
+		(f.ID == nil || e.ID == *f.ID) &&
+		(f.Actor == "" || e.Actor == f.Actor) &&
+		(f.Operation == "" || e.Operation == f.Operation) &&
+		(f.Since.IsZero() || !e.Time.Before(f.Since)) &&
+		(f.Until.IsZero() || e.Time.Before(f.Until))
+}
+
+// Verification is the result of Verify.
+type Verification struct {
+	Valid   bool   `json:"valid"`
+	Entries int    `json:"entries"`
+	Head    string `json:"head"` // hash of the last entry
+
+	// BrokenAt is the sequence number of the first entry not matching
+	// its hash, the hash of the entry before it or the anchor of the
+	// log, or following the last entry if the last entries were
+	// removed.
+	BrokenAt uint64 `json:"broken_at,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Anchor is the sequence number and the hash of the last entry of a
+// log. It is kept apart from the log, in a file next to it, so that
+// the removal of the last entries, which leaves a valid chain, is
+// detected.
+type Anchor struct {
+	Seq  uint64 `json:"seq"`
+	Hash string `json:"hash"`
+}
+
+// Options configures a Log.
+type Options struct {
+	// Path is the file the entries are appended to as JSON lines, and
+	// read back from. Its anchor is written to Path.anchor. The log is
+	// only kept in memory when empty.
+	Path string
+
+	// MaxEntries is the number of the last entries kept by a log only
+	// kept in memory, DefaultMaxEntries if zero.
+	MaxEntries int
+
+	Logger *logging.Logger
+}
+
+// Log is the append-only audit log. It implements data.Auditor. A log
+// with a file reads its entries back from it, only keeping the last
+// one in memory; otherwise it keeps its last entries.
+type Log struct {
+	mtx    sync.RWMutex
+	logger *logging.Logger
+
+	// head is the last entry recorded
+	head Anchor
+
+	// path is the file of the log, file the file opened to append to
+	// it, and size the size of the entries written
+	path string
+	file *os.File
+	size int64
+
+	// anchor is the anchor the log was opened with, written again at
+	// most every anchorInterval, unless the log was found broken
+	anchor   Anchor
+	anchored time.Time
+	broken   bool
+
+	// entries are the last entries of a log without a file
+	entries    []*Entry
+	maxEntries int
+}
+
+// Open loads the head and the anchor of the file of the log, if any,
+// and opens it to append the new entries. A broken log is reported but
+// does not prevent it from being opened, so the tampering can be looked
+// into with Verify.
+func Open(opts Options) (*Log, error) {
+	if opts.Logger == nil {
+		opts.Logger = logging.Default
+	}
+	if opts.MaxEntries <= 0 {
+		opts.MaxEntries = DefaultMaxEntries
+	}
+
+	l := &Log{
+		logger:     opts.Logger,
+		head:       Anchor{Hash: GenesisHash},
+		anchor:     Anchor{Hash: GenesisHash},
+		path:       opts.Path,
+		entries:    []*Entry{},
+		maxEntries: opts.MaxEntries,
+	}
+	if opts.Path == "" {
+		return l, nil
+	}
+
+	if err := l.load(); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(opts.Path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	l.file = f
+
+	if v := l.Verify(); !v.Valid {
+		l.broken = true
+		l.logger.Error("the audit log was tampered with", "broken_at", v.BrokenAt, "error", v.Error)
+	}
+
+	return l, nil
+}
+
+// load reads the anchor of the log, and its last entry.
+func (l *Log) load() error {
+	b, err := os.ReadFile(l.path + ".anchor")
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(b, &l.anchor); err != nil {
+			return fmt.Errorf("failed to decode audit log anchor: %w", err)
+		}
+	case !os.IsNotExist(err):
+		return fmt.Errorf("failed to read audit log anchor: %w", err)
+	}
+
+	f, err := os.Open(l.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer f.Close()
+
+	n := 0
+	err = scan(f, func(e *Entry) error {
+		l.head = Anchor{e.Seq, e.Hash}
+		n++
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read audit log %s: %w", l.path, err)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to read audit log: %w", err)
+	}
+	l.size = info.Size()
+
+	entriesLogged.Add(float64(n))
+	return nil
+}
+
+// scan calls fn with the entries read from r, one JSON line each.
+func scan(r io.Reader, fn func(e *Entry) error) error {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 16<<20)
+	for line := 1; sc.Scan(); line++ {
+		if len(bytes.TrimSpace(sc.Bytes())) == 0 {
+			continue
+		}
+
+		e := &Entry{}
+		if err := json.Unmarshal(sc.Bytes(), e); err != nil {
+			return fmt.Errorf("invalid entry at line %d: %w", line, err)
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+
+	return sc.Err()
+}
+
+// Close anchors the log and closes its file. The changes recorded after
+// are only kept in memory.
+func (l *Log) Close() error {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	if l.file == nil {
+		return nil
+	}
+
+	err := l.file.Sync()
+	if cerr := l.file.Close(); err == nil {
+		err = cerr
+	}
+	if aerr := l.anchorLocked(); err == nil {
+		err = aerr
+	}
+	l.file = nil
+
+	return err
+}
+
+// anchorLocked writes the head of the log to its anchor file, unless
+// the log was found broken: the anchor it was opened with is kept so
+// that Verify keeps reporting the tampering.
+func (l *Log) anchorLocked() error {
+	if l.broken || l.head == l.anchor {
+		return nil
+	}
+
+	if err := atomicfile.WriteJSON(l.path+".anchor", l.head); err != nil {
+		writeErrors.Inc()
+		l.logger.Error("failed to write audit log anchor", "seq", l.head.Seq, "error", err)
+		return err
+	}
+	l.anchor = l.head
+	l.anchored = time.Now()
+
+	return nil
+}
+
+// Record appends the change c to the log.
+func (l *Log) Record(c *data.Change) {
+	changes, err := diff(c.Before, c.After)
+	if err != nil {
+		l.logger.Error("failed to compute the diff of a change", "resource", c.Resource, "id", c.ID, "error", err)
+	}
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	e := &Entry{
+		Seq:       l.head.Seq + 1,
+		Time:      c.Time,
+		Actor:     c.Actor,
+		RequestID: c.RequestID,
+		Resource:  c.Resource,
+		ID:        c.ID,
+		Operation: c.Operation,
+		Diff:      changes,
+		PrevHash:  l.head.Hash,
+	}
+	e.Hash = e.hash()
+
+	if l.path == "" {
+		l.entries = append(l.entries, e)
+		if len(l.entries) > l.maxEntries {
+			l.entries = l.entries[1:]
+		}
+	} else {
+		if l.file == nil {
+			writeErrors.Inc()
+			l.logger.Error("failed to write audit log entry, the log is closed", "seq", e.Seq)
+			return
+		}
+
+		b, _ := json.Marshal(e)
+		n, err := l.file.Write(append(b, '\n'))
+		l.size += int64(n)
+		if err != nil {
+			writeErrors.Inc()
+			l.logger.Error("failed to write audit log entry", "seq", e.Seq, "error", err)
+			return
+		}
+	}
+
+	l.head = Anchor{e.Seq, e.Hash}
+	entriesLogged.Inc()
+
+	if l.file != nil && time.Since(l.anchored) >= anchorInterval {
+		l.anchorLocked()
+	}
+}
+
+// Entries returns the entries selected by f, in the order they were
+// recorded.
+func (l *Log) Entries(f Filter) ([]*Entry, error) {
+	entries := []*Entry{}
+	err := l.each(f, func(e *Entry) error {
+		entries = append(entries, e)
+		return nil
+	})
+
+	return entries, err
+}
+
+// Export writes the entries selected by f to w as JSON lines, so that
+// they can be verified out of the API.
+func (l *Log) Export(w io.Writer, f Filter) error {
+	enc := json.NewEncoder(w)
+	return l.each(f, func(e *Entry) error {
+		return enc.Encode(e)
+	})
+}
+
+// errLimit stops the reading of the entries once the limit is reached.
+var errLimit = fmt.Errorf("limit reached")
+
+// each calls fn with the entries selected by f.
+func (l *Log) each(f Filter, fn func(e *Entry) error) error {
+	skipped, n := 0, 0
+	err := l.all(func(e *Entry) error {
+		if !f.matches(e) {
+			return nil
+		}
+
+		if skipped < f.Offset {
+			skipped++
+			return nil
+		}
+
+		if f.Limit > 0 && n == f.Limit {
+			return errLimit
+		}
+		n++
+
+		return fn(e)
+	})
+	if err == errLimit {
+		return nil
+	}
+
+	return err
+}
+
+// all calls fn with every entry of the log, read from its file if it
+// has one. The entries of a log without file are never modified once
+// recorded, so they are shared with fn.
+func (l *Log) all(fn func(e *Entry) error) error {
+	l.mtx.RLock()
+	entries, size := l.entries, l.size
+	l.mtx.RUnlock()
+
+	if l.path == "" {
+		for _, e := range entries {
+			if err := fn(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	f, err := os.Open(l.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer f.Close()
+
+	// the entries being appended are left out
+	return scan(io.LimitReader(f, size), fn)
+}
+
+// Verify checks the hash chain of the whole log, and that it ends with
+// its last entry and goes through its anchor. The chain of a log
+// without file starts with the first entry it kept.
+func (l *Log) Verify() Verification {
+	l.mtx.RLock()
+	head, anchor := l.head, l.anchor
+	l.mtx.RUnlock()
+
+	v := Verification{Valid: true, Head: GenesisHash}
+	broken := func(seq uint64, msg string) error {
+		v.Valid, v.BrokenAt, v.Error = false, seq, msg
+		return errLimit
+	}
+
+	var last uint64
+	err := l.all(func(e *Entry) error {
+		if v.Entries == 0 && e.Seq > 1 && l.path == "" {
+			v.Head, last = e.PrevHash, e.Seq-1
+		}
+		v.Entries++
+
+		switch {
+		case e.PrevHash != v.Head:
+			return broken(e.Seq, "the previous hash does not match the hash of the previous entry")
+		case e.hash() != e.Hash:
+			return broken(e.Seq, "the hash does not match the content of the entry")
+		case e.Seq != last+1:
+			return broken(e.Seq, "the sequence numbers are not consecutive")
+		case e.Seq == anchor.Seq && e.Hash != anchor.Hash:
+			return broken(e.Seq, "the entry does not match the anchor of the log")
+		}
+
+		v.Head, last = e.Hash, e.Seq
+		return nil
+	})
+
+	switch {
+	case err != nil && err != errLimit:
+		v.Valid, v.Error = false, err.Error()
+	case !v.Valid:
+	case last < anchor.Seq || last < head.Seq:
+		broken(last+1, "the log ends before its last entry, entries were removed")
+	case last > head.Seq:
+		broken(head.Seq+1, "the log goes on after its last entry, entries were added")
+	}
+
+	return v
+}
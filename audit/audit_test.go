@@ -0,0 +1,442 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/imariom/products-api/data"
+	"github.com/imariom/products-api/logging"
+)
+
+var start = time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+
+// record records the changes of n products, every third one deleted
+// by the admin, the others created by a user.
+func record(l *Log, n int) {
+	for i := 1; i <= n; i++ {
+		c := &data.Change{
+			Time:      start.Add(time.Duration(i) * time.Minute),
+			Actor:     "user:1",
+			RequestID: "request-" + strings.Repeat("x", i),
+			Resource:  "products",
+			ID:        uint64(i),
+			Operation: "create",
+			After:     map[string]interface{}{"id": i, "name": "product"},
+		}
+		if i%3 == 0 {
+			c.Actor, c.Operation = "admin", "delete"
+			c.Before, c.After = c.After, nil
+		}
+		l.Record(c)
+	}
+}
+
+func open(t *testing.T, path string) *Log {
+	t.Helper()
+
+	l, err := Open(Options{Path: path, Logger: logging.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	return l
+}
+
+// readEntries returns the entries of the file of a log.
+func readEntries(t *testing.T, path string) []*Entry {
+	t.Helper()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var entries []*Entry
+	if err := scan(f, func(e *Entry) error {
+		entries = append(entries, e)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	return entries
+}
+
+// writeEntries replaces the file of a log with entries.
+func writeEntries(t *testing.T, path string, entries []*Entry) {
+	t.Helper()
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, e := range entries {
+		enc.Encode(e)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// rechain recomputes the hashes of entries from the i-th one, as done
+// by someone rewriting the log.
+func rechain(entries []*Entry, i int) []*Entry {
+	for ; i < len(entries); i++ {
+		if i > 0 {
+			entries[i].PrevHash = entries[i-1].Hash
+		}
+		entries[i].Seq = uint64(i + 1)
+		entries[i].Hash = entries[i].hash()
+	}
+	return entries
+}
+
+// TestVerify checks that the changes made to the file of a log while it
+// was closed are detected when it is opened again.
+func TestVerify(t *testing.T) {
+	tests := []struct {
+		name string
+
+		// tamper changes the entries of the file
+		tamper   func(entries []*Entry) []*Entry
+		entries  int
+		brokenAt uint64
+		err      string
+	}{
+		{
+			name:    "intact",
+			tamper:  func(entries []*Entry) []*Entry { return entries },
+			entries: 5,
+		},
+		{
+			name: "changed entry",
+			tamper: func(entries []*Entry) []*Entry {
+				entries[2].Actor = "user:1"
+				return entries
+			},
+			entries:  3,
+			brokenAt: 3,
+			err:      "the hash does not match the content of the entry",
+		},
+		{
+			name: "changed and rehashed entry",
+			tamper: func(entries []*Entry) []*Entry {
+				entries[2].Actor = "user:1"
+				entries[2].Hash = entries[2].hash()
+				return entries
+			},
+			entries:  4,
+			brokenAt: 4,
+			err:      "the previous hash does not match the hash of the previous entry",
+		},
+		{
+			name: "removed entry",
+			tamper: func(entries []*Entry) []*Entry {
+				return append(entries[:2], entries[3:]...)
+			},
+			entries:  3,
+			brokenAt: 4,
+			err:      "the previous hash does not match the hash of the previous entry",
+		},
+		{
+			name: "renumbered entry",
+			tamper: func(entries []*Entry) []*Entry {
+				entries[4].Seq = 6
+				entries[4].Hash = entries[4].hash()
+				return entries
+			},
+			entries:  5,
+			brokenAt: 6,
+			err:      "the sequence numbers are not consecutive",
+		},
+		{
+			name: "truncated",
+			tamper: func(entries []*Entry) []*Entry {
+				return entries[:3]
+			},
+			entries:  3,
+			brokenAt: 4,
+			err:      "the log ends before its last entry, entries were removed",
+		},
+		{
+			name: "emptied",
+			tamper: func(entries []*Entry) []*Entry {
+				return []*Entry{}
+			},
+			brokenAt: 1,
+			err:      "the log ends before its last entry, entries were removed",
+		},
+		{
+			name: "removed entry and rechained",
+			tamper: func(entries []*Entry) []*Entry {
+				return rechain(append(entries[:2], entries[3:]...), 2)
+			},
+			entries:  4,
+			brokenAt: 5,
+			err:      "the log ends before its last entry, entries were removed",
+		},
+		{
+			name: "last entry replaced",
+			tamper: func(entries []*Entry) []*Entry {
+				entries[4].Operation = "update"
+				return rechain(entries, 4)
+			},
+			entries:  5,
+			brokenAt: 5,
+			err:      "the entry does not match the anchor of the log",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "audit.log")
+
+			l := open(t, path)
+			record(l, 5)
+			want := l.Verify()
+			if !want.Valid || want.Entries != 5 {
+				t.Fatalf("got %+v before tampering, want a valid log of 5 entries", want)
+			}
+			if err := l.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			writeEntries(t, path, tt.tamper(readEntries(t, path)))
+
+			v := open(t, path).Verify()
+			if tt.err == "" {
+				if !reflect.DeepEqual(v, want) {
+					t.Errorf("got %+v, want %+v", v, want)
+				}
+				return
+			}
+
+			if v.Valid || v.Entries != tt.entries || v.BrokenAt != tt.brokenAt || v.Error != tt.err {
+				t.Errorf("got %+v, want broken at %d after %d entries: %s", v, tt.brokenAt, tt.entries, tt.err)
+			}
+		})
+	}
+}
+
+// TestOpenInvalid checks that a log with an invalid entry is not opened.
+func TestOpenInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	l := open(t, path)
+	record(l, 3)
+	l.Close()
+
+	b, _ := os.ReadFile(path)
+	lines := strings.SplitAfter(string(b), "\n")
+	lines[1] = "{not json\n"
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "")), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := Open(Options{Path: path, Logger: logging.Discard})
+	if err == nil || !strings.Contains(err.Error(), "invalid entry at line 2") {
+		t.Errorf("got error %v, want an invalid entry at line 2", err)
+	}
+}
+
+// TestVerifyStaysBroken checks that a truncated log is still reported
+// once new entries are recorded past its anchor.
+func TestVerifyStaysBroken(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	l := open(t, path)
+	record(l, 5)
+	l.Close()
+
+	writeEntries(t, path, readEntries(t, path)[:3])
+
+	l = open(t, path)
+	if v := l.Verify(); v.Valid {
+		t.Fatal("the truncated log is valid")
+	}
+	record(l, 2)
+	l.Close()
+
+	v := open(t, path).Verify()
+	if v.Valid || v.BrokenAt != 5 || v.Error != "the entry does not match the anchor of the log" {
+		t.Errorf("got %+v, want broken at 5", v)
+	}
+}
+
+// TestReopen checks that the entries recorded after a log is opened
+// again are chained to the previous ones.
+func TestReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	l := open(t, path)
+	record(l, 2)
+	l.Close()
+
+	l = open(t, path)
+	record(l, 3)
+
+	v := l.Verify()
+	entries, err := l.Entries(Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !v.Valid || v.Entries != 5 || v.Head != entries[4].Hash || entries[2].PrevHash != entries[1].Hash {
+		t.Errorf("got %+v, want a valid log of 5 entries", v)
+	}
+
+	// the anchor is written when the log is closed
+	l.Close()
+	b, err := os.ReadFile(path + ".anchor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var anchor Anchor
+	if err := json.Unmarshal(b, &anchor); err != nil || anchor != (Anchor{5, v.Head}) {
+		t.Errorf("got anchor %s, want %d %s", b, 5, v.Head)
+	}
+}
+
+// TestVerifyInMemory checks the chain of a log without file, from the
+// first entry it kept.
+func TestVerifyInMemory(t *testing.T) {
+	l, err := Open(Options{MaxEntries: 3, Logger: logging.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	record(l, 5)
+
+	entries, _ := l.Entries(Filter{})
+	if len(entries) != 3 || entries[0].Seq != 3 {
+		t.Fatalf("got %d entries from %d, want the last 3", len(entries), entries[0].Seq)
+	}
+
+	v := l.Verify()
+	if !v.Valid || v.Entries != 3 || v.Head != entries[2].Hash {
+		t.Errorf("got %+v, want a valid log of 3 entries", v)
+	}
+}
+
+func TestEntries(t *testing.T) {
+	id := uint64(3)
+
+	tests := []struct {
+		name   string
+		filter Filter
+		want   []uint64
+	}{
+		{"all", Filter{}, []uint64{1, 2, 3, 4, 5, 6, 7}},
+		{"resource", Filter{Resource: "carts"}, nil},
+		{"id", Filter{ID: &id}, []uint64{3}},
+		{"actor", Filter{Actor: "admin"}, []uint64{3, 6}},
+		{"operation", Filter{Operation: "create"}, []uint64{1, 2, 4, 5, 7}},
+		{"since", Filter{Since: start.Add(6 * time.Minute)}, []uint64{6, 7}},
+		{"until", Filter{Until: start.Add(2 * time.Minute)}, []uint64{1}},
+		{"limit", Filter{Operation: "create", Limit: 2}, []uint64{1, 2}},
+		{"offset", Filter{Operation: "create", Offset: 1, Limit: 2}, []uint64{2, 4}},
+		{"offset past the end", Filter{Offset: 7}, nil},
+	}
+
+	logs := map[string]*Log{}
+	logs["file"] = open(t, filepath.Join(t.TempDir(), "audit.log"))
+	logs["memory"], _ = Open(Options{Logger: logging.Discard})
+
+	for name, l := range logs {
+		record(l, 7)
+
+		for _, tt := range tests {
+			t.Run(name+"/"+tt.name, func(t *testing.T) {
+				entries, err := l.Entries(tt.filter)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				var got []uint64
+				for _, e := range entries {
+					got = append(got, e.ID)
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("got %v, want %v", got, tt.want)
+				}
+			})
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+	type user struct {
+		ID       uint64 `json:"id"`
+		Name     string `json:"name"`
+		Password string `json:"password"`
+	}
+
+	tests := []struct {
+		name          string
+		before, after interface{}
+		want          map[string]FieldDiff
+	}{
+		{
+			name:  "creation",
+			after: user{ID: 1, Name: "john", Password: "secret"},
+			want: map[string]FieldDiff{
+				"id":       {After: json.RawMessage(`1`)},
+				"name":     {After: json.RawMessage(`"john"`)},
+				"password": {After: json.RawMessage(Redacted)},
+			},
+		},
+		{
+			name:   "update",
+			before: user{ID: 1, Name: "john", Password: "secret"},
+			after:  user{ID: 1, Name: "johnd", Password: "other"},
+			want: map[string]FieldDiff{
+				"name":     {Before: json.RawMessage(`"john"`), After: json.RawMessage(`"johnd"`)},
+				"password": {Before: json.RawMessage(Redacted), After: json.RawMessage(Redacted)},
+			},
+		},
+		{
+			name:   "unchanged",
+			before: user{ID: 1, Name: "john"},
+			after:  &user{ID: 1, Name: "john"},
+			want:   map[string]FieldDiff{},
+		},
+		{
+			name:   "deletion",
+			before: map[string]interface{}{"id": 1, "tags": []string{"a"}},
+			want: map[string]FieldDiff{
+				"id":   {Before: json.RawMessage(`1`)},
+				"tags": {Before: json.RawMessage(`["a"]`)},
+			},
+		},
+		{
+			name:   "fields added and removed",
+			before: map[string]interface{}{"a": 1},
+			after:  map[string]interface{}{"b": 2},
+			want: map[string]FieldDiff{
+				"a": {Before: json.RawMessage(`1`)},
+				"b": {After: json.RawMessage(`2`)},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := diff(tt.before, tt.after)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %s, want %s", jsonString(got), jsonString(tt.want))
+			}
+		})
+	}
+}
+
+func jsonString(v interface{}) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
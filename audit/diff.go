@@ -0,0 +1,67 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// diff returns the fields of the JSON objects of before and after whose
+// values differ. The values of the redacted fields are replaced.
+func diff(before, after interface{}) (map[string]FieldDiff, error) {
+	b, err := fields(before)
+	if err != nil {
+		return nil, err
+	}
+
+	a, err := fields(after)
+	if err != nil {
+		return nil, err
+	}
+
+	d := map[string]FieldDiff{}
+	for k, v := range b {
+		if av, ok := a[k]; !ok || !bytes.Equal(v, av) {
+			d[k] = FieldDiff{Before: v, After: av}
+		}
+	}
+	for k, v := range a {
+		if _, ok := b[k]; !ok {
+			d[k] = FieldDiff{After: v}
+		}
+	}
+
+	for k, fd := range d {
+		if !redactedFields[k] {
+			continue
+		}
+
+		if fd.Before != nil {
+			fd.Before = json.RawMessage(Redacted)
+		}
+		if fd.After != nil {
+			fd.After = json.RawMessage(Redacted)
+		}
+		d[k] = fd
+	}
+
+	return d, nil
+}
+
+// fields returns the fields of the JSON object of v, none if v is nil.
+func fields(v interface{}) (map[string]json.RawMessage, error) {
+	m := map[string]json.RawMessage{}
+	if v == nil {
+		return m, nil
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
@@ -0,0 +1,11 @@
+package audit
+
+import "github.com/imariom/products-api/metrics"
+
+var (
+	entriesLogged = metrics.NewCounter("audit_entries_total",
+		"Number of entries in the audit log.")
+
+	writeErrors = metrics.NewCounter("audit_write_errors_total",
+		"Number of audit log entries that failed to be written to the file.")
+)
@@ -0,0 +1,152 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/imariom/products-api/data"
+)
+
+// dateLayout is the layout of the dates in cart date range paths.
+const dateLayout = "2006-01-02"
+
+// CartsService is the client of the /carts endpoints. It has no
+// Checkout method yet: the API does not check carts out, as products
+// have no stock to check them out against.
+type CartsService struct {
+	c *Client
+}
+
+// List returns a page of carts.
+func (s *CartsService) List(ctx context.Context, opts *ListOptions) (data.Carts, error) {
+	var carts data.Carts
+	if err := s.c.do(ctx, http.MethodGet, "/carts", opts.query(), nil, &carts); err != nil {
+		return nil, err
+	}
+
+	return carts, nil
+}
+
+// Iter returns an iterator over all carts, fetched in pages of
+// opts.Limit carts (100 if not set) starting at opts.Offset.
+func (s *CartsService) Iter(ctx context.Context, opts *ListOptions) *CartIterator {
+	return &CartIterator{ctx: ctx, s: s, opts: pageOptions(opts)}
+}
+
+// Get returns the cart with the given id.
+func (s *CartsService) Get(ctx context.Context, id uint64) (*data.Cart, error) {
+	cart := &data.Cart{}
+	if err := s.c.do(ctx, http.MethodGet, fmt.Sprintf("/carts/%d", id), nil, nil, cart); err != nil {
+		return nil, err
+	}
+
+	return cart, nil
+}
+
+// Create creates a cart and returns it with its assigned ID.
+func (s *CartsService) Create(ctx context.Context, c *data.Cart) (*data.Cart, error) {
+	cart := &data.Cart{}
+	if err := s.c.do(ctx, http.MethodPost, "/carts", nil, c, cart); err != nil {
+		return nil, err
+	}
+
+	return cart, nil
+}
+
+// Update replaces all the attributes of cart c.ID.
+func (s *CartsService) Update(ctx context.Context, c *data.Cart) (*data.Cart, error) {
+	return s.update(ctx, http.MethodPut, c)
+}
+
+// Patch updates the non-zero attributes of c on cart c.ID.
+func (s *CartsService) Patch(ctx context.Context, c *data.Cart) (*data.Cart, error) {
+	return s.update(ctx, http.MethodPatch, c)
+}
+
+func (s *CartsService) update(ctx context.Context, method string, c *data.Cart) (*data.Cart, error) {
+	cart := &data.Cart{}
+	if err := s.c.do(ctx, method, fmt.Sprintf("/carts/%d", c.ID), nil, c, cart); err != nil {
+		return nil, err
+	}
+
+	return cart, nil
+}
+
+// Delete deletes the cart with the given id and returns it.
+func (s *CartsService) Delete(ctx context.Context, id uint64) (*data.Cart, error) {
+	cart := &data.Cart{}
+	if err := s.c.do(ctx, http.MethodDelete, fmt.Sprintf("/carts/%d", id), nil, nil, cart); err != nil {
+		return nil, err
+	}
+
+	return cart, nil
+}
+
+// AddItem adds quantity of the product productID to the cart id, merging
+// it with the item of the product if any.
+func (s *CartsService) AddItem(ctx context.Context, id, productID, quantity uint64) (*data.Cart, error) {
+	cart := &data.Cart{}
+	item := &data.Item{ProductID: productID, Quantity: quantity}
+	if err := s.c.do(ctx, http.MethodPost, fmt.Sprintf("/carts/%d/items", id), nil, item, cart); err != nil {
+		return nil, err
+	}
+
+	return cart, nil
+}
+
+// AdjustItem increments, decrements or sets the quantity of the product
+// productID in the cart id, as the server applies adj atomically. The
+// item is removed when its quantity drops to zero.
+func (s *CartsService) AdjustItem(ctx context.Context, id, productID uint64, adj *data.ItemAdjustment) (*data.Cart, error) {
+	cart := &data.Cart{}
+	if err := s.c.do(ctx, http.MethodPatch, fmt.Sprintf("/carts/%d/items/%d", id, productID), nil, adj, cart); err != nil {
+		return nil, err
+	}
+
+	return cart, nil
+}
+
+// RemoveItem removes the product productID from the cart id.
+func (s *CartsService) RemoveItem(ctx context.Context, id, productID uint64) (*data.Cart, error) {
+	cart := &data.Cart{}
+	if err := s.c.do(ctx, http.MethodDelete, fmt.Sprintf("/carts/%d/items/%d", id, productID), nil, nil, cart); err != nil {
+		return nil, err
+	}
+
+	return cart, nil
+}
+
+// ListByUser returns the carts of a user.
+func (s *CartsService) ListByUser(ctx context.Context, userID uint64) (data.Carts, error) {
+	var carts data.Carts
+	if err := s.c.do(ctx, http.MethodGet, fmt.Sprintf("/carts/user/%d", userID), nil, nil, &carts); err != nil {
+		return nil, err
+	}
+
+	return carts, nil
+}
+
+// ListInDateRange returns the carts dated between start and end. A zero
+// start or end leaves that side of the range open.
+func (s *CartsService) ListInDateRange(ctx context.Context, start, end time.Time) (data.Carts, error) {
+	var path string
+	switch {
+	case !start.IsZero() && !end.IsZero():
+		path = fmt.Sprintf("/carts/startdate=%s&enddate=%s", start.Format(dateLayout), end.Format(dateLayout))
+	case !start.IsZero():
+		path = "/carts/startdate=" + start.Format(dateLayout)
+	case !end.IsZero():
+		path = "/carts/enddate=" + end.Format(dateLayout)
+	default:
+		return s.List(ctx, nil)
+	}
+
+	var carts data.Carts
+	if err := s.c.do(ctx, http.MethodGet, path, nil, nil, &carts); err != nil {
+		return nil, err
+	}
+
+	return carts, nil
+}
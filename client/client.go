@@ -0,0 +1,289 @@
+// Package client is a typed Go client for the store API. It shares the
+// product, cart and user types of the data package with the server.
+//
+//	c, err := client.New("http://127.0.0.1:8080", nil)
+//	products, err := c.Products.List(ctx, &client.ListOptions{Limit: 10})
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultMaxRetries = 3
+	defaultMinBackoff = 100 * time.Millisecond
+	defaultMaxBackoff = 2 * time.Second
+	defaultUserAgent  = "store-api-go-client"
+)
+
+// Options configures a Client. The zero value is valid.
+type Options struct {
+	// Transport performs the HTTP requests, http.DefaultTransport if
+	// nil. Wrap it to add tracing, authentication or to stub the API
+	// in tests (e.g. tracing.Transport).
+	Transport http.RoundTripper
+
+	// Timeout limits every attempt of a request. Zero means no limit
+	// other than the context of the call.
+	Timeout time.Duration
+
+	// MaxRetries is the number of times idempotent requests are retried
+	// on network errors and on 429, 502, 503 and 504 responses. Zero
+	// means the default of 3, a negative value disables retries.
+	MaxRetries int
+
+	// MinBackoff and MaxBackoff bound the exponential backoff between
+	// retries, 100ms and 2s by default. A Retry-After response header
+	// takes precedence, capped to MaxBackoff.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// UserAgent is sent with every request.
+	UserAgent string
+}
+
+// Client is a client of the store API. It is safe for concurrent use.
+type Client struct {
+	baseURL *url.URL
+	http    *http.Client
+	opts    Options
+
+	Products *ProductsService
+	Carts    *CartsService
+	Users    *UsersService
+}
+
+// New returns a client of the API served at baseURL.
+func New(baseURL string, opts *Options) (*Client, error) {
+	u, err := url.Parse(strings.TrimSuffix(baseURL, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid base URL: %w", err)
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid base URL %q: scheme must be http or https", baseURL)
+	}
+
+	c := &Client{baseURL: u}
+	if opts != nil {
+		c.opts = *opts
+	}
+
+	if c.opts.MaxRetries == 0 {
+		c.opts.MaxRetries = defaultMaxRetries
+	}
+	if c.opts.MinBackoff <= 0 {
+		c.opts.MinBackoff = defaultMinBackoff
+	}
+	if c.opts.MaxBackoff <= 0 {
+		c.opts.MaxBackoff = defaultMaxBackoff
+	}
+	if c.opts.UserAgent == "" {
+		c.opts.UserAgent = defaultUserAgent
+	}
+
+	transport := c.opts.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	c.http = &http.Client{Transport: transport, Timeout: c.opts.Timeout}
+
+	c.Products = &ProductsService{c}
+	c.Carts = &CartsService{c}
+	c.Users = &UsersService{c}
+
+	return c, nil
+}
+
+// ListOptions are the pagination and sorting options of list calls.
+type ListOptions struct {
+	// Limit is the maximum number of results, all if zero.
+	Limit int
+
+	// Offset is the number of results to skip.
+	Offset int
+
+	// Sort is the sort order, "asc" or "desc".
+	Sort string
+}
+
+func (o *ListOptions) query() url.Values {
+	q := url.Values{}
+	if o == nil {
+		return q
+	}
+
+	if o.Limit > 0 {
+		q.Set("limit", strconv.Itoa(o.Limit))
+	}
+	if o.Offset > 0 {
+		q.Set("offset", strconv.Itoa(o.Offset))
+	}
+	if o.Sort != "" {
+		q.Set("sort", o.Sort)
+	}
+
+	return q
+}
+
+// idempotent reports whether requests with method may be retried.
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete, http.MethodOptions:
+		return true
+	}
+
+	return false
+}
+
+// retryable reports whether a response with status may succeed later.
+func retryable(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+
+	return false
+}
+
+// do sends a request with the JSON encoding of in as body (if not nil)
+// and decodes the JSON response into out (if not nil). Idempotent
+// requests are retried with exponential backoff.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, in, out interface{}) error {
+	var body []byte
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("failed to encode request: %w", err)
+		}
+		body = b
+	}
+
+	u := *c.baseURL
+	u.Path += path
+	if len(query) > 0 {
+		u.RawQuery = query.Encode()
+	}
+
+	retries := 0
+	if idempotent(method) && c.opts.MaxRetries > 0 {
+		retries = c.opts.MaxRetries
+	}
+
+	for attempt := 0; ; attempt++ {
+		res, err := c.send(ctx, method, u.String(), body)
+
+		var wait time.Duration
+		switch {
+		case err != nil:
+			// errors of the context are final
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if attempt >= retries {
+				return err
+			}
+
+		case retryable(res.StatusCode) && attempt < retries:
+			wait = retryAfter(res.Header.Get("Retry-After"))
+			io.Copy(io.Discard, res.Body)
+			res.Body.Close()
+
+		default:
+			return decodeResponse(res, out)
+		}
+
+		if wait <= 0 {
+			wait = c.backoff(attempt)
+		}
+		if wait > c.opts.MaxBackoff {
+			wait = c.opts.MaxBackoff
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func (c *Client) send(ctx context.Context, method, u string, body []byte) (*http.Response, error) {
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u, r)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", c.opts.UserAgent)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	return c.http.Do(req)
+}
+
+// backoff returns the delay before retry attempt+1, doubling from
+// MinBackoff with full jitter.
+func (c *Client) backoff(attempt int) time.Duration {
+	d := c.opts.MinBackoff << uint(attempt)
+	if d <= 0 || d > c.opts.MaxBackoff {
+		d = c.opts.MaxBackoff
+	}
+
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// retryAfter parses a Retry-After header in seconds or as an HTTP date.
+func retryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+
+	if secs, err := strconv.Atoi(v); err == nil {
+		return time.Duration(secs) * time.Second
+	}
+
+	if t, err := http.ParseTime(v); err == nil {
+		return time.Until(t)
+	}
+
+	return 0
+}
+
+func decodeResponse(res *http.Response, out interface{}) error {
+	defer res.Body.Close()
+
+	if res.StatusCode >= http.StatusBadRequest {
+		return newError(res)
+	}
+
+	if out == nil {
+		_, err := io.Copy(io.Discard, res.Body)
+		return err
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,89 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// requestIDHeader is the header the API echoes the request ID in.
+const requestIDHeader = "X-Request-ID"
+
+// Sentinel errors matched by errors.Is against an *Error.
+var (
+	ErrBadRequest    = errors.New("bad request")
+	ErrNotFound      = errors.New("not found")
+	ErrConflict      = errors.New("conflict")
+	ErrUnprocessable = errors.New("unprocessable entity")
+	ErrRateLimited   = errors.New("rate limited")
+	ErrUnavailable   = errors.New("service unavailable")
+	ErrServer        = errors.New("server error")
+)
+
+// Error is an error response of the API.
+type Error struct {
+	StatusCode int
+	Message    string
+
+	// Field is the invalid field of a validation error, if any.
+	Field string
+
+	// RequestID identifies the request in the server logs.
+	RequestID string
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("store api: %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+
+	return msg
+}
+
+// Is matches e against the sentinel errors of its status code.
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrBadRequest:
+		return e.StatusCode == http.StatusBadRequest
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrConflict:
+		return e.StatusCode == http.StatusConflict
+	case ErrUnprocessable:
+		return e.StatusCode == http.StatusUnprocessableEntity
+	case ErrRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests
+	case ErrUnavailable:
+		return e.StatusCode == http.StatusServiceUnavailable
+	case ErrServer:
+		return e.StatusCode >= http.StatusInternalServerError
+	}
+
+	return false
+}
+
+// validationRe matches the messages of data.ValidationError.
+var validationRe = regexp.MustCompile(`^invalid field '([^']*)': `)
+
+// maxErrorBody limits how much of an error response is read.
+const maxErrorBody = 4 << 10
+
+func newError(res *http.Response) *Error {
+	b, _ := io.ReadAll(io.LimitReader(res.Body, maxErrorBody))
+
+	e := &Error{
+		StatusCode: res.StatusCode,
+		Message:    strings.TrimSpace(string(b)),
+		RequestID:  res.Header.Get(requestIDHeader),
+	}
+
+	if m := validationRe.FindStringSubmatch(e.Message); m != nil {
+		e.Field = m[1]
+	}
+
+	return e
+}
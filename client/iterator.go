@@ -0,0 +1,138 @@
+package client
+
+import (
+	"context"
+
+	"github.com/imariom/products-api/data"
+)
+
+// defaultPageSize is the page size of iterators when not set.
+const defaultPageSize = 100
+
+func pageOptions(opts *ListOptions) ListOptions {
+	o := ListOptions{}
+	if opts != nil {
+		o = *opts
+	}
+
+	if o.Limit <= 0 {
+		o.Limit = defaultPageSize
+	}
+
+	return o
+}
+
+// ProductIterator iterates over the pages of a product listing:
+//
+//	it := c.Products.Iter(ctx, nil)
+//	for it.Next() {
+//		p := it.Product()
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+type ProductIterator struct {
+	ctx  context.Context
+	s    *ProductsService
+	opts ListOptions
+
+	page data.Products
+	cur  *data.Product
+	done bool
+	err  error
+}
+
+// Next advances to the next product, fetching the next page when
+// needed. It returns false at the end or on error.
+func (it *ProductIterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+
+	if len(it.page) == 0 {
+		if it.done {
+			return false
+		}
+
+		page, err := it.s.List(it.ctx, &it.opts)
+		if err != nil {
+			it.err = err
+			return false
+		}
+
+		it.opts.Offset += len(page)
+		it.done = len(page) < it.opts.Limit
+		it.page = page
+
+		if len(page) == 0 {
+			return false
+		}
+	}
+
+	it.cur, it.page = it.page[0], it.page[1:]
+	return true
+}
+
+// Product returns the current product.
+func (it *ProductIterator) Product() *data.Product {
+	return it.cur
+}
+
+// Err returns the error that stopped the iteration, if any.
+func (it *ProductIterator) Err() error {
+	return it.err
+}
+
+// CartIterator iterates over the pages of a cart listing. It is used
+// like ProductIterator.
+type CartIterator struct {
+	ctx  context.Context
+	s    *CartsService
+	opts ListOptions
+
+	page data.Carts
+	cur  *data.Cart
+	done bool
+	err  error
+}
+
+// Next advances to the next cart, fetching the next page when needed.
+// It returns false at the end or on error.
+func (it *CartIterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+
+	if len(it.page) == 0 {
+		if it.done {
+			return false
+		}
+
+		page, err := it.s.List(it.ctx, &it.opts)
+		if err != nil {
+			it.err = err
+			return false
+		}
+
+		it.opts.Offset += len(page)
+		it.done = len(page) < it.opts.Limit
+		it.page = page
+
+		if len(page) == 0 {
+			return false
+		}
+	}
+
+	it.cur, it.page = it.page[0], it.page[1:]
+	return true
+}
+
+// Cart returns the current cart.
+func (it *CartIterator) Cart() *data.Cart {
+	return it.cur
+}
+
+// Err returns the error that stopped the iteration, if any.
+func (it *CartIterator) Err() error {
+	return it.err
+}
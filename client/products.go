@@ -0,0 +1,101 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/imariom/products-api/data"
+)
+
+// ProductsService is the client of the /products endpoints.
+type ProductsService struct {
+	c *Client
+}
+
+// List returns a page of products.
+func (s *ProductsService) List(ctx context.Context, opts *ListOptions) (data.Products, error) {
+	var products data.Products
+	if err := s.c.do(ctx, http.MethodGet, "/products", opts.query(), nil, &products); err != nil {
+		return nil, err
+	}
+
+	return products, nil
+}
+
+// Iter returns an iterator over all products, fetched in pages of
+// opts.Limit products (100 if not set) starting at opts.Offset.
+func (s *ProductsService) Iter(ctx context.Context, opts *ListOptions) *ProductIterator {
+	return &ProductIterator{ctx: ctx, s: s, opts: pageOptions(opts)}
+}
+
+// Get returns the product with the given id.
+func (s *ProductsService) Get(ctx context.Context, id uint64) (*data.Product, error) {
+	product := &data.Product{}
+	if err := s.c.do(ctx, http.MethodGet, fmt.Sprintf("/products/%d", id), nil, nil, product); err != nil {
+		return nil, err
+	}
+
+	return product, nil
+}
+
+// Create creates a product and returns it with its assigned ID.
+func (s *ProductsService) Create(ctx context.Context, p *data.Product) (*data.Product, error) {
+	product := &data.Product{}
+	if err := s.c.do(ctx, http.MethodPost, "/products", nil, p, product); err != nil {
+		return nil, err
+	}
+
+	return product, nil
+}
+
+// Update replaces all the attributes of product p.ID.
+func (s *ProductsService) Update(ctx context.Context, p *data.Product) (*data.Product, error) {
+	return s.update(ctx, http.MethodPut, p)
+}
+
+// Patch updates the non-zero attributes of p on product p.ID.
+func (s *ProductsService) Patch(ctx context.Context, p *data.Product) (*data.Product, error) {
+	return s.update(ctx, http.MethodPatch, p)
+}
+
+func (s *ProductsService) update(ctx context.Context, method string, p *data.Product) (*data.Product, error) {
+	product := &data.Product{}
+	if err := s.c.do(ctx, method, fmt.Sprintf("/products/%d", p.ID), nil, p, product); err != nil {
+		return nil, err
+	}
+
+	return product, nil
+}
+
+// Delete deletes the product with the given id and returns it.
+func (s *ProductsService) Delete(ctx context.Context, id uint64) (*data.Product, error) {
+	product := &data.Product{}
+	if err := s.c.do(ctx, http.MethodDelete, fmt.Sprintf("/products/%d", id), nil, nil, product); err != nil {
+		return nil, err
+	}
+
+	return product, nil
+}
+
+// Categories returns the number of products in each category.
+func (s *ProductsService) Categories(ctx context.Context) (data.Categories, error) {
+	categories := data.Categories{}
+	if err := s.c.do(ctx, http.MethodGet, "/products/categories", nil, nil, &categories); err != nil {
+		return nil, err
+	}
+
+	return categories, nil
+}
+
+// ListByCategory returns the products in category.
+func (s *ProductsService) ListByCategory(ctx context.Context, category string) (data.Products, error) {
+	var products data.Products
+	path := "/products/categories/" + url.PathEscape(category)
+	if err := s.c.do(ctx, http.MethodGet, path, nil, nil, &products); err != nil {
+		return nil, err
+	}
+
+	return products, nil
+}
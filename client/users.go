@@ -0,0 +1,73 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/imariom/products-api/data"
+)
+
+// UsersService is the client of the /users endpoints.
+type UsersService struct {
+	c *Client
+}
+
+// List returns all users.
+func (s *UsersService) List(ctx context.Context) (data.Users, error) {
+	var users data.Users
+	if err := s.c.do(ctx, http.MethodGet, "/users", nil, nil, &users); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+// Get returns the user with the given id.
+func (s *UsersService) Get(ctx context.Context, id uint64) (*data.User, error) {
+	user := &data.User{}
+	if err := s.c.do(ctx, http.MethodGet, fmt.Sprintf("/users/%d", id), nil, nil, user); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// Create creates a user and returns it with its assigned ID.
+func (s *UsersService) Create(ctx context.Context, u *data.User) (*data.User, error) {
+	user := &data.User{}
+	if err := s.c.do(ctx, http.MethodPost, "/users", nil, u, user); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// Update replaces all the attributes of user u.ID.
+func (s *UsersService) Update(ctx context.Context, u *data.User) (*data.User, error) {
+	return s.update(ctx, http.MethodPut, u)
+}
+
+// Patch updates the non-zero attributes of u on user u.ID.
+func (s *UsersService) Patch(ctx context.Context, u *data.User) (*data.User, error) {
+	return s.update(ctx, http.MethodPatch, u)
+}
+
+func (s *UsersService) update(ctx context.Context, method string, u *data.User) (*data.User, error) {
+	user := &data.User{}
+	if err := s.c.do(ctx, method, fmt.Sprintf("/users/%d", u.ID), nil, u, user); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// Delete deletes the user with the given id and returns it.
+func (s *UsersService) Delete(ctx context.Context, id uint64) (*data.User, error) {
+	user := &data.User{}
+	if err := s.c.do(ctx, http.MethodDelete, fmt.Sprintf("/users/%d", id), nil, nil, user); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
@@ -0,0 +1,109 @@
+// Command devcert generates a self-signed certificate and private key
+// for serving the API over HTTPS during local development.
+//
+//	go run ./cmd/devcert -out ./certs
+//	go run . -tls-cert ./certs/cert.pem -tls-key ./certs/key.pem
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"flag"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+func main() {
+	hosts := flag.String("hosts", "localhost,127.0.0.1,::1", "comma separated hostnames and IPs to include in the certificate")
+	outDir := flag.String("out", ".", "directory where cert.pem and key.pem are written")
+	validFor := flag.Duration("valid-for", 365*24*time.Hour, "duration the certificate is valid for")
+	flag.Parse()
+
+	logger := log.New(os.Stderr, "[DEVCERT] ", log.LstdFlags)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		logger.Fatalln("[ERROR] failed to generate private key:", err)
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		logger.Fatalln("[ERROR] failed to generate serial number:", err)
+	}
+
+	notBefore := time.Now()
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"Store API development"}},
+		NotBefore:             notBefore,
+		NotAfter:              notBefore.Add(*validFor),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	for _, h := range strings.Split(*hosts, ",") {
+		h = strings.TrimSpace(h)
+		if h == "" {
+			continue
+		}
+
+		if ip := net.ParseIP(h); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, h)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		logger.Fatalln("[ERROR] failed to create certificate:", err)
+	}
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		logger.Fatalln("[ERROR] failed to encode private key:", err)
+	}
+
+	if err := os.MkdirAll(*outDir, 0o755); err != nil {
+		logger.Fatalln("[ERROR]", err)
+	}
+
+	certPath := filepath.Join(*outDir, "cert.pem")
+	keyPath := filepath.Join(*outDir, "key.pem")
+
+	if err := writePEM(certPath, "CERTIFICATE", der, 0o644); err != nil {
+		logger.Fatalln("[ERROR]", err)
+	}
+
+	if err := writePEM(keyPath, "PRIVATE KEY", keyDER, 0o600); err != nil {
+		logger.Fatalln("[ERROR]", err)
+	}
+
+	logger.Println("[INFO] wrote", certPath, "and", keyPath)
+}
+
+// writePEM encodes a single PEM block into path.
+func writePEM(path, blockType string, b []byte, perm os.FileMode) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+
+	if err := pem.Encode(f, &pem.Block{Type: blockType, Bytes: b}); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
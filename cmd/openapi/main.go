@@ -0,0 +1,57 @@
+// Command openapi writes the OpenAPI document of the API generated from
+// the route table of the handlers package. With -check it compares the
+// generated document with a committed one and fails if they differ,
+// which is how CI catches a specification drifting from the handlers:
+//
+//	go run ./cmd/openapi -out api/openapi.json
+//	go run ./cmd/openapi -check api/openapi.json
+//
+// That the router of the server serves every route of the table is
+// checked by the tests of the main package.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/imariom/products-api/handlers"
+	"github.com/imariom/products-api/openapi"
+)
+
+func main() {
+	out := flag.String("out", "", "file to write the document to (stdout if empty)")
+	check := flag.String("check", "", "committed document to compare the generated one with")
+	flag.Parse()
+
+	doc, err := openapi.Build(handlers.APIInfo, handlers.Routes).JSON()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "failed to generate OpenAPI document:", err)
+		os.Exit(1)
+	}
+
+	if *check != "" {
+		committed, err := os.ReadFile(*check)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		if !bytes.Equal(committed, doc) {
+			fmt.Fprintf(os.Stderr, "%s is out of date, regenerate it with: go run ./cmd/openapi -out %s\n", *check, *check)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *out == "" {
+		os.Stdout.Write(doc)
+		return
+	}
+
+	if err := os.WriteFile(*out, doc, 0o644); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
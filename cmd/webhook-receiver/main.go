@@ -0,0 +1,78 @@
+// Command webhook-receiver is a local webhook receiver for testing the
+// webhook subscriptions of the API. It verifies the signature of every
+// delivery, logs it, and can fail deliveries to exercise the retries.
+//
+//	go run ./cmd/webhook-receiver -addr 127.0.0.1:9000 -secret s3cr3t -fail 2
+//	curl -X POST localhost:8080/admin/webhooks \
+//		-d '{"url":"http://127.0.0.1:9000/","events":["*"],"secret":"s3cr3t"}'
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/imariom/products-api/webhooks"
+)
+
+func main() {
+	addr := flag.String("addr", "127.0.0.1:9000", "address the receiver listens on")
+	secret := flag.String("secret", "", "secret of the subscription (signatures are not verified when empty)")
+	tolerance := flag.Duration("tolerance", 5*time.Minute, "maximum age of the signatures")
+	fail := flag.Int("fail", 0, "number of deliveries answered with -fail-status before accepting them")
+	failStatus := flag.Int("fail-status", http.StatusServiceUnavailable, "status of the failed deliveries")
+	flag.Parse()
+
+	logger := log.New(os.Stderr, "[RECEIVER] ", log.LstdFlags)
+
+	var (
+		mtx      sync.Mutex
+		received int
+	)
+
+	http.HandleFunc("/", func(rw http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+		if err != nil {
+			http.Error(rw, "failed to read body", http.StatusBadRequest)
+			return
+		}
+
+		if *secret != "" {
+			if err := webhooks.Verify(*secret, r.Header, body, *tolerance); err != nil {
+				logger.Println("[ERROR] rejected delivery:", err)
+				http.Error(rw, err.Error(), http.StatusUnauthorized)
+				return
+			}
+		}
+
+		mtx.Lock()
+		received++
+		n := received
+		mtx.Unlock()
+
+		var event struct {
+			ID   uint64 `json:"id"`
+			Type string `json:"type"`
+		}
+		json.Unmarshal(body, &event)
+
+		if n <= *fail {
+			logger.Printf("failing delivery %s (event %d %s, %d/%d)",
+				r.Header.Get(webhooks.HeaderID), event.ID, event.Type, n, *fail)
+			http.Error(rw, "failing on purpose", *failStatus)
+			return
+		}
+
+		logger.Printf("received delivery %s: event %d %s %s",
+			r.Header.Get(webhooks.HeaderID), event.ID, event.Type, body)
+		rw.WriteHeader(http.StatusNoContent)
+	})
+
+	logger.Println("listening on", *addr)
+	logger.Fatalln("[ERROR]", http.ListenAndServe(*addr, nil))
+}
@@ -0,0 +1,246 @@
+// Package codec encodes and decodes API bodies in the formats clients
+// negotiate: JSON, NDJSON, CSV, XML and MessagePack.
+//
+// Every format uses the JSON field names of the data types, so the
+// same document reads the same in any of them.
+package codec
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FormatParam is the query parameter overriding the Accept header,
+// e.g. /products?format=csv.
+const FormatParam = "format"
+
+var (
+	// ErrNotAcceptable is returned when no registered format satisfies
+	// the Accept header or the format parameter of a request.
+	ErrNotAcceptable = errors.New("none of the requested formats is supported")
+
+	// ErrUnsupportedMediaType is returned for request bodies in a
+	// format that is not registered.
+	ErrUnsupportedMediaType = errors.New("unsupported content type")
+)
+
+// Codec is an encoding format.
+type Codec struct {
+	// Name is the value of the format parameter selecting the codec.
+	Name string
+
+	// MediaTypes are the media types of the format, the first one is
+	// sent as the Content-Type of responses.
+	MediaTypes []string
+
+	Encode func(w io.Writer, v interface{}) error
+
+	// Decode decodes a body into v, a pointer to a struct or a slice.
+	Decode func(r io.Reader, v interface{}) error
+
+	// NewReader and NewWriter read and write lists one record at a
+	// time. They are nil for formats that cannot be streamed.
+	NewReader func(r io.Reader) RecordReader
+	NewWriter func(w io.Writer) RecordWriter
+}
+
+// ContentType returns the Content-Type of the bodies encoded by c.
+func (c *Codec) ContentType() string {
+	ct := c.MediaTypes[0]
+	if strings.HasPrefix(ct, "text/") {
+		ct += "; charset=utf-8"
+	}
+
+	return ct
+}
+
+// Registry holds the codecs a server supports. The first codec
+// registered is the default one.
+type Registry struct {
+	codecs []*Codec
+}
+
+// Default is the registry of the formats of the API.
+var Default = NewRegistry(JSON, NDJSON, CSV, XML, MessagePack)
+
+// NewRegistry returns a registry with the given codecs.
+func NewRegistry(codecs ...*Codec) *Registry {
+	return &Registry{codecs: codecs}
+}
+
+// Register adds c to the registry.
+func (reg *Registry) Register(c *Codec) {
+	reg.codecs = append(reg.codecs, c)
+}
+
+// MediaTypes returns the primary media type of every codec.
+func (reg *Registry) MediaTypes() []string {
+	types := make([]string, 0, len(reg.codecs))
+	for _, c := range reg.codecs {
+		types = append(types, c.MediaTypes[0])
+	}
+
+	return types
+}
+
+// Names returns the names of the codecs.
+func (reg *Registry) Names() []string {
+	names := make([]string, 0, len(reg.codecs))
+	for _, c := range reg.codecs {
+		names = append(names, c.Name)
+	}
+
+	return names
+}
+
+// ByName returns the codec with the given name.
+func (reg *Registry) ByName(name string) (*Codec, bool) {
+	for _, c := range reg.codecs {
+		if strings.EqualFold(c.Name, name) {
+			return c, true
+		}
+	}
+
+	return nil, false
+}
+
+// ByMediaType returns the codec of a media type, parameters such as
+// the charset are ignored.
+func (reg *Registry) ByMediaType(mediaType string) (*Codec, bool) {
+	if mt, _, err := mime.ParseMediaType(mediaType); err == nil {
+		mediaType = mt
+	}
+
+	for _, c := range reg.codecs {
+		for _, mt := range c.MediaTypes {
+			if strings.EqualFold(mt, mediaType) {
+				return c, true
+			}
+		}
+	}
+
+	return nil, false
+}
+
+// Negotiate returns the codec of the response to r: the one named by
+// the format parameter if present, else the best match of the Accept
+// header, else the default codec.
+func (reg *Registry) Negotiate(r *http.Request) (*Codec, error) {
+	if name := r.URL.Query().Get(FormatParam); name != "" {
+		if c, ok := reg.ByName(name); ok {
+			return c, nil
+		}
+		return nil, fmt.Errorf("%w: format '%s'", ErrNotAcceptable, name)
+	}
+
+	accept := r.Header.Values("Accept")
+	if len(accept) == 0 {
+		return reg.codecs[0], nil
+	}
+
+	for _, rng := range parseAccept(strings.Join(accept, ",")) {
+		for _, c := range reg.codecs {
+			if rng.matches(c) {
+				return c, nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrNotAcceptable, strings.Join(accept, ", "))
+}
+
+// ForRequest returns the codec of the body of r by its Content-Type,
+// JSON when not set.
+func (reg *Registry) ForRequest(r *http.Request) (*Codec, error) {
+	ct := r.Header.Get("Content-Type")
+	if ct == "" {
+		return reg.codecs[0], nil
+	}
+
+	c, ok := reg.ByMediaType(ct)
+	if !ok {
+		return nil, fmt.Errorf("%w '%s'", ErrUnsupportedMediaType, ct)
+	}
+
+	return c, nil
+}
+
+// mediaRange is an entry of an Accept header.
+type mediaRange struct {
+	typ, subtype string
+	q            float64
+}
+
+func (m mediaRange) matches(c *Codec) bool {
+	for _, mt := range c.MediaTypes {
+		typ, subtype := mt, ""
+		if i := strings.Index(mt, "/"); i >= 0 {
+			typ, subtype = mt[:i], mt[i+1:]
+		}
+
+		if (m.typ == "*" || strings.EqualFold(m.typ, typ)) &&
+			(m.subtype == "*" || strings.EqualFold(m.subtype, subtype)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseAccept returns the media ranges of an Accept header by
+// decreasing preference, ranges with q=0 excluded.
+func parseAccept(accept string) []mediaRange {
+	ranges := []mediaRange{}
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		mt := strings.TrimSpace(params[0])
+		if mt == "" {
+			continue
+		}
+
+		rng := mediaRange{typ: mt, subtype: "*", q: 1}
+		if i := strings.Index(mt, "/"); i >= 0 {
+			rng.typ, rng.subtype = mt[:i], mt[i+1:]
+		}
+
+		for _, p := range params[1:] {
+			p = strings.TrimSpace(p)
+			if strings.HasPrefix(p, "q=") {
+				if q, err := strconv.ParseFloat(p[2:], 64); err == nil {
+					rng.q = q
+				}
+			}
+		}
+
+		if rng.q > 0 {
+			ranges = append(ranges, rng)
+		}
+	}
+
+	// more specific ranges win ties, e.g. text/csv over */*
+	sort.SliceStable(ranges, func(i, j int) bool {
+		if ranges[i].q != ranges[j].q {
+			return ranges[i].q > ranges[j].q
+		}
+		return specificity(ranges[i]) > specificity(ranges[j])
+	})
+
+	return ranges
+}
+
+func specificity(m mediaRange) int {
+	switch {
+	case m.typ == "*":
+		return 0
+	case m.subtype == "*":
+		return 1
+	}
+
+	return 2
+}
@@ -0,0 +1,134 @@
+package codec
+
+import (
+	"bytes"
+	"errors"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/imariom/products-api/data"
+)
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		url    string
+		accept []string
+		want   string
+		err    error
+	}{
+		{"/", nil, "json", nil},
+		{"/", []string{"*/*"}, "json", nil},
+		{"/", []string{"text/csv"}, "csv", nil},
+		{"/", []string{"text/*"}, "csv", nil},
+		{"/", []string{"application/vnd.msgpack"}, "msgpack", nil},
+		{"/", []string{"application/xml;q=0.5, text/csv"}, "csv", nil},
+		{"/", []string{"*/*;q=0.8, application/xml;q=0.8"}, "xml", nil},
+		{"/", []string{"application/json;q=0, */*"}, "json", nil},
+		{"/", []string{"text/html", "application/x-ndjson"}, "ndjson", nil},
+		{"/", []string{"TEXT/XML"}, "xml", nil},
+		{"/", []string{"text/html"}, "", ErrNotAcceptable},
+		{"/", []string{"text/csv;q=0"}, "", ErrNotAcceptable},
+		{"/?format=XML", []string{"text/csv"}, "xml", nil},
+		{"/?format=yaml", nil, "", ErrNotAcceptable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url+" "+tt.want, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tt.url, nil)
+			for _, a := range tt.accept {
+				r.Header.Add("Accept", a)
+			}
+
+			c, err := Default.Negotiate(r)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+			if err == nil && c.Name != tt.want {
+				t.Errorf("negotiated %s, want %s", c.Name, tt.want)
+			}
+		})
+	}
+}
+
+func TestForRequest(t *testing.T) {
+	tests := []struct {
+		contentType string
+		want        string
+		err         error
+	}{
+		{"", "json", nil},
+		{"text/csv; charset=utf-8", "csv", nil},
+		{"application/x-msgpack", "msgpack", nil},
+		{"text/xml", "xml", nil},
+		{"text/plain", "", ErrUnsupportedMediaType},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.contentType, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/", nil)
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+
+			c, err := Default.ForRequest(r)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+			if err == nil && c.Name != tt.want {
+				t.Errorf("got %s, want %s", c.Name, tt.want)
+			}
+		})
+	}
+}
+
+// TestRoundTrip checks that the documents of the API are decoded by
+// every codec as they were encoded.
+func TestRoundTrip(t *testing.T) {
+	date := time.Date(2020, 3, 2, 10, 30, 0, 0, time.UTC)
+
+	docs := []interface{}{
+		&data.Products{
+			{ID: 1, SKU: "S-1", Name: `Bag, "large" & <red>`, Description: "two\nlines", Category: "bags", Price: 109.95, MaxQuantity: 5},
+			{ID: 2, Name: "Shoe", Price: 0.1},
+		},
+		&data.Carts{
+			{ID: 1, UserID: 2, Date: date, UpdatedAt: date, Products: []data.Item{{ProductID: 1, Quantity: 4}, {ProductID: 2, Quantity: 1, Unavailable: true}}},
+			{ID: 3, Date: date, UpdatedAt: date, Guest: true, Abandoned: true, Products: []data.Item{}},
+		},
+		&data.Users{
+			{ID: 1, Username: "johnd", Password: "m38rmF$", Name: "John Doe", Phone: "1-570-236-7033", Address: &data.Address{City: "kilcoole", Street: "new road", Number: 7682, ZipCode: "12926-3874"}},
+			// CSV cannot tell a missing address from an empty one
+			{ID: 2, Username: "anon", Address: &data.Address{}},
+		},
+		&data.Product{ID: 1, Name: "Bag", Price: 1e-7},
+		&data.Categories{"bags": 2, "shoes": 1},
+	}
+
+	for _, c := range []*Codec{JSON, NDJSON, CSV, XML, MessagePack} {
+		for _, doc := range docs {
+			typ := reflect.TypeOf(doc).Elem()
+
+			t.Run(c.Name+"/"+typ.Name(), func(t *testing.T) {
+				// NDJSON only encodes lists, and CSV does not decode maps
+				if c == NDJSON && typ.Kind() != reflect.Slice || c == CSV && typ.Kind() == reflect.Map {
+					t.Skip()
+				}
+
+				var buf bytes.Buffer
+				if err := c.Encode(&buf, doc); err != nil {
+					t.Fatal(err)
+				}
+
+				got := reflect.New(typ).Interface()
+				if err := c.Decode(&buf, got); err != nil {
+					t.Fatalf("failed to decode %s: %v", buf.String(), err)
+				}
+				if !reflect.DeepEqual(got, doc) {
+					t.Errorf("got %+v, want %+v", got, doc)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,387 @@
+package codec
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// CSV encodes lists as a header row followed by a row per record.
+// Nested objects are flattened into columns named after their path
+// (e.g. "address.city"), and lists of records, such as the items of a
+// cart, are expanded into a row per element repeating the columns of
+// the parent (e.g. "products.product_id", "products.quantity"). Lists
+// of plain values are joined with ";". Maps, such as the category
+// counts, are encoded as key and value columns.
+var CSV = &Codec{
+	Name:       "csv",
+	MediaTypes: []string{"text/csv"},
+	Encode:     encodeCSV,
+	Decode:     decodeCSV,
+	NewReader:  newCSVReader,
+	NewWriter:  newCSVWriter,
+}
+
+// csvListSep separates the values of lists of plain values.
+const csvListSep = ";"
+
+// csvRow is a flattened record.
+type csvRow map[string]string
+
+func (r csvRow) clone() csvRow {
+	c := make(csvRow, len(r))
+	for k, v := range r {
+		c[k] = v
+	}
+
+	return c
+}
+
+// csvTable accumulates rows and the columns in the order first seen.
+type csvTable struct {
+	columns []string
+	seen    map[string]bool
+}
+
+func (t *csvTable) column(name string) {
+	if !t.seen[name] {
+		t.seen[name] = true
+		t.columns = append(t.columns, name)
+	}
+}
+
+// flatten adds the columns of v under column to rows, returning the
+// rows expanded by the lists of records in v.
+func (t *csvTable) flatten(rows []csvRow, column string, v interface{}) []csvRow {
+	switch v := v.(type) {
+	case *object:
+		for _, k := range v.keys {
+			rows = t.flatten(rows, joinColumn(column, k), v.values[k])
+		}
+		return rows
+
+	case []interface{}:
+		// empty lists add no columns, they may be lists of records
+		if len(v) == 0 {
+			return rows
+		}
+
+		if _, ok := v[0].(*object); ok {
+			// a row per element of a list of records
+			expanded := []csvRow{}
+			for _, r := range rows {
+				for _, e := range v {
+					expanded = append(expanded, t.flatten([]csvRow{r.clone()}, column, e)...)
+				}
+			}
+			return expanded
+		}
+
+		vals := make([]string, 0, len(v))
+		for _, e := range v {
+			vals = append(vals, scalar(e))
+		}
+		return t.set(rows, column, strings.Join(vals, csvListSep))
+	}
+
+	return t.set(rows, column, scalar(v))
+}
+
+func joinColumn(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+
+	return prefix + "." + name
+}
+
+func (t *csvTable) set(rows []csvRow, column, value string) []csvRow {
+	t.column(column)
+	for _, r := range rows {
+		r[column] = value
+	}
+
+	return rows
+}
+
+func encodeCSV(w io.Writer, v interface{}) error {
+	cw := csv.NewWriter(w)
+	rv := reflect.Indirect(reflect.ValueOf(v))
+
+	if rv.Kind() == reflect.Map {
+		keys := make([]string, 0, rv.Len())
+		values := make(map[string]string, rv.Len())
+		for _, k := range rv.MapKeys() {
+			key := fmt.Sprint(k.Interface())
+			keys = append(keys, key)
+			values[key] = fmt.Sprint(rv.MapIndex(k).Interface())
+		}
+		sort.Strings(keys)
+
+		cw.Write([]string{"key", "value"})
+		for _, k := range keys {
+			cw.Write([]string{k, values[k]})
+		}
+
+		cw.Flush()
+		return cw.Error()
+	}
+
+	tree, err := toTree(v)
+	if err != nil {
+		return err
+	}
+
+	records, ok := tree.([]interface{})
+	if !ok {
+		records = []interface{}{tree}
+	}
+
+	table := &csvTable{seen: make(map[string]bool)}
+	rows := []csvRow{}
+	for _, rec := range records {
+		rows = append(rows, table.flatten([]csvRow{{}}, "", rec)...)
+	}
+
+	if err := cw.Write(table.columns); err != nil {
+		return err
+	}
+
+	line := make([]string, len(table.columns))
+	for _, r := range rows {
+		for i, c := range table.columns {
+			line[i] = r[c]
+		}
+		if err := cw.Write(line); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// csvList is a list of records under construction in a row, e.g. the
+// items of a cart.
+type csvList struct {
+	parent *object
+	name   string
+	elem   *object
+}
+
+// decodeCSV decodes the rows of a CSV body into v, a pointer to a
+// slice of structs or to a struct. Consecutive rows repeating the same
+// values outside of the lists of records are merged into one record,
+// which is the inverse of encodeCSV.
+func decodeCSV(r io.Reader, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr {
+		return fmt.Errorf("csv: cannot decode into %T", v)
+	}
+
+	recType := rv.Type().Elem()
+	isList := recType.Kind() == reflect.Slice
+	if isList {
+		recType = recType.Elem()
+	}
+	recType = deref(recType)
+
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+
+	header, err := cr.Read()
+	if err != nil {
+		return fmt.Errorf("csv: failed to read header: %w", err)
+	}
+
+	// the lists of records each column belongs to, "" for none
+	listOf, err := csvHeader(recType, header)
+	if err != nil {
+		return err
+	}
+
+	records := []interface{}{}
+	var prev *csvRecord
+
+	for line := 2; ; line++ {
+		cells, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("csv: %w", err)
+		}
+
+		rec, err := newCSVRecord(recType, header, listOf, cells)
+		if err != nil {
+			return fmt.Errorf("csv: line %d: %w", line, err)
+		}
+
+		// rows repeating the previous record only add list elements
+		if isList && prev != nil && rec.key == prev.key {
+			prev.merge(rec)
+			continue
+		}
+
+		records = append(records, rec.obj)
+		prev = rec
+	}
+
+	if isList {
+		return fromTree(records, v)
+	}
+
+	if len(records) == 0 {
+		return fmt.Errorf("csv: no records")
+	}
+
+	return fromTree(records[0], v)
+}
+
+// csvRecord is a record decoded from a row.
+type csvRecord struct {
+	obj   *object
+	lists map[string]*csvList
+
+	// key identifies the values outside of the lists of records
+	key string
+}
+
+// newCSVRecord decodes the cells of a row into a record of type t.
+func newCSVRecord(t reflect.Type, header, listOf, cells []string) (*csvRecord, error) {
+	rec := &csvRecord{obj: newObject(), lists: make(map[string]*csvList)}
+	key := make([]string, 0, len(cells))
+
+	for i, col := range header {
+		cell := ""
+		if i < len(cells) {
+			cell = cells[i]
+		}
+
+		if listOf[i] == "" {
+			key = append(key, cell)
+		}
+
+		if err := setCSVCell(rec.obj, t, col, cell, rec.lists); err != nil {
+			return nil, fmt.Errorf("column '%s': %w", col, err)
+		}
+	}
+
+	for _, l := range rec.lists {
+		arr := []interface{}{}
+		if len(l.elem.keys) > 0 {
+			arr = append(arr, l.elem)
+		}
+		l.parent.set(l.name, arr)
+	}
+
+	rec.key = strings.Join(key, "\x00")
+	return rec, nil
+}
+
+// merge appends the list elements of next to the lists of r.
+func (r *csvRecord) merge(next *csvRecord) {
+	for path, l := range next.lists {
+		prev, ok := r.lists[path]
+		if !ok || len(l.elem.keys) == 0 {
+			continue
+		}
+
+		arr, _ := prev.parent.get(prev.name)
+		prev.parent.set(prev.name, append(arr.([]interface{}), l.elem))
+	}
+}
+
+// csvHeader checks the columns of a header against type t, returning
+// the paths of the lists of records of the columns.
+func csvHeader(t reflect.Type, header []string) ([]string, error) {
+	listOf := make([]string, len(header))
+	for i, col := range header {
+		header[i] = strings.TrimSpace(col)
+
+		var err error
+		if listOf[i], err = csvListPath(t, header[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	return listOf, nil
+}
+
+// csvListPath returns the path of the list of records a column belongs
+// to, "" if none. The fields of the elements of the list are checked too.
+func csvListPath(t reflect.Type, column string) (string, error) {
+	list := ""
+	segs := strings.Split(column, ".")
+	for i, seg := range segs {
+		ft, ok := fieldType(t, seg)
+		if !ok {
+			return "", fmt.Errorf("csv: unknown column '%s'", column)
+		}
+		t = ft
+
+		if i < len(segs)-1 && isRecordList(ft) {
+			if list == "" {
+				list = strings.Join(segs[:i+1], ".")
+			}
+			t = deref(ft).Elem()
+		}
+	}
+
+	return list, nil
+}
+
+func setCSVCell(rec *object, t reflect.Type, column, cell string, lists map[string]*csvList) error {
+	segs := strings.Split(column, ".")
+	cur := rec
+
+	for i, seg := range segs {
+		ft, _ := fieldType(t, seg)
+
+		if i == len(segs)-1 {
+			if deref(ft).Kind() == reflect.Slice && deref(ft) != timeType {
+				vals := []interface{}{}
+				if strings.TrimSpace(cell) != "" {
+					for _, s := range strings.Split(cell, csvListSep) {
+						v, err := typed(deref(ft).Elem(), s)
+						if err != nil {
+							return err
+						}
+						vals = append(vals, v)
+					}
+				}
+				cur.set(seg, vals)
+				return nil
+			}
+
+			v, err := typed(ft, cell)
+			if err != nil {
+				return err
+			}
+			if v != nil {
+				cur.set(seg, v)
+			}
+			return nil
+		}
+
+		if isRecordList(ft) {
+			path := strings.Join(segs[:i+1], ".")
+			l, ok := lists[path]
+			if !ok {
+				l = &csvList{parent: cur, name: seg, elem: newObject()}
+				lists[path] = l
+			}
+			cur = l.elem
+			t = deref(ft).Elem()
+			continue
+		}
+
+		cur = cur.child(seg)
+		t = ft
+	}
+
+	return nil
+}
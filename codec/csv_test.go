@@ -0,0 +1,216 @@
+package codec
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/imariom/products-api/data"
+)
+
+// tagged has a list of plain values.
+type tagged struct {
+	Name string   `json:"name"`
+	Tags []string `json:"tags"`
+}
+
+func TestCSVEncode(t *testing.T) {
+	date := time.Date(2020, 3, 2, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		v    interface{}
+		want string
+	}{
+		{
+			name: "list",
+			v:    data.Products{{ID: 1, Name: `Bag, "large"`, Category: "bags", Price: 9.5}, {ID: 2, MaxQuantity: 3}},
+			want: "id,sku,name,description,category,image,price,max_quantity\n" +
+				"1,,\"Bag, \"\"large\"\"\",,bags,,9.5,\n" +
+				"2,,,,,,0,3\n",
+		},
+		{
+			name: "single record",
+			v:    &data.Product{ID: 1, Name: "Bag"},
+			want: "id,sku,name,description,category,image,price\n1,,Bag,,,,0\n",
+		},
+		{
+			name: "row per element of lists of records",
+			v: data.Carts{
+				{ID: 1, UserID: 2, Date: date, UpdatedAt: date, Products: []data.Item{{ProductID: 3, Quantity: 1}, {ProductID: 4, Quantity: 2}}},
+				{ID: 5, Date: date, UpdatedAt: date, Products: []data.Item{}},
+			},
+			want: "id,userId,date,products.product_id,products.quantity,updatedAt\n" +
+				"1,2,2020-03-02T00:00:00Z,3,1,2020-03-02T00:00:00Z\n" +
+				"1,2,2020-03-02T00:00:00Z,4,2,2020-03-02T00:00:00Z\n" +
+				"5,0,2020-03-02T00:00:00Z,,,2020-03-02T00:00:00Z\n",
+		},
+		{
+			name: "embedded struct",
+			v:    data.Users{{ID: 1, Username: "johnd", Address: &data.Address{City: "kilcoole", Number: 7}}},
+			want: "id,username,password,name,phone,city,street,number,zip_code\n1,johnd,,,,kilcoole,,7,\n",
+		},
+		{
+			name: "lists of plain values",
+			v:    []tagged{{Name: "a", Tags: []string{"x", "y"}}, {Name: "b"}},
+			want: "name,tags\na,x;y\nb,\n",
+		},
+		{
+			name: "map",
+			v:    data.Categories{"shoes": 1, "bags": 2},
+			want: "key,value\nbags,2\nshoes,1\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := CSV.Encode(&buf, tt.v); err != nil {
+				t.Fatal(err)
+			}
+			if buf.String() != tt.want {
+				t.Errorf("got\n%s\nwant\n%s", buf.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestCSVDecode(t *testing.T) {
+	date := time.Date(2020, 3, 2, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		csv  string
+		v    interface{}
+		want interface{}
+	}{
+		{
+			name: "columns in any order",
+			csv:  "price, name ,id\n9.5,\"Bag, \"\"large\"\"\",1\n,,2\n",
+			v:    &data.Products{},
+			want: &data.Products{{ID: 1, Name: `Bag, "large"`, Price: 9.5}, {ID: 2}},
+		},
+		{
+			name: "rows of the same record are merged",
+			csv: "id,date,products.product_id,products.quantity\n" +
+				"1,2020-03-02T00:00:00Z,3,1\n" +
+				"1,2020-03-02T00:00:00Z,4,2\n" +
+				"5,2020-03-02T00:00:00Z,,\n" +
+				"1,2020-03-02T00:00:00Z,6,1\n",
+			v: &data.Carts{},
+			want: &data.Carts{
+				{ID: 1, Date: date, Products: []data.Item{{ProductID: 3, Quantity: 1}, {ProductID: 4, Quantity: 2}}},
+				{ID: 5, Date: date, Products: []data.Item{}},
+				{ID: 1, Date: date, Products: []data.Item{{ProductID: 6, Quantity: 1}}},
+			},
+		},
+		{
+			name: "single record",
+			csv:  "id,name\n1,Bag\n2,Shoe\n",
+			v:    &data.Product{},
+			want: &data.Product{ID: 1, Name: "Bag"},
+		},
+		{
+			name: "embedded struct",
+			csv:  "id,username,city,number\n1,johnd,kilcoole,7\n",
+			v:    &data.Users{},
+			want: &data.Users{{ID: 1, Username: "johnd", Address: &data.Address{City: "kilcoole", Number: 7}}},
+		},
+		{
+			name: "lists of plain values",
+			csv:  "name,tags\na,x;y\nb,\n",
+			v:    &[]tagged{},
+			want: &[]tagged{{Name: "a", Tags: []string{"x", "y"}}, {Name: "b", Tags: []string{}}},
+		},
+		{
+			name: "short rows",
+			csv:  "id,name\n1\n",
+			v:    &data.Products{},
+			want: &data.Products{{ID: 1}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := CSV.Decode(strings.NewReader(tt.csv), tt.v); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(tt.v, tt.want) {
+				t.Errorf("got %+v, want %+v", tt.v, tt.want)
+			}
+		})
+	}
+}
+
+func TestCSVDecodeErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		csv  string
+		v    interface{}
+		want string
+	}{
+		{"no header", "", &data.Products{}, "csv: failed to read header: EOF"},
+		{"unknown column", "id,title\n", &data.Products{}, "csv: unknown column 'title'"},
+		{"unknown nested column", "id,products.price\n", &data.Carts{}, "csv: unknown column 'products.price'"},
+		{"unknown column of a record", "id,products.quantity.value\n", &data.Carts{}, "csv: unknown column 'products.quantity.value'"},
+		{"invalid number", "id,price\n1,2\n2,cheap\n", &data.Products{}, "csv: line 3: column 'price': invalid number 'cheap'"},
+		{"invalid boolean", "id,products.unavailable\n1,maybe\n", &data.Carts{}, "csv: line 2: column 'products.unavailable': invalid boolean 'maybe'"},
+		{"no records", "id,name\n", &data.Product{}, "csv: no records"},
+		{"malformed", "id,name\n1,\"Bag\n", &data.Products{}, `csv: parse error on line 2, column 8: extraneous or missing " in quoted-field`},
+		{"not a pointer", "id\n", data.Products{}, "csv: cannot decode into data.Products"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CSV.Decode(strings.NewReader(tt.csv), tt.v)
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("got error %v, want %s", err, tt.want)
+			}
+		})
+	}
+}
+
+// TestCSVStream checks that the records are streamed a row each, with
+// the lines of the invalid ones.
+func TestCSVStream(t *testing.T) {
+	var buf bytes.Buffer
+	w := CSV.NewWriter(&buf)
+	for _, p := range []*data.Product{{ID: 1, Name: "Bag"}, {ID: 2, Name: "Shoe", MaxQuantity: 3}} {
+		if err := w.Write(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// columns missing from the first record are not written
+	want := "id,sku,name,description,category,image,price\n1,,Bag,,,,0\n2,,Shoe,,,,0\n"
+	if buf.String() != want {
+		t.Fatalf("got\n%s\nwant\n%s", buf.String(), want)
+	}
+
+	r := CSV.NewReader(strings.NewReader(want + "x,,Hat,,,,0\n"))
+	for _, id := range []uint64{1, 2} {
+		var p data.Product
+		if err := r.Read(&p); err != nil {
+			t.Fatal(err)
+		}
+		if p.ID != id || r.Line() != int(id)+1 {
+			t.Errorf("read product %d on line %d, want product %d on line %d", p.ID, r.Line(), id, id+1)
+		}
+	}
+
+	var p data.Product
+	var rerr *RecordError
+	if err := r.Read(&p); !errors.As(err, &rerr) || rerr.Line != 4 {
+		t.Errorf("got error %v, want an error on line 4", err)
+	}
+	if err := r.Read(&p); err != io.EOF {
+		t.Errorf("got %v after the last record, want EOF", err)
+	}
+}
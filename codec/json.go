@@ -0,0 +1,78 @@
+package codec
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"reflect"
+)
+
+// JSON is the default format of the API.
+var JSON = &Codec{
+	Name:       "json",
+	MediaTypes: []string{"application/json"},
+	Encode: func(w io.Writer, v interface{}) error {
+		return json.NewEncoder(w).Encode(v)
+	},
+	Decode: func(r io.Reader, v interface{}) error {
+		return json.NewDecoder(r).Decode(v)
+	},
+	NewWriter: newJSONWriter,
+}
+
+// ndjsonFlushEvery is the number of lines after which NDJSON responses
+// are flushed to the client.
+const ndjsonFlushEvery = 100
+
+// NDJSON encodes lists as one JSON document per line, so clients can
+// process them as they are received.
+var NDJSON = &Codec{
+	Name:       "ndjson",
+	MediaTypes: []string{"application/x-ndjson", "application/ndjson"},
+	Encode:     encodeNDJSON,
+	Decode:     decodeNDJSON,
+	NewReader:  newNDJSONReader,
+	NewWriter:  newNDJSONWriter,
+}
+
+func encodeNDJSON(w io.Writer, v interface{}) error {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	enc := json.NewEncoder(w)
+
+	if rv.Kind() != reflect.Slice {
+		return enc.Encode(v)
+	}
+
+	flusher, _ := w.(http.Flusher)
+	for i := 0; i < rv.Len(); i++ {
+		if err := enc.Encode(rv.Index(i).Interface()); err != nil {
+			return err
+		}
+
+		if flusher != nil && (i+1)%ndjsonFlushEvery == 0 {
+			flusher.Flush()
+		}
+	}
+
+	return nil
+}
+
+func decodeNDJSON(r io.Reader, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	dec := json.NewDecoder(r)
+
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Slice {
+		return dec.Decode(v)
+	}
+
+	s := rv.Elem()
+	for dec.More() {
+		e := reflect.New(s.Type().Elem())
+		if err := dec.Decode(e.Interface()); err != nil {
+			return err
+		}
+		s.Set(reflect.Append(s, e.Elem()))
+	}
+
+	return nil
+}
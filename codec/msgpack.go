@@ -0,0 +1,286 @@
+package codec
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+)
+
+// MessagePack encodes documents as MessagePack maps and arrays with the
+// JSON field names as keys. Dates are strings in RFC 3339 format, as
+// in JSON.
+var MessagePack = &Codec{
+	Name:       "msgpack",
+	MediaTypes: []string{"application/msgpack", "application/x-msgpack", "application/vnd.msgpack"},
+	Encode:     encodeMsgpack,
+	Decode:     decodeMsgpack,
+}
+
+// maxMsgpackLen limits the length of the strings, arrays and maps of
+// decoded documents.
+const maxMsgpackLen = 1 << 24
+
+func encodeMsgpack(w io.Writer, v interface{}) error {
+	tree, err := toTree(v)
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	if err := writeMsgpack(bw, tree); err != nil {
+		return err
+	}
+
+	return bw.Flush()
+}
+
+func writeMsgpack(w *bufio.Writer, v interface{}) error {
+	switch v := v.(type) {
+	case nil:
+		w.WriteByte(0xc0)
+
+	case bool:
+		if v {
+			w.WriteByte(0xc3)
+		} else {
+			w.WriteByte(0xc2)
+		}
+
+	case json.Number:
+		writeMsgpackNumber(w, v)
+
+	case string:
+		writeMsgpackLen(w, len(v), 0xa0, 31, 0xd9, 0xda, 0xdb)
+		w.WriteString(v)
+
+	case []interface{}:
+		writeMsgpackLen(w, len(v), 0x90, 15, 0, 0xdc, 0xdd)
+		for _, e := range v {
+			if err := writeMsgpack(w, e); err != nil {
+				return err
+			}
+		}
+
+	case *object:
+		writeMsgpackLen(w, len(v.keys), 0x80, 15, 0, 0xde, 0xdf)
+		for _, k := range v.keys {
+			writeMsgpack(w, k)
+			if err := writeMsgpack(w, v.values[k]); err != nil {
+				return err
+			}
+		}
+
+	default:
+		return fmt.Errorf("msgpack: unsupported value %T", v)
+	}
+
+	return nil
+}
+
+// writeMsgpackLen writes the header of a string, array or map: the fix
+// format if n <= fixMax, else the 8 (if any), 16 or 32 bits format.
+func writeMsgpackLen(w *bufio.Writer, n int, fix byte, fixMax int, c8, c16, c32 byte) {
+	var b [5]byte
+	switch {
+	case n <= fixMax:
+		w.WriteByte(fix | byte(n))
+	case c8 != 0 && n <= math.MaxUint8:
+		w.Write([]byte{c8, byte(n)})
+	case n <= math.MaxUint16:
+		b[0] = c16
+		binary.BigEndian.PutUint16(b[1:], uint16(n))
+		w.Write(b[:3])
+	default:
+		b[0] = c32
+		binary.BigEndian.PutUint32(b[1:], uint32(n))
+		w.Write(b[:5])
+	}
+}
+
+func writeMsgpackNumber(w *bufio.Writer, n json.Number) {
+	var b [9]byte
+
+	if u, err := strconv.ParseUint(string(n), 10, 64); err == nil {
+		if u <= 0x7f {
+			w.WriteByte(byte(u))
+			return
+		}
+		b[0] = 0xcf
+		binary.BigEndian.PutUint64(b[1:], u)
+		w.Write(b[:])
+		return
+	}
+
+	if i, err := strconv.ParseInt(string(n), 10, 64); err == nil {
+		if i >= -32 {
+			w.WriteByte(byte(int8(i)))
+			return
+		}
+		b[0] = 0xd3
+		binary.BigEndian.PutUint64(b[1:], uint64(i))
+		w.Write(b[:])
+		return
+	}
+
+	f, _ := n.Float64()
+	b[0] = 0xcb
+	binary.BigEndian.PutUint64(b[1:], math.Float64bits(f))
+	w.Write(b[:])
+}
+
+func decodeMsgpack(r io.Reader, v interface{}) error {
+	tree, err := readMsgpack(bufio.NewReader(r))
+	if err != nil {
+		return fmt.Errorf("msgpack: %w", err)
+	}
+
+	return fromTree(tree, v)
+}
+
+func readMsgpack(r *bufio.Reader) (interface{}, error) {
+	c, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case c <= 0x7f:
+		return json.Number(strconv.Itoa(int(c))), nil
+	case c >= 0xe0:
+		return json.Number(strconv.Itoa(int(int8(c)))), nil
+	case c&0xe0 == 0xa0:
+		return readMsgpackString(r, int(c&0x1f))
+	case c&0xf0 == 0x90:
+		return readMsgpackArray(r, int(c&0x0f))
+	case c&0xf0 == 0x80:
+		return readMsgpackMap(r, int(c&0x0f))
+	}
+
+	switch c {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		u, err := readUint(r, 1<<(c-0xcc))
+		return json.Number(strconv.FormatUint(u, 10)), err
+
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (c - 0xd0)
+		u, err := readUint(r, size)
+		shift := uint(64 - 8*size)
+		return json.Number(strconv.FormatInt(int64(u<<shift)>>shift, 10)), err
+
+	case 0xca:
+		u, err := readUint(r, 4)
+		return floatNumber(float64(math.Float32frombits(uint32(u)))), err
+
+	case 0xcb:
+		u, err := readUint(r, 8)
+		return floatNumber(math.Float64frombits(u)), err
+
+	case 0xd9, 0xda, 0xdb, 0xc4, 0xc5, 0xc6:
+		// strings and binaries are both decoded as strings
+		size := 1 << ((c - 0xd9) % 3)
+		if c >= 0xc4 && c <= 0xc6 {
+			size = 1 << (c - 0xc4)
+		}
+		n, err := readUint(r, size)
+		if err != nil {
+			return nil, err
+		}
+		return readMsgpackString(r, int(n))
+
+	case 0xdc, 0xdd:
+		n, err := readUint(r, 2<<(c-0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return readMsgpackArray(r, int(n))
+
+	case 0xde, 0xdf:
+		n, err := readUint(r, 2<<(c-0xde))
+		if err != nil {
+			return nil, err
+		}
+		return readMsgpackMap(r, int(n))
+	}
+
+	return nil, fmt.Errorf("unsupported type 0x%02x", c)
+}
+
+func floatNumber(f float64) interface{} {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return nil
+	}
+
+	return json.Number(strconv.FormatFloat(f, 'g', -1, 64))
+}
+
+func readUint(r *bufio.Reader, size int) (uint64, error) {
+	var b [8]byte
+	if _, err := io.ReadFull(r, b[8-size:]); err != nil {
+		return 0, err
+	}
+
+	return binary.BigEndian.Uint64(b[:]), nil
+}
+
+func readMsgpackString(r *bufio.Reader, n int) (interface{}, error) {
+	if n > maxMsgpackLen {
+		return nil, fmt.Errorf("string too long")
+	}
+
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+
+	return string(b), nil
+}
+
+func readMsgpackArray(r *bufio.Reader, n int) (interface{}, error) {
+	if n > maxMsgpackLen {
+		return nil, fmt.Errorf("array too long")
+	}
+
+	arr := make([]interface{}, 0, n)
+	for i := 0; i < n; i++ {
+		v, err := readMsgpack(r)
+		if err != nil {
+			return nil, err
+		}
+		arr = append(arr, v)
+	}
+
+	return arr, nil
+}
+
+func readMsgpackMap(r *bufio.Reader, n int) (interface{}, error) {
+	if n > maxMsgpackLen {
+		return nil, fmt.Errorf("map too large")
+	}
+
+	obj := newObject()
+	for i := 0; i < n; i++ {
+		k, err := readMsgpack(r)
+		if err != nil {
+			return nil, err
+		}
+
+		v, err := readMsgpack(r)
+		if err != nil {
+			return nil, err
+		}
+		obj.set(scalar(k), v)
+	}
+
+	return obj, nil
+}
@@ -0,0 +1,145 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// hexBytes decodes s, ignoring spaces.
+func hexBytes(s string) []byte {
+	b, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func TestMsgpackEncode(t *testing.T) {
+	str32 := strings.Repeat("s", 32)
+	str256 := strings.Repeat("s", 256)
+
+	tests := []struct {
+		json string
+		want []byte
+	}{
+		{"null", hexBytes("c0")},
+		{"true", hexBytes("c3")},
+		{"false", hexBytes("c2")},
+		{"0", hexBytes("00")},
+		{"127", hexBytes("7f")},
+		{"128", hexBytes("cf 00 00 00 00 00 00 00 80")},
+		{"18446744073709551615", hexBytes("cf ff ff ff ff ff ff ff ff")},
+		{"-1", hexBytes("ff")},
+		{"-32", hexBytes("e0")},
+		{"-33", hexBytes("d3 ff ff ff ff ff ff ff df")},
+		{"1.5", hexBytes("cb 3f f8 00 00 00 00 00 00")},
+		{"1e2", hexBytes("cb 40 59 00 00 00 00 00 00")},
+		{`""`, hexBytes("a0")},
+		{`"abc"`, hexBytes("a3 61 62 63")},
+		{`"` + str32 + `"`, append(hexBytes("d9 20"), str32...)},
+		{`"` + str256 + `"`, append(hexBytes("da 01 00"), str256...)},
+		{"[]", hexBytes("90")},
+		{`[1,"a",null]`, hexBytes("93 01 a1 61 c0")},
+		{"[0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0]", append(hexBytes("dc 00 10"), make([]byte, 16)...)},
+		{"{}", hexBytes("80")},
+		// the keys keep the order of the JSON encoding
+		{`{"b":1,"a":{"c":[]}}`, hexBytes("82 a1 62 01 a1 61 81 a1 63 90")},
+	}
+
+	for _, tt := range tests {
+		name := tt.json
+		if len(name) > 40 {
+			name = name[:40]
+		}
+
+		t.Run(name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := MessagePack.Encode(&buf, json.RawMessage(tt.json)); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(buf.Bytes(), tt.want) {
+				t.Errorf("got % x, want % x", buf.Bytes(), tt.want)
+			}
+		})
+	}
+}
+
+// TestMsgpackDecode checks the formats other encoders use, which the
+// encoder does not.
+func TestMsgpackDecode(t *testing.T) {
+	tests := []struct {
+		name string
+		b    []byte
+		want string
+	}{
+		{"positive fixint", hexBytes("05"), "5"},
+		{"negative fixint", hexBytes("e0"), "-32"},
+		{"uint 8", hexBytes("cc ff"), "255"},
+		{"uint 16", hexBytes("cd 01 00"), "256"},
+		{"uint 32", hexBytes("ce 00 01 00 00"), "65536"},
+		{"uint 64", hexBytes("cf ff ff ff ff ff ff ff ff"), "18446744073709551615"},
+		{"int 8", hexBytes("d0 80"), "-128"},
+		{"int 16", hexBytes("d1 ff 00"), "-256"},
+		{"int 32", hexBytes("d2 ff ff ff ff"), "-1"},
+		{"int 64", hexBytes("d3 80 00 00 00 00 00 00 00"), "-9223372036854775808"},
+		{"float 32", hexBytes("ca 3f c0 00 00"), "1.5"},
+		{"float 64", hexBytes("cb 3f b9 99 99 99 99 99 9a"), "0.1"},
+		{"NaN", hexBytes("cb 7f f8 00 00 00 00 00 01"), "null"},
+		{"infinity", hexBytes("ca 7f 80 00 00"), "null"},
+		{"str 8", hexBytes("d9 01 61"), `"a"`},
+		{"str 16", hexBytes("da 00 01 61"), `"a"`},
+		{"str 32", hexBytes("db 00 00 00 01 61"), `"a"`},
+		{"bin 8", hexBytes("c4 03 61 62 63"), `"abc"`},
+		{"bin 16", hexBytes("c5 00 01 61"), `"a"`},
+		{"bin 32", hexBytes("c6 00 00 00 01 61"), `"a"`},
+		{"array 16", hexBytes("dc 00 02 01 c3"), "[1,true]"},
+		{"array 32", hexBytes("dd 00 00 00 00"), "[]"},
+		{"map 16", hexBytes("de 00 01 a1 61 c2"), `{"a":false}`},
+		{"map 32", hexBytes("df 00 00 00 01 a1 61 c0"), `{"a":null}`},
+		{"scalar keys", hexBytes("82 01 02 c3 03"), `{"1":2,"true":3}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got json.RawMessage
+			if err := MessagePack.Decode(bytes.NewReader(tt.b), &got); err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMsgpackDecodeErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		b    []byte
+		want string
+	}{
+		{"empty", nil, "msgpack: EOF"},
+		{"never used type", hexBytes("c1"), "msgpack: unsupported type 0xc1"},
+		{"extension", hexBytes("d4 01 00"), "msgpack: unsupported type 0xd4"},
+		{"truncated string", hexBytes("a3 61"), "msgpack: unexpected EOF"},
+		{"truncated length", hexBytes("cd 01"), "msgpack: unexpected EOF"},
+		{"truncated array", hexBytes("92 01"), "msgpack: EOF"},
+		{"map without value", hexBytes("81 a1 61"), "msgpack: EOF"},
+		{"string too long", hexBytes("db ff ff ff ff"), "msgpack: string too long"},
+		{"array too long", hexBytes("dd ff ff ff ff"), "msgpack: array too long"},
+		{"map too large", hexBytes("df ff ff ff ff"), "msgpack: map too large"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var v interface{}
+			err := MessagePack.Decode(bytes.NewReader(tt.b), &v)
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("got error %v, want %s", err, tt.want)
+			}
+		})
+	}
+}
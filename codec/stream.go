@@ -0,0 +1,287 @@
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+)
+
+// flushEvery is the number of records after which streamed lists are
+// flushed to the client.
+const flushEvery = 100
+
+// maxLineSize limits the size of an NDJSON line.
+const maxLineSize = 1 << 20
+
+// RecordReader reads the records of a list one at a time.
+type RecordReader interface {
+	// Read decodes the next record into v, a pointer to a struct. It
+	// returns io.EOF when there are no more records, and a
+	// *RecordError when a record is invalid, after which reading can
+	// go on with the next record.
+	Read(v interface{}) error
+
+	// Line returns the line of the last record read.
+	Line() int
+}
+
+// RecordWriter writes the records of a list one at a time.
+type RecordWriter interface {
+	Write(v interface{}) error
+
+	// Close ends the list and flushes it. It does not close the
+	// underlying writer.
+	Close() error
+}
+
+// RecordError is an invalid record of a list.
+type RecordError struct {
+	Line int
+	Err  error
+}
+
+func (e *RecordError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e *RecordError) Unwrap() error {
+	return e.Err
+}
+
+func flush(w io.Writer) {
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// jsonWriter writes a JSON array.
+type jsonWriter struct {
+	w io.Writer
+	n int
+}
+
+func newJSONWriter(w io.Writer) RecordWriter {
+	return &jsonWriter{w: w}
+}
+
+func (jw *jsonWriter) Write(v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	sep := []byte{','}
+	if jw.n == 0 {
+		sep[0] = '['
+	}
+
+	if _, err := jw.w.Write(append(sep, b...)); err != nil {
+		return err
+	}
+
+	jw.n++
+	if jw.n%flushEvery == 0 {
+		flush(jw.w)
+	}
+	return nil
+}
+
+func (jw *jsonWriter) Close() error {
+	end := "]\n"
+	if jw.n == 0 {
+		end = "[]\n"
+	}
+
+	_, err := io.WriteString(jw.w, end)
+	return err
+}
+
+// ndjsonWriter writes a JSON document per line.
+type ndjsonWriter struct {
+	w   io.Writer
+	enc *json.Encoder
+	n   int
+}
+
+func newNDJSONWriter(w io.Writer) RecordWriter {
+	return &ndjsonWriter{w: w, enc: json.NewEncoder(w)}
+}
+
+func (nw *ndjsonWriter) Write(v interface{}) error {
+	if err := nw.enc.Encode(v); err != nil {
+		return err
+	}
+
+	nw.n++
+	if nw.n%flushEvery == 0 {
+		flush(nw.w)
+	}
+	return nil
+}
+
+func (nw *ndjsonWriter) Close() error {
+	return nil
+}
+
+// ndjsonReader reads a JSON document per line, skipping blank lines.
+type ndjsonReader struct {
+	s    *bufio.Scanner
+	line int
+}
+
+func newNDJSONReader(r io.Reader) RecordReader {
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64<<10), maxLineSize)
+
+	return &ndjsonReader{s: s}
+}
+
+func (nr *ndjsonReader) Read(v interface{}) error {
+	for nr.s.Scan() {
+		nr.line++
+
+		b := bytes.TrimSpace(nr.s.Bytes())
+		if len(b) == 0 {
+			continue
+		}
+
+		if err := json.Unmarshal(b, v); err != nil {
+			return &RecordError{nr.line, err}
+		}
+		return nil
+	}
+
+	if err := nr.s.Err(); err != nil {
+		return err
+	}
+
+	return io.EOF
+}
+
+func (nr *ndjsonReader) Line() int {
+	return nr.line
+}
+
+// csvWriter writes a header with the columns of the first record and
+// the rows of every record. Columns missing from the first record are
+// not written.
+type csvWriter struct {
+	w       io.Writer
+	cw      *csv.Writer
+	columns []string
+	n       int
+}
+
+func newCSVWriter(w io.Writer) RecordWriter {
+	return &csvWriter{w: w, cw: csv.NewWriter(w)}
+}
+
+func (cw *csvWriter) Write(v interface{}) error {
+	tree, err := toTree(v)
+	if err != nil {
+		return err
+	}
+
+	table := &csvTable{seen: make(map[string]bool)}
+	rows := table.flatten([]csvRow{{}}, "", tree)
+
+	if cw.columns == nil {
+		cw.columns = table.columns
+		if err := cw.cw.Write(cw.columns); err != nil {
+			return err
+		}
+	}
+
+	line := make([]string, len(cw.columns))
+	for _, r := range rows {
+		for i, c := range cw.columns {
+			line[i] = r[c]
+		}
+		if err := cw.cw.Write(line); err != nil {
+			return err
+		}
+	}
+
+	cw.n++
+	if cw.n%flushEvery == 0 {
+		cw.cw.Flush()
+		flush(cw.w)
+	}
+
+	return cw.cw.Error()
+}
+
+func (cw *csvWriter) Close() error {
+	cw.cw.Flush()
+	return cw.cw.Error()
+}
+
+// csvReader reads a record per row. Unlike Decode it does not merge
+// the rows of records with lists of records.
+type csvReader struct {
+	cr     *csv.Reader
+	header []string
+	listOf []string
+	line   int
+}
+
+func newCSVReader(r io.Reader) RecordReader {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.ReuseRecord = true
+
+	return &csvReader{cr: cr}
+}
+
+func (r *csvReader) Read(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr {
+		return fmt.Errorf("csv: cannot decode into %T", v)
+	}
+	t := deref(rv.Type())
+
+	if r.header == nil {
+		header, err := r.cr.Read()
+		if err != nil {
+			return err
+		}
+		r.line, _ = r.cr.FieldPos(0)
+
+		r.header = append([]string(nil), header...)
+		if r.listOf, err = csvHeader(t, r.header); err != nil {
+			return err
+		}
+	}
+
+	cells, err := r.cr.Read()
+	if err != nil {
+		var perr *csv.ParseError
+		if errors.As(err, &perr) {
+			r.line = perr.Line
+			return &RecordError{perr.Line, perr.Err}
+		}
+		return err
+	}
+	r.line, _ = r.cr.FieldPos(0)
+
+	rec, err := newCSVRecord(t, r.header, r.listOf, cells)
+	if err != nil {
+		return &RecordError{r.line, err}
+	}
+
+	if err := fromTree(rec.obj, v); err != nil {
+		return &RecordError{r.line, err}
+	}
+
+	return nil
+}
+
+func (r *csvReader) Line() int {
+	return r.line
+}
@@ -0,0 +1,250 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/imariom/products-api/data"
+)
+
+// The formats other than JSON are converted from and to a generic tree
+// of JSON values, so they use the JSON field names and representation
+// of the data types. The tree is made of *object, []interface{},
+// json.Number, string, bool and nil values.
+
+// object is a JSON object preserving the order of its keys.
+type object struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func newObject() *object {
+	return &object{values: make(map[string]interface{})}
+}
+
+func (o *object) get(key string) (interface{}, bool) {
+	v, ok := o.values[key]
+	return v, ok
+}
+
+func (o *object) set(key string, v interface{}) {
+	if _, ok := o.values[key]; !ok {
+		o.keys = append(o.keys, key)
+	}
+	o.values[key] = v
+}
+
+// child returns the object at key, creating it if needed.
+func (o *object) child(key string) *object {
+	if c, ok := o.values[key].(*object); ok {
+		return c
+	}
+
+	c := newObject()
+	o.set(key, c)
+	return c
+}
+
+// toTree returns the tree of the JSON encoding of v.
+func toTree(v interface{}) (interface{}, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+
+	return readTree(dec)
+}
+
+func readTree(dec *json.Decoder) (interface{}, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch tok := tok.(type) {
+	case json.Delim:
+		if tok == '[' {
+			arr := []interface{}{}
+			for dec.More() {
+				v, err := readTree(dec)
+				if err != nil {
+					return nil, err
+				}
+				arr = append(arr, v)
+			}
+			_, err := dec.Token()
+			return arr, err
+		}
+
+		obj := newObject()
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+
+			v, err := readTree(dec)
+			if err != nil {
+				return nil, err
+			}
+			obj.set(key.(string), v)
+		}
+		_, err := dec.Token()
+		return obj, err
+	}
+
+	return tok, nil
+}
+
+// fromTree decodes tree into v through its JSON encoding.
+func fromTree(tree interface{}, v interface{}) error {
+	var buf bytes.Buffer
+	if err := writeJSON(&buf, tree); err != nil {
+		return err
+	}
+
+	return json.Unmarshal(buf.Bytes(), v)
+}
+
+func writeJSON(buf *bytes.Buffer, tree interface{}) error {
+	switch t := tree.(type) {
+	case *object:
+		buf.WriteByte('{')
+		for i, k := range t.keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+
+			key, _ := json.Marshal(k)
+			buf.Write(key)
+			buf.WriteByte(':')
+			if err := writeJSON(buf, t.values[k]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+
+	case []interface{}:
+		buf.WriteByte('[')
+		for i, e := range t {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeJSON(buf, e); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+
+	default:
+		b, err := json.Marshal(t)
+		if err != nil {
+			return err
+		}
+		buf.Write(b)
+	}
+
+	return nil
+}
+
+// scalar returns the text of a scalar tree value.
+func scalar(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	}
+
+	return fmt.Sprint(v)
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func deref(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	return t
+}
+
+// fieldType returns the type of the field of struct t with the given
+// JSON name, looking into embedded structs like encoding/json does.
+func fieldType(t reflect.Type, name string) (reflect.Type, bool) {
+	t = deref(t)
+	if t.Kind() != reflect.Struct {
+		return nil, false
+	}
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" && !f.Anonymous {
+			continue
+		}
+
+		if f.Anonymous && f.Tag.Get("json") == "" {
+			if ft, ok := fieldType(f.Type, name); ok {
+				return ft, true
+			}
+			continue
+		}
+
+		if data.JSONName(f) == name {
+			return f.Type, true
+		}
+	}
+
+	return nil, false
+}
+
+// isRecordList reports whether t is a slice of structs.
+func isRecordList(t reflect.Type) bool {
+	t = deref(t)
+	return t.Kind() == reflect.Slice && deref(t.Elem()).Kind() == reflect.Struct && deref(t.Elem()) != timeType
+}
+
+// typed converts text to the tree value of a field of type t. Empty
+// text is the zero value of t.
+func typed(t reflect.Type, text string) (interface{}, error) {
+	t = deref(t)
+	if t == timeType || t.Kind() == reflect.String {
+		return text, nil
+	}
+
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil, nil
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		b, err := strconv.ParseBool(text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid boolean '%s'", text)
+		}
+		return b, nil
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		if _, err := strconv.ParseFloat(text, 64); err != nil {
+			return nil, fmt.Errorf("invalid number '%s'", text)
+		}
+		return json.Number(text), nil
+	}
+
+	return nil, fmt.Errorf("unsupported field type %s", t)
+}
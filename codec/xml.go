@@ -0,0 +1,276 @@
+package codec
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// XML encodes documents with an element per JSON field. The root
+// element is named after the type of the document (<products>, <cart>)
+// and lists hold an element per value, named after the element type at
+// the top level (<product>) and <item> when nested. Maps, such as the
+// category counts, hold <entry key="..."> elements.
+var XML = &Codec{
+	Name:       "xml",
+	MediaTypes: []string{"application/xml", "text/xml"},
+	Encode:     encodeXML,
+	Decode:     decodeXML,
+}
+
+const (
+	xmlItem  = "item"
+	xmlEntry = "entry"
+	xmlKey   = "key"
+)
+
+// xmlName returns the element name of a type, e.g. "products".
+func xmlName(t reflect.Type, fallback string) string {
+	t = deref(t)
+	name := t.Name()
+	if name == "" {
+		return fallback
+	}
+
+	r := []rune(name)
+	r[0] = unicode.ToLower(r[0])
+	return string(r)
+}
+
+func encodeXML(w io.Writer, v interface{}) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+
+	enc := xml.NewEncoder(w)
+	t := reflect.TypeOf(v)
+	root := xml.StartElement{Name: xml.Name{Local: xmlName(t, "response")}}
+	rv := reflect.Indirect(reflect.ValueOf(v))
+
+	if err := enc.EncodeToken(root); err != nil {
+		return err
+	}
+
+	switch rv.Kind() {
+	case reflect.Map:
+		keys := make([]string, 0, rv.Len())
+		values := make(map[string]string, rv.Len())
+		for _, k := range rv.MapKeys() {
+			key := fmt.Sprint(k.Interface())
+			keys = append(keys, key)
+			values[key] = fmt.Sprint(rv.MapIndex(k).Interface())
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			start := xml.StartElement{
+				Name: xml.Name{Local: xmlEntry},
+				Attr: []xml.Attr{{Name: xml.Name{Local: xmlKey}, Value: k}},
+			}
+			if err := enc.EncodeElement(values[k], start); err != nil {
+				return err
+			}
+		}
+
+	default:
+		tree, err := toTree(v)
+		if err != nil {
+			return err
+		}
+
+		if arr, ok := tree.([]interface{}); ok {
+			name := xmlName(deref(t).Elem(), xmlItem)
+			for _, e := range arr {
+				if err := writeXML(enc, name, e); err != nil {
+					return err
+				}
+			}
+		} else if obj, ok := tree.(*object); ok {
+			for _, k := range obj.keys {
+				if err := writeXML(enc, k, obj.values[k]); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	if err := enc.EncodeToken(root.End()); err != nil {
+		return err
+	}
+
+	return enc.Flush()
+}
+
+func writeXML(enc *xml.Encoder, name string, v interface{}) error {
+	if v == nil {
+		return nil
+	}
+
+	start := xml.StartElement{Name: xml.Name{Local: name}}
+	if err := enc.EncodeToken(start); err != nil {
+		return err
+	}
+
+	switch v := v.(type) {
+	case *object:
+		for _, k := range v.keys {
+			if err := writeXML(enc, k, v.values[k]); err != nil {
+				return err
+			}
+		}
+
+	case []interface{}:
+		for _, e := range v {
+			if err := writeXML(enc, xmlItem, e); err != nil {
+				return err
+			}
+		}
+
+	default:
+		if err := enc.EncodeToken(xml.CharData(scalar(v))); err != nil {
+			return err
+		}
+	}
+
+	return enc.EncodeToken(start.End())
+}
+
+// xmlNode is an element of a decoded XML document.
+type xmlNode struct {
+	name     string
+	attrs    []xml.Attr
+	children []*xmlNode
+	text     strings.Builder
+}
+
+func (n *xmlNode) attr(name string) string {
+	for _, a := range n.attrs {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+
+	return ""
+}
+
+func readXML(r io.Reader) (*xmlNode, error) {
+	dec := xml.NewDecoder(r)
+	var stack []*xmlNode
+	var root *xmlNode
+
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			n := &xmlNode{name: tok.Name.Local, attrs: tok.Attr}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, n)
+			} else if root == nil {
+				root = n
+			}
+			stack = append(stack, n)
+
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+
+		case xml.CharData:
+			if len(stack) > 0 {
+				stack[len(stack)-1].text.Write(tok)
+			}
+		}
+	}
+
+	if root == nil {
+		return nil, fmt.Errorf("xml: empty document")
+	}
+
+	return root, nil
+}
+
+func decodeXML(r io.Reader, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr {
+		return fmt.Errorf("xml: cannot decode into %T", v)
+	}
+
+	root, err := readXML(r)
+	if err != nil {
+		return err
+	}
+
+	tree, err := xmlTree(rv.Type().Elem(), root)
+	if err != nil {
+		return err
+	}
+
+	return fromTree(tree, v)
+}
+
+// xmlTree converts n to the tree value of a field of type t.
+func xmlTree(t reflect.Type, n *xmlNode) (interface{}, error) {
+	t = deref(t)
+	if t == timeType {
+		return strings.TrimSpace(n.text.String()), nil
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		obj := newObject()
+		for _, c := range n.children {
+			ft, ok := fieldType(t, c.name)
+			if !ok {
+				return nil, fmt.Errorf("xml: unknown element <%s> in <%s>", c.name, n.name)
+			}
+
+			v, err := xmlTree(ft, c)
+			if err != nil {
+				return nil, err
+			}
+			if v != nil {
+				obj.set(c.name, v)
+			}
+		}
+		return obj, nil
+
+	case reflect.Slice, reflect.Array:
+		arr := []interface{}{}
+		for _, c := range n.children {
+			v, err := xmlTree(t.Elem(), c)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, v)
+		}
+		return arr, nil
+
+	case reflect.Map:
+		obj := newObject()
+		for _, c := range n.children {
+			v, err := xmlTree(t.Elem(), c)
+			if err != nil {
+				return nil, err
+			}
+			obj.set(c.attr(xmlKey), v)
+		}
+		return obj, nil
+	}
+
+	v, err := typed(t, n.text.String())
+	if err != nil {
+		return nil, fmt.Errorf("xml: element <%s>: %w", n.name, err)
+	}
+
+	return v, nil
+}
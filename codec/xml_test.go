@@ -0,0 +1,155 @@
+package codec
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/imariom/products-api/data"
+)
+
+func TestXMLEncode(t *testing.T) {
+	tests := []struct {
+		name string
+		v    interface{}
+		want string
+	}{
+		{
+			name: "list",
+			v:    data.Products{{ID: 1, SKU: "S-1", Name: "Bag & co", Category: "bags", Price: 9.5}},
+			want: "<products><product><id>1</id><sku>S-1</sku><name>Bag &amp; co</name><description></description>" +
+				"<category>bags</category><image></image><price>9.5</price></product></products>",
+		},
+		{
+			name: "empty list",
+			v:    data.Products{},
+			want: "<products></products>",
+		},
+		{
+			name: "nested records and lists",
+			v:    &data.Cart{ID: 2, UserID: 3, Products: []data.Item{{ProductID: 1, Quantity: 4}, {ProductID: 5, Quantity: 1, Unavailable: true}}},
+			want: "<cart><id>2</id><userId>3</userId><date>0001-01-01T00:00:00Z</date><products>" +
+				"<item><product_id>1</product_id><quantity>4</quantity></item>" +
+				"<item><product_id>5</product_id><quantity>1</quantity><unavailable>true</unavailable></item>" +
+				"</products><updatedAt>0001-01-01T00:00:00Z</updatedAt></cart>",
+		},
+		{
+			name: "embedded struct",
+			v:    &data.User{ID: 1, Username: "johnd", Address: &data.Address{City: "kilcoole", Number: 7}},
+			want: "<user><id>1</id><username>johnd</username><password></password><name></name><phone></phone>" +
+				"<city>kilcoole</city><street></street><number>7</number><zip_code></zip_code></user>",
+		},
+		{
+			name: "nil values are omitted",
+			v:    &struct{ A *int }{},
+			want: "<response></response>",
+		},
+		{
+			name: "map",
+			v:    data.Categories{"shoes": 1, "bags": 2},
+			want: `<categories><entry key="bags">2</entry><entry key="shoes">1</entry></categories>`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := XML.Encode(&buf, tt.v); err != nil {
+				t.Fatal(err)
+			}
+
+			got := buf.String()
+			if !strings.HasPrefix(got, `<?xml version="1.0" encoding="UTF-8"?>`+"\n") {
+				t.Fatalf("got %q, want an XML declaration", got)
+			}
+			if got = strings.TrimPrefix(got, `<?xml version="1.0" encoding="UTF-8"?>`+"\n"); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestXMLDecode(t *testing.T) {
+	tests := []struct {
+		name string
+		xml  string
+		v    interface{}
+		want interface{}
+	}{
+		{
+			name: "list",
+			xml: `<?xml version="1.0"?>
+				<products>
+					<product><id>1</id><name>Bag &amp; co</name><price> 9.5 </price></product>
+					<product><id>2</id></product>
+				</products>`,
+			v:    &data.Products{},
+			want: &data.Products{{ID: 1, Name: "Bag & co", Price: 9.5}, {ID: 2}},
+		},
+		{
+			// elements are named after the fields, not the position
+			name: "any element name in lists",
+			xml:  "<cart><products><a><quantity>2</quantity></a><b><product_id>3</product_id></b></products></cart>",
+			v:    &data.Cart{},
+			want: &data.Cart{Products: []data.Item{{Quantity: 2}, {ProductID: 3}}},
+		},
+		{
+			name: "embedded struct",
+			xml:  "<user><username>johnd</username><city>kilcoole</city></user>",
+			v:    &data.User{},
+			want: &data.User{Username: "johnd", Address: &data.Address{City: "kilcoole"}},
+		},
+		{
+			name: "empty numbers are zero",
+			xml:  "<product><id></id><price/></product>",
+			v:    &data.Product{},
+			want: &data.Product{},
+		},
+		{
+			name: "map",
+			xml:  `<categories><entry key="bags">2</entry><entry key="shoes">1</entry></categories>`,
+			v:    &data.Categories{},
+			want: &data.Categories{"bags": 2, "shoes": 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := XML.Decode(strings.NewReader(tt.xml), tt.v); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(tt.v, tt.want) {
+				t.Errorf("got %+v, want %+v", tt.v, tt.want)
+			}
+		})
+	}
+}
+
+func TestXMLDecodeErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		xml  string
+		want string
+	}{
+		{"empty document", "  ", "xml: empty document"},
+		{"unknown element", "<product><title>x</title></product>", "xml: unknown element <title> in <product>"},
+		{"invalid number", "<product><price>cheap</price></product>", "xml: element <price>: invalid number 'cheap'"},
+		{"invalid boolean", "<cart><products><item><unavailable>maybe</unavailable></item></products></cart>", "xml: element <unavailable>: invalid boolean 'maybe'"},
+		{"malformed", "<product><id>1</product>", "XML syntax error on line 1: element <id> closed by </product>"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var v interface{} = &data.Product{}
+			if strings.HasPrefix(tt.xml, "<cart>") {
+				v = &data.Cart{}
+			}
+
+			err := XML.Decode(strings.NewReader(tt.xml), v)
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("got error %v, want %s", err, tt.want)
+			}
+		})
+	}
+}
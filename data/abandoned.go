@@ -0,0 +1,43 @@
+package data
+
+import (
+	"context"
+	"time"
+)
+
+// touch records a change of c by its owner, which is no longer
+// abandoned.
+func touch(c *Cart) {
+	c.UpdatedAt = time.Now().UTC()
+	c.Abandoned = false
+}
+
+// FlagAbandonedCarts flags the carts with items not updated since t as
+// abandoned, publishing a CartAbandoned event for each, and returns how
+// many were flagged. Carts are flagged once, until they are changed
+// again. It is run by a background job, so it holds off transactions
+// itself.
+func FlagAbandonedCarts(ctx context.Context, t time.Time) int {
+	defer observe("carts", "flag_abandoned", time.Now())
+	defer HoldWrites()()
+
+	cartsRWMtx.Lock()
+	defer cartsRWMtx.Unlock()
+
+	n := 0
+	for _, c := range cartList {
+		if c.Abandoned || len(c.Products) == 0 || !c.UpdatedAt.Before(t) {
+			continue
+		}
+
+		previous := copyCarts(Carts{c})[0]
+		c.Abandoned = true
+
+		publishCart(ctx, CartAbandoned, c, previous)
+		recordCart(ctx, OpUpdate, c, previous)
+		n++
+	}
+	cartsAbandoned.Add(float64(n))
+
+	return n
+}
@@ -0,0 +1,164 @@
+package data
+
+import (
+	"context"
+	"time"
+)
+
+// the operations reported to the Auditor
+const (
+	OpCreate  = "create"
+	OpUpdate  = "update"
+	OpPatch   = "patch"
+	OpDelete  = "delete"
+	OpRestore = "restore"
+	OpPurge   = "purge"
+	OpMerge   = "merge"
+	OpExpire  = "expire"
+)
+
+// SystemActor is the actor of the changes made outside of requests,
+// e.g. by the background jobs.
+const SystemActor = "system"
+
+// Change is a change made to a record of a data store. Before is nil
+// for creations and After for deletions; both are copies the Auditor
+// may keep.
+type Change struct {
+	Time      time.Time
+	Actor     string
+	RequestID string
+	Resource  string // products, carts or users
+	ID        uint64
+	Operation string
+	Before    interface{}
+	After     interface{}
+}
+
+// Auditor records the changes made to the data stores. Record is called
+// while the store changed is locked, or when the transaction changing
+// it is committed, so the changes of a record are reported in order. It
+// must not call back into the data stores. The changes rolled back are
+// never reported.
+type Auditor interface {
+	Record(c *Change)
+}
+
+// OriginFunc returns who makes the changes with ctx and the ID of their
+// request, empty outside of requests.
+type OriginFunc func(ctx context.Context) (actor, requestID string)
+
+var (
+	auditor  Auditor
+	originOf OriginFunc = func(context.Context) (string, string) { return "", "" }
+)
+
+// SetAuditor sets the Auditor the changes are reported to. It is meant
+// to be called once, before the stores are used.
+func SetAuditor(a Auditor) {
+	auditor = a
+}
+
+// SetOriginFunc sets the function telling who makes the changes, which
+// is recorded in the trash and reported to the Auditor. It is meant to
+// be called once, before the stores are used.
+func SetOriginFunc(fn OriginFunc) {
+	originOf = fn
+}
+
+// actorOf returns who makes the changes with ctx.
+func actorOf(ctx context.Context) string {
+	actor, _ := originOf(ctx)
+	if actor == "" {
+		return SystemActor
+	}
+
+	return actor
+}
+
+// record reports a change of the record id of resource to the Auditor,
+// once the open transaction if any is committed.
+func record(ctx context.Context, resource, operation string, id uint64, before, after interface{}) {
+	if auditor == nil {
+		return
+	}
+
+	actor, requestID := originOf(ctx)
+	if actor == "" {
+		actor = SystemActor
+	}
+
+	c := &Change{
+		Time:      time.Now().UTC(),
+		Actor:     actor,
+		RequestID: requestID,
+		Resource:  resource,
+		ID:        id,
+		Operation: operation,
+		Before:    before,
+		After:     after,
+	}
+	emit(func() { auditor.Record(c) })
+}
+
+// recordProduct reports a change of a product, copying it, and adds
+// the product it left to its history.
+func recordProduct(ctx context.Context, operation string, p, prev *Product) {
+	var before, after interface{}
+	if prev != nil {
+		before = copyProducts(Products{prev})[0]
+	}
+	if p != nil {
+		after = copyProducts(Products{p})[0]
+	}
+
+	id := p
+	if id == nil {
+		id = prev
+	}
+
+	addRevision(ctx, "products", operation, id.ID, after)
+	record(ctx, "products", operation, id.ID, before, after)
+}
+
+// recordCart reports a change of a cart, copying it, and adds
+// the cart it left to its history.
+func recordCart(ctx context.Context, operation string, c, prev *Cart) {
+	var before, after interface{}
+	if prev != nil {
+		before = copyCarts(Carts{prev})[0]
+	}
+	if c != nil {
+		after = copyCarts(Carts{c})[0]
+	}
+
+	id := c
+	if id == nil {
+		id = prev
+	}
+
+	addRevision(ctx, "carts", operation, id.ID, after)
+	record(ctx, "carts", operation, id.ID, before, after)
+}
+
+// recordUser reports a change of a user, copying it.
+func recordUser(ctx context.Context, operation string, u, prev *User) {
+	if auditor == nil {
+		return
+	}
+
+	var before, after interface{}
+	if prev != nil {
+		before = copyUsers(Users{prev})[0]
+	}
+	if u != nil {
+		after = copyUsers(Users{u})[0]
+	}
+
+	id := u
+	if id == nil {
+		id = prev
+	}
+
+	record(ctx, "users", operation, id.ID, before, after)
+}
@@ -1,6 +1,7 @@
 package data
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -13,7 +14,11 @@ var cartsRWMtx = &sync.RWMutex{}
 
 type Item struct {
 	ProductID uint64 `json:"product_id"`
-	Quantity  uint64 `json:"quantity"`
+	Quantity  uint64 `json:"quantity" validate:"min=1"`
+
+	// Unavailable is set by the store on the items of a product removed
+	// under the Nullify on-delete policy.
+	Unavailable bool `json:"unavailable,omitempty"`
 }
 
 type Cart struct {
@@ -21,6 +26,18 @@ type Cart struct {
 	UserID   uint64    `json:"userId"`
 	Date     time.Time `json:"date"` // YYYY-MM-DD
 	Products []Item    `json:"products"`
+
+	// Guest is set on the carts of anonymous customers, which have no
+	// user until they are merged into the cart of one.
+	Guest bool `json:"guest,omitempty"`
+
+	// UpdatedAt is set by the store when the cart is created or changed
+	// by its owner.
+	UpdatedAt time.Time `json:"updatedAt"`
+
+	// Abandoned is set by the store on the carts left unchanged for too
+	// long, until they are changed again.
+	Abandoned bool `json:"abandoned,omitempty"`
 }
 
 type Carts []*Cart
@@ -36,15 +53,19 @@ var cartList = Carts{
 				Quantity:  2,
 			},
 		},
+		UpdatedAt: time.Now().UTC(),
 	},
 }
 
+// cartExists returns the cart id and its index. Guest carts are only
+// reached with their token, through the functions of guest.go, so they
+// are left out here as by the other functions of this file.
 func cartExists(id uint64) (int, *Cart, error) {
 	cartsRWMtx.RLock()
 	defer cartsRWMtx.RUnlock()
 
 	for i, c := range cartList {
-		if id == c.ID {
+		if id == c.ID && !c.Guest {
 			tmp := &Cart{}
 			*tmp = *c
 			return i, tmp, nil
@@ -54,63 +75,106 @@ func cartExists(id uint64) (int, *Cart, error) {
 	return -1, nil, fmt.Errorf("requested cart does not exist")
 }
 
+// getNextCartID returns the ID following the highest ID of the carts,
+// including those in the trash. It must be called with cartsRWMtx held
+// for writing, along with the append of the cart, so that concurrent
+// creations do not get the same ID.
 func getNextCartID() uint64 {
-	cartsRWMtx.RLock()
-	defer cartsRWMtx.RUnlock()
+	max, found := maxTrashedID("carts")
+	for _, c := range cartList {
+		if !found || c.ID > max {
+			max, found = c.ID, true
+		}
+	}
 
-	if len(cartList) == 0 {
+	if !found {
 		return 0
 	}
 
-	lastCart := cartList[len(cartList)-1]
-
-	return lastCart.ID + 1
+	return max + 1
 }
 
-func AddCart(c *Cart) error {
-	// TODO: validate if provided user_id and each product_id
-	// are valid (talk to users and products models to verify)
-	c.ID = getNextCartID()
+// AddCart stores c, which must reference an existing user and existing
+// products, otherwise a *ValidationError is returned.
+func AddCart(ctx context.Context, c *Cart) error {
+	defer observe("carts", "create", time.Now())
+
+	productsRWMtx.RLock()
+	defer productsRWMtx.RUnlock()
+	userRWMutex.RLock()
+	defer userRWMutex.RUnlock()
+
+	if err := checkOwner(c); err != nil {
+		return err
+	}
+	items, err := checkItems(c.Products)
+	if err != nil {
+		return err
+	}
+	c.Products = items
+
+	touch(c)
 
 	cartsRWMtx.Lock()
+	c.ID = getNextCartID()
 	cartList = append(cartList, c)
+	publishCart(ctx, CartCreated, c, nil)
+	recordCart(ctx, OpCreate, c, nil)
 	cartsRWMtx.Unlock()
 
+	cartsCreated.Inc()
+
 	return nil
 }
 
-func RemoveCart(id uint64) (*Cart, error) {
-	index, cart, err := cartExists(id)
-	if err != nil {
-		return nil, err
-	}
-
-	deletedCart := &Cart{}
+// RemoveCart moves the cart id to the trash, recording who deleted it.
+func RemoveCart(ctx context.Context, id uint64) (*Cart, error) {
+	defer observe("carts", "delete", time.Now())
 
 	cartsRWMtx.Lock()
-	*deletedCart = *cart
-	tmpList := make(Carts, 0, len(cartList)-1)
-
-	for i, c := range cartList {
-		if i == index {
-			continue
-		}
+	defer cartsRWMtx.Unlock()
 
-		tmpList = append(tmpList, c)
+	dropped := dropCarts(func(c *Cart) bool { return c.ID == id && !c.Guest })
+	if len(dropped) == 0 {
+		return nil, fmt.Errorf("requested cart does not exist")
 	}
-	cartList = tmpList
-	cartsRWMtx.Unlock()
+
+	deletedCart := copyCarts(dropped)[0]
+	moveToTrash(ctx, "carts", id, copyCarts(dropped)[0])
+	publishCart(ctx, CartDeleted, deletedCart, nil)
+	recordCart(ctx, OpDelete, nil, deletedCart)
 
 	return deletedCart, nil
 }
 
-func UpdateCart(cart *Cart) error {
+func UpdateCart(ctx context.Context, cart *Cart) error {
+	defer observe("carts", "update", time.Now())
+
+	productsRWMtx.RLock()
+	defer productsRWMtx.RUnlock()
+	userRWMutex.RLock()
+	defer userRWMutex.RUnlock()
+
 	cartsRWMtx.Lock()
 	defer cartsRWMtx.Unlock()
 
 	for i, c := range cartList {
-		if c.ID == cart.ID {
+		if c.ID == cart.ID && !c.Guest {
+			// guest carts are only created with their token
+			cart.Guest = false
+			if err := checkOwner(cart); err != nil {
+				return err
+			}
+			items, err := checkItems(cart.Products)
+			if err != nil {
+				return err
+			}
+			cart.Products = items
+
+			touch(cart)
 			cartList[i] = cart
+			publishCart(ctx, CartUpdated, cart, c)
+			recordCart(ctx, OpUpdate, cart, c)
 			return nil
 		}
 	}
@@ -118,14 +182,36 @@ func UpdateCart(cart *Cart) error {
 	return fmt.Errorf("requested cart does not exist")
 }
 
-func SetCart(cart *Cart) error {
+func SetCart(ctx context.Context, cart *Cart) error {
+	defer observe("carts", "patch", time.Now())
+
+	productsRWMtx.RLock()
+	defer productsRWMtx.RUnlock()
+	userRWMutex.RLock()
+	defer userRWMutex.RUnlock()
+
 	cartsRWMtx.Lock()
 	defer cartsRWMtx.Unlock()
 
 	// TODO: optimize this loop by using the userExists() function
 	// and using a better data structure.
 	for i, c := range cartList {
-		if c.ID == cart.ID {
+		if c.ID == cart.ID && !c.Guest {
+			if cart.UserID != 0 {
+				if err := checkUser(cart.UserID); err != nil {
+					return err
+				}
+			}
+			if cart.Products != nil {
+				items, err := checkItems(cart.Products)
+				if err != nil {
+					return err
+				}
+				cart.Products = items
+			}
+
+			previous := copyCarts(Carts{c})[0]
+
 			if cart.UserID != 0 {
 				cartList[i].UserID = cart.UserID
 			}
@@ -133,9 +219,12 @@ func SetCart(cart *Cart) error {
 			if cart.Products != nil {
 				cartList[i].Products = cart.Products
 			}
+			touch(cartList[i])
 
 			// set temporary cart equal to original product
 			*cart = *cartList[i]
+			publishCart(ctx, CartUpdated, cart, previous)
+			recordCart(ctx, OpPatch, cart, previous)
 			return nil
 		}
 	}
@@ -143,7 +232,11 @@ func SetCart(cart *Cart) error {
 	return fmt.Errorf("requested cart does not exist")
 }
 
-func GetAllCarts(l int, s string) Carts {
+// GetAllCarts retrieve at most l carts sorted by date, skipping the
+// first o carts. Guest carts are left out.
+func GetAllCarts(l, o int, s string) Carts {
+	defer observe("carts", "list", time.Now())
+
 	// sort cart list
 	if s == "asc" {
 		sort.Sort(cartList)
@@ -151,32 +244,37 @@ func GetAllCarts(l int, s string) Carts {
 		sort.Sort(sort.Reverse(cartList))
 	}
 
-	// limit the result
-	if l <= 0 || l >= cartList.Len() {
-		l = cartList.Len()
-	}
-
 	cartsRWMtx.RLock()
 	defer cartsRWMtx.RUnlock()
 
-	// it is necessary to get a copy of each product from the
-	// memory to avoid returning a cartList that while is being
-	// used by the caller it is being modified by another goroutine.
-	temp := make(Carts, 0, l)
-	for i := 0; i != l; i++ {
-		temp = append(temp, cartList[i])
+	carts := make(Carts, 0, len(cartList))
+	for _, c := range cartList {
+		if !c.Guest {
+			carts = append(carts, c)
+		}
+	}
+
+	// skip the offset and limit the result
+	if o < 0 || o > len(carts) {
+		o = len(carts)
+	}
+
+	if l <= 0 || l >= len(carts)-o {
+		l = len(carts) - o
 	}
 
-	return temp
+	return carts[o : o+l]
 }
 
 func GetAllUserCarts(userID uint64) Carts {
+	defer observe("carts", "list_by_user", time.Now())
+
 	cartsRWMtx.RLock()
 	defer cartsRWMtx.RUnlock()
 
 	tmpCarts := make(Carts, 0)
 	for _, c := range cartList {
-		if c.UserID == userID {
+		if !c.Guest && c.UserID == userID {
 			tmpCarts = append(tmpCarts, c)
 		}
 	}
@@ -184,13 +282,45 @@ func GetAllUserCarts(userID uint64) Carts {
 	return tmpCarts
 }
 
+// GetAllUsersCarts get copies of the carts of each of the given users in
+// a single pass over the data store.
+func GetAllUsersCarts(userIDs []uint64) map[uint64]Carts {
+	defer observe("carts", "list_by_users", time.Now())
+
+	carts := make(map[uint64]Carts, len(userIDs))
+	for _, id := range userIDs {
+		carts[id] = Carts{}
+	}
+
+	cartsRWMtx.RLock()
+	defer cartsRWMtx.RUnlock()
+
+	for _, c := range cartList {
+		if userCarts, ok := carts[c.UserID]; ok && !c.Guest {
+			carts[c.UserID] = append(userCarts, c)
+		}
+	}
+
+	for id, userCarts := range carts {
+		carts[id] = copyCarts(userCarts)
+	}
+
+	return carts
+}
+
 func GetCartsInDateRange(start, end time.Time) Carts {
+	defer observe("carts", "list_by_date", time.Now())
+
 	// get all carts with date after or starting from start and ending on end
 	cartsRWMtx.RLock()
 	defer cartsRWMtx.RUnlock()
 
 	tmpCarts := make(Carts, 0)
 	for _, c := range cartList {
+		if c.Guest {
+			continue
+		}
+
 		// get all carts in the range (;end]
 		if start.IsZero() {
 			if c.Date.Equal(end) || c.Date.Before(end) {
@@ -218,6 +348,8 @@ func GetCartsInDateRange(start, end time.Time) Carts {
 }
 
 func GetCart(id uint64) (*Cart, error) {
+	defer observe("carts", "get", time.Now())
+
 	_, cart, err := cartExists(id)
 	if err != nil {
 		return nil, err
@@ -227,10 +359,16 @@ func GetCart(id uint64) (*Cart, error) {
 }
 
 func (cs *Carts) ToJSON(w io.Writer) error {
+	return cs.Encode(json.NewEncoder(w).Encode)
+}
+
+// Encode calls enc with the carts while holding the store lock, as the
+// carts are shared with the data store.
+func (cs *Carts) Encode(enc func(v interface{}) error) error {
 	cartsRWMtx.RLock()
 	defer cartsRWMtx.RUnlock()
 
-	return json.NewEncoder(w).Encode(cs)
+	return enc(cs)
 }
 
 func (c *Cart) ToJSON(w io.Writer) error {
@@ -0,0 +1,57 @@
+package data
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// newTestProduct adds a product with the maximum quantity max to the
+// store.
+func newTestProduct(t *testing.T, max uint64) *Product {
+	t.Helper()
+
+	p := &Product{Name: "test", Category: "test", Price: 1, MaxQuantity: max}
+	if err := AddNewProduct(context.Background(), p); err != nil {
+		t.Fatal(err)
+	}
+
+	return p
+}
+
+// newTestUser adds a user to the store.
+func newTestUser(t *testing.T) *User {
+	t.Helper()
+
+	u := &User{Username: "test", Password: "test", Name: "test"}
+	AddNewUser(context.Background(), u)
+
+	return u
+}
+
+// newTestCart adds a cart of the user userID with items to the store,
+// a guest cart if guest is set.
+func newTestCart(t *testing.T, userID uint64, guest bool, items ...Item) *Cart {
+	t.Helper()
+
+	c := &Cart{UserID: userID, Date: time.Now(), Guest: guest, Products: items}
+	if err := AddCart(context.Background(), c); err != nil {
+		t.Fatal(err)
+	}
+
+	return c
+}
+
+// equalItems reports whether the items a and b are the same, in order.
+func equalItems(a, b []Item) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
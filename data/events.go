@@ -0,0 +1,94 @@
+package data
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/imariom/products-api/events"
+)
+
+// the types of the events published by the data stores on the default
+// events bus
+const (
+	ProductCreated  = "product.created"
+	ProductUpdated  = "product.updated"
+	ProductDeleted  = "product.deleted"
+	ProductRestored = "product.restored"
+	CartCreated     = "cart.created"
+	CartUpdated     = "cart.updated"
+	CartDeleted     = "cart.deleted"
+	CartRestored    = "cart.restored"
+	CartAbandoned   = "cart.abandoned"
+	UserCreated     = "user.created"
+	UserUpdated     = "user.updated"
+	UserDeleted     = "user.deleted"
+	UserRestored    = "user.restored"
+)
+
+// publishProduct publishes a copy of p, and of prev for updates, once
+// the open transaction if any is committed, on the topics products,
+// products:{id} and products:category:{category}. The event carries on
+// the trace of ctx, as do those of publishCart and publishUser.
+func publishProduct(ctx context.Context, typ string, p, prev *Product) {
+	topics := []string{
+		"products",
+		"products:" + strconv.FormatUint(p.ID, 10),
+		"products:category:" + p.Category,
+	}
+
+	var previous interface{}
+	if prev != nil {
+		previous = copyProducts(Products{prev})[0]
+	}
+
+	current := copyProducts(Products{p})[0]
+	emit(func() { events.PublishContext(ctx, typ, topics, current, previous) })
+}
+
+// publishCart publishes a copy of c, and of prev for updates, once the
+// open transaction if any is committed, on the topics carts, carts:{id}
+// and carts:user:{userId}, or carts:guest for guest carts.
+func publishCart(ctx context.Context, typ string, c, prev *Cart) {
+	owner := "carts:user:" + strconv.FormatUint(c.UserID, 10)
+	if c.Guest {
+		owner = "carts:guest"
+	}
+
+	topics := []string{
+		"carts",
+		"carts:" + strconv.FormatUint(c.ID, 10),
+		owner,
+	}
+
+	var previous interface{}
+	if prev != nil {
+		previous = copyCarts(Carts{prev})[0]
+	}
+
+	current := copyCarts(Carts{c})[0]
+	emit(func() { events.PublishContext(ctx, typ, topics, current, previous) })
+}
+
+// publishUser publishes a copy of u, and of prev for updates, without
+// their password once the open transaction if any is committed, on the
+// topics users and users:{id}.
+func publishUser(ctx context.Context, typ string, u, prev *User) {
+	topics := []string{
+		"users",
+		"users:" + strconv.FormatUint(u.ID, 10),
+	}
+
+	var previous interface{}
+	if prev != nil {
+		previous = userWithoutPassword(prev)
+	}
+
+	current := userWithoutPassword(u)
+	emit(func() { events.PublishContext(ctx, typ, topics, current, previous) })
+}
+
+func userWithoutPassword(u *User) *User {
+	c := copyUsers(Users{u})[0]
+	c.Password = ""
+	return c
+}
@@ -0,0 +1,267 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+)
+
+// MergeRule is how the quantities of the items of a guest cart and of
+// the cart of a user are combined when they have the same product.
+type MergeRule string
+
+const (
+	// MergeSum adds the quantities of both items.
+	MergeSum MergeRule = "sum"
+
+	// MergeMax keeps the largest quantity.
+	MergeMax MergeRule = "max"
+
+	// MergeGuest keeps the quantity of the guest cart.
+	MergeGuest MergeRule = "guest"
+
+	// MergeUser keeps the quantity of the cart of the user.
+	MergeUser MergeRule = "user"
+)
+
+// mergeRule defaults to sum, so no item added as a guest is lost.
+var mergeRule = MergeSum
+
+// SetMergeRule sets the rule combining the items of the same product
+// when merging guest carts. It is meant to be called once, before the
+// stores are used.
+func SetMergeRule(rule MergeRule) error {
+	switch rule {
+	case MergeSum, MergeMax, MergeGuest, MergeUser:
+	default:
+		return fmt.Errorf("invalid guest cart merge rule: %q", rule)
+	}
+
+	mergeRule = rule
+	return nil
+}
+
+// GetGuestCart returns the guest cart id. Carts of users are not
+// returned, so a guest cannot read them once theirs was merged.
+func GetGuestCart(id uint64) (*Cart, error) {
+	defer observe("carts", "get_guest", time.Now())
+
+	cartsRWMtx.RLock()
+	defer cartsRWMtx.RUnlock()
+
+	for _, c := range cartList {
+		if c.ID == id && c.Guest {
+			return copyCarts(Carts{c})[0], nil
+		}
+	}
+
+	return nil, fmt.Errorf("requested cart does not exist")
+}
+
+// UpdateGuestCart replaces the items of the guest cart id. Its date is
+// kept, as the token of the cart is bound to it. It fails with a
+// *ValidationError if an item references a product that does not exist
+// or exceeds its maximum quantity.
+func UpdateGuestCart(ctx context.Context, id uint64, items []Item) (*Cart, error) {
+	defer observe("carts", "update_guest", time.Now())
+
+	productsRWMtx.RLock()
+	defer productsRWMtx.RUnlock()
+	cartsRWMtx.Lock()
+	defer cartsRWMtx.Unlock()
+
+	for _, c := range cartList {
+		if c.ID != id || !c.Guest {
+			continue
+		}
+
+		items, err := checkItems(items)
+		if err != nil {
+			return nil, err
+		}
+
+		previous := copyCarts(Carts{c})[0]
+		c.Products = items
+		touch(c)
+
+		publishCart(ctx, CartUpdated, c, previous)
+		recordCart(ctx, OpUpdate, c, previous)
+
+		return copyCarts(Carts{c})[0], nil
+	}
+
+	return nil, fmt.Errorf("requested cart does not exist")
+}
+
+// MergeGuestCart gives the guest cart id to the user userID, when the
+// guest logs in. Its items are merged into the most recently updated
+// cart of the user, following the merge rule, and the guest cart is
+// dropped; it becomes the cart of the user if they have none. It fails
+// with a *ValidationError if the quantity of an item would overflow.
+func MergeGuestCart(ctx context.Context, id, userID uint64) (*Cart, error) {
+	defer observe("carts", "merge", time.Now())
+
+	productsRWMtx.RLock()
+	defer productsRWMtx.RUnlock()
+	userRWMutex.RLock()
+	defer userRWMutex.RUnlock()
+
+	if err := checkUser(userID); err != nil {
+		return nil, err
+	}
+
+	cartsRWMtx.Lock()
+	defer cartsRWMtx.Unlock()
+
+	var guest, active *Cart
+	for _, c := range cartList {
+		switch {
+		case c.ID == id && c.Guest:
+			guest = c
+		case !c.Guest && c.UserID == userID && (active == nil || c.UpdatedAt.After(active.UpdatedAt)):
+			active = c
+		}
+	}
+
+	if guest == nil {
+		return nil, fmt.Errorf("requested cart does not exist")
+	}
+
+	if active == nil {
+		previous := copyCarts(Carts{guest})[0]
+		guest.Guest = false
+		guest.UserID = userID
+		touch(guest)
+
+		publishCart(ctx, CartUpdated, guest, previous)
+		recordCart(ctx, OpMerge, guest, previous)
+		guestCartsMerged.Inc()
+
+		return copyCarts(Carts{guest})[0], nil
+	}
+
+	items, err := mergeItems(active.Products, guest.Products, mergeRule)
+	if err != nil {
+		return nil, err
+	}
+
+	previous := copyCarts(Carts{active})[0]
+	active.Products = items
+	touch(active)
+
+	dropCarts(func(c *Cart) bool { return c == guest })
+	publishCart(ctx, CartDeleted, guest, nil)
+	recordCart(ctx, OpDelete, nil, guest)
+	dropHistory("carts", guest.ID)
+
+	publishCart(ctx, CartUpdated, active, previous)
+	recordCart(ctx, OpMerge, active, previous)
+	guestCartsMerged.Inc()
+
+	return copyCarts(Carts{active})[0], nil
+}
+
+// mergeItems returns the items of the cart of a user with the items of
+// a guest cart added, combining the items of the same product with rule
+// up to the maximum quantity of the product. The unavailable items of
+// the guest cart are left out, and those of the user replaced by the
+// items of the guest cart for the same product. It fails with a
+// *ValidationError if a sum overflows for a product with no maximum
+// quantity. It must be called with productsRWMtx held.
+func mergeItems(items, guest []Item, rule MergeRule) ([]Item, error) {
+	merged, err := coalesceItems(append([]Item(nil), items...))
+	if err != nil {
+		return nil, err
+	}
+
+next:
+	for _, g := range guest {
+		if g.Unavailable {
+			continue
+		}
+
+		for i, item := range merged {
+			if item.ProductID != g.ProductID {
+				continue
+			}
+
+			if item.Unavailable {
+				merged[i] = g
+				continue next
+			}
+
+			switch rule {
+			case MergeSum:
+				// saturated, then clamped to the maximum below
+				q, err := addQuantity(item.Quantity, g.Quantity)
+				if err != nil {
+					if p := findProduct(item.ProductID); p == nil || p.MaxQuantity == 0 {
+						return nil, err
+					}
+					q = math.MaxUint64
+				}
+				merged[i].Quantity = q
+			case MergeMax:
+				if g.Quantity > item.Quantity {
+					merged[i].Quantity = g.Quantity
+				}
+			case MergeGuest:
+				merged[i].Quantity = g.Quantity
+			}
+			continue next
+		}
+
+		merged = append(merged, g)
+	}
+
+	for i, item := range merged {
+		if p := findProduct(item.ProductID); p != nil && p.MaxQuantity > 0 && item.Quantity > p.MaxQuantity {
+			merged[i].Quantity = p.MaxQuantity
+		}
+	}
+
+	return merged, nil
+}
+
+// ExpireGuestCarts drops the guest carts not updated since t, and
+// returns their number. They are not kept in the trash. It is run by a
+// background job, so it holds off transactions itself.
+func ExpireGuestCarts(ctx context.Context, t time.Time) int {
+	defer observe("carts", "expire_guests", time.Now())
+	defer HoldWrites()()
+
+	cartsRWMtx.Lock()
+	defer cartsRWMtx.Unlock()
+
+	expired := dropCarts(func(c *Cart) bool {
+		return c.Guest && c.UpdatedAt.Before(t)
+	})
+
+	for _, c := range expired {
+		publishCart(ctx, CartDeleted, c, nil)
+		recordCart(ctx, OpExpire, nil, c)
+		dropHistory("carts", c.ID)
+	}
+	guestCartsExpired.Add(float64(len(expired)))
+
+	return len(expired)
+}
+
+// dropCarts removes the carts matching drop from the data store, and
+// returns them. It must be called with cartsRWMtx held.
+func dropCarts(drop func(c *Cart) bool) Carts {
+	dropped := Carts{}
+	kept := make(Carts, 0, len(cartList))
+
+	for _, c := range cartList {
+		if drop(c) {
+			dropped = append(dropped, c)
+			continue
+		}
+		kept = append(kept, c)
+	}
+	cartList = kept
+
+	return dropped
+}
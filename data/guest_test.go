@@ -0,0 +1,102 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestMergeGuestCart(t *testing.T) {
+	unlimited := newTestProduct(t, 0)
+	limited := newTestProduct(t, 5)
+
+	tests := []struct {
+		name  string
+		rule  MergeRule
+		user  []Item
+		guest []Item
+		want  []Item
+		err   bool
+	}{
+		{
+			name:  "sum",
+			rule:  MergeSum,
+			user:  []Item{{ProductID: unlimited.ID, Quantity: 2}},
+			guest: []Item{{ProductID: unlimited.ID, Quantity: 3}, {ProductID: limited.ID, Quantity: 1}},
+			want:  []Item{{ProductID: unlimited.ID, Quantity: 5}, {ProductID: limited.ID, Quantity: 1}},
+		},
+		{
+			name:  "sum up to the maximum quantity",
+			rule:  MergeSum,
+			user:  []Item{{ProductID: limited.ID, Quantity: 4}},
+			guest: []Item{{ProductID: limited.ID, Quantity: 3}},
+			want:  []Item{{ProductID: limited.ID, Quantity: 5}},
+		},
+		{
+			name:  "sum overflow",
+			rule:  MergeSum,
+			user:  []Item{{ProductID: unlimited.ID, Quantity: math.MaxUint64}},
+			guest: []Item{{ProductID: unlimited.ID, Quantity: 1}},
+			err:   true,
+		},
+		{
+			name:  "max",
+			rule:  MergeMax,
+			user:  []Item{{ProductID: unlimited.ID, Quantity: 2}},
+			guest: []Item{{ProductID: unlimited.ID, Quantity: 3}},
+			want:  []Item{{ProductID: unlimited.ID, Quantity: 3}},
+		},
+		{
+			name:  "user",
+			rule:  MergeUser,
+			user:  []Item{{ProductID: unlimited.ID, Quantity: 2}},
+			guest: []Item{{ProductID: unlimited.ID, Quantity: 3}},
+			want:  []Item{{ProductID: unlimited.ID, Quantity: 2}},
+		},
+	}
+
+	t.Cleanup(func() { SetMergeRule(MergeSum) })
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := SetMergeRule(tt.rule); err != nil {
+				t.Fatal(err)
+			}
+
+			u := newTestUser(t)
+			user := newTestCart(t, u.ID, false, tt.user...)
+			guest := newTestCart(t, 0, true, tt.guest...)
+
+			c, err := MergeGuestCart(context.Background(), guest.ID, u.ID)
+			if tt.err {
+				var ve *ValidationError
+				if !errors.As(err, &ve) {
+					t.Fatalf("got error %v, want a *ValidationError", err)
+				}
+
+				// neither cart changed
+				if _, err := GetGuestCart(guest.ID); err != nil {
+					t.Errorf("guest cart dropped: %v", err)
+				}
+				if c, _ := GetCart(user.ID); !equalItems(c.Products, tt.user) {
+					t.Errorf("got items %v, want %v", c.Products, tt.user)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if c.ID != user.ID {
+				t.Errorf("merged into cart %d, want %d", c.ID, user.ID)
+			}
+			if !equalItems(c.Products, tt.want) {
+				t.Errorf("got items %v, want %v", c.Products, tt.want)
+			}
+			if _, err := GetGuestCart(guest.ID); err == nil {
+				t.Error("guest cart not dropped")
+			}
+		})
+	}
+}
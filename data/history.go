@@ -0,0 +1,305 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrNoRevision is returned when reading a record at a time it did not
+// exist, either because it was not created yet or it was deleted.
+var ErrNoRevision = errors.New("requested record did not exist at that time")
+
+// ErrRevisionDropped is returned when reading a record at a time older
+// than the revisions kept of it.
+var ErrRevisionDropped = errors.New("requested record is no longer known as it was at that time")
+
+// DefaultMaxRevisions is the number of revisions kept by record, unless
+// configured otherwise.
+const DefaultMaxRevisions = 100
+
+// maxRevisions is the number of revisions kept by record, the oldest
+// being dropped beyond.
+var maxRevisions = DefaultMaxRevisions
+
+// SetMaxRevisions sets the number of revisions kept by record. It is
+// meant to be called once, before the stores are used.
+func SetMaxRevisions(n int) error {
+	if n <= 0 {
+		return fmt.Errorf("invalid number of revisions: %d, it must be positive", n)
+	}
+
+	maxRevisions = n
+	return nil
+}
+
+// revision is a version of a record, as left by a change. The record is
+// nil once deleted and is never modified.
+type revision struct {
+	version   int
+	time      time.Time
+	actor     string
+	operation string
+	record    interface{}
+}
+
+// ProductRevision is a version of a product, as left by a change.
+// Product is nil for the revisions recording its deletion.
+type ProductRevision struct {
+	Version   int       `json:"version"`
+	Time      time.Time `json:"time"`
+	Actor     string    `json:"actor"`
+	Operation string    `json:"operation"`
+	Product   *Product  `json:"product"`
+}
+
+// this mutex is used to control access to the history. It is always
+// acquired after the locks of the data stores and the trash.
+var historyMtx = &sync.Mutex{}
+
+// the revisions of the products and the carts by ID, oldest first. The
+// history starts with the records of the data stores when the program
+// starts, keeps the last maxRevisions revisions of each record, and
+// forgets the records once they are purged from the trash or dropped.
+var history = map[string]map[uint64][]*revision{
+	"products": seedHistory(productList),
+	"carts":    seedHistory(cartList),
+}
+
+// seedHistory returns the first revisions of the seeded records.
+func seedHistory(records interface{}) map[uint64][]*revision {
+	revisions := map[uint64][]*revision{}
+	seed := func(id uint64, record interface{}) {
+		revisions[id] = []*revision{{
+			version:   1,
+			time:      time.Now().UTC(),
+			actor:     SystemActor,
+			operation: OpCreate,
+			record:    record,
+		}}
+	}
+
+	switch rs := records.(type) {
+	case Products:
+		for _, p := range copyProducts(rs) {
+			seed(p.ID, p)
+		}
+	case Carts:
+		for _, c := range copyCarts(rs) {
+			seed(c.ID, c)
+		}
+	}
+
+	return revisions
+}
+
+// addRevision appends the state left by a change of the record id of
+// resource to its history, dropping its oldest revision beyond
+// maxRevisions. record must be a copy, nil for deletions.
+func addRevision(ctx context.Context, resource, operation string, id uint64, record interface{}) {
+	historyMtx.Lock()
+	defer historyMtx.Unlock()
+
+	version := 1
+	revisions := history[resource][id]
+	if n := len(revisions); n > 0 {
+		version = revisions[n-1].version + 1
+	}
+
+	revisions = append(revisions, &revision{
+		version:   version,
+		time:      time.Now().UTC(),
+		actor:     actorOf(ctx),
+		operation: operation,
+		record:    record,
+	})
+	if len(revisions) > maxRevisions {
+		revisions = revisions[len(revisions)-maxRevisions:]
+	}
+	history[resource][id] = revisions
+}
+
+// dropHistory forgets the revisions of the record id of resource, once
+// it is gone for good.
+func dropHistory(resource string, id uint64) {
+	historyMtx.Lock()
+	defer historyMtx.Unlock()
+
+	delete(history[resource], id)
+}
+
+// recordAt returns the record id of resource as it was at t.
+func recordAt(resource string, id uint64, t time.Time) (interface{}, error) {
+	historyMtx.Lock()
+	defer historyMtx.Unlock()
+
+	revisions := history[resource][id]
+	i := sort.Search(len(revisions), func(i int) bool {
+		return revisions[i].time.After(t)
+	})
+
+	if i == 0 && len(revisions) > 0 && revisions[0].version > 1 {
+		return nil, ErrRevisionDropped
+	}
+	if i == 0 || revisions[i-1].record == nil {
+		return nil, ErrNoRevision
+	}
+
+	return revisions[i-1].record, nil
+}
+
+// recordsAt returns the records of resource which existed at t.
+func recordsAt(resource string, t time.Time) []interface{} {
+	historyMtx.Lock()
+	ids := make([]uint64, 0, len(history[resource]))
+	for id := range history[resource] {
+		ids = append(ids, id)
+	}
+	historyMtx.Unlock()
+
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	records := []interface{}{}
+	for _, id := range ids {
+		if r, err := recordAt(resource, id, t); err == nil {
+			records = append(records, r)
+		}
+	}
+
+	return records
+}
+
+// GetProductHistory returns the revisions of the product id kept, oldest
+// first, including those of its deletions and restorations.
+func GetProductHistory(id uint64) ([]*ProductRevision, error) {
+	defer observe("products", "history", time.Now())
+
+	historyMtx.Lock()
+	defer historyMtx.Unlock()
+
+	revisions := history["products"][id]
+	if len(revisions) == 0 {
+		return nil, errors.New("product not found")
+	}
+
+	list := make([]*ProductRevision, 0, len(revisions))
+	for _, r := range revisions {
+		pr := &ProductRevision{
+			Version:   r.version,
+			Time:      r.time,
+			Actor:     r.actor,
+			Operation: r.operation,
+		}
+		if p, ok := r.record.(*Product); ok {
+			pr.Product = copyProducts(Products{p})[0]
+		}
+		list = append(list, pr)
+	}
+
+	return list, nil
+}
+
+// GetProductAsOf returns the product id as it was at t.
+func GetProductAsOf(id uint64, t time.Time) (*Product, error) {
+	defer observe("products", "get_as_of", time.Now())
+
+	r, err := recordAt("products", id, t)
+	if err != nil {
+		return nil, err
+	}
+
+	return copyProducts(Products{r.(*Product)})[0], nil
+}
+
+// GetAllProductsAsOf is GetAllProducts reading the products as they were
+// at t.
+func GetAllProductsAsOf(t time.Time, limitRes, offset int, sortCriteria string) Products {
+	defer observe("products", "list_as_of", time.Now())
+
+	products := Products{}
+	for _, r := range recordsAt("products", t) {
+		products = append(products, copyProducts(Products{r.(*Product)})[0])
+	}
+
+	sort.SliceStable(products, func(i, j int) bool {
+		if sortCriteria == "desc" {
+			return products[i].Price > products[j].Price
+		}
+		return products[i].Price < products[j].Price
+	})
+
+	start, end := pageBounds(len(products), limitRes, offset)
+	return products[start:end]
+}
+
+// GetCartAsOf returns the cart id as it was at t. Its items can be priced
+// with the products as they were at the same time. Guest carts are left
+// out, as by GetCart.
+func GetCartAsOf(id uint64, t time.Time) (*Cart, error) {
+	defer observe("carts", "get_as_of", time.Now())
+
+	r, err := recordAt("carts", id, t)
+	if err != nil {
+		return nil, err
+	}
+	if r.(*Cart).Guest {
+		return nil, ErrNoRevision
+	}
+
+	return copyCarts(Carts{r.(*Cart)})[0], nil
+}
+
+// GetAllCartsAsOf is GetAllCarts reading the carts as they were at t.
+func GetAllCartsAsOf(t time.Time, limitRes, offset int, sortCriteria string) Carts {
+	defer observe("carts", "list_as_of", time.Now())
+
+	carts := Carts{}
+	for _, r := range recordsAt("carts", t) {
+		if c := r.(*Cart); !c.Guest {
+			carts = append(carts, copyCarts(Carts{c})[0])
+		}
+	}
+
+	sort.SliceStable(carts, func(i, j int) bool {
+		if sortCriteria == "desc" {
+			return carts[i].Date.After(carts[j].Date)
+		}
+		return carts[i].Date.Before(carts[j].Date)
+	})
+
+	start, end := pageBounds(len(carts), limitRes, offset)
+	return carts[start:end]
+}
+
+// pageBounds returns the bounds of the page of a list of n records
+// skipping offset records and limited to limit records, or to the
+// remaining records when limit is not positive.
+func pageBounds(n, limit, offset int) (int, int) {
+	if offset < 0 || offset > n {
+		offset = n
+	}
+
+	if limit <= 0 || limit >= n-offset {
+		limit = n - offset
+	}
+
+	return offset, offset + limit
+}
+
+// copyHistory copies the history for snapshots. The revisions are
+// shared, as they are never modified.
+func copyHistory(h map[string]map[uint64][]*revision) map[string]map[uint64][]*revision {
+	c := make(map[string]map[uint64][]*revision, len(h))
+	for resource, records := range h {
+		c[resource] = make(map[uint64][]*revision, len(records))
+		for id, revisions := range records {
+			// limit the capacity so appends do not share the array
+			c[resource][id] = revisions[:len(revisions):len(revisions)]
+		}
+	}
+
+	return c
+}
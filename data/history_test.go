@@ -0,0 +1,272 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// mark returns the current time, apart from the revisions made before
+// and after it.
+func mark() time.Time {
+	time.Sleep(time.Millisecond)
+	t := time.Now().UTC()
+	time.Sleep(time.Millisecond)
+	return t
+}
+
+// renameProduct replaces the name of the product p.
+func renameProduct(t *testing.T, p *Product, name string) {
+	t.Helper()
+
+	update := *p
+	update.Name = name
+	if err := UpdateProduct(context.Background(), &update); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// productIDs returns the IDs of ps found in ids, in the order of ids.
+func productIDs(ps Products, ids ...uint64) []uint64 {
+	found := []uint64{}
+	for _, id := range ids {
+		for _, p := range ps {
+			if p.ID == id {
+				found = append(found, id)
+			}
+		}
+	}
+
+	return found
+}
+
+// equalIDs reports whether a and b hold the same IDs in the same order.
+func equalIDs(a, b []uint64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func TestGetProductAsOf(t *testing.T) {
+	ctx := context.Background()
+
+	beforeCreation := mark()
+	p := newTestProduct(t, 0)
+	created := mark()
+	renameProduct(t, p, "updated")
+	updated := mark()
+	if _, err := RemoveProduct(ctx, p.ID); err != nil {
+		t.Fatal(err)
+	}
+	deleted := mark()
+	if _, err := RestoreProduct(ctx, p.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		at   time.Time
+		want string
+		err  error
+	}{
+		{"before its creation", beforeCreation, "", ErrNoRevision},
+		{"created", created, "test", nil},
+		{"updated", updated, "updated", nil},
+		{"deleted", deleted, "", ErrNoRevision},
+		{"restored", time.Now(), "updated", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := GetProductAsOf(p.ID, tt.at)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+			if err == nil && got.Name != tt.want {
+				t.Errorf("got product %q, want %q", got.Name, tt.want)
+			}
+		})
+	}
+
+	h, err := GetProductHistory(p.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{OpCreate, OpUpdate, OpDelete, OpRestore}
+	if len(h) != len(want) {
+		t.Fatalf("got %d revisions, want %d", len(h), len(want))
+	}
+	for i, r := range h {
+		if r.Version != i+1 || r.Operation != want[i] {
+			t.Errorf("got version %d %s, want %d %s", r.Version, r.Operation, i+1, want[i])
+		}
+		if (r.Operation == OpDelete) != (r.Product == nil) {
+			t.Errorf("got product %v for the revision %s", r.Product, r.Operation)
+		}
+	}
+}
+
+// TestMaxRevisions checks that the oldest revisions are dropped beyond
+// the maximum, and that the product cannot be read as it was then.
+func TestMaxRevisions(t *testing.T) {
+	previous := maxRevisions
+	if err := SetMaxRevisions(3); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { maxRevisions = previous })
+
+	p := newTestProduct(t, 0)
+	created := mark()
+	for _, name := range []string{"a", "b", "c"} {
+		renameProduct(t, p, name)
+	}
+
+	h, err := GetProductHistory(p.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(h) != 3 || h[0].Version != 2 || h[2].Version != 4 {
+		t.Fatalf("got %d revisions from version %d, want 3 from version 2", len(h), h[0].Version)
+	}
+
+	if _, err := GetProductAsOf(p.ID, created); !errors.Is(err, ErrRevisionDropped) {
+		t.Errorf("got error %v, want ErrRevisionDropped", err)
+	}
+	if got, err := GetProductAsOf(p.ID, time.Now()); err != nil || got.Name != "c" {
+		t.Errorf("got product %+v (%v), want the last revision", got, err)
+	}
+
+	if err := SetMaxRevisions(0); err == nil {
+		t.Error("set no revisions")
+	}
+}
+
+// TestHistoryRollback checks that the revisions made in a transaction
+// are dropped when it is rolled back, without altering those kept.
+func TestHistoryRollback(t *testing.T) {
+	p := newTestProduct(t, 0)
+
+	tx := Begin()
+	renameProduct(t, p, "rolled back")
+	if h, _ := GetProductHistory(p.ID); len(h) != 2 {
+		t.Fatalf("got %d revisions in the transaction, want 2", len(h))
+	}
+	tx.Rollback()
+
+	h, err := GetProductHistory(p.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(h) != 1 || h[0].Product.Name != "test" {
+		t.Fatalf("got %d revisions after the rollback, want the first one", len(h))
+	}
+
+	// the revisions after the rollback do not overwrite the snapshot
+	renameProduct(t, p, "updated")
+	h, _ = GetProductHistory(p.ID)
+	if len(h) != 2 || h[1].Version != 2 || h[1].Product.Name != "updated" {
+		t.Errorf("got revisions %+v, want version 2 updated", h)
+	}
+}
+
+func TestGetAllProductsAsOf(t *testing.T) {
+	ctx := context.Background()
+
+	first := newTestProduct(t, 0)
+	t1 := mark()
+	second := newTestProduct(t, 0)
+	renameProduct(t, first, "updated")
+	t2 := mark()
+	if _, err := RemoveProduct(ctx, first.ID); err != nil {
+		t.Fatal(err)
+	}
+	t3 := mark()
+
+	tests := []struct {
+		at   time.Time
+		want []uint64
+		name string // of the first product
+	}{
+		{t1, []uint64{first.ID}, "test"},
+		{t2, []uint64{first.ID, second.ID}, "updated"},
+		{t3, []uint64{second.ID}, ""},
+	}
+
+	for _, tt := range tests {
+		products := GetAllProductsAsOf(tt.at, 0, 0, "asc")
+		if got := productIDs(products, first.ID, second.ID); !equalIDs(got, tt.want) {
+			t.Errorf("got products %v at %s, want %v", got, tt.at, tt.want)
+		}
+		for _, p := range products {
+			if p.ID == first.ID && p.Name != tt.name {
+				t.Errorf("got product %q at %s, want %q", p.Name, tt.at, tt.name)
+			}
+		}
+	}
+
+	if got := GetAllProductsAsOf(t2, 1, 0, "asc"); len(got) != 1 {
+		t.Errorf("got %d products with a limit of 1", len(got))
+	}
+}
+
+func TestGetAllCartsAsOf(t *testing.T) {
+	ctx := context.Background()
+
+	p := newTestProduct(t, 0)
+	u := newTestUser(t)
+	cart := newTestCart(t, u.ID, false, Item{ProductID: p.ID, Quantity: 1})
+	guest := newTestCart(t, 0, true, Item{ProductID: p.ID, Quantity: 1})
+	t1 := mark()
+	update := *cart
+	update.Products = []Item{{ProductID: p.ID, Quantity: 3}}
+	if err := UpdateCart(ctx, &update); err != nil {
+		t.Fatal(err)
+	}
+	t2 := mark()
+	if _, err := RemoveCart(ctx, cart.ID); err != nil {
+		t.Fatal(err)
+	}
+	t3 := mark()
+
+	tests := []struct {
+		at       time.Time
+		quantity uint64 // of the item of the cart, none if zero
+	}{
+		{t1, 1},
+		{t2, 3},
+		{t3, 0},
+	}
+
+	for _, tt := range tests {
+		var found *Cart
+		for _, c := range GetAllCartsAsOf(tt.at, 0, 0, "asc") {
+			switch c.ID {
+			case guest.ID:
+				t.Errorf("got guest cart %d at %s", c.ID, tt.at)
+			case cart.ID:
+				found = c
+			}
+		}
+
+		switch {
+		case tt.quantity == 0 && found != nil:
+			t.Errorf("got deleted cart %d at %s", found.ID, tt.at)
+		case tt.quantity != 0 && found == nil:
+			t.Errorf("cart %d missing at %s", cart.ID, tt.at)
+		case found != nil && found.Products[0].Quantity != tt.quantity:
+			t.Errorf("got quantity %d at %s, want %d", found.Products[0].Quantity, tt.at, tt.quantity)
+		}
+	}
+
+	if _, err := GetCartAsOf(guest.ID, time.Now()); !errors.Is(err, ErrNoRevision) {
+		t.Errorf("got error %v reading a guest cart, want ErrNoRevision", err)
+	}
+}
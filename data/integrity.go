@@ -0,0 +1,326 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// DeletePolicy is what happens to the carts referencing a product or a
+// user when it is removed.
+type DeletePolicy string
+
+const (
+	// Restrict refuses to remove a product or a user referenced by a
+	// cart with ErrReferenced.
+	Restrict DeletePolicy = "restrict"
+
+	// Cascade removes the items of a removed product from the carts,
+	// and the carts of a removed user.
+	Cascade DeletePolicy = "cascade"
+
+	// Nullify keeps the items of a removed product in the carts, marked
+	// as unavailable. It does not apply to users, as carts always
+	// belong to one.
+	Nullify DeletePolicy = "nullify"
+)
+
+// ErrReferenced is returned when removing a product or a user still
+// referenced by a cart under the Restrict policy.
+var ErrReferenced = errors.New("referenced by existing carts")
+
+// OnDelete holds the policies applied when products and users are
+// removed.
+type OnDelete struct {
+	Products DeletePolicy `json:"products"`
+	Users    DeletePolicy `json:"users"`
+}
+
+// onDelete defaults to restrict, so carts are never left dangling.
+var onDelete = OnDelete{Products: Restrict, Users: Restrict}
+
+// SetOnDelete sets the policies applied when products and users are
+// removed. It is meant to be called once, before the stores are used.
+func SetOnDelete(p OnDelete) error {
+	switch p.Products {
+	case Restrict, Cascade, Nullify:
+	default:
+		return fmt.Errorf("invalid on-delete policy for products: %q", p.Products)
+	}
+
+	switch p.Users {
+	case Restrict, Cascade:
+	default:
+		return fmt.Errorf("invalid on-delete policy for users: %q", p.Users)
+	}
+
+	onDelete = p
+	return nil
+}
+
+// GetOnDelete returns the policies applied when products and users are
+// removed.
+func GetOnDelete() OnDelete {
+	return onDelete
+}
+
+// hasProduct reports whether the product id exists. It must be called
+// with productsRWMtx held.
+func hasProduct(id uint64) bool {
+	return findProduct(id) != nil
+}
+
+// findProduct returns the product id, or nil. It must be called with
+// productsRWMtx held.
+func findProduct(id uint64) *Product {
+	for _, p := range productList {
+		if p.ID == id {
+			return p
+		}
+	}
+
+	return nil
+}
+
+// hasUser reports whether the user id exists. It must be called with
+// userRWMutex held.
+func hasUser(id uint64) bool {
+	for _, u := range usersList {
+		if u.ID == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkUser returns a *ValidationError if the user id does not exist.
+// It must be called with userRWMutex held.
+func checkUser(id uint64) error {
+	if !hasUser(id) {
+		return &ValidationError{Field: "userId", Msg: fmt.Sprintf("user '%d' does not exist", id)}
+	}
+
+	return nil
+}
+
+// checkOwner is checkUser for the owner of c. Guest carts have none, so
+// their UserID is cleared instead.
+func checkOwner(c *Cart) error {
+	if c.Guest {
+		c.UserID = 0
+		return nil
+	}
+
+	return checkUser(c.UserID)
+}
+
+// checkItems returns the items with the lines of the same product
+// coalesced, or a *ValidationError if an item references a product that
+// does not exist or exceeds its maximum quantity. It clears the
+// unavailable flag of the items, which only the store sets. It must be
+// called with productsRWMtx held.
+func checkItems(items []Item) ([]Item, error) {
+	items, err := coalesceItems(items)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range items {
+		p := findProduct(items[i].ProductID)
+		if p == nil {
+			return nil, &ValidationError{
+				Field: "products",
+				Msg:   fmt.Sprintf("product '%d' does not exist", items[i].ProductID),
+			}
+		}
+		if err := checkQuantity(p, items[i].Quantity); err != nil {
+			return nil, err
+		}
+		items[i].Unavailable = false
+	}
+
+	return items, nil
+}
+
+// referencingCarts returns the IDs of the carts matching fn. It must be
+// called with cartsRWMtx held.
+func referencingCarts(fn func(c *Cart) bool) []uint64 {
+	ids := []uint64{}
+	for _, c := range cartList {
+		if fn(c) {
+			ids = append(ids, c.ID)
+		}
+	}
+
+	return ids
+}
+
+// cartHasProduct reports whether c has an available item of product id.
+func cartHasProduct(c *Cart, id uint64) bool {
+	for _, item := range c.Products {
+		if item.ProductID == id && !item.Unavailable {
+			return true
+		}
+	}
+
+	return false
+}
+
+// releaseProduct applies the products on-delete policy to the carts
+// referencing the product id, or returns ErrReferenced. It must be
+// called with productsRWMtx held.
+func releaseProduct(ctx context.Context, id uint64) error {
+	cartsRWMtx.Lock()
+	defer cartsRWMtx.Unlock()
+
+	references := func(c *Cart) bool { return cartHasProduct(c, id) }
+
+	switch onDelete.Products {
+	case Cascade, Nullify:
+		for _, c := range cartList {
+			if !references(c) {
+				continue
+			}
+
+			previous := copyCarts(Carts{c})[0]
+			items := make([]Item, 0, len(c.Products))
+			for _, item := range c.Products {
+				if item.ProductID == id {
+					if onDelete.Products == Cascade {
+						continue
+					}
+					item.Unavailable = true
+				}
+				items = append(items, item)
+			}
+			c.Products = items
+
+			publishCart(ctx, CartUpdated, c, previous)
+			recordCart(ctx, OpUpdate, c, previous)
+		}
+
+		return nil
+
+	default:
+		if ids := referencingCarts(references); len(ids) > 0 {
+			return fmt.Errorf("product '%d' is %w %v", id, ErrReferenced, ids)
+		}
+
+		return nil
+	}
+}
+
+// releaseUser applies the users on-delete policy to the carts of the
+// user id, moving them to the trash, or returns ErrReferenced. It must
+// be called with userRWMutex held.
+func releaseUser(ctx context.Context, id uint64) error {
+	cartsRWMtx.Lock()
+	defer cartsRWMtx.Unlock()
+
+	ownedBy := func(c *Cart) bool { return !c.Guest && c.UserID == id }
+
+	if onDelete.Users != Cascade {
+		if ids := referencingCarts(ownedBy); len(ids) > 0 {
+			return fmt.Errorf("user '%d' is %w %v", id, ErrReferenced, ids)
+		}
+
+		return nil
+	}
+
+	carts := make(Carts, 0, len(cartList))
+	for _, c := range cartList {
+		if ownedBy(c) {
+			moveToTrash(ctx, "carts", c.ID, c)
+			publishCart(ctx, CartDeleted, c, nil)
+			recordCart(ctx, OpDelete, nil, c)
+			continue
+		}
+		carts = append(carts, c)
+	}
+	cartList = carts
+
+	return nil
+}
+
+// reconcileItems applies the products on-delete policy to the items of
+// the products deleted while their cart was in the trash, and makes the
+// items of the products restored since available again. It must be
+// called with productsRWMtx held.
+func reconcileItems(items []Item) ([]Item, error) {
+	reconciled := make([]Item, 0, len(items))
+	for _, item := range items {
+		switch {
+		case hasProduct(item.ProductID):
+			item.Unavailable = false
+		case item.Unavailable:
+		case onDelete.Products == Cascade:
+			continue
+		case onDelete.Products == Nullify:
+			item.Unavailable = true
+		default:
+			return nil, &ValidationError{
+				Field: "products",
+				Msg:   fmt.Sprintf("product '%d' does not exist", item.ProductID),
+			}
+		}
+
+		reconciled = append(reconciled, item)
+	}
+
+	return reconciled, nil
+}
+
+// Orphan is a reference from a cart to a user or a product that does
+// not exist.
+type Orphan struct {
+	CartID   uint64 `json:"cart_id"`
+	Resource string `json:"resource"` // users or products
+	ID       uint64 `json:"id"`
+}
+
+// IntegrityReport is the result of CheckIntegrity.
+type IntegrityReport struct {
+	CheckedAt    time.Time `json:"checked_at"`
+	CheckedCarts int       `json:"checked_carts"`
+	OnDelete     OnDelete  `json:"on_delete"`
+	Orphans      []Orphan  `json:"orphans"`
+}
+
+// CheckIntegrity finds the carts referencing users or products that do
+// not exist, e.g. left by snapshots or imports. Items marked unavailable
+// by the Nullify policy are not reported.
+func CheckIntegrity() *IntegrityReport {
+	defer observe("all", "check_integrity", time.Now())
+
+	productsRWMtx.RLock()
+	defer productsRWMtx.RUnlock()
+	userRWMutex.RLock()
+	defer userRWMutex.RUnlock()
+	cartsRWMtx.RLock()
+	defer cartsRWMtx.RUnlock()
+
+	report := &IntegrityReport{
+		CheckedAt:    time.Now().UTC(),
+		CheckedCarts: len(cartList),
+		OnDelete:     onDelete,
+		Orphans:      []Orphan{},
+	}
+
+	for _, c := range cartList {
+		if !c.Guest && !hasUser(c.UserID) {
+			report.Orphans = append(report.Orphans,
+				Orphan{CartID: c.ID, Resource: "users", ID: c.UserID})
+		}
+
+		for _, item := range c.Products {
+			if !item.Unavailable && !hasProduct(item.ProductID) {
+				report.Orphans = append(report.Orphans,
+					Orphan{CartID: c.ID, Resource: "products", ID: item.ProductID})
+			}
+		}
+	}
+
+	return report
+}
@@ -0,0 +1,226 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// the adjustments of the quantity of a cart item
+const (
+	ItemIncrement = "increment"
+	ItemDecrement = "decrement"
+	ItemSet       = "set"
+)
+
+// ItemAdjustment is a change of the quantity of a cart item. Op is
+// ItemIncrement, ItemDecrement or ItemSet, the default.
+type ItemAdjustment struct {
+	Op       string `json:"op"`
+	Quantity uint64 `json:"quantity"`
+}
+
+// ErrItemNotFound is returned when changing an item of a product that is
+// not in the cart.
+var ErrItemNotFound = errors.New("requested item is not in the cart")
+
+// coalesceItems merges the items of the same product into the first of
+// them, adding up their quantities. An item stays unavailable only if
+// all the items of its product are. It fails with a *ValidationError if
+// a quantity overflows.
+func coalesceItems(items []Item) ([]Item, error) {
+	coalesced := make([]Item, 0, len(items))
+	index := make(map[uint64]int, len(items))
+
+	for _, item := range items {
+		i, ok := index[item.ProductID]
+		if !ok {
+			index[item.ProductID] = len(coalesced)
+			coalesced = append(coalesced, item)
+			continue
+		}
+
+		q, err := addQuantity(coalesced[i].Quantity, item.Quantity)
+		if err != nil {
+			return nil, err
+		}
+		coalesced[i].Quantity = q
+		coalesced[i].Unavailable = coalesced[i].Unavailable && item.Unavailable
+	}
+
+	return coalesced, nil
+}
+
+// addQuantity returns a+b, or a *ValidationError if it overflows.
+func addQuantity(a, b uint64) (uint64, error) {
+	if b > math.MaxUint64-a {
+		return 0, &ValidationError{Field: "quantity", Msg: "the quantity of the item would overflow"}
+	}
+
+	return a + b, nil
+}
+
+// checkQuantity returns a *ValidationError if quantity exceeds the
+// maximum quantity of p in a cart.
+func checkQuantity(p *Product, quantity uint64) error {
+	if p.MaxQuantity > 0 && quantity > p.MaxQuantity {
+		return &ValidationError{
+			Field: "quantity",
+			Msg:   fmt.Sprintf("at most %d of product '%d' can be in a cart", p.MaxQuantity, p.ID),
+		}
+	}
+
+	return nil
+}
+
+// AdjustItem atomically changes the quantity of the product productID in
+// the cart id: op increments or decrements it by quantity, or sets it to
+// quantity. The item is added if needed, and removed when its quantity
+// drops to zero. It fails with a *ValidationError if the product does not
+// exist or the quantity exceeds its maximum, and with ErrItemNotFound when
+// decrementing or zeroing an item not in the cart.
+func AdjustItem(ctx context.Context, id, productID uint64, op string, quantity uint64) (*Cart, error) {
+	defer observe("carts", "adjust_item", time.Now())
+
+	switch {
+	case op != ItemIncrement && op != ItemDecrement && op != ItemSet:
+		return nil, &ValidationError{Field: "op", Msg: fmt.Sprintf("must be one of %s, %s or %s", ItemIncrement, ItemDecrement, ItemSet)}
+	case op != ItemSet && quantity == 0:
+		return nil, &ValidationError{Field: "quantity", Msg: "must be at least 1"}
+	}
+
+	productsRWMtx.RLock()
+	defer productsRWMtx.RUnlock()
+	cartsRWMtx.Lock()
+	defer cartsRWMtx.Unlock()
+
+	cart := findCart(id)
+	if cart == nil {
+		return nil, fmt.Errorf("requested cart does not exist")
+	}
+
+	items, err := coalesceItems(cart.Products)
+	if err != nil {
+		return nil, err
+	}
+	i := itemIndex(items, productID)
+
+	var current uint64
+	if i >= 0 {
+		current = items[i].Quantity
+	}
+
+	var next uint64
+	switch op {
+	case ItemIncrement:
+		var err error
+		if next, err = addQuantity(current, quantity); err != nil {
+			return nil, err
+		}
+	case ItemDecrement:
+		if i < 0 {
+			return nil, ErrItemNotFound
+		}
+		if quantity < current {
+			next = current - quantity
+		}
+	case ItemSet:
+		if i < 0 && quantity == 0 {
+			return nil, ErrItemNotFound
+		}
+		next = quantity
+	}
+
+	// only removing items of deleted products is allowed
+	if next > 0 {
+		p := findProduct(productID)
+		if p == nil {
+			return nil, &ValidationError{
+				Field: "product_id",
+				Msg:   fmt.Sprintf("product '%d' does not exist", productID),
+			}
+		}
+
+		// decrements are allowed above a maximum lowered since
+		if op != ItemDecrement {
+			if err := checkQuantity(p, next); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	switch {
+	case next == 0:
+		items = append(items[:i], items[i+1:]...)
+	case i < 0:
+		items = append(items, Item{ProductID: productID, Quantity: next})
+	default:
+		items[i].Quantity = next
+	}
+
+	return updateItems(ctx, cart, items), nil
+}
+
+// RemoveItem removes the product productID from the cart id. It fails
+// with ErrItemNotFound if the product is not in the cart.
+func RemoveItem(ctx context.Context, id, productID uint64) (*Cart, error) {
+	defer observe("carts", "remove_item", time.Now())
+
+	cartsRWMtx.Lock()
+	defer cartsRWMtx.Unlock()
+
+	cart := findCart(id)
+	if cart == nil {
+		return nil, fmt.Errorf("requested cart does not exist")
+	}
+
+	items, err := coalesceItems(cart.Products)
+	if err != nil {
+		return nil, err
+	}
+	i := itemIndex(items, productID)
+	if i < 0 {
+		return nil, ErrItemNotFound
+	}
+
+	return updateItems(ctx, cart, append(items[:i], items[i+1:]...)), nil
+}
+
+// updateItems sets the items of cart, and returns a copy of it. It must
+// be called with cartsRWMtx held.
+func updateItems(ctx context.Context, cart *Cart, items []Item) *Cart {
+	previous := copyCarts(Carts{cart})[0]
+	cart.Products = items
+	touch(cart)
+
+	publishCart(ctx, CartUpdated, cart, previous)
+	recordCart(ctx, OpPatch, cart, previous)
+
+	return copyCarts(Carts{cart})[0]
+}
+
+// findCart returns the cart id, or nil. Guest carts are left out, see
+// cartExists. It must be called with cartsRWMtx held.
+func findCart(id uint64) *Cart {
+	for _, c := range cartList {
+		if c.ID == id && !c.Guest {
+			return c
+		}
+	}
+
+	return nil
+}
+
+// itemIndex returns the index of the item of the product productID, or
+// -1.
+func itemIndex(items []Item, productID uint64) int {
+	for i, item := range items {
+		if item.ProductID == productID {
+			return i
+		}
+	}
+
+	return -1
+}
@@ -0,0 +1,58 @@
+package data
+
+import (
+	"time"
+
+	"github.com/imariom/products-api/metrics"
+)
+
+var (
+	storeDuration = metrics.NewHistogramVec("store_operation_duration_seconds",
+		"Latency of data store operations by store and operation.",
+		nil, "store", "operation")
+
+	productsCreated = metrics.NewCounter("store_products_created_total",
+		"Number of products created.")
+
+	cartsCreated = metrics.NewCounter("store_carts_created_total",
+		"Number of carts created.")
+
+	usersCreated = metrics.NewCounter("store_users_created_total",
+		"Number of users created.")
+
+	trashPurged = metrics.NewCounter("store_trash_purged_total",
+		"Number of deleted records purged from the trash.")
+
+	cartsAbandoned = metrics.NewCounter("store_carts_abandoned_total",
+		"Number of carts flagged as abandoned.")
+
+	guestCartsMerged = metrics.NewCounter("store_guest_carts_merged_total",
+		"Number of guest carts given to users when they logged in.")
+
+	guestCartsExpired = metrics.NewCounter("store_guest_carts_expired_total",
+		"Number of guest carts dropped after being unused for too long.")
+)
+
+func init() {
+	metrics.NewGaugeFunc("store_products", "Number of products in the data store.",
+		func() float64 { return float64(GetStats().Products) })
+
+	metrics.NewGaugeFunc("store_carts", "Number of carts in the data store.",
+		func() float64 { return float64(GetStats().Carts) })
+
+	metrics.NewGaugeFunc("store_users", "Number of users in the data store.",
+		func() float64 { return float64(GetStats().Users) })
+
+	metrics.NewGaugeFunc("store_trash", "Number of deleted records in the trash.",
+		func() float64 {
+			trashMtx.Lock()
+			defer trashMtx.Unlock()
+			return float64(len(trash))
+		})
+}
+
+// observe records the latency of a store operation started at start.
+// It is meant to be deferred at the top of each operation.
+func observe(store, operation string, start time.Time) {
+	storeDuration.WithLabelValues(store, operation).Observe(time.Since(start).Seconds())
+}
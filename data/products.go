@@ -1,13 +1,20 @@
 package data
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"sort"
 	"sync"
+	"time"
 )
 
+// ErrDuplicateSKU is returned when a product is stored with the SKU of
+// another product.
+var ErrDuplicateSKU = errors.New("a product with this SKU already exists")
+
 // to protect read and write operations on the productList data store
 var productsRWMtx = &sync.RWMutex{}
 
@@ -23,17 +30,27 @@ var productList = Products{
 	},
 }
 
+// productsBySKU indexes the products of productList by SKU, so imports
+// upserting every row by SKU do not scan the whole store for each one.
+// Products without a SKU are not indexed.
+var productsBySKU = indexProducts(productList)
+
 // store next product id. Start with 1 because the API assumes
 // and initial product in the data store.
 var nextProductId uint64 = 1
 
 type Product struct {
 	ID          uint64  `json:"id"`
-	Name        string  `json:"name"`
-	Description string  `json:"description"`
-	Category    string  `json:"category"`
-	Image       string  `json:"image"`
-	Price       float64 `json:"price"`
+	SKU         string  `json:"sku" validate:"maxlen=64"`
+	Name        string  `json:"name" validate:"required,maxlen=200"`
+	Description string  `json:"description" validate:"maxlen=2000"`
+	Category    string  `json:"category" validate:"required,maxlen=50"`
+	Image       string  `json:"image" validate:"maxlen=2048"`
+	Price       float64 `json:"price" validate:"min=0"`
+
+	// MaxQuantity is the maximum quantity of the product in a cart, or
+	// zero for no maximum.
+	MaxQuantity uint64 `json:"max_quantity,omitempty"`
 }
 
 // Products represent the type of the In-Memory Data Store
@@ -61,6 +78,42 @@ func getNextProductId() uint64 {
 	return tempID
 }
 
+// indexProducts returns the products of ps with a SKU, by SKU.
+func indexProducts(ps Products) map[string]*Product {
+	index := make(map[string]*Product, len(ps))
+	for _, p := range ps {
+		if p.SKU != "" {
+			index[p.SKU] = p
+		}
+	}
+
+	return index
+}
+
+// indexProduct replaces the SKU previousSKU, which may be empty, with
+// p in the SKU index. SKUs are unique, so previousSKU can only be that
+// of the product p replaces. It must be called with productsRWMtx held
+// for writing.
+func indexProduct(p *Product, previousSKU string) {
+	if previousSKU != "" {
+		delete(productsBySKU, previousSKU)
+	}
+	if p != nil && p.SKU != "" {
+		productsBySKU[p.SKU] = p
+	}
+}
+
+// skuTaken reports whether a product other than id has the SKU sku.
+// It must be called with productsRWMtx held.
+func skuTaken(sku string, id uint64) bool {
+	if sku == "" {
+		return false
+	}
+
+	p, ok := productsBySKU[sku]
+	return ok && p.ID != id
+}
+
 func productExists(id uint64) (int, bool) {
 	productsRWMtx.RLock()
 	defer productsRWMtx.RUnlock()
@@ -75,8 +128,10 @@ func productExists(id uint64) (int, bool) {
 }
 
 // GetAllProducts retrieve a slice of all products that
-// exist on the data store.
-func GetAllProducts(limitRes int, sortCriteria string) Products {
+// exist on the data store, skipping the first offset products.
+func GetAllProducts(limitRes, offset int, sortCriteria string) Products {
+	defer observe("products", "list", time.Now())
+
 	// sort products
 	if sortCriteria == "asc" {
 		// sort products in ascending order of price
@@ -86,16 +141,20 @@ func GetAllProducts(limitRes int, sortCriteria string) Products {
 		sort.Sort(sort.Reverse(&productList))
 	}
 
-	// limit number of products to return
-	if limitRes <= 0 || limitRes >= productList.Len() {
-		limitRes = productList.Len()
-	}
-
 	productsRWMtx.RLock()
 	defer productsRWMtx.RUnlock()
 
+	// skip offset products and limit number of products to return
+	if offset < 0 || offset > len(productList) {
+		offset = len(productList)
+	}
+
+	if limitRes <= 0 || limitRes >= len(productList)-offset {
+		limitRes = len(productList) - offset
+	}
+
 	tmpProducts := make(Products, 0, limitRes)
-	for i := 0; i != limitRes; i++ {
+	for i := offset; i != offset+limitRes; i++ {
 		tmpProducts = append(tmpProducts, productList[i])
 	}
 
@@ -104,6 +163,8 @@ func GetAllProducts(limitRes int, sortCriteria string) Products {
 
 // GetProduct get and retrieve a product from the data store.
 func GetProduct(prodId uint64) (*Product, error) {
+	defer observe("products", "get", time.Now())
+
 	product := &Product{}
 
 	productsRWMtx.RLock()
@@ -121,6 +182,31 @@ func GetProduct(prodId uint64) (*Product, error) {
 	return nil, fmt.Errorf("product not found")
 }
 
+// GetProductsByID get copies of the products with the given IDs in a
+// single pass over the data store. IDs with no product are left out.
+func GetProductsByID(ids []uint64) map[uint64]*Product {
+	defer observe("products", "get_many", time.Now())
+
+	wanted := make(map[uint64]bool, len(ids))
+	for _, id := range ids {
+		wanted[id] = true
+	}
+
+	products := make(map[uint64]*Product, len(ids))
+
+	productsRWMtx.RLock()
+	defer productsRWMtx.RUnlock()
+
+	for _, prod := range productList {
+		if wanted[prod.ID] {
+			product := *prod
+			products[prod.ID] = &product
+		}
+	}
+
+	return products
+}
+
 // GetAllCategories return all the categories that exist on the data
 // store in the form of an object.
 // This object contains the name of the category and its count (the
@@ -128,6 +214,8 @@ func GetProduct(prodId uint64) (*Product, error) {
 // The object will contain a key-value pair in the form
 // { "category0": count, "category1": count, ... }
 func GetAllCategories() Categories {
+	defer observe("products", "categories", time.Now())
+
 	categories := make(Categories, 0)
 
 	// prevent concurrent access
@@ -144,6 +232,8 @@ func GetAllCategories() Categories {
 // GetProductsByCategory retrieve all products on a specific
 // category in the data store.
 func GetProductsByCategory(category string) Products {
+	defer observe("products", "list_by_category", time.Now())
+
 	products := Products{}
 
 	productsRWMtx.RLock()
@@ -158,23 +248,44 @@ func GetProductsByCategory(category string) Products {
 	return products
 }
 
-func AddNewProduct(p *Product) {
-	p.ID = getNextProductId()
+func AddNewProduct(ctx context.Context, p *Product) error {
+	defer observe("products", "create", time.Now())
 
 	productsRWMtx.Lock()
+	defer productsRWMtx.Unlock()
+
+	if skuTaken(p.SKU, 0) {
+		return ErrDuplicateSKU
+	}
+
+	p.ID = getNextProductId()
 	productList = append(productList, p)
-	productsRWMtx.Unlock()
+	indexProduct(p, "")
+
+	productsCreated.Inc()
+	publishProduct(ctx, ProductCreated, p, nil)
+	recordProduct(ctx, OpCreate, p, nil)
+	return nil
 }
 
-func UpdateProduct(prod *Product) error {
+func UpdateProduct(ctx context.Context, prod *Product) error {
+	defer observe("products", "update", time.Now())
+
 	productsRWMtx.Lock()
 	defer productsRWMtx.Unlock()
 
+	if skuTaken(prod.SKU, prod.ID) {
+		return ErrDuplicateSKU
+	}
+
 	// TODO: optimize the productList data structure for
 	// reading and writing (e.g, map data structure)
 	for i, p := range productList {
 		if p.ID == prod.ID {
 			productList[i] = prod
+			indexProduct(prod, p.SKU)
+			publishProduct(ctx, ProductUpdated, prod, p)
+			recordProduct(ctx, OpUpdate, prod, p)
 			return nil
 		}
 	}
@@ -182,12 +293,25 @@ func UpdateProduct(prod *Product) error {
 	return fmt.Errorf("product not found")
 }
 
-func SetProduct(prod *Product) error {
+func SetProduct(ctx context.Context, prod *Product) error {
+	defer observe("products", "patch", time.Now())
+
 	productsRWMtx.Lock()
 	defer productsRWMtx.Unlock()
 
+	if skuTaken(prod.SKU, prod.ID) {
+		return ErrDuplicateSKU
+	}
+
 	for i, p := range productList {
 		if p.ID == prod.ID {
+			previous := *p
+
+			if prod.SKU != "" {
+				productList[i].SKU = prod.SKU
+				indexProduct(productList[i], previous.SKU)
+			}
+
 			if prod.Name != "" {
 				productList[i].Name = prod.Name
 			}
@@ -208,9 +332,15 @@ func SetProduct(prod *Product) error {
 				productList[i].Image = prod.Image
 			}
 
+			if prod.MaxQuantity != 0 {
+				productList[i].MaxQuantity = prod.MaxQuantity
+			}
+
 			// set temporary product equal to original product
 			*prod = *productList[i]
 
+			publishProduct(ctx, ProductUpdated, prod, &previous)
+			recordProduct(ctx, OpPatch, prod, &previous)
 			return nil
 		}
 	}
@@ -218,17 +348,121 @@ func SetProduct(prod *Product) error {
 	return fmt.Errorf("product not found")
 }
 
-func RemoveProduct(id uint64) (*Product, error) {
-	// checks wheter product exists
-	index, exists := productExists(uint64(id))
-	if !exists {
-		return nil, fmt.Errorf("product not found")
+// GetProductBySKU get and retrieve the product with the given SKU.
+func GetProductBySKU(sku string) (*Product, error) {
+	defer observe("products", "get_by_sku", time.Now())
+
+	productsRWMtx.RLock()
+	defer productsRWMtx.RUnlock()
+
+	if prod, ok := productsBySKU[sku]; ok && sku != "" {
+		product := *prod
+		return &product, nil
+	}
+
+	return nil, fmt.Errorf("product not found")
+}
+
+// UpsertProduct replaces the product with the SKU of p, keeping its ID,
+// or adds p as a new product if there is none. It reports whether the
+// product was created.
+func UpsertProduct(ctx context.Context, p *Product) (bool, error) {
+	defer observe("products", "upsert", time.Now())
+
+	if p.SKU == "" {
+		return false, fmt.Errorf("product SKU is required")
+	}
+
+	productsRWMtx.Lock()
+	defer productsRWMtx.Unlock()
+
+	// the product is replaced in place, as finding its position in the
+	// store would take a scan
+	if prod, ok := productsBySKU[p.SKU]; ok {
+		previous := *prod
+		p.ID = prod.ID
+		*prod = *p
+		publishProduct(ctx, ProductUpdated, p, &previous)
+		recordProduct(ctx, OpUpdate, p, &previous)
+		return false, nil
+	}
+
+	p.ID = getNextProductId()
+	productList = append(productList, p)
+	indexProduct(p, "")
+
+	productsCreated.Inc()
+	publishProduct(ctx, ProductCreated, p, nil)
+	recordProduct(ctx, OpCreate, p, nil)
+	return true, nil
+}
+
+// ExportProducts calls fn with copies of all the products in batches
+// of at most size products, so the whole catalog does not need to be
+// copied at once. The products exported are those in the store when the
+// export starts, so products added, removed or sorted meanwhile neither
+// shift nor repeat the batches.
+func ExportProducts(size int, fn func(Products) error) error {
+	defer observe("products", "export", time.Now())
+
+	productsRWMtx.RLock()
+	products := append(Products{}, productList...)
+	productsRWMtx.RUnlock()
+
+	for offset := 0; ; offset += size {
+		end := offset + size
+		if end > len(products) {
+			end = len(products)
+		}
+
+		// the products themselves may be patched meanwhile
+		var batch Products
+		productsRWMtx.RLock()
+		for i := offset; i < end; i++ {
+			p := *products[i]
+			batch = append(batch, &p)
+		}
+		productsRWMtx.RUnlock()
+
+		if len(batch) == 0 {
+			return nil
+		}
+
+		if err := fn(batch); err != nil {
+			return err
+		}
 	}
+}
+
+// RemoveProduct moves the product id to the trash, recording who
+// deleted it, and applies the products on-delete policy to the carts
+// referencing it.
+func RemoveProduct(ctx context.Context, id uint64) (*Product, error) {
+	defer observe("products", "delete", time.Now())
 
 	deletedProduct := &Product{}
 
 	// remove product from datastore
-	productsRWMtx.RLock()
+	productsRWMtx.Lock()
+
+	// find the product under the write lock, as it may be removed or the
+	// products sorted meanwhile
+	index := -1
+	for i, p := range productList {
+		if p.ID == id {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		productsRWMtx.Unlock()
+		return nil, fmt.Errorf("product not found")
+	}
+
+	if err := releaseProduct(ctx, id); err != nil {
+		productsRWMtx.Unlock()
+		return nil, err
+	}
 
 	*deletedProduct = *productList[index]
 	tmpList := make(Products, 0, len(productList)-1)
@@ -240,9 +474,14 @@ func RemoveProduct(id uint64) (*Product, error) {
 
 		tmpList = append(tmpList, p)
 	}
+	indexProduct(nil, productList[index].SKU)
 	productList = tmpList
+	moveToTrash(ctx, "products", id, copyProducts(Products{deletedProduct})[0])
+	recordProduct(ctx, OpDelete, nil, deletedProduct)
 
-	productsRWMtx.RUnlock()
+	productsRWMtx.Unlock()
+
+	publishProduct(ctx, ProductDeleted, deletedProduct, nil)
 
 	return deletedProduct, nil
 }
@@ -0,0 +1,109 @@
+package data
+
+import "time"
+
+// Snapshot is a copy of the data stores, taken to roll back the changes
+// made after it.
+type Snapshot struct {
+	products      Products
+	nextProductID uint64
+	carts         Carts
+	users         Users
+	trash         []*Trashed
+	history       map[string]map[uint64][]*revision
+}
+
+// TakeSnapshot copies every data store.
+func TakeSnapshot() *Snapshot {
+	defer observe("all", "snapshot", time.Now())
+
+	s := &Snapshot{}
+
+	productsRWMtx.RLock()
+	s.products = copyProducts(productList)
+	s.nextProductID = nextProductId
+	productsRWMtx.RUnlock()
+
+	cartsRWMtx.RLock()
+	s.carts = copyCarts(cartList)
+	cartsRWMtx.RUnlock()
+
+	userRWMutex.RLock()
+	s.users = copyUsers(usersList)
+	userRWMutex.RUnlock()
+
+	trashMtx.Lock()
+	s.trash = copyTrash(trash)
+	trashMtx.Unlock()
+
+	historyMtx.Lock()
+	s.history = copyHistory(history)
+	historyMtx.Unlock()
+
+	return s
+}
+
+// Restore sets every data store back to its state in the snapshot. The
+// snapshot can be restored more than once.
+func (s *Snapshot) Restore() {
+	defer observe("all", "restore", time.Now())
+
+	productsRWMtx.Lock()
+	productList = copyProducts(s.products)
+	productsBySKU = indexProducts(productList)
+	nextProductId = s.nextProductID
+	productsRWMtx.Unlock()
+
+	cartsRWMtx.Lock()
+	cartList = copyCarts(s.carts)
+	cartsRWMtx.Unlock()
+
+	userRWMutex.Lock()
+	usersList = copyUsers(s.users)
+	userRWMutex.Unlock()
+
+	trashMtx.Lock()
+	trash = copyTrash(s.trash)
+	trashMtx.Unlock()
+
+	historyMtx.Lock()
+	history = copyHistory(s.history)
+	historyMtx.Unlock()
+}
+
+func copyProducts(ps Products) Products {
+	c := make(Products, 0, len(ps))
+	for _, p := range ps {
+		tmp := *p
+		c = append(c, &tmp)
+	}
+
+	return c
+}
+
+func copyCarts(cs Carts) Carts {
+	c := make(Carts, 0, len(cs))
+	for _, cart := range cs {
+		tmp := *cart
+		if cart.Products != nil {
+			tmp.Products = append(make([]Item, 0, len(cart.Products)), cart.Products...)
+		}
+		c = append(c, &tmp)
+	}
+
+	return c
+}
+
+func copyUsers(us Users) Users {
+	c := make(Users, 0, len(us))
+	for _, u := range us {
+		tmp := *u
+		if u.Address != nil {
+			addr := *u.Address
+			tmp.Address = &addr
+		}
+		c = append(c, &tmp)
+	}
+
+	return c
+}
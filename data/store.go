@@ -0,0 +1,67 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// StoreName is the name of the storage backend used by the data
+// package, reported by status endpoints.
+const StoreName = "memory"
+
+// Stats holds the number of records in each data store.
+type Stats struct {
+	Products int `json:"products"`
+	Carts    int `json:"carts"`
+	Users    int `json:"users"`
+}
+
+// GetStats returns the number of records in each data store.
+func GetStats() Stats {
+	stats := Stats{}
+
+	productsRWMtx.RLock()
+	stats.Products = len(productList)
+	productsRWMtx.RUnlock()
+
+	cartsRWMtx.RLock()
+	stats.Carts = len(cartList)
+	cartsRWMtx.RUnlock()
+
+	userRWMutex.RLock()
+	stats.Users = len(usersList)
+	userRWMutex.RUnlock()
+
+	return stats
+}
+
+// Ping checks that every data store can be read before ctx expires,
+// which catches stores stuck behind a lock that was never released.
+func Ping(ctx context.Context) error {
+	stores := []struct {
+		name string
+		mtx  *sync.RWMutex
+	}{
+		{"products", productsRWMtx},
+		{"carts", cartsRWMtx},
+		{"users", userRWMutex},
+	}
+
+	for _, s := range stores {
+		acquired := make(chan struct{})
+		go func(mtx *sync.RWMutex) {
+			mtx.RLock()
+			mtx.RUnlock()
+			close(acquired)
+		}(s.mtx)
+
+		select {
+		case <-acquired:
+		case <-ctx.Done():
+			return fmt.Errorf("%s store is not responding: %v", s.name, ctx.Err())
+		}
+	}
+
+	return nil
+}
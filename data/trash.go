@@ -0,0 +1,289 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrNotInTrash is returned when restoring a record that is not in the
+// trash, either because it was never deleted or it was purged.
+var ErrNotInTrash = errors.New("requested record is not in the trash")
+
+// ErrIDTaken is returned when restoring a record whose ID is used by a
+// record of the store. The IDs of the records in the trash are not
+// given to new records, so it is only returned if the stores were
+// changed otherwise.
+var ErrIDTaken = errors.New("the ID of the record is used by another record")
+
+// Trashed is a record deleted from a data store. It is kept in the
+// trash, hidden from the reads of the store, until it is restored or
+// purged.
+type Trashed struct {
+	Resource  string      `json:"resource"` // products, carts or users
+	ID        uint64      `json:"id"`
+	DeletedAt time.Time   `json:"deleted_at"`
+	DeletedBy string      `json:"deleted_by"`
+	Record    interface{} `json:"record"`
+}
+
+// this mutex is used to control access to the trash. It is always
+// acquired after the locks of the data stores.
+var trashMtx = &sync.Mutex{}
+
+// the records in the trash, in the order they were deleted. The records
+// are never modified while in the trash.
+var trash = []*Trashed{}
+
+// moveToTrash keeps record in the trash, with who deleted it.
+func moveToTrash(ctx context.Context, resource string, id uint64, record interface{}) {
+	trashMtx.Lock()
+	defer trashMtx.Unlock()
+
+	trash = append(trash, &Trashed{
+		Resource:  resource,
+		ID:        id,
+		DeletedAt: time.Now().UTC(),
+		DeletedBy: actorOf(ctx),
+		Record:    record,
+	})
+}
+
+// trashIndex returns the index of the record id of resource in the
+// trash, or -1. It must be called with trashMtx held.
+func trashIndex(resource string, id uint64) int {
+	for i, t := range trash {
+		if t.Resource == resource && t.ID == id {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// removeFromTrash drops the record at index i of the trash. It must be
+// called with trashMtx held.
+func removeFromTrash(i int) {
+	tmp := make([]*Trashed, 0, len(trash)-1)
+	tmp = append(tmp, trash[:i]...)
+	trash = append(tmp, trash[i+1:]...)
+}
+
+// maxTrashedID returns the highest ID of the records of resource in the
+// trash, so the IDs are not given to new records while they can still
+// be restored.
+func maxTrashedID(resource string) (uint64, bool) {
+	trashMtx.Lock()
+	defer trashMtx.Unlock()
+
+	max, found := uint64(0), false
+	for _, t := range trash {
+		if t.Resource == resource && (!found || t.ID > max) {
+			max, found = t.ID, true
+		}
+	}
+
+	return max, found
+}
+
+// GetTrash returns the records in the trash, the most recently deleted
+// first, of the given resource or of every resource when empty. The
+// passwords of the users are not returned.
+func GetTrash(resource string) []*Trashed {
+	defer observe("trash", "list", time.Now())
+
+	trashMtx.Lock()
+	defer trashMtx.Unlock()
+
+	records := make([]*Trashed, 0, len(trash))
+	for i := len(trash) - 1; i >= 0; i-- {
+		if resource != "" && trash[i].Resource != resource {
+			continue
+		}
+
+		t := *trash[i]
+		if u, ok := t.Record.(*User); ok {
+			t.Record = userWithoutPassword(u)
+		}
+		records = append(records, &t)
+	}
+
+	return records
+}
+
+// PurgeTrash permanently drops the records deleted before t, and
+// returns their number. It is run by a background job, so it holds off
+// transactions itself.
+func PurgeTrash(ctx context.Context, t time.Time) int {
+	defer observe("trash", "purge", time.Now())
+	defer HoldWrites()()
+
+	trashMtx.Lock()
+	defer trashMtx.Unlock()
+
+	kept := make([]*Trashed, 0, len(trash))
+	for _, r := range trash {
+		if r.DeletedAt.Before(t) {
+			record(ctx, r.Resource, OpPurge, r.ID, r.Record, nil)
+			dropHistory(r.Resource, r.ID)
+			continue
+		}
+		kept = append(kept, r)
+	}
+
+	purged := len(trash) - len(kept)
+	trash = kept
+	trashPurged.Add(float64(purged))
+
+	return purged
+}
+
+// RestoreProduct moves the product id back from the trash. The items
+// marked unavailable when it was deleted are available again. It fails
+// with ErrDuplicateSKU if its SKU was given to another product since.
+func RestoreProduct(ctx context.Context, id uint64) (*Product, error) {
+	defer observe("products", "restore", time.Now())
+
+	productsRWMtx.Lock()
+	defer productsRWMtx.Unlock()
+
+	if hasProduct(id) {
+		return nil, ErrIDTaken
+	}
+
+	trashMtx.Lock()
+	i := trashIndex("products", id)
+	if i < 0 {
+		trashMtx.Unlock()
+		return nil, ErrNotInTrash
+	}
+
+	product := copyProducts(Products{trash[i].Record.(*Product)})[0]
+	if skuTaken(product.SKU, product.ID) {
+		trashMtx.Unlock()
+		return nil, ErrDuplicateSKU
+	}
+
+	removeFromTrash(i)
+	trashMtx.Unlock()
+
+	productList = append(productList, product)
+	indexProduct(product, "")
+	publishProduct(ctx, ProductRestored, product, nil)
+	recordProduct(ctx, OpRestore, product, nil)
+
+	// make the items nullified by the deletion available again
+	cartsRWMtx.Lock()
+	for _, c := range cartList {
+		var previous *Cart
+		for j, item := range c.Products {
+			if item.ProductID == id && item.Unavailable {
+				if previous == nil {
+					previous = copyCarts(Carts{c})[0]
+				}
+				c.Products[j].Unavailable = false
+			}
+		}
+
+		if previous != nil {
+			publishCart(ctx, CartUpdated, c, previous)
+			recordCart(ctx, OpUpdate, c, previous)
+		}
+	}
+	cartsRWMtx.Unlock()
+
+	return copyProducts(Products{product})[0], nil
+}
+
+// RestoreCart moves the cart id back from the trash. It fails with a
+// *ValidationError if its user was deleted since. The on-delete policy
+// of the products is applied to its items of the products deleted
+// since.
+func RestoreCart(ctx context.Context, id uint64) (*Cart, error) {
+	defer observe("carts", "restore", time.Now())
+
+	productsRWMtx.RLock()
+	defer productsRWMtx.RUnlock()
+	userRWMutex.RLock()
+	defer userRWMutex.RUnlock()
+	cartsRWMtx.Lock()
+	defer cartsRWMtx.Unlock()
+
+	for _, c := range cartList {
+		if c.ID == id {
+			return nil, ErrIDTaken
+		}
+	}
+
+	trashMtx.Lock()
+	i := trashIndex("carts", id)
+	if i < 0 {
+		trashMtx.Unlock()
+		return nil, ErrNotInTrash
+	}
+
+	cart := copyCarts(Carts{trash[i].Record.(*Cart)})[0]
+	if err := checkOwner(cart); err != nil {
+		trashMtx.Unlock()
+		return nil, err
+	}
+
+	items, err := reconcileItems(cart.Products)
+	if err != nil {
+		trashMtx.Unlock()
+		return nil, err
+	}
+	cart.Products = items
+
+	removeFromTrash(i)
+	trashMtx.Unlock()
+
+	cartList = append(cartList, cart)
+	publishCart(ctx, CartRestored, cart, nil)
+	recordCart(ctx, OpRestore, cart, nil)
+
+	return copyCarts(Carts{cart})[0], nil
+}
+
+// RestoreUser moves the user id back from the trash. The carts deleted
+// with the user are not restored with it.
+func RestoreUser(ctx context.Context, id uint64) (*User, error) {
+	defer observe("users", "restore", time.Now())
+
+	userRWMutex.Lock()
+	defer userRWMutex.Unlock()
+
+	if hasUser(id) {
+		return nil, ErrIDTaken
+	}
+
+	trashMtx.Lock()
+	i := trashIndex("users", id)
+	if i < 0 {
+		trashMtx.Unlock()
+		return nil, ErrNotInTrash
+	}
+
+	user := copyUsers(Users{trash[i].Record.(*User)})[0]
+	removeFromTrash(i)
+	trashMtx.Unlock()
+
+	usersList = append(usersList, user)
+	publishUser(ctx, UserRestored, user, nil)
+	recordUser(ctx, OpRestore, user, nil)
+
+	return copyUsers(Users{user})[0], nil
+}
+
+// copyTrash copies the trash for snapshots. The records are shared, as
+// they are never modified while in the trash.
+func copyTrash(ts []*Trashed) []*Trashed {
+	c := make([]*Trashed, 0, len(ts))
+	for _, t := range ts {
+		tmp := *t
+		c = append(c, &tmp)
+	}
+
+	return c
+}
@@ -0,0 +1,203 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// inTrash reports whether the record id of resource is in the trash.
+func inTrash(resource string, id uint64) bool {
+	for _, t := range GetTrash(resource) {
+		if t.ID == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+// TestRestoreCascadedCarts checks that the carts deleted with their user
+// stay in the trash when the user is restored, and can only be restored
+// once it is.
+func TestRestoreCascadedCarts(t *testing.T) {
+	ctx := context.Background()
+
+	previous := GetOnDelete()
+	if err := SetOnDelete(OnDelete{Products: Restrict, Users: Cascade}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { SetOnDelete(previous) })
+
+	p := newTestProduct(t, 0)
+	u := newTestUser(t)
+	carts := []*Cart{
+		newTestCart(t, u.ID, false, Item{ProductID: p.ID, Quantity: 1}),
+		newTestCart(t, u.ID, false, Item{ProductID: p.ID, Quantity: 2}),
+	}
+
+	if _, err := RemoveUser(ctx, u.ID); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range carts {
+		if !inTrash("carts", c.ID) {
+			t.Fatalf("cart %d not moved to the trash with its user", c.ID)
+		}
+	}
+
+	// not before their user
+	var ve *ValidationError
+	if _, err := RestoreCart(ctx, carts[0].ID); !errors.As(err, &ve) {
+		t.Fatalf("got error %v restoring a cart of a deleted user, want a *ValidationError", err)
+	}
+
+	if _, err := RestoreUser(ctx, u.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got := GetAllUserCarts(u.ID); len(got) != 0 {
+		t.Errorf("got %d carts restored with the user, want none", len(got))
+	}
+
+	for _, c := range carts {
+		restored, err := RestoreCart(ctx, c.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if restored.UserID != u.ID || !equalItems(restored.Products, c.Products) {
+			t.Errorf("got cart %+v, want %+v", restored, c)
+		}
+		if inTrash("carts", c.ID) {
+			t.Errorf("cart %d restored but still in the trash", c.ID)
+		}
+	}
+	if got := GetAllUserCarts(u.ID); len(got) != len(carts) {
+		t.Errorf("got %d carts of the user, want %d", len(got), len(carts))
+	}
+}
+
+// TestRestoreConflict checks that the records are not restored over the
+// records using their ID, or the SKU of a product.
+func TestRestoreConflict(t *testing.T) {
+	ctx := context.Background()
+
+	// live records with the IDs of records in the trash, as the store
+	// never gives them
+	p := newTestProduct(t, 0)
+	u := newTestUser(t)
+	c := newTestCart(t, u.ID, false)
+	if _, err := RemoveProduct(ctx, p.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RemoveCart(ctx, c.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RemoveUser(ctx, u.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	productsRWMtx.Lock()
+	productList = append(productList, &Product{ID: p.ID, Name: "other", Category: "test"})
+	productsRWMtx.Unlock()
+	userRWMutex.Lock()
+	usersList = append(usersList, &User{ID: u.ID, Username: "other"})
+	userRWMutex.Unlock()
+	cartsRWMtx.Lock()
+	cartList = append(cartList, &Cart{ID: c.ID, UserID: u.ID})
+	cartsRWMtx.Unlock()
+
+	t.Cleanup(func() {
+		productsRWMtx.Lock()
+		productList = productList[:len(productList)-1]
+		productsRWMtx.Unlock()
+		userRWMutex.Lock()
+		usersList = usersList[:len(usersList)-1]
+		userRWMutex.Unlock()
+		cartsRWMtx.Lock()
+		dropCarts(func(other *Cart) bool { return other.ID == c.ID })
+		cartsRWMtx.Unlock()
+	})
+
+	if _, err := RestoreProduct(ctx, p.ID); !errors.Is(err, ErrIDTaken) {
+		t.Errorf("got error %v restoring a product, want ErrIDTaken", err)
+	}
+	if _, err := RestoreUser(ctx, u.ID); !errors.Is(err, ErrIDTaken) {
+		t.Errorf("got error %v restoring a user, want ErrIDTaken", err)
+	}
+	if _, err := RestoreCart(ctx, c.ID); !errors.Is(err, ErrIDTaken) {
+		t.Errorf("got error %v restoring a cart, want ErrIDTaken", err)
+	}
+
+	for resource, id := range map[string]uint64{"products": p.ID, "users": u.ID, "carts": c.ID} {
+		if !inTrash(resource, id) {
+			t.Errorf("%s %d dropped from the trash", resource, id)
+		}
+	}
+
+	// the SKU of a product in the trash given to another product
+	sku := &Product{Name: "test", Category: "test", SKU: "trash-conflict-" + strconv.FormatUint(p.ID, 10)}
+	if err := AddNewProduct(ctx, sku); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RemoveProduct(ctx, sku.ID); err != nil {
+		t.Fatal(err)
+	}
+	other := &Product{Name: "other", Category: "test", SKU: sku.SKU}
+	if err := AddNewProduct(ctx, other); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := RestoreProduct(ctx, sku.ID); !errors.Is(err, ErrDuplicateSKU) {
+		t.Errorf("got error %v restoring a product, want ErrDuplicateSKU", err)
+	}
+	if got, err := GetProductBySKU(sku.SKU); err != nil || got.ID != other.ID {
+		t.Errorf("got product %+v (%v) for the SKU, want %d", got, err, other.ID)
+	}
+}
+
+// TestPurgeTrash checks that the records purged from the trash cannot be
+// restored, and that their history is dropped.
+func TestPurgeTrash(t *testing.T) {
+	ctx := context.Background()
+
+	purged := newTestProduct(t, 0)
+	if _, err := RemoveProduct(ctx, purged.ID); err != nil {
+		t.Fatal(err)
+	}
+	before := time.Now()
+	time.Sleep(time.Millisecond)
+
+	kept := newTestProduct(t, 0)
+	if _, err := RemoveProduct(ctx, kept.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := PurgeTrash(ctx, before); n < 1 {
+		t.Errorf("purged %d records, want at least 1", n)
+	}
+
+	if inTrash("products", purged.ID) {
+		t.Error("purged product still in the trash")
+	}
+	if _, err := RestoreProduct(ctx, purged.ID); !errors.Is(err, ErrNotInTrash) {
+		t.Errorf("got error %v restoring a purged product, want ErrNotInTrash", err)
+	}
+	if _, err := GetProductHistory(purged.ID); err == nil {
+		t.Error("got the history of a purged product")
+	}
+	if _, err := GetProductAsOf(purged.ID, before); !errors.Is(err, ErrNoRevision) {
+		t.Errorf("got error %v reading a purged product, want ErrNoRevision", err)
+	}
+
+	// the records deleted since are kept
+	if !inTrash("products", kept.ID) {
+		t.Error("product deleted after the purge time dropped from the trash")
+	}
+	if h, err := GetProductHistory(kept.ID); err != nil || len(h) != 2 {
+		t.Errorf("got history %v (%v), want 2 revisions", h, err)
+	}
+	if _, err := RestoreProduct(ctx, kept.ID); err != nil {
+		t.Error(err)
+	}
+}
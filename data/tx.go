@@ -0,0 +1,99 @@
+package data
+
+import (
+	"sync"
+	"time"
+)
+
+// writeGate keeps the writes made outside of a transaction from
+// interleaving with it, so rolling it back does not undo the writes of
+// others. Transactions hold it for writing, the other writers for
+// reading.
+var writeGate sync.RWMutex
+
+// HoldWrites holds off transactions while the caller writes to the data
+// stores. It returns the function releasing them, and must not be
+// called within a transaction.
+func HoldWrites() func() {
+	writeGate.RLock()
+	return writeGate.RUnlock
+}
+
+// Tx is a transaction over every data store. The events and the audit
+// records of the changes made within it are held back until it is
+// committed, and dropped if it is rolled back, so nothing is told about
+// changes that are undone.
+type Tx struct {
+	snapshot *Snapshot
+	effects  []func()
+}
+
+var (
+	txMtx sync.Mutex
+	tx    *Tx // the open transaction, if any
+)
+
+// Begin starts a transaction, waiting for the writes in progress. Until
+// it is committed or rolled back, only its goroutine may write to the
+// data stores.
+func Begin() *Tx {
+	writeGate.Lock()
+
+	t := &Tx{snapshot: TakeSnapshot()}
+
+	txMtx.Lock()
+	tx = t
+	txMtx.Unlock()
+
+	return t
+}
+
+// Commit ends t, publishing the events and reporting the changes made
+// within it.
+func (t *Tx) Commit() {
+	defer observe("all", "commit", time.Now())
+
+	effects := t.end()
+	for _, fn := range effects {
+		fn()
+	}
+
+	writeGate.Unlock()
+}
+
+// Rollback ends t, setting every data store back to its state when t
+// began. The events and the changes made within it are dropped.
+func (t *Tx) Rollback() {
+	t.snapshot.Restore()
+	t.end()
+
+	writeGate.Unlock()
+}
+
+// end closes t and returns its pending effects.
+func (t *Tx) end() []func() {
+	txMtx.Lock()
+	defer txMtx.Unlock()
+
+	if tx == t {
+		tx = nil
+	}
+
+	effects := t.effects
+	t.effects = nil
+	return effects
+}
+
+// emit runs fn, which tells others about a change, or holds it back
+// until the open transaction is committed.
+func emit(fn func()) {
+	txMtx.Lock()
+	if tx != nil {
+		tx.effects = append(tx.effects, fn)
+		txMtx.Unlock()
+		return
+	}
+	txMtx.Unlock()
+
+	fn()
+}
@@ -1,10 +1,14 @@
 package data
 
 import (
+	"context"
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"sync"
+	"time"
 )
 
 const (
@@ -35,18 +39,18 @@ var usersList = Users{
 }
 
 type Address struct {
-	City    string `json:"city"`
-	Street  string `json:"street"`
+	City    string `json:"city" validate:"maxlen=100"`
+	Street  string `json:"street" validate:"maxlen=200"`
 	Number  uint64 `json:"number"`
-	ZipCode string `json:"zip_code"`
+	ZipCode string `json:"zip_code" validate:"maxlen=20"`
 }
 
 type User struct {
 	ID       uint64 `json:"id"`
-	Username string `json:"username"`
-	Password string `json:"password"`
-	Name     string `json:"name"`
-	Phone    string `json:"phone"`
+	Username string `json:"username" validate:"required,maxlen=50"`
+	Password string `json:"password" validate:"required,minlen=5,maxlen=128"`
+	Name     string `json:"name" validate:"maxlen=100"`
+	Phone    string `json:"phone" validate:"maxlen=30"`
 	*Address
 }
 
@@ -67,21 +71,28 @@ func userExists(id uint64) (index int, user *User, err error) {
 	return -1, nil, fmt.Errorf(UserNotFoundError)
 }
 
+// getNextUserID returns the ID following the highest ID of the users,
+// including those in the trash. It must be called with userRWMutex held
+// for writing, along with the append of the user, so that concurrent
+// creations do not get the same ID.
 func getNextUserID() uint64 {
-	userRWMutex.RLock()
-	defer userRWMutex.RUnlock()
+	max, found := maxTrashedID("users")
+	for _, u := range usersList {
+		if !found || u.ID > max {
+			max, found = u.ID, true
+		}
+	}
 
-	if len(usersList) == 0 {
+	if !found {
 		return 0
 	}
 
-	// assumes the user with ID 0 will never be deleted
-	lastUser := usersList[len(usersList)-1]
-
-	return lastUser.ID + 1
+	return max + 1
 }
 
 func GetAllUsers() Users {
+	defer observe("users", "list", time.Now())
+
 	userRWMutex.RLock()
 	tmp := make(Users, 0, len(usersList))
 	tmp = append(tmp, usersList...)
@@ -91,6 +102,8 @@ func GetAllUsers() Users {
 }
 
 func GetUser(id uint64) (*User, error) {
+	defer observe("users", "get", time.Now())
+
 	_, user, err := userExists(id)
 	if err != nil {
 		return nil, err
@@ -98,13 +111,63 @@ func GetUser(id uint64) (*User, error) {
 	return user, nil
 }
 
-func UpdateUser(user *User) error {
+// GetUsersByID get copies of the users with the given IDs in a single
+// pass over the data store. IDs with no user are left out.
+func GetUsersByID(ids []uint64) map[uint64]*User {
+	defer observe("users", "get_many", time.Now())
+
+	wanted := make(map[uint64]bool, len(ids))
+	for _, id := range ids {
+		wanted[id] = true
+	}
+
+	users := make(map[uint64]*User, len(ids))
+
+	userRWMutex.RLock()
+	defer userRWMutex.RUnlock()
+
+	for _, u := range usersList {
+		if wanted[u.ID] {
+			user := *u
+			users[u.ID] = &user
+		}
+	}
+
+	return users
+}
+
+// ErrInvalidCredentials is returned by Authenticate for an unknown
+// username or a wrong password.
+var ErrInvalidCredentials = errors.New("invalid username or password")
+
+// Authenticate returns the user with username and password, without
+// its password, or ErrInvalidCredentials.
+func Authenticate(username, password string) (*User, error) {
+	defer observe("users", "authenticate", time.Now())
+
+	userRWMutex.RLock()
+	defer userRWMutex.RUnlock()
+
+	for _, u := range usersList {
+		if u.Username == username && subtle.ConstantTimeCompare([]byte(u.Password), []byte(password)) == 1 {
+			return userWithoutPassword(u), nil
+		}
+	}
+
+	return nil, ErrInvalidCredentials
+}
+
+func UpdateUser(ctx context.Context, user *User) error {
+	defer observe("users", "update", time.Now())
+
 	userRWMutex.Lock()
 	defer userRWMutex.Unlock()
 
 	for i, u := range usersList {
 		if u.ID == user.ID {
 			usersList[i] = user
+			publishUser(ctx, UserUpdated, user, u)
+			recordUser(ctx, OpUpdate, user, u)
 			return nil
 		}
 	}
@@ -112,7 +175,9 @@ func UpdateUser(user *User) error {
 	return fmt.Errorf(UserNotFoundError)
 }
 
-func SetUser(user *User) error {
+func SetUser(ctx context.Context, user *User) error {
+	defer observe("users", "patch", time.Now())
+
 	userRWMutex.Lock()
 	defer userRWMutex.Unlock()
 
@@ -120,6 +185,8 @@ func SetUser(user *User) error {
 	// and using a better data structure.
 	for i, u := range usersList {
 		if u.ID == user.ID {
+			previous := copyUsers(Users{u})[0]
+
 			if user.Username != "" {
 				usersList[i].Username = user.Username
 			}
@@ -154,6 +221,8 @@ func SetUser(user *User) error {
 
 			// set temporary product equal to original product
 			*user = *usersList[i]
+			publishUser(ctx, UserUpdated, user, previous)
+			recordUser(ctx, OpPatch, user, previous)
 			return nil
 		}
 	}
@@ -161,24 +230,44 @@ func SetUser(user *User) error {
 	return fmt.Errorf(UserNotFoundError)
 }
 
-func AddNewUser(u *User) {
-	u.ID = getNextUserID()
+func AddNewUser(ctx context.Context, u *User) {
+	defer observe("users", "create", time.Now())
 
 	userRWMutex.Lock()
+	u.ID = getNextUserID()
 	usersList = append(usersList, u)
+	publishUser(ctx, UserCreated, u, nil)
+	recordUser(ctx, OpCreate, u, nil)
 	userRWMutex.Unlock()
+
+	usersCreated.Inc()
 }
 
-func RemoveUser(id uint64) (*User, error) {
-	index, user, err := userExists(id)
-	if err != nil {
-		return nil, err
+// RemoveUser moves the user id to the trash, recording who deleted it,
+// and applies the users on-delete policy to their carts.
+func RemoveUser(ctx context.Context, id uint64) (*User, error) {
+	defer observe("users", "delete", time.Now())
+
+	userRWMutex.Lock()
+	defer userRWMutex.Unlock()
+
+	// find the user under the write lock, as it may be removed meanwhile
+	index := -1
+	for i, u := range usersList {
+		if u.ID == id {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return nil, fmt.Errorf(UserNotFoundError)
 	}
 
-	deletedUser := &User{}
+	if err := releaseUser(ctx, id); err != nil {
+		return nil, err
+	}
 
-	userRWMutex.Lock()
-	*deletedUser = *user
+	deletedUser := copyUsers(Users{usersList[index]})[0]
 	tmpList := make(Users, 0, len(usersList)-1)
 
 	for i, u := range usersList {
@@ -189,8 +278,9 @@ func RemoveUser(id uint64) (*User, error) {
 		tmpList = append(tmpList, u)
 	}
 	usersList = tmpList
-
-	userRWMutex.Unlock()
+	moveToTrash(ctx, "users", id, copyUsers(Users{deletedUser})[0])
+	publishUser(ctx, UserDeleted, deletedUser, nil)
+	recordUser(ctx, OpDelete, nil, deletedUser)
 
 	return deletedUser, nil
 }
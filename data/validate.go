@@ -0,0 +1,187 @@
+package data
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// ValidateTag is the struct tag holding the validation rules of a
+// field, e.g. `validate:"required,maxlen=200"`. Supported rules are:
+//
+//	required   the field must not be the zero value
+//	min=N      numbers must be >= N
+//	max=N      numbers must be <= N
+//	minlen=N   strings must have at least N characters
+//	maxlen=N   strings must have at most N characters
+//
+// The same rules are published in the OpenAPI document of the API.
+const ValidateTag = "validate"
+
+// Rule is a single validation rule parsed from a ValidateTag.
+type Rule struct {
+	Name  string
+	Value float64
+}
+
+// ParseRules parses the value of a ValidateTag.
+func ParseRules(tag string) []Rule {
+	rules := []Rule{}
+	for _, r := range strings.Split(tag, ",") {
+		r = strings.TrimSpace(r)
+		if r == "" {
+			continue
+		}
+
+		rule := Rule{Name: r}
+		if i := strings.Index(r, "="); i >= 0 {
+			rule.Name = r[:i]
+			rule.Value, _ = strconv.ParseFloat(r[i+1:], 64)
+		}
+
+		rules = append(rules, rule)
+	}
+
+	return rules
+}
+
+// ValidationError describes a field not satisfying its rules.
+type ValidationError struct {
+	Field string
+	Msg   string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid field '%s': %s", e.Field, e.Msg)
+}
+
+// Validate checks v (a struct or a pointer to one) against the rules in
+// its ValidateTag struct tags, descending into nested structs and
+// slices of structs.
+func Validate(v interface{}) error {
+	return validateValue(reflect.ValueOf(v), "")
+}
+
+func validateValue(v reflect.Value, prefix string) error {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" && !f.Anonymous {
+				continue
+			}
+
+			name := JSONName(f)
+			if name == "-" {
+				continue
+			}
+
+			field := prefix + name
+			if f.Anonymous && f.Tag.Get("json") == "" {
+				// embedded structs are flattened by encoding/json
+				field = strings.TrimSuffix(prefix, ".")
+			}
+
+			if err := validateField(v.Field(i), field, ParseRules(f.Tag.Get(ValidateTag))); err != nil {
+				return err
+			}
+
+			nextPrefix := field + "."
+			if field == "" {
+				nextPrefix = ""
+			}
+			if err := validateValue(v.Field(i), nextPrefix); err != nil {
+				return err
+			}
+		}
+
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := validateValue(v.Index(i), fmt.Sprintf("%s[%d].", strings.TrimSuffix(prefix, "."), i)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func validateField(v reflect.Value, field string, rules []Rule) error {
+	for _, r := range rules {
+		switch r.Name {
+		case "required":
+			if v.IsZero() {
+				return &ValidationError{field, "is required"}
+			}
+
+		case "min", "max":
+			n, ok := numberOf(v)
+			if !ok {
+				continue
+			}
+
+			if r.Name == "min" && n < r.Value {
+				return &ValidationError{field, fmt.Sprintf("must be greater than or equal to %v", r.Value)}
+			}
+			if r.Name == "max" && n > r.Value {
+				return &ValidationError{field, fmt.Sprintf("must be less than or equal to %v", r.Value)}
+			}
+
+		case "minlen", "maxlen":
+			if v.Kind() != reflect.String {
+				continue
+			}
+
+			l := float64(utf8.RuneCountInString(v.String()))
+			if r.Name == "minlen" && l < r.Value {
+				return &ValidationError{field, fmt.Sprintf("must have at least %v characters", r.Value)}
+			}
+			if r.Name == "maxlen" && l > r.Value {
+				return &ValidationError{field, fmt.Sprintf("must have at most %v characters", r.Value)}
+			}
+		}
+	}
+
+	return nil
+}
+
+func numberOf(v reflect.Value) (float64, bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	}
+
+	return 0, false
+}
+
+// JSONName returns the name encoding/json uses for the field.
+func JSONName(f reflect.StructField) string {
+	tag := f.Tag.Get("json")
+	if tag == "-" {
+		return "-"
+	}
+
+	if i := strings.Index(tag, ","); i >= 0 {
+		tag = tag[:i]
+	}
+
+	if tag == "" {
+		return f.Name
+	}
+
+	return tag
+}
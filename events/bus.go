@@ -0,0 +1,339 @@
+// Package events implements the in-process bus the data stores publish
+// their changes to. A bounded replay buffer lets subscribers resume
+// after a disconnection without missing events.
+package events
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/imariom/products-api/tracing"
+)
+
+// DefaultReplaySize is the number of events kept for resumption by the
+// default bus.
+const DefaultReplaySize = 1000
+
+// subscriberBuffer is the number of events a subscriber may fall
+// behind before it is dropped.
+const subscriberBuffer = 256
+
+// Event is a change published on the bus.
+type Event struct {
+	// ID increases with every event published on a bus.
+	ID   uint64    `json:"id"`
+	Type string    `json:"type"` // e.g. product.created
+	Time time.Time `json:"time"`
+
+	// Topics are the topics the event is published on, from the most
+	// general to the most specific, e.g. carts, carts:3, carts:user:42.
+	Topics []string `json:"topics"`
+
+	// Data is the record after the change, or before it was deleted,
+	// and Previous the record before an update.
+	Data     interface{} `json:"data"`
+	Previous interface{} `json:"previous,omitempty"`
+
+	// Traceparent is the W3C traceparent of the span the event was
+	// published in, if any, so the handlers can carry on its trace.
+	Traceparent string `json:"-"`
+}
+
+// Matches reports whether e was published on a topic selected by one of
+// filters. A filter selects a topic and the topics under it (carts
+// selects carts:3 and carts:user:42), and "*" selects all of them.
+func (e *Event) Matches(filters []string) bool {
+	for _, f := range filters {
+		if f == "*" {
+			return true
+		}
+
+		for _, t := range e.Topics {
+			if t == f || strings.HasPrefix(t, f+":") {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Bus delivers the published events to its handlers and subscribers.
+// Handlers run with Publish and see every event. Slow subscribers are
+// dropped so publishers never block on them.
+type Bus struct {
+	mtx      sync.Mutex
+	lastID   uint64
+	replay   []Event // ring buffer of the last events
+	next     int     // index of the next event in replay
+	full     bool
+	subs     map[*Subscription]struct{}
+	handlers []*handler
+}
+
+type handler struct {
+	fn func(Event)
+}
+
+// NewBus creates a bus keeping the last replaySize events.
+func NewBus(replaySize int) *Bus {
+	if replaySize < 0 {
+		replaySize = 0
+	}
+
+	return &Bus{
+		replay: make([]Event, replaySize),
+		subs:   map[*Subscription]struct{}{},
+	}
+}
+
+// Publish assigns an ID to a new event and sends it to the subscribers.
+func (b *Bus) Publish(typ string, topics []string, data, previous interface{}) Event {
+	return b.PublishContext(context.Background(), typ, topics, data, previous)
+}
+
+// PublishContext is Publish for an event published in the span of ctx,
+// if any, whose trace the event carries on.
+func (b *Bus) PublishContext(ctx context.Context, typ string, topics []string, data, previous interface{}) Event {
+	var traceparent string
+	if sc := tracing.SpanContextFromContext(ctx); sc.IsValid() {
+		traceparent = sc.Traceparent()
+	}
+
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	b.lastID++
+	e := Event{
+		ID:          b.lastID,
+		Type:        typ,
+		Time:        time.Now().UTC(),
+		Topics:      topics,
+		Data:        data,
+		Previous:    previous,
+		Traceparent: traceparent,
+	}
+
+	if len(b.replay) > 0 {
+		b.replay[b.next] = e
+		b.next = (b.next + 1) % len(b.replay)
+		if b.next == 0 {
+			b.full = true
+		}
+	}
+
+	eventsPublished.WithLabelValues(typ).Inc()
+
+	for _, h := range b.handlers {
+		h.fn(e)
+	}
+
+	for s := range b.subs {
+		if !e.Matches(s.filters) {
+			continue
+		}
+
+		select {
+		case s.c <- e:
+		default:
+			s.dropLocked()
+			subscribersDropped.Inc()
+		}
+	}
+
+	return e
+}
+
+// Handle calls fn with every event published from now on, until the
+// returned function is called. Unlike subscribers, handlers are never
+// dropped: fn runs with Publish, one event after another, and must
+// neither block for long nor publish.
+func (b *Bus) Handle(fn func(Event)) (remove func()) {
+	h := &handler{fn}
+
+	b.mtx.Lock()
+	b.handlers = append(b.handlers, h)
+	b.mtx.Unlock()
+
+	return func() {
+		b.mtx.Lock()
+		defer b.mtx.Unlock()
+
+		for i, other := range b.handlers {
+			if other == h {
+				b.handlers = append(b.handlers[:i:i], b.handlers[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// LastID returns the ID of the last event published.
+func (b *Bus) LastID() uint64 {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	return b.lastID
+}
+
+// Subscribe returns a subscription to the events matching filters (see
+// Event.Matches) published from now on.
+func (b *Bus) Subscribe(filters []string) *Subscription {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	return b.subscribeLocked(filters)
+}
+
+// SubscribeAfter subscribes to the events matching filters and returns
+// the buffered ones published after the event lastID, to be handled
+// before the events of the subscription. complete is false when some of
+// them are no longer buffered, or lastID is unknown to the bus (e.g. it
+// was issued before a restart).
+func (b *Bus) SubscribeAfter(filters []string, lastID uint64) (sub *Subscription, replay []Event, complete bool) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	sub = b.subscribeLocked(filters)
+	if lastID > b.lastID {
+		return sub, nil, false
+	}
+
+	buffered := b.buffered()
+	complete = lastID == b.lastID
+	if len(buffered) > 0 {
+		complete = lastID+1 >= buffered[0].ID
+	}
+
+	for _, e := range buffered {
+		if e.ID > lastID && e.Matches(filters) {
+			replay = append(replay, e)
+		}
+	}
+
+	return sub, replay, complete
+}
+
+func (b *Bus) subscribeLocked(filters []string) *Subscription {
+	sub := &Subscription{
+		bus:     b,
+		c:       make(chan Event, subscriberBuffer),
+		filters: filters,
+	}
+	sub.C = sub.c
+	b.subs[sub] = struct{}{}
+
+	return sub
+}
+
+// Subscribers returns the number of subscribers of the bus.
+func (b *Bus) Subscribers() int {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	return len(b.subs)
+}
+
+// buffered returns the events of the replay buffer, oldest first.
+func (b *Bus) buffered() []Event {
+	if !b.full {
+		return append([]Event(nil), b.replay[:b.next]...)
+	}
+
+	events := make([]Event, 0, len(b.replay))
+	events = append(events, b.replay[b.next:]...)
+	return append(events, b.replay[:b.next]...)
+}
+
+// Subscription receives the events of a bus on C, which is closed when
+// the subscription is closed or dropped for falling behind.
+type Subscription struct {
+	C <-chan Event
+
+	bus     *Bus
+	c       chan Event
+	filters []string
+	dropped bool
+	closed  bool
+}
+
+// SetFilters changes the events received by the subscription.
+func (s *Subscription) SetFilters(filters []string) {
+	s.bus.mtx.Lock()
+	s.filters = filters
+	s.bus.mtx.Unlock()
+}
+
+// Filters returns the filters of the subscription.
+func (s *Subscription) Filters() []string {
+	s.bus.mtx.Lock()
+	defer s.bus.mtx.Unlock()
+
+	return append([]string(nil), s.filters...)
+}
+
+// Dropped reports whether the subscription was closed because it fell
+// too far behind.
+func (s *Subscription) Dropped() bool {
+	s.bus.mtx.Lock()
+	defer s.bus.mtx.Unlock()
+
+	return s.dropped
+}
+
+// Close stops the subscription. It may be called more than once.
+func (s *Subscription) Close() {
+	s.bus.mtx.Lock()
+	defer s.bus.mtx.Unlock()
+
+	s.closeLocked()
+}
+
+func (s *Subscription) dropLocked() {
+	s.dropped = true
+	s.closeLocked()
+}
+
+func (s *Subscription) closeLocked() {
+	if s.closed {
+		return
+	}
+
+	s.closed = true
+	delete(s.bus.subs, s)
+	close(s.c)
+}
+
+var (
+	defaultMtx sync.RWMutex
+	defaultBus = NewBus(DefaultReplaySize)
+)
+
+// SetDefault sets the bus used by the package level functions.
+func SetDefault(b *Bus) {
+	defaultMtx.Lock()
+	defaultBus = b
+	defaultMtx.Unlock()
+}
+
+// Default returns the bus set by SetDefault, by default one keeping
+// DefaultReplaySize events.
+func Default() *Bus {
+	defaultMtx.RLock()
+	defer defaultMtx.RUnlock()
+
+	return defaultBus
+}
+
+// Publish publishes an event on the default bus.
+func Publish(typ string, topics []string, data, previous interface{}) Event {
+	return Default().Publish(typ, topics, data, previous)
+}
+
+// PublishContext publishes an event on the default bus, in the span of
+// ctx if any.
+func PublishContext(ctx context.Context, typ string, topics []string, data, previous interface{}) Event {
+	return Default().PublishContext(ctx, typ, topics, data, previous)
+}
@@ -0,0 +1,16 @@
+package events
+
+import "github.com/imariom/products-api/metrics"
+
+var (
+	eventsPublished = metrics.NewCounterVec("events_published_total",
+		"Number of events published on the bus by type.", "type")
+
+	subscribersDropped = metrics.NewCounter("events_subscribers_dropped_total",
+		"Number of subscribers dropped for falling behind the events.")
+)
+
+func init() {
+	metrics.NewGaugeFunc("events_subscribers", "Number of subscribers of the default bus.",
+		func() float64 { return float64(Default().Subscribers()) })
+}
@@ -1,3 +1,3 @@
 module github.com/imariom/products-api
 
-go 1.17
+go 1.24
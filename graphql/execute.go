@@ -0,0 +1,610 @@
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Request is a GraphQL request.
+type Request struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName,omitempty"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+
+	// QueryOnly rejects mutations, as for requests made with GET.
+	QueryOnly bool `json:"-"`
+}
+
+// Response is the result of a request. Data is nil when the request
+// failed before it could be executed.
+type Response struct {
+	Data   interface{} `json:"data"`
+	Errors []*Error    `json:"errors,omitempty"`
+
+	executed bool
+}
+
+// Executed reports whether the operation was executed, in which case the
+// response has data even if some fields failed.
+func (r *Response) Executed() bool {
+	return r.executed
+}
+
+// MarshalJSON encodes the response, with "data" only when the operation
+// was executed.
+func (r *Response) MarshalJSON() ([]byte, error) {
+	out := struct {
+		Errors []*Error     `json:"errors,omitempty"`
+		Data   *interface{} `json:"data,omitempty"`
+	}{Errors: r.Errors}
+
+	if r.executed {
+		out.Data = &r.Data
+	}
+
+	return json.Marshal(out)
+}
+
+// Thunk is a value that is resolved later; resolvers return thunks to
+// defer lookups that can be batched with the other fields of the level.
+type Thunk func() (interface{}, error)
+
+// result is an object of the response, which keeps the order of the
+// fields.
+type result struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func newResult() *result {
+	return &result{values: map[string]interface{}{}}
+}
+
+func (r *result) set(key string, v interface{}) {
+	if _, ok := r.values[key]; !ok {
+		r.keys = append(r.keys, key)
+	}
+	r.values[key] = v
+}
+
+func (r *result) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+
+	for i, k := range r.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+
+		key, _ := json.Marshal(k)
+		buf.Write(key)
+		buf.WriteByte(':')
+
+		v, err := json.Marshal(r.values[k])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(v)
+	}
+
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// node is an object being resolved.
+type node struct {
+	source interface{}
+	path   []interface{}
+	result *result
+
+	// null is set when a non-null field of the object is null, which
+	// makes the object null.
+	null bool
+}
+
+// fieldGroup is the fields of a selection set with the same response
+// key.
+type fieldGroup struct {
+	key    string
+	fields []*field
+}
+
+type executor struct {
+	ctx    context.Context
+	schema *Schema
+	doc    *document
+	vars   map[string]interface{}
+	errors []*Error
+}
+
+// Execute runs the operation of req.
+func (s *Schema) Execute(ctx context.Context, req Request) *Response {
+	doc, err := parse(req.Query)
+	if err != nil {
+		return &Response{Errors: []*Error{toError(err)}}
+	}
+
+	op, err := selectOperation(doc, req.OperationName)
+	if err != nil {
+		return &Response{Errors: []*Error{toError(err)}}
+	}
+
+	root := s.Query
+	switch op.kind {
+	case "mutation":
+		if req.QueryOnly {
+			return &Response{Errors: []*Error{{
+				Message:   "Mutations can only be sent with POST requests.",
+				Locations: []Location{op.loc},
+			}}}
+		}
+		root = s.Mutation
+		if root == nil {
+			return &Response{Errors: []*Error{{
+				Message:   "Schema is not configured for mutations.",
+				Locations: []Location{op.loc},
+			}}}
+		}
+
+	case "subscription":
+		return &Response{Errors: []*Error{{
+			Message:   "Subscriptions are not supported.",
+			Locations: []Location{op.loc},
+		}}}
+	}
+
+	vars, errs := s.coerceVariables(op, req.Variables)
+	if len(errs) > 0 {
+		return &Response{Errors: errs}
+	}
+
+	if errs := s.validate(doc, op, root, vars); len(errs) > 0 {
+		return &Response{Errors: errs}
+	}
+
+	e := &executor{ctx: ctx, schema: s, doc: doc, vars: vars}
+	n := &node{result: newResult()}
+	groups := e.collect(root, op.selections, map[string]bool{})
+
+	if op.kind == "mutation" {
+		// top-level mutation fields run one after the other
+		for _, g := range groups {
+			e.execute([]*level{{t: root, nodes: []*node{n}, groups: []*fieldGroup{g}}})
+		}
+	} else {
+		e.execute([]*level{{t: root, nodes: []*node{n}, groups: groups}})
+	}
+
+	resp := &Response{Errors: e.errors, executed: true}
+	if !n.null {
+		resp.Data = n.result
+	}
+
+	return resp
+}
+
+func toError(err error) *Error {
+	if e, ok := err.(*Error); ok {
+		return e
+	}
+
+	return &Error{Message: err.Error()}
+}
+
+// selectOperation returns the operation of doc named name.
+func selectOperation(doc *document, name string) (*operation, error) {
+	if name == "" {
+		if len(doc.operations) > 1 {
+			return nil, fmt.Errorf("Must provide operation name if query contains multiple operations.")
+		}
+		return doc.operations[0], nil
+	}
+
+	for _, op := range doc.operations {
+		if op.name == name {
+			return op, nil
+		}
+	}
+
+	return nil, fmt.Errorf("Unknown operation named %q.", name)
+}
+
+// collect groups the fields selected on an object of type t by response
+// key, expanding fragments and applying @skip and @include.
+func (e *executor) collect(t *Object, sels []selection, visited map[string]bool) []*fieldGroup {
+	var groups []*fieldGroup
+	index := map[string]*fieldGroup{}
+
+	var walk func(sels []selection)
+	walk = func(sels []selection) {
+		for _, sel := range sels {
+			switch sel := sel.(type) {
+			case *field:
+				if !e.included(sel.directives) {
+					continue
+				}
+
+				g, ok := index[sel.key()]
+				if !ok {
+					g = &fieldGroup{key: sel.key()}
+					index[g.key] = g
+					groups = append(groups, g)
+				}
+				g.fields = append(g.fields, sel)
+
+			case *fragmentSpread:
+				if !e.included(sel.directives) || visited[sel.name] {
+					continue
+				}
+
+				f := e.doc.fragments[sel.name]
+				if f == nil || f.typeCond != t.Name {
+					continue
+				}
+
+				visited[sel.name] = true
+				walk(f.selections)
+
+			case *inlineFragment:
+				if !e.included(sel.directives) || (sel.typeCond != "" && sel.typeCond != t.Name) {
+					continue
+				}
+				walk(sel.selections)
+			}
+		}
+	}
+	walk(sels)
+
+	return groups
+}
+
+// included applies the @skip and @include directives.
+func (e *executor) included(dirs []*directive) bool {
+	for _, d := range dirs {
+		if d.name != "skip" && d.name != "include" {
+			continue
+		}
+
+		args, err := coerceArgs([]*Argument{{Name: "if", Type: NonNullOf(Boolean)}}, d.args, e.vars)
+		if err != nil {
+			continue
+		}
+
+		if args["if"].(bool) == (d.name == "skip") {
+			return false
+		}
+	}
+
+	return true
+}
+
+// level is the field groups to resolve on nodes, objects of type t.
+type level struct {
+	t      *Object
+	nodes  []*node
+	groups []*fieldGroup
+}
+
+// execute resolves the levels, the field groups selected on the objects
+// at the same depth of the response. The fields of the whole depth are
+// resolved before the thunks they returned are run, so loaders can batch
+// the lookups.
+func (e *executor) execute(levels []*level) {
+	values := make([][][]interface{}, len(levels))
+	for li, l := range levels {
+		values[li] = make([][]interface{}, len(l.groups))
+		for gi, g := range l.groups {
+			values[li][gi] = make([]interface{}, len(l.nodes))
+			for ni, n := range l.nodes {
+				values[li][gi][ni] = e.resolve(l.t, n, g)
+			}
+		}
+	}
+
+	// complete the values of the fields, then resolve the objects they
+	// contain together
+	var next []*level
+	for li, l := range levels {
+		for gi, g := range l.groups {
+			f := g.fields[0]
+			if f.name == "__typename" {
+				continue
+			}
+
+			def := l.t.Field(f.name)
+
+			var children []*node
+			for ni, n := range l.nodes {
+				v := values[li][gi][ni]
+				for {
+					thunk, ok := v.(Thunk)
+					if !ok {
+						break
+					}
+					v = e.call(n, g, func() (interface{}, error) { return thunk() })
+				}
+
+				values[li][gi][ni] = e.complete(def.Type, v, appendPath(n.path, g.key), g, &children)
+			}
+
+			if len(children) > 0 {
+				var sels []selection
+				for _, f := range g.fields {
+					sels = append(sels, f.selections...)
+				}
+
+				child := named(def.Type).(*Object)
+				next = append(next, &level{t: child, nodes: children, groups: e.collect(child, sels, map[string]bool{})})
+			}
+		}
+	}
+
+	if len(next) > 0 {
+		e.execute(next)
+	}
+
+	for li, l := range levels {
+		for gi, g := range l.groups {
+			f := g.fields[0]
+			if f.name == "__typename" {
+				for _, n := range l.nodes {
+					n.result.set(g.key, l.t.Name)
+				}
+				continue
+			}
+
+			def := l.t.Field(f.name)
+			for ni, n := range l.nodes {
+				v, ok := finish(def.Type, values[li][gi][ni])
+				if !ok {
+					n.null = true
+					continue
+				}
+				n.result.set(g.key, v)
+			}
+		}
+	}
+}
+
+// errored is the value of a field whose resolver failed.
+type errored struct{}
+
+// resolve calls the resolver of the field group g on n.
+func (e *executor) resolve(t *Object, n *node, g *fieldGroup) interface{} {
+	f := g.fields[0]
+	if f.name == "__typename" {
+		return nil
+	}
+
+	def := t.Field(f.name)
+
+	args, err := coerceArgs(def.Args, f.args, e.vars)
+	if err != nil {
+		e.fail(n, g, err)
+		return errored{}
+	}
+
+	return e.call(n, g, func() (interface{}, error) {
+		if def.Resolve == nil {
+			return defaultResolve(n.source, def.Name), nil
+		}
+
+		return def.Resolve(ResolveParams{
+			Context: e.ctx,
+			Source:  n.source,
+			Args:    args,
+		})
+	})
+}
+
+// call runs a resolver or thunk, recording its error.
+func (e *executor) call(n *node, g *fieldGroup, fn func() (interface{}, error)) (v interface{}) {
+	defer func() {
+		if r := recover(); r != nil {
+			e.fail(n, g, fmt.Errorf("internal error: %v", r))
+			v = errored{}
+		}
+	}()
+
+	v, err := fn()
+	if err != nil {
+		e.fail(n, g, err)
+		return errored{}
+	}
+
+	return v
+}
+
+func (e *executor) fail(n *node, g *fieldGroup, err error) {
+	var locs []Location
+	for _, f := range g.fields {
+		locs = append(locs, f.loc)
+	}
+
+	e.errors = append(e.errors, &Error{
+		Message:   err.Error(),
+		Locations: locs,
+		Path:      appendPath(n.path, g.key),
+	})
+}
+
+// complete converts the resolved value v to type t. Objects are replaced
+// with nodes, added to children, which are resolved by the caller.
+func (e *executor) complete(t Type, v interface{}, path []interface{}, g *fieldGroup, children *[]*node) interface{} {
+	if _, ok := v.(errored); ok {
+		return nil
+	}
+
+	if nn, ok := t.(*NonNull); ok {
+		if isNil(v) {
+			e.errors = append(e.errors, &Error{
+				Message: fmt.Sprintf("Cannot return null for non-nullable field %q.", g.fields[0].name),
+				Path:    path,
+			})
+			return nil
+		}
+		return e.complete(nn.Of, v, path, g, children)
+	}
+
+	if isNil(v) {
+		return nil
+	}
+
+	switch t := t.(type) {
+	case *Scalar:
+		s := t.Serialize(v)
+		if s == nil {
+			e.errors = append(e.errors, &Error{
+				Message: fmt.Sprintf("%s cannot represent value %v.", t, v),
+				Path:    path,
+			})
+		}
+		return s
+
+	case *Object:
+		n := &node{source: v, path: path, result: newResult()}
+		*children = append(*children, n)
+		return n
+
+	case *List:
+		rv := reflect.ValueOf(v)
+		if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+			e.errors = append(e.errors, &Error{
+				Message: fmt.Sprintf("Expected a list for field %q.", g.fields[0].name),
+				Path:    path,
+			})
+			return nil
+		}
+
+		list := make([]interface{}, rv.Len())
+		for i := range list {
+			list[i] = e.complete(t.Of, rv.Index(i).Interface(), appendPath(path, i), g, children)
+		}
+		return list
+	}
+
+	return nil
+}
+
+// finish replaces the nodes in the completed value v with their results.
+// It returns false if v is null while t is non-null, which makes the
+// parent null.
+func finish(t Type, v interface{}) (interface{}, bool) {
+	if nn, ok := t.(*NonNull); ok {
+		f, _ := finish(nn.Of, v)
+		return f, f != nil
+	}
+
+	switch v := v.(type) {
+	case *node:
+		if v.null {
+			return nil, true
+		}
+		return v.result, true
+
+	case []interface{}:
+		elem := t.(*List).Of
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			f, ok := finish(elem, item)
+			if !ok {
+				return nil, true
+			}
+			list[i] = f
+		}
+		return list, true
+	}
+
+	return v, true
+}
+
+func appendPath(path []interface{}, elem interface{}) []interface{} {
+	p := make([]interface{}, len(path), len(path)+1)
+	copy(p, path)
+	return append(p, elem)
+}
+
+func isNil(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Interface, reflect.Func:
+		return rv.IsNil()
+	}
+
+	return false
+}
+
+// defaultResolve reads the field name from a map, or from the struct
+// field with that JSON name.
+func defaultResolve(source interface{}, name string) interface{} {
+	if m, ok := source.(map[string]interface{}); ok {
+		return m[name]
+	}
+
+	rv := reflect.ValueOf(source)
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nil
+		}
+		rv = rv.Elem()
+	}
+
+	if rv.Kind() != reflect.Struct {
+		return nil
+	}
+
+	if f, ok := structField(rv, name); ok {
+		return f.Interface()
+	}
+
+	return nil
+}
+
+// structField finds the field with the JSON name name in the struct v,
+// following embedded structs.
+func structField(v reflect.Value, name string) (reflect.Value, bool) {
+	t := v.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.PkgPath != "" && !sf.Anonymous {
+			continue
+		}
+
+		tag := strings.Split(sf.Tag.Get("json"), ",")[0]
+		if tag == "-" {
+			continue
+		}
+
+		if sf.Anonymous && tag == "" {
+			fv := v.Field(i)
+			if fv.Kind() == reflect.Ptr {
+				if fv.IsNil() {
+					continue
+				}
+				fv = fv.Elem()
+			}
+
+			if fv.Kind() == reflect.Struct {
+				if f, ok := structField(fv, name); ok {
+					return f, true
+				}
+			}
+			continue
+		}
+
+		if tag == name || (tag == "" && strings.EqualFold(sf.Name, name)) {
+			return v.Field(i), true
+		}
+	}
+
+	return reflect.Value{}, false
+}
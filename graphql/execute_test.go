@@ -0,0 +1,435 @@
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// testProduct is the source of the Product objects of the test schema,
+// resolved from its JSON names by default.
+type testProduct struct {
+	ID      int      `json:"id"`
+	Name    string   `json:"name"`
+	Price   float64  `json:"price"`
+	Tags    []string `json:"tags"`
+	Related int      `json:"-"`
+}
+
+var testProducts = map[string]*testProduct{
+	"1": {ID: 1, Name: "Backpack", Price: 109.95, Tags: []string{"bags"}, Related: 2},
+	"2": {ID: 2, Name: "T-Shirt", Price: 22.3, Tags: []string{}, Related: 1},
+	"3": {ID: 3, Name: "", Price: 0},
+}
+
+type loaderKey struct{}
+
+// testSchema returns the schema of the tests, and a pointer to the
+// number of batches of its loader.
+func testSchema(t *testing.T, limits Schema) (*Schema, *int) {
+	t.Helper()
+
+	batches := 0
+	product := &Object{Name: "Product"}
+	product.Fields = []*Field{
+		{Name: "id", Type: NonNullOf(ID)},
+		{Name: "name", Type: String},
+		{Name: "price", Type: Float},
+		{Name: "tags", Type: NonNullOf(ListOf(NonNullOf(String)))},
+		{
+			// name, which is required, is empty for product 3
+			Name: "label",
+			Type: NonNullOf(String),
+			Resolve: func(p ResolveParams) (interface{}, error) {
+				if name := p.Source.(*testProduct).Name; name != "" {
+					return name, nil
+				}
+				return nil, nil
+			},
+		},
+		{
+			Name: "related",
+			Type: product,
+			Resolve: func(p ResolveParams) (interface{}, error) {
+				l := p.Context.Value(loaderKey{}).(*Loader)
+				return l.Load(p.Context, fmt.Sprint(p.Source.(*testProduct).Related)), nil
+			},
+		},
+	}
+
+	var added []string
+	query := &Object{
+		Name: "Query",
+		Fields: []*Field{
+			{
+				Name: "hello",
+				Type: String,
+				Args: []*Argument{{Name: "name", Type: String, Default: "world"}},
+				Resolve: func(p ResolveParams) (interface{}, error) {
+					return "hello " + p.Args["name"].(string), nil
+				},
+			},
+			{
+				Name: "product",
+				Type: product,
+				Args: []*Argument{{Name: "id", Type: NonNullOf(ID)}},
+				Resolve: func(p ResolveParams) (interface{}, error) {
+					if p, ok := testProducts[p.Args["id"].(string)]; ok {
+						return p, nil
+					}
+					return nil, nil
+				},
+			},
+			{
+				Name: "products",
+				Type: NonNullOf(ListOf(NonNullOf(product))),
+				Args: []*Argument{{Name: "limit", Type: Int}},
+				Resolve: func(p ResolveParams) (interface{}, error) {
+					products := []*testProduct{testProducts["1"], testProducts["2"], testProducts["3"]}
+					if limit, ok := p.Args["limit"].(int); ok && limit < len(products) {
+						products = products[:limit]
+					}
+					return products, nil
+				},
+			},
+			{
+				Name: "fail",
+				Type: String,
+				Resolve: func(p ResolveParams) (interface{}, error) {
+					return nil, errors.New("failed")
+				},
+			},
+			{
+				Name: "required",
+				Type: NonNullOf(String),
+				Resolve: func(p ResolveParams) (interface{}, error) {
+					return nil, nil
+				},
+			},
+			{
+				Name: "added",
+				Type: NonNullOf(ListOf(NonNullOf(String))),
+				Resolve: func(p ResolveParams) (interface{}, error) {
+					return added, nil
+				},
+			},
+		},
+	}
+
+	mutation := &Object{
+		Name: "Mutation",
+		Fields: []*Field{{
+			Name: "add",
+			Type: NonNullOf(ListOf(NonNullOf(String))),
+			Args: []*Argument{{Name: "name", Type: NonNullOf(String)}},
+			Resolve: func(p ResolveParams) (interface{}, error) {
+				added = append(added, p.Args["name"].(string))
+				return append([]string(nil), added...), nil
+			},
+		}},
+	}
+
+	s, err := NewSchema(Schema{
+		Query:         query,
+		Mutation:      mutation,
+		MaxDepth:      limits.MaxDepth,
+		MaxComplexity: limits.MaxComplexity,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s, &batches
+}
+
+// execute runs req on s with a loader of the products, and returns the
+// JSON encoding of the response.
+func execute(t *testing.T, s *Schema, batches *int, req Request) string {
+	t.Helper()
+
+	l := NewLoader(func(ctx context.Context, keys []interface{}) (map[interface{}]interface{}, error) {
+		*batches++
+		values := map[interface{}]interface{}{}
+		for _, k := range keys {
+			if p, ok := testProducts[k.(string)]; ok {
+				values[k] = p
+			}
+		}
+		return values, nil
+	})
+	ctx := context.WithValue(context.Background(), loaderKey{}, l)
+
+	b, err := json.Marshal(s.Execute(ctx, req))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestExecute(t *testing.T) {
+	tests := []struct {
+		name string
+		req  Request
+		want string
+	}{
+		{
+			name: "default argument",
+			req:  Request{Query: "{ hello }"},
+			want: `{"data":{"hello":"hello world"}}`,
+		},
+		{
+			name: "literal argument",
+			req:  Request{Query: `{ hello(name: "you") }`},
+			want: `{"data":{"hello":"hello you"}}`,
+		},
+		{
+			name: "variables",
+			req: Request{
+				Query:     `query ($name: String, $id: ID!) { hello(name: $name) product(id: $id) { id } }`,
+				Variables: map[string]interface{}{"name": "var", "id": 2.0},
+			},
+			want: `{"data":{"hello":"hello var","product":{"id":"2"}}}`,
+		},
+		{
+			name: "variable defaults",
+			req:  Request{Query: `query ($name: String = "default") { hello(name: $name) }`},
+			want: `{"data":{"hello":"hello default"}}`,
+		},
+		{
+			name: "aliases keep the order of the selection",
+			req:  Request{Query: `{ b: hello(name: "b") a: hello(name: "a") hello }`},
+			want: `{"data":{"b":"hello b","a":"hello a","hello":"hello world"}}`,
+		},
+		{
+			name: "fields are merged",
+			req:  Request{Query: `{ product(id: 1) { id } product(id: 1) { name } }`},
+			want: `{"data":{"product":{"id":"1","name":"Backpack"}}}`,
+		},
+		{
+			name: "fragments",
+			req: Request{Query: `
+				{ product(id: "1") { ...Names ... on Product { price } ... { tags } } }
+				fragment Names on Product { id name }
+			`},
+			want: `{"data":{"product":{"id":"1","name":"Backpack","price":109.95,"tags":["bags"]}}}`,
+		},
+		{
+			name: "skip and include",
+			req: Request{
+				Query:     `query ($yes: Boolean!) { a: hello @skip(if: $yes) b: hello @include(if: $yes) c: hello @skip(if: false) @include(if: false) }`,
+				Variables: map[string]interface{}{"yes": true},
+			},
+			want: `{"data":{"b":"hello world"}}`,
+		},
+		{
+			name: "typename",
+			req:  Request{Query: `{ __typename product(id: 2) { __typename } }`},
+			want: `{"data":{"__typename":"Query","product":{"__typename":"Product"}}}`,
+		},
+		{
+			name: "lists",
+			req:  Request{Query: `{ products(limit: 2) { id tags } }`},
+			want: `{"data":{"products":[{"id":"1","tags":["bags"]},{"id":"2","tags":[]}]}}`,
+		},
+		{
+			name: "null object",
+			req:  Request{Query: `{ product(id: 9) { id } }`},
+			want: `{"data":{"product":null}}`,
+		},
+		{
+			name: "loader",
+			req:  Request{Query: `{ products { related { name related { id } } } }`},
+			want: `{"data":{"products":[{"related":{"name":"T-Shirt","related":{"id":"1"}}},{"related":{"name":"Backpack","related":{"id":"2"}}},{"related":null}]}}`,
+		},
+		{
+			name: "operation name",
+			req:  Request{Query: `query A { a: hello } query B { b: hello }`, OperationName: "B"},
+			want: `{"data":{"b":"hello world"}}`,
+		},
+		{
+			name: "mutations run in order",
+			req:  Request{Query: `mutation { first: add(name: "a") second: add(name: "b") }`},
+			want: `{"data":{"first":["a"],"second":["a","b"]}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, batches := testSchema(t, Schema{})
+			if got := execute(t, s, batches, tt.req); got != tt.want {
+				t.Errorf("got  %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestLoaderBatches checks that the loads of a level are batched, and
+// that the values loaded are not loaded again.
+func TestLoaderBatches(t *testing.T) {
+	tests := []struct {
+		query   string
+		batches int
+	}{
+		{`{ product(id: 1) { id } }`, 0},
+		{`{ products { related { id } } }`, 1},
+		{`{ a: product(id: 1) { related { id } } b: product(id: 2) { related { id } } }`, 1},
+		// the products related to the related products are cached
+		{`{ products { related { related { related { id } } } } }`, 1},
+		{`{ product(id: 1) { related { related { id } } } }`, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			s, batches := testSchema(t, Schema{})
+
+			execute(t, s, batches, Request{Query: tt.query})
+			if *batches != tt.batches {
+				t.Errorf("loaded the products with %d batches, want %d", *batches, tt.batches)
+			}
+		})
+	}
+}
+
+// TestExecuteErrors checks the errors of the fields, which leave the
+// other fields of the response, and those of the requests, which are
+// not executed.
+func TestExecuteErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		req    Request
+		limits Schema
+		want   string
+	}{
+		{
+			name: "field error",
+			req:  Request{Query: `{ fail hello }`},
+			want: `{"errors":[{"message":"failed","locations":[{"line":1,"column":3}],"path":["fail"]}],"data":{"fail":null,"hello":"hello world"}}`,
+		},
+		{
+			name: "null non-null field nulls its parent",
+			req:  Request{Query: `{ a: product(id: 1) { label } b: product(id: 3) { label } }`},
+			want: `{"errors":[{"message":"Cannot return null for non-nullable field \"label\".","path":["b","label"]}],"data":{"a":{"label":"Backpack"},"b":null}}`,
+		},
+		{
+			name: "null non-null list element nulls the list",
+			req:  Request{Query: `{ products { label } }`},
+			want: `{"errors":[{"message":"Cannot return null for non-nullable field \"label\".","path":["products",2,"label"]}],"data":null}`,
+		},
+		{
+			name: "null non-null root field nulls the data",
+			req:  Request{Query: `{ required hello }`},
+			want: `{"errors":[{"message":"Cannot return null for non-nullable field \"required\".","path":["required"]}],"data":null}`,
+		},
+		{
+			name: "syntax error",
+			req:  Request{Query: `{ hello `},
+			want: `{"errors":[{"message":"Syntax Error: Expected Name, found \u003cEOF\u003e.","locations":[{"line":1,"column":9}]}]}`,
+		},
+		{
+			name: "unknown field",
+			req:  Request{Query: `{ hello nope }`},
+			want: `{"errors":[{"message":"Cannot query field \"nope\" on type \"Query\".","locations":[{"line":1,"column":9}]}]}`,
+		},
+		{
+			name: "missing selection",
+			req:  Request{Query: `{ product(id: 1) }`},
+			want: `{"errors":[{"message":"Field \"product\" of type \"Product\" must have a selection of subfields.","locations":[{"line":1,"column":3}]}]}`,
+		},
+		{
+			name: "selection on a scalar",
+			req:  Request{Query: `{ hello { x } }`},
+			want: `{"errors":[{"message":"Field \"hello\" must not have a selection since type \"String\" has no subfields.","locations":[{"line":1,"column":3}]}]}`,
+		},
+		{
+			name: "unknown fragment",
+			req:  Request{Query: `{ ...F }`},
+			want: `{"errors":[{"message":"Unknown fragment \"F\".","locations":[{"line":1,"column":3}]}]}`,
+		},
+		{
+			name: "fragment cycle",
+			req:  Request{Query: `{ ...A } fragment A on Query { ...B } fragment B on Query { ...A }`},
+			want: `{"errors":[{"message":"Cannot spread fragment \"A\" within itself.","locations":[{"line":1,"column":10}]}]}`,
+		},
+		{
+			name: "fragment on another type",
+			req:  Request{Query: `{ ... on Product { id } }`},
+			want: `{"errors":[{"message":"Fragment on \"Product\" cannot be spread here as objects of type \"Query\" can never be of type \"Product\".","locations":[{"line":1,"column":3}]}]}`,
+		},
+		{
+			name: "unknown directive",
+			req:  Request{Query: `{ hello @deprecated }`},
+			want: `{"errors":[{"message":"Unknown directive \"@deprecated\".","locations":[{"line":1,"column":9}]}]}`,
+		},
+		{
+			name: "missing variable",
+			req:  Request{Query: `query ($id: ID!) { product(id: $id) { id } }`},
+			want: `{"errors":[{"message":"Variable \"$id\" of required type \"ID!\" was not provided.","locations":[{"line":1,"column":8}]}]}`,
+		},
+		{
+			name: "invalid variable",
+			req:  Request{Query: `query ($n: Int) { products(limit: $n) { id } }`, Variables: map[string]interface{}{"n": "x"}},
+			want: `{"errors":[{"message":"Variable \"$n\" got invalid value \"x\"; Int cannot represent \"x\"","locations":[{"line":1,"column":8}]}]}`,
+		},
+		{
+			name: "operation name required",
+			req:  Request{Query: `query A { hello } query B { hello }`},
+			want: `{"errors":[{"message":"Must provide operation name if query contains multiple operations."}]}`,
+		},
+		{
+			name: "unknown operation",
+			req:  Request{Query: `query A { hello }`, OperationName: "B"},
+			want: `{"errors":[{"message":"Unknown operation named \"B\"."}]}`,
+		},
+		{
+			name: "mutation with GET",
+			req:  Request{Query: `mutation { add(name: "a") }`, QueryOnly: true},
+			want: `{"errors":[{"message":"Mutations can only be sent with POST requests.","locations":[{"line":1,"column":1}]}]}`,
+		},
+		{
+			name: "subscription",
+			req:  Request{Query: `subscription { hello }`},
+			want: `{"errors":[{"message":"Subscriptions are not supported.","locations":[{"line":1,"column":1}]}]}`,
+		},
+		{
+			name:   "depth limit",
+			req:    Request{Query: `{ product(id: 1) { related { related { id } } } }`},
+			limits: Schema{MaxDepth: 3},
+			want:   `{"errors":[{"message":"Query has depth 4, which exceeds the maximum depth of 3.","locations":[{"line":1,"column":1}]}]}`,
+		},
+		{
+			name:   "complexity of the lists is their limit",
+			req:    Request{Query: `{ products(limit: 50) { id name } }`},
+			limits: Schema{MaxComplexity: 100},
+			want:   `{"errors":[{"message":"Query has complexity 101, which exceeds the maximum complexity of 100.","locations":[{"line":1,"column":1}]}]}`,
+		},
+		{
+			name:   "complexity of the lists without a limit",
+			req:    Request{Query: `{ products { related { id name } } }`},
+			limits: Schema{MaxComplexity: 30},
+			want:   `{"errors":[{"message":"Query has complexity 31, which exceeds the maximum complexity of 30.","locations":[{"line":1,"column":1}]}]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, batches := testSchema(t, tt.limits)
+			if got := execute(t, s, batches, tt.req); got != tt.want {
+				t.Errorf("got  %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestExecuteWithinLimits checks that queries at the limits run.
+func TestExecuteWithinLimits(t *testing.T) {
+	// the complexity is 1+50*2 for a and 1+1*(1+1) for b
+	s, batches := testSchema(t, Schema{MaxDepth: 3, MaxComplexity: 104})
+
+	got := execute(t, s, batches, Request{Query: `{ a: products(limit: 50) { id name } b: product(id: 1) { related { id } } }`})
+	if strings.Contains(got, `"errors"`) {
+		t.Errorf("got %s, want no errors", got)
+	}
+}
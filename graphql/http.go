@@ -0,0 +1,76 @@
+package graphql
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+)
+
+// maxRequestSize is the maximum size of the body of a request.
+const maxRequestSize = 1 << 20
+
+// RequestError is an HTTP request that is not a valid GraphQL request.
+type RequestError struct {
+	Status  int
+	Message string
+}
+
+func (e *RequestError) Error() string {
+	return e.Message
+}
+
+// ParseRequest reads the GraphQL request from the query string of GET
+// requests or from the body of POST requests, encoded in JSON or given
+// as a document with the application/graphql media type. Requests made
+// with GET can only run queries.
+func ParseRequest(r *http.Request) (Request, error) {
+	var req Request
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		q := r.URL.Query()
+		req.Query = q.Get("query")
+		req.OperationName = q.Get("operationName")
+		req.QueryOnly = true
+
+		if vars := q.Get("variables"); vars != "" {
+			if err := json.Unmarshal([]byte(vars), &req.Variables); err != nil {
+				return req, &RequestError{http.StatusBadRequest, "variables must be a JSON object"}
+			}
+		}
+
+	case http.MethodPost:
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxRequestSize+1))
+		if err != nil {
+			return req, &RequestError{http.StatusBadRequest, "failed to read request body"}
+		}
+		if len(body) > maxRequestSize {
+			return req, &RequestError{http.StatusRequestEntityTooLarge,
+				fmt.Sprintf("request body exceeds %d bytes", maxRequestSize)}
+		}
+
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		switch mediaType {
+		case "application/json", "":
+			if err := json.Unmarshal(body, &req); err != nil {
+				return req, &RequestError{http.StatusBadRequest, "invalid GraphQL request: " + err.Error()}
+			}
+		case "application/graphql":
+			req.Query = string(body)
+		default:
+			return req, &RequestError{http.StatusUnsupportedMediaType,
+				fmt.Sprintf("unsupported media type %q", mediaType)}
+		}
+
+	default:
+		return req, &RequestError{http.StatusMethodNotAllowed, "GraphQL requests must use GET or POST"}
+	}
+
+	if req.Query == "" {
+		return req, &RequestError{http.StatusBadRequest, "missing GraphQL query"}
+	}
+
+	return req, nil
+}
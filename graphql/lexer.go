@@ -0,0 +1,319 @@
+package graphql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenPunct
+	tokenName
+	tokenInt
+	tokenFloat
+	tokenString
+)
+
+// Location is a position in a GraphQL document.
+type Location struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+type token struct {
+	kind  tokenKind
+	value string
+	loc   Location
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokenEOF:
+		return "<EOF>"
+	case tokenString:
+		return strconv.Quote(t.value)
+	}
+
+	return t.value
+}
+
+// lexer splits a document into tokens, skipping whitespace, commas and
+// comments.
+type lexer struct {
+	src  string
+	pos  int
+	line int
+	col  int
+}
+
+func newLexer(src string) *lexer {
+	return &lexer{src: src, line: 1, col: 1}
+}
+
+// advance moves n bytes forward, which must not contain line breaks.
+func (l *lexer) advance(n int) {
+	l.pos += n
+	l.col += n
+}
+
+func (l *lexer) next() (token, error) {
+	// skip ignored tokens
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch {
+		case c == '\n':
+			l.pos++
+			l.line++
+			l.col = 1
+		case c == ' ' || c == '\t' || c == '\r' || c == ',':
+			l.advance(1)
+		case c == '#':
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' {
+				l.advance(1)
+			}
+		case strings.HasPrefix(l.src[l.pos:], "\uFEFF"):
+			l.advance(len("\uFEFF"))
+		default:
+			return l.token()
+		}
+	}
+
+	return token{kind: tokenEOF, loc: Location{l.line, l.col}}, nil
+}
+
+func (l *lexer) token() (token, error) {
+	loc := Location{l.line, l.col}
+	c := l.src[l.pos]
+
+	switch {
+	case strings.HasPrefix(l.src[l.pos:], "..."):
+		l.advance(3)
+		return token{kind: tokenPunct, value: "...", loc: loc}, nil
+
+	case strings.IndexByte("!$():=@[]{}|&", c) >= 0:
+		l.advance(1)
+		return token{kind: tokenPunct, value: string(c), loc: loc}, nil
+
+	case c == '_' || isLetter(c):
+		start := l.pos
+		for l.pos < len(l.src) && (l.src[l.pos] == '_' || isLetter(l.src[l.pos]) || isDigit(l.src[l.pos])) {
+			l.advance(1)
+		}
+		return token{kind: tokenName, value: l.src[start:l.pos], loc: loc}, nil
+
+	case c == '-' || isDigit(c):
+		return l.number(loc)
+
+	case c == '"':
+		if strings.HasPrefix(l.src[l.pos:], `"""`) {
+			return l.blockString(loc)
+		}
+		return l.string(loc)
+	}
+
+	r, _ := utf8.DecodeRuneInString(l.src[l.pos:])
+	return token{}, &Error{
+		Message:   fmt.Sprintf("Syntax Error: Unexpected character %q.", r),
+		Locations: []Location{loc},
+	}
+}
+
+func (l *lexer) number(loc Location) (token, error) {
+	start := l.pos
+	kind := tokenInt
+
+	if l.src[l.pos] == '-' {
+		l.advance(1)
+	}
+
+	// the integer part has no leading zeros, and the fractional part
+	// and the exponent have digits
+	intStart := l.pos
+	valid := l.digits() > 0 && (l.src[intStart] != '0' || l.pos-intStart == 1)
+
+	if l.pos < len(l.src) && l.src[l.pos] == '.' {
+		kind = tokenFloat
+		l.advance(1)
+		valid = l.digits() > 0 && valid
+	}
+
+	if l.pos < len(l.src) && (l.src[l.pos] == 'e' || l.src[l.pos] == 'E') {
+		kind = tokenFloat
+		l.advance(1)
+		if l.pos < len(l.src) && (l.src[l.pos] == '+' || l.src[l.pos] == '-') {
+			l.advance(1)
+		}
+		valid = l.digits() > 0 && valid
+	}
+
+	value := l.src[start:l.pos]
+	if !valid || (l.pos < len(l.src) && (isLetter(l.src[l.pos]) || l.src[l.pos] == '_' || l.src[l.pos] == '.')) {
+		return token{}, &Error{
+			Message:   fmt.Sprintf("Syntax Error: Invalid number %q.", value),
+			Locations: []Location{loc},
+		}
+	}
+
+	return token{kind: kind, value: value, loc: loc}, nil
+}
+
+// digits skips the digits at the position and returns their number.
+func (l *lexer) digits() int {
+	start := l.pos
+	for l.pos < len(l.src) && isDigit(l.src[l.pos]) {
+		l.advance(1)
+	}
+
+	return l.pos - start
+}
+
+func (l *lexer) string(loc Location) (token, error) {
+	var sb strings.Builder
+	l.advance(1)
+
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch {
+		case c == '"':
+			l.advance(1)
+			return token{kind: tokenString, value: sb.String(), loc: loc}, nil
+
+		case c == '\n' || c == '\r':
+			return token{}, &Error{
+				Message:   "Syntax Error: Unterminated string.",
+				Locations: []Location{loc},
+			}
+
+		case c == '\\':
+			if l.pos+1 >= len(l.src) {
+				return token{}, &Error{
+					Message:   "Syntax Error: Unterminated string.",
+					Locations: []Location{loc},
+				}
+			}
+
+			esc := l.src[l.pos+1]
+			switch esc {
+			case '"', '\\', '/':
+				sb.WriteByte(esc)
+			case 'b':
+				sb.WriteByte('\b')
+			case 'f':
+				sb.WriteByte('\f')
+			case 'n':
+				sb.WriteByte('\n')
+			case 'r':
+				sb.WriteByte('\r')
+			case 't':
+				sb.WriteByte('\t')
+			case 'u':
+				var n uint64
+				var err error
+				if l.pos+6 <= len(l.src) {
+					n, err = strconv.ParseUint(l.src[l.pos+2:l.pos+6], 16, 32)
+				}
+				if l.pos+6 > len(l.src) || err != nil {
+					return token{}, &Error{
+						Message:   "Syntax Error: Invalid Unicode escape sequence.",
+						Locations: []Location{{l.line, l.col}},
+					}
+				}
+				sb.WriteRune(rune(n))
+				l.advance(4)
+			default:
+				return token{}, &Error{
+					Message:   fmt.Sprintf("Syntax Error: Invalid character escape sequence \\%c.", esc),
+					Locations: []Location{{l.line, l.col}},
+				}
+			}
+			l.advance(2)
+
+		default:
+			sb.WriteByte(c)
+			l.advance(1)
+		}
+	}
+
+	return token{}, &Error{
+		Message:   "Syntax Error: Unterminated string.",
+		Locations: []Location{loc},
+	}
+}
+
+func (l *lexer) blockString(loc Location) (token, error) {
+	l.advance(3)
+	start := l.pos
+
+	for l.pos < len(l.src) {
+		switch {
+		case strings.HasPrefix(l.src[l.pos:], `"""`):
+			raw := strings.ReplaceAll(l.src[start:l.pos], `\"""`, `"""`)
+			l.advance(3)
+			return token{kind: tokenString, value: blockStringValue(raw), loc: loc}, nil
+
+		case strings.HasPrefix(l.src[l.pos:], `\"""`):
+			l.advance(4)
+
+		case l.src[l.pos] == '\n':
+			l.pos++
+			l.line++
+			l.col = 1
+
+		default:
+			l.advance(1)
+		}
+	}
+
+	return token{}, &Error{
+		Message:   "Syntax Error: Unterminated string.",
+		Locations: []Location{loc},
+	}
+}
+
+// blockStringValue removes the common indentation and the blank first
+// and last lines of a block string.
+func blockStringValue(raw string) string {
+	lines := strings.Split(strings.ReplaceAll(raw, "\r\n", "\n"), "\n")
+
+	indent := -1
+	for _, line := range lines[1:] {
+		trimmed := strings.TrimLeft(line, " \t")
+		if trimmed == "" {
+			continue
+		}
+		if n := len(line) - len(trimmed); indent < 0 || n < indent {
+			indent = n
+		}
+	}
+
+	if indent > 0 {
+		for i := 1; i < len(lines); i++ {
+			if len(lines[i]) >= indent {
+				lines[i] = lines[i][indent:]
+			} else {
+				lines[i] = strings.TrimLeft(lines[i], " \t")
+			}
+		}
+	}
+
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
@@ -0,0 +1,156 @@
+package graphql
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// lex returns the tokens of src, up to the end of the document.
+func lex(src string) ([]token, error) {
+	l := newLexer(src)
+
+	var tokens []token
+	for {
+		tok, err := l.next()
+		if err != nil {
+			return tokens, err
+		}
+		if tok.kind == tokenEOF {
+			return tokens, nil
+		}
+		tokens = append(tokens, tok)
+	}
+}
+
+func TestLexer(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want []token
+	}{
+		{
+			name: "punctuators and names",
+			src:  "query Q($id: ID!) { ...F @skip(if: true) }",
+			want: []token{
+				{tokenName, "query", Location{1, 1}},
+				{tokenName, "Q", Location{1, 7}},
+				{tokenPunct, "(", Location{1, 8}},
+				{tokenPunct, "$", Location{1, 9}},
+				{tokenName, "id", Location{1, 10}},
+				{tokenPunct, ":", Location{1, 12}},
+				{tokenName, "ID", Location{1, 14}},
+				{tokenPunct, "!", Location{1, 16}},
+				{tokenPunct, ")", Location{1, 17}},
+				{tokenPunct, "{", Location{1, 19}},
+				{tokenPunct, "...", Location{1, 21}},
+				{tokenName, "F", Location{1, 24}},
+				{tokenPunct, "@", Location{1, 26}},
+				{tokenName, "skip", Location{1, 27}},
+				{tokenPunct, "(", Location{1, 31}},
+				{tokenName, "if", Location{1, 32}},
+				{tokenPunct, ":", Location{1, 34}},
+				{tokenName, "true", Location{1, 36}},
+				{tokenPunct, ")", Location{1, 40}},
+				{tokenPunct, "}", Location{1, 42}},
+			},
+		},
+		{
+			name: "ignored tokens",
+			src:  "\uFEFF a,,b # comment, c\n\t_d_1\r\n",
+			want: []token{
+				{tokenName, "a", Location{1, 5}},
+				{tokenName, "b", Location{1, 8}},
+				{tokenName, "_d_1", Location{2, 2}},
+			},
+		},
+		{
+			name: "numbers",
+			src:  "0 -12 3.25 -1e10 6.02E+23 1.5e-3",
+			want: []token{
+				{tokenInt, "0", Location{1, 1}},
+				{tokenInt, "-12", Location{1, 3}},
+				{tokenFloat, "3.25", Location{1, 7}},
+				{tokenFloat, "-1e10", Location{1, 12}},
+				{tokenFloat, "6.02E+23", Location{1, 18}},
+				{tokenFloat, "1.5e-3", Location{1, 27}},
+			},
+		},
+		{
+			name: "strings",
+			src:  `"" "a b" "\"\\\/\b\f\n\r\t" "\u00e9\u0041"`,
+			want: []token{
+				{tokenString, "", Location{1, 1}},
+				{tokenString, "a b", Location{1, 4}},
+				{tokenString, "\"\\/\b\f\n\r\t", Location{1, 10}},
+				{tokenString, "éA", Location{1, 29}},
+			},
+		},
+		{
+			name: "block strings",
+			src:  "\"\"\"\n    first\n      second\n    \\\"\"\" quoted\n  \"\"\" x",
+			want: []token{
+				{tokenString, "first\n  second\n\"\"\" quoted", Location{1, 1}},
+				{tokenName, "x", Location{5, 7}},
+			},
+		},
+		{
+			name: "empty document",
+			src:  " \n # only a comment",
+			want: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := lex(tt.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLexerErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		msg  string
+		want Location
+	}{
+		{"a ? b", "Unexpected character '?'", Location{1, 3}},
+		{"\n  é", "Unexpected character 'é'", Location{2, 3}},
+		{"12a", `Invalid number "12"`, Location{1, 1}},
+		{"1.", `Invalid number "1."`, Location{1, 1}},
+		{"1.5.2", `Invalid number "1.5"`, Location{1, 1}},
+		{"01", `Invalid number "01"`, Location{1, 1}},
+		{"-0.e1", `Invalid number "-0.e1"`, Location{1, 1}},
+		{"1e", `Invalid number "1e"`, Location{1, 1}},
+		{"-", `Invalid number "-"`, Location{1, 1}},
+		{`"abc`, "Unterminated string", Location{1, 1}},
+		{"\"ab\nc\"", "Unterminated string", Location{1, 1}},
+		{`"\`, "Unterminated string", Location{1, 1}},
+		{`"""abc`, "Unterminated string", Location{1, 1}},
+		{`"\x"`, `Invalid character escape sequence \x`, Location{1, 2}},
+		{`"\u00g0"`, "Invalid Unicode escape sequence", Location{1, 2}},
+		{`"\u00"`, "Invalid Unicode escape sequence", Location{1, 2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.src, func(t *testing.T) {
+			_, err := lex(tt.src)
+			e, ok := err.(*Error)
+			if !ok {
+				t.Fatalf("got error %v, want a syntax error", err)
+			}
+			if !strings.HasPrefix(e.Message, "Syntax Error: ") || !strings.Contains(e.Message, tt.msg) {
+				t.Errorf("got %q, want a syntax error containing %q", e.Message, tt.msg)
+			}
+			if !reflect.DeepEqual(e.Locations, []Location{tt.want}) {
+				t.Errorf("got locations %v, want %v", e.Locations, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,231 @@
+package graphql
+
+import (
+	"fmt"
+	"sort"
+)
+
+// listSize is the number of elements assumed for lists without a limit
+// argument when estimating the complexity of a query.
+const listSize = 10
+
+// validator checks an operation against the schema before it runs and
+// measures its depth and complexity.
+type validator struct {
+	schema *Schema
+	doc    *document
+	vars   map[string]interface{}
+	errors []*Error
+}
+
+// validate checks the selections of op and enforces the depth and
+// complexity limits of the schema.
+func (s *Schema) validate(doc *document, op *operation, root *Object, vars map[string]interface{}) []*Error {
+	v := &validator{schema: s, doc: doc, vars: vars}
+
+	// check the fragments in the order of the document, for the error
+	// to name the first fragment of a cycle
+	fragments := make([]*fragment, 0, len(doc.fragments))
+	for _, f := range doc.fragments {
+		fragments = append(fragments, f)
+	}
+	sort.Slice(fragments, func(i, j int) bool {
+		a, b := fragments[i].loc, fragments[j].loc
+		return a.Line < b.Line || (a.Line == b.Line && a.Column < b.Column)
+	})
+
+	for _, f := range fragments {
+		v.checkCycles(f, map[string]bool{})
+	}
+	if len(v.errors) > 0 {
+		return v.errors
+	}
+
+	depth, complexity := v.selections(root, op.selections)
+	if len(v.errors) > 0 {
+		return v.errors
+	}
+
+	if depth > s.MaxDepth {
+		v.errors = append(v.errors, &Error{
+			Message:   fmt.Sprintf("Query has depth %d, which exceeds the maximum depth of %d.", depth, s.MaxDepth),
+			Locations: []Location{op.loc},
+		})
+	}
+
+	if complexity > s.MaxComplexity {
+		v.errors = append(v.errors, &Error{
+			Message:   fmt.Sprintf("Query has complexity %d, which exceeds the maximum complexity of %d.", complexity, s.MaxComplexity),
+			Locations: []Location{op.loc},
+		})
+	}
+
+	return v.errors
+}
+
+func (v *validator) fail(loc Location, format string, args ...interface{}) {
+	v.errors = append(v.errors, &Error{
+		Message:   fmt.Sprintf(format, args...),
+		Locations: []Location{loc},
+	})
+}
+
+// checkCycles reports fragments that spread themselves.
+func (v *validator) checkCycles(f *fragment, path map[string]bool) {
+	if path[f.name] {
+		v.fail(f.loc, "Cannot spread fragment %q within itself.", f.name)
+		return
+	}
+
+	path[f.name] = true
+	defer delete(path, f.name)
+
+	var walk func(sels []selection)
+	walk = func(sels []selection) {
+		for _, sel := range sels {
+			switch sel := sel.(type) {
+			case *field:
+				walk(sel.selections)
+			case *inlineFragment:
+				walk(sel.selections)
+			case *fragmentSpread:
+				if next := v.doc.fragments[sel.name]; next != nil && len(v.errors) == 0 {
+					v.checkCycles(next, path)
+				}
+			}
+		}
+	}
+	walk(f.selections)
+}
+
+// selections checks the selections on an object of type t and returns
+// their depth and complexity.
+func (v *validator) selections(t *Object, sels []selection) (depth, complexity int) {
+	for _, sel := range sels {
+		var d, c int
+
+		switch sel := sel.(type) {
+		case *field:
+			v.directives(sel.directives)
+			d, c = v.field(t, sel)
+
+		case *fragmentSpread:
+			v.directives(sel.directives)
+
+			f := v.doc.fragments[sel.name]
+			if f == nil {
+				v.fail(sel.loc, "Unknown fragment %q.", sel.name)
+				continue
+			}
+
+			if !v.typeCondition(f.typeCond, t, sel.loc) {
+				continue
+			}
+			d, c = v.selections(t, f.selections)
+
+		case *inlineFragment:
+			v.directives(sel.directives)
+
+			if sel.typeCond != "" && !v.typeCondition(sel.typeCond, t, sel.loc) {
+				continue
+			}
+			d, c = v.selections(t, sel.selections)
+		}
+
+		if d > depth {
+			depth = d
+		}
+		complexity += c
+	}
+
+	return depth, complexity
+}
+
+// typeCondition checks that a fragment on the type named cond can be
+// spread on an object of type t.
+func (v *validator) typeCondition(cond string, t *Object, loc Location) bool {
+	if _, ok := v.schema.types[cond].(*Object); !ok {
+		v.fail(loc, "Unknown type %q.", cond)
+		return false
+	}
+
+	if cond != t.Name {
+		v.fail(loc, "Fragment on %q cannot be spread here as objects of type %q can never be of type %q.", cond, t.Name, cond)
+		return false
+	}
+
+	return true
+}
+
+func (v *validator) directives(dirs []*directive) {
+	for _, d := range dirs {
+		if d.name != "skip" && d.name != "include" {
+			v.fail(d.loc, "Unknown directive \"@%s\".", d.name)
+		}
+	}
+}
+
+// field checks a field selected on an object of type t and returns its
+// depth and complexity.
+func (v *validator) field(t *Object, f *field) (depth, complexity int) {
+	if f.name == "__typename" {
+		if f.selections != nil {
+			v.fail(f.loc, "Field \"__typename\" must not have a selection since type \"String!\" has no subfields.")
+		}
+		return 1, 1
+	}
+
+	def := t.Field(f.name)
+	if def == nil {
+		v.fail(f.loc, "Cannot query field %q on type %q.", f.name, t.Name)
+		return 0, 0
+	}
+
+	obj, composite := named(def.Type).(*Object)
+	if !composite {
+		if f.selections != nil {
+			v.fail(f.loc, "Field %q must not have a selection since type %q has no subfields.", f.name, def.Type)
+		}
+		return 1, 1
+	}
+
+	if f.selections == nil {
+		v.fail(f.loc, "Field %q of type %q must have a selection of subfields.", f.name, def.Type)
+		return 1, 1
+	}
+
+	depth, complexity = v.selections(obj, f.selections)
+	return depth + 1, 1 + v.multiplier(def, f)*complexity
+}
+
+// multiplier estimates the number of objects a field returns: one, or
+// the limit argument of lists.
+func (v *validator) multiplier(def *Field, f *field) int {
+	t := def.Type
+	if nn, ok := t.(*NonNull); ok {
+		t = nn.Of
+	}
+
+	if _, ok := t.(*List); !ok {
+		return 1
+	}
+
+	if limit := argDef(def.Args, "limit"); limit != nil {
+		args, err := coerceArgs([]*Argument{limit}, limitArg(f), v.vars)
+		if n, ok := args["limit"].(int); err == nil && ok && n > 0 {
+			return n
+		}
+	}
+
+	return listSize
+}
+
+func limitArg(f *field) []*argument {
+	for _, a := range f.args {
+		if a.name == "limit" {
+			return []*argument{a}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,72 @@
+package graphql
+
+import (
+	"context"
+	"sync"
+)
+
+// BatchFunc loads the values of keys at once. Keys missing from the
+// returned map have a null value.
+type BatchFunc func(ctx context.Context, keys []interface{}) (map[interface{}]interface{}, error)
+
+// Loader batches and caches the lookups of the resolvers, in the manner
+// of DataLoader. Resolvers return the thunk of Load, and the keys loaded
+// by all the fields of a level are fetched with one call to the batch
+// function when the first thunk runs. A loader must only be used for a
+// single request.
+type Loader struct {
+	fn BatchFunc
+
+	mu      sync.Mutex
+	pending []interface{}
+	queued  map[interface{}]bool
+	cache   map[interface{}]loaded
+}
+
+type loaded struct {
+	value interface{}
+	err   error
+}
+
+// NewLoader returns a loader fetching values with fn.
+func NewLoader(fn BatchFunc) *Loader {
+	return &Loader{
+		fn:     fn,
+		queued: map[interface{}]bool{},
+		cache:  map[interface{}]loaded{},
+	}
+}
+
+// Load queues key and returns a thunk returning its value.
+func (l *Loader) Load(ctx context.Context, key interface{}) Thunk {
+	l.mu.Lock()
+	if _, ok := l.cache[key]; !ok && !l.queued[key] {
+		l.queued[key] = true
+		l.pending = append(l.pending, key)
+	}
+	l.mu.Unlock()
+
+	return func() (interface{}, error) {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		if _, ok := l.cache[key]; !ok {
+			l.dispatch(ctx)
+		}
+
+		v := l.cache[key]
+		return v.value, v.err
+	}
+}
+
+// dispatch fetches the pending keys. It must be called with mu held.
+func (l *Loader) dispatch(ctx context.Context) {
+	keys := l.pending
+	l.pending = nil
+	l.queued = map[interface{}]bool{}
+
+	values, err := l.fn(ctx, keys)
+	for _, key := range keys {
+		l.cache[key] = loaded{value: values[key], err: err}
+	}
+}
@@ -0,0 +1,425 @@
+package graphql
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// document is a parsed GraphQL document.
+type document struct {
+	operations []*operation
+	fragments  map[string]*fragment
+}
+
+type operation struct {
+	kind       string // query or mutation
+	name       string
+	vars       []*varDef
+	selections []selection
+	loc        Location
+}
+
+type varDef struct {
+	name string
+	typ  *typeRef
+	def  interface{}
+	loc  Location
+}
+
+// typeRef is a reference to a type in a variable definition.
+type typeRef struct {
+	name    string
+	elem    *typeRef // list element, when name is empty
+	nonNull bool
+}
+
+func (t *typeRef) String() string {
+	s := t.name
+	if t.elem != nil {
+		s = "[" + t.elem.String() + "]"
+	}
+	if t.nonNull {
+		s += "!"
+	}
+
+	return s
+}
+
+type fragment struct {
+	name       string
+	typeCond   string
+	directives []*directive
+	selections []selection
+	loc        Location
+}
+
+type selection interface{}
+
+type field struct {
+	alias      string
+	name       string
+	args       []*argument
+	directives []*directive
+	selections []selection
+	loc        Location
+}
+
+// key is the name of the field in the response.
+func (f *field) key() string {
+	if f.alias != "" {
+		return f.alias
+	}
+
+	return f.name
+}
+
+type fragmentSpread struct {
+	name       string
+	directives []*directive
+	loc        Location
+}
+
+type inlineFragment struct {
+	typeCond   string
+	directives []*directive
+	selections []selection
+	loc        Location
+}
+
+type argument struct {
+	name  string
+	value interface{}
+	loc   Location
+}
+
+type directive struct {
+	name string
+	args []*argument
+	loc  Location
+}
+
+// Literal values are int64, float64, string, bool, nil, variable,
+// enumValue, []interface{} and map[string]interface{}.
+type (
+	variable  string
+	enumValue string
+)
+
+// parser is a recursive descent parser of executable documents.
+type parser struct {
+	lex *lexer
+	tok token
+}
+
+// parse parses the GraphQL document src.
+func parse(src string) (doc *document, err error) {
+	p := &parser{lex: newLexer(src)}
+
+	// syntax errors are raised as panics to keep the parser short
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(*Error)
+			if !ok {
+				panic(r)
+			}
+			doc, err = nil, e
+		}
+	}()
+
+	p.advance()
+	return p.document(), nil
+}
+
+func (p *parser) advance() {
+	tok, err := p.lex.next()
+	if err != nil {
+		panic(err)
+	}
+	p.tok = tok
+}
+
+func (p *parser) fail(format string, args ...interface{}) {
+	panic(&Error{
+		Message:   "Syntax Error: " + fmt.Sprintf(format, args...),
+		Locations: []Location{p.tok.loc},
+	})
+}
+
+// peek reports whether the current token is the punctuator or keyword s.
+func (p *parser) peek(s string) bool {
+	return (p.tok.kind == tokenPunct || p.tok.kind == tokenName) && p.tok.value == s
+}
+
+// skip consumes the current token if it is s.
+func (p *parser) skip(s string) bool {
+	if p.peek(s) {
+		p.advance()
+		return true
+	}
+
+	return false
+}
+
+func (p *parser) expect(s string) {
+	if !p.skip(s) {
+		p.fail("Expected %q, found %s.", s, p.tok)
+	}
+}
+
+func (p *parser) name() string {
+	if p.tok.kind != tokenName {
+		p.fail("Expected Name, found %s.", p.tok)
+	}
+
+	name := p.tok.value
+	p.advance()
+	return name
+}
+
+func (p *parser) document() *document {
+	doc := &document{fragments: map[string]*fragment{}}
+
+	for p.tok.kind != tokenEOF {
+		switch {
+		case p.peek("{"):
+			op := &operation{kind: "query", loc: p.tok.loc}
+			op.selections = p.selectionSet()
+			doc.operations = append(doc.operations, op)
+
+		case p.peek("query") || p.peek("mutation") || p.peek("subscription"):
+			doc.operations = append(doc.operations, p.operation())
+
+		case p.peek("fragment"):
+			f := p.fragment()
+			if _, ok := doc.fragments[f.name]; ok {
+				panic(&Error{
+					Message:   fmt.Sprintf("There can be only one fragment named %q.", f.name),
+					Locations: []Location{f.loc},
+				})
+			}
+			doc.fragments[f.name] = f
+
+		default:
+			p.fail("Unexpected %s.", p.tok)
+		}
+	}
+
+	if len(doc.operations) == 0 {
+		p.fail("Document has no operation.")
+	}
+
+	return doc
+}
+
+func (p *parser) operation() *operation {
+	op := &operation{kind: p.tok.value, loc: p.tok.loc}
+	p.advance()
+
+	if p.tok.kind == tokenName {
+		op.name = p.name()
+	}
+
+	if p.skip("(") {
+		for !p.skip(")") {
+			op.vars = append(op.vars, p.varDef())
+		}
+	}
+
+	p.directives()
+	op.selections = p.selectionSet()
+	return op
+}
+
+func (p *parser) varDef() *varDef {
+	v := &varDef{loc: p.tok.loc}
+
+	p.expect("$")
+	v.name = p.name()
+	p.expect(":")
+	v.typ = p.typeRef()
+
+	if p.skip("=") {
+		v.def = p.value(true)
+	}
+
+	p.directives()
+	return v
+}
+
+func (p *parser) typeRef() *typeRef {
+	t := &typeRef{}
+
+	if p.skip("[") {
+		t.elem = p.typeRef()
+		p.expect("]")
+	} else {
+		t.name = p.name()
+	}
+
+	t.nonNull = p.skip("!")
+	return t
+}
+
+func (p *parser) fragment() *fragment {
+	f := &fragment{loc: p.tok.loc}
+	p.expect("fragment")
+
+	if p.peek("on") {
+		p.fail("Unexpected Name \"on\".")
+	}
+	f.name = p.name()
+
+	p.expect("on")
+	f.typeCond = p.name()
+	f.directives = p.directives()
+	f.selections = p.selectionSet()
+	return f
+}
+
+func (p *parser) selectionSet() []selection {
+	p.expect("{")
+
+	var sels []selection
+	for !p.peek("}") {
+		sels = append(sels, p.selection())
+	}
+
+	if len(sels) == 0 {
+		p.fail("Expected Name, found \"}\".")
+	}
+
+	p.advance()
+	return sels
+}
+
+func (p *parser) selection() selection {
+	loc := p.tok.loc
+
+	if !p.skip("...") {
+		return p.field()
+	}
+
+	if p.tok.kind == tokenName && p.tok.value != "on" {
+		return &fragmentSpread{name: p.name(), directives: p.directives(), loc: loc}
+	}
+
+	f := &inlineFragment{loc: loc}
+	if p.skip("on") {
+		f.typeCond = p.name()
+	}
+	f.directives = p.directives()
+	f.selections = p.selectionSet()
+	return f
+}
+
+func (p *parser) field() *field {
+	f := &field{loc: p.tok.loc}
+	f.name = p.name()
+
+	if p.skip(":") {
+		f.alias, f.name = f.name, p.name()
+	}
+
+	f.args = p.arguments(false)
+	f.directives = p.directives()
+
+	if p.peek("{") {
+		f.selections = p.selectionSet()
+	}
+
+	return f
+}
+
+func (p *parser) arguments(constant bool) []*argument {
+	if !p.skip("(") {
+		return nil
+	}
+
+	var args []*argument
+	for !p.skip(")") {
+		a := &argument{loc: p.tok.loc}
+		a.name = p.name()
+		p.expect(":")
+		a.value = p.value(constant)
+		args = append(args, a)
+	}
+
+	return args
+}
+
+func (p *parser) directives() []*directive {
+	var dirs []*directive
+
+	for p.peek("@") {
+		d := &directive{loc: p.tok.loc}
+		p.advance()
+		d.name = p.name()
+		d.args = p.arguments(false)
+		dirs = append(dirs, d)
+	}
+
+	return dirs
+}
+
+// value parses a literal value; variables are not allowed in constant
+// values.
+func (p *parser) value(constant bool) interface{} {
+	tok := p.tok
+
+	switch tok.kind {
+	case tokenInt:
+		p.advance()
+		n, err := strconv.ParseInt(tok.value, 10, 64)
+		if err != nil {
+			f, _ := strconv.ParseFloat(tok.value, 64)
+			return f
+		}
+		return n
+
+	case tokenFloat:
+		p.advance()
+		f, _ := strconv.ParseFloat(tok.value, 64)
+		return f
+
+	case tokenString:
+		p.advance()
+		return tok.value
+
+	case tokenName:
+		p.advance()
+		switch tok.value {
+		case "true":
+			return true
+		case "false":
+			return false
+		case "null":
+			return nil
+		}
+		return enumValue(tok.value)
+	}
+
+	switch {
+	case p.peek("$") && !constant:
+		p.advance()
+		return variable(p.name())
+
+	case p.skip("["):
+		list := []interface{}{}
+		for !p.skip("]") {
+			list = append(list, p.value(constant))
+		}
+		return list
+
+	case p.skip("{"):
+		obj := map[string]interface{}{}
+		for !p.skip("}") {
+			name := p.name()
+			p.expect(":")
+			obj[name] = p.value(constant)
+		}
+		return obj
+	}
+
+	p.fail("Unexpected %s.", tok)
+	return nil
+}
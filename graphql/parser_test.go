@@ -0,0 +1,224 @@
+package graphql
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want *document
+	}{
+		{
+			name: "shorthand query",
+			src:  "{ a }",
+			want: &document{
+				operations: []*operation{{
+					kind:       "query",
+					selections: []selection{&field{name: "a", loc: Location{1, 3}}},
+					loc:        Location{1, 1},
+				}},
+				fragments: map[string]*fragment{},
+			},
+		},
+		{
+			name: "operation with variables",
+			src:  `query Q($id: ID!, $tags: [String!] = ["a"]) { p: product(id: $id) { name } }`,
+			want: &document{
+				operations: []*operation{{
+					kind: "query",
+					name: "Q",
+					vars: []*varDef{
+						{name: "id", typ: &typeRef{name: "ID", nonNull: true}, loc: Location{1, 9}},
+						{
+							name: "tags",
+							typ:  &typeRef{elem: &typeRef{name: "String", nonNull: true}},
+							def:  []interface{}{"a"},
+							loc:  Location{1, 19},
+						},
+					},
+					selections: []selection{&field{
+						alias:      "p",
+						name:       "product",
+						args:       []*argument{{name: "id", value: variable("id"), loc: Location{1, 58}}},
+						selections: []selection{&field{name: "name", loc: Location{1, 69}}},
+						loc:        Location{1, 47},
+					}},
+					loc: Location{1, 1},
+				}},
+				fragments: map[string]*fragment{},
+			},
+		},
+		{
+			name: "fragments and directives",
+			src:  "mutation { ...F @include(if: $x) ... on Product { id } ... @skip(if: true) { id } } fragment F on Mutation { id }",
+			want: &document{
+				operations: []*operation{{
+					kind: "mutation",
+					selections: []selection{
+						&fragmentSpread{
+							name: "F",
+							directives: []*directive{{
+								name: "include",
+								args: []*argument{{name: "if", value: variable("x"), loc: Location{1, 26}}},
+								loc:  Location{1, 17},
+							}},
+							loc: Location{1, 12},
+						},
+						&inlineFragment{
+							typeCond:   "Product",
+							selections: []selection{&field{name: "id", loc: Location{1, 51}}},
+							loc:        Location{1, 34},
+						},
+						&inlineFragment{
+							directives: []*directive{{
+								name: "skip",
+								args: []*argument{{name: "if", value: true, loc: Location{1, 66}}},
+								loc:  Location{1, 60},
+							}},
+							selections: []selection{&field{name: "id", loc: Location{1, 78}}},
+							loc:        Location{1, 56},
+						},
+					},
+					loc: Location{1, 1},
+				}},
+				fragments: map[string]*fragment{
+					"F": {
+						name:       "F",
+						typeCond:   "Mutation",
+						selections: []selection{&field{name: "id", loc: Location{1, 110}}},
+						loc:        Location{1, 85},
+					},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := parse(tt.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(doc, tt.want) {
+				t.Errorf("got %s, want %s", dump(doc), dump(tt.want))
+			}
+		})
+	}
+}
+
+// TestParseValues checks the literal values of the arguments.
+func TestParseValues(t *testing.T) {
+	tests := []struct {
+		src  string
+		want interface{}
+	}{
+		{"1", int64(1)},
+		{"-7", int64(-7)},
+		{"99999999999999999999", 1e20},
+		{"1.5", 1.5},
+		{"2e3", 2000.0},
+		{`"s"`, "s"},
+		{`"""block"""`, "block"},
+		{"true", true},
+		{"false", false},
+		{"null", nil},
+		{"RED", enumValue("RED")},
+		{"$v", variable("v")},
+		{"[]", []interface{}{}},
+		{"[1, [true], $v]", []interface{}{int64(1), []interface{}{true}, variable("v")}},
+		{"{}", map[string]interface{}{}},
+		{`{a: 1, b: {c: "d"}}`, map[string]interface{}{"a": int64(1), "b": map[string]interface{}{"c": "d"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.src, func(t *testing.T) {
+			doc, err := parse("{ f(v: " + tt.src + ") }")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := doc.operations[0].selections[0].(*field).args[0].value
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		msg  string
+		want Location
+	}{
+		{"", "Syntax Error: Document has no operation.", Location{1, 1}},
+		{"fragment F on Q { a }", "Syntax Error: Document has no operation.", Location{1, 22}},
+		{"{}", `Syntax Error: Expected Name, found "}".`, Location{1, 2}},
+		{"{ a", "Syntax Error: Expected Name, found <EOF>.", Location{1, 4}},
+		{"{ a(b) }", `Syntax Error: Expected ":", found ).`, Location{1, 6}},
+		{"{ a(b: ) }", "Syntax Error: Unexpected ).", Location{1, 8}},
+		{"{ a: }", `Syntax Error: Expected Name, found }.`, Location{1, 6}},
+		{"type Q { a }", `Syntax Error: Unexpected type.`, Location{1, 1}},
+		{"query ($a: ) { a }", "Syntax Error: Expected Name, found ).", Location{1, 12}},
+		{"query ($a: Int = $b) { a }", "Syntax Error: Unexpected $.", Location{1, 18}},
+		{"query ($a: [Int) { a }", `Syntax Error: Expected "]", found ).`, Location{1, 16}},
+		{"fragment on on Q { a }", `Syntax Error: Unexpected Name "on".`, Location{1, 10}},
+		{"fragment F Q { a }", `Syntax Error: Expected "on", found Q.`, Location{1, 12}},
+		{"{ ...F } fragment F on Q { a } fragment F on Q { b }", `There can be only one fragment named "F".`, Location{1, 32}},
+		{"{ a(b: [1 }", "Syntax Error: Unexpected }.", Location{1, 11}},
+		{`{ a(b: "x) }`, "Syntax Error: Unterminated string.", Location{1, 8}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.src, func(t *testing.T) {
+			_, err := parse(tt.src)
+			e, ok := err.(*Error)
+			if !ok {
+				t.Fatalf("got error %v, want a GraphQL error", err)
+			}
+			if e.Message != tt.msg {
+				t.Errorf("got %q, want %q", e.Message, tt.msg)
+			}
+			if !reflect.DeepEqual(e.Locations, []Location{tt.want}) {
+				t.Errorf("got locations %v, want %v", e.Locations, tt.want)
+			}
+		})
+	}
+}
+
+// dump formats the selections of doc for the failures of the tests.
+func dump(doc *document) string {
+	var sb strings.Builder
+	for _, op := range doc.operations {
+		sb.WriteString(op.kind + " " + op.name + " ")
+		dumpSelections(&sb, op.selections)
+	}
+	for name, f := range doc.fragments {
+		sb.WriteString(" fragment " + name + " ")
+		dumpSelections(&sb, f.selections)
+	}
+	return sb.String()
+}
+
+func dumpSelections(sb *strings.Builder, sels []selection) {
+	sb.WriteString("{")
+	for _, sel := range sels {
+		switch sel := sel.(type) {
+		case *field:
+			sb.WriteString(" " + sel.key())
+			if sel.selections != nil {
+				dumpSelections(sb, sel.selections)
+			}
+		case *fragmentSpread:
+			sb.WriteString(" ..." + sel.name)
+		case *inlineFragment:
+			sb.WriteString(" ... on " + sel.typeCond)
+			dumpSelections(sb, sel.selections)
+		}
+	}
+	sb.WriteString(" }")
+}
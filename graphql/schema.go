@@ -0,0 +1,370 @@
+// Package graphql implements a small GraphQL server: a parser for
+// queries and mutations, an executor resolving fields level by level so
+// that loaders can batch the lookups of a whole level, and limits on the
+// depth and complexity of the queries.
+//
+// Only object, scalar and input object types are supported; interfaces,
+// unions, subscriptions and the introspection schema are not.
+package graphql
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// Type is a GraphQL type: a *Scalar, *Object, *InputObject, *List or
+// *NonNull.
+type Type interface {
+	String() string
+}
+
+// Scalar is a leaf type.
+type Scalar struct {
+	Name        string
+	Description string
+
+	// Serialize converts a resolved value to its JSON representation,
+	// returning nil if it is not valid.
+	Serialize func(v interface{}) interface{}
+
+	// Parse converts an input value (a literal or a decoded JSON variable)
+	// to the value seen by the resolvers.
+	Parse func(v interface{}) (interface{}, error)
+}
+
+func (s *Scalar) String() string { return s.Name }
+
+// Object is a type with fields.
+type Object struct {
+	Name        string
+	Description string
+	Fields      []*Field
+}
+
+func (o *Object) String() string { return o.Name }
+
+// Field returns the field of o named name, or nil.
+func (o *Object) Field(name string) *Field {
+	for _, f := range o.Fields {
+		if f.Name == name {
+			return f
+		}
+	}
+
+	return nil
+}
+
+// ResolveParams are the arguments of a ResolveFunc.
+type ResolveParams struct {
+	Context context.Context
+
+	// Source is the value of the object the field belongs to.
+	Source interface{}
+
+	// Args are the coerced arguments of the field.
+	Args map[string]interface{}
+}
+
+// ResolveFunc resolves the value of a field. It may return a Thunk to
+// defer the lookup until all the fields of the level were resolved.
+type ResolveFunc func(p ResolveParams) (interface{}, error)
+
+// Field is a field of an Object.
+type Field struct {
+	Name        string
+	Description string
+	Type        Type
+	Args        []*Argument
+
+	// Resolve resolves the field. When nil, the field is read from the
+	// source value: the map key or the struct field with the JSON name
+	// of the field.
+	Resolve ResolveFunc
+}
+
+// Argument is an argument of a field.
+type Argument struct {
+	Name        string
+	Description string
+	Type        Type
+	Default     interface{}
+}
+
+// InputObject is a type of argument with fields.
+type InputObject struct {
+	Name        string
+	Description string
+	Fields      []*Argument
+}
+
+func (o *InputObject) String() string { return o.Name }
+
+// List is a list of values of type Of.
+type List struct {
+	Of Type
+}
+
+func (l *List) String() string { return "[" + l.Of.String() + "]" }
+
+// NonNull is a type that can not be null.
+type NonNull struct {
+	Of Type
+}
+
+func (n *NonNull) String() string { return n.Of.String() + "!" }
+
+// ListOf returns the list type of t.
+func ListOf(t Type) *List { return &List{Of: t} }
+
+// NonNullOf returns the non-null type of t.
+func NonNullOf(t Type) *NonNull { return &NonNull{Of: t} }
+
+// named returns the named type wrapped by t.
+func named(t Type) Type {
+	for {
+		switch w := t.(type) {
+		case *List:
+			t = w.Of
+		case *NonNull:
+			t = w.Of
+		default:
+			return t
+		}
+	}
+}
+
+// Schema is a GraphQL schema.
+type Schema struct {
+	Query    *Object
+	Mutation *Object
+
+	// MaxDepth is the maximum nesting of the fields of an operation.
+	// Zero means DefaultMaxDepth.
+	MaxDepth int
+
+	// MaxComplexity is the maximum complexity of an operation, the number
+	// of fields it may resolve. Zero means DefaultMaxComplexity.
+	MaxComplexity int
+
+	types map[string]Type
+}
+
+// default limits of a schema
+const (
+	DefaultMaxDepth      = 10
+	DefaultMaxComplexity = 1000
+)
+
+// NewSchema checks the types reachable from query and mutation and
+// returns the schema.
+func NewSchema(s Schema) (*Schema, error) {
+	if s.Query == nil {
+		return nil, fmt.Errorf("graphql: schema has no query type")
+	}
+
+	if s.MaxDepth <= 0 {
+		s.MaxDepth = DefaultMaxDepth
+	}
+
+	if s.MaxComplexity <= 0 {
+		s.MaxComplexity = DefaultMaxComplexity
+	}
+
+	s.types = map[string]Type{}
+	for _, t := range []Type{Int, Float, String, Boolean, ID, s.Query} {
+		if err := s.add(t); err != nil {
+			return nil, err
+		}
+	}
+
+	if s.Mutation != nil {
+		if err := s.add(s.Mutation); err != nil {
+			return nil, err
+		}
+	}
+
+	return &s, nil
+}
+
+// add adds t and the types it references to the types of the schema.
+func (s *Schema) add(t Type) error {
+	t = named(t)
+
+	if prev, ok := s.types[t.String()]; ok {
+		if prev != t {
+			return fmt.Errorf("graphql: two types are named %q", t)
+		}
+		return nil
+	}
+	s.types[t.String()] = t
+
+	switch t := t.(type) {
+	case *Object:
+		for _, f := range t.Fields {
+			if err := s.add(f.Type); err != nil {
+				return err
+			}
+
+			for _, a := range f.Args {
+				if err := s.add(a.Type); err != nil {
+					return err
+				}
+			}
+		}
+
+	case *InputObject:
+		for _, f := range t.Fields {
+			if err := s.add(f.Type); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// built-in scalars
+var (
+	Int = &Scalar{
+		Name:        "Int",
+		Description: "A signed 32-bit integer.",
+		Serialize: func(v interface{}) interface{} {
+			if f, ok := toFloat(v); ok && f == math.Trunc(f) {
+				return int64(f)
+			}
+			return nil
+		},
+		Parse: func(v interface{}) (interface{}, error) {
+			f, ok := toFloat(v)
+			if !ok || f != math.Trunc(f) || f > math.MaxInt32 || f < math.MinInt32 {
+				return nil, fmt.Errorf("Int cannot represent %s", describe(v))
+			}
+			return int(f), nil
+		},
+	}
+
+	Float = &Scalar{
+		Name:        "Float",
+		Description: "A double-precision floating-point number.",
+		Serialize: func(v interface{}) interface{} {
+			if f, ok := toFloat(v); ok {
+				return f
+			}
+			return nil
+		},
+		Parse: func(v interface{}) (interface{}, error) {
+			f, ok := toFloat(v)
+			if !ok {
+				return nil, fmt.Errorf("Float cannot represent %s", describe(v))
+			}
+			return f, nil
+		},
+	}
+
+	String = &Scalar{
+		Name:        "String",
+		Description: "A UTF-8 character sequence.",
+		Serialize: func(v interface{}) interface{} {
+			if s, ok := v.(string); ok {
+				return s
+			}
+			return fmt.Sprint(v)
+		},
+		Parse: func(v interface{}) (interface{}, error) {
+			s, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("String cannot represent %s", describe(v))
+			}
+			return s, nil
+		},
+	}
+
+	Boolean = &Scalar{
+		Name:        "Boolean",
+		Description: "true or false.",
+		Serialize: func(v interface{}) interface{} {
+			if b, ok := v.(bool); ok {
+				return b
+			}
+			return nil
+		},
+		Parse: func(v interface{}) (interface{}, error) {
+			b, ok := v.(bool)
+			if !ok {
+				return nil, fmt.Errorf("Boolean cannot represent %s", describe(v))
+			}
+			return b, nil
+		},
+	}
+
+	// ID is serialized as a string and accepts strings and integers.
+	ID = &Scalar{
+		Name:        "ID",
+		Description: "A unique identifier, serialized as a string.",
+		Serialize: func(v interface{}) interface{} {
+			if f, ok := toFloat(v); ok {
+				return strconv.FormatFloat(f, 'f', -1, 64)
+			}
+			return fmt.Sprint(v)
+		},
+		Parse: func(v interface{}) (interface{}, error) {
+			if s, ok := v.(string); ok {
+				return s, nil
+			}
+			if f, ok := toFloat(v); ok && f == math.Trunc(f) {
+				return strconv.FormatFloat(f, 'f', -1, 64), nil
+			}
+			return nil, fmt.Errorf("ID cannot represent %s", describe(v))
+		},
+	}
+)
+
+// toFloat converts the Go numbers to float64.
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int8:
+		return float64(n), true
+	case int16:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint:
+		return float64(n), true
+	case uint8:
+		return float64(n), true
+	case uint16:
+		return float64(n), true
+	case uint32:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case float32:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+
+	return 0, false
+}
+
+// describe describes an input value in error messages.
+func describe(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return strconv.Quote(v)
+	case map[string]interface{}:
+		return "an object"
+	case []interface{}:
+		return "a list"
+	}
+
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,99 @@
+package graphql
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// SDL returns the schema in the GraphQL schema definition language.
+func (s *Schema) SDL() string {
+	var sb strings.Builder
+
+	names := make([]string, 0, len(s.types))
+	for name, t := range s.types {
+		switch t {
+		case Int, Float, String, Boolean, ID, s.Query, s.Mutation:
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	types := []Type{s.Query}
+	if s.Mutation != nil {
+		types = append(types, s.Mutation)
+	}
+	for _, name := range names {
+		types = append(types, s.types[name])
+	}
+
+	for i, t := range types {
+		if i > 0 {
+			sb.WriteString("\n")
+		}
+		writeType(&sb, t)
+	}
+
+	return sb.String()
+}
+
+func writeType(sb *strings.Builder, t Type) {
+	switch t := t.(type) {
+	case *Scalar:
+		writeDescription(sb, "", t.Description)
+		fmt.Fprintf(sb, "scalar %s\n", t.Name)
+
+	case *Object:
+		writeDescription(sb, "", t.Description)
+		fmt.Fprintf(sb, "type %s {\n", t.Name)
+		for _, f := range t.Fields {
+			writeDescription(sb, "  ", f.Description)
+			fmt.Fprintf(sb, "  %s%s: %s\n", f.Name, arguments(f.Args), f.Type)
+		}
+		sb.WriteString("}\n")
+
+	case *InputObject:
+		writeDescription(sb, "", t.Description)
+		fmt.Fprintf(sb, "input %s {\n", t.Name)
+		for _, f := range t.Fields {
+			writeDescription(sb, "  ", f.Description)
+			fmt.Fprintf(sb, "  %s\n", argumentDef(f))
+		}
+		sb.WriteString("}\n")
+	}
+}
+
+func writeDescription(sb *strings.Builder, indent, desc string) {
+	if desc == "" {
+		return
+	}
+
+	d, _ := json.Marshal(desc)
+	fmt.Fprintf(sb, "%s%s\n", indent, d)
+}
+
+func arguments(args []*Argument) string {
+	if len(args) == 0 {
+		return ""
+	}
+
+	list := make([]string, len(args))
+	for i, a := range args {
+		list[i] = argumentDef(a)
+	}
+
+	return "(" + strings.Join(list, ", ") + ")"
+}
+
+func argumentDef(a *Argument) string {
+	s := a.Name + ": " + a.Type.String()
+
+	if a.Default != nil {
+		d, _ := json.Marshal(a.Default)
+		s += " = " + string(d)
+	}
+
+	return s
+}
@@ -0,0 +1,235 @@
+package graphql
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Error is an error of a request, located in the document or in the
+// response.
+type Error struct {
+	Message   string        `json:"message"`
+	Locations []Location    `json:"locations,omitempty"`
+	Path      []interface{} `json:"path,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// coerceVariables coerces the variables of op given in the request.
+func (s *Schema) coerceVariables(op *operation, given map[string]interface{}) (map[string]interface{}, []*Error) {
+	vars := map[string]interface{}{}
+	var errs []*Error
+
+	for _, def := range op.vars {
+		t, err := s.inputType(def.typ)
+		if err != nil {
+			errs = append(errs, &Error{Message: err.Error(), Locations: []Location{def.loc}})
+			continue
+		}
+
+		v, ok := given[def.name]
+		if !ok {
+			if def.def == nil {
+				if _, required := t.(*NonNull); required {
+					errs = append(errs, &Error{
+						Message:   fmt.Sprintf("Variable \"$%s\" of required type %q was not provided.", def.name, t),
+						Locations: []Location{def.loc},
+					})
+				}
+				continue
+			}
+			v = def.def
+		}
+
+		c, err := coerce(t, v, nil)
+		if err != nil {
+			errs = append(errs, &Error{
+				Message:   fmt.Sprintf("Variable \"$%s\" got invalid value %s; %s", def.name, describe(v), err),
+				Locations: []Location{def.loc},
+			})
+			continue
+		}
+		vars[def.name] = c
+	}
+
+	return vars, errs
+}
+
+// inputType returns the schema type referenced in a variable definition.
+func (s *Schema) inputType(ref *typeRef) (Type, error) {
+	var t Type
+
+	if ref.elem != nil {
+		elem, err := s.inputType(ref.elem)
+		if err != nil {
+			return nil, err
+		}
+		t = ListOf(elem)
+	} else {
+		switch named := s.types[ref.name].(type) {
+		case *Scalar:
+			t = named
+		case *InputObject:
+			t = named
+		case nil:
+			return nil, fmt.Errorf("Unknown type %q.", ref.name)
+		default:
+			return nil, fmt.Errorf("Variable cannot be of non-input type %q.", ref.name)
+		}
+	}
+
+	if ref.nonNull {
+		t = NonNullOf(t)
+	}
+
+	return t, nil
+}
+
+// coerceArgs coerces the arguments of a field.
+func coerceArgs(defs []*Argument, args []*argument, vars map[string]interface{}) (map[string]interface{}, error) {
+	values := map[string]interface{}{}
+
+	for _, a := range args {
+		if argDef(defs, a.name) == nil {
+			return nil, fmt.Errorf("Unknown argument %q.", a.name)
+		}
+	}
+
+	for _, def := range defs {
+		var a *argument
+		for _, arg := range args {
+			if arg.name == def.Name {
+				a = arg
+			}
+		}
+
+		// an argument set to a variable that was not provided is missing
+		provided := a != nil
+		if a != nil {
+			if name, ok := a.value.(variable); ok {
+				_, provided = vars[string(name)]
+			}
+		}
+
+		if !provided {
+			if def.Default != nil {
+				values[def.Name] = def.Default
+			} else if _, required := def.Type.(*NonNull); required {
+				return nil, fmt.Errorf("Argument %q of type %q is required, but it was not provided.", def.Name, def.Type)
+			}
+			continue
+		}
+
+		v, err := coerce(def.Type, a.value, vars)
+		if err != nil {
+			return nil, fmt.Errorf("Argument %q has invalid value %s; %s", def.Name, describe(a.value), err)
+		}
+		values[def.Name] = v
+	}
+
+	return values, nil
+}
+
+func argDef(defs []*Argument, name string) *Argument {
+	for _, def := range defs {
+		if def.Name == name {
+			return def
+		}
+	}
+
+	return nil
+}
+
+// coerce coerces the literal or variable value v to the input type t,
+// replacing the variables it contains.
+func coerce(t Type, v interface{}, vars map[string]interface{}) (interface{}, error) {
+	if name, ok := v.(variable); ok {
+		v = vars[string(name)]
+	}
+
+	if nn, ok := t.(*NonNull); ok {
+		if v == nil {
+			return nil, fmt.Errorf("Expected non-nullable type %q not to be null.", t)
+		}
+		return coerce(nn.Of, v, vars)
+	}
+
+	if v == nil {
+		return nil, nil
+	}
+
+	switch t := t.(type) {
+	case *List:
+		items, ok := v.([]interface{})
+		if !ok {
+			// a single value is coerced to a list of one value
+			item, err := coerce(t.Of, v, vars)
+			if err != nil {
+				return nil, err
+			}
+			return []interface{}{item}, nil
+		}
+
+		list := make([]interface{}, 0, len(items))
+		for i, item := range items {
+			c, err := coerce(t.Of, item, vars)
+			if err != nil {
+				return nil, fmt.Errorf("In element #%d: %s", i, err)
+			}
+			list = append(list, c)
+		}
+		return list, nil
+
+	case *InputObject:
+		fields, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("Expected type %q to be an object.", t)
+		}
+
+		var unknown []string
+		for name := range fields {
+			if argDef(t.Fields, name) == nil {
+				unknown = append(unknown, name)
+			}
+		}
+		if len(unknown) > 0 {
+			sort.Strings(unknown)
+			return nil, fmt.Errorf("Field %q is not defined by type %q.", strings.Join(unknown, ", "), t)
+		}
+
+		obj := map[string]interface{}{}
+		for _, def := range t.Fields {
+			fv, ok := fields[def.Name]
+			if name, isVar := fv.(variable); isVar {
+				_, ok = vars[string(name)]
+			}
+
+			if !ok {
+				if def.Default != nil {
+					obj[def.Name] = def.Default
+				} else if _, required := def.Type.(*NonNull); required {
+					return nil, fmt.Errorf("Field %q of required type %q was not provided.", def.Name, def.Type)
+				}
+				continue
+			}
+
+			c, err := coerce(def.Type, fv, vars)
+			if err != nil {
+				return nil, fmt.Errorf("In field %q: %s", def.Name, err)
+			}
+			obj[def.Name] = c
+		}
+		return obj, nil
+
+	case *Scalar:
+		if _, ok := v.(enumValue); ok {
+			return nil, fmt.Errorf("%s cannot represent %s", t, v)
+		}
+		return t.Parse(v)
+	}
+
+	return nil, fmt.Errorf("Type %q is not an input type.", t)
+}
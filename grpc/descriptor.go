@@ -0,0 +1,272 @@
+package grpc
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// File describes a .proto file: its messages, enums and services. It
+// is used to route calls and to serve the file through reflection.
+type File struct {
+	// Name is the path of the file, e.g. store/v1/store.proto.
+	Name    string
+	Package string
+
+	Messages []Message
+	Enums    []Enum
+	Services []*ServiceDesc
+}
+
+// Message names the message type of a Go struct.
+type Message struct {
+	// Name is the name of the message in the package of the file.
+	Name string
+
+	// Type is a value of the struct type, e.g. Product{}.
+	Type interface{}
+}
+
+// Enum names an enum type, a named int32 Go type. The name of an enum
+// nested in a message is prefixed by the message name, e.g.
+// HealthCheckResponse.ServingStatus.
+type Enum struct {
+	Name   string
+	Type   interface{}
+	Values []EnumValue
+}
+
+// EnumValue is a value of an enum.
+type EnumValue struct {
+	Name   string
+	Number int32
+}
+
+// the messages of google/protobuf/descriptor.proto needed to describe
+// a file
+type fileDescriptorProto struct {
+	Name        string                    `pb:"1"`
+	Package     string                    `pb:"2"`
+	MessageType []*descriptorProto        `pb:"4"`
+	EnumType    []*enumDescriptorProto    `pb:"5"`
+	Service     []*serviceDescriptorProto `pb:"6"`
+	Syntax      string                    `pb:"12"`
+}
+
+type descriptorProto struct {
+	Name       string                  `pb:"1"`
+	Field      []*fieldDescriptorProto `pb:"2"`
+	NestedType []*descriptorProto      `pb:"3"`
+	EnumType   []*enumDescriptorProto  `pb:"4"`
+}
+
+type fieldDescriptorProto struct {
+	Name     string `pb:"1"`
+	Number   int32  `pb:"3"`
+	Label    int32  `pb:"4"`
+	Type     int32  `pb:"5"`
+	TypeName string `pb:"6"`
+	JSONName string `pb:"10"`
+}
+
+type enumDescriptorProto struct {
+	Name  string                      `pb:"1"`
+	Value []*enumValueDescriptorProto `pb:"2"`
+}
+
+type enumValueDescriptorProto struct {
+	Name   string `pb:"1"`
+	Number *int32 `pb:"2"`
+}
+
+type serviceDescriptorProto struct {
+	Name   string                   `pb:"1"`
+	Method []*methodDescriptorProto `pb:"2"`
+}
+
+type methodDescriptorProto struct {
+	Name            string `pb:"1"`
+	InputType       string `pb:"2"`
+	OutputType      string `pb:"3"`
+	ClientStreaming bool   `pb:"5"`
+	ServerStreaming bool   `pb:"6"`
+}
+
+// field labels and types of FieldDescriptorProto
+const (
+	labelOptional = 1
+	labelRepeated = 3
+
+	typeDouble  = 1
+	typeFloat   = 2
+	typeInt64   = 3
+	typeUint64  = 4
+	typeInt32   = 5
+	typeBool    = 8
+	typeString  = 9
+	typeMessage = 11
+	typeBytes   = 12
+	typeUint32  = 13
+	typeEnum    = 14
+)
+
+// names maps the Go types of the messages and enums of the files known
+// to a server to their full names.
+type names map[reflect.Type]string
+
+func (n names) add(f *File) {
+	for _, m := range f.Messages {
+		n[messageType(m.Type)] = f.Package + "." + m.Name
+	}
+
+	for _, e := range f.Enums {
+		n[reflect.TypeOf(e.Type)] = f.Package + "." + e.Name
+	}
+}
+
+// messageName returns the full name of the message type of v.
+func (n names) messageName(v interface{}) string {
+	return n[messageType(v)]
+}
+
+// descriptor returns the encoded FileDescriptorProto of f.
+func (f *File) descriptor(n names) ([]byte, error) {
+	fd := &fileDescriptorProto{
+		Name:    f.Name,
+		Package: f.Package,
+		Syntax:  "proto3",
+	}
+
+	messages := map[string]*descriptorProto{}
+	for _, m := range f.Messages {
+		d, err := messageDescriptor(m, n)
+		if err != nil {
+			return nil, err
+		}
+
+		messages[m.Name] = d
+		if i := strings.LastIndex(m.Name, "."); i >= 0 {
+			parent := messages[m.Name[:i]]
+			if parent == nil {
+				return nil, fmt.Errorf("grpc: message %s is declared before its parent", m.Name)
+			}
+			d.Name = m.Name[i+1:]
+			parent.NestedType = append(parent.NestedType, d)
+		} else {
+			fd.MessageType = append(fd.MessageType, d)
+		}
+	}
+
+	for _, e := range f.Enums {
+		d := &enumDescriptorProto{Name: e.Name}
+		for _, v := range e.Values {
+			number := v.Number
+			d.Value = append(d.Value, &enumValueDescriptorProto{Name: v.Name, Number: &number})
+		}
+
+		if i := strings.LastIndex(e.Name, "."); i >= 0 {
+			parent := messages[e.Name[:i]]
+			if parent == nil {
+				return nil, fmt.Errorf("grpc: unknown parent message of enum %s", e.Name)
+			}
+			d.Name = e.Name[i+1:]
+			parent.EnumType = append(parent.EnumType, d)
+		} else {
+			fd.EnumType = append(fd.EnumType, d)
+		}
+	}
+
+	for _, s := range f.Services {
+		sd := &serviceDescriptorProto{Name: s.Name}
+		for _, m := range s.Methods {
+			sd.Method = append(sd.Method, &methodDescriptorProto{
+				Name:            m.Name,
+				InputType:       "." + n.messageName(m.Request),
+				OutputType:      "." + n.messageName(m.Response),
+				ClientStreaming: m.ClientStreaming,
+				ServerStreaming: m.ServerStreaming,
+			})
+		}
+		fd.Service = append(fd.Service, sd)
+	}
+
+	return Marshal(fd)
+}
+
+func messageDescriptor(m Message, n names) (*descriptorProto, error) {
+	d := &descriptorProto{Name: m.Name}
+
+	for _, f := range fieldsOf(messageType(m.Type)) {
+		fd := &fieldDescriptorProto{
+			Name:     f.name,
+			Number:   int32(f.num),
+			Label:    labelOptional,
+			JSONName: jsonName(f.name),
+		}
+
+		t := f.typ
+		if t.Kind() == reflect.Slice && t.Elem().Kind() != reflect.Uint8 {
+			fd.Label = labelRepeated
+			t = t.Elem()
+		}
+		if t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+
+		if name, ok := n[t]; ok {
+			fd.TypeName = "." + name
+			fd.Type = typeMessage
+			if t.Kind() != reflect.Struct {
+				fd.Type = typeEnum
+			}
+		} else {
+			fd.Type = scalarType(t)
+			if fd.Type == 0 {
+				return nil, fmt.Errorf("grpc: unsupported type %s of field %s.%s", t, m.Name, f.name)
+			}
+		}
+
+		d.Field = append(d.Field, fd)
+	}
+
+	return d, nil
+}
+
+func scalarType(t reflect.Type) int32 {
+	switch t.Kind() {
+	case reflect.Bool:
+		return typeBool
+	case reflect.Int32:
+		return typeInt32
+	case reflect.Int64:
+		return typeInt64
+	case reflect.Uint32:
+		return typeUint32
+	case reflect.Uint64:
+		return typeUint64
+	case reflect.Float32:
+		return typeFloat
+	case reflect.Float64:
+		return typeDouble
+	case reflect.String:
+		return typeString
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return typeBytes
+		}
+	}
+
+	return 0
+}
+
+// jsonName converts a snake_case field name to lowerCamelCase.
+func jsonName(name string) string {
+	parts := strings.Split(name, "_")
+	for i := 1; i < len(parts); i++ {
+		if parts[i] != "" {
+			parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
+		}
+	}
+
+	return strings.Join(parts, "")
+}
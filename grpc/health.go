@@ -0,0 +1,182 @@
+package grpc
+
+import (
+	"context"
+	"sync"
+)
+
+// HealthService is the name of the standard health checking service.
+const HealthService = "grpc.health.v1.Health"
+
+// ServingStatus is the status of a service reported by Health.
+type ServingStatus int32
+
+// the serving statuses of grpc.health.v1
+const (
+	StatusUnknown        ServingStatus = 0
+	StatusServing        ServingStatus = 1
+	StatusNotServing     ServingStatus = 2
+	StatusServiceUnknown ServingStatus = 3
+)
+
+// HealthCheckRequest is the request of the Check and Watch methods.
+type HealthCheckRequest struct {
+	Service string `pb:"1"`
+}
+
+// HealthCheckResponse is the response of the Check and Watch methods.
+type HealthCheckResponse struct {
+	Status ServingStatus `pb:"1"`
+}
+
+// Health implements the grpc.health.v1.Health service. The empty
+// service name is the status of the server as a whole.
+type Health struct {
+	mu       sync.Mutex
+	statuses map[string]ServingStatus
+	watchers map[string]map[chan ServingStatus]struct{}
+	shutdown bool
+}
+
+// NewHealth returns a Health reporting the server as serving.
+func NewHealth() *Health {
+	return &Health{
+		statuses: map[string]ServingStatus{"": StatusServing},
+		watchers: map[string]map[chan ServingStatus]struct{}{},
+	}
+}
+
+// SetServingStatus sets the status of service, notifying the watchers.
+// It is ignored after Shutdown.
+func (h *Health) SetServingStatus(service string, status ServingStatus) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.shutdown {
+		return
+	}
+
+	h.setLocked(service, status)
+}
+
+// Shutdown sets every service as not serving and ignores later status
+// changes, so clients stop sending calls while the server drains.
+func (h *Health) Shutdown() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.shutdown = true
+	for service := range h.statuses {
+		h.setLocked(service, StatusNotServing)
+	}
+}
+
+func (h *Health) setLocked(service string, status ServingStatus) {
+	h.statuses[service] = status
+
+	for ch := range h.watchers[service] {
+		// only the latest status matters to a watcher
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- status
+	}
+}
+
+// File returns the description of the service to register on a Server.
+func (h *Health) File() *File {
+	return &File{
+		Name:    "grpc/health/v1/health.proto",
+		Package: "grpc.health.v1",
+		Messages: []Message{
+			{Name: "HealthCheckRequest", Type: HealthCheckRequest{}},
+			{Name: "HealthCheckResponse", Type: HealthCheckResponse{}},
+		},
+		Enums: []Enum{
+			{
+				Name: "HealthCheckResponse.ServingStatus",
+				Type: StatusUnknown,
+				Values: []EnumValue{
+					{Name: "UNKNOWN", Number: int32(StatusUnknown)},
+					{Name: "SERVING", Number: int32(StatusServing)},
+					{Name: "NOT_SERVING", Number: int32(StatusNotServing)},
+					{Name: "SERVICE_UNKNOWN", Number: int32(StatusServiceUnknown)},
+				},
+			},
+		},
+		Services: []*ServiceDesc{
+			{
+				Name: HealthService,
+				Methods: []MethodDesc{
+					{
+						Name:     "Check",
+						Request:  &HealthCheckRequest{},
+						Response: &HealthCheckResponse{},
+						Unary:    h.check,
+					},
+					{
+						Name:            "Watch",
+						Request:         &HealthCheckRequest{},
+						Response:        &HealthCheckResponse{},
+						ServerStreaming: true,
+						Stream:          h.watch,
+					},
+				},
+			},
+		},
+	}
+}
+
+func (h *Health) check(ctx context.Context, req interface{}) (interface{}, error) {
+	service := req.(*HealthCheckRequest).Service
+
+	h.mu.Lock()
+	status, ok := h.statuses[service]
+	h.mu.Unlock()
+
+	if !ok {
+		return nil, Errorf(NotFound, "unknown service %q", service)
+	}
+
+	return &HealthCheckResponse{Status: status}, nil
+}
+
+func (h *Health) watch(ss *ServerStream) error {
+	req := &HealthCheckRequest{}
+	if err := ss.RecvMsg(req); err != nil {
+		return err
+	}
+
+	ch := make(chan ServingStatus, 1)
+
+	h.mu.Lock()
+	status, ok := h.statuses[req.Service]
+	if !ok {
+		status = StatusServiceUnknown
+	}
+	ch <- status
+
+	if h.watchers[req.Service] == nil {
+		h.watchers[req.Service] = map[chan ServingStatus]struct{}{}
+	}
+	h.watchers[req.Service][ch] = struct{}{}
+	h.mu.Unlock()
+
+	defer func() {
+		h.mu.Lock()
+		delete(h.watchers[req.Service], ch)
+		h.mu.Unlock()
+	}()
+
+	for {
+		select {
+		case status := <-ch:
+			if err := ss.SendMsg(&HealthCheckResponse{Status: status}); err != nil {
+				return err
+			}
+		case <-ss.Context().Done():
+			return ss.Context().Err()
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package grpc
+
+import (
+	"context"
+	"crypto/subtle"
+	"strings"
+	"time"
+
+	"github.com/imariom/products-api/logging"
+	"github.com/imariom/products-api/middleware"
+	"github.com/imariom/products-api/tracing"
+)
+
+// Logging attaches to every call a logger carrying its request ID (see
+// logging.FromContext) and writes an access log line once the call was
+// served, like middleware.Logging does for HTTP requests.
+func Logging(logger *logging.Logger) Interceptor {
+	// callLogger returns the logger of the call served with ctx
+	callLogger := func(ctx context.Context) *logging.Logger {
+		l := logger
+		if id := middleware.RequestIDFromContext(ctx); id != "" {
+			l = l.With("request_id", id)
+		}
+
+		if sc := tracing.SpanContextFromContext(ctx); sc.IsValid() {
+			l = l.With("trace_id", sc.TraceID.String())
+		}
+
+		return l
+	}
+
+	logCall := func(l *logging.Logger, ctx context.Context, info *MethodInfo, start time.Time, err error) {
+		code, msg := status(err)
+
+		kv := []interface{}{
+			"method", info.FullMethod,
+			"code", code.String(),
+			"duration", time.Since(start),
+			"remote", Peer(ctx),
+		}
+
+		if info.ClientStreaming || info.ServerStreaming {
+			kv = append(kv, "stream", true)
+		}
+
+		if ua := Metadata(ctx).Get("User-Agent"); ua != "" {
+			kv = append(kv, "user_agent", ua)
+		}
+
+		switch code {
+		case OK:
+			l.Info("rpc served", kv...)
+		case Unknown, Internal, DataLoss:
+			l.Error("rpc served", append(kv, "error", msg)...)
+		default:
+			l.Info("rpc served", append(kv, "error", msg)...)
+		}
+	}
+
+	return Interceptor{
+		Unary: func(ctx context.Context, req interface{}, info *MethodInfo, handler UnaryHandler) (interface{}, error) {
+			start := time.Now()
+
+			l := callLogger(ctx)
+			resp, err := handler(logging.NewContext(ctx, l), req)
+
+			logCall(l, ctx, info, start, err)
+			return resp, err
+		},
+		Stream: func(ss *ServerStream, info *MethodInfo, handler StreamHandler) error {
+			start := time.Now()
+
+			l := callLogger(ss.Context())
+			err := handler(ss.WithContext(logging.NewContext(ss.Context(), l)))
+
+			logCall(l, ss.Context(), info, start, err)
+			return err
+		},
+	}
+}
+
+// TokenAuth rejects the calls without an "authorization: Bearer <token>"
+// header carrying one of tokens with Unauthenticated. Calls to the
+// exempt services (full names, e.g. grpc.health.v1.Health) are always
+// allowed.
+func TokenAuth(tokens []string, exempt ...string) Interceptor {
+	authorize := func(ctx context.Context, info *MethodInfo) error {
+		for _, svc := range exempt {
+			if info.Service == svc {
+				return nil
+			}
+		}
+
+		auth := Metadata(ctx).Get("Authorization")
+		if len(auth) < len("Bearer ") || !strings.EqualFold(auth[:len("Bearer ")], "Bearer ") {
+			return Errorf(Unauthenticated, "missing bearer token")
+		}
+
+		token := []byte(strings.TrimSpace(auth[len("Bearer "):]))
+		for _, t := range tokens {
+			if subtle.ConstantTimeCompare(token, []byte(t)) == 1 {
+				return nil
+			}
+		}
+
+		return Errorf(Unauthenticated, "invalid bearer token")
+	}
+
+	return Interceptor{
+		Unary: func(ctx context.Context, req interface{}, info *MethodInfo, handler UnaryHandler) (interface{}, error) {
+			if err := authorize(ctx, info); err != nil {
+				return nil, err
+			}
+
+			return handler(ctx, req)
+		},
+		Stream: func(ss *ServerStream, info *MethodInfo, handler StreamHandler) error {
+			if err := authorize(ss.Context(), info); err != nil {
+				return err
+			}
+
+			return handler(ss)
+		},
+	}
+}
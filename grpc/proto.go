@@ -0,0 +1,453 @@
+package grpc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// ProtoTag is the struct tag giving the field number of a message field,
+// optionally followed by its name in the .proto file, e.g. `pb:"3"` or
+// `pb:"3,name=zip_code"`. The name defaults to the snake_case Go name.
+//
+// Messages are structs. Fields may be bools, 32 and 64-bit integers and
+// floats, strings, byte slices, pointers to messages, named int32 types
+// (enums) and slices of these (repeated fields). Pointers to scalars
+// are encoded when they are not nil, even with a zero value, which is
+// how members of a oneof are represented. Zero scalars are omitted, as
+// in proto3.
+const ProtoTag = "pb"
+
+// protobuf wire types
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+var errTruncated = errors.New("proto: truncated message")
+
+// fieldInfo describes a field of a message.
+type fieldInfo struct {
+	num   int
+	name  string
+	index int
+	typ   reflect.Type
+}
+
+var fieldCache sync.Map // reflect.Type -> []fieldInfo
+
+// fieldsOf returns the tagged fields of the message type t.
+func fieldsOf(t reflect.Type) []fieldInfo {
+	if fields, ok := fieldCache.Load(t); ok {
+		return fields.([]fieldInfo)
+	}
+
+	var fields []fieldInfo
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+
+		tag, ok := sf.Tag.Lookup(ProtoTag)
+		if !ok {
+			continue
+		}
+
+		parts := strings.Split(tag, ",")
+		num, err := strconv.Atoi(parts[0])
+		if err != nil || num <= 0 {
+			panic(fmt.Sprintf("proto: invalid field number in tag of %s.%s", t.Name(), sf.Name))
+		}
+
+		f := fieldInfo{num: num, name: snakeCase(sf.Name), index: i, typ: sf.Type}
+		for _, opt := range parts[1:] {
+			if strings.HasPrefix(opt, "name=") {
+				f.name = strings.TrimPrefix(opt, "name=")
+			}
+		}
+
+		fields = append(fields, f)
+	}
+
+	fieldCache.Store(t, fields)
+	return fields
+}
+
+// snakeCase converts a Go name to snake_case, keeping acronyms together
+// (ProductID is product_id).
+func snakeCase(name string) string {
+	runes := []rune(name)
+
+	var sb strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) && i > 0 &&
+			(unicode.IsLower(runes[i-1]) || (i+1 < len(runes) && unicode.IsLower(runes[i+1]))) {
+			sb.WriteByte('_')
+		}
+		sb.WriteRune(unicode.ToLower(r))
+	}
+
+	return sb.String()
+}
+
+// messageType returns the struct type of the message v, a struct or a
+// pointer to one.
+func messageType(v interface{}) reflect.Type {
+	t := reflect.TypeOf(v)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if t.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("proto: %s is not a message", t))
+	}
+
+	return t
+}
+
+// Marshal encodes the message v, a struct or a pointer to one.
+func Marshal(v interface{}) ([]byte, error) {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nil, nil
+		}
+		rv = rv.Elem()
+	}
+
+	if rv.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("proto: cannot marshal %s", rv.Type())
+	}
+
+	return appendMessage(nil, rv)
+}
+
+func appendMessage(b []byte, v reflect.Value) ([]byte, error) {
+	var err error
+
+	for _, f := range fieldsOf(v.Type()) {
+		b, err = appendField(b, f.num, v.Field(f.index))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return b, nil
+}
+
+func appendTag(b []byte, num, wire int) []byte {
+	return binary.AppendUvarint(b, uint64(num)<<3|uint64(wire))
+}
+
+func appendField(b []byte, num int, v reflect.Value) ([]byte, error) {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return b, nil
+		}
+
+		if v.Elem().Kind() == reflect.Struct {
+			msg, err := appendMessage(nil, v.Elem())
+			if err != nil {
+				return nil, err
+			}
+			b = appendTag(b, num, wireBytes)
+			b = binary.AppendUvarint(b, uint64(len(msg)))
+			return append(b, msg...), nil
+		}
+
+		// pointers to scalars are encoded even when zero
+		return appendScalar(b, num, v.Elem())
+
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			if v.Len() == 0 {
+				return b, nil
+			}
+			b = appendTag(b, num, wireBytes)
+			b = binary.AppendUvarint(b, uint64(v.Len()))
+			return append(b, v.Bytes()...), nil
+		}
+
+		if v.Len() == 0 {
+			return b, nil
+		}
+
+		// numeric repeated fields are packed
+		if wire, ok := scalarWire(v.Type().Elem()); ok && wire != wireBytes {
+			var packed []byte
+			for i := 0; i < v.Len(); i++ {
+				packed = appendValue(packed, v.Index(i))
+			}
+			b = appendTag(b, num, wireBytes)
+			b = binary.AppendUvarint(b, uint64(len(packed)))
+			return append(b, packed...), nil
+		}
+
+		var err error
+		for i := 0; i < v.Len(); i++ {
+			elem := v.Index(i)
+			if elem.Kind() == reflect.Struct {
+				elem = elem.Addr()
+			}
+
+			// elements of repeated fields are encoded even when zero
+			if elem.Kind() == reflect.Ptr && elem.IsNil() {
+				elem = reflect.New(elem.Type().Elem())
+			}
+			switch elem.Kind() {
+			case reflect.Slice:
+				b = appendTag(b, num, wireBytes)
+				b = binary.AppendUvarint(b, uint64(elem.Len()))
+				b = append(b, elem.Bytes()...)
+			case reflect.Ptr:
+				b, err = appendField(b, num, elem)
+			default:
+				b, err = appendScalar(b, num, elem)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+
+	case reflect.Struct:
+		if v.CanAddr() {
+			return appendField(b, num, v.Addr())
+		}
+		p := reflect.New(v.Type())
+		p.Elem().Set(v)
+		return appendField(b, num, p)
+	}
+
+	if v.IsZero() {
+		return b, nil
+	}
+
+	return appendScalar(b, num, v)
+}
+
+// scalarWire returns the wire type of the scalar type t.
+func scalarWire(t reflect.Type) (int, bool) {
+	switch t.Kind() {
+	case reflect.Bool, reflect.Int32, reflect.Int64, reflect.Uint32, reflect.Uint64:
+		return wireVarint, true
+	case reflect.Float32:
+		return wireFixed32, true
+	case reflect.Float64:
+		return wireFixed64, true
+	case reflect.String:
+		return wireBytes, true
+	}
+
+	return 0, false
+}
+
+func appendScalar(b []byte, num int, v reflect.Value) ([]byte, error) {
+	wire, ok := scalarWire(v.Type())
+	if !ok {
+		return nil, fmt.Errorf("proto: unsupported field type %s", v.Type())
+	}
+
+	b = appendTag(b, num, wire)
+	return appendValue(b, v), nil
+}
+
+// appendValue appends the scalar v without its tag.
+func appendValue(b []byte, v reflect.Value) []byte {
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			return append(b, 1)
+		}
+		return append(b, 0)
+	case reflect.Int32, reflect.Int64:
+		return binary.AppendUvarint(b, uint64(v.Int()))
+	case reflect.Uint32, reflect.Uint64:
+		return binary.AppendUvarint(b, v.Uint())
+	case reflect.Float32:
+		return binary.LittleEndian.AppendUint32(b, math.Float32bits(float32(v.Float())))
+	case reflect.Float64:
+		return binary.LittleEndian.AppendUint64(b, math.Float64bits(v.Float()))
+	case reflect.String:
+		b = binary.AppendUvarint(b, uint64(v.Len()))
+		return append(b, v.String()...)
+	}
+
+	return b
+}
+
+// Unmarshal decodes the message b into v, a pointer to a struct.
+// Unknown fields are skipped.
+func Unmarshal(b []byte, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("proto: Unmarshal needs a pointer to a struct, not %T", v)
+	}
+
+	return decodeMessage(b, rv.Elem())
+}
+
+func decodeMessage(b []byte, v reflect.Value) error {
+	fields := fieldsOf(v.Type())
+
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return errTruncated
+		}
+		b = b[n:]
+
+		num, wire := int(key>>3), int(key&7)
+
+		// read the raw value of the field
+		var raw []byte
+		var scalar uint64
+		switch wire {
+		case wireVarint:
+			scalar, n = binary.Uvarint(b)
+			if n <= 0 {
+				return errTruncated
+			}
+		case wireFixed64:
+			if len(b) < 8 {
+				return errTruncated
+			}
+			scalar, n = binary.LittleEndian.Uint64(b), 8
+		case wireFixed32:
+			if len(b) < 4 {
+				return errTruncated
+			}
+			scalar, n = uint64(binary.LittleEndian.Uint32(b)), 4
+		case wireBytes:
+			size, m := binary.Uvarint(b)
+			if m <= 0 || uint64(len(b)-m) < size {
+				return errTruncated
+			}
+			raw, n = b[m:m+int(size)], m+int(size)
+		default:
+			return fmt.Errorf("proto: unsupported wire type %d of field %d", wire, num)
+		}
+		b = b[n:]
+
+		for _, f := range fields {
+			if f.num != num {
+				continue
+			}
+
+			if err := decodeField(v.Field(f.index), wire, scalar, raw); err != nil {
+				return fmt.Errorf("proto: field %s.%s: %v", v.Type().Name(), f.name, err)
+			}
+			break
+		}
+	}
+
+	return nil
+}
+
+func decodeField(v reflect.Value, wire int, scalar uint64, raw []byte) error {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		if v.Elem().Kind() == reflect.Struct {
+			if wire != wireBytes {
+				return fmt.Errorf("wrong wire type %d", wire)
+			}
+			return decodeMessage(raw, v.Elem())
+		}
+		return decodeField(v.Elem(), wire, scalar, raw)
+
+	case reflect.Struct:
+		if wire != wireBytes {
+			return fmt.Errorf("wrong wire type %d", wire)
+		}
+		return decodeMessage(raw, v)
+
+	case reflect.Slice:
+		elemType := v.Type().Elem()
+		if elemType.Kind() == reflect.Uint8 {
+			if wire != wireBytes {
+				return fmt.Errorf("wrong wire type %d", wire)
+			}
+			v.SetBytes(append([]byte(nil), raw...))
+			return nil
+		}
+
+		// packed numeric values
+		if elemWire, ok := scalarWire(elemType); ok && elemWire != wireBytes && wire == wireBytes {
+			for len(raw) > 0 {
+				var n int
+				switch elemWire {
+				case wireVarint:
+					scalar, n = binary.Uvarint(raw)
+					if n <= 0 {
+						return errTruncated
+					}
+				case wireFixed64:
+					if len(raw) < 8 {
+						return errTruncated
+					}
+					scalar, n = binary.LittleEndian.Uint64(raw), 8
+				case wireFixed32:
+					if len(raw) < 4 {
+						return errTruncated
+					}
+					scalar, n = uint64(binary.LittleEndian.Uint32(raw)), 4
+				}
+				raw = raw[n:]
+
+				elem := reflect.New(elemType).Elem()
+				setScalar(elem, scalar, nil)
+				v.Set(reflect.Append(v, elem))
+			}
+			return nil
+		}
+
+		elem := reflect.New(elemType).Elem()
+		if err := decodeField(elem, wire, scalar, raw); err != nil {
+			return err
+		}
+		v.Set(reflect.Append(v, elem))
+		return nil
+	}
+
+	want, ok := scalarWire(v.Type())
+	if !ok {
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	if want != wire {
+		return fmt.Errorf("wrong wire type %d", wire)
+	}
+
+	setScalar(v, scalar, raw)
+	return nil
+}
+
+func setScalar(v reflect.Value, scalar uint64, raw []byte) {
+	switch v.Kind() {
+	case reflect.Bool:
+		v.SetBool(scalar != 0)
+	case reflect.Int32:
+		v.SetInt(int64(int32(scalar)))
+	case reflect.Int64:
+		v.SetInt(int64(scalar))
+	case reflect.Uint32:
+		v.SetUint(uint64(uint32(scalar)))
+	case reflect.Uint64:
+		v.SetUint(scalar)
+	case reflect.Float32:
+		v.SetFloat(float64(math.Float32frombits(uint32(scalar))))
+	case reflect.Float64:
+		v.SetFloat(math.Float64frombits(scalar))
+	case reflect.String:
+		v.SetString(string(raw))
+	}
+}
@@ -0,0 +1,271 @@
+package grpc
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// test messages, the first four are the examples of the encoding guide
+// of Protocol Buffers (https://protobuf.dev/programming-guides/encoding)
+type test1 struct {
+	A int32 `pb:"1"`
+}
+
+type test2 struct {
+	B string `pb:"2"`
+}
+
+type test3 struct {
+	C *test1 `pb:"3"`
+}
+
+type test4 struct {
+	D []int32 `pb:"4"`
+}
+
+type testEnum int32
+
+type scalars struct {
+	Bool    bool     `pb:"1"`
+	Int32   int32    `pb:"2"`
+	Int64   int64    `pb:"3"`
+	Uint32  uint32   `pb:"4"`
+	Uint64  uint64   `pb:"5"`
+	Float   float32  `pb:"6"`
+	Double  float64  `pb:"7"`
+	String  string   `pb:"8"`
+	Bytes   []byte   `pb:"9"`
+	Enum    testEnum `pb:"10"`
+	Large   uint32   `pb:"16"`
+	Present *int32   `pb:"17"`
+}
+
+type pair struct {
+	A int32  `pb:"1"`
+	B string `pb:"2"`
+}
+
+type repeated struct {
+	Strings  []string  `pb:"1"`
+	Messages []*pair   `pb:"2"`
+	Doubles  []float64 `pb:"3"`
+	Values   []pair    `pb:"4"`
+}
+
+type nested struct {
+	Pair *pair `pb:"3"`
+}
+
+// unhex decodes the hexadecimal bytes s, which may be separated by
+// spaces.
+func unhex(t *testing.T, s string) []byte {
+	t.Helper()
+
+	b, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func int32Ptr(v int32) *int32 { return &v }
+
+// TestMarshal checks the encoding of the messages against the wire
+// format, and that they are decoded back to the same values.
+func TestMarshal(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  interface{}
+		want string
+	}{
+		{"varint", &test1{A: 150}, "08 96 01"},
+		{"string", &test2{B: "testing"}, "12 07 74 65 73 74 69 6e 67"},
+		{"embedded message", &test3{C: &test1{A: 150}}, "1a 03 08 96 01"},
+		{"packed repeated", &test4{D: []int32{3, 270, 86942}}, "22 06 03 8e 02 9e a7 05"},
+
+		{"zero values omitted", &scalars{}, ""},
+		{"empty embedded message", &test3{C: &test1{}}, "1a 00"},
+		{"bool", &scalars{Bool: true}, "08 01"},
+		{"negative int32", &scalars{Int32: -1}, "10 ff ff ff ff ff ff ff ff ff 01"},
+		{"negative int64", &scalars{Int64: -2}, "18 fe ff ff ff ff ff ff ff ff 01"},
+		{"max uint32", &scalars{Uint32: math.MaxUint32}, "20 ff ff ff ff 0f"},
+		{"max uint64", &scalars{Uint64: math.MaxUint64}, "28 ff ff ff ff ff ff ff ff ff 01"},
+		{"float", &scalars{Float: 1.5}, "35 00 00 c0 3f"},
+		{"double", &scalars{Double: 1.5}, "39 00 00 00 00 00 00 f8 3f"},
+		{"bytes", &scalars{Bytes: []byte{1, 2}}, "4a 02 01 02"},
+		{"enum", &scalars{Enum: 2}, "50 02"},
+		{"field number above 15", &scalars{Large: 1}, "80 01 01"},
+		{"present zero", &scalars{Present: int32Ptr(0)}, "88 01 00"},
+
+		{"repeated strings", &repeated{Strings: []string{"a", "", "b"}}, "0a 01 61 0a 00 0a 01 62"},
+		{"repeated messages", &repeated{Messages: []*pair{{A: 1}, nil, {B: "x"}}}, "12 02 08 01 12 00 12 03 12 01 78"},
+		{"packed doubles", &repeated{Doubles: []float64{1.5, 0}}, "1a 10 00 00 00 00 00 00 f8 3f 00 00 00 00 00 00 00 00"},
+		{"repeated message values", &repeated{Values: []pair{{A: 1}}}, "22 02 08 01"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := Marshal(tt.msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := unhex(t, tt.want); !bytes.Equal(b, want) {
+				t.Fatalf("got % x, want % x", b, want)
+			}
+
+			got := reflect.New(reflect.TypeOf(tt.msg).Elem())
+			if err := Unmarshal(b, got.Interface()); err != nil {
+				t.Fatal(err)
+			}
+
+			// nil elements of repeated messages are decoded as empty ones
+			want := tt.msg
+			if r, ok := want.(*repeated); ok {
+				c := *r
+				c.Messages = nil
+				for _, m := range r.Messages {
+					if m == nil {
+						m = &pair{}
+					}
+					c.Messages = append(c.Messages, m)
+				}
+				want = &c
+			}
+			if !reflect.DeepEqual(got.Interface(), want) {
+				t.Errorf("decoded %+v, want %+v", got.Interface(), want)
+			}
+		})
+	}
+}
+
+// TestUnmarshal checks that the encodings other encoders may produce
+// are decoded as the specification requires.
+func TestUnmarshal(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		msg  interface{}
+		want interface{}
+	}{
+		{
+			name: "unknown fields of every wire type are skipped",
+			in:   "10 05 19 01 02 03 04 05 06 07 08 25 01 02 03 04 2a 01 78 08 96 01",
+			msg:  &test1{},
+			want: &test1{A: 150},
+		},
+		{
+			name: "last value wins",
+			in:   "08 01 08 02",
+			msg:  &test1{},
+			want: &test1{A: 2},
+		},
+		{
+			name: "unpacked repeated values",
+			in:   "20 03 20 8e 02",
+			msg:  &test4{},
+			want: &test4{D: []int32{3, 270}},
+		},
+		{
+			name: "packed and unpacked repeated values",
+			in:   "20 03 22 02 8e 02",
+			msg:  &test4{},
+			want: &test4{D: []int32{3, 270}},
+		},
+		{
+			name: "embedded messages are merged",
+			in:   "1a 02 08 01 1a 03 12 01 78",
+			msg:  &nested{},
+			want: &nested{Pair: &pair{A: 1, B: "x"}},
+		},
+		{
+			name: "int32 from a 64-bit varint",
+			in:   "10 ff ff ff ff ff ff ff ff ff 01",
+			msg:  &scalars{},
+			want: &scalars{Int32: -1},
+		},
+		{
+			name: "empty message",
+			in:   "",
+			msg:  &scalars{},
+			want: &scalars{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := Unmarshal(unhex(t, tt.in), tt.msg); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(tt.msg, tt.want) {
+				t.Errorf("got %+v, want %+v", tt.msg, tt.want)
+			}
+		})
+	}
+}
+
+// TestUnmarshalErrors checks that malformed messages are rejected.
+func TestUnmarshalErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		msg  interface{}
+	}{
+		{"truncated tag", "80", &test1{}},
+		{"truncated varint", "08 96", &test1{}},
+		{"truncated fixed64", "39 00 00", &scalars{}},
+		{"truncated fixed32", "35 00", &scalars{}},
+		{"truncated length", "12 07 74 65", &test2{}},
+		{"truncated packed value", "22 01 96", &test4{}},
+		{"group wire type", "0b 0c", &test1{}},
+		{"wrong wire type", "0a 00", &test1{}},
+		{"scalar for a message", "18 01", &test3{}},
+		{"truncated embedded message", "1a 02 08 96", &test3{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := Unmarshal(unhex(t, tt.in), tt.msg); err == nil {
+				t.Errorf("decoded %+v, want an error", tt.msg)
+			}
+		})
+	}
+}
+
+func TestUnmarshalNotAPointer(t *testing.T) {
+	for _, v := range []interface{}{test1{}, (*test1)(nil), new(int)} {
+		if err := Unmarshal(nil, v); err == nil {
+			t.Errorf("Unmarshal(%T) succeeded, want an error", v)
+		}
+	}
+}
+
+func TestMarshalNil(t *testing.T) {
+	b, err := Marshal((*test1)(nil))
+	if err != nil || b != nil {
+		t.Errorf("got %x, %v, want nil, nil", b, err)
+	}
+}
+
+func TestSnakeCase(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"ID", "id"},
+		{"Name", "name"},
+		{"ProductID", "product_id"},
+		{"MaxQuantity", "max_quantity"},
+		{"SKU", "sku"},
+		{"JSONName", "json_name"},
+		{"IncludeExisting", "include_existing"},
+	}
+
+	for _, tt := range tests {
+		if got := snakeCase(tt.in); got != tt.want {
+			t.Errorf("snakeCase(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package grpc
+
+import (
+	"io"
+	"sort"
+	"strings"
+)
+
+// ReflectionService is the name of the server reflection service, used
+// by tools like grpcurl to discover the services of a server.
+const ReflectionService = "grpc.reflection.v1.ServerReflection"
+
+// the messages of grpc/reflection/v1/reflection.proto; the pointer
+// fields are the members of a oneof
+type serverReflectionRequest struct {
+	Host                      string            `pb:"1"`
+	FileByFilename            *string           `pb:"3"`
+	FileContainingSymbol      *string           `pb:"4"`
+	FileContainingExtension   *extensionRequest `pb:"5"`
+	AllExtensionNumbersOfType *string           `pb:"6"`
+	ListServices              *string           `pb:"7"`
+}
+
+type extensionRequest struct {
+	ContainingType  string `pb:"1"`
+	ExtensionNumber int32  `pb:"2"`
+}
+
+type serverReflectionResponse struct {
+	ValidHost                   string                   `pb:"1"`
+	OriginalRequest             *serverReflectionRequest `pb:"2"`
+	FileDescriptorResponse      *fileDescriptorResponse  `pb:"4"`
+	AllExtensionNumbersResponse *extensionNumberResponse `pb:"5"`
+	ListServicesResponse        *listServiceResponse     `pb:"6"`
+	ErrorResponse               *errorResponse           `pb:"7"`
+}
+
+type fileDescriptorResponse struct {
+	FileDescriptorProto [][]byte `pb:"1"`
+}
+
+type extensionNumberResponse struct {
+	BaseTypeName    string  `pb:"1"`
+	ExtensionNumber []int32 `pb:"2"`
+}
+
+type listServiceResponse struct {
+	Service []*serviceResponse `pb:"1"`
+}
+
+type serviceResponse struct {
+	Name string `pb:"1"`
+}
+
+type errorResponse struct {
+	ErrorCode    int32  `pb:"1"`
+	ErrorMessage string `pb:"2"`
+}
+
+// reflectionFile describes the reflection service of s.
+func reflectionFile(s *Server) *File {
+	return &File{
+		Name:    "grpc/reflection/v1/reflection.proto",
+		Package: "grpc.reflection.v1",
+		Messages: []Message{
+			{Name: "ServerReflectionRequest", Type: serverReflectionRequest{}},
+			{Name: "ExtensionRequest", Type: extensionRequest{}},
+			{Name: "ServerReflectionResponse", Type: serverReflectionResponse{}},
+			{Name: "FileDescriptorResponse", Type: fileDescriptorResponse{}},
+			{Name: "ExtensionNumberResponse", Type: extensionNumberResponse{}},
+			{Name: "ListServiceResponse", Type: listServiceResponse{}},
+			{Name: "ServiceResponse", Type: serviceResponse{}},
+			{Name: "ErrorResponse", Type: errorResponse{}},
+		},
+		Services: []*ServiceDesc{
+			{
+				Name: ReflectionService,
+				Methods: []MethodDesc{
+					{
+						Name:            "ServerReflectionInfo",
+						Request:         &serverReflectionRequest{},
+						Response:        &serverReflectionResponse{},
+						ClientStreaming: true,
+						ServerStreaming: true,
+						Stream:          s.reflectionInfo,
+					},
+				},
+			},
+		},
+	}
+}
+
+func (s *Server) reflectionInfo(ss *ServerStream) error {
+	for {
+		req := &serverReflectionRequest{}
+		if err := ss.RecvMsg(req); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		resp := &serverReflectionResponse{ValidHost: req.Host, OriginalRequest: req}
+
+		switch {
+		case req.FileByFilename != nil:
+			resp.FileDescriptorResponse = s.fileByName(*req.FileByFilename)
+		case req.FileContainingSymbol != nil:
+			resp.FileDescriptorResponse = s.fileBySymbol(*req.FileContainingSymbol)
+		case req.ListServices != nil:
+			resp.ListServicesResponse = s.listServices()
+		case req.FileContainingExtension != nil, req.AllExtensionNumbersOfType != nil:
+			// extensions are not used by proto3 files
+			resp.ErrorResponse = &errorResponse{
+				ErrorCode:    int32(NotFound),
+				ErrorMessage: "extensions are not supported",
+			}
+		default:
+			resp.ErrorResponse = &errorResponse{
+				ErrorCode:    int32(InvalidArgument),
+				ErrorMessage: "invalid reflection request",
+			}
+		}
+
+		if resp.FileDescriptorResponse == nil && resp.ListServicesResponse == nil && resp.ErrorResponse == nil {
+			resp.ErrorResponse = &errorResponse{
+				ErrorCode:    int32(NotFound),
+				ErrorMessage: "file or symbol not found",
+			}
+		}
+
+		if err := ss.SendMsg(resp); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Server) fileByName(name string) *fileDescriptorResponse {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	descriptor, ok := s.descriptors[name]
+	if !ok {
+		return nil
+	}
+
+	return &fileDescriptorResponse{FileDescriptorProto: [][]byte{descriptor}}
+}
+
+// fileBySymbol returns the file declaring a service, method, message
+// or enum.
+func (s *Server) fileBySymbol(symbol string) *fileDescriptorResponse {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, f := range s.files {
+		if !strings.HasPrefix(symbol, f.Package+".") {
+			continue
+		}
+		name := strings.TrimPrefix(symbol, f.Package+".")
+
+		if f.declares(name) {
+			return &fileDescriptorResponse{FileDescriptorProto: [][]byte{s.descriptors[f.Name]}}
+		}
+	}
+
+	return nil
+}
+
+// declares reports whether name, relative to the package of f, is
+// declared by f.
+func (f *File) declares(name string) bool {
+	for _, m := range f.Messages {
+		if m.Name == name {
+			return true
+		}
+	}
+
+	for _, e := range f.Enums {
+		if e.Name == name {
+			return true
+		}
+	}
+
+	for _, sd := range f.Services {
+		svc := strings.TrimPrefix(sd.Name, f.Package+".")
+		if svc == name {
+			return true
+		}
+
+		for _, md := range sd.Methods {
+			if svc+"."+md.Name == name {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (s *Server) listServices() *listServiceResponse {
+	s.mu.RLock()
+	services := append([]string(nil), s.services...)
+	s.mu.RUnlock()
+
+	sort.Strings(services)
+
+	resp := &listServiceResponse{}
+	for _, name := range services {
+		resp.Service = append(resp.Service, &serviceResponse{Name: name})
+	}
+
+	return resp
+}
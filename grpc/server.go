@@ -0,0 +1,439 @@
+// Package grpc implements a gRPC server on top of net/http: the
+// protobuf wire format, HTTP/2 framing of messages, status codes,
+// interceptors, health checking and server reflection.
+package grpc
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// MaxMessageSize is the largest message received or sent by a server.
+const MaxMessageSize = 4 << 20
+
+// ServiceDesc describes a gRPC service.
+type ServiceDesc struct {
+	// Name is the full name of the service, e.g. store.v1.ProductService.
+	Name    string
+	Methods []MethodDesc
+}
+
+// MethodDesc describes a method of a service. Unary methods set Unary,
+// streaming methods set Stream.
+type MethodDesc struct {
+	Name string
+
+	// Request and Response are values of the message types of the
+	// method, e.g. &GetProductRequest{}.
+	Request  interface{}
+	Response interface{}
+
+	ClientStreaming bool
+	ServerStreaming bool
+
+	Unary  UnaryHandler
+	Stream StreamHandler
+}
+
+// MethodInfo describes the method being called to interceptors.
+type MethodInfo struct {
+	// FullMethod is /service/method.
+	FullMethod      string
+	Service         string
+	Method          string
+	ClientStreaming bool
+	ServerStreaming bool
+}
+
+// UnaryHandler serves a unary call.
+type UnaryHandler func(ctx context.Context, req interface{}) (interface{}, error)
+
+// StreamHandler serves a streaming call.
+type StreamHandler func(s *ServerStream) error
+
+// UnaryInterceptor intercepts unary calls; it must call handler to
+// continue the call.
+type UnaryInterceptor func(ctx context.Context, req interface{}, info *MethodInfo, handler UnaryHandler) (interface{}, error)
+
+// StreamInterceptor intercepts streaming calls; it must call handler to
+// continue the call.
+type StreamInterceptor func(s *ServerStream, info *MethodInfo, handler StreamHandler) error
+
+// Interceptor intercepts the unary and streaming calls of a server.
+// Either function may be nil.
+type Interceptor struct {
+	Unary  UnaryInterceptor
+	Stream StreamInterceptor
+}
+
+// ServerOptions configures a Server.
+type ServerOptions struct {
+	// Interceptors are applied in order, the first one is the outermost.
+	Interceptors []Interceptor
+
+	// Reflection registers the server reflection services.
+	Reflection bool
+}
+
+// Server serves gRPC calls over HTTP/2. It is an http.Handler, meant to
+// be used by an http.Server that accepts HTTP/2 connections.
+type Server struct {
+	opts ServerOptions
+
+	mu          sync.RWMutex
+	methods     map[string]*method
+	services    []string
+	files       []*File
+	names       names
+	descriptors map[string][]byte // file name -> FileDescriptorProto
+}
+
+type method struct {
+	desc MethodDesc
+	info MethodInfo
+}
+
+// NewServer returns a Server without services.
+func NewServer(opts ServerOptions) *Server {
+	s := &Server{
+		opts:        opts,
+		methods:     map[string]*method{},
+		names:       names{},
+		descriptors: map[string][]byte{},
+	}
+
+	if opts.Reflection {
+		s.Register(reflectionFile(s))
+	}
+
+	return s
+}
+
+// Register registers the services of f. It panics if the file cannot
+// be described, a programming error.
+func (s *Server) Register(f *File) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.names.add(f)
+
+	descriptor, err := f.descriptor(s.names)
+	if err != nil {
+		panic(err)
+	}
+	s.files = append(s.files, f)
+	s.descriptors[f.Name] = descriptor
+
+	for _, sd := range f.Services {
+		s.services = append(s.services, sd.Name)
+
+		for _, md := range sd.Methods {
+			full := "/" + sd.Name + "/" + md.Name
+			s.methods[full] = &method{
+				desc: md,
+				info: MethodInfo{
+					FullMethod:      full,
+					Service:         sd.Name,
+					Method:          md.Name,
+					ClientStreaming: md.ClientStreaming,
+					ServerStreaming: md.ServerStreaming,
+				},
+			}
+		}
+	}
+}
+
+type metadataKey struct{}
+
+// Metadata returns the request headers of the call served with ctx.
+func Metadata(ctx context.Context) http.Header {
+	md, _ := ctx.Value(metadataKey{}).(http.Header)
+	return md
+}
+
+type peerKey struct{}
+
+// Peer returns the address of the client of the call served with ctx.
+func Peer(ctx context.Context) string {
+	addr, _ := ctx.Value(peerKey{}).(string)
+	return addr
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.ProtoMajor != 2 {
+		http.Error(w, "gRPC requires HTTP/2", http.StatusHTTPVersionNotSupported)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ct := r.Header.Get("Content-Type")
+	if ct != "application/grpc" && ct != "application/grpc+proto" {
+		http.Error(w, "Unsupported Media Type", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Grpc-Accept-Encoding", "identity")
+
+	s.mu.RLock()
+	m := s.methods[r.URL.Path]
+	s.mu.RUnlock()
+
+	if m == nil {
+		writeStatus(w, Errorf(Unimplemented, "unknown method %s", r.URL.Path))
+		return
+	}
+
+	if enc := r.Header.Get("Grpc-Encoding"); enc != "" && enc != "identity" {
+		writeStatus(w, Errorf(Unimplemented, "unsupported encoding %q", enc))
+		return
+	}
+
+	ctx := r.Context()
+	if t := r.Header.Get("Grpc-Timeout"); t != "" {
+		timeout, err := parseTimeout(t)
+		if err != nil {
+			writeStatus(w, Errorf(InvalidArgument, "%v", err))
+			return
+		}
+
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	ctx = context.WithValue(ctx, metadataKey{}, r.Header)
+	ctx = context.WithValue(ctx, peerKey{}, r.RemoteAddr)
+
+	ss := &ServerStream{ctx: ctx, body: r.Body, w: w, desc: &m.desc, mu: &sync.Mutex{}}
+	w.WriteHeader(http.StatusOK)
+
+	var err error
+	if m.desc.ClientStreaming || m.desc.ServerStreaming {
+		err = s.serveStream(ss, m)
+	} else {
+		err = s.serveUnary(ss, m)
+	}
+
+	writeStatus(w, err)
+}
+
+func (s *Server) serveUnary(ss *ServerStream, m *method) (err error) {
+	req := reflect.New(messageType(m.desc.Request)).Interface()
+	if err := ss.RecvMsg(req); err != nil {
+		if err == io.EOF {
+			return Errorf(Internal, "missing request message")
+		}
+		return err
+	}
+
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		resp, err := m.desc.Unary(ctx, req)
+		return resp, shutdownError(ctx, err)
+	}
+	for i := len(s.opts.Interceptors) - 1; i >= 0; i-- {
+		if icpt := s.opts.Interceptors[i].Unary; icpt != nil {
+			next := handler
+			handler = func(ctx context.Context, req interface{}) (interface{}, error) {
+				return icpt(ctx, req, &m.info, next)
+			}
+		}
+	}
+
+	defer recoverCall(&err)
+
+	resp, err := handler(ss.ctx, req)
+	if err != nil {
+		return err
+	}
+
+	return ss.SendMsg(resp)
+}
+
+func (s *Server) serveStream(ss *ServerStream, m *method) (err error) {
+	handler := func(ss *ServerStream) error {
+		return shutdownError(ss.Context(), m.desc.Stream(ss))
+	}
+	for i := len(s.opts.Interceptors) - 1; i >= 0; i-- {
+		if icpt := s.opts.Interceptors[i].Stream; icpt != nil {
+			next := handler
+			handler = func(ss *ServerStream) error {
+				return icpt(ss, &m.info, next)
+			}
+		}
+	}
+
+	defer recoverCall(&err)
+
+	return handler(ss)
+}
+
+// shutdownError lets clients retry the calls cut by a server shutdown
+// elsewhere, the base context of the calls being cancelled with
+// http.ErrServerClosed as cause.
+func shutdownError(ctx context.Context, err error) error {
+	if CodeOf(err) == Canceled && errors.Is(context.Cause(ctx), http.ErrServerClosed) {
+		return Errorf(Unavailable, "server is shutting down")
+	}
+
+	return err
+}
+
+// recoverCall turns the panic of a method into an Internal error so the
+// stream is properly closed.
+func recoverCall(err *error) {
+	if r := recover(); r != nil {
+		*err = Errorf(Internal, "panic: %v", r)
+	}
+}
+
+// writeStatus ends the response with the status of err as trailers.
+func writeStatus(w http.ResponseWriter, err error) {
+	code, msg := status(err)
+
+	w.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(int(code)))
+	if msg != "" {
+		w.Header().Set(http.TrailerPrefix+"Grpc-Message", encodeMessage(msg))
+	}
+}
+
+// parseTimeout parses the value of the grpc-timeout header, e.g. 100m.
+func parseTimeout(s string) (time.Duration, error) {
+	if len(s) < 2 || len(s) > 9 {
+		return 0, fmt.Errorf("invalid grpc-timeout %q", s)
+	}
+
+	n, err := strconv.ParseInt(s[:len(s)-1], 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid grpc-timeout %q", s)
+	}
+
+	units := map[byte]time.Duration{
+		'H': time.Hour,
+		'M': time.Minute,
+		'S': time.Second,
+		'm': time.Millisecond,
+		'u': time.Microsecond,
+		'n': time.Nanosecond,
+	}
+
+	unit, ok := units[s[len(s)-1]]
+	if !ok {
+		return 0, fmt.Errorf("invalid grpc-timeout %q", s)
+	}
+
+	return time.Duration(n) * unit, nil
+}
+
+// ServerStream is a call being served: it receives the messages of the
+// client and sends the messages of the server.
+type ServerStream struct {
+	ctx  context.Context
+	body io.Reader
+	w    http.ResponseWriter
+	desc *MethodDesc
+
+	mu *sync.Mutex // serializes SendMsg
+}
+
+// Context returns the context of the call, cancelled when the client
+// goes away, the deadline expires or the server shuts down.
+func (ss *ServerStream) Context() context.Context {
+	return ss.ctx
+}
+
+// WithContext returns a copy of the stream using ctx, for interceptors
+// that attach values to the context of the call.
+func (ss *ServerStream) WithContext(ctx context.Context) *ServerStream {
+	return &ServerStream{ctx: ctx, body: ss.body, w: ss.w, desc: ss.desc, mu: ss.mu}
+}
+
+// RecvMsg receives the next message of the client into m. It returns
+// io.EOF when the client has no more messages.
+func (ss *ServerStream) RecvMsg(m interface{}) error {
+	var header [5]byte
+	if _, err := io.ReadFull(ss.body, header[:]); err != nil {
+		if err == io.EOF {
+			return io.EOF
+		}
+		return ss.recvError(err)
+	}
+
+	if header[0] != 0 {
+		return Errorf(Unimplemented, "compressed messages are not supported")
+	}
+
+	size := binary.BigEndian.Uint32(header[1:])
+	if size > MaxMessageSize {
+		return Errorf(ResourceExhausted, "received message larger than max (%d vs. %d)", size, MaxMessageSize)
+	}
+
+	b := make([]byte, size)
+	if _, err := io.ReadFull(ss.body, b); err != nil {
+		return ss.recvError(err)
+	}
+
+	if err := Unmarshal(b, m); err != nil {
+		return Errorf(Internal, "grpc: failed to unmarshal the received message: %v", err)
+	}
+
+	return nil
+}
+
+func (ss *ServerStream) recvError(err error) error {
+	if ctxErr := ss.ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return Errorf(Internal, "truncated message")
+	}
+
+	return Errorf(Canceled, "%v", err)
+}
+
+// SendMsg sends the message m to the client. It may be called
+// concurrently.
+func (ss *ServerStream) SendMsg(m interface{}) error {
+	b, err := Marshal(m)
+	if err != nil {
+		return Errorf(Internal, "grpc: failed to marshal the response: %v", err)
+	}
+
+	if len(b) > MaxMessageSize {
+		return Errorf(ResourceExhausted, "sent message larger than max (%d vs. %d)", len(b), MaxMessageSize)
+	}
+
+	frame := make([]byte, 5, 5+len(b))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(b)))
+	frame = append(frame, b...)
+
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	if err := ss.ctx.Err(); err != nil {
+		return err
+	}
+
+	if _, err := ss.w.Write(frame); err != nil {
+		return Errorf(Unavailable, "%v", err)
+	}
+
+	if f, ok := ss.w.(http.Flusher); ok && ss.desc.ServerStreaming {
+		f.Flush()
+	}
+
+	return nil
+}
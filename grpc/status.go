@@ -0,0 +1,96 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+// Code is a gRPC status code.
+type Code uint32
+
+// the gRPC status codes
+const (
+	OK                 Code = 0
+	Canceled           Code = 1
+	Unknown            Code = 2
+	InvalidArgument    Code = 3
+	DeadlineExceeded   Code = 4
+	NotFound           Code = 5
+	AlreadyExists      Code = 6
+	PermissionDenied   Code = 7
+	ResourceExhausted  Code = 8
+	FailedPrecondition Code = 9
+	Aborted            Code = 10
+	OutOfRange         Code = 11
+	Unimplemented      Code = 12
+	Internal           Code = 13
+	Unavailable        Code = 14
+	DataLoss           Code = 15
+	Unauthenticated    Code = 16
+)
+
+var codeNames = []string{
+	"OK", "Canceled", "Unknown", "InvalidArgument", "DeadlineExceeded",
+	"NotFound", "AlreadyExists", "PermissionDenied", "ResourceExhausted",
+	"FailedPrecondition", "Aborted", "OutOfRange", "Unimplemented",
+	"Internal", "Unavailable", "DataLoss", "Unauthenticated",
+}
+
+func (c Code) String() string {
+	if int(c) < len(codeNames) {
+		return codeNames[c]
+	}
+
+	return "Code(" + strconv.Itoa(int(c)) + ")"
+}
+
+// Error is an error with a gRPC status code.
+type Error struct {
+	Code    Code
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("rpc error: code = %s desc = %s", e.Code, e.Message)
+}
+
+// Errorf returns an error with the status code c.
+func Errorf(c Code, format string, args ...interface{}) error {
+	return &Error{Code: c, Message: fmt.Sprintf(format, args...)}
+}
+
+// status returns the code and message sent to the client for err.
+func status(err error) (Code, string) {
+	if err == nil {
+		return OK, ""
+	}
+
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Code, e.Message
+	}
+
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return DeadlineExceeded, err.Error()
+	case errors.Is(err, context.Canceled):
+		return Canceled, err.Error()
+	}
+
+	return Unknown, err.Error()
+}
+
+// CodeOf returns the status code of err, OK if it is nil.
+func CodeOf(err error) Code {
+	c, _ := status(err)
+	return c
+}
+
+// encodeMessage percent-encodes a status message for the grpc-message
+// trailer.
+func encodeMessage(msg string) string {
+	return url.PathEscape(msg)
+}
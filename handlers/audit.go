@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/imariom/products-api/audit"
+	"github.com/imariom/products-api/logging"
+)
+
+// maxAuditPage limits the entries listed by /audit at once.
+const maxAuditPage = 1000
+
+// Audit is the HTTP handler of the admin endpoints reading the audit
+// log of the changes made to the data stores: /audit lists the entries,
+// /audit:export downloads them as JSON lines and /audit:verify checks
+// their hash chain.
+type Audit struct {
+	logger *logging.Logger
+	log    *audit.Log
+}
+
+// NewAudit is a constructor for Audit handler.
+func NewAudit(l *logging.Logger, log *audit.Log) *Audit {
+	return &Audit{l, log}
+}
+
+// ServeHTTP implements http.Handler.
+func (h *Audit) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	path := r.URL.Path
+	switch {
+	case path == "/audit" && r.Method == http.MethodGet:
+		h.list(rw, r)
+
+	case path == "/audit:export" && r.Method == http.MethodGet:
+		h.export(rw, r)
+
+	case path == "/audit:verify" && r.Method == http.MethodGet:
+		h.verify(rw, r)
+
+	case path == "/audit" || path == "/audit:export" || path == "/audit:verify":
+		http.Error(rw, "HTTP verb not implemented", http.StatusNotImplemented)
+
+	default:
+		http.NotFound(rw, r)
+	}
+}
+
+// list returns the entries selected by the query parameters, in the
+// order they were recorded.
+func (h *Audit) list(rw http.ResponseWriter, r *http.Request) {
+	h.logger.For(r.Context()).Debug("received a GET audit request")
+
+	r, ok := negotiate(rw, r)
+	if !ok {
+		return
+	}
+
+	f, ok := auditFilter(rw, r, true)
+	if !ok {
+		return
+	}
+
+	entries, err := h.log.Entries(f)
+	if err != nil {
+		h.logger.For(r.Context()).Error("failed to read the audit log", "error", err)
+		http.Error(rw, "failed to read the audit log", http.StatusInternalServerError)
+		return
+	}
+
+	if err := respond(rw, r, entries); err != nil {
+		http.Error(rw, "failed to retrieve the audit log", http.StatusInternalServerError)
+	}
+}
+
+// export streams the entries selected by the query parameters as JSON
+// lines.
+func (h *Audit) export(rw http.ResponseWriter, r *http.Request) {
+	h.logger.For(r.Context()).Debug("received an audit export request")
+
+	f, ok := auditFilter(rw, r, false)
+	if !ok {
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/x-ndjson")
+	rw.Header().Set("Content-Disposition", `attachment; filename="audit.jsonl"`)
+
+	// the status was sent with the first entries, so errors (mostly
+	// clients going away) can only be logged
+	if err := h.log.Export(rw, f); err != nil {
+		h.logger.For(r.Context()).Warn("audit export interrupted", "error", err)
+	}
+}
+
+// verify checks the hash chain of the log.
+func (h *Audit) verify(rw http.ResponseWriter, r *http.Request) {
+	h.logger.For(r.Context()).Debug("received an audit verify request")
+
+	r, ok := negotiate(rw, r)
+	if !ok {
+		return
+	}
+
+	if err := respond(rw, r, h.log.Verify()); err != nil {
+		http.Error(rw, "failed to verify the audit log", http.StatusInternalServerError)
+	}
+}
+
+// auditFilter parses the resource, id, actor, operation, since, until,
+// limit and offset query parameters, answering 400 if they are invalid.
+// The pages of entries are limited to maxAuditPage entries when paged,
+// all entries being exported otherwise.
+func auditFilter(rw http.ResponseWriter, r *http.Request, paged bool) (audit.Filter, bool) {
+	query := r.URL.Query()
+	limit, offset, _ := getQueryParams(r.URL.RawQuery)
+	if paged && (limit <= 0 || limit > maxAuditPage) {
+		limit = maxAuditPage
+	}
+
+	f := audit.Filter{
+		Resource:  query.Get("resource"),
+		Actor:     query.Get("actor"),
+		Operation: query.Get("operation"),
+		Limit:     limit,
+		Offset:    offset,
+	}
+
+	if id := query.Get("id"); id != "" {
+		n, err := strconv.ParseUint(id, 10, 64)
+		if err != nil {
+			http.Error(rw, "invalid id", http.StatusBadRequest)
+			return f, false
+		}
+		f.ID = &n
+	}
+
+	for name, t := range map[string]*time.Time{"since": &f.Since, "until": &f.Until} {
+		v := query.Get(name)
+		if v == "" {
+			continue
+		}
+
+		parsed, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			http.Error(rw, "invalid "+name+", expected an RFC 3339 time", http.StatusBadRequest)
+			return f, false
+		}
+		*t = parsed
+	}
+
+	return f, true
+}
@@ -0,0 +1,340 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/imariom/products-api/data"
+	"github.com/imariom/products-api/logging"
+)
+
+const (
+	// DefaultMaxBatchOperations is the default limit of operations in a
+	// batch.
+	DefaultMaxBatchOperations = 50
+
+	// maxBatchBody limits the size of batch requests.
+	maxBatchBody = 1 << 20
+)
+
+type txKey struct{}
+
+// lockWrites holds off transactional batches while r writes to the data
+// stores. It returns the function releasing the lock.
+func lockWrites(r *http.Request) func() {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead || r.Context().Value(txKey{}) != nil {
+		return func() {}
+	}
+
+	return data.HoldWrites()
+}
+
+// BatchOperation is a sub-request of a batch.
+type BatchOperation struct {
+	// ID is an optional client identifier echoed in the result.
+	ID      string            `json:"id,omitempty"`
+	Method  string            `json:"method"`
+	Path    string            `json:"path"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    json.RawMessage   `json:"body,omitempty"`
+}
+
+// BatchRequest is the payload of POST /batch. A bare array of
+// operations is accepted too.
+type BatchRequest struct {
+	// Transactional makes the batch all-or-nothing: the data stores
+	// are rolled back if an operation fails.
+	Transactional bool             `json:"transactional"`
+	Operations    []BatchOperation `json:"operations"`
+}
+
+// BatchResult is the response to a sub-request. JSON bodies are
+// embedded as they are, other bodies as strings.
+type BatchResult struct {
+	ID      string            `json:"id,omitempty"`
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    json.RawMessage   `json:"body,omitempty"`
+}
+
+// BatchResponse is the response of POST /batch.
+type BatchResponse struct {
+	Transactional bool          `json:"transactional"`
+	Committed     bool          `json:"committed"`
+	Error         string        `json:"error,omitempty"`
+	Results       []BatchResult `json:"results"`
+}
+
+// BatchOptions configures a Batch handler.
+type BatchOptions struct {
+	// MaxOperations limits the operations of a batch,
+	// DefaultMaxBatchOperations if zero.
+	MaxOperations int
+}
+
+// Batch is the HTTP handler of POST /batch. It serves each operation
+// of a batch with the handler of the API, one after another, in the
+// context of the batch request: the request ID, the user and the actor
+// of the batch are those of its operations.
+type Batch struct {
+	logger  *logging.Logger
+	handler http.Handler
+	opts    BatchOptions
+}
+
+// NewBatch is a constructor for Batch handler, serving operations with h.
+func NewBatch(l *logging.Logger, h http.Handler, opts BatchOptions) *Batch {
+	if opts.MaxOperations <= 0 {
+		opts.MaxOperations = DefaultMaxBatchOperations
+	}
+
+	return &Batch{logger: l, handler: h, opts: opts}
+}
+
+// ServeHTTP implements http.Handler.
+func (h *Batch) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		http.Error(rw, "HTTP verb not implemented", http.StatusNotImplemented)
+		return
+	}
+
+	log := h.logger.For(r.Context())
+	log.Debug("received a batch request")
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBatchBody+1))
+	if err != nil {
+		http.Error(rw, "failed to read batch", http.StatusBadRequest)
+		return
+	}
+	if len(body) > maxBatchBody {
+		http.Error(rw, fmt.Sprintf("batches are limited to %d bytes", maxBatchBody), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	batch, err := parseBatch(body)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if len(batch.Operations) > h.opts.MaxOperations {
+		http.Error(rw, fmt.Sprintf("batches are limited to %d operations", h.opts.MaxOperations), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	for i, op := range batch.Operations {
+		if err := checkOperation(op); err != nil {
+			http.Error(rw, fmt.Sprintf("invalid operation %d: %v", i, err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	var res BatchResponse
+	if batch.Transactional {
+		res = h.runTransaction(r, batch.Operations)
+	} else {
+		res = h.run(r.Context(), r, batch.Operations, false)
+	}
+
+	if !res.Committed {
+		log.Info("batch rolled back", "error", res.Error)
+	}
+
+	if err := json.NewEncoder(rw).Encode(res); err != nil {
+		http.Error(rw, "failed to write batch results", http.StatusInternalServerError)
+	}
+}
+
+// runTransaction runs the operations in a transaction, with the other
+// writes held off, rolling back the data stores if one of them fails.
+// The events and the audit records of the operations are only emitted
+// if the transaction is committed.
+func (h *Batch) runTransaction(r *http.Request, ops []BatchOperation) BatchResponse {
+	storeSpan := traceStore(r, "Begin")
+	tx := data.Begin()
+	storeSpan.End()
+
+	// the other writes stay held off until the transaction ends
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	ctx := context.WithValue(r.Context(), txKey{}, true)
+	res := h.run(ctx, r, ops, true)
+
+	if !res.Committed {
+		storeSpan := traceStore(r, "Rollback")
+		tx.Rollback()
+		storeSpan.End()
+		return res
+	}
+
+	storeSpan = traceStore(r, "Commit")
+	tx.Commit()
+	storeSpan.End()
+
+	return res
+}
+
+// run serves the operations one after another. In transactional mode
+// it stops at the first operation failing.
+func (h *Batch) run(ctx context.Context, r *http.Request, ops []BatchOperation, transactional bool) BatchResponse {
+	res := BatchResponse{
+		Transactional: transactional,
+		Committed:     true,
+		Results:       make([]BatchResult, 0, len(ops)),
+	}
+
+	for i, op := range ops {
+		result := h.serve(ctx, r, op)
+		res.Results = append(res.Results, result)
+
+		if transactional && result.Status >= http.StatusBadRequest {
+			res.Committed = false
+			res.Error = fmt.Sprintf("operation %d failed with status %d, the batch was rolled back", i, result.Status)
+
+			// the operations left are not executed
+			for _, op := range ops[i+1:] {
+				res.Results = append(res.Results, BatchResult{ID: op.ID, Status: http.StatusFailedDependency})
+			}
+			break
+		}
+	}
+
+	return res
+}
+
+// serve serves a single operation with the API handler.
+func (h *Batch) serve(ctx context.Context, parent *http.Request, op BatchOperation) BatchResult {
+	var body io.Reader
+	if len(op.Body) > 0 {
+		body = bytes.NewReader(op.Body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, strings.ToUpper(op.Method), op.Path, body)
+	if err != nil {
+		return errorResult(op, http.StatusBadRequest, err.Error())
+	}
+
+	req.RemoteAddr = parent.RemoteAddr
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for k, v := range op.Headers {
+		req.Header.Set(k, v)
+	}
+
+	rec := &batchRecorder{header: make(http.Header)}
+	h.handler.ServeHTTP(rec, req)
+
+	result := BatchResult{ID: op.ID, Status: rec.Status(), Headers: make(map[string]string)}
+	for k := range rec.header {
+		result.Headers[k] = rec.header.Get(k)
+	}
+
+	b := bytes.TrimSpace(rec.body.Bytes())
+	if len(b) > 0 {
+		mt, _, _ := mime.ParseMediaType(rec.header.Get("Content-Type"))
+		if mt != "application/json" || !json.Valid(b) {
+			b, _ = json.Marshal(string(b))
+		}
+		result.Body = b
+	}
+
+	return result
+}
+
+func errorResult(op BatchOperation, status int, msg string) BatchResult {
+	b, _ := json.Marshal(msg)
+	return BatchResult{ID: op.ID, Status: status, Body: b}
+}
+
+// parseBatch decodes a BatchRequest or a bare array of operations.
+func parseBatch(body []byte) (*BatchRequest, error) {
+	batch := &BatchRequest{}
+
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '[' {
+		if err := json.Unmarshal(body, &batch.Operations); err != nil {
+			return nil, fmt.Errorf("invalid batch payload")
+		}
+		return batch, nil
+	}
+
+	if err := json.Unmarshal(body, batch); err != nil {
+		return nil, fmt.Errorf("invalid batch payload")
+	}
+
+	return batch, nil
+}
+
+// checkOperation rejects operations that cannot be served in a batch.
+func checkOperation(op BatchOperation) error {
+	switch strings.ToUpper(op.Method) {
+	case http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+	default:
+		return fmt.Errorf("unsupported method '%s'", op.Method)
+	}
+
+	if !strings.HasPrefix(op.Path, "/") {
+		return fmt.Errorf("path must start with '/'")
+	}
+
+	// nested batches would escape the limits, and imports run in the
+	// background, out of reach of transactions
+	path := op.Path
+	if i := strings.IndexAny(path, "?"); i >= 0 {
+		path = path[:i]
+	}
+	if strings.HasSuffix(path, "/batch") || strings.Contains(path, ":import") {
+		return fmt.Errorf("'%s' cannot be batched", op.Path)
+	}
+
+	return nil
+}
+
+// batchRecorder holds the response to an operation.
+type batchRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *batchRecorder) Header() http.Header {
+	return w.header
+}
+
+func (w *batchRecorder) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+}
+
+func (w *batchRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+
+	return w.body.Write(b)
+}
+
+// Status returns the status code of the response.
+func (w *batchRecorder) Status() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+
+	return w.status
+}
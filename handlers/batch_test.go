@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/imariom/products-api/data"
+	"github.com/imariom/products-api/events"
+	"github.com/imariom/products-api/logging"
+)
+
+// auditRecorder is a data.Auditor keeping the changes reported.
+type auditRecorder struct {
+	mtx     sync.Mutex
+	changes []*data.Change
+}
+
+func (a *auditRecorder) Record(c *data.Change) {
+	a.mtx.Lock()
+	a.changes = append(a.changes, c)
+	a.mtx.Unlock()
+}
+
+func (a *auditRecorder) len() int {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	return len(a.changes)
+}
+
+// serveBatch serves the batch b with a Batch handler of the products,
+// and returns its response along with the number of events published
+// and of changes audited while it was served.
+func serveBatch(t *testing.T, b BatchRequest) (res BatchResponse, published, audited int) {
+	t.Helper()
+
+	bus := events.NewBus(0)
+	previous := events.Default()
+	events.SetDefault(bus)
+	t.Cleanup(func() { events.SetDefault(previous) })
+
+	auditor := &auditRecorder{}
+	data.SetAuditor(auditor)
+	t.Cleanup(func() { data.SetAuditor(nil) })
+
+	products := NewProduct(logging.Discard)
+	mux := http.NewServeMux()
+	mux.Handle("/products", products)
+	mux.Handle("/products/", products)
+
+	body, err := json.Marshal(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/batch", bytes.NewReader(body))
+	rw := httptest.NewRecorder()
+	NewBatch(logging.Discard, mux, BatchOptions{}).ServeHTTP(rw, r)
+
+	if rw.Code != http.StatusOK {
+		t.Fatalf("got %d %s, want 200", rw.Code, rw.Body)
+	}
+	if err := json.Unmarshal(rw.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+
+	return res, int(bus.LastID()), auditor.len()
+}
+
+func TestBatch(t *testing.T) {
+	existing := &data.Product{Name: "existing", Category: "test"}
+	if err := data.AddNewProduct(context.Background(), existing); err != nil {
+		t.Fatal(err)
+	}
+
+	// the SKUs are unique to the run, as the products stay in the store
+	sku := func(s string) string { return s + "-" + strconv.FormatUint(existing.ID, 10) }
+	product := func(name, s string) json.RawMessage {
+		p := data.Product{Name: name, Category: "test"}
+		if s != "" {
+			p.SKU = sku(s)
+		}
+		b, _ := json.Marshal(p)
+		return b
+	}
+	existingPath := "/products/" + strconv.FormatUint(existing.ID, 10)
+
+	tests := []struct {
+		name          string
+		transactional bool
+		ops           []BatchOperation
+		statuses      []int
+		committed     bool
+		created       []string // the SKUs of the products created
+		missing       []string // the SKUs of the products not created
+		existingName  string
+		events        int
+	}{
+		{
+			name:          "committed",
+			transactional: true,
+			ops: []BatchOperation{
+				{Method: http.MethodPost, Path: "/products", Body: product("created", "batch-committed")},
+				{Method: http.MethodPut, Path: existingPath, Body: product("updated", "")},
+			},
+			statuses:     []int{http.StatusOK, http.StatusOK},
+			committed:    true,
+			created:      []string{"batch-committed"},
+			existingName: "updated",
+			events:       2,
+		},
+		{
+			name:          "rolled back",
+			transactional: true,
+			ops: []BatchOperation{
+				{Method: http.MethodPost, Path: "/products", Body: product("created", "batch-rolled-back")},
+				{Method: http.MethodPut, Path: existingPath, Body: product("rolled back", "")},
+				{Method: http.MethodPost, Path: "/products", Body: product("", "batch-invalid")},
+				{Method: http.MethodPost, Path: "/products", Body: product("not run", "batch-not-run")},
+			},
+			statuses:     []int{http.StatusOK, http.StatusOK, http.StatusBadRequest, http.StatusFailedDependency},
+			missing:      []string{"batch-rolled-back", "batch-invalid", "batch-not-run"},
+			existingName: "updated",
+		},
+		{
+			name: "not transactional",
+			ops: []BatchOperation{
+				{Method: http.MethodPost, Path: "/products", Body: product("created", "batch-kept")},
+				{Method: http.MethodPost, Path: "/products", Body: product("", "batch-invalid")},
+				{Method: http.MethodPut, Path: existingPath, Body: product("kept", "")},
+			},
+			statuses:     []int{http.StatusOK, http.StatusBadRequest, http.StatusOK},
+			committed:    true,
+			created:      []string{"batch-kept"},
+			missing:      []string{"batch-invalid"},
+			existingName: "kept",
+			events:       2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, published, audited := serveBatch(t, BatchRequest{Transactional: tt.transactional, Operations: tt.ops})
+
+			if res.Committed != tt.committed {
+				t.Errorf("got committed %v (%s), want %v", res.Committed, res.Error, tt.committed)
+			}
+			if len(res.Results) != len(tt.statuses) {
+				t.Fatalf("got %d results, want %d", len(res.Results), len(tt.statuses))
+			}
+			for i, result := range res.Results {
+				if result.Status != tt.statuses[i] {
+					t.Errorf("got status %d for operation %d, want %d: %s", result.Status, i, tt.statuses[i], result.Body)
+				}
+			}
+
+			for _, s := range tt.created {
+				if _, err := data.GetProductBySKU(sku(s)); err != nil {
+					t.Errorf("product %s not created: %v", s, err)
+				}
+			}
+			for _, s := range tt.missing {
+				if _, err := data.GetProductBySKU(sku(s)); err == nil {
+					t.Errorf("product %s created", s)
+				}
+			}
+			if p, err := data.GetProduct(existing.ID); err != nil || p.Name != tt.existingName {
+				t.Errorf("got product %+v (%v), want the name %q", p, err, tt.existingName)
+			}
+
+			// the changes rolled back are neither published nor audited
+			if published != tt.events {
+				t.Errorf("got %d events, want %d", published, tt.events)
+			}
+			if audited != tt.events {
+				t.Errorf("got %d changes audited, want %d", audited, tt.events)
+			}
+		})
+	}
+}
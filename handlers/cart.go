@@ -1,20 +1,21 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
-	"log"
 	"net/http"
 	"regexp"
 	"time"
 
 	"github.com/imariom/products-api/data"
+	"github.com/imariom/products-api/logging"
 )
 
 type Cart struct {
-	logger *log.Logger
+	logger *logging.Logger
 }
 
-func NewCart(l *log.Logger) *Cart {
+func NewCart(l *logging.Logger) *Cart {
 	return &Cart{l}
 }
 
@@ -28,7 +29,7 @@ func parseCart(regex *regexp.Regexp, r *http.Request) (*data.Cart, error) {
 
 	// try to decode user from request body
 	cart := &data.Cart{}
-	if err := cart.FromJSON(r.Body); err != nil {
+	if err := decodeBody(r, cart); err != nil {
 		return nil, fmt.Errorf("invalid cart payload")
 	}
 
@@ -43,8 +44,14 @@ func parseCart(regex *regexp.Regexp, r *http.Request) (*data.Cart, error) {
 // ServeHTTP is a method implementation of the http.Handler interface.
 // This method turns Product into an HTTP handler.
 func (h *Cart) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
-	// set API to be json based (send and receive JSON data)
-	rw.Header().Set("Content-Type", "application/json")
+	// negotiate the format of the request and response bodies
+	r, ok := negotiate(rw, r)
+	if !ok {
+		return
+	}
+
+	// transactional batches hold off writes while they run
+	defer lockWrites(r)()
 
 	switch r.Method {
 	case http.MethodGet:
@@ -52,16 +59,32 @@ func (h *Cart) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 		return
 
 	case http.MethodPost:
+		if restoreCartRe.MatchString(r.URL.Path) {
+			h.restore(rw, r)
+			return
+		}
+		if cartItemsRe.MatchString(r.URL.Path) {
+			h.addItem(rw, r)
+			return
+		}
 		h.create(rw, r)
 		return
 
 	case http.MethodDelete:
+		if cartItemRe.MatchString(r.URL.Path) {
+			h.removeItem(rw, r)
+			return
+		}
 		h.delete(rw, r)
 		return
 
 	case http.MethodPut:
 		fallthrough
 	case http.MethodPatch:
+		if r.Method == http.MethodPatch && cartItemRe.MatchString(r.URL.Path) {
+			h.adjustItem(rw, r)
+			return
+		}
 		h.update(rw, r)
 		return
 
@@ -73,21 +96,33 @@ func (h *Cart) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 // create parse and create new cart from request body and
 // store this product on internal data store.
 func (h *Cart) create(rw http.ResponseWriter, r *http.Request) {
-	h.logger.Println("received a PUT request")
+	h.logger.For(r.Context()).Debug("received a POST cart request")
 
 	// parse cart from request object
 	cart := &data.Cart{}
-	if err := cart.FromJSON(r.Body); err != nil {
+	if err := decodeBody(r, cart); err != nil {
 		http.Error(rw, "invalid cart payload", http.StatusBadRequest)
 		return
 	}
+
+	if err := data.Validate(cart); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
 	cart.Date = time.Now()
+	cart.Guest = false // guest carts are created with /carts/guest
 
 	// add cart to data store
-	data.AddCart(cart)
+	storeSpan := traceStore(r, "AddCart")
+	err := data.AddCart(r.Context(), cart)
+	storeSpan.End()
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	// try to return created cart
-	if err := cart.ToJSON(rw); err != nil {
+	if err := respond(rw, r, cart); err != nil {
 		http.Error(rw,
 			fmt.Sprintf("user created with ID: '%d', but failed to retrieve it",
 				cart.ID),
@@ -97,18 +132,32 @@ func (h *Cart) create(rw http.ResponseWriter, r *http.Request) {
 
 // get all carts, single cart or carts in a date range
 func (h *Cart) get(rw http.ResponseWriter, r *http.Request) {
-	h.logger.Println("received a GET request")
+	h.logger.For(r.Context()).Debug("received a GET cart request")
+
+	asOf, past, ok := getAsOf(rw, r)
+	if !ok {
+		return
+	}
 
 	// list all carts
 	listCartsRe := regexp.MustCompile(`^/carts[/]?$`)
-	limitRes, sortCriteria := getQueryParams(r.URL.RawQuery)
+	limitRes, offset, sortCriteria := getQueryParams(r.URL.RawQuery)
 
 	if listCartsRe.MatchString(r.URL.Path) {
-		carts := data.GetAllCarts(limitRes, sortCriteria)
+		var carts data.Carts
+		if past {
+			storeSpan := traceStore(r, "GetAllCartsAsOf")
+			carts = data.GetAllCartsAsOf(asOf, limitRes, offset, sortCriteria)
+			storeSpan.End()
+		} else {
+			storeSpan := traceStore(r, "GetAllCarts")
+			carts = data.GetAllCarts(limitRes, offset, sortCriteria)
+			storeSpan.End()
+		}
 
-		if err := carts.ToJSON(rw); err != nil {
+		if err := respond(rw, r, &carts); err != nil {
 			msg := "internal server error, while converting carts to JSON"
-			h.logger.Println("[ERROR]", msg)
+			h.logger.For(r.Context()).Error(msg, "error", err)
 			http.Error(rw, msg, http.StatusInternalServerError)
 		}
 		return
@@ -124,13 +173,22 @@ func (h *Cart) get(rw http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		cart, err := data.GetCart(uint64(cartID))
+		var cart *data.Cart
+		if past {
+			storeSpan := traceStore(r, "GetCartAsOf")
+			cart, err = data.GetCartAsOf(cartID, asOf)
+			storeSpan.End()
+		} else {
+			storeSpan := traceStore(r, "GetCart")
+			cart, err = data.GetCart(uint64(cartID))
+			storeSpan.End()
+		}
 		if err != nil {
 			http.Error(rw, err.Error(), http.StatusNotFound)
 			return
 		}
 
-		if err := cart.ToJSON(rw); err != nil {
+		if err := respond(rw, r, cart); err != nil {
 			http.Error(rw, "failed to convert cart", http.StatusInternalServerError)
 		}
 	}
@@ -145,8 +203,10 @@ func (h *Cart) get(rw http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		storeSpan := traceStore(r, "GetAllUserCarts")
 		carts := data.GetAllUserCarts(userID)
-		if err := carts.ToJSON(rw); err != nil {
+		storeSpan.End()
+		if err := respond(rw, r, &carts); err != nil {
 			http.Error(rw, "failed to convert carts", http.StatusInternalServerError)
 		}
 	}
@@ -170,8 +230,10 @@ func (h *Cart) get(rw http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		storeSpan := traceStore(r, "GetCartsInDateRange")
 		carts := data.GetCartsInDateRange(startDate, endDate)
-		if err := carts.ToJSON(rw); err != nil {
+		storeSpan.End()
+		if err := respond(rw, r, &carts); err != nil {
 			http.Error(rw, "failed to convert carts", http.StatusInternalServerError)
 		}
 	} else if listDateRangeRe.MatchString(r.URL.Path) {
@@ -184,8 +246,10 @@ func (h *Cart) get(rw http.ResponseWriter, r *http.Request) {
 				return
 			}
 
+			storeSpan := traceStore(r, "GetCartsInDateRange")
 			carts := data.GetCartsInDateRange(startDate, time.Time{})
-			if err := carts.ToJSON(rw); err != nil {
+			storeSpan.End()
+			if err := respond(rw, r, &carts); err != nil {
 				http.Error(rw, "failed to convert carts", http.StatusInternalServerError)
 			}
 		} else if matches[1] == "enddate" {
@@ -195,8 +259,10 @@ func (h *Cart) get(rw http.ResponseWriter, r *http.Request) {
 				return
 			}
 
+			storeSpan := traceStore(r, "GetCartsInDateRange")
 			carts := data.GetCartsInDateRange(time.Time{}, endDate)
-			if err := carts.ToJSON(rw); err != nil {
+			storeSpan.End()
+			if err := respond(rw, r, &carts); err != nil {
 				http.Error(rw, "failed to convert carts", http.StatusInternalServerError)
 			}
 		}
@@ -208,9 +274,9 @@ func (h *Cart) get(rw http.ResponseWriter, r *http.Request) {
 // attributes of a cart need to be updated.
 func (h *Cart) update(rw http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodPut {
-		h.logger.Println("received a PUT cart request")
+		h.logger.For(r.Context()).Debug("received a PUT cart request")
 	} else if r.Method == http.MethodPatch {
-		h.logger.Println("received a PATCH cart request")
+		h.logger.For(r.Context()).Debug("received a PATCH cart request")
 	}
 
 	// try to parse cart from request object
@@ -222,23 +288,38 @@ func (h *Cart) update(rw http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// carts have no required attributes, so PATCH payloads are
+	// validated the same way as PUT payloads
+	if err := data.Validate(cart); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	// match request method (PUT or PATCH)
 	if r.Method == http.MethodPut {
 		// update whole cart information
-		if err := data.UpdateCart(cart); err != nil {
-			http.Error(rw, err.Error(), http.StatusNotFound)
+		storeSpan := traceStore(r, "UpdateCart")
+		err = data.UpdateCart(r.Context(), cart)
+		storeSpan.End()
+
+		if err != nil {
+			cartStoreError(rw, err)
 			return
 		}
 	} else if r.Method == http.MethodPatch {
 		// update cart attributes
-		if err := data.SetCart(cart); err != nil {
-			http.Error(rw, err.Error(), http.StatusNotFound)
+		storeSpan := traceStore(r, "SetCart")
+		err = data.SetCart(r.Context(), cart)
+		storeSpan.End()
+
+		if err != nil {
+			cartStoreError(rw, err)
 			return
 		}
 	}
 
 	// return updated cart
-	if err := cart.ToJSON(rw); err != nil {
+	if err := respond(rw, r, cart); err != nil {
 		http.Error(rw,
 			fmt.Sprintf("cart with ID: '%d' was updated sucessfully, but failed to retrieve it",
 				cart.ID),
@@ -246,9 +327,30 @@ func (h *Cart) update(rw http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// restore moves a deleted cart back from the trash.
+func (h *Cart) restore(rw http.ResponseWriter, r *http.Request) {
+	h.logger.For(r.Context()).Debug("received a restore cart request")
+
+	restore(rw, r, restoreCartRe, "RestoreCart", func(id uint64) (interface{}, error) {
+		return data.RestoreCart(r.Context(), id)
+	})
+}
+
+// cartStoreError answers 400 for carts referencing unknown users or
+// products, and 404 for unknown carts.
+func cartStoreError(rw http.ResponseWriter, err error) {
+	var ve *data.ValidationError
+	if errors.As(err, &ve) {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	http.Error(rw, err.Error(), http.StatusNotFound)
+}
+
 // delete removes a single cart from data store.
 func (h *Cart) delete(rw http.ResponseWriter, r *http.Request) {
-	h.logger.Println("received a DELETE cart request")
+	h.logger.For(r.Context()).Debug("received a DELETE cart request")
 
 	deleteCartRe := regexp.MustCompile(`^/carts/(\d+)$`)
 
@@ -260,14 +362,16 @@ func (h *Cart) delete(rw http.ResponseWriter, r *http.Request) {
 	}
 
 	// delete cart from datastore
-	cart, err := data.RemoveCart(cartID)
+	storeSpan := traceStore(r, "RemoveCart")
+	cart, err := data.RemoveCart(r.Context(), cartID)
+	storeSpan.End()
 	if err != nil {
 		http.Error(rw, err.Error(), http.StatusNotFound)
 		return
 	}
 
 	// return deleted cart to client
-	if err := cart.ToJSON(rw); err != nil {
+	if err := respond(rw, r, cart); err != nil {
 		http.Error(rw,
 			fmt.Sprintf("cart with ID: '%d' was deleted, but failed to retrieve it",
 				cart.ID),
@@ -0,0 +1,534 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/imariom/products-api/codec"
+	"github.com/imariom/products-api/data"
+	"github.com/imariom/products-api/logging"
+	"github.com/imariom/products-api/middleware"
+	"github.com/imariom/products-api/queue"
+	"github.com/imariom/products-api/tracing"
+)
+
+// ImportKind is the kind of the queued jobs importing the products.
+const ImportKind = "products.import"
+
+const (
+	// maxImportSize limits the size of the files imported.
+	maxImportSize = 256 << 20
+
+	// maxImportErrors limits the row errors kept in an import report.
+	maxImportErrors = 1000
+
+	// importJobTTL is how long finished imports can be polled.
+	importJobTTL = 24 * time.Hour
+
+	// importTimeout bounds the time an import may take.
+	importTimeout = time.Hour
+
+	// exportBatchSize is the number of products copied from the store
+	// at a time while exporting.
+	exportBatchSize = 500
+)
+
+// Import job statuses.
+const (
+	ImportQueued    = "queued"
+	ImportRunning   = "running"
+	ImportSucceeded = "succeeded"
+	ImportFailed    = "failed"
+)
+
+// RowError is a row of an import that could not be imported.
+type RowError struct {
+	Line  int    `json:"line"`
+	SKU   string `json:"sku,omitempty"`
+	Error string `json:"error"`
+}
+
+// ImportProgress counts the rows processed by an import.
+type ImportProgress struct {
+	BytesRead  int64   `json:"bytes_read"`
+	BytesTotal int64   `json:"bytes_total"`
+	Percent    float64 `json:"percent"`
+	Rows       int     `json:"rows"`
+	Created    int     `json:"created"`
+	Updated    int     `json:"updated"`
+	Failed     int     `json:"failed"`
+}
+
+// ImportJob is the report of an import, polled by clients while it runs.
+// Its ID is the ID of the queued job running the import.
+type ImportJob struct {
+	ID              uint64         `json:"id"`
+	Status          string         `json:"status"`
+	DryRun          bool           `json:"dry_run"`
+	Format          string         `json:"format"`
+	CreatedAt       time.Time      `json:"created_at"`
+	StartedAt       *time.Time     `json:"started_at,omitempty"`
+	FinishedAt      *time.Time     `json:"finished_at,omitempty"`
+	Progress        ImportProgress `json:"progress"`
+	Errors          []RowError     `json:"errors"`
+	ErrorsTruncated bool           `json:"errors_truncated,omitempty"`
+	Error           string         `json:"error,omitempty"`
+}
+
+// importJob is an import in progress.
+type importJob struct {
+	mtx       sync.Mutex
+	job       ImportJob
+	bytesRead int64 // updated atomically while reading the file
+}
+
+// snapshot returns a copy of the report of the job.
+func (j *importJob) snapshot() ImportJob {
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+
+	job := j.job
+	job.Errors = append([]RowError{}, j.job.Errors...)
+	job.Progress.BytesRead = atomic.LoadInt64(&j.bytesRead)
+	if job.Progress.BytesTotal > 0 {
+		job.Progress.Percent = float64(job.Progress.BytesRead) * 100 / float64(job.Progress.BytesTotal)
+	}
+
+	return job
+}
+
+func (j *importJob) update(fn func(job *ImportJob)) {
+	j.mtx.Lock()
+	fn(&j.job)
+	j.mtx.Unlock()
+}
+
+// countingReader counts the bytes read into n.
+type countingReader struct {
+	r io.Reader
+	n *int64
+}
+
+func (c *countingReader) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	atomic.AddInt64(c.n, int64(n))
+	return n, err
+}
+
+// importPayload is the payload of the jobs importing the products. The
+// imports are made on behalf of the actor of the request queueing them.
+type importPayload struct {
+	Path      string `json:"path"`
+	Format    string `json:"format"`
+	DryRun    bool   `json:"dry_run,omitempty"`
+	Size      int64  `json:"size"`
+	Actor     string `json:"actor,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// Catalog is the HTTP handler of the bulk import (/products:import) and
+// export (/products:export) of the products. The imports run as jobs of
+// the background job queue.
+type Catalog struct {
+	logger *logging.Logger
+	queue  *queue.Queue
+
+	mtx  sync.Mutex
+	jobs map[uint64]*importJob
+}
+
+// NewCatalog is a constructor for Catalog handler, registering the
+// imports on q.
+func NewCatalog(l *logging.Logger, q *queue.Queue) *Catalog {
+	h := &Catalog{logger: l, queue: q, jobs: make(map[uint64]*importJob)}
+	q.Register(ImportKind, h.runImport)
+
+	return h
+}
+
+var importJobRe = regexp.MustCompile(`^/products:import/(\d+)$`)
+
+// ServeHTTP implements http.Handler.
+func (h *Catalog) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	r, ok := negotiate(rw, r)
+	if !ok {
+		return
+	}
+
+	switch {
+	case r.URL.Path == "/products:export" && r.Method == http.MethodGet:
+		h.export(rw, r)
+
+	case r.URL.Path == "/products:import" && r.Method == http.MethodPost:
+		h.startImport(rw, r)
+
+	case r.URL.Path == "/products:import" && r.Method == http.MethodGet:
+		h.listImports(rw, r)
+
+	case importJobRe.MatchString(r.URL.Path) && r.Method == http.MethodGet:
+		h.getImport(rw, r)
+
+	case r.URL.Path == "/products:export" || r.URL.Path == "/products:import" || importJobRe.MatchString(r.URL.Path):
+		http.Error(rw, "HTTP verb not implemented", http.StatusNotImplemented)
+
+	default:
+		http.NotFound(rw, r)
+	}
+}
+
+// export streams all the products in the negotiated format.
+func (h *Catalog) export(rw http.ResponseWriter, r *http.Request) {
+	h.logger.For(r.Context()).Debug("received a product export request")
+
+	c := format(r)
+	if c.NewWriter == nil {
+		http.Error(rw, fmt.Sprintf("format '%s' cannot be exported", c.Name), http.StatusNotAcceptable)
+		return
+	}
+
+	// the write timeout of the server does not apply to the export, which
+	// may take longer to stream
+	rc := http.NewResponseController(rw)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil && err != http.ErrNotSupported {
+		h.logger.For(r.Context()).Warn("failed to clear the write deadline of the export", "error", err)
+	}
+
+	rw.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"products.%s\"", c.Name))
+
+	storeSpan := traceStore(r, "ExportProducts")
+	defer storeSpan.End()
+
+	w := c.NewWriter(rw)
+	err := data.ExportProducts(exportBatchSize, func(products data.Products) error {
+		for _, p := range products {
+			if err := w.Write(p); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		err = w.Close()
+	}
+
+	// the status was sent with the first products, so errors (mostly
+	// clients going away) can only be logged
+	if err != nil {
+		storeSpan.RecordError(err)
+		h.logger.For(r.Context()).Warn("product export interrupted", "error", err)
+	}
+}
+
+// startImport saves the request body and queues its import, answering
+// with the job to poll.
+func (h *Catalog) startImport(rw http.ResponseWriter, r *http.Request) {
+	log := h.logger.For(r.Context())
+	log.Debug("received a product import request")
+
+	c, err := codec.Default.ForRequest(r)
+	if err != nil || c.NewReader == nil {
+		http.Error(rw, "products can be imported from CSV or NDJSON", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dry_run"))
+
+	// the timeouts of the server do not apply to the upload, which may
+	// take longer to receive, nor to the answer sent after it
+	rc := http.NewResponseController(rw)
+	if err := rc.SetReadDeadline(time.Time{}); err != nil && err != http.ErrNotSupported {
+		log.Warn("failed to clear the read deadline of the import", "error", err)
+	}
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil && err != http.ErrNotSupported {
+		log.Warn("failed to clear the write deadline of the import", "error", err)
+	}
+
+	// the body is spooled to disk as the job outlives the request
+	f, err := os.CreateTemp("", "products-import-*")
+	if err != nil {
+		log.Error("failed to create import file", "error", err)
+		http.Error(rw, "failed to save import", http.StatusInternalServerError)
+		return
+	}
+
+	size, err := io.Copy(f, io.LimitReader(r.Body, maxImportSize+1))
+	f.Close()
+	if err != nil || size > maxImportSize {
+		os.Remove(f.Name())
+
+		if size > maxImportSize {
+			http.Error(rw, fmt.Sprintf("imports are limited to %d bytes", maxImportSize), http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(rw, "failed to read import", http.StatusBadRequest)
+		return
+	}
+
+	p := importPayload{
+		Path:      f.Name(),
+		Format:    c.Name,
+		DryRun:    dryRun,
+		Size:      size,
+		Actor:     middleware.ActorFromContext(r.Context()),
+		RequestID: middleware.RequestIDFromContext(r.Context()),
+	}
+	payload, _ := json.Marshal(p)
+
+	qj := &queue.Job{Kind: ImportKind, Payload: payload, MaxAttempts: 1, TimeoutSeconds: int(importTimeout / time.Second)}
+	if sc := tracing.SpanContextFromContext(r.Context()); sc.IsValid() {
+		qj.Traceparent = sc.Traceparent()
+	}
+
+	if err := h.queue.Enqueue(qj); err != nil {
+		os.Remove(f.Name())
+		log.Error("failed to queue import", "error", err)
+		http.Error(rw, "failed to queue import", http.StatusInternalServerError)
+		return
+	}
+
+	job := h.job(qj.ID, p).snapshot()
+	log.Info("product import queued", "job", job.ID, "format", job.Format, "dry_run", dryRun, "bytes", size)
+
+	rw.Header().Set("Location", fmt.Sprintf("/products:import/%d", job.ID))
+	rw.WriteHeader(http.StatusAccepted)
+	if err := respond(rw, r, job); err != nil {
+		log.Warn("failed to write import job", "error", err)
+	}
+}
+
+// job returns the report of the import id, created from p if there is
+// none, e.g. for the imports queued before a restart.
+func (h *Catalog) job(id uint64, p importPayload) *importJob {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	if j, ok := h.jobs[id]; ok {
+		return j
+	}
+
+	// forget the jobs finished long ago
+	for id, j := range h.jobs {
+		job := j.snapshot()
+		if job.FinishedAt != nil && time.Since(*job.FinishedAt) > importJobTTL {
+			delete(h.jobs, id)
+		}
+	}
+
+	j := &importJob{job: ImportJob{
+		ID:        id,
+		Status:    ImportQueued,
+		DryRun:    p.DryRun,
+		Format:    p.Format,
+		CreatedAt: time.Now(),
+		Progress:  ImportProgress{BytesTotal: p.Size},
+		Errors:    []RowError{},
+	}}
+	h.jobs[id] = j
+
+	return j
+}
+
+// runImport is the handler of the import jobs. It upserts the products
+// of the file of the import by SKU, recording the rows that fail. The
+// changes are made on behalf of the request that queued the import. An
+// import interrupted by the queue stopping keeps its file, to run again
+// at the next start.
+func (h *Catalog) runImport(ctx context.Context, payload json.RawMessage) error {
+	var p importPayload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return queue.Permanent(fmt.Errorf("invalid import: %w", err))
+	}
+
+	c, ok := codec.Default.ByName(p.Format)
+	if !ok || c.NewReader == nil {
+		os.Remove(p.Path)
+		return queue.Permanent(fmt.Errorf("products cannot be imported from '%s'", p.Format))
+	}
+
+	qj, _ := queue.JobFromContext(ctx)
+	j := h.job(qj.ID, p)
+	log := h.logger.With("job", qj.ID)
+
+	ctx = middleware.ContextWithActor(ctx, p.Actor)
+	ctx = middleware.ContextWithRequestID(ctx, p.RequestID)
+
+	ctx, span := tracing.Start(ctx, "import products",
+		tracing.WithAttributes("import.job", qj.ID, "import.format", c.Name))
+	defer span.End()
+
+	started := time.Now()
+	atomic.StoreInt64(&j.bytesRead, 0)
+	j.update(func(job *ImportJob) {
+		job.Status = ImportRunning
+		job.StartedAt = &started
+		job.Progress = ImportProgress{BytesTotal: job.Progress.BytesTotal}
+		job.Errors = []RowError{}
+		job.ErrorsTruncated = false
+	})
+
+	err := h.importFile(ctx, j, c, p.Path)
+	if errors.Is(err, context.Canceled) {
+		j.update(func(job *ImportJob) {
+			job.Status = ImportQueued
+		})
+		log.Warn("product import interrupted, it will run again")
+		return err
+	}
+	os.Remove(p.Path)
+
+	finished := time.Now()
+	job := j.snapshot()
+	j.update(func(job *ImportJob) {
+		job.FinishedAt = &finished
+		job.Status = ImportSucceeded
+		if err != nil {
+			job.Status = ImportFailed
+			job.Error = err.Error()
+		}
+	})
+
+	span.SetAttributes("import.rows", job.Progress.Rows, "import.failed", job.Progress.Failed)
+	if err != nil {
+		span.RecordError(err)
+		log.Error("product import failed", "error", err, "rows", job.Progress.Rows)
+		return queue.Permanent(err)
+	}
+
+	log.Info("product import finished",
+		"rows", job.Progress.Rows,
+		"created", job.Progress.Created,
+		"updated", job.Progress.Updated,
+		"failed", job.Progress.Failed,
+		"dry_run", job.DryRun,
+		"duration", finished.Sub(started))
+
+	return nil
+}
+
+func (h *Catalog) importFile(ctx context.Context, j *importJob, c *codec.Codec, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open import: %w", err)
+	}
+	defer f.Close()
+
+	dryRun := j.snapshot().DryRun
+	rr := c.NewReader(&countingReader{r: f, n: &j.bytesRead})
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		p := &data.Product{}
+		err := rr.Read(p)
+		if err == io.EOF {
+			return nil
+		}
+
+		var recErr *codec.RecordError
+		if err != nil && !errors.As(err, &recErr) {
+			return err
+		}
+
+		created := false
+		if err == nil {
+			release := data.HoldWrites()
+			created, err = importProduct(ctx, p, dryRun)
+			release()
+		}
+
+		j.update(func(job *ImportJob) {
+			job.Progress.Rows++
+
+			switch {
+			case err != nil:
+				job.Progress.Failed++
+				if len(job.Errors) == maxImportErrors {
+					job.ErrorsTruncated = true
+					return
+				}
+
+				msg := err.Error()
+				if recErr != nil {
+					msg = recErr.Err.Error()
+				}
+				job.Errors = append(job.Errors, RowError{Line: rr.Line(), SKU: p.SKU, Error: msg})
+
+			case created:
+				job.Progress.Created++
+
+			default:
+				job.Progress.Updated++
+			}
+		})
+	}
+}
+
+// importProduct upserts p by SKU, or only checks it in dry-run mode.
+func importProduct(ctx context.Context, p *data.Product, dryRun bool) (bool, error) {
+	if p.SKU == "" {
+		return false, &data.ValidationError{Field: "sku", Msg: "is required"}
+	}
+
+	if err := data.Validate(p); err != nil {
+		return false, err
+	}
+
+	// products are identified by SKU, IDs in the file are ignored
+	p.ID = 0
+
+	if dryRun {
+		_, err := data.GetProductBySKU(p.SKU)
+		return err != nil, nil
+	}
+
+	return data.UpsertProduct(ctx, p)
+}
+
+func (h *Catalog) getImport(rw http.ResponseWriter, r *http.Request) {
+	id, err := getItemID(importJobRe, r.URL.Path)
+	if err != nil {
+		http.Error(rw, "invalid import ID", http.StatusBadRequest)
+		return
+	}
+
+	h.mtx.Lock()
+	j, ok := h.jobs[id]
+	h.mtx.Unlock()
+
+	if !ok {
+		http.Error(rw, "import not found", http.StatusNotFound)
+		return
+	}
+
+	if err := respond(rw, r, j.snapshot()); err != nil {
+		http.Error(rw, "failed to retrieve import", http.StatusInternalServerError)
+	}
+}
+
+func (h *Catalog) listImports(rw http.ResponseWriter, r *http.Request) {
+	h.mtx.Lock()
+	jobs := make([]ImportJob, 0, len(h.jobs))
+	for _, j := range h.jobs {
+		jobs = append(jobs, j.snapshot())
+	}
+	h.mtx.Unlock()
+
+	sort.Slice(jobs, func(i, k int) bool { return jobs[i].ID > jobs[k].ID })
+
+	if err := respond(rw, r, jobs); err != nil {
+		http.Error(rw, "failed to retrieve imports", http.StatusInternalServerError)
+	}
+}
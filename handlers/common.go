@@ -1,9 +1,17 @@
 package handlers
 
 import (
+	"context"
 	"fmt"
+	"net/http"
+	"net/url"
 	"regexp"
 	"strconv"
+	"time"
+
+	"github.com/imariom/products-api/codec"
+	"github.com/imariom/products-api/data"
+	"github.com/imariom/products-api/tracing"
 )
 
 func getItemID(regex *regexp.Regexp, exp string) (uint64, error) {
@@ -13,25 +21,128 @@ func getItemID(regex *regexp.Regexp, exp string) (uint64, error) {
 		return 0, fmt.Errorf("id not found")
 	}
 
-	// convert id to integer
-	id, _ := strconv.Atoi(matches[1])
+	// convert id to integer, failing if it overflows
+	id, err := strconv.ParseUint(matches[1], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid id: %w", err)
+	}
 
-	return uint64(id), nil
+	return id, nil
 }
 
-func getQueryParams(query string) (limit int, sort string) {
-	limit = 0
+// getQueryParams parses the limit, offset and sort parameters of list
+// requests. Invalid values are ignored.
+func getQueryParams(query string) (limit, offset int, sort string) {
 	sort = "asc"
 
-	queryRegex := regexp.MustCompile(`(limit=(\d+))|(sort=(asc|desc))`)
-	matches := queryRegex.FindStringSubmatch(query)
-	if matches != nil {
-		if matches[2] != "" {
-			limit, _ = strconv.Atoi(matches[2])
-		} else if matches[4] != "" {
-			sort = matches[4]
-		}
+	values, _ := url.ParseQuery(query)
+
+	if n, err := strconv.Atoi(values.Get("limit")); err == nil && n > 0 {
+		limit = n
+	}
+
+	if n, err := strconv.Atoi(values.Get("offset")); err == nil && n > 0 {
+		offset = n
+	}
+
+	if s := values.Get("sort"); s == "asc" || s == "desc" {
+		sort = s
 	}
 
 	return
 }
+
+// getAsOf parses the as_of query parameter of the reads of past states,
+// an RFC 3339 time, answering 400 if it is invalid. It reports whether
+// the parameter is set, and false as ok if the request was answered.
+func getAsOf(rw http.ResponseWriter, r *http.Request) (t time.Time, set, ok bool) {
+	v := r.URL.Query().Get("as_of")
+	if v == "" {
+		return time.Time{}, false, true
+	}
+
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		http.Error(rw, "invalid as_of, expected an RFC 3339 time", http.StatusBadRequest)
+		return time.Time{}, false, false
+	}
+
+	return t, true, true
+}
+
+// traceStore starts a span around a call to the data store made while
+// serving r. The caller must end the span once the call returns.
+func traceStore(r *http.Request, operation string) *tracing.Span {
+	return traceStoreContext(r.Context(), operation)
+}
+
+// traceStoreContext is traceStore for calls made outside of the handler
+// of a request, such as GraphQL resolvers.
+func traceStoreContext(ctx context.Context, operation string) *tracing.Span {
+	_, span := tracing.Start(ctx, "store "+operation,
+		tracing.WithAttributes(
+			"db.system", data.StoreName,
+			"db.operation", operation,
+		))
+
+	return span
+}
+
+type formatKey struct{}
+
+// negotiate checks the format of the body of r and selects the format
+// of the response, answering 406 or 415 when they are not supported.
+// It returns false if the request was answered.
+func negotiate(rw http.ResponseWriter, r *http.Request) (*http.Request, bool) {
+	if r.Method == http.MethodPost || r.Method == http.MethodPut || r.Method == http.MethodPatch {
+		if _, err := codec.Default.ForRequest(r); err != nil {
+			http.Error(rw, err.Error(), http.StatusUnsupportedMediaType)
+			return r, false
+		}
+	}
+
+	c, err := codec.Default.Negotiate(r)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusNotAcceptable)
+		return r, false
+	}
+
+	rw.Header().Set("Content-Type", c.ContentType())
+	return r.WithContext(context.WithValue(r.Context(), formatKey{}, c)), true
+}
+
+// encoder is implemented by data collections that must be encoded
+// while holding the lock of the data store.
+type encoder interface {
+	Encode(enc func(v interface{}) error) error
+}
+
+// format returns the format of the response negotiated for r.
+func format(r *http.Request) *codec.Codec {
+	if c, ok := r.Context().Value(formatKey{}).(*codec.Codec); ok {
+		return c
+	}
+
+	return codec.JSON
+}
+
+// respond encodes v in the format negotiated with the client.
+func respond(rw http.ResponseWriter, r *http.Request, v interface{}) error {
+	c := format(r)
+
+	if e, ok := v.(encoder); ok {
+		return e.Encode(func(v interface{}) error { return c.Encode(rw, v) })
+	}
+
+	return c.Encode(rw, v)
+}
+
+// decodeBody decodes the request body according to its Content-Type.
+func decodeBody(r *http.Request, v interface{}) error {
+	c, err := codec.Default.ForRequest(r)
+	if err != nil {
+		return err
+	}
+
+	return c.Decode(r.Body, v)
+}
@@ -0,0 +1,414 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/imariom/products-api/events"
+	"github.com/imariom/products-api/logging"
+	"github.com/imariom/products-api/websocket"
+)
+
+const (
+	// DefaultEventsHeartbeat is the default interval of the comments
+	// (SSE) and pings (WebSocket) keeping idle streams alive.
+	DefaultEventsHeartbeat = 15 * time.Second
+
+	// eventsRetry is the reconnection delay advised to SSE clients.
+	eventsRetry = 3 * time.Second
+
+	// eventsWriteTimeout bounds the time to write a single message.
+	eventsWriteTimeout = 10 * time.Second
+)
+
+// EventsOptions configures the events endpoint.
+type EventsOptions struct {
+	// Heartbeat is the interval of the keep-alive messages,
+	// DefaultEventsHeartbeat if zero.
+	Heartbeat time.Duration
+}
+
+// EventsReset is sent to the clients resuming from an event no longer
+// buffered, or issued before a restart: some events were missed, so the
+// state of the resources should be fetched again. LastID is the last
+// event published when the stream started.
+type EventsReset struct {
+	Type   string `json:"type"` // always "reset"
+	LastID uint64 `json:"last_id"`
+}
+
+// EventsSubscriptions is sent to WebSocket clients when their topics
+// change.
+type EventsSubscriptions struct {
+	Type   string   `json:"type"` // always "subscriptions"
+	Topics []string `json:"topics"`
+}
+
+// EventsCommand is sent by WebSocket clients to change their topics.
+type EventsCommand struct {
+	Action string   `json:"action"` // subscribe or unsubscribe
+	Topics []string `json:"topics"`
+}
+
+// eventsError is sent to WebSocket clients for invalid commands.
+type eventsError struct {
+	Type  string `json:"type"` // always "error"
+	Error string `json:"error"`
+}
+
+// Events is the HTTP handler streaming the changes of the data store
+// (/events), as server-sent events or over a WebSocket.
+type Events struct {
+	logger    *logging.Logger
+	heartbeat time.Duration
+
+	done     chan struct{}
+	shutdown sync.Once
+
+	// the WebSocket streams, which the HTTP server no longer tracks
+	conns sync.WaitGroup
+}
+
+// NewEvents creates the events handler.
+func NewEvents(l *logging.Logger, opts EventsOptions) *Events {
+	if opts.Heartbeat <= 0 {
+		opts.Heartbeat = DefaultEventsHeartbeat
+	}
+
+	return &Events{
+		logger:    l,
+		heartbeat: opts.Heartbeat,
+		done:      make(chan struct{}),
+	}
+}
+
+// Shutdown ends the streams being served, so the server can shut down
+// gracefully. Clients are expected to reconnect to another instance.
+func (h *Events) Shutdown() {
+	h.shutdown.Do(func() {
+		close(h.done)
+	})
+}
+
+// Wait waits for the WebSocket streams ended by Shutdown to be closed,
+// or for ctx to be done.
+func (h *Events) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		h.conns.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ServeHTTP streams the events published on the topics given with the
+// topic query parameter, all of them by default. Requests upgrading to
+// the WebSocket protocol get them as JSON messages, the other ones as
+// server-sent events.
+func (h *Events) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/events" {
+		http.NotFound(rw, r)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		rw.Header().Set("Allow", "GET")
+		http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	topics := parseTopics(r.URL.Query()["topic"])
+	if len(topics) == 0 {
+		topics = []string{"*"}
+	}
+
+	// events resumed after the event lastID, see Bus.SubscribeAfter
+	var lastID *uint64
+	id := r.Header.Get("Last-Event-ID")
+	if id == "" {
+		id = r.URL.Query().Get("last_event_id")
+	}
+	if id != "" {
+		n, err := strconv.ParseUint(id, 10, 64)
+		if err != nil {
+			http.Error(rw, "invalid last event ID", http.StatusBadRequest)
+			return
+		}
+		lastID = &n
+	}
+
+	if websocket.IsUpgrade(r) {
+		h.serveWebSocket(rw, r, topics, lastID)
+		return
+	}
+
+	h.serveSSE(rw, r, topics, lastID)
+}
+
+// subscribe subscribes to topics, returning the events to send before
+// the ones of the subscription and, when some were missed, the reset
+// message to send first.
+func subscribe(topics []string, lastID *uint64) (*events.Subscription, []events.Event, *EventsReset) {
+	bus := events.Default()
+	if lastID == nil {
+		return bus.Subscribe(topics), nil, nil
+	}
+
+	sub, replay, complete := bus.SubscribeAfter(topics, *lastID)
+	if complete {
+		return sub, replay, nil
+	}
+
+	return sub, replay, &EventsReset{Type: "reset", LastID: bus.LastID()}
+}
+
+// serveSSE streams the events as server-sent events. Each event carries
+// its ID so clients resume with Last-Event-ID after reconnecting.
+func (h *Events) serveSSE(rw http.ResponseWriter, r *http.Request, topics []string, lastID *uint64) {
+	ctx := r.Context()
+	h.logger.For(ctx).Debug("received an events stream request", "topics", topics)
+
+	rc := http.NewResponseController(rw)
+
+	// the write timeout of the server does not apply to the stream
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil && err != http.ErrNotSupported {
+		h.logger.For(ctx).Warn("failed to clear the write deadline of the events stream", "error", err)
+	}
+
+	sub, replay, reset := subscribe(topics, lastID)
+	defer sub.Close()
+
+	rw.Header().Set("Content-Type", "text/event-stream")
+	rw.Header().Set("Cache-Control", "no-cache")
+	rw.Header().Set("X-Accel-Buffering", "no")
+	rw.WriteHeader(http.StatusOK)
+
+	write := func(format string, args ...interface{}) error {
+		rc.SetWriteDeadline(time.Now().Add(eventsWriteTimeout))
+		if _, err := fmt.Fprintf(rw, format, args...); err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+
+	writeEvent := func(e events.Event) error {
+		b, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		return write("id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, b)
+	}
+
+	if err := write("retry: %d\n\n", eventsRetry.Milliseconds()); err != nil {
+		return
+	}
+
+	if reset != nil {
+		b, _ := json.Marshal(reset)
+		if err := write("event: reset\ndata: %s\n\n", b); err != nil {
+			return
+		}
+	}
+
+	for _, e := range replay {
+		if err := writeEvent(e); err != nil {
+			return
+		}
+	}
+
+	heartbeat := time.NewTicker(h.heartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case e, ok := <-sub.C:
+			if !ok {
+				// the client resumes from its last event once reconnected
+				h.logger.For(ctx).Warn("events stream fell too far behind, closing it")
+				return
+			}
+
+			if err := writeEvent(e); err != nil {
+				h.logger.For(ctx).Debug("events stream closed", "error", err)
+				return
+			}
+
+		case <-heartbeat.C:
+			if err := write(": heartbeat\n\n"); err != nil {
+				return
+			}
+
+		case <-h.done:
+			return
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// serveWebSocket sends the events as JSON messages over a WebSocket.
+// The client changes its topics by sending EventsCommand messages.
+func (h *Events) serveWebSocket(rw http.ResponseWriter, r *http.Request, topics []string, lastID *uint64) {
+	ctx := r.Context()
+	h.logger.For(ctx).Debug("received an events WebSocket request", "topics", topics)
+
+	conn, err := websocket.Upgrade(rw, r)
+	if err != nil {
+		h.logger.For(ctx).Debug("failed to upgrade the events request", "error", err)
+		return
+	}
+
+	h.conns.Add(1)
+	defer h.conns.Done()
+
+	sub, replay, reset := subscribe(topics, lastID)
+	defer sub.Close()
+
+	send := func(v interface{}) error {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		return conn.WriteText(b, time.Now().Add(eventsWriteTimeout))
+	}
+
+	// commands of the client, read until the connection is closed
+	closed := make(chan error, 1)
+	go func() {
+		for {
+			text, msg, err := conn.ReadMessage()
+			if err != nil {
+				closed <- err
+				return
+			}
+
+			var cmd EventsCommand
+			if !text || json.Unmarshal(msg, &cmd) != nil {
+				send(eventsError{Type: "error", Error: "invalid command"})
+				continue
+			}
+
+			filters := sub.Filters()
+			switch cmd.Action {
+			case "subscribe":
+				filters = addTopics(filters, parseTopics(cmd.Topics))
+			case "unsubscribe":
+				filters = removeTopics(filters, parseTopics(cmd.Topics))
+			default:
+				send(eventsError{Type: "error", Error: fmt.Sprintf("unknown action '%s'", cmd.Action)})
+				continue
+			}
+
+			sub.SetFilters(filters)
+			send(EventsSubscriptions{Type: "subscriptions", Topics: filters})
+		}
+	}()
+
+	if err := send(EventsSubscriptions{Type: "subscriptions", Topics: topics}); err != nil {
+		conn.Close(websocket.CloseInternalError, "")
+		return
+	}
+
+	if reset != nil {
+		send(reset)
+	}
+
+	for _, e := range replay {
+		if err := send(e); err != nil {
+			conn.Close(websocket.CloseInternalError, "")
+			return
+		}
+	}
+
+	heartbeat := time.NewTicker(h.heartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case e, ok := <-sub.C:
+			if !ok {
+				conn.Close(websocket.CloseTryAgainLater, "fell too far behind the events")
+				return
+			}
+
+			if err := send(e); err != nil {
+				conn.Close(websocket.CloseInternalError, "")
+				return
+			}
+
+		case <-heartbeat.C:
+			if err := conn.Ping(time.Now().Add(eventsWriteTimeout)); err != nil {
+				conn.Close(websocket.CloseInternalError, "")
+				return
+			}
+
+		case err := <-closed:
+			h.logger.For(ctx).Debug("events WebSocket closed", "error", err)
+			conn.Close(websocket.CloseNormal, "")
+			return
+
+		case <-h.done:
+			conn.Close(websocket.CloseGoingAway, "server is shutting down")
+			return
+		}
+	}
+}
+
+// parseTopics splits the comma separated topics of values, dropping the
+// empty ones.
+func parseTopics(values []string) []string {
+	var topics []string
+	for _, v := range values {
+		for _, t := range strings.Split(v, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				topics = append(topics, t)
+			}
+		}
+	}
+
+	return topics
+}
+
+func addTopics(topics, add []string) []string {
+	for _, t := range add {
+		if !containsTopic(topics, t) {
+			topics = append(topics, t)
+		}
+	}
+
+	return topics
+}
+
+func removeTopics(topics, remove []string) []string {
+	kept := []string{}
+	for _, t := range topics {
+		if !containsTopic(remove, t) {
+			kept = append(kept, t)
+		}
+	}
+
+	return kept
+}
+
+func containsTopic(topics []string, topic string) bool {
+	for _, t := range topics {
+		if t == topic {
+			return true
+		}
+	}
+
+	return false
+}
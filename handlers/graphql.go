@@ -0,0 +1,881 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/imariom/products-api/data"
+	"github.com/imariom/products-api/graphql"
+	"github.com/imariom/products-api/logging"
+)
+
+// GraphQLOptions configures the GraphQL endpoint.
+type GraphQLOptions struct {
+	// MaxDepth and MaxComplexity limit the queries, see graphql.Schema.
+	MaxDepth      int
+	MaxComplexity int
+}
+
+// GraphQL serves the products, carts and users as a GraphQL API.
+type GraphQL struct {
+	logger *logging.Logger
+	schema *graphql.Schema
+}
+
+// NewGraphQL creates the GraphQL handler.
+func NewGraphQL(l *logging.Logger, opts GraphQLOptions) (*GraphQL, error) {
+	schema, err := storeSchema(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &GraphQL{l, schema}, nil
+}
+
+// ServeHTTP runs GraphQL queries sent with GET or POST. A GET request
+// without a query returns the schema.
+func (h *GraphQL) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	h.logger.For(r.Context()).Debug("received a GraphQL request")
+
+	if r.Method == http.MethodGet && r.URL.Query().Get("query") == "" {
+		rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprint(rw, h.schema.SDL())
+		return
+	}
+
+	req, err := graphql.ParseRequest(r)
+	if err != nil {
+		if re, ok := err.(*graphql.RequestError); ok {
+			if re.Status == http.StatusMethodNotAllowed {
+				rw.Header().Set("Allow", "GET, POST")
+			}
+			http.Error(rw, re.Message, re.Status)
+			return
+		}
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// transactional batches hold off writes while they run
+	defer lockWrites(r)()
+
+	resp := h.schema.Execute(withLoaders(r.Context()), req)
+
+	rw.Header().Set("Content-Type", "application/json")
+	if !resp.Executed() {
+		rw.WriteHeader(http.StatusBadRequest)
+	}
+
+	if err := json.NewEncoder(rw).Encode(resp); err != nil {
+		h.logger.For(r.Context()).Error("failed to encode GraphQL response", "error", err)
+	}
+}
+
+type loadersKey struct{}
+
+// loaders batch the lookups of the relations of a request.
+type loaders struct {
+	products  *graphql.Loader
+	users     *graphql.Loader
+	userCarts *graphql.Loader
+}
+
+func withLoaders(ctx context.Context) context.Context {
+	l := &loaders{
+		products: graphql.NewLoader(func(ctx context.Context, keys []interface{}) (map[interface{}]interface{}, error) {
+			span := traceStoreContext(ctx, "GetProductsByID")
+			products := data.GetProductsByID(loaderIDs(keys))
+			span.End()
+
+			values := make(map[interface{}]interface{}, len(products))
+			for id, p := range products {
+				values[id] = p
+			}
+			return values, nil
+		}),
+
+		users: graphql.NewLoader(func(ctx context.Context, keys []interface{}) (map[interface{}]interface{}, error) {
+			span := traceStoreContext(ctx, "GetUsersByID")
+			users := data.GetUsersByID(loaderIDs(keys))
+			span.End()
+
+			values := make(map[interface{}]interface{}, len(users))
+			for id, u := range users {
+				values[id] = u
+			}
+			return values, nil
+		}),
+
+		userCarts: graphql.NewLoader(func(ctx context.Context, keys []interface{}) (map[interface{}]interface{}, error) {
+			span := traceStoreContext(ctx, "GetAllUsersCarts")
+			carts := data.GetAllUsersCarts(loaderIDs(keys))
+			span.End()
+
+			values := make(map[interface{}]interface{}, len(carts))
+			for id, c := range carts {
+				values[id] = c
+			}
+			return values, nil
+		}),
+	}
+
+	return context.WithValue(ctx, loadersKey{}, l)
+}
+
+func loadersFrom(ctx context.Context) *loaders {
+	return ctx.Value(loadersKey{}).(*loaders)
+}
+
+func loaderIDs(keys []interface{}) []uint64 {
+	ids := make([]uint64, len(keys))
+	for i, k := range keys {
+		ids[i] = k.(uint64)
+	}
+
+	return ids
+}
+
+// idArg parses the ID argument name.
+func idArg(p graphql.ResolveParams, name string) (uint64, error) {
+	s, _ := p.Args[name].(string)
+
+	id, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %q", name, s)
+	}
+
+	return id, nil
+}
+
+// intArg returns the Int argument name, or zero.
+func intArg(p graphql.ResolveParams, name string) int {
+	n, _ := p.Args[name].(int)
+	return n
+}
+
+// storeSchema builds the GraphQL schema of the data store.
+func storeSchema(opts GraphQLOptions) (*graphql.Schema, error) {
+	nonNull := graphql.NonNullOf
+	listOf := func(t graphql.Type) graphql.Type {
+		return nonNull(graphql.ListOf(nonNull(t)))
+	}
+
+	product := &graphql.Object{
+		Name:        "Product",
+		Description: "A product of the catalog.",
+		Fields: []*graphql.Field{
+			{Name: "id", Type: nonNull(graphql.ID)},
+			{Name: "sku", Type: graphql.String},
+			{Name: "name", Type: nonNull(graphql.String)},
+			{Name: "description", Type: graphql.String},
+			{Name: "category", Type: nonNull(graphql.String)},
+			{Name: "image", Type: graphql.String},
+			{Name: "price", Type: nonNull(graphql.Float)},
+			{
+				Name:        "maxQuantity",
+				Description: "The maximum quantity of the product in a cart, or null for no maximum.",
+				Type:        graphql.Int,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					if max := p.Source.(*data.Product).MaxQuantity; max > 0 {
+						return max, nil
+					}
+					return nil, nil
+				},
+			},
+		},
+	}
+
+	category := &graphql.Object{
+		Name:        "Category",
+		Description: "A product category and the number of products in it.",
+		Fields: []*graphql.Field{
+			{Name: "name", Type: nonNull(graphql.String)},
+			{Name: "count", Type: nonNull(graphql.Int)},
+		},
+	}
+
+	address := &graphql.Object{
+		Name: "Address",
+		Fields: []*graphql.Field{
+			{Name: "city", Type: graphql.String},
+			{Name: "street", Type: graphql.String},
+			{Name: "number", Type: graphql.Int},
+			{
+				Name: "zipCode",
+				Type: graphql.String,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*data.Address).ZipCode, nil
+				},
+			},
+		},
+	}
+
+	item := &graphql.Object{
+		Name:        "Item",
+		Description: "A product in a cart.",
+		Fields: []*graphql.Field{
+			{
+				Name: "productId",
+				Type: nonNull(graphql.ID),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(data.Item).ProductID, nil
+				},
+			},
+			{Name: "quantity", Type: nonNull(graphql.Int)},
+			{
+				Name:        "unavailable",
+				Description: "Whether the product was removed after it was added to the cart.",
+				Type:        nonNull(graphql.Boolean),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(data.Item).Unavailable, nil
+				},
+			},
+			{
+				Name:        "product",
+				Description: "The product, or null if it no longer exists.",
+				Type:        product,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return loadersFrom(p.Context).products.Load(p.Context, p.Source.(data.Item).ProductID), nil
+				},
+			},
+		},
+	}
+
+	user := &graphql.Object{
+		Name:        "User",
+		Description: "A user of the store. Passwords are never returned.",
+	}
+
+	cart := &graphql.Object{
+		Name:        "Cart",
+		Description: "A shopping cart of a user.",
+		Fields: []*graphql.Field{
+			{Name: "id", Type: nonNull(graphql.ID)},
+			{Name: "userId", Type: nonNull(graphql.ID)},
+			{
+				Name:        "date",
+				Description: "The time of the last update of the cart, in RFC 3339 format.",
+				Type:        nonNull(graphql.String),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*data.Cart).Date.Format(time.RFC3339), nil
+				},
+			},
+			{
+				Name: "items",
+				Type: listOf(item),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					if items := p.Source.(*data.Cart).Products; items != nil {
+						return items, nil
+					}
+					return []data.Item{}, nil
+				},
+			},
+			{
+				Name:        "user",
+				Description: "The owner of the cart, or null if it no longer exists or is a guest cart.",
+				Type:        user,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					c := p.Source.(*data.Cart)
+					if c.Guest {
+						return nil, nil
+					}
+					return loadersFrom(p.Context).users.Load(p.Context, c.UserID), nil
+				},
+			},
+		},
+	}
+
+	user.Fields = []*graphql.Field{
+		{Name: "id", Type: nonNull(graphql.ID)},
+		{Name: "username", Type: nonNull(graphql.String)},
+		{Name: "name", Type: graphql.String},
+		{Name: "phone", Type: graphql.String},
+		{
+			Name: "address",
+			Type: address,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return p.Source.(*data.User).Address, nil
+			},
+		},
+		{
+			Name: "carts",
+			Type: listOf(cart),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return loadersFrom(p.Context).userCarts.Load(p.Context, p.Source.(*data.User).ID), nil
+			},
+		},
+	}
+
+	listArgs := []*graphql.Argument{
+		{Name: "limit", Type: graphql.Int, Description: "Maximum number of results."},
+		{Name: "offset", Type: graphql.Int, Description: "Number of results to skip."},
+		{Name: "sort", Type: graphql.String, Default: "asc", Description: "Sort order, asc or desc."},
+	}
+
+	query := &graphql.Object{
+		Name: "Query",
+		Fields: []*graphql.Field{
+			{
+				Name: "product",
+				Type: product,
+				Args: []*graphql.Argument{{Name: "id", Type: nonNull(graphql.ID)}},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					id, err := idArg(p, "id")
+					if err != nil {
+						return nil, err
+					}
+					return loadersFrom(p.Context).products.Load(p.Context, id), nil
+				},
+			},
+			{
+				Name: "productBySku",
+				Type: product,
+				Args: []*graphql.Argument{{Name: "sku", Type: nonNull(graphql.String)}},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					span := traceStoreContext(p.Context, "GetProductBySKU")
+					prod, err := data.GetProductBySKU(p.Args["sku"].(string))
+					span.End()
+					if err != nil {
+						return nil, nil
+					}
+					return prod, nil
+				},
+			},
+			{
+				Name:        "products",
+				Description: "The products sorted by price, optionally in a single category.",
+				Type:        listOf(product),
+				Args: append(listArgs[:3:3],
+					&graphql.Argument{Name: "category", Type: graphql.String}),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					if c, ok := p.Args["category"].(string); ok {
+						span := traceStoreContext(p.Context, "GetProductsByCategory")
+						products := data.GetProductsByCategory(c)
+						span.End()
+						return page(products, intArg(p, "limit"), intArg(p, "offset")), nil
+					}
+
+					span := traceStoreContext(p.Context, "GetAllProducts")
+					products := data.GetAllProducts(intArg(p, "limit"), intArg(p, "offset"), sortArg(p))
+					span.End()
+					return products, nil
+				},
+			},
+			{
+				Name: "categories",
+				Type: listOf(category),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					span := traceStoreContext(p.Context, "GetAllCategories")
+					categories := data.GetAllCategories()
+					span.End()
+
+					list := make([]map[string]interface{}, 0, len(categories))
+					for name, count := range categories {
+						list = append(list, map[string]interface{}{"name": name, "count": count})
+					}
+					sort.Slice(list, func(i, j int) bool {
+						return list[i]["name"].(string) < list[j]["name"].(string)
+					})
+					return list, nil
+				},
+			},
+			{
+				Name: "cart",
+				Type: cart,
+				Args: []*graphql.Argument{{Name: "id", Type: nonNull(graphql.ID)}},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					id, err := idArg(p, "id")
+					if err != nil {
+						return nil, err
+					}
+
+					span := traceStoreContext(p.Context, "GetCart")
+					c, err := data.GetCart(id)
+					span.End()
+					if err != nil {
+						return nil, nil
+					}
+					return c, nil
+				},
+			},
+			{
+				Name:        "carts",
+				Description: "The carts sorted by date, optionally of a single user.",
+				Type:        listOf(cart),
+				Args: append(listArgs[:3:3],
+					&graphql.Argument{Name: "userId", Type: graphql.ID}),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					if _, ok := p.Args["userId"]; ok {
+						id, err := idArg(p, "userId")
+						if err != nil {
+							return nil, err
+						}
+
+						span := traceStoreContext(p.Context, "GetAllUserCarts")
+						carts := data.GetAllUserCarts(id)
+						span.End()
+						return page(carts, intArg(p, "limit"), intArg(p, "offset")), nil
+					}
+
+					span := traceStoreContext(p.Context, "GetAllCarts")
+					carts := data.GetAllCarts(intArg(p, "limit"), intArg(p, "offset"), sortArg(p))
+					span.End()
+					return carts, nil
+				},
+			},
+			{
+				Name: "user",
+				Type: user,
+				Args: []*graphql.Argument{{Name: "id", Type: nonNull(graphql.ID)}},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					id, err := idArg(p, "id")
+					if err != nil {
+						return nil, err
+					}
+					return loadersFrom(p.Context).users.Load(p.Context, id), nil
+				},
+			},
+			{
+				Name: "users",
+				Type: listOf(user),
+				Args: listArgs[:2:2],
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					span := traceStoreContext(p.Context, "GetAllUsers")
+					users := data.GetAllUsers()
+					span.End()
+					return page(users, intArg(p, "limit"), intArg(p, "offset")), nil
+				},
+			},
+		},
+	}
+
+	mutation, err := storeMutations(product, cart, user)
+	if err != nil {
+		return nil, err
+	}
+
+	return graphql.NewSchema(graphql.Schema{
+		Query:         query,
+		Mutation:      mutation,
+		MaxDepth:      opts.MaxDepth,
+		MaxComplexity: opts.MaxComplexity,
+	})
+}
+
+// sortArg returns the sort argument of a list.
+func sortArg(p graphql.ResolveParams) string {
+	if s, _ := p.Args["sort"].(string); s == "desc" {
+		return s
+	}
+
+	return "asc"
+}
+
+// page skips the first offset elements of the slice list and returns at
+// most limit elements.
+func page(list interface{}, limit, offset int) interface{} {
+	switch l := list.(type) {
+	case data.Products:
+		lo, hi := pageBounds(len(l), limit, offset)
+		return l[lo:hi]
+	case data.Carts:
+		lo, hi := pageBounds(len(l), limit, offset)
+		return l[lo:hi]
+	case data.Users:
+		lo, hi := pageBounds(len(l), limit, offset)
+		return l[lo:hi]
+	}
+
+	return list
+}
+
+func pageBounds(n, limit, offset int) (int, int) {
+	if offset < 0 || offset > n {
+		offset = n
+	}
+
+	if limit <= 0 || limit > n-offset {
+		limit = n - offset
+	}
+
+	return offset, offset + limit
+}
+
+// storeMutations builds the mutations of the GraphQL schema, which wrap
+// the data store functions used by the REST handlers.
+func storeMutations(product, cart, user *graphql.Object) (*graphql.Object, error) {
+	nonNull := graphql.NonNullOf
+	idArgs := []*graphql.Argument{{Name: "id", Type: nonNull(graphql.ID)}}
+
+	productInput := &graphql.InputObject{
+		Name:        "ProductInput",
+		Description: "The attributes of a product. Omitted attributes are left unchanged by updateProduct.",
+		Fields: []*graphql.Argument{
+			{Name: "sku", Type: graphql.String},
+			{Name: "name", Type: graphql.String},
+			{Name: "description", Type: graphql.String},
+			{Name: "category", Type: graphql.String},
+			{Name: "image", Type: graphql.String},
+			{Name: "price", Type: graphql.Float},
+			{Name: "maxQuantity", Type: graphql.Int},
+		},
+	}
+
+	itemInput := &graphql.InputObject{
+		Name: "ItemInput",
+		Fields: []*graphql.Argument{
+			{Name: "productId", Type: nonNull(graphql.ID)},
+			{Name: "quantity", Type: nonNull(graphql.Int)},
+		},
+	}
+
+	cartInput := &graphql.InputObject{
+		Name:        "CartInput",
+		Description: "The attributes of a cart. Omitted attributes are left unchanged by updateCart.",
+		Fields: []*graphql.Argument{
+			{Name: "userId", Type: graphql.ID},
+			{Name: "items", Type: graphql.ListOf(nonNull(itemInput))},
+		},
+	}
+
+	addressInput := &graphql.InputObject{
+		Name: "AddressInput",
+		Fields: []*graphql.Argument{
+			{Name: "city", Type: graphql.String},
+			{Name: "street", Type: graphql.String},
+			{Name: "number", Type: graphql.Int},
+			{Name: "zipCode", Type: graphql.String},
+		},
+	}
+
+	userInput := &graphql.InputObject{
+		Name:        "UserInput",
+		Description: "The attributes of a user. Omitted attributes are left unchanged by updateUser.",
+		Fields: []*graphql.Argument{
+			{Name: "username", Type: graphql.String},
+			{Name: "password", Type: graphql.String},
+			{Name: "name", Type: graphql.String},
+			{Name: "phone", Type: graphql.String},
+			{Name: "address", Type: addressInput},
+		},
+	}
+
+	inputArgs := func(t *graphql.InputObject, withID bool) []*graphql.Argument {
+		args := []*graphql.Argument{{Name: "input", Type: nonNull(t)}}
+		if withID {
+			args = append(idArgs[:1:1], args...)
+		}
+		return args
+	}
+
+	return &graphql.Object{
+		Name: "Mutation",
+		Fields: []*graphql.Field{
+			{
+				Name: "createProduct",
+				Type: nonNull(product),
+				Args: inputArgs(productInput, false),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					prod := productFromInput(p.Args["input"])
+					if err := data.Validate(prod); err != nil {
+						return nil, err
+					}
+
+					span := traceStoreContext(p.Context, "AddNewProduct")
+					err := data.AddNewProduct(p.Context, prod)
+					span.End()
+					return prod, err
+				},
+			},
+			{
+				Name:        "replaceProduct",
+				Description: "Replaces all the attributes of a product.",
+				Type:        nonNull(product),
+				Args:        inputArgs(productInput, true),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					id, err := idArg(p, "id")
+					if err != nil {
+						return nil, err
+					}
+
+					prod := productFromInput(p.Args["input"])
+					prod.ID = id
+					if err := data.Validate(prod); err != nil {
+						return nil, err
+					}
+
+					span := traceStoreContext(p.Context, "UpdateProduct")
+					err = data.UpdateProduct(p.Context, prod)
+					span.End()
+					return prod, err
+				},
+			},
+			{
+				Name:        "updateProduct",
+				Description: "Updates the given attributes of a product.",
+				Type:        nonNull(product),
+				Args:        inputArgs(productInput, true),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					id, err := idArg(p, "id")
+					if err != nil {
+						return nil, err
+					}
+
+					prod := productFromInput(p.Args["input"])
+					prod.ID = id
+
+					span := traceStoreContext(p.Context, "SetProduct")
+					err = data.SetProduct(p.Context, prod)
+					span.End()
+					return prod, err
+				},
+			},
+			{
+				Name: "deleteProduct",
+				Type: nonNull(product),
+				Args: idArgs,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					id, err := idArg(p, "id")
+					if err != nil {
+						return nil, err
+					}
+
+					span := traceStoreContext(p.Context, "RemoveProduct")
+					defer span.End()
+					return data.RemoveProduct(p.Context, id)
+				},
+			},
+			{
+				Name: "createCart",
+				Type: nonNull(cart),
+				Args: inputArgs(cartInput, false),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					c, err := cartFromInput(p.Args["input"])
+					if err != nil {
+						return nil, err
+					}
+					if err := data.Validate(c); err != nil {
+						return nil, err
+					}
+					c.Date = time.Now()
+
+					span := traceStoreContext(p.Context, "AddCart")
+					err = data.AddCart(p.Context, c)
+					span.End()
+					return c, err
+				},
+			},
+			{
+				Name:        "replaceCart",
+				Description: "Replaces all the attributes of a cart.",
+				Type:        nonNull(cart),
+				Args:        inputArgs(cartInput, true),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return updateCart(p, "UpdateCart", data.UpdateCart)
+				},
+			},
+			{
+				Name:        "updateCart",
+				Description: "Updates the given attributes of a cart.",
+				Type:        nonNull(cart),
+				Args:        inputArgs(cartInput, true),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return updateCart(p, "SetCart", data.SetCart)
+				},
+			},
+			{
+				Name: "deleteCart",
+				Type: nonNull(cart),
+				Args: idArgs,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					id, err := idArg(p, "id")
+					if err != nil {
+						return nil, err
+					}
+
+					span := traceStoreContext(p.Context, "RemoveCart")
+					defer span.End()
+					return data.RemoveCart(p.Context, id)
+				},
+			},
+			{
+				Name: "createUser",
+				Type: nonNull(user),
+				Args: inputArgs(userInput, false),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					u := userFromInput(p.Args["input"])
+					if err := data.Validate(u); err != nil {
+						return nil, err
+					}
+
+					span := traceStoreContext(p.Context, "AddNewUser")
+					data.AddNewUser(p.Context, u)
+					span.End()
+					return u, nil
+				},
+			},
+			{
+				Name:        "replaceUser",
+				Description: "Replaces all the attributes of a user.",
+				Type:        nonNull(user),
+				Args:        inputArgs(userInput, true),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					id, err := idArg(p, "id")
+					if err != nil {
+						return nil, err
+					}
+
+					u := userFromInput(p.Args["input"])
+					u.ID = id
+					if err := data.Validate(u); err != nil {
+						return nil, err
+					}
+
+					span := traceStoreContext(p.Context, "UpdateUser")
+					err = data.UpdateUser(p.Context, u)
+					span.End()
+					return u, err
+				},
+			},
+			{
+				Name:        "updateUser",
+				Description: "Updates the given attributes of a user.",
+				Type:        nonNull(user),
+				Args:        inputArgs(userInput, true),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					id, err := idArg(p, "id")
+					if err != nil {
+						return nil, err
+					}
+
+					u := userFromInput(p.Args["input"])
+					u.ID = id
+
+					span := traceStoreContext(p.Context, "SetUser")
+					err = data.SetUser(p.Context, u)
+					span.End()
+					return u, err
+				},
+			},
+			{
+				Name: "deleteUser",
+				Type: nonNull(user),
+				Args: idArgs,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					id, err := idArg(p, "id")
+					if err != nil {
+						return nil, err
+					}
+
+					span := traceStoreContext(p.Context, "RemoveUser")
+					defer span.End()
+					return data.RemoveUser(p.Context, id)
+				},
+			},
+		},
+	}, nil
+}
+
+// updateCart replaces or patches a cart with update.
+func updateCart(p graphql.ResolveParams, operation string, update func(context.Context, *data.Cart) error) (interface{}, error) {
+	id, err := idArg(p, "id")
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := cartFromInput(p.Args["input"])
+	if err != nil {
+		return nil, err
+	}
+	c.ID = id
+
+	// carts have no required attributes, so partial updates are
+	// validated the same way as whole ones
+	if err := data.Validate(c); err != nil {
+		return nil, err
+	}
+	c.Date = time.Now()
+
+	span := traceStoreContext(p.Context, operation)
+	err = update(p.Context, c)
+	span.End()
+	return c, err
+}
+
+func productFromInput(input interface{}) *data.Product {
+	in := input.(map[string]interface{})
+	p := &data.Product{}
+
+	p.SKU, _ = in["sku"].(string)
+	p.Name, _ = in["name"].(string)
+	p.Description, _ = in["description"].(string)
+	p.Category, _ = in["category"].(string)
+	p.Image, _ = in["image"].(string)
+	p.Price, _ = in["price"].(float64)
+	if max, ok := in["maxQuantity"].(int); ok && max > 0 {
+		p.MaxQuantity = uint64(max)
+	}
+
+	return p
+}
+
+func cartFromInput(input interface{}) (*data.Cart, error) {
+	in := input.(map[string]interface{})
+	c := &data.Cart{}
+
+	if s, ok := in["userId"].(string); ok {
+		id, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid userId: %q", s)
+		}
+		c.UserID = id
+	}
+
+	if items, ok := in["items"].([]interface{}); ok {
+		c.Products = make([]data.Item, 0, len(items))
+		for _, item := range items {
+			fields := item.(map[string]interface{})
+
+			s := fields["productId"].(string)
+			id, err := strconv.ParseUint(s, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid productId: %q", s)
+			}
+
+			quantity := fields["quantity"].(int)
+			if quantity < 0 {
+				return nil, fmt.Errorf("invalid quantity: %d", quantity)
+			}
+
+			c.Products = append(c.Products, data.Item{ProductID: id, Quantity: uint64(quantity)})
+		}
+	}
+
+	return c, nil
+}
+
+func userFromInput(input interface{}) *data.User {
+	in := input.(map[string]interface{})
+	u := &data.User{Address: &data.Address{}}
+
+	u.Username, _ = in["username"].(string)
+	u.Password, _ = in["password"].(string)
+	u.Name, _ = in["name"].(string)
+	u.Phone, _ = in["phone"].(string)
+
+	if addr, ok := in["address"].(map[string]interface{}); ok {
+		u.City, _ = addr["city"].(string)
+		u.Street, _ = addr["street"].(string)
+		u.ZipCode, _ = addr["zipCode"].(string)
+		if n, ok := addr["number"].(int); ok && n > 0 {
+			u.Number = uint64(n)
+		}
+	}
+
+	return u
+}
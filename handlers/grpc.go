@@ -0,0 +1,612 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/imariom/products-api/data"
+	"github.com/imariom/products-api/events"
+	"github.com/imariom/products-api/grpc"
+	"github.com/imariom/products-api/logging"
+	"github.com/imariom/products-api/storepb"
+)
+
+// GRPC serves the products, carts and users over gRPC, wrapping the
+// data store functions used by the REST handlers.
+type GRPC struct {
+	logger *logging.Logger
+}
+
+// NewGRPC creates the gRPC services.
+func NewGRPC(l *logging.Logger) *GRPC {
+	return &GRPC{l}
+}
+
+// File returns the store services to register on a grpc.Server.
+func (h *GRPC) File() *grpc.File {
+	return &grpc.File{
+		Name:     storepb.FileName,
+		Package:  storepb.Package,
+		Messages: storepb.Messages,
+		Enums:    storepb.Enums,
+		Services: []*grpc.ServiceDesc{
+			{
+				Name: storepb.Package + ".ProductService",
+				Methods: []grpc.MethodDesc{
+					unary("GetProduct", &storepb.GetProductRequest{}, &storepb.Product{}, h.getProduct),
+					unary("ListProducts", &storepb.ListProductsRequest{}, &storepb.ListProductsResponse{}, h.listProducts),
+					unary("CreateProduct", &storepb.CreateProductRequest{}, &storepb.Product{}, h.createProduct),
+					unary("ReplaceProduct", &storepb.ReplaceProductRequest{}, &storepb.Product{}, h.replaceProduct),
+					unary("UpdateProduct", &storepb.UpdateProductRequest{}, &storepb.Product{}, h.updateProduct),
+					unary("DeleteProduct", &storepb.DeleteProductRequest{}, &storepb.Product{}, h.deleteProduct),
+					unary("ListCategories", &storepb.ListCategoriesRequest{}, &storepb.ListCategoriesResponse{}, h.listCategories),
+					{
+						Name:            "WatchProducts",
+						Request:         &storepb.WatchProductsRequest{},
+						Response:        &storepb.ProductEvent{},
+						ServerStreaming: true,
+						Stream:          h.watchProducts,
+					},
+				},
+			},
+			{
+				Name: storepb.Package + ".CartService",
+				Methods: []grpc.MethodDesc{
+					unary("GetCart", &storepb.GetCartRequest{}, &storepb.Cart{}, h.getCart),
+					unary("ListCarts", &storepb.ListCartsRequest{}, &storepb.ListCartsResponse{}, h.listCarts),
+					unary("ListUserCarts", &storepb.ListUserCartsRequest{}, &storepb.ListCartsResponse{}, h.listUserCarts),
+					unary("CreateCart", &storepb.CreateCartRequest{}, &storepb.Cart{}, h.createCart),
+					unary("ReplaceCart", &storepb.ReplaceCartRequest{}, &storepb.Cart{}, h.replaceCart),
+					unary("UpdateCart", &storepb.UpdateCartRequest{}, &storepb.Cart{}, h.updateCart),
+					unary("DeleteCart", &storepb.DeleteCartRequest{}, &storepb.Cart{}, h.deleteCart),
+				},
+			},
+			{
+				Name: storepb.Package + ".UserService",
+				Methods: []grpc.MethodDesc{
+					unary("GetUser", &storepb.GetUserRequest{}, &storepb.User{}, h.getUser),
+					unary("ListUsers", &storepb.ListUsersRequest{}, &storepb.ListUsersResponse{}, h.listUsers),
+					unary("CreateUser", &storepb.CreateUserRequest{}, &storepb.User{}, h.createUser),
+					unary("ReplaceUser", &storepb.ReplaceUserRequest{}, &storepb.User{}, h.replaceUser),
+					unary("UpdateUser", &storepb.UpdateUserRequest{}, &storepb.User{}, h.updateUser),
+					unary("DeleteUser", &storepb.DeleteUserRequest{}, &storepb.User{}, h.deleteUser),
+				},
+			},
+		},
+	}
+}
+
+func unary(name string, req, resp interface{}, handler grpc.UnaryHandler) grpc.MethodDesc {
+	return grpc.MethodDesc{Name: name, Request: req, Response: resp, Unary: handler}
+}
+
+// grpcWrite holds off transactional batches while a call writes to the
+// data stores, see lockWrites.
+func grpcWrite(ctx context.Context, operation string, fn func() error) error {
+	defer data.HoldWrites()()
+
+	span := traceStoreContext(ctx, operation)
+	defer span.End()
+
+	return fn()
+}
+
+// grpcError maps the errors of the data store to status codes. Besides
+// the errors below, the store only fails reads and writes of records
+// that do not exist.
+func grpcError(err error) error {
+	var ve *data.ValidationError
+
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &ve):
+		return grpc.Errorf(grpc.InvalidArgument, "%v", err)
+	case errors.Is(err, data.ErrDuplicateSKU):
+		return grpc.Errorf(grpc.AlreadyExists, "%v", err)
+	case errors.Is(err, data.ErrReferenced):
+		return grpc.Errorf(grpc.FailedPrecondition, "%v", err)
+	}
+
+	return grpc.Errorf(grpc.NotFound, "%v", err)
+}
+
+func (h *GRPC) getProduct(ctx context.Context, req interface{}) (interface{}, error) {
+	h.logger.For(ctx).Debug("received a GetProduct call")
+
+	span := traceStoreContext(ctx, "GetProduct")
+	p, err := data.GetProduct(req.(*storepb.GetProductRequest).ID)
+	span.End()
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	return productToPB(p), nil
+}
+
+func (h *GRPC) listProducts(ctx context.Context, req interface{}) (interface{}, error) {
+	h.logger.For(ctx).Debug("received a ListProducts call")
+	in := req.(*storepb.ListProductsRequest)
+
+	var products data.Products
+	if in.Category != "" {
+		span := traceStoreContext(ctx, "GetProductsByCategory")
+		products = data.GetProductsByCategory(in.Category)
+		span.End()
+		products = page(products, int(in.Limit), int(in.Offset)).(data.Products)
+	} else {
+		span := traceStoreContext(ctx, "GetAllProducts")
+		products = data.GetAllProducts(int(in.Limit), int(in.Offset), in.Sort)
+		span.End()
+	}
+
+	resp := &storepb.ListProductsResponse{}
+	for _, p := range products {
+		resp.Products = append(resp.Products, productToPB(p))
+	}
+
+	return resp, nil
+}
+
+func (h *GRPC) createProduct(ctx context.Context, req interface{}) (interface{}, error) {
+	h.logger.For(ctx).Debug("received a CreateProduct call")
+
+	p := productFromPB(req.(*storepb.CreateProductRequest).Product)
+	if err := data.Validate(p); err != nil {
+		return nil, grpcError(err)
+	}
+
+	err := grpcWrite(ctx, "AddNewProduct", func() error {
+		return data.AddNewProduct(ctx, p)
+	})
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	return productToPB(p), nil
+}
+
+func (h *GRPC) replaceProduct(ctx context.Context, req interface{}) (interface{}, error) {
+	h.logger.For(ctx).Debug("received a ReplaceProduct call")
+	in := req.(*storepb.ReplaceProductRequest)
+
+	p := productFromPB(in.Product)
+	p.ID = in.ID
+	if err := data.Validate(p); err != nil {
+		return nil, grpcError(err)
+	}
+
+	err := grpcWrite(ctx, "UpdateProduct", func() error {
+		return data.UpdateProduct(ctx, p)
+	})
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	return productToPB(p), nil
+}
+
+func (h *GRPC) updateProduct(ctx context.Context, req interface{}) (interface{}, error) {
+	h.logger.For(ctx).Debug("received an UpdateProduct call")
+	in := req.(*storepb.UpdateProductRequest)
+
+	p := productFromPB(in.Product)
+	p.ID = in.ID
+
+	err := grpcWrite(ctx, "SetProduct", func() error {
+		return data.SetProduct(ctx, p)
+	})
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	return productToPB(p), nil
+}
+
+func (h *GRPC) deleteProduct(ctx context.Context, req interface{}) (interface{}, error) {
+	h.logger.For(ctx).Debug("received a DeleteProduct call")
+
+	var p *data.Product
+	err := grpcWrite(ctx, "RemoveProduct", func() (err error) {
+		p, err = data.RemoveProduct(ctx, req.(*storepb.DeleteProductRequest).ID)
+		return err
+	})
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	return productToPB(p), nil
+}
+
+func (h *GRPC) listCategories(ctx context.Context, req interface{}) (interface{}, error) {
+	h.logger.For(ctx).Debug("received a ListCategories call")
+
+	span := traceStoreContext(ctx, "GetAllCategories")
+	categories := data.GetAllCategories()
+	span.End()
+
+	resp := &storepb.ListCategoriesResponse{}
+	for name, count := range categories {
+		resp.Categories = append(resp.Categories, &storepb.Category{Name: name, Count: uint32(count)})
+	}
+	sort.Slice(resp.Categories, func(i, j int) bool {
+		return resp.Categories[i].Name < resp.Categories[j].Name
+	})
+
+	return resp, nil
+}
+
+// watchProducts streams the changes of the products. Changes made
+// while the existing products are sent are not missed since the watch
+// starts first.
+func (h *GRPC) watchProducts(ss *grpc.ServerStream) error {
+	ctx := ss.Context()
+	h.logger.For(ctx).Debug("received a WatchProducts call")
+
+	in := &storepb.WatchProductsRequest{}
+	if err := ss.RecvMsg(in); err != nil {
+		return err
+	}
+
+	topic := "products"
+	if in.Category != "" {
+		topic = "products:category:" + in.Category
+	}
+
+	sub := events.Default().Subscribe([]string{topic})
+	defer sub.Close()
+
+	if in.IncludeExisting {
+		var products data.Products
+		err := data.ExportProducts(100, func(batch data.Products) error {
+			products = append(products, batch...)
+			return nil
+		})
+		if err != nil {
+			return grpcError(err)
+		}
+
+		for _, p := range products {
+			if in.Category != "" && p.Category != in.Category {
+				continue
+			}
+
+			event := &storepb.ProductEvent{Type: storepb.EventExisting, Product: productToPB(p)}
+			if err := ss.SendMsg(event); err != nil {
+				return err
+			}
+		}
+	}
+
+	eventTypes := map[string]storepb.EventType{
+		data.ProductCreated:  storepb.EventCreated,
+		data.ProductUpdated:  storepb.EventUpdated,
+		data.ProductDeleted:  storepb.EventDeleted,
+		data.ProductRestored: storepb.EventCreated,
+	}
+
+	for {
+		select {
+		case e, ok := <-sub.C:
+			if !ok {
+				return grpc.Errorf(grpc.ResourceExhausted, "the watcher fell too far behind the changes")
+			}
+
+			p, ok := e.Data.(*data.Product)
+			if !ok {
+				continue
+			}
+
+			event := &storepb.ProductEvent{Type: eventTypes[e.Type], Product: productToPB(p)}
+			if err := ss.SendMsg(event); err != nil {
+				return err
+			}
+
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (h *GRPC) getCart(ctx context.Context, req interface{}) (interface{}, error) {
+	h.logger.For(ctx).Debug("received a GetCart call")
+
+	span := traceStoreContext(ctx, "GetCart")
+	c, err := data.GetCart(req.(*storepb.GetCartRequest).ID)
+	span.End()
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	return cartToPB(c), nil
+}
+
+func (h *GRPC) listCarts(ctx context.Context, req interface{}) (interface{}, error) {
+	h.logger.For(ctx).Debug("received a ListCarts call")
+	in := req.(*storepb.ListCartsRequest)
+
+	span := traceStoreContext(ctx, "GetAllCarts")
+	carts := data.GetAllCarts(int(in.Limit), int(in.Offset), in.Sort)
+	span.End()
+
+	return cartsToPB(carts), nil
+}
+
+func (h *GRPC) listUserCarts(ctx context.Context, req interface{}) (interface{}, error) {
+	h.logger.For(ctx).Debug("received a ListUserCarts call")
+
+	span := traceStoreContext(ctx, "GetAllUserCarts")
+	carts := data.GetAllUserCarts(req.(*storepb.ListUserCartsRequest).UserID)
+	span.End()
+
+	return cartsToPB(carts), nil
+}
+
+func (h *GRPC) createCart(ctx context.Context, req interface{}) (interface{}, error) {
+	h.logger.For(ctx).Debug("received a CreateCart call")
+
+	c := cartFromPB(req.(*storepb.CreateCartRequest).Cart)
+	if err := data.Validate(c); err != nil {
+		return nil, grpcError(err)
+	}
+	c.Date = time.Now()
+
+	err := grpcWrite(ctx, "AddCart", func() error {
+		return data.AddCart(ctx, c)
+	})
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	return cartToPB(c), nil
+}
+
+func (h *GRPC) replaceCart(ctx context.Context, req interface{}) (interface{}, error) {
+	h.logger.For(ctx).Debug("received a ReplaceCart call")
+	in := req.(*storepb.ReplaceCartRequest)
+
+	return h.writeCart(ctx, in.ID, in.Cart, "UpdateCart", data.UpdateCart)
+}
+
+func (h *GRPC) updateCart(ctx context.Context, req interface{}) (interface{}, error) {
+	h.logger.For(ctx).Debug("received an UpdateCart call")
+	in := req.(*storepb.UpdateCartRequest)
+
+	return h.writeCart(ctx, in.ID, in.Cart, "SetCart", data.SetCart)
+}
+
+// writeCart replaces or patches the cart id with update.
+func (h *GRPC) writeCart(ctx context.Context, id uint64, in *storepb.Cart, operation string, update func(context.Context, *data.Cart) error) (interface{}, error) {
+	c := cartFromPB(in)
+	c.ID = id
+
+	// carts have no required attributes, so partial updates are
+	// validated the same way as whole ones
+	if err := data.Validate(c); err != nil {
+		return nil, grpcError(err)
+	}
+	c.Date = time.Now()
+
+	err := grpcWrite(ctx, operation, func() error {
+		return update(ctx, c)
+	})
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	return cartToPB(c), nil
+}
+
+func (h *GRPC) deleteCart(ctx context.Context, req interface{}) (interface{}, error) {
+	h.logger.For(ctx).Debug("received a DeleteCart call")
+
+	var c *data.Cart
+	err := grpcWrite(ctx, "RemoveCart", func() (err error) {
+		c, err = data.RemoveCart(ctx, req.(*storepb.DeleteCartRequest).ID)
+		return err
+	})
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	return cartToPB(c), nil
+}
+
+func (h *GRPC) getUser(ctx context.Context, req interface{}) (interface{}, error) {
+	h.logger.For(ctx).Debug("received a GetUser call")
+
+	span := traceStoreContext(ctx, "GetUser")
+	u, err := data.GetUser(req.(*storepb.GetUserRequest).ID)
+	span.End()
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	return userToPB(u), nil
+}
+
+func (h *GRPC) listUsers(ctx context.Context, req interface{}) (interface{}, error) {
+	h.logger.For(ctx).Debug("received a ListUsers call")
+	in := req.(*storepb.ListUsersRequest)
+
+	span := traceStoreContext(ctx, "GetAllUsers")
+	users := data.GetAllUsers()
+	span.End()
+
+	resp := &storepb.ListUsersResponse{}
+	for _, u := range page(users, int(in.Limit), int(in.Offset)).(data.Users) {
+		resp.Users = append(resp.Users, userToPB(u))
+	}
+
+	return resp, nil
+}
+
+func (h *GRPC) createUser(ctx context.Context, req interface{}) (interface{}, error) {
+	h.logger.For(ctx).Debug("received a CreateUser call")
+
+	u := userFromPB(req.(*storepb.CreateUserRequest).User)
+	if err := data.Validate(u); err != nil {
+		return nil, grpcError(err)
+	}
+
+	grpcWrite(ctx, "AddNewUser", func() error {
+		data.AddNewUser(ctx, u)
+		return nil
+	})
+
+	return userToPB(u), nil
+}
+
+func (h *GRPC) replaceUser(ctx context.Context, req interface{}) (interface{}, error) {
+	h.logger.For(ctx).Debug("received a ReplaceUser call")
+	in := req.(*storepb.ReplaceUserRequest)
+
+	u := userFromPB(in.User)
+	u.ID = in.ID
+	if err := data.Validate(u); err != nil {
+		return nil, grpcError(err)
+	}
+
+	err := grpcWrite(ctx, "UpdateUser", func() error {
+		return data.UpdateUser(ctx, u)
+	})
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	return userToPB(u), nil
+}
+
+func (h *GRPC) updateUser(ctx context.Context, req interface{}) (interface{}, error) {
+	h.logger.For(ctx).Debug("received an UpdateUser call")
+	in := req.(*storepb.UpdateUserRequest)
+
+	u := userFromPB(in.User)
+	u.ID = in.ID
+
+	err := grpcWrite(ctx, "SetUser", func() error {
+		return data.SetUser(ctx, u)
+	})
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	return userToPB(u), nil
+}
+
+func (h *GRPC) deleteUser(ctx context.Context, req interface{}) (interface{}, error) {
+	h.logger.For(ctx).Debug("received a DeleteUser call")
+
+	var u *data.User
+	err := grpcWrite(ctx, "RemoveUser", func() (err error) {
+		u, err = data.RemoveUser(ctx, req.(*storepb.DeleteUserRequest).ID)
+		return err
+	})
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	return userToPB(u), nil
+}
+
+func productToPB(p *data.Product) *storepb.Product {
+	return &storepb.Product{
+		ID:          p.ID,
+		SKU:         p.SKU,
+		Name:        p.Name,
+		Description: p.Description,
+		Category:    p.Category,
+		Image:       p.Image,
+		Price:       p.Price,
+		MaxQuantity: p.MaxQuantity,
+	}
+}
+
+func productFromPB(p *storepb.Product) *data.Product {
+	if p == nil {
+		return &data.Product{}
+	}
+
+	return &data.Product{
+		SKU:         p.SKU,
+		Name:        p.Name,
+		Description: p.Description,
+		Category:    p.Category,
+		Image:       p.Image,
+		Price:       p.Price,
+		MaxQuantity: p.MaxQuantity,
+	}
+}
+
+func cartToPB(c *data.Cart) *storepb.Cart {
+	pb := &storepb.Cart{ID: c.ID, UserID: c.UserID, Date: c.Date.Format(time.RFC3339)}
+	for _, item := range c.Products {
+		pb.Items = append(pb.Items, &storepb.Item{ProductID: item.ProductID, Quantity: item.Quantity, Unavailable: item.Unavailable})
+	}
+
+	return pb
+}
+
+func cartsToPB(carts data.Carts) *storepb.ListCartsResponse {
+	resp := &storepb.ListCartsResponse{}
+	for _, c := range carts {
+		resp.Carts = append(resp.Carts, cartToPB(c))
+	}
+
+	return resp
+}
+
+// cartFromPB leaves the items of the cart nil when none are given, so
+// partial updates keep them.
+func cartFromPB(c *storepb.Cart) *data.Cart {
+	if c == nil {
+		return &data.Cart{}
+	}
+
+	cart := &data.Cart{UserID: c.UserID}
+	for _, item := range c.Items {
+		if item != nil {
+			cart.Products = append(cart.Products, data.Item{ProductID: item.ProductID, Quantity: item.Quantity})
+		}
+	}
+
+	return cart
+}
+
+// userToPB never returns the password of the user.
+func userToPB(u *data.User) *storepb.User {
+	pb := &storepb.User{ID: u.ID, Username: u.Username, Name: u.Name, Phone: u.Phone}
+	if u.Address != nil {
+		pb.Address = &storepb.Address{
+			City:    u.City,
+			Street:  u.Street,
+			Number:  u.Number,
+			ZipCode: u.ZipCode,
+		}
+	}
+
+	return pb
+}
+
+func userFromPB(u *storepb.User) *data.User {
+	user := &data.User{Address: &data.Address{}}
+	if u == nil {
+		return user
+	}
+
+	user.Username = u.Username
+	user.Password = u.Password
+	user.Name = u.Name
+	user.Phone = u.Phone
+
+	if u.Address != nil {
+		user.City = u.Address.City
+		user.Street = u.Address.Street
+		user.Number = u.Address.Number
+		user.ZipCode = u.Address.ZipCode
+	}
+
+	return user
+}
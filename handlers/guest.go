@@ -0,0 +1,314 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/imariom/products-api/data"
+	"github.com/imariom/products-api/logging"
+	"github.com/imariom/products-api/middleware"
+)
+
+// the cookie and the header carrying the token of a guest cart; the
+// header is used by the clients not keeping cookies
+const (
+	CartTokenCookie = "cart_token"
+	CartTokenHeader = "X-Cart-Token"
+)
+
+// DefaultGuestCartTTL is the time guest carts are kept after their last
+// change, unless configured otherwise.
+const DefaultGuestCartTTL = 72 * time.Hour
+
+var (
+	errMissingCartToken = errors.New("missing cart token")
+	errInvalidCartToken = errors.New("invalid cart token")
+)
+
+// GuestCartOptions configures a GuestCart handler.
+type GuestCartOptions struct {
+	// Key signs the cart tokens.
+	Key []byte
+
+	// TTL is the lifetime of the cart cookie, renewed by each change,
+	// DefaultGuestCartTTL if zero.
+	TTL time.Duration
+}
+
+// GuestCart is the HTTP handler of the carts of anonymous customers.
+// A guest cart is identified by a token signed by the API, carried by
+// a cookie or the X-Cart-Token header: /carts/guest creates, reads and
+// replaces it, and /carts/guest:merge gives it to the guest once they
+// are authenticated as a user. Guest carts are not served by /carts.
+type GuestCart struct {
+	logger *logging.Logger
+	opts   GuestCartOptions
+}
+
+// NewGuestCart is a constructor for GuestCart handler.
+func NewGuestCart(l *logging.Logger, opts GuestCartOptions) *GuestCart {
+	if opts.TTL <= 0 {
+		opts.TTL = DefaultGuestCartTTL
+	}
+
+	return &GuestCart{l, opts}
+}
+
+// ServeHTTP implements http.Handler.
+func (h *GuestCart) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	r, ok := negotiate(rw, r)
+	if !ok {
+		return
+	}
+
+	// transactional batches hold off writes while they run
+	defer lockWrites(r)()
+
+	path := r.URL.Path
+	switch {
+	case path == "/carts/guest" && r.Method == http.MethodPost:
+		h.create(rw, r)
+
+	case path == "/carts/guest" && r.Method == http.MethodGet:
+		h.get(rw, r)
+
+	case path == "/carts/guest" && r.Method == http.MethodPut:
+		h.update(rw, r)
+
+	case path == "/carts/guest:merge" && r.Method == http.MethodPost:
+		h.merge(rw, r)
+
+	case path == "/carts/guest" || path == "/carts/guest:merge":
+		http.Error(rw, "HTTP verb not implemented", http.StatusNotImplemented)
+
+	default:
+		http.NotFound(rw, r)
+	}
+}
+
+// create starts a guest cart and returns its token.
+func (h *GuestCart) create(rw http.ResponseWriter, r *http.Request) {
+	h.logger.For(r.Context()).Debug("received a POST guest cart request")
+
+	cart := &data.Cart{}
+	if err := decodeBody(r, cart); err != nil {
+		http.Error(rw, "invalid cart payload", http.StatusBadRequest)
+		return
+	}
+
+	if err := data.Validate(cart); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	cart.Guest = true
+	cart.Date = time.Now()
+
+	storeSpan := traceStore(r, "AddCart")
+	err := data.AddCart(r.Context(), cart)
+	storeSpan.End()
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	h.setToken(rw, r, cart)
+	if err := respond(rw, r, cart); err != nil {
+		http.Error(rw,
+			fmt.Sprintf("guest cart created with ID: '%d', but failed to retrieve it",
+				cart.ID),
+			http.StatusInternalServerError)
+	}
+}
+
+// get returns the guest cart of the token.
+func (h *GuestCart) get(rw http.ResponseWriter, r *http.Request) {
+	h.logger.For(r.Context()).Debug("received a GET guest cart request")
+
+	cart, ok := h.cart(rw, r)
+	if !ok {
+		return
+	}
+
+	if err := respond(rw, r, cart); err != nil {
+		http.Error(rw, "failed to convert cart", http.StatusInternalServerError)
+	}
+}
+
+// update replaces the items of the guest cart of the token.
+func (h *GuestCart) update(rw http.ResponseWriter, r *http.Request) {
+	h.logger.For(r.Context()).Debug("received a PUT guest cart request")
+
+	current, ok := h.cart(rw, r)
+	if !ok {
+		return
+	}
+
+	cart := &data.Cart{}
+	if err := decodeBody(r, cart); err != nil {
+		http.Error(rw, "invalid cart payload", http.StatusBadRequest)
+		return
+	}
+
+	if err := data.Validate(cart); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// the date of the cart is kept, the token is bound to it
+	storeSpan := traceStore(r, "UpdateGuestCart")
+	current, err := data.UpdateGuestCart(r.Context(), current.ID, cart.Products)
+	storeSpan.End()
+	if err != nil {
+		cartStoreError(rw, err)
+		return
+	}
+
+	h.setToken(rw, r, current)
+	if err := respond(rw, r, current); err != nil {
+		http.Error(rw,
+			fmt.Sprintf("guest cart with ID: '%d' was updated sucessfully, but failed to retrieve it",
+				current.ID),
+			http.StatusInternalServerError)
+	}
+}
+
+// merge gives the guest cart of the token to the authenticated user,
+// and clears the cookie.
+func (h *GuestCart) merge(rw http.ResponseWriter, r *http.Request) {
+	h.logger.For(r.Context()).Debug("received a merge guest cart request")
+
+	user, ok := middleware.UserFromContext(r.Context())
+	if !ok {
+		rw.Header().Set("WWW-Authenticate", `Basic realm="store-api"`)
+		http.Error(rw, "the user must be authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	guest, ok := h.cart(rw, r)
+	if !ok {
+		return
+	}
+
+	storeSpan := traceStore(r, "MergeGuestCart")
+	cart, err := data.MergeGuestCart(r.Context(), guest.ID, user.ID)
+	storeSpan.End()
+	if err != nil {
+		cartStoreError(rw, err)
+		return
+	}
+
+	http.SetCookie(rw, &http.Cookie{
+		Name:     CartTokenCookie,
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	if err := respond(rw, r, cart); err != nil {
+		http.Error(rw, "failed to convert cart", http.StatusInternalServerError)
+	}
+}
+
+// cart returns the guest cart of the token of r, answering 401 if the
+// token is missing or not signed by the API, and 404 if the cart was
+// merged or expired.
+func (h *GuestCart) cart(rw http.ResponseWriter, r *http.Request) (*data.Cart, bool) {
+	token := r.Header.Get(CartTokenHeader)
+	if token == "" {
+		if c, err := r.Cookie(CartTokenCookie); err == nil {
+			token = c.Value
+		}
+	}
+
+	id, created, err := h.verify(token)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusUnauthorized)
+		return nil, false
+	}
+
+	storeSpan := traceStore(r, "GetGuestCart")
+	cart, err := data.GetGuestCart(id)
+	storeSpan.End()
+
+	// the IDs of merged and expired carts are given to new carts, which
+	// the tokens of the former must not give access to
+	if err == nil && cart.Date.UnixNano() != created {
+		err = fmt.Errorf("requested cart does not exist")
+	}
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusNotFound)
+		return nil, false
+	}
+
+	return cart, true
+}
+
+// setToken returns the token of the guest cart c in the header and the
+// cookie of the response.
+func (h *GuestCart) setToken(rw http.ResponseWriter, r *http.Request, c *data.Cart) {
+	token := h.sign(c)
+
+	rw.Header().Set(CartTokenHeader, token)
+	http.SetCookie(rw, &http.Cookie{
+		Name:     CartTokenCookie,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   int(h.opts.TTL.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// sign returns the token of the guest cart c: its ID and creation time
+// in nanoseconds, and their HMAC-SHA256, separated by dots.
+func (h *GuestCart) sign(c *data.Cart) string {
+	payload := strconv.FormatUint(c.ID, 10) + "." + strconv.FormatInt(c.Date.UnixNano(), 10)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(h.mac(payload))
+}
+
+// verify returns the ID and the creation time of the guest cart of
+// token.
+func (h *GuestCart) verify(token string) (uint64, int64, error) {
+	if token == "" {
+		return 0, 0, errMissingCartToken
+	}
+
+	i := strings.LastIndexByte(token, '.')
+	if i < 0 {
+		return 0, 0, errInvalidCartToken
+	}
+	payload, sig := token[:i], token[i+1:]
+
+	mac, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(mac, h.mac(payload)) {
+		return 0, 0, errInvalidCartToken
+	}
+
+	id, created, _ := strings.Cut(payload, ".")
+	cartID, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return 0, 0, errInvalidCartToken
+	}
+	nanos, err := strconv.ParseInt(created, 10, 64)
+	if err != nil {
+		return 0, 0, errInvalidCartToken
+	}
+
+	return cartID, nanos, nil
+}
+
+func (h *GuestCart) mac(payload string) []byte {
+	m := hmac.New(sha256.New, h.opts.Key)
+	m.Write([]byte(payload))
+	return m.Sum(nil)
+}
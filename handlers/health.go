@@ -0,0 +1,244 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/imariom/products-api/data"
+	"github.com/imariom/products-api/logging"
+)
+
+// checkTimeout bounds the time a single dependency check may take.
+const checkTimeout = 2 * time.Second
+
+// CheckFunc reports whether a dependency of the API is healthy.
+type CheckFunc func(ctx context.Context) error
+
+// JobState describes the current state of a background job.
+type JobState struct {
+	State     string    `json:"state"`
+	LastRun   time.Time `json:"last_run,omitempty"`
+	NextRun   time.Time `json:"next_run,omitempty"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+// JobStateFunc reports the current state of a background job.
+type JobStateFunc func() JobState
+
+// BuildInfo identifies the running build of the API.
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"build_date"`
+	GoVersion string `json:"go_version"`
+}
+
+// checkResult is the outcome of a single check.
+type checkResult struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// status is the payload returned by the /status endpoint.
+type status struct {
+	Status string                 `json:"status"`
+	Uptime string                 `json:"uptime"`
+	Build  BuildInfo              `json:"build"`
+	Store  storeStatus            `json:"store"`
+	Checks map[string]checkResult `json:"checks"`
+	Jobs   map[string]JobState    `json:"jobs"`
+}
+
+type storeStatus struct {
+	Backend string     `json:"backend"`
+	Status  string     `json:"status"`
+	Error   string     `json:"error,omitempty"`
+	Records data.Stats `json:"records"`
+}
+
+// Health is the HTTP handler for the liveness (/healthz), readiness
+// (/readyz) and detailed status (/status) endpoints.
+type Health struct {
+	logger  *logging.Logger
+	build   BuildInfo
+	started time.Time
+
+	mtx         sync.RWMutex
+	readyChecks map[string]CheckFunc
+	jobs        map[string]JobStateFunc
+}
+
+// NewHealth allocates and construct a new Health handler. The data
+// store is always checked for readiness.
+func NewHealth(l *logging.Logger, build BuildInfo) *Health {
+	if build.GoVersion == "" {
+		build.GoVersion = runtime.Version()
+	}
+
+	h := &Health{
+		logger:      l,
+		build:       build,
+		started:     time.Now(),
+		readyChecks: make(map[string]CheckFunc),
+		jobs:        make(map[string]JobStateFunc),
+	}
+	h.AddReadyCheck("store", data.Ping)
+
+	return h
+}
+
+// AddReadyCheck registers a check that must pass for the API to be
+// considered ready to receive traffic.
+func (h *Health) AddReadyCheck(name string, check CheckFunc) {
+	h.mtx.Lock()
+	h.readyChecks[name] = check
+	h.mtx.Unlock()
+}
+
+// RegisterJob registers a background job whose state is reported by
+// the /status endpoint.
+func (h *Health) RegisterJob(name string, state JobStateFunc) {
+	h.mtx.Lock()
+	h.jobs[name] = state
+	h.mtx.Unlock()
+}
+
+// ServeHTTP is the http.Handler interface implementation method for
+// Health handler.
+func (h *Health) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Set("Cache-Control", "no-store")
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(rw, "HTTP verb not implemented", http.StatusNotImplemented)
+		return
+	}
+
+	switch r.URL.Path {
+	case "/healthz":
+		h.live(rw, r)
+
+	case "/readyz":
+		h.ready(rw, r)
+
+	case "/status":
+		h.status(rw, r)
+
+	default:
+		http.Error(rw, "bad request", http.StatusBadRequest)
+	}
+}
+
+// live reports that the process is up and able to serve requests.
+func (h *Health) live(rw http.ResponseWriter, r *http.Request) {
+	h.writeJSON(rw, http.StatusOK, checkResult{Status: "ok"})
+}
+
+// ready runs every readiness check and fails if any of them fails.
+func (h *Health) ready(rw http.ResponseWriter, r *http.Request) {
+	results, ok := h.runChecks(r.Context())
+
+	code := http.StatusOK
+	overall := "ok"
+	if !ok {
+		code = http.StatusServiceUnavailable
+		overall = "unavailable"
+	}
+
+	h.writeJSON(rw, code, struct {
+		Status string                 `json:"status"`
+		Checks map[string]checkResult `json:"checks"`
+	}{overall, results})
+}
+
+// status reports detailed information about the running API.
+func (h *Health) status(rw http.ResponseWriter, r *http.Request) {
+	results, ok := h.runChecks(r.Context())
+
+	st := status{
+		Status: "ok",
+		Uptime: time.Since(h.started).Truncate(time.Second).String(),
+		Build:  h.build,
+		Store: storeStatus{
+			Backend: data.StoreName,
+			Status:  "ok",
+			Records: data.GetStats(),
+		},
+		Checks: results,
+		Jobs:   make(map[string]JobState),
+	}
+
+	if !ok {
+		st.Status = "unavailable"
+	}
+
+	if res, found := results["store"]; found && res.Error != "" {
+		st.Store.Status = res.Status
+		st.Store.Error = res.Error
+	}
+
+	h.mtx.RLock()
+	for name, state := range h.jobs {
+		st.Jobs[name] = state()
+	}
+	h.mtx.RUnlock()
+
+	h.writeJSON(rw, http.StatusOK, st)
+}
+
+// runChecks runs all readiness checks concurrently.
+func (h *Health) runChecks(ctx context.Context) (map[string]checkResult, bool) {
+	h.mtx.RLock()
+	names := make([]string, 0, len(h.readyChecks))
+	for name := range h.readyChecks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	checks := make([]CheckFunc, len(names))
+	for i, name := range names {
+		checks[i] = h.readyChecks[name]
+	}
+	h.mtx.RUnlock()
+
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	errs := make([]error, len(checks))
+	wg := sync.WaitGroup{}
+	for i, check := range checks {
+		wg.Add(1)
+		go func(i int, check CheckFunc) {
+			defer wg.Done()
+			errs[i] = check(ctx)
+		}(i, check)
+	}
+	wg.Wait()
+
+	ok := true
+	results := make(map[string]checkResult, len(names))
+	for i, name := range names {
+		if errs[i] != nil {
+			ok = false
+			results[name] = checkResult{Status: "failing", Error: errs[i].Error()}
+			continue
+		}
+
+		results[name] = checkResult{Status: "ok"}
+	}
+
+	return results, ok
+}
+
+func (h *Health) writeJSON(rw http.ResponseWriter, code int, v interface{}) {
+	rw.WriteHeader(code)
+
+	if err := json.NewEncoder(rw).Encode(v); err != nil {
+		h.logger.Error("failed to encode health response", "error", err)
+	}
+}
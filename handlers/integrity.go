@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/imariom/products-api/data"
+	"github.com/imariom/products-api/logging"
+)
+
+// Integrity is the HTTP handler of the admin endpoint reporting the
+// carts referencing users or products that do not exist
+// (/admin/integrity).
+type Integrity struct {
+	logger *logging.Logger
+}
+
+// NewIntegrity is a constructor for Integrity handler.
+func NewIntegrity(l *logging.Logger) *Integrity {
+	return &Integrity{l}
+}
+
+// ServeHTTP implements http.Handler.
+func (h *Integrity) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	r, ok := negotiate(rw, r)
+	if !ok {
+		return
+	}
+
+	if r.URL.Path != "/admin/integrity" {
+		http.NotFound(rw, r)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(rw, "HTTP verb not implemented", http.StatusNotImplemented)
+		return
+	}
+
+	h.logger.For(r.Context()).Debug("received a GET integrity request")
+
+	span := traceStore(r, "CheckIntegrity")
+	report := data.CheckIntegrity()
+	span.End()
+
+	if err := respond(rw, r, report); err != nil {
+		http.Error(rw, "failed to retrieve integrity report", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+
+	"github.com/imariom/products-api/data"
+)
+
+// the paths of the items of a cart, changed one at a time so that
+// concurrent changes of different items do not overwrite each other
+var (
+	cartItemsRe = regexp.MustCompile(`^/carts/(\d+)/items$`)
+	cartItemRe  = regexp.MustCompile(`^/carts/(\d+)/items/(\d+)$`)
+)
+
+// addItem adds the quantity of the item in the request body to the
+// cart, merging it with the item of the same product if any.
+func (h *Cart) addItem(rw http.ResponseWriter, r *http.Request) {
+	h.logger.For(r.Context()).Debug("received a POST cart item request")
+
+	cartID, err := getItemID(cartItemsRe, r.URL.Path)
+	if err != nil {
+		http.Error(rw, "invalid cart ID", http.StatusNotFound)
+		return
+	}
+
+	item := &data.Item{}
+	if err := decodeBody(r, item); err != nil {
+		http.Error(rw, "invalid item payload", http.StatusBadRequest)
+		return
+	}
+
+	if err := data.Validate(item); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	storeSpan := traceStore(r, "AdjustItem")
+	cart, err := data.AdjustItem(r.Context(), cartID, item.ProductID, data.ItemIncrement, item.Quantity)
+	storeSpan.End()
+
+	h.respondItems(rw, r, cart, err)
+}
+
+// adjustItem increments, decrements or sets the quantity of an item.
+func (h *Cart) adjustItem(rw http.ResponseWriter, r *http.Request) {
+	h.logger.For(r.Context()).Debug("received a PATCH cart item request")
+
+	cartID, productID, ok := cartItemIDs(rw, r)
+	if !ok {
+		return
+	}
+
+	adj := &data.ItemAdjustment{}
+	if err := decodeBody(r, adj); err != nil {
+		http.Error(rw, "invalid item adjustment payload", http.StatusBadRequest)
+		return
+	}
+	if adj.Op == "" {
+		adj.Op = data.ItemSet
+	}
+
+	storeSpan := traceStore(r, "AdjustItem")
+	cart, err := data.AdjustItem(r.Context(), cartID, productID, adj.Op, adj.Quantity)
+	storeSpan.End()
+
+	h.respondItems(rw, r, cart, err)
+}
+
+// removeItem removes an item from the cart.
+func (h *Cart) removeItem(rw http.ResponseWriter, r *http.Request) {
+	h.logger.For(r.Context()).Debug("received a DELETE cart item request")
+
+	cartID, productID, ok := cartItemIDs(rw, r)
+	if !ok {
+		return
+	}
+
+	storeSpan := traceStore(r, "RemoveItem")
+	cart, err := data.RemoveItem(r.Context(), cartID, productID)
+	storeSpan.End()
+
+	h.respondItems(rw, r, cart, err)
+}
+
+// respondItems returns the cart changed by an item request, or the error
+// of the change.
+func (h *Cart) respondItems(rw http.ResponseWriter, r *http.Request, cart *data.Cart, err error) {
+	if errors.Is(err, data.ErrItemNotFound) {
+		http.Error(rw, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		cartStoreError(rw, err)
+		return
+	}
+
+	if err := respond(rw, r, cart); err != nil {
+		http.Error(rw, "failed to convert cart", http.StatusInternalServerError)
+	}
+}
+
+// cartItemIDs returns the IDs of the cart and the product of an item
+// path.
+func cartItemIDs(rw http.ResponseWriter, r *http.Request) (uint64, uint64, bool) {
+	matches := cartItemRe.FindStringSubmatch(r.URL.Path)
+
+	cartID, err := strconv.ParseUint(matches[1], 10, 64)
+	if err != nil {
+		http.Error(rw, "invalid cart ID", http.StatusNotFound)
+		return 0, 0, false
+	}
+
+	productID, err := strconv.ParseUint(matches[2], 10, 64)
+	if err != nil {
+		http.Error(rw, "invalid product ID", http.StatusNotFound)
+		return 0, 0, false
+	}
+
+	return cartID, productID, true
+}
@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"net/http"
+	"regexp"
+
+	"github.com/imariom/products-api/logging"
+	"github.com/imariom/products-api/scheduler"
+)
+
+var jobRe = regexp.MustCompile(`^/admin/jobs/([\w.-]+)$`)
+
+// Jobs is the HTTP handler of the admin endpoints reporting the status
+// and the next run of the scheduled jobs (/admin/jobs).
+type Jobs struct {
+	logger    *logging.Logger
+	scheduler *scheduler.Scheduler
+}
+
+// NewJobs is a constructor for Jobs handler.
+func NewJobs(l *logging.Logger, s *scheduler.Scheduler) *Jobs {
+	return &Jobs{l, s}
+}
+
+// ServeHTTP implements http.Handler.
+func (h *Jobs) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	r, ok := negotiate(rw, r)
+	if !ok {
+		return
+	}
+
+	path := r.URL.Path
+	switch {
+	case path == "/admin/jobs" && r.Method == http.MethodGet:
+		h.logger.For(r.Context()).Debug("received a GET jobs request")
+
+		if err := respond(rw, r, h.scheduler.Jobs()); err != nil {
+			http.Error(rw, "failed to retrieve jobs", http.StatusInternalServerError)
+		}
+
+	case jobRe.MatchString(path) && r.Method == http.MethodGet:
+		h.logger.For(r.Context()).Debug("received a GET job request")
+
+		job, ok := h.scheduler.Job(jobRe.FindStringSubmatch(path)[1])
+		if !ok {
+			http.Error(rw, "job not found", http.StatusNotFound)
+			return
+		}
+
+		if err := respond(rw, r, job); err != nil {
+			http.Error(rw, "failed to retrieve job", http.StatusInternalServerError)
+		}
+
+	case path == "/admin/jobs" || jobRe.MatchString(path):
+		http.Error(rw, "HTTP verb not implemented", http.StatusNotImplemented)
+
+	default:
+		http.NotFound(rw, r)
+	}
+}
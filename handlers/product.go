@@ -1,12 +1,13 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
-	"log"
 	"net/http"
 	"regexp"
 
 	"github.com/imariom/products-api/data"
+	"github.com/imariom/products-api/logging"
 )
 
 // Product represent the HTTP handler. It handles and serve requests
@@ -15,14 +16,23 @@ type Product struct {
 	// logger is the object used to log information.
 	// The destination of the logs is defined somewhere
 	// by the user of the handler (normally on the main function).
-	logger *log.Logger
+	logger *logging.Logger
 }
 
 // NewProduct is a constructor for Product handler.
-func NewProduct(l *log.Logger) *Product {
+func NewProduct(l *logging.Logger) *Product {
 	return &Product{l}
 }
 
+// restore moves a deleted product back from the trash.
+func (h *Product) restore(rw http.ResponseWriter, r *http.Request) {
+	h.logger.For(r.Context()).Debug("received a restore product request")
+
+	restore(rw, r, restoreProductRe, "RestoreProduct", func(id uint64) (interface{}, error) {
+		return data.RestoreProduct(r.Context(), id)
+	})
+}
+
 // getProduct match regex parameter to the request URL path
 // and if it is able to parse and decode the product information
 // from the request it will create a new product and return it.
@@ -35,7 +45,7 @@ func getProduct(regex *regexp.Regexp, r *http.Request) (*data.Product, error) {
 
 	// decode the product from the request body
 	product := &data.Product{}
-	if err := product.FromJSON(r.Body); err != nil {
+	if err := decodeBody(r, product); err != nil {
 		return nil, fmt.Errorf("invalid product payload")
 	}
 	product.ID = uint64(id)
@@ -46,12 +56,22 @@ func getProduct(regex *regexp.Regexp, r *http.Request) (*data.Product, error) {
 // ServeHTTP is a method implementation of the http.Handler interface.
 // This method turns Product into an HTTP handler.
 func (h *Product) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
-	// set API to be json based (send and receive JSON data)
-	rw.Header().Set("Content-Type", "application/json")
+	// negotiate the format of the request and response bodies
+	r, ok := negotiate(rw, r)
+	if !ok {
+		return
+	}
+
+	// transactional batches hold off writes while they run
+	defer lockWrites(r)()
 
 	// route each incoming request to specific handler
 	switch r.Method {
 	case http.MethodPost:
+		if restoreProductRe.MatchString(r.URL.Path) {
+			h.restore(rw, r)
+			return
+		}
 		h.create(rw, r)
 		return
 
@@ -78,18 +98,31 @@ func (h *Product) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 // create parse and create new product from request body and
 // store this product on internal data store.
 func (h *Product) create(rw http.ResponseWriter, r *http.Request) {
-	h.logger.Println("[INFO] received a POST product request")
+	h.logger.For(r.Context()).Debug("received a POST product request")
 
 	// create and store new product on the data store
 	newProduct := &data.Product{}
-	if err := newProduct.FromJSON(r.Body); err != nil {
+	if err := decodeBody(r, newProduct); err != nil {
 		http.Error(rw, "invalid product payload", http.StatusBadRequest)
 		return
 	}
-	data.AddNewProduct(newProduct)
+
+	if err := data.Validate(newProduct); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	storeSpan := traceStore(r, "AddNewProduct")
+	err := data.AddNewProduct(r.Context(), newProduct)
+	storeSpan.End()
+
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusConflict)
+		return
+	}
 
 	// try to return created product
-	if err := newProduct.ToJSON(rw); err != nil {
+	if err := respond(rw, r, newProduct); err != nil {
 		http.Error(rw,
 			fmt.Sprintf("product with ID '%d' was created, but failed to retrieve it",
 				newProduct.ID),
@@ -101,18 +134,32 @@ func (h *Product) create(rw http.ResponseWriter, r *http.Request) {
 // all products in a specific category or all categories that
 // exist on the data store.
 func (h *Product) get(rw http.ResponseWriter, r *http.Request) {
-	h.logger.Println("[INFO] received a GET product request")
+	h.logger.For(r.Context()).Debug("received a GET product request")
 
 	urlPath := r.URL.Path
-	limitRes, sortCriteria := getQueryParams(r.URL.RawQuery)
+	limitRes, offset, sortCriteria := getQueryParams(r.URL.RawQuery)
+
+	asOf, past, ok := getAsOf(rw, r)
+	if !ok {
+		return
+	}
 
 	// get all products
 	listProductsRe := regexp.MustCompile(`^/products[/]?$`)
 
 	if listProductsRe.MatchString(urlPath) {
-		products := data.GetAllProducts(limitRes, sortCriteria)
+		var products data.Products
+		if past {
+			storeSpan := traceStore(r, "GetAllProductsAsOf")
+			products = data.GetAllProductsAsOf(asOf, limitRes, offset, sortCriteria)
+			storeSpan.End()
+		} else {
+			storeSpan := traceStore(r, "GetAllProducts")
+			products = data.GetAllProducts(limitRes, offset, sortCriteria)
+			storeSpan.End()
+		}
 
-		if err := products.ToJSON(rw); err != nil {
+		if err := respond(rw, r, products); err != nil {
 			http.Error(rw, "failed to retrieve products", http.StatusInternalServerError)
 		}
 		return
@@ -129,27 +176,62 @@ func (h *Product) get(rw http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		// try to get product
-		product, err := data.GetProduct(productId)
+		// try to get product, as it was at as_of if set
+		var product *data.Product
+		if past {
+			storeSpan := traceStore(r, "GetProductAsOf")
+			product, err = data.GetProductAsOf(productId, asOf)
+			storeSpan.End()
+		} else {
+			storeSpan := traceStore(r, "GetProduct")
+			product, err = data.GetProduct(productId)
+			storeSpan.End()
+		}
 		if err != nil {
 			http.Error(rw, err.Error(), http.StatusNotFound)
 			return
 		}
 
 		// try to return the product
-		if err := product.ToJSON(rw); err != nil {
+		if err := respond(rw, r, product); err != nil {
 			http.Error(rw, "failed to retrieve product", http.StatusInternalServerError)
 		}
 		return
 	}
 
+	// get the revisions of a single product
+	productHistoryRe := regexp.MustCompile(`^/products/(\d+)/history$`)
+
+	if productHistoryRe.MatchString(urlPath) {
+		productId, err := getItemID(productHistoryRe, urlPath)
+		if err != nil {
+			http.Error(rw, "invalid product ID", http.StatusBadRequest)
+			return
+		}
+
+		storeSpan := traceStore(r, "GetProductHistory")
+		history, err := data.GetProductHistory(productId)
+		storeSpan.End()
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		if err := respond(rw, r, history); err != nil {
+			http.Error(rw, "failed to retrieve product history", http.StatusInternalServerError)
+		}
+		return
+	}
+
 	// get all product categories
 	categoriesRe := regexp.MustCompile(`^/products/categories[/]?$`)
 
 	if categoriesRe.MatchString(urlPath) {
+		storeSpan := traceStore(r, "GetAllCategories")
 		products := data.GetAllCategories()
+		storeSpan.End()
 
-		if err := products.ToJSON(rw); err != nil {
+		if err := respond(rw, r, products); err != nil {
 			http.Error(rw, "failed to retrieve categories", http.StatusInternalServerError)
 		}
 		return
@@ -167,13 +249,15 @@ func (h *Product) get(rw http.ResponseWriter, r *http.Request) {
 		}
 
 		// try to get all products
+		storeSpan := traceStore(r, "GetProductsByCategory")
 		products := data.GetProductsByCategory(matches[1])
+		storeSpan.End()
 		if len(products) == 0 {
 			http.Error(rw, "category not found", http.StatusNotFound)
 			return
 		}
 
-		if err := products.ToJSON(rw); err != nil {
+		if err := respond(rw, r, products); err != nil {
 			http.Error(rw, "failed to retrieve products", http.StatusInternalServerError)
 		}
 		return
@@ -191,7 +275,7 @@ func (h *Product) update(rw http.ResponseWriter, r *http.Request) {
 	updateProductRe := regexp.MustCompile(`^/products/(\d+)$`)
 
 	if r.Method == http.MethodPut {
-		h.logger.Println("[INFO] received a PUT product request")
+		h.logger.For(r.Context()).Debug("received a PUT product request")
 
 		product, err := getProduct(updateProductRe, r)
 		if err != nil {
@@ -199,14 +283,28 @@ func (h *Product) update(rw http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		if err := data.Validate(product); err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+
 		// update whole product information
-		if err := data.UpdateProduct(product); err != nil {
+		storeSpan := traceStore(r, "UpdateProduct")
+		err = data.UpdateProduct(r.Context(), product)
+		storeSpan.End()
+
+		if errors.Is(err, data.ErrDuplicateSKU) {
+			http.Error(rw, err.Error(), http.StatusConflict)
+			return
+		}
+
+		if err != nil {
 			http.Error(rw, err.Error(), http.StatusNotFound)
 			return
 		}
 
 		// return updated product
-		if err := product.ToJSON(rw); err != nil {
+		if err := respond(rw, r, product); err != nil {
 			http.Error(rw,
 				fmt.Sprintf("product with ID: '%d' was updated, but failed to retrieve it",
 					product.ID),
@@ -218,7 +316,7 @@ func (h *Product) update(rw http.ResponseWriter, r *http.Request) {
 
 	// update specific attributes of a product
 	if r.Method == http.MethodPatch {
-		h.logger.Println("[INFO] received a PATCH product request")
+		h.logger.For(r.Context()).Debug("received a PATCH product request")
 
 		// try to get the product payload and id to be updated (PATCH)
 		product, err := getProduct(updateProductRe, r)
@@ -228,13 +326,22 @@ func (h *Product) update(rw http.ResponseWriter, r *http.Request) {
 		}
 
 		// update product attributes
-		if err := data.SetProduct(product); err != nil {
+		storeSpan := traceStore(r, "SetProduct")
+		err = data.SetProduct(r.Context(), product)
+		storeSpan.End()
+
+		if errors.Is(err, data.ErrDuplicateSKU) {
+			http.Error(rw, err.Error(), http.StatusConflict)
+			return
+		}
+
+		if err != nil {
 			http.Error(rw, err.Error(), http.StatusNotFound)
 			return
 		}
 
 		// return updated product
-		if err := product.ToJSON(rw); err != nil {
+		if err := respond(rw, r, product); err != nil {
 			http.Error(rw,
 				fmt.Sprintf("product with ID: '%d' was updated, but failed to retrieve it",
 					product.ID),
@@ -246,7 +353,7 @@ func (h *Product) update(rw http.ResponseWriter, r *http.Request) {
 // delete handle DELETE request on a single product. It removes the
 // product from the data store and retrieve this deleted product.
 func (h *Product) delete(rw http.ResponseWriter, r *http.Request) {
-	h.logger.Println("[INFO] received a DELETE product request")
+	h.logger.For(r.Context()).Debug("received a DELETE product request")
 
 	deleteProductRe := regexp.MustCompile(`^/products/(\d+)$`)
 
@@ -258,14 +365,20 @@ func (h *Product) delete(rw http.ResponseWriter, r *http.Request) {
 	}
 
 	// delete product from data store
-	product, err := data.RemoveProduct(productID)
+	storeSpan := traceStore(r, "RemoveProduct")
+	product, err := data.RemoveProduct(r.Context(), productID)
+	storeSpan.End()
+	if errors.Is(err, data.ErrReferenced) {
+		http.Error(rw, err.Error(), http.StatusConflict)
+		return
+	}
 	if err != nil {
 		http.Error(rw, "product not found", http.StatusNotFound)
 		return
 	}
 
 	// return deleted product
-	if err := product.ToJSON(rw); err != nil {
+	if err := respond(rw, r, product); err != nil {
 		http.Error(rw,
 			fmt.Sprintf("product with ID: '%d' was deleted, but failed to retrieve it",
 				product.ID),
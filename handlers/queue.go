@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+
+	"github.com/imariom/products-api/data"
+	"github.com/imariom/products-api/logging"
+	"github.com/imariom/products-api/queue"
+)
+
+// Queue is the HTTP handler of the admin endpoints managing the jobs of
+// the background job queue (/admin/queue), including its dead letters.
+type Queue struct {
+	logger *logging.Logger
+	queue  *queue.Queue
+}
+
+// NewQueue is a constructor for Queue handler.
+func NewQueue(l *logging.Logger, q *queue.Queue) *Queue {
+	return &Queue{l, q}
+}
+
+var (
+	queueJobRe   = regexp.MustCompile(`^/admin/queue/(\d+)$`)
+	queueRetryRe = regexp.MustCompile(`^/admin/queue/(\d+):retry$`)
+)
+
+// ServeHTTP implements http.Handler.
+func (h *Queue) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	r, ok := negotiate(rw, r)
+	if !ok {
+		return
+	}
+
+	path := r.URL.Path
+	switch {
+	case path == "/admin/queue" && r.Method == http.MethodGet:
+		h.list(rw, r)
+
+	case path == "/admin/queue" && r.Method == http.MethodPost:
+		h.enqueue(rw, r)
+
+	case queueJobRe.MatchString(path) && r.Method == http.MethodGet:
+		h.get(rw, r)
+
+	case queueJobRe.MatchString(path) && r.Method == http.MethodDelete:
+		h.delete(rw, r)
+
+	case queueRetryRe.MatchString(path) && r.Method == http.MethodPost:
+		h.retry(rw, r)
+
+	case path == "/admin/queue" || queueJobRe.MatchString(path) || queueRetryRe.MatchString(path):
+		http.Error(rw, "HTTP verb not implemented", http.StatusNotImplemented)
+
+	default:
+		http.NotFound(rw, r)
+	}
+}
+
+// list returns the jobs, the most recent first, filtered by the kind
+// and status query parameters; status=dead lists the dead letters.
+func (h *Queue) list(rw http.ResponseWriter, r *http.Request) {
+	h.logger.For(r.Context()).Debug("received a GET jobs request")
+
+	limit, offset, _ := getQueryParams(r.URL.RawQuery)
+	query := r.URL.Query()
+
+	jobs := h.queue.Jobs(queue.JobFilter{
+		Kind:   query.Get("kind"),
+		Status: query.Get("status"),
+		Limit:  limit,
+		Offset: offset,
+	})
+
+	if err := respond(rw, r, jobs); err != nil {
+		http.Error(rw, "failed to retrieve jobs", http.StatusInternalServerError)
+	}
+}
+
+// enqueue queues a job of a registered kind. A job with the unique key
+// of a queued one is not queued, the queued one is returned instead.
+func (h *Queue) enqueue(rw http.ResponseWriter, r *http.Request) {
+	h.logger.For(r.Context()).Debug("received a POST job request")
+
+	j := &queue.Job{}
+	if err := decodeBody(r, j); err != nil {
+		http.Error(rw, "invalid job payload", http.StatusBadRequest)
+		return
+	}
+
+	if err := data.Validate(j); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err := h.queue.Enqueue(j)
+	switch {
+	case errors.Is(err, queue.ErrDuplicate):
+		rw.WriteHeader(http.StatusOK)
+	case err != nil:
+		h.error(rw, err)
+		return
+	default:
+		rw.WriteHeader(http.StatusAccepted)
+	}
+
+	if err := respond(rw, r, j); err != nil {
+		h.logger.For(r.Context()).Error("failed to encode job", "error", err)
+	}
+}
+
+// get returns a job with its attempts.
+func (h *Queue) get(rw http.ResponseWriter, r *http.Request) {
+	h.logger.For(r.Context()).Debug("received a GET job request")
+
+	id, _ := getItemID(queueJobRe, r.URL.Path)
+	j, err := h.queue.Job(id)
+	if err != nil {
+		h.error(rw, err)
+		return
+	}
+
+	if err := respond(rw, r, j); err != nil {
+		http.Error(rw, "failed to retrieve job", http.StatusInternalServerError)
+	}
+}
+
+// delete cancels a pending job, or drops a finished one.
+func (h *Queue) delete(rw http.ResponseWriter, r *http.Request) {
+	h.logger.For(r.Context()).Debug("received a DELETE job request")
+
+	id, _ := getItemID(queueJobRe, r.URL.Path)
+	j, err := h.queue.Delete(id)
+	if err != nil {
+		h.error(rw, err)
+		return
+	}
+
+	if err := respond(rw, r, j); err != nil {
+		http.Error(rw, "failed to retrieve job", http.StatusInternalServerError)
+	}
+}
+
+// retry queues a new job with the kind and the payload of a dead job.
+func (h *Queue) retry(rw http.ResponseWriter, r *http.Request) {
+	h.logger.For(r.Context()).Debug("received a job retry request")
+
+	id, _ := getItemID(queueRetryRe, r.URL.Path)
+	j, err := h.queue.Retry(id)
+	if err != nil {
+		h.error(rw, err)
+		return
+	}
+
+	rw.WriteHeader(http.StatusAccepted)
+	if err := respond(rw, r, j); err != nil {
+		h.logger.For(r.Context()).Error("failed to encode job", "error", err)
+	}
+}
+
+// error answers with the status matching err.
+func (h *Queue) error(rw http.ResponseWriter, err error) {
+	var ve *queue.ValidationError
+
+	switch {
+	case errors.Is(err, queue.ErrNotFound):
+		http.Error(rw, err.Error(), http.StatusNotFound)
+	case errors.As(err, &ve):
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(rw, err.Error(), http.StatusConflict)
+	}
+}
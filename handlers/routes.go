@@ -0,0 +1,313 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/imariom/products-api/audit"
+	"github.com/imariom/products-api/codec"
+	"github.com/imariom/products-api/data"
+	"github.com/imariom/products-api/events"
+	"github.com/imariom/products-api/graphql"
+	"github.com/imariom/products-api/middleware"
+	"github.com/imariom/products-api/openapi"
+	"github.com/imariom/products-api/queue"
+	"github.com/imariom/products-api/scheduler"
+	"github.com/imariom/products-api/webhooks"
+)
+
+// APIInfo describes the API in its OpenAPI document.
+var APIInfo = openapi.Info{
+	Title:       "Store API",
+	Description: "Simple ecommerce REST API for products, carts and users.",
+	Version:     "1.0.0",
+}
+
+// parameters shared by the collection endpoints
+var (
+	limitParam = openapi.Param{
+		Name:        "limit",
+		In:          "query",
+		Description: "maximum number of results",
+	}
+
+	offsetParam = openapi.Param{
+		Name:        "offset",
+		In:          "query",
+		Description: "number of results to skip",
+	}
+
+	formatParam = openapi.Param{
+		Name:        codec.FormatParam,
+		In:          "query",
+		Type:        "string",
+		Enum:        codec.Default.Names(),
+		Description: "format of the response, overrides the Accept header",
+	}
+
+	idempotencyKeyParam = openapi.Param{
+		Name:        middleware.IdempotencyKeyHeader,
+		In:          "header",
+		Type:        "string",
+		Description: "makes retries safe: a key reused by the same client on the same endpoint returns the original response, or 422 for another request",
+	}
+
+	cartTokenParam = openapi.Param{
+		Name:        CartTokenHeader,
+		In:          "header",
+		Type:        "string",
+		Description: "token of the guest cart, instead of the " + CartTokenCookie + " cookie",
+	}
+
+	sortParam = openapi.Param{
+		Name:        "sort",
+		In:          "query",
+		Type:        "string",
+		Enum:        []string{"asc", "desc"},
+		Description: "sort order of the results",
+	}
+
+	asOfParam = openapi.Param{
+		Name:        "as_of",
+		In:          "query",
+		Type:        "string",
+		Description: "RFC 3339 time to read the state at, from the stored revisions",
+	}
+
+	// auditParams select the entries of the audit log
+	auditParams = []openapi.Param{
+		{Name: "resource", In: "query", Type: "string", Enum: []string{"products", "carts", "users"}},
+		{Name: "id", In: "query", Description: "only the changes of this record"},
+		{Name: "actor", In: "query", Type: "string", Description: "only the changes made by this actor"},
+		{Name: "operation", In: "query", Type: "string", Enum: []string{
+			data.OpCreate, data.OpUpdate, data.OpPatch, data.OpDelete, data.OpRestore, data.OpPurge, data.OpMerge, data.OpExpire,
+		}},
+		{Name: "since", In: "query", Type: "string", Description: "only the changes made at or after this RFC 3339 time"},
+		{Name: "until", In: "query", Type: "string", Description: "only the changes made before this RFC 3339 time"},
+	}
+)
+
+// formats are the media types of the bodies of the resource endpoints.
+var formats = codec.Default.MediaTypes()
+
+// the media types products can be imported from and exported to
+var (
+	importFormats = []string{codec.CSV.MediaTypes[0], codec.NDJSON.MediaTypes[0]}
+	exportFormats = []string{codec.JSON.MediaTypes[0], codec.CSV.MediaTypes[0], codec.NDJSON.MediaTypes[0]}
+)
+
+func dateParam(name string) openapi.Param {
+	return openapi.Param{Name: name, In: "path", Type: "string", Format: "date"}
+}
+
+// Routes is the table of the endpoints served by the handlers of this
+// package. It is the source of the OpenAPI document of the API, so it
+// must be kept in sync with the handlers (see cmd/openapi).
+var Routes = []openapi.Route{
+	// products
+	{Method: http.MethodGet, Path: "/products", Tag: "products", MediaTypes: formats, Summary: "List products",
+		Params:   []openapi.Param{limitParam, offsetParam, sortParam, asOfParam, formatParam},
+		Response: data.Products{}, Errors: []int{http.StatusBadRequest}},
+	{Method: http.MethodPost, Path: "/products", Tag: "products", MediaTypes: formats, Summary: "Create a product",
+		Params:  []openapi.Param{idempotencyKeyParam},
+		Request: data.Product{}, Response: data.Product{}, Errors: []int{http.StatusBadRequest, http.StatusConflict, http.StatusUnprocessableEntity}},
+	{Method: http.MethodGet, Path: "/products/{id}", Tag: "products", MediaTypes: formats, Summary: "Get a product",
+		Params:   []openapi.Param{asOfParam},
+		Response: data.Product{}, Errors: []int{http.StatusBadRequest, http.StatusNotFound}},
+	{Method: http.MethodGet, Path: "/products/{id}/history", Tag: "products", MediaTypes: formats, Summary: "List the revisions of a product, oldest first",
+		Response: []data.ProductRevision{}, Errors: []int{http.StatusBadRequest, http.StatusNotFound}},
+	{Method: http.MethodPut, Path: "/products/{id}", Tag: "products", MediaTypes: formats, Summary: "Replace a product",
+		Request: data.Product{}, Response: data.Product{}, Errors: []int{http.StatusBadRequest, http.StatusNotFound, http.StatusConflict}},
+	{Method: http.MethodPatch, Path: "/products/{id}", Tag: "products", MediaTypes: formats, Summary: "Update product attributes",
+		Request: data.Product{}, Response: data.Product{}, Errors: []int{http.StatusNotFound, http.StatusConflict}},
+	{Method: http.MethodDelete, Path: "/products/{id}", Tag: "products", MediaTypes: formats, Summary: "Move a product to the trash, applying the on-delete policy to the carts referencing it",
+		Response: data.Product{}, Errors: []int{http.StatusNotFound, http.StatusConflict}},
+	{Method: http.MethodPost, Path: "/products/{id}:restore", Tag: "products", MediaTypes: formats, Summary: "Restore a product from the trash",
+		Response: data.Product{}, Errors: []int{http.StatusNotFound, http.StatusConflict}},
+	{Method: http.MethodGet, Path: "/products/categories", Tag: "products", MediaTypes: formats, Summary: "Count products by category",
+		Response: data.Categories{}},
+	{Method: http.MethodGet, Path: "/products/categories/{category}", Tag: "products", MediaTypes: formats, Summary: "List products in a category",
+		Params:   []openapi.Param{{Name: "category", In: "path", Type: "string"}},
+		Response: data.Products{}, Errors: []int{http.StatusNotFound}},
+	{Method: http.MethodPost, Path: "/products:import", Tag: "products", Summary: "Import products, upserting them by SKU",
+		Params:  []openapi.Param{{Name: "dry_run", In: "query", Type: "boolean", Description: "only validate the products"}},
+		Request: data.Products{}, Response: ImportJob{}, MediaTypes: importFormats,
+		Errors: []int{http.StatusRequestEntityTooLarge, http.StatusUnsupportedMediaType}},
+	{Method: http.MethodGet, Path: "/products:import", Tag: "products", Summary: "List the product imports",
+		Response: []ImportJob{}},
+	{Method: http.MethodGet, Path: "/products:import/{id}", Tag: "products", Summary: "Get the progress and report of an import",
+		Response: ImportJob{}, Errors: []int{http.StatusNotFound}},
+	{Method: http.MethodGet, Path: "/products:export", Tag: "products", Summary: "Export all products",
+		Params:   []openapi.Param{formatParam},
+		Response: data.Products{}, MediaTypes: exportFormats, Errors: []int{http.StatusNotAcceptable}},
+
+	// carts
+	{Method: http.MethodGet, Path: "/carts", Tag: "carts", MediaTypes: formats, Summary: "List carts",
+		Params:   []openapi.Param{limitParam, offsetParam, sortParam, asOfParam, formatParam},
+		Response: data.Carts{}, Errors: []int{http.StatusBadRequest}},
+	{Method: http.MethodPost, Path: "/carts", Tag: "carts", MediaTypes: formats, Summary: "Create a cart",
+		Params:  []openapi.Param{idempotencyKeyParam},
+		Request: data.Cart{}, Response: data.Cart{}, Errors: []int{http.StatusBadRequest, http.StatusUnprocessableEntity}},
+	{Method: http.MethodGet, Path: "/carts/{id}", Tag: "carts", MediaTypes: formats, Summary: "Get a cart",
+		Params:   []openapi.Param{asOfParam},
+		Response: data.Cart{}, Errors: []int{http.StatusBadRequest, http.StatusNotFound}},
+	{Method: http.MethodPut, Path: "/carts/{id}", Tag: "carts", MediaTypes: formats, Summary: "Replace a cart",
+		Request: data.Cart{}, Response: data.Cart{}, Errors: []int{http.StatusBadRequest, http.StatusNotFound}},
+	{Method: http.MethodPatch, Path: "/carts/{id}", Tag: "carts", MediaTypes: formats, Summary: "Update cart attributes",
+		Request: data.Cart{}, Response: data.Cart{}, Errors: []int{http.StatusBadRequest, http.StatusNotFound}},
+	{Method: http.MethodDelete, Path: "/carts/{id}", Tag: "carts", MediaTypes: formats, Summary: "Move a cart to the trash",
+		Response: data.Cart{}, Errors: []int{http.StatusNotFound}},
+	{Method: http.MethodPost, Path: "/carts/{id}:restore", Tag: "carts", MediaTypes: formats, Summary: "Restore a cart from the trash, unless its user was deleted",
+		Response: data.Cart{}, Errors: []int{http.StatusNotFound, http.StatusConflict}},
+	{Method: http.MethodPost, Path: "/carts/guest", Tag: "carts", MediaTypes: formats, Summary: "Create a guest cart, returning its token in the " + CartTokenCookie + " cookie and the " + CartTokenHeader + " header",
+		Request: data.Cart{}, Response: data.Cart{}, Errors: []int{http.StatusBadRequest}},
+	{Method: http.MethodGet, Path: "/carts/guest", Tag: "carts", MediaTypes: formats, Summary: "Get the guest cart of the token",
+		Params:   []openapi.Param{cartTokenParam},
+		Response: data.Cart{}, Errors: []int{http.StatusUnauthorized, http.StatusNotFound}},
+	{Method: http.MethodPut, Path: "/carts/guest", Tag: "carts", MediaTypes: formats, Summary: "Replace the items of the guest cart of the token",
+		Params:  []openapi.Param{cartTokenParam},
+		Request: data.Cart{}, Response: data.Cart{}, Errors: []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusNotFound}},
+	{Method: http.MethodPost, Path: "/carts/guest:merge", Tag: "carts", MediaTypes: formats, Summary: "Merge the guest cart of the token into the cart of the user authenticated with HTTP Basic credentials",
+		Params:   []openapi.Param{cartTokenParam},
+		Response: data.Cart{}, Errors: []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusNotFound}},
+	{Method: http.MethodPost, Path: "/carts/{id}/items", Tag: "carts", MediaTypes: formats, Summary: "Add a quantity of a product to a cart, merging it with the item of the product",
+		Request: data.Item{}, Response: data.Cart{}, Errors: []int{http.StatusBadRequest, http.StatusNotFound}},
+	{Method: http.MethodPatch, Path: "/carts/{id}/items/{productId}", Tag: "carts", MediaTypes: formats, Summary: "Increment, decrement or set the quantity of an item, removing it at zero",
+		Request: data.ItemAdjustment{}, Response: data.Cart{}, Errors: []int{http.StatusBadRequest, http.StatusNotFound}},
+	{Method: http.MethodDelete, Path: "/carts/{id}/items/{productId}", Tag: "carts", MediaTypes: formats, Summary: "Remove an item from a cart",
+		Response: data.Cart{}, Errors: []int{http.StatusNotFound}},
+	{Method: http.MethodGet, Path: "/carts/user/{userId}", Tag: "carts", MediaTypes: formats, Summary: "List the carts of a user",
+		Response: data.Carts{}, Errors: []int{http.StatusNotFound}},
+	{Method: http.MethodGet, Path: "/carts/startdate={startdate}&enddate={enddate}", Tag: "carts", MediaTypes: formats, Summary: "List carts in a date range",
+		Params:   []openapi.Param{dateParam("startdate"), dateParam("enddate")},
+		Response: data.Carts{}, Errors: []int{http.StatusBadRequest}},
+	{Method: http.MethodGet, Path: "/carts/startdate={startdate}", Tag: "carts", MediaTypes: formats, Summary: "List carts since a date",
+		Params:   []openapi.Param{dateParam("startdate")},
+		Response: data.Carts{}, Errors: []int{http.StatusBadRequest}},
+	{Method: http.MethodGet, Path: "/carts/enddate={enddate}", Tag: "carts", MediaTypes: formats, Summary: "List carts until a date",
+		Params:   []openapi.Param{dateParam("enddate")},
+		Response: data.Carts{}, Errors: []int{http.StatusBadRequest}},
+
+	// users
+	{Method: http.MethodGet, Path: "/users", Tag: "users", MediaTypes: formats, Summary: "List users",
+		Response: data.Users{}},
+	{Method: http.MethodPost, Path: "/users", Tag: "users", MediaTypes: formats, Summary: "Create a user",
+		Params:  []openapi.Param{idempotencyKeyParam},
+		Request: data.User{}, Response: data.User{}, Errors: []int{http.StatusBadRequest, http.StatusUnprocessableEntity}},
+	{Method: http.MethodGet, Path: "/users/{id}", Tag: "users", MediaTypes: formats, Summary: "Get a user",
+		Response: data.User{}, Errors: []int{http.StatusNotFound}},
+	{Method: http.MethodPut, Path: "/users/{id}", Tag: "users", MediaTypes: formats, Summary: "Replace a user",
+		Request: data.User{}, Response: data.User{}, Errors: []int{http.StatusBadRequest, http.StatusNotFound}},
+	{Method: http.MethodPatch, Path: "/users/{id}", Tag: "users", MediaTypes: formats, Summary: "Update user attributes",
+		Request: data.User{}, Response: data.User{}, Errors: []int{http.StatusNotFound}},
+	{Method: http.MethodDelete, Path: "/users/{id}", Tag: "users", MediaTypes: formats, Summary: "Move a user to the trash, applying the on-delete policy to their carts",
+		Response: data.User{}, Errors: []int{http.StatusNotFound, http.StatusConflict}},
+	{Method: http.MethodPost, Path: "/users/{id}:restore", Tag: "users", MediaTypes: formats, Summary: "Restore a user from the trash, without their carts",
+		Response: data.User{}, Errors: []int{http.StatusNotFound, http.StatusConflict}},
+
+	// trash
+	{Method: http.MethodGet, Path: "/trash", Tag: "trash", MediaTypes: formats, Summary: "List the deleted records, the most recently deleted first",
+		Params: []openapi.Param{
+			{Name: "resource", In: "query", Type: "string", Enum: []string{"products", "carts", "users"}},
+			limitParam, offsetParam,
+		},
+		Response: []data.Trashed{}, Errors: []int{http.StatusBadRequest}},
+
+	// batch
+	{Method: http.MethodPost, Path: "/batch", Tag: "batch", Summary: "Serve several operations in one request",
+		Params:  []openapi.Param{idempotencyKeyParam},
+		Request: BatchRequest{}, Response: BatchResponse{},
+		Errors: []int{http.StatusBadRequest, http.StatusRequestEntityTooLarge, http.StatusUnprocessableEntity}},
+
+	// graphql
+	{Method: http.MethodGet, Path: "/graphql", Tag: "graphql", Summary: "Run a GraphQL query, or get the schema without one",
+		Params: []openapi.Param{
+			{Name: "query", In: "query", Type: "string", Description: "GraphQL query document"},
+			{Name: "operationName", In: "query", Type: "string", Description: "operation of the document to run"},
+			{Name: "variables", In: "query", Type: "string", Description: "JSON object of variables"},
+		},
+		Response: graphql.Response{}, Errors: []int{http.StatusBadRequest}},
+	{Method: http.MethodPost, Path: "/graphql", Tag: "graphql", Summary: "Run a GraphQL query or mutation",
+		Request: graphql.Request{}, Response: graphql.Response{},
+		Errors: []int{http.StatusBadRequest, http.StatusUnsupportedMediaType, http.StatusRequestEntityTooLarge}},
+
+	// events
+	{Method: http.MethodGet, Path: "/events", Tag: "events", Summary: "Stream the changes of the store as server-sent events, or over a WebSocket",
+		Params: []openapi.Param{
+			{Name: "topic", In: "query", Type: "string", Description: "comma separated topics, e.g. products or carts:user:42 (all by default)"},
+			{Name: "last_event_id", In: "query", Description: "resume after this event, like the Last-Event-ID header"},
+		},
+		Response: events.Event{}, MediaTypes: []string{"text/event-stream"}, Errors: []int{http.StatusBadRequest}},
+
+	// webhooks
+	{Method: http.MethodGet, Path: "/admin/webhooks", Tag: "webhooks", Summary: "List the webhook subscriptions",
+		Response: []webhooks.Subscription{}, Errors: []int{http.StatusUnauthorized}},
+	{Method: http.MethodPost, Path: "/admin/webhooks", Tag: "webhooks", Summary: "Subscribe to events, the secret is only returned by this request",
+		Request: webhooks.Subscription{}, Response: webhooks.Subscription{},
+		Errors: []int{http.StatusBadRequest, http.StatusUnauthorized}},
+	{Method: http.MethodGet, Path: "/admin/webhooks/{id}", Tag: "webhooks", Summary: "Get a webhook subscription",
+		Response: webhooks.Subscription{}, Errors: []int{http.StatusUnauthorized, http.StatusNotFound}},
+	{Method: http.MethodPut, Path: "/admin/webhooks/{id}", Tag: "webhooks", Summary: "Replace a webhook subscription, keeping its secret unless given",
+		Request: webhooks.Subscription{}, Response: webhooks.Subscription{},
+		Errors: []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusNotFound}},
+	{Method: http.MethodDelete, Path: "/admin/webhooks/{id}", Tag: "webhooks", Summary: "Delete a webhook subscription",
+		Response: webhooks.Subscription{}, Errors: []int{http.StatusUnauthorized, http.StatusNotFound}},
+	{Method: http.MethodGet, Path: "/admin/webhooks/deliveries", Tag: "webhooks", Summary: "List the webhook deliveries, the most recent first",
+		Params: []openapi.Param{
+			{Name: "subscription_id", In: "query", Description: "only the deliveries of this subscription"},
+			{Name: "event_type", In: "query", Type: "string", Description: "only the deliveries of this event type"},
+			{Name: "status", In: "query", Type: "string", Enum: []string{webhooks.StatusPending, webhooks.StatusSucceeded, webhooks.StatusFailed}},
+			limitParam, offsetParam,
+		},
+		Response: []webhooks.Delivery{}, Errors: []int{http.StatusBadRequest, http.StatusUnauthorized}},
+	{Method: http.MethodGet, Path: "/admin/webhooks/deliveries/{id}", Tag: "webhooks", Summary: "Get a webhook delivery and its attempts",
+		Response: webhooks.Delivery{}, Errors: []int{http.StatusUnauthorized, http.StatusNotFound}},
+	{Method: http.MethodPost, Path: "/admin/webhooks/deliveries/{id}:redeliver", Tag: "webhooks", Summary: "Deliver the event of a delivery again",
+		Response: webhooks.Delivery{}, Errors: []int{http.StatusUnauthorized, http.StatusNotFound, http.StatusConflict}},
+
+	// audit
+	{Method: http.MethodGet, Path: "/audit", Tag: "audit", MediaTypes: formats, Summary: "List the changes of the audit log, in the order they were made",
+		Params:   append(auditParams, limitParam, offsetParam),
+		Response: []audit.Entry{}, Errors: []int{http.StatusBadRequest, http.StatusUnauthorized}},
+	{Method: http.MethodGet, Path: "/audit:export", Tag: "audit", Summary: "Export the changes of the audit log as JSON lines",
+		Params: auditParams, MediaTypes: []string{"application/x-ndjson"},
+		Errors: []int{http.StatusBadRequest, http.StatusUnauthorized}},
+	{Method: http.MethodGet, Path: "/audit:verify", Tag: "audit", Summary: "Verify the hash chain of the audit log",
+		Response: audit.Verification{}, Errors: []int{http.StatusUnauthorized}},
+
+	// integrity
+	{Method: http.MethodGet, Path: "/admin/integrity", Tag: "integrity", Summary: "Report the carts referencing users or products that do not exist",
+		Response: data.IntegrityReport{}, Errors: []int{http.StatusUnauthorized}},
+
+	// jobs
+	{Method: http.MethodGet, Path: "/admin/jobs", Tag: "jobs", Summary: "List the scheduled jobs with their status and next run",
+		Response: []scheduler.JobStatus{}, Errors: []int{http.StatusUnauthorized}},
+	{Method: http.MethodGet, Path: "/admin/jobs/{name}", Tag: "jobs", Summary: "Get the status and next run of a scheduled job",
+		Params:   []openapi.Param{{Name: "name", In: "path", Type: "string"}},
+		Response: scheduler.JobStatus{}, Errors: []int{http.StatusUnauthorized, http.StatusNotFound}},
+
+	// queue
+	{Method: http.MethodGet, Path: "/admin/queue", Tag: "queue", Summary: "List the background jobs, the most recent first",
+		Params: []openapi.Param{
+			limitParam, offsetParam,
+			{Name: "kind", In: "query", Type: "string"},
+			{Name: "status", In: "query", Type: "string", Enum: []string{queue.StatusPending, queue.StatusRunning, queue.StatusSucceeded, queue.StatusDead}},
+		},
+		Response: []queue.Job{}, Errors: []int{http.StatusUnauthorized}},
+	{Method: http.MethodPost, Path: "/admin/queue", Tag: "queue", Summary: "Queue a background job, or return the queued job with its unique key",
+		Request: queue.Job{}, Response: queue.Job{},
+		Errors: []int{http.StatusBadRequest, http.StatusUnauthorized}},
+	{Method: http.MethodGet, Path: "/admin/queue/{id}", Tag: "queue", Summary: "Get a background job and its attempts",
+		Response: queue.Job{}, Errors: []int{http.StatusUnauthorized, http.StatusNotFound}},
+	{Method: http.MethodDelete, Path: "/admin/queue/{id}", Tag: "queue", Summary: "Cancel a pending background job or delete a finished one",
+		Response: queue.Job{}, Errors: []int{http.StatusUnauthorized, http.StatusNotFound, http.StatusConflict}},
+	{Method: http.MethodPost, Path: "/admin/queue/{id}:retry", Tag: "queue", Summary: "Queue a dead background job again",
+		Response: queue.Job{}, Errors: []int{http.StatusUnauthorized, http.StatusNotFound, http.StatusConflict}},
+
+	// operations
+	{Method: http.MethodGet, Path: "/healthz", Tag: "operations", Summary: "Liveness probe"},
+	{Method: http.MethodGet, Path: "/readyz", Tag: "operations", Summary: "Readiness probe",
+		Errors: []int{http.StatusServiceUnavailable}},
+	{Method: http.MethodGet, Path: "/status", Tag: "operations", Summary: "Detailed status"},
+	{Method: http.MethodGet, Path: "/metrics", Tag: "operations", Summary: "Prometheus metrics"},
+	{Method: http.MethodGet, Path: "/openapi.json", Tag: "operations", Summary: "This OpenAPI document"},
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+
+	"github.com/imariom/products-api/data"
+	"github.com/imariom/products-api/logging"
+)
+
+// the paths restoring the records from the trash
+var (
+	restoreProductRe = regexp.MustCompile(`^/products/(\d+):restore$`)
+	restoreCartRe    = regexp.MustCompile(`^/carts/(\d+):restore$`)
+	restoreUserRe    = regexp.MustCompile(`^/users/(\d+):restore$`)
+)
+
+// Trash is the HTTP handler listing the deleted records (/trash). They
+// are restored by POST /{resource}/{id}:restore, served by the handler
+// of each resource.
+type Trash struct {
+	logger *logging.Logger
+}
+
+// NewTrash is a constructor for Trash handler.
+func NewTrash(l *logging.Logger) *Trash {
+	return &Trash{l}
+}
+
+// ServeHTTP implements http.Handler.
+func (h *Trash) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	r, ok := negotiate(rw, r)
+	if !ok {
+		return
+	}
+
+	if r.URL.Path != "/trash" {
+		http.NotFound(rw, r)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(rw, "HTTP verb not implemented", http.StatusNotImplemented)
+		return
+	}
+
+	h.logger.For(r.Context()).Debug("received a GET trash request")
+
+	resource := r.URL.Query().Get("resource")
+	switch resource {
+	case "", "products", "carts", "users":
+	default:
+		http.Error(rw, "invalid resource, expected products, carts or users", http.StatusBadRequest)
+		return
+	}
+
+	span := traceStore(r, "GetTrash")
+	records := data.GetTrash(resource)
+	span.End()
+
+	// skip the offset and limit the result
+	limit, offset, _ := getQueryParams(r.URL.RawQuery)
+	if offset < 0 || offset > len(records) {
+		offset = len(records)
+	}
+	records = records[offset:]
+	if limit > 0 && limit < len(records) {
+		records = records[:limit]
+	}
+
+	if err := respond(rw, r, records); err != nil {
+		http.Error(rw, "failed to retrieve the trash", http.StatusInternalServerError)
+	}
+}
+
+// restore serves POST /{resource}/{id}:restore with fn, the store
+// function restoring the records of the resource matched by re.
+func restore(rw http.ResponseWriter, r *http.Request, re *regexp.Regexp, operation string, fn func(id uint64) (interface{}, error)) {
+	id, err := getItemID(re, r.URL.Path)
+	if err != nil {
+		http.Error(rw, "invalid ID", http.StatusNotFound)
+		return
+	}
+
+	storeSpan := traceStore(r, operation)
+	record, err := fn(id)
+	storeSpan.End()
+
+	var ve *data.ValidationError
+	switch {
+	case errors.Is(err, data.ErrNotInTrash):
+		http.Error(rw, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, data.ErrDuplicateSKU), errors.Is(err, data.ErrIDTaken), errors.As(err, &ve):
+		http.Error(rw, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := respond(rw, r, record); err != nil {
+		http.Error(rw, "record was restored, but failed to retrieve it", http.StatusInternalServerError)
+	}
+}
@@ -1,12 +1,13 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
-	"log"
 	"net/http"
 	"regexp"
 
 	"github.com/imariom/products-api/data"
+	"github.com/imariom/products-api/logging"
 )
 
 // User represents the HTTP handler for the HTTP request multiplexer
@@ -14,12 +15,12 @@ import (
 type User struct {
 	// logger represents the log object used to log all necessary
 	// information of the API.
-	logger *log.Logger
+	logger *logging.Logger
 }
 
 // NewUser allocates and construct a new User handler provided
 // a logger object.
-func NewUser(l *log.Logger) *User {
+func NewUser(l *logging.Logger) *User {
 	return &User{l}
 }
 
@@ -33,7 +34,7 @@ func parseUser(regex *regexp.Regexp, r *http.Request) (*data.User, error) {
 
 	// try to decode user from request body
 	user := &data.User{}
-	if err := user.FromJSON(r.Body); err != nil {
+	if err := decodeBody(r, user); err != nil {
 		return nil, fmt.Errorf(data.UserPayloadError)
 	}
 
@@ -50,8 +51,14 @@ func parseUser(regex *regexp.Regexp, r *http.Request) (*data.User, error) {
 // ServeHTTP is the http.Handler interface implementation method for
 // User handler.
 func (h *User) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
-	// set API to be JSON based (send and receive JSON data)
-	rw.Header().Set("Content-Type", "application/json")
+	// negotiate the format of the request and response bodies
+	r, ok := negotiate(rw, r)
+	if !ok {
+		return
+	}
+
+	// transactional batches hold off writes while they run
+	defer lockWrites(r)()
 
 	// route each incoming request to specific handler
 	switch r.Method {
@@ -60,6 +67,10 @@ func (h *User) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 		return
 
 	case http.MethodPost:
+		if restoreUserRe.MatchString(r.URL.Path) {
+			h.restore(rw, r)
+			return
+		}
 		h.create(rw, r)
 		return
 
@@ -78,19 +89,30 @@ func (h *User) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// restore moves a deleted user back from the trash.
+func (h *User) restore(rw http.ResponseWriter, r *http.Request) {
+	h.logger.For(r.Context()).Debug("received a restore user request")
+
+	restore(rw, r, restoreUserRe, "RestoreUser", func(id uint64) (interface{}, error) {
+		return data.RestoreUser(r.Context(), id)
+	})
+}
+
 // get get a list or single user from data store and return it
 // back to the client.
 func (h *User) get(rw http.ResponseWriter, r *http.Request) {
-	h.logger.Println("received a GET user request")
+	h.logger.For(r.Context()).Debug("received a GET user request")
 
 	// serve list all users request
 	listUsersRe := regexp.MustCompile(`^/users[/]?$`)
 
 	if listUsersRe.MatchString(r.URL.Path) {
+		storeSpan := traceStore(r, "GetAllUsers")
 		users := data.GetAllUsers()
+		storeSpan.End()
 
-		if err := users.ToJSON(rw); err != nil {
-			h.logger.Println(data.UserConvertionError)
+		if err := respond(rw, r, users); err != nil {
+			h.logger.For(r.Context()).Error(data.UserConvertionError, "error", err)
 			http.Error(rw, data.UserConvertionError, http.StatusInternalServerError)
 		}
 
@@ -106,13 +128,15 @@ func (h *User) get(rw http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		storeSpan := traceStore(r, "GetUser")
 		user, err := data.GetUser(uint64(userID))
+		storeSpan.End()
 		if err != nil {
 			http.Error(rw, data.UserNotFoundError, http.StatusNotFound)
 			return
 		}
 
-		if err := user.ToJSON(rw); err != nil {
+		if err := respond(rw, r, user); err != nil {
 			http.Error(rw, data.UserConvertionError, http.StatusInternalServerError)
 		}
 
@@ -125,20 +149,27 @@ func (h *User) get(rw http.ResponseWriter, r *http.Request) {
 // create create and store new user on the data store
 // retrieving this user back to the client.
 func (h *User) create(rw http.ResponseWriter, r *http.Request) {
-	h.logger.Println("received a POST user request")
+	h.logger.For(r.Context()).Debug("received a POST user request")
 
 	// parse user from request object
 	user := &data.User{}
-	if err := user.FromJSON(r.Body); err != nil {
+	if err := decodeBody(r, user); err != nil {
 		http.Error(rw, data.UserPayloadError, http.StatusBadRequest)
 		return
 	}
 
+	if err := data.Validate(user); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	// add user to data store
-	data.AddNewUser(user)
+	storeSpan := traceStore(r, "AddNewUser")
+	data.AddNewUser(r.Context(), user)
+	storeSpan.End()
 
 	// try to return created user
-	if err := user.ToJSON(rw); err != nil {
+	if err := respond(rw, r, user); err != nil {
 		http.Error(rw,
 			fmt.Sprintf("user created with ID: '%d', but failed to retrieve it",
 				user.ID),
@@ -150,9 +181,9 @@ func (h *User) create(rw http.ResponseWriter, r *http.Request) {
 // and return the updated user back to the client.
 func (h *User) update(rw http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodPut {
-		h.logger.Println("received a PUT user request")
+		h.logger.For(r.Context()).Debug("received a PUT user request")
 	} else if r.Method == http.MethodPatch {
-		h.logger.Println("received a PATCH user request")
+		h.logger.For(r.Context()).Debug("received a PATCH user request")
 	}
 
 	// try to parse user from request object
@@ -166,21 +197,34 @@ func (h *User) update(rw http.ResponseWriter, r *http.Request) {
 
 	// match request method (PUT or PATCH)
 	if r.Method == http.MethodPut {
+		if err := data.Validate(user); err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+
 		// update whole user information
-		if err := data.UpdateUser(user); err != nil {
+		storeSpan := traceStore(r, "UpdateUser")
+		err = data.UpdateUser(r.Context(), user)
+		storeSpan.End()
+
+		if err != nil {
 			http.Error(rw, err.Error(), http.StatusNotFound)
 			return
 		}
 	} else if r.Method == http.MethodPatch {
 		// update user attributes
-		if err := data.SetUser(user); err != nil {
+		storeSpan := traceStore(r, "SetUser")
+		err = data.SetUser(r.Context(), user)
+		storeSpan.End()
+
+		if err != nil {
 			http.Error(rw, err.Error(), http.StatusNotFound)
 			return
 		}
 	}
 
 	// return updated user
-	if err := user.ToJSON(rw); err != nil {
+	if err := respond(rw, r, user); err != nil {
 		http.Error(rw,
 			fmt.Sprintf("user with ID: '%d' was updated sucessfully, but failed to retrieve it",
 				user.ID),
@@ -191,7 +235,7 @@ func (h *User) update(rw http.ResponseWriter, r *http.Request) {
 // delete remove from the data store a single user and retrieve it
 // to the client.
 func (h *User) delete(rw http.ResponseWriter, r *http.Request) {
-	h.logger.Println("received a DELETE user request")
+	h.logger.For(r.Context()).Debug("received a DELETE user request")
 
 	deleteUserRe := regexp.MustCompile(`^/users/(\d+)$`)
 
@@ -203,14 +247,20 @@ func (h *User) delete(rw http.ResponseWriter, r *http.Request) {
 	}
 
 	// delete user from datastore
-	user, err := data.RemoveUser(uint64(userID))
+	storeSpan := traceStore(r, "RemoveUser")
+	user, err := data.RemoveUser(r.Context(), uint64(userID))
+	storeSpan.End()
+	if errors.Is(err, data.ErrReferenced) {
+		http.Error(rw, err.Error(), http.StatusConflict)
+		return
+	}
 	if err != nil {
 		http.Error(rw, data.UserNotFoundError, http.StatusNotFound)
 		return
 	}
 
 	// return deleted user to client
-	if err := user.ToJSON(rw); err != nil {
+	if err := respond(rw, r, user); err != nil {
 		http.Error(rw,
 			fmt.Sprintf("user with ID: '%d' was deleted, but failed to retrieve it",
 				user.ID),
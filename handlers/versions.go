@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"time"
+
+	"github.com/imariom/products-api/apiversion"
+)
+
+// cartFieldsV2 are the cart fields renamed in v2 to follow the snake
+// case of the other resources (v1 name: v2 name).
+var cartFieldsV2 = map[string]string{
+	"userId":   "user_id",
+	"products": "items",
+}
+
+// Versions are the versions of the API. v1 is the representation the
+// handlers implement, other versions convert from and to it.
+var Versions = []*apiversion.Version{
+	{
+		Name:       "v1",
+		Deprecated: time.Date(2026, time.October, 18, 0, 0, 0, 0, time.UTC),
+		Sunset:     time.Date(2027, time.April, 18, 0, 0, 0, 0, time.UTC),
+	},
+	{
+		Name: "v2",
+		Transforms: []apiversion.Transform{{
+			Prefix:   "/carts",
+			Request:  apiversion.RenameFields(apiversion.Invert(cartFieldsV2)),
+			Response: apiversion.RenameFields(cartFieldsV2),
+		}},
+	},
+}
+
+// DefaultVersion is the version served on unversioned paths.
+const DefaultVersion = "v1"
+
+// VersionedPrefixes are the paths of the resources served under the
+// version prefixes.
+var VersionedPrefixes = []string{"/products", "/carts", "/users", "/trash"}
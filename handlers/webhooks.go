@@ -0,0 +1,244 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+
+	"github.com/imariom/products-api/data"
+	"github.com/imariom/products-api/logging"
+	"github.com/imariom/products-api/webhooks"
+)
+
+// Webhooks is the HTTP handler of the admin endpoints managing the
+// webhook subscriptions (/admin/webhooks) and their delivery log
+// (/admin/webhooks/deliveries).
+type Webhooks struct {
+	logger     *logging.Logger
+	dispatcher *webhooks.Dispatcher
+}
+
+// NewWebhooks is a constructor for Webhooks handler.
+func NewWebhooks(l *logging.Logger, d *webhooks.Dispatcher) *Webhooks {
+	return &Webhooks{l, d}
+}
+
+var (
+	webhookRe    = regexp.MustCompile(`^/admin/webhooks/(\d+)$`)
+	deliveryRe   = regexp.MustCompile(`^/admin/webhooks/deliveries/(\d+)$`)
+	redeliveryRe = regexp.MustCompile(`^/admin/webhooks/deliveries/(\d+):redeliver$`)
+)
+
+// ServeHTTP implements http.Handler.
+func (h *Webhooks) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	r, ok := negotiate(rw, r)
+	if !ok {
+		return
+	}
+
+	path := r.URL.Path
+	switch {
+	case path == "/admin/webhooks" && r.Method == http.MethodGet:
+		h.list(rw, r)
+
+	case path == "/admin/webhooks" && r.Method == http.MethodPost:
+		h.create(rw, r)
+
+	case webhookRe.MatchString(path) && r.Method == http.MethodGet:
+		h.get(rw, r)
+
+	case webhookRe.MatchString(path) && r.Method == http.MethodPut:
+		h.update(rw, r)
+
+	case webhookRe.MatchString(path) && r.Method == http.MethodDelete:
+		h.delete(rw, r)
+
+	case path == "/admin/webhooks/deliveries" && r.Method == http.MethodGet:
+		h.listDeliveries(rw, r)
+
+	case deliveryRe.MatchString(path) && r.Method == http.MethodGet:
+		h.getDelivery(rw, r)
+
+	case redeliveryRe.MatchString(path) && r.Method == http.MethodPost:
+		h.redeliver(rw, r)
+
+	case path == "/admin/webhooks" || webhookRe.MatchString(path) || path == "/admin/webhooks/deliveries" ||
+		deliveryRe.MatchString(path) || redeliveryRe.MatchString(path):
+		http.Error(rw, "HTTP verb not implemented", http.StatusNotImplemented)
+
+	default:
+		http.NotFound(rw, r)
+	}
+}
+
+// list returns the subscriptions, without their secret.
+func (h *Webhooks) list(rw http.ResponseWriter, r *http.Request) {
+	h.logger.For(r.Context()).Debug("received a GET webhooks request")
+
+	if err := respond(rw, r, h.dispatcher.Subscriptions()); err != nil {
+		http.Error(rw, "failed to retrieve webhooks", http.StatusInternalServerError)
+	}
+}
+
+// create adds a subscription. Its secret, generated unless given, is
+// only returned by this request.
+func (h *Webhooks) create(rw http.ResponseWriter, r *http.Request) {
+	h.logger.For(r.Context()).Debug("received a POST webhook request")
+
+	s := &webhooks.Subscription{}
+	if err := decodeBody(r, s); err != nil {
+		http.Error(rw, "invalid webhook payload", http.StatusBadRequest)
+		return
+	}
+
+	if err := data.Validate(s); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.dispatcher.AddSubscription(s); err != nil {
+		h.error(rw, err)
+		return
+	}
+
+	if err := respond(rw, r, s); err != nil {
+		http.Error(rw, "failed to retrieve webhook", http.StatusInternalServerError)
+	}
+}
+
+// get returns a subscription, without its secret.
+func (h *Webhooks) get(rw http.ResponseWriter, r *http.Request) {
+	h.logger.For(r.Context()).Debug("received a GET webhook request")
+
+	id, _ := getItemID(webhookRe, r.URL.Path)
+	s, err := h.dispatcher.Subscription(id)
+	if err != nil {
+		h.error(rw, err)
+		return
+	}
+
+	if err := respond(rw, r, s); err != nil {
+		http.Error(rw, "failed to retrieve webhook", http.StatusInternalServerError)
+	}
+}
+
+// update replaces a subscription. Its secret is kept unless a new one
+// is given.
+func (h *Webhooks) update(rw http.ResponseWriter, r *http.Request) {
+	h.logger.For(r.Context()).Debug("received a PUT webhook request")
+
+	s := &webhooks.Subscription{}
+	if err := decodeBody(r, s); err != nil {
+		http.Error(rw, "invalid webhook payload", http.StatusBadRequest)
+		return
+	}
+	s.ID, _ = getItemID(webhookRe, r.URL.Path)
+
+	if err := data.Validate(s); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.dispatcher.SetSubscription(s); err != nil {
+		h.error(rw, err)
+		return
+	}
+
+	if err := respond(rw, r, s); err != nil {
+		http.Error(rw, "failed to retrieve webhook", http.StatusInternalServerError)
+	}
+}
+
+// delete removes a subscription. Its pending deliveries fail.
+func (h *Webhooks) delete(rw http.ResponseWriter, r *http.Request) {
+	h.logger.For(r.Context()).Debug("received a DELETE webhook request")
+
+	id, _ := getItemID(webhookRe, r.URL.Path)
+	s, err := h.dispatcher.RemoveSubscription(id)
+	if err != nil {
+		h.error(rw, err)
+		return
+	}
+
+	if err := respond(rw, r, s); err != nil {
+		http.Error(rw, "failed to retrieve webhook", http.StatusInternalServerError)
+	}
+}
+
+// listDeliveries returns the delivery log, the most recent deliveries
+// first, filtered by the subscription_id, event_type and status query
+// parameters.
+func (h *Webhooks) listDeliveries(rw http.ResponseWriter, r *http.Request) {
+	h.logger.For(r.Context()).Debug("received a GET webhook deliveries request")
+
+	limit, offset, _ := getQueryParams(r.URL.RawQuery)
+	query := r.URL.Query()
+
+	filter := webhooks.DeliveryFilter{
+		EventType: query.Get("event_type"),
+		Status:    query.Get("status"),
+		Limit:     limit,
+		Offset:    offset,
+	}
+
+	if id := query.Get("subscription_id"); id != "" {
+		n, err := strconv.ParseUint(id, 10, 64)
+		if err != nil {
+			http.Error(rw, "invalid subscription ID", http.StatusBadRequest)
+			return
+		}
+		filter.SubscriptionID = n
+	}
+
+	if err := respond(rw, r, h.dispatcher.Deliveries(filter)); err != nil {
+		http.Error(rw, "failed to retrieve deliveries", http.StatusInternalServerError)
+	}
+}
+
+// getDelivery returns a delivery with its attempts.
+func (h *Webhooks) getDelivery(rw http.ResponseWriter, r *http.Request) {
+	h.logger.For(r.Context()).Debug("received a GET webhook delivery request")
+
+	id, _ := getItemID(deliveryRe, r.URL.Path)
+	d, err := h.dispatcher.Delivery(id)
+	if err != nil {
+		h.error(rw, err)
+		return
+	}
+
+	if err := respond(rw, r, d); err != nil {
+		http.Error(rw, "failed to retrieve delivery", http.StatusInternalServerError)
+	}
+}
+
+// redeliver queues a new delivery of the event of a delivery.
+func (h *Webhooks) redeliver(rw http.ResponseWriter, r *http.Request) {
+	h.logger.For(r.Context()).Debug("received a webhook redelivery request")
+
+	id, _ := getItemID(redeliveryRe, r.URL.Path)
+	d, err := h.dispatcher.Redeliver(id)
+	if err != nil {
+		h.error(rw, err)
+		return
+	}
+
+	rw.WriteHeader(http.StatusAccepted)
+	if err := respond(rw, r, d); err != nil {
+		h.logger.For(r.Context()).Error("failed to encode redelivery", "error", err)
+	}
+}
+
+// error answers with the status matching err.
+func (h *Webhooks) error(rw http.ResponseWriter, err error) {
+	var ve *webhooks.ValidationError
+
+	switch {
+	case errors.Is(err, webhooks.ErrNotFound):
+		http.Error(rw, err.Error(), http.StatusNotFound)
+	case errors.As(err, &ve):
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(rw, err.Error(), http.StatusConflict)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/imariom/products-api/data"
+	"github.com/imariom/products-api/handlers"
+	"github.com/imariom/products-api/logging"
+	"github.com/imariom/products-api/queue"
+	"github.com/imariom/products-api/scheduler"
+)
+
+// cleanupOptions configures the recurring cleanups of the data stores.
+type cleanupOptions struct {
+	// TrashRetention is the time the deleted records are kept in the
+	// trash, forever when zero.
+	TrashRetention     time.Duration
+	TrashPurgeInterval time.Duration
+
+	// GuestCartTTL is the time the guest carts are kept after their
+	// last change.
+	GuestCartTTL            time.Duration
+	GuestCartExpiryInterval time.Duration
+
+	// AbandonedCartAfter is the time after their last change the carts
+	// with items are flagged as abandoned, never when zero.
+	AbandonedCartAfter    time.Duration
+	AbandonedCartInterval time.Duration
+}
+
+// scheduleCleanups registers the jobs cleaning up the data stores on q,
+// and schedules them on s to be queued at their interval. A cleanup is
+// not queued again while the previous one is pending or running.
+func scheduleCleanups(s *scheduler.Scheduler, q *queue.Queue, l *logging.Logger, opts cleanupOptions) error {
+	// purge the trash of the records deleted before the retention
+	if opts.TrashRetention > 0 {
+		q.Register("trash.purge", func(ctx context.Context, _ json.RawMessage) error {
+			if n := data.PurgeTrash(ctx, time.Now().Add(-opts.TrashRetention)); n > 0 {
+				l.Info("purged the trash", "records", n, "retention", opts.TrashRetention.String())
+			}
+			return nil
+		})
+		if err := s.Every("trash-purge", opts.TrashPurgeInterval, enqueueTask(q, "trash.purge")); err != nil {
+			return err
+		}
+	}
+
+	// drop the guest carts unused for longer than their TTL. Carts do
+	// not reserve stock, which the store does not keep, so there are no
+	// reservations to release with them.
+	q.Register("carts.expire_guests", func(ctx context.Context, _ json.RawMessage) error {
+		if n := data.ExpireGuestCarts(ctx, time.Now().Add(-opts.GuestCartTTL)); n > 0 {
+			l.Info("expired guest carts", "carts", n, "ttl", opts.GuestCartTTL.String())
+		}
+		return nil
+	})
+	if err := s.Every("guest-cart-expiry", opts.GuestCartExpiryInterval, enqueueTask(q, "carts.expire_guests")); err != nil {
+		return err
+	}
+
+	// flag the carts left unchanged, so they can be followed up on
+	// through the cart.abandoned events
+	if opts.AbandonedCartAfter > 0 {
+		q.Register("carts.flag_abandoned", func(ctx context.Context, _ json.RawMessage) error {
+			if n := data.FlagAbandonedCarts(ctx, time.Now().Add(-opts.AbandonedCartAfter)); n > 0 {
+				l.Info("flagged abandoned carts", "carts", n, "after", opts.AbandonedCartAfter.String())
+			}
+			return nil
+		})
+		if err := s.Every("abandoned-carts", opts.AbandonedCartInterval, enqueueTask(q, "carts.flag_abandoned")); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// enqueueTask returns a scheduled task queueing a job of kind, unless
+// one is still pending or running.
+func enqueueTask(q *queue.Queue, kind string) scheduler.Task {
+	return func(ctx context.Context) error {
+		err := q.Enqueue(&queue.Job{Kind: kind, UniqueKey: kind})
+		if errors.Is(err, queue.ErrDuplicate) {
+			return nil
+		}
+		return err
+	}
+}
+
+// scheduleJobs schedules the jobs of schedules, semicolon separated
+// kind=cron entries (e.g. "trash.purge=0 3 * * *"), to be queued at
+// the times of their cron expression.
+func scheduleJobs(s *scheduler.Scheduler, q *queue.Queue, schedules string) error {
+	for _, entry := range strings.Split(schedules, ";") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+
+		kind, spec, ok := strings.Cut(entry, "=")
+		kind = strings.TrimSpace(kind)
+		if !ok {
+			return fmt.Errorf("invalid job schedule %q: expected kind=cron", entry)
+		}
+		if !q.Handles(kind) {
+			return fmt.Errorf("invalid job schedule %q: unknown job kind '%s'", entry, kind)
+		}
+
+		if err := s.Cron(kind, spec, enqueueTask(q, kind)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// registerJobs reports the state of the jobs of s on the /status
+// endpoint.
+func registerJobs(h *handlers.Health, s *scheduler.Scheduler) {
+	for _, j := range s.Jobs() {
+		name := j.Name
+		h.RegisterJob(name, func() handlers.JobState {
+			j, _ := s.Job(name)
+			return handlers.JobState{
+				State:     j.State,
+				LastRun:   j.LastRun,
+				NextRun:   j.NextRun,
+				LastError: j.LastError,
+			}
+		})
+	}
+}
+
+// interval is a flag.Value of the interval of a recurring job, which
+// must be positive: a job would otherwise run continuously.
+type interval time.Duration
+
+func (d *interval) String() string {
+	return time.Duration(*d).String()
+}
+
+func (d *interval) Set(s string) error {
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	if v <= 0 {
+		return fmt.Errorf("the interval must be positive")
+	}
+
+	*d = interval(v)
+	return nil
+}
+
+// intervalFlag defines an interval flag with the name, the default
+// value and the usage string.
+func intervalFlag(name string, value time.Duration, usage string) *time.Duration {
+	d := value
+	flag.Var((*interval)(&d), name, usage)
+	return &d
+}
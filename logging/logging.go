@@ -0,0 +1,331 @@
+// Package logging implements a small structured, leveled logger that
+// writes JSON or logfmt lines and redacts sensitive fields.
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is the severity of a log line.
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+func (lv Level) String() string {
+	switch lv {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarn:
+		return "warn"
+	case LevelError:
+		return "error"
+	}
+
+	return "level(" + strconv.Itoa(int(lv)) + ")"
+}
+
+// ParseLevel converts a level name ("debug", "info", "warn", "error")
+// to a Level.
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return LevelDebug, nil
+	case "info", "":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	}
+
+	return LevelInfo, fmt.Errorf("unknown log level %q", s)
+}
+
+// supported output formats
+const (
+	FormatJSON   = "json"
+	FormatLogfmt = "logfmt"
+)
+
+// Redacted replaces the value of sensitive fields.
+const Redacted = "[REDACTED]"
+
+// sensitiveKeys are matched (case insensitively) against field keys to
+// decide whether their values are redacted.
+var sensitiveKeys = []string{"password", "passwd", "token", "secret", "authorization", "cookie", "api_key", "apikey"}
+
+// IsSensitive reports whether values stored under key must be redacted.
+func IsSensitive(key string) bool {
+	key = strings.ToLower(key)
+	for _, s := range sensitiveKeys {
+		if strings.Contains(key, s) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Options configures a Logger.
+type Options struct {
+	// Level is the minimum level written.
+	Level Level
+
+	// Format is either FormatJSON (the default) or FormatLogfmt.
+	Format string
+}
+
+// output is shared by a logger and all the loggers derived from it.
+type output struct {
+	mtx    sync.Mutex
+	w      io.Writer
+	level  Level
+	format string
+}
+
+// Logger writes structured log lines. Fields are given as alternating
+// keys and values, e.g. logger.Info("user created", "user_id", 3).
+type Logger struct {
+	out    *output
+	fields []interface{}
+}
+
+// New creates a Logger writing to w.
+func New(w io.Writer, opts Options) *Logger {
+	format := opts.Format
+	if format != FormatLogfmt {
+		format = FormatJSON
+	}
+
+	return &Logger{out: &output{w: w, level: opts.Level, format: format}}
+}
+
+// Discard is a logger that writes nothing.
+var Discard = New(io.Discard, Options{Level: LevelError + 1})
+
+// Default is the logger used when none was configured.
+var Default = New(os.Stderr, Options{Level: LevelInfo})
+
+// With returns a logger that adds the given fields to every line.
+func (l *Logger) With(kv ...interface{}) *Logger {
+	fields := make([]interface{}, 0, len(l.fields)+len(kv))
+	fields = append(fields, l.fields...)
+	fields = append(fields, kv...)
+
+	return &Logger{out: l.out, fields: fields}
+}
+
+// Enabled reports whether lines of level lv are written.
+func (l *Logger) Enabled(lv Level) bool {
+	return lv >= l.out.level
+}
+
+// Debug writes a debug line.
+func (l *Logger) Debug(msg string, kv ...interface{}) {
+	l.log(LevelDebug, msg, kv)
+}
+
+// Info writes an informational line.
+func (l *Logger) Info(msg string, kv ...interface{}) {
+	l.log(LevelInfo, msg, kv)
+}
+
+// Warn writes a warning line.
+func (l *Logger) Warn(msg string, kv ...interface{}) {
+	l.log(LevelWarn, msg, kv)
+}
+
+// Error writes an error line.
+func (l *Logger) Error(msg string, kv ...interface{}) {
+	l.log(LevelError, msg, kv)
+}
+
+func (l *Logger) log(lv Level, msg string, kv []interface{}) {
+	if !l.Enabled(lv) {
+		return
+	}
+
+	fields := make([]interface{}, 0, 6+len(l.fields)+len(kv))
+	fields = append(fields, "time", time.Now().UTC().Format(time.RFC3339Nano),
+		"level", lv.String(), "msg", msg)
+	fields = append(fields, l.fields...)
+	fields = append(fields, kv...)
+
+	buf := &bytes.Buffer{}
+	if l.out.format == FormatLogfmt {
+		writeLogfmt(buf, fields)
+	} else {
+		writeJSON(buf, fields)
+	}
+	buf.WriteByte('\n')
+
+	l.out.mtx.Lock()
+	l.out.w.Write(buf.Bytes())
+	l.out.mtx.Unlock()
+}
+
+// StdLogger returns a *log.Logger whose output is written by l at level
+// lv, for packages that only accept the standard logger (e.g.
+// http.Server.ErrorLog).
+func (l *Logger) StdLogger(lv Level) *log.Logger {
+	return log.New(&stdWriter{l, lv}, "", 0)
+}
+
+type stdWriter struct {
+	l  *Logger
+	lv Level
+}
+
+func (w *stdWriter) Write(p []byte) (int, error) {
+	w.l.log(w.lv, strings.TrimSpace(string(p)), nil)
+	return len(p), nil
+}
+
+// contextKey is the type of the context key used to store loggers.
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying l.
+func NewContext(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, l)
+}
+
+// FromContext returns the logger carried by ctx, or Default if none.
+func FromContext(ctx context.Context) *Logger {
+	if l, ok := ctx.Value(contextKey{}).(*Logger); ok {
+		return l
+	}
+
+	return Default
+}
+
+// For returns the logger carried by ctx (e.g. the request scoped logger
+// with the request ID) or l itself if ctx has none.
+func (l *Logger) For(ctx context.Context) *Logger {
+	if cl, ok := ctx.Value(contextKey{}).(*Logger); ok {
+		return cl
+	}
+
+	return l
+}
+
+// fieldKey returns the key at position i of a key-value list.
+func fieldKey(k interface{}) string {
+	if s, ok := k.(string); ok {
+		return s
+	}
+
+	return fmt.Sprint(k)
+}
+
+// fieldValue converts a value to something that encodes well, redacting
+// it if key is sensitive.
+func fieldValue(key string, v interface{}) interface{} {
+	if IsSensitive(key) {
+		return Redacted
+	}
+
+	switch val := v.(type) {
+	case nil:
+		return nil
+	case error:
+		return val.Error()
+	case time.Duration:
+		return val.String()
+	case time.Time:
+		return val.UTC().Format(time.RFC3339Nano)
+	case fmt.Stringer:
+		return val.String()
+	}
+
+	return v
+}
+
+func writeJSON(buf *bytes.Buffer, fields []interface{}) {
+	buf.WriteByte('{')
+	for i := 0; i < len(fields); i += 2 {
+		key := fieldKey(fields[i])
+
+		var val interface{} = "!MISSING"
+		if i+1 < len(fields) {
+			val = fieldValue(key, fields[i+1])
+		}
+
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+
+		k, _ := json.Marshal(key)
+		buf.Write(k)
+		buf.WriteByte(':')
+
+		b, err := json.Marshal(val)
+		if err != nil {
+			b, _ = json.Marshal(fmt.Sprint(val))
+		}
+		buf.Write(b)
+	}
+	buf.WriteByte('}')
+}
+
+func writeLogfmt(buf *bytes.Buffer, fields []interface{}) {
+	for i := 0; i < len(fields); i += 2 {
+		key := fieldKey(fields[i])
+
+		var val interface{} = "!MISSING"
+		if i+1 < len(fields) {
+			val = fieldValue(key, fields[i+1])
+		}
+
+		if i > 0 {
+			buf.WriteByte(' ')
+		}
+
+		buf.WriteString(strings.Map(func(r rune) rune {
+			if r <= ' ' || r == '=' || r == '"' {
+				return '_'
+			}
+			return r
+		}, key))
+		buf.WriteByte('=')
+
+		var s string
+		switch v := val.(type) {
+		case nil:
+			s = "null"
+		case string:
+			s = v
+		default:
+			if b, err := json.Marshal(v); err == nil {
+				s = string(b)
+				// JSON strings are already quoted
+				if len(s) > 0 && s[0] == '"' {
+					s, _ = strconv.Unquote(s)
+				}
+			} else {
+				s = fmt.Sprint(v)
+			}
+		}
+
+		if s == "" || strings.ContainsAny(s, " =\"\t\n\\") {
+			s = strconv.Quote(s)
+		}
+		buf.WriteString(s)
+	}
+}
@@ -1,38 +1,417 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"flag"
+	"fmt"
 	"log"
-	"net/http"
 	"os"
+	"strings"
+	"time"
 
+	"github.com/imariom/products-api/audit"
+	"github.com/imariom/products-api/data"
+	"github.com/imariom/products-api/events"
+	"github.com/imariom/products-api/graphql"
+	"github.com/imariom/products-api/grpc"
 	"github.com/imariom/products-api/handlers"
+	"github.com/imariom/products-api/logging"
+	"github.com/imariom/products-api/middleware"
+	"github.com/imariom/products-api/queue"
+	"github.com/imariom/products-api/scheduler"
 	"github.com/imariom/products-api/server"
+	"github.com/imariom/products-api/tracing"
+	"github.com/imariom/products-api/webhooks"
+)
+
+// build information, set at build time with
+// -ldflags "-X main.version=... -X main.commit=... -X main.buildDate=..."
+var (
+	version   = "dev"
+	commit    = "unknown"
+	buildDate = "unknown"
 )
 
 func main() {
+	addr := flag.String("addr", "127.0.0.1:8080", "address the server listens on")
+	listen := flag.String("listen", "", "comma separated extra addresses to listen on (host:port, unix:/path or systemd)")
+	tlsCert := flag.String("tls-cert", "", "path to the TLS certificate (enables HTTPS)")
+	tlsKey := flag.String("tls-key", "", "path to the TLS private key")
+	tlsClientCA := flag.String("tls-client-ca", "", "path to a CA bundle used to verify client certificates (enables mTLS)")
+	batchMaxOps := flag.Int("batch-max-operations", handlers.DefaultMaxBatchOperations, "maximum number of operations in a batch request")
+	graphqlMaxDepth := flag.Int("graphql-max-depth", graphql.DefaultMaxDepth, "maximum depth of GraphQL queries")
+	graphqlMaxComplexity := flag.Int("graphql-max-complexity", graphql.DefaultMaxComplexity, "maximum complexity of GraphQL queries")
+	idempotencyTTL := flag.Duration("idempotency-ttl", middleware.DefaultIdempotencyTTL, "time the Idempotency-Key of POST requests are remembered")
+	eventsReplay := flag.Int("events-replay", events.DefaultReplaySize, "number of events kept for clients resuming their event stream")
+	webhooksFile := flag.String("webhooks-file", "webhooks.json", "file the webhook subscriptions are persisted to (in memory when empty), the deliveries are queued jobs")
+	webhooksMaxAttempts := flag.Int("webhooks-max-attempts", webhooks.DefaultMaxAttempts, "number of attempts before a webhook delivery fails")
+	onDeleteProduct := flag.String("on-delete-product", string(data.Restrict), "what happens to the cart items of a removed product (restrict, cascade or nullify)")
+	onDeleteUser := flag.String("on-delete-user", string(data.Restrict), "what happens to the carts of a removed user (restrict or cascade)")
+	historyMaxRevisions := flag.Int("history-max-revisions", data.DefaultMaxRevisions, "number of revisions kept by product and cart for the as_of reads, the oldest being dropped beyond")
+	trashRetentionDays := flag.Int("trash-retention-days", 30, "days deleted records are kept in the trash before they are purged (kept forever when 0)")
+	trashPurgeInterval := intervalFlag("trash-purge-interval", time.Hour, "time between two purges of the trash")
+	guestCartTTL := flag.Duration("guest-cart-ttl", handlers.DefaultGuestCartTTL, "time guest carts are kept after their last change")
+	guestCartExpiryInterval := intervalFlag("guest-cart-expiry-interval", 15*time.Minute, "time between two expirations of the unused guest carts")
+	guestCartMerge := flag.String("guest-cart-merge", string(data.MergeSum), "how the quantities of a product in a guest cart and a user cart are merged (sum, max, guest or user)")
+	abandonedCartAfter := flag.Duration("abandoned-cart-after", 24*time.Hour, "time after their last change carts with items are flagged as abandoned (never when 0)")
+	abandonedCartInterval := intervalFlag("abandoned-cart-interval", 15*time.Minute, "time between two searches for abandoned carts")
+	queueFile := flag.String("queue-file", "jobs.json", "file the background job queue is persisted to (in memory when empty)")
+	queueWorkers := flag.Int("queue-workers", queue.DefaultWorkers, "number of background jobs run concurrently")
+	queueMaxAttempts := flag.Int("queue-max-attempts", queue.DefaultMaxAttempts, "number of attempts before a background job is moved to the dead letters")
+	queueDrainTimeout := flag.Duration("queue-drain-timeout", queue.DefaultDrainTimeout, "time the running background jobs are given to end when shutting down before they are queued again")
+	jobSchedules := flag.String("job-schedules", "", "semicolon separated cron schedules of background jobs, e.g. \"trash.purge=0 3 * * *\"")
+	cartTokenSecret := flag.String("cart-token-secret", "", "secret signing the guest cart tokens (random, so they are invalidated by restarts, when empty)")
+	auditFile := flag.String("audit-file", "", "file the audit log of the changes is appended to, its anchor being written next to it (the last changes are kept in memory when empty)")
+	adminTokens := flag.String("admin-tokens", "", "comma separated bearer tokens accepted by the admin endpoints (no authentication when empty, but the webhooks and the audit log are then refused)")
+	grpcAddr := flag.String("grpc-addr", "", "address the gRPC server listens on (disabled when empty)")
+	grpcTokens := flag.String("grpc-tokens", "", "comma separated bearer tokens accepted by the gRPC server (no authentication when empty)")
+	grpcReflection := flag.Bool("grpc-reflection", true, "serve the gRPC server reflection service")
+	drainDelay := flag.Duration("drain-delay", 0, "time to keep serving after shutdown begins so load balancers can drain")
+	logLevel := flag.String("log-level", "info", "minimum log level (debug, info, warn or error)")
+	logFormat := flag.String("log-format", logging.FormatJSON, "log format (json or logfmt)")
+	traceExporter := flag.String("trace-exporter", "none", "trace exporter (none, otlp, stdout or file)")
+	traceEndpoint := flag.String("trace-endpoint", tracing.DefaultOTLPEndpoint, "OTLP/HTTP traces endpoint")
+	traceFile := flag.String("trace-file", "traces.jsonl", "file the file exporter writes spans to")
+	traceSampleRatio := flag.Float64("trace-sample-ratio", 1, "fraction of new traces recorded")
+	flag.Parse()
+
 	// Logger for the API
-	logger := log.New(os.Stdout, "[PRODUCT API] ", log.LstdFlags)
+	level, err := logging.ParseLevel(*logLevel)
+	if err != nil {
+		log.Fatalln("[ERROR]", err)
+	}
+
+	logger := logging.New(os.Stdout, logging.Options{
+		Level:  level,
+		Format: *logFormat,
+	}).With("service", "store-api")
+
+	// tracing
+	var exporter tracing.Exporter
+	switch *traceExporter {
+	case "none":
+	case "otlp":
+		exporter = tracing.NewOTLPExporter(*traceEndpoint, nil)
+	case "stdout":
+		exporter = tracing.NewWriterExporter(os.Stdout)
+	case "file":
+		f, err := os.OpenFile(*traceFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			logger.Error("failed to open trace file", "error", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		exporter = tracing.NewWriterExporter(f)
+	default:
+		logger.Error("unknown trace exporter", "exporter", *traceExporter)
+		os.Exit(1)
+	}
+
+	tracer := tracing.NewTracer(tracing.Options{
+		ServiceName: "store-api",
+		Exporter:    exporter,
+		SampleRatio: *traceSampleRatio,
+	})
+	tracing.SetDefault(tracer)
+
+	// referential integrity of the carts
+	err = data.SetOnDelete(data.OnDelete{
+		Products: data.DeletePolicy(*onDeleteProduct),
+		Users:    data.DeletePolicy(*onDeleteUser),
+	})
+	if err != nil {
+		logger.Error("invalid on-delete policy", "error", err)
+		os.Exit(1)
+	}
+
+	// revisions of the records, read with as_of
+	if err := data.SetMaxRevisions(*historyMaxRevisions); err != nil {
+		logger.Error("invalid history retention", "error", err)
+		os.Exit(1)
+	}
+
+	// guest carts, identified by signed tokens and merged into the cart
+	// of the guests once they log in
+	if err := data.SetMergeRule(data.MergeRule(*guestCartMerge)); err != nil {
+		logger.Error("invalid guest cart merge rule", "error", err)
+		os.Exit(1)
+	}
+
+	cartTokenKey := []byte(*cartTokenSecret)
+	if len(cartTokenKey) == 0 {
+		cartTokenKey = make([]byte, 32)
+		if _, err := rand.Read(cartTokenKey); err != nil {
+			logger.Error("failed to generate the cart token secret", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	// audit log of the changes, made on behalf of the authenticated
+	// user of the requests
+	auditLog, err := audit.Open(audit.Options{Path: *auditFile, Logger: logger})
+	if err != nil {
+		logger.Error("failed to open the audit log", "error", err)
+		os.Exit(1)
+	}
+	data.SetAuditor(auditLog)
+	data.SetOriginFunc(func(ctx context.Context) (string, string) {
+		return middleware.ActorFromContext(ctx), middleware.RequestIDFromContext(ctx)
+	})
+
+	// changes of the data store
+	events.SetDefault(events.NewBus(*eventsReplay))
+
+	// background jobs, run by a pool of workers and retried until they
+	// succeed or run out of attempts
+	jobQueue, err := queue.New(queue.Options{
+		Path:         *queueFile,
+		Workers:      *queueWorkers,
+		MaxAttempts:  *queueMaxAttempts,
+		DrainTimeout: *queueDrainTimeout,
+		Logger:       logger,
+	})
+	if err != nil {
+		logger.Error("failed to load jobs", "error", err)
+		os.Exit(1)
+	}
+
+	// webhook deliveries of the changes, run by the job queue
+	dispatcher, err := webhooks.New(webhooks.Options{
+		Path:        *webhooksFile,
+		Queue:       jobQueue,
+		MaxAttempts: *webhooksMaxAttempts,
+		Logger:      logger,
+	})
+	if err != nil {
+		logger.Error("failed to load webhooks", "error", err)
+		os.Exit(1)
+	}
+
+	// recurring jobs, reported by /status and /admin/jobs. They queue
+	// the cleanups, which are not queued twice when they fall behind
+	jobs := scheduler.New(logger)
+
+	err = scheduleCleanups(jobs, jobQueue, logger, cleanupOptions{
+		TrashRetention:          time.Duration(*trashRetentionDays) * 24 * time.Hour,
+		TrashPurgeInterval:      *trashPurgeInterval,
+		GuestCartTTL:            *guestCartTTL,
+		GuestCartExpiryInterval: *guestCartExpiryInterval,
+		AbandonedCartAfter:      *abandonedCartAfter,
+		AbandonedCartInterval:   *abandonedCartInterval,
+	})
+	if err != nil {
+		logger.Error("failed to schedule the cleanups", "error", err)
+		os.Exit(1)
+	}
+
+	// additional cron schedules of the jobs
+	if err := scheduleJobs(jobs, jobQueue, *jobSchedules); err != nil {
+		logger.Error("invalid job schedules", "error", err)
+		os.Exit(1)
+	}
 
 	// api handlers
 	productHandler := handlers.NewProduct(logger)
 	cartHandler := handlers.NewCart(logger)
+	guestCartHandler := handlers.NewGuestCart(logger, handlers.GuestCartOptions{
+		Key: cartTokenKey,
+		TTL: *guestCartTTL,
+	})
 	usersHandler := handlers.NewUser(logger)
+	catalogHandler := handlers.NewCatalog(logger, jobQueue)
+	graphqlHandler, err := handlers.NewGraphQL(logger, handlers.GraphQLOptions{
+		MaxDepth:      *graphqlMaxDepth,
+		MaxComplexity: *graphqlMaxComplexity,
+	})
+	if err != nil {
+		logger.Error("failed to create GraphQL schema", "error", err)
+		os.Exit(1)
+	}
+	webhooksHandler := handlers.NewWebhooks(logger, dispatcher)
+	integrityHandler := handlers.NewIntegrity(logger)
+	jobsHandler := handlers.NewJobs(logger, jobs)
+	queueHandler := handlers.NewQueue(logger, jobQueue)
+	trashHandler := handlers.NewTrash(logger)
+	auditHandler := handlers.NewAudit(logger, auditLog)
+	eventsHandler := handlers.NewEvents(logger, handlers.EventsOptions{})
+	healthHandler := handlers.NewHealth(logger, handlers.BuildInfo{
+		Version:   version,
+		Commit:    commit,
+		BuildDate: buildDate,
+	})
 
-	// multiplexer
-	mux := http.NewServeMux()
-	mux.Handle("/products/", productHandler)
-	mux.Handle("/products", productHandler)
+	registerJobs(healthHandler, jobs)
 
-	mux.Handle("/carts/", cartHandler)
-	mux.Handle("/carts", cartHandler)
+	// routing of the requests, on the paths of handlers.Routes
+	var admin []string
+	if *adminTokens != "" {
+		admin = strings.Split(*adminTokens, ",")
+	}
 
-	mux.Handle("/users/", usersHandler)
-	mux.Handle("/users", usersHandler)
+	router, err := newRouter(logger, apiHandlers{
+		Product:   productHandler,
+		Cart:      cartHandler,
+		GuestCart: guestCartHandler,
+		Users:     usersHandler,
+		Catalog:   catalogHandler,
+		Trash:     trashHandler,
+		GraphQL:   graphqlHandler,
+		Events:    eventsHandler,
+		Webhooks:  webhooksHandler,
+		Integrity: integrityHandler,
+		Jobs:      jobsHandler,
+		Queue:     queueHandler,
+		Audit:     auditHandler,
+		Health:    healthHandler,
+	}, routerOptions{
+		AdminTokens:        admin,
+		BatchMaxOperations: *batchMaxOps,
+	})
+	if err != nil {
+		logger.Error("failed to create API version router", "error", err)
+		os.Exit(1)
+	}
+
+	// label the requests with the routes they match
+	middleware.SetRoutes(routeTemplates())
 
 	// create and run server
-	server.Run(&server.Options{
-		Addr:    "127.0.0.1:8080",
-		Handler: mux,
-		Logger:  logger,
+	opts := &server.Options{
+		Addr: *addr,
+		Handler: middleware.Chain(router,
+			middleware.RequestID,
+			middleware.UserAuth(authenticateUser),
+			middleware.Actor,
+			middleware.Tracing,
+			middleware.Logging(logger),
+			middleware.Metrics,
+			middleware.Idempotency(middleware.IdempotencyOptions{
+				TTL: *idempotencyTTL,
+			}),
+		),
+		Logger: logger,
+
+		HandleSignals: true,
+		DrainDelay:    *drainDelay,
+	}
+
+	// gRPC services on their own port, sharing the data store
+	var grpcHealth *grpc.Health
+	if *grpcAddr != "" {
+		interceptors := []grpc.Interceptor{grpc.Logging(logger)}
+		if *grpcTokens != "" {
+			interceptors = append(interceptors, grpc.TokenAuth(strings.Split(*grpcTokens, ","), grpc.HealthService))
+		}
+
+		grpcServer := grpc.NewServer(grpc.ServerOptions{
+			Interceptors: interceptors,
+			Reflection:   *grpcReflection,
+		})
+
+		storeServices := handlers.NewGRPC(logger).File()
+		grpcServer.Register(storeServices)
+
+		grpcHealth = grpc.NewHealth()
+		for _, svc := range storeServices.Services {
+			grpcHealth.SetServingStatus(svc.Name, grpc.StatusServing)
+		}
+		grpcServer.Register(grpcHealth.File())
+
+		opts.GRPCAddr = *grpcAddr
+		opts.GRPCHandler = middleware.Chain(grpcServer,
+			middleware.RequestID,
+			middleware.UserAuth(authenticateUser),
+			middleware.Actor,
+			middleware.Tracing,
+		)
+	}
+
+	if *listen != "" {
+		opts.Addrs = strings.Split(*listen, ",")
+	}
+
+	if *tlsCert != "" || *tlsKey != "" {
+		opts.TLS = &server.TLSOptions{
+			CertFile:     *tlsCert,
+			KeyFile:      *tlsKey,
+			ClientCAFile: *tlsClientCA,
+		}
+	}
+
+	srv, err := server.New(opts)
+	if err != nil {
+		logger.Error("failed to create server", "error", err)
+		os.Exit(1)
+	}
+
+	// anchor and close the audit log last, once the jobs making changes
+	// were stopped
+	srv.OnShutdown(func(ctx context.Context) error {
+		return auditLog.Close()
+	})
+
+	// stop receiving traffic as soon as the server starts shutting down
+	healthHandler.AddReadyCheck("server", func(ctx context.Context) error {
+		if srv.ShuttingDown() {
+			return fmt.Errorf("server is shutting down")
+		}
+		return nil
 	})
+
+	// end the event streams so they do not hold off the shutdown, then
+	// wait for the WebSocket ones to be closed
+	go func() {
+		<-srv.Draining()
+		eventsHandler.Shutdown()
+	}()
+	srv.OnShutdown(eventsHandler.Wait)
+
+	// report the gRPC services as not serving while draining
+	if grpcHealth != nil {
+		go func() {
+			<-srv.Draining()
+			grpcHealth.Shutdown()
+		}()
+	}
+
+	// queue the webhook deliveries of the changes while serving
+	srv.OnStart(func(ctx context.Context) error {
+		dispatcher.Start(events.Default())
+		return nil
+	})
+	srv.OnShutdown(dispatcher.Stop)
+
+	// run the queued jobs while serving. The scheduled jobs stop first,
+	// then the running queued jobs are given the drain timeout to end
+	// before they are cancelled and left in the queue for the next start
+	srv.OnStart(jobQueue.Start)
+	srv.OnShutdown(jobQueue.Stop)
+
+	// run the scheduled jobs while serving
+	srv.OnStart(jobs.Start)
+	srv.OnShutdown(jobs.Stop)
+
+	// flush the pending spans once requests were drained
+	srv.OnShutdown(tracer.Shutdown)
+
+	if err := srv.Start(context.Background()); err != nil {
+		logger.Error("failed to start server", "error", err)
+		os.Exit(1)
+	}
+
+	if err := srv.Wait(); err != nil {
+		logger.Error("server stopped", "error", err)
+		os.Exit(1)
+	}
+}
+
+// authenticateUser checks the credentials of a user of the store.
+func authenticateUser(username, password string) (middleware.User, bool) {
+	u, err := data.Authenticate(username, password)
+	if err != nil {
+		return middleware.User{}, false
+	}
+
+	return middleware.User{ID: u.ID, Username: u.Username}, true
 }
@@ -0,0 +1,48 @@
+package metrics
+
+import (
+	"net/http"
+	"os"
+	"runtime"
+	"time"
+)
+
+// contentType is the content type of the Prometheus text format.
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Handler returns an HTTP handler exposing the metrics of r.
+func Handler(r *Registry) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			http.Error(rw, "HTTP verb not implemented", http.StatusNotImplemented)
+			return
+		}
+
+		rw.Header().Set("Content-Type", contentType)
+		if err := r.WriteText(rw); err != nil {
+			http.Error(rw, "failed to write metrics", http.StatusInternalServerError)
+		}
+	})
+}
+
+// process and Go runtime metrics
+func init() {
+	startTime := float64(time.Now().Unix())
+
+	NewGaugeFunc("process_start_time_seconds",
+		"Start time of the process since unix epoch in seconds.",
+		func() float64 { return startTime })
+
+	NewGaugeFunc("process_pid", "Process ID of the API.",
+		func() float64 { return float64(os.Getpid()) })
+
+	NewGaugeFunc("go_goroutines", "Number of goroutines that currently exist.",
+		func() float64 { return float64(runtime.NumGoroutine()) })
+
+	NewGaugeFunc("go_memstats_alloc_bytes", "Number of bytes allocated and still in use.",
+		func() float64 {
+			var m runtime.MemStats
+			runtime.ReadMemStats(&m)
+			return float64(m.Alloc)
+		})
+}
@@ -0,0 +1,487 @@
+// Package metrics implements counters, gauges and histograms exposed in
+// the Prometheus text exposition format, without external dependencies.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefBuckets are the default histogram buckets, in seconds, tailored
+// to measure the latency of HTTP requests and store operations.
+var DefBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// metric types as named by the exposition format
+const (
+	typeCounter   = "counter"
+	typeGauge     = "gauge"
+	typeHistogram = "histogram"
+)
+
+// collector is implemented by every metric family held by a Registry.
+type collector interface {
+	desc() *desc
+	write(w *bufio.Writer, d *desc)
+}
+
+// desc describes a metric family.
+type desc struct {
+	name       string
+	help       string
+	typ        string
+	labelNames []string
+}
+
+// Registry holds metric families and writes them in the Prometheus text
+// format.
+type Registry struct {
+	mtx        sync.RWMutex
+	collectors map[string]collector
+}
+
+// Default is the registry used by the New* constructors.
+var Default = NewRegistry()
+
+// NewRegistry allocates an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{collectors: make(map[string]collector)}
+}
+
+// register adds c to the registry. It panics if a metric with the same
+// name was already registered, as that is always a programming error.
+func (r *Registry) register(c collector) {
+	d := c.desc()
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if _, exists := r.collectors[d.name]; exists {
+		panic(fmt.Sprintf("metrics: duplicate metric %q", d.name))
+	}
+	r.collectors[d.name] = c
+}
+
+// WriteText writes all metrics in the Prometheus text format (version
+// 0.0.4), sorted by name.
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mtx.RLock()
+	names := make([]string, 0, len(r.collectors))
+	for name := range r.collectors {
+		names = append(names, name)
+	}
+	collectors := make([]collector, 0, len(names))
+	sort.Strings(names)
+	for _, name := range names {
+		collectors = append(collectors, r.collectors[name])
+	}
+	r.mtx.RUnlock()
+
+	bw := bufio.NewWriter(w)
+	for _, c := range collectors {
+		d := c.desc()
+		fmt.Fprintf(bw, "# HELP %s %s\n", d.name, escapeHelp(d.help))
+		fmt.Fprintf(bw, "# TYPE %s %s\n", d.name, d.typ)
+		c.write(bw, d)
+	}
+
+	return bw.Flush()
+}
+
+// float64 values are stored as their bits so they can be updated
+// atomically.
+type atomicFloat struct {
+	bits uint64
+}
+
+func (f *atomicFloat) add(v float64) {
+	for {
+		old := atomic.LoadUint64(&f.bits)
+		updated := math.Float64bits(math.Float64frombits(old) + v)
+		if atomic.CompareAndSwapUint64(&f.bits, old, updated) {
+			return
+		}
+	}
+}
+
+func (f *atomicFloat) set(v float64) {
+	atomic.StoreUint64(&f.bits, math.Float64bits(v))
+}
+
+func (f *atomicFloat) load() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&f.bits))
+}
+
+// Counter is a monotonically increasing value.
+type Counter struct {
+	v atomicFloat
+}
+
+// Inc increments the counter by 1.
+func (c *Counter) Inc() {
+	c.v.add(1)
+}
+
+// Add increments the counter by v, which must not be negative.
+func (c *Counter) Add(v float64) {
+	if v < 0 {
+		panic("metrics: counter cannot decrease")
+	}
+	c.v.add(v)
+}
+
+// Value returns the current value of the counter.
+func (c *Counter) Value() float64 {
+	return c.v.load()
+}
+
+// Gauge is a value that can go up and down.
+type Gauge struct {
+	v atomicFloat
+}
+
+// Set sets the gauge to v.
+func (g *Gauge) Set(v float64) {
+	g.v.set(v)
+}
+
+// Inc increments the gauge by 1.
+func (g *Gauge) Inc() {
+	g.v.add(1)
+}
+
+// Dec decrements the gauge by 1.
+func (g *Gauge) Dec() {
+	g.v.add(-1)
+}
+
+// Add adds v (which may be negative) to the gauge.
+func (g *Gauge) Add(v float64) {
+	g.v.add(v)
+}
+
+// Value returns the current value of the gauge.
+func (g *Gauge) Value() float64 {
+	return g.v.load()
+}
+
+// Histogram counts observations in configurable buckets.
+type Histogram struct {
+	// count is accessed atomically and kept first for 64-bit alignment
+	count       uint64
+	sum         atomicFloat
+	upperBounds []float64
+	counts      []uint64
+}
+
+func newHistogram(buckets []float64) *Histogram {
+	bounds := append([]float64(nil), buckets...)
+	sort.Float64s(bounds)
+
+	return &Histogram{
+		upperBounds: bounds,
+		counts:      make([]uint64, len(bounds)),
+	}
+}
+
+// Observe adds a single observation to the histogram.
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.upperBounds, v)
+	if i < len(h.counts) {
+		atomic.AddUint64(&h.counts[i], 1)
+	}
+	atomic.AddUint64(&h.count, 1)
+	h.sum.add(v)
+}
+
+// vec holds the children of a metric family, one per combination of
+// label values.
+type vec struct {
+	d        *desc
+	mtx      sync.RWMutex
+	children map[string]interface{}
+	values   map[string][]string
+	newChild func() interface{}
+}
+
+func newVec(d *desc, newChild func() interface{}) *vec {
+	return &vec{
+		d:        d,
+		children: make(map[string]interface{}),
+		values:   make(map[string][]string),
+		newChild: newChild,
+	}
+}
+
+func (v *vec) desc() *desc {
+	return v.d
+}
+
+// with returns the child for the given label values, creating it on
+// first use.
+func (v *vec) with(lvs []string) interface{} {
+	if len(lvs) != len(v.d.labelNames) {
+		panic(fmt.Sprintf("metrics: %q expects %d label values, got %d",
+			v.d.name, len(v.d.labelNames), len(lvs)))
+	}
+
+	key := strings.Join(lvs, "\xff")
+
+	v.mtx.RLock()
+	child, ok := v.children[key]
+	v.mtx.RUnlock()
+	if ok {
+		return child
+	}
+
+	v.mtx.Lock()
+	defer v.mtx.Unlock()
+
+	if child, ok = v.children[key]; !ok {
+		child = v.newChild()
+		v.children[key] = child
+		v.values[key] = append([]string(nil), lvs...)
+	}
+
+	return child
+}
+
+// each calls fn for every child, sorted by label values.
+func (v *vec) each(fn func(lvs []string, child interface{})) {
+	v.mtx.RLock()
+	keys := make([]string, 0, len(v.children))
+	for k := range v.children {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	children := make([]interface{}, len(keys))
+	values := make([][]string, len(keys))
+	for i, k := range keys {
+		children[i] = v.children[k]
+		values[i] = v.values[k]
+	}
+	v.mtx.RUnlock()
+
+	for i := range children {
+		fn(values[i], children[i])
+	}
+}
+
+func (v *vec) write(w *bufio.Writer, d *desc) {
+	v.each(func(lvs []string, child interface{}) {
+		switch c := child.(type) {
+		case *Counter:
+			writeSample(w, d.name, d.labelNames, lvs, "", "", c.Value())
+		case *Gauge:
+			writeSample(w, d.name, d.labelNames, lvs, "", "", c.Value())
+		case *Histogram:
+			writeHistogram(w, d, lvs, c)
+		}
+	})
+}
+
+// CounterVec is a family of counters partitioned by labels.
+type CounterVec struct {
+	*vec
+}
+
+// WithLabelValues returns the counter for the given label values.
+func (cv *CounterVec) WithLabelValues(lvs ...string) *Counter {
+	return cv.with(lvs).(*Counter)
+}
+
+// GaugeVec is a family of gauges partitioned by labels.
+type GaugeVec struct {
+	*vec
+}
+
+// WithLabelValues returns the gauge for the given label values.
+func (gv *GaugeVec) WithLabelValues(lvs ...string) *Gauge {
+	return gv.with(lvs).(*Gauge)
+}
+
+// HistogramVec is a family of histograms partitioned by labels.
+type HistogramVec struct {
+	*vec
+}
+
+// WithLabelValues returns the histogram for the given label values.
+func (hv *HistogramVec) WithLabelValues(lvs ...string) *Histogram {
+	return hv.with(lvs).(*Histogram)
+}
+
+// gaugeFunc is a gauge whose value is computed at collection time.
+type gaugeFunc struct {
+	d  *desc
+	fn func() float64
+}
+
+func (g *gaugeFunc) desc() *desc {
+	return g.d
+}
+
+func (g *gaugeFunc) write(w *bufio.Writer, d *desc) {
+	writeSample(w, d.name, nil, nil, "", "", g.fn())
+}
+
+// NewCounter registers a counter without labels in r.
+func (r *Registry) NewCounter(name, help string) *Counter {
+	return r.NewCounterVec(name, help).WithLabelValues()
+}
+
+// NewCounterVec registers a counter family in r.
+func (r *Registry) NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	v := newVec(&desc{name, help, typeCounter, labelNames},
+		func() interface{} { return &Counter{} })
+	r.register(v)
+
+	return &CounterVec{v}
+}
+
+// NewGauge registers a gauge without labels in r.
+func (r *Registry) NewGauge(name, help string) *Gauge {
+	return r.NewGaugeVec(name, help).WithLabelValues()
+}
+
+// NewGaugeVec registers a gauge family in r.
+func (r *Registry) NewGaugeVec(name, help string, labelNames ...string) *GaugeVec {
+	v := newVec(&desc{name, help, typeGauge, labelNames},
+		func() interface{} { return &Gauge{} })
+	r.register(v)
+
+	return &GaugeVec{v}
+}
+
+// NewGaugeFunc registers a gauge whose value is returned by fn each time
+// the metrics are collected.
+func (r *Registry) NewGaugeFunc(name, help string, fn func() float64) {
+	r.register(&gaugeFunc{&desc{name, help, typeGauge, nil}, fn})
+}
+
+// NewHistogram registers a histogram without labels in r.
+func (r *Registry) NewHistogram(name, help string, buckets []float64) *Histogram {
+	return r.NewHistogramVec(name, help, buckets).WithLabelValues()
+}
+
+// NewHistogramVec registers a histogram family in r. DefBuckets are used
+// when buckets is nil.
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefBuckets
+	}
+
+	v := newVec(&desc{name, help, typeHistogram, labelNames},
+		func() interface{} { return newHistogram(buckets) })
+	r.register(v)
+
+	return &HistogramVec{v}
+}
+
+// NewCounter registers a counter in the Default registry.
+func NewCounter(name, help string) *Counter {
+	return Default.NewCounter(name, help)
+}
+
+// NewCounterVec registers a counter family in the Default registry.
+func NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	return Default.NewCounterVec(name, help, labelNames...)
+}
+
+// NewGauge registers a gauge in the Default registry.
+func NewGauge(name, help string) *Gauge {
+	return Default.NewGauge(name, help)
+}
+
+// NewGaugeVec registers a gauge family in the Default registry.
+func NewGaugeVec(name, help string, labelNames ...string) *GaugeVec {
+	return Default.NewGaugeVec(name, help, labelNames...)
+}
+
+// NewGaugeFunc registers a computed gauge in the Default registry.
+func NewGaugeFunc(name, help string, fn func() float64) {
+	Default.NewGaugeFunc(name, help, fn)
+}
+
+// NewHistogram registers a histogram in the Default registry.
+func NewHistogram(name, help string, buckets []float64) *Histogram {
+	return Default.NewHistogram(name, help, buckets)
+}
+
+// NewHistogramVec registers a histogram family in the Default registry.
+func NewHistogramVec(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	return Default.NewHistogramVec(name, help, buckets, labelNames...)
+}
+
+func writeHistogram(w *bufio.Writer, d *desc, lvs []string, h *Histogram) {
+	var cumulative uint64
+	for i, bound := range h.upperBounds {
+		cumulative += atomic.LoadUint64(&h.counts[i])
+		writeSample(w, d.name+"_bucket", d.labelNames, lvs, "le", formatFloat(bound), float64(cumulative))
+	}
+
+	count := atomic.LoadUint64(&h.count)
+	writeSample(w, d.name+"_bucket", d.labelNames, lvs, "le", "+Inf", float64(count))
+	writeSample(w, d.name+"_sum", d.labelNames, lvs, "", "", h.sum.load())
+	writeSample(w, d.name+"_count", d.labelNames, lvs, "", "", float64(count))
+}
+
+// writeSample writes a single sample line. extraName and extraValue add
+// one more label (used for the histogram "le" label).
+func writeSample(w *bufio.Writer, name string, labelNames, lvs []string, extraName, extraValue string, v float64) {
+	w.WriteString(name)
+
+	if len(labelNames) > 0 || extraName != "" {
+		w.WriteByte('{')
+		for i, ln := range labelNames {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", ln, escapeLabel(lvs[i]))
+		}
+
+		if extraName != "" {
+			if len(labelNames) > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", extraName, extraValue)
+		}
+		w.WriteByte('}')
+	}
+
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(v))
+	w.WriteByte('\n')
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+)
+
+// AnonymousActor is the actor of the requests without an authenticated
+// user.
+const AnonymousActor = "anonymous"
+
+type actorKey struct{}
+
+// ActorFromContext returns the actor of the request being served, or
+// an empty string outside of requests.
+func ActorFromContext(ctx context.Context) string {
+	actor, _ := ctx.Value(actorKey{}).(string)
+	return actor
+}
+
+// ContextWithActor returns a copy of ctx carrying actor, e.g. to make
+// changes on behalf of a request after it was served.
+func ContextWithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// Actor attaches the actor of the requests, recorded on the changes
+// they make in the trash and the audit log: the username of the user
+// authenticated by UserAuth, which must run before it. Since the actor
+// is only taken from the authentication, it cannot be claimed by the
+// clients.
+func Actor(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		actor := AnonymousActor
+		if u, ok := UserFromContext(r.Context()); ok {
+			actor = u.Username
+		}
+
+		ctx := context.WithValue(r.Context(), actorKey{}, actor)
+		next.ServeHTTP(rw, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// BearerAuth rejects the requests without an "Authorization: Bearer
+// <token>" header carrying one of tokens with 401. It lets every
+// request through when tokens is empty, e.g. during development.
+func BearerAuth(tokens []string) Middleware {
+	return func(next http.Handler) http.Handler {
+		if len(tokens) == 0 {
+			return next
+		}
+
+		return RequireBearerAuth(tokens)(next)
+	}
+}
+
+// RequireBearerAuth is BearerAuth rejecting every request when tokens
+// is empty, for the endpoints never open, e.g. the audit log.
+func RequireBearerAuth(tokens []string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			auth := r.Header.Get("Authorization")
+			if len(auth) < len("Bearer ") || !strings.EqualFold(auth[:len("Bearer ")], "Bearer ") {
+				rw.Header().Set("WWW-Authenticate", `Bearer realm="store-api"`)
+				http.Error(rw, "missing bearer token", http.StatusUnauthorized)
+				return
+			}
+
+			token := []byte(strings.TrimSpace(auth[len("Bearer "):]))
+			for _, t := range tokens {
+				if subtle.ConstantTimeCompare(token, []byte(t)) == 1 {
+					next.ServeHTTP(rw, r)
+					return
+				}
+			}
+
+			rw.Header().Set("WWW-Authenticate", `Bearer realm="store-api", error="invalid_token"`)
+			http.Error(rw, "invalid bearer token", http.StatusUnauthorized)
+		})
+	}
+}
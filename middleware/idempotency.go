@@ -0,0 +1,327 @@
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// IdempotencyKeyHeader is the header clients set on POST requests to
+// make their retries safe.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// IdempotentReplayedHeader is set on the responses replayed for a key
+// that was already used.
+const IdempotentReplayedHeader = "Idempotent-Replayed"
+
+const (
+	// DefaultIdempotencyTTL is the default time keys are remembered.
+	DefaultIdempotencyTTL = 24 * time.Hour
+
+	// DefaultIdempotencyMaxBody is the default size limit of the bodies
+	// of the requests and responses of idempotent requests.
+	DefaultIdempotencyMaxBody = 1 << 20
+
+	// maxIdempotencyKeyLen bounds the length of the keys.
+	maxIdempotencyKeyLen = 255
+
+	// idempotencySweepInterval is how often expired keys are dropped.
+	idempotencySweepInterval = time.Minute
+)
+
+// IdempotencyOptions configures the Idempotency middleware.
+type IdempotencyOptions struct {
+	// TTL is the time a key is remembered after its first use,
+	// DefaultIdempotencyTTL if zero.
+	TTL time.Duration
+
+	// MaxBodySize limits the bodies of the requests with a key, which
+	// are answered with 413 beyond it, and of the responses stored,
+	// DefaultIdempotencyMaxBody if zero.
+	MaxBodySize int64
+}
+
+// idempotentResponse is a response stored to be replayed.
+type idempotentResponse struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+// idempotencyEntry is the use of a key. done is closed once the first
+// request with the key was served: resp then holds its response, or is
+// nil if it was not stored and the key can be used again.
+type idempotencyEntry struct {
+	fingerprint [sha256.Size]byte
+	expires     time.Time
+	done        chan struct{}
+	resp        *idempotentResponse
+}
+
+// idempotencyStore remembers the keys in memory.
+type idempotencyStore struct {
+	mtx       sync.Mutex
+	ttl       time.Duration
+	entries   map[string]*idempotencyEntry
+	nextSweep time.Time
+}
+
+// acquire returns the entry of key, creating it when the key is new or
+// expired, in which case created is true and the caller must call
+// complete once the request was served.
+func (s *idempotencyStore) acquire(key string, fingerprint [sha256.Size]byte) (e *idempotencyEntry, created bool) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	now := time.Now()
+	if now.After(s.nextSweep) {
+		for k, e := range s.entries {
+			if e.resp != nil && now.After(e.expires) {
+				delete(s.entries, k)
+			}
+		}
+		s.nextSweep = now.Add(idempotencySweepInterval)
+	}
+
+	if e, ok := s.entries[key]; ok && (e.resp == nil || now.Before(e.expires)) {
+		return e, false
+	}
+
+	e = &idempotencyEntry{
+		fingerprint: fingerprint,
+		expires:     now.Add(s.ttl),
+		done:        make(chan struct{}),
+	}
+	s.entries[key] = e
+
+	return e, true
+}
+
+// complete stores the response of the request that created e, or
+// forgets the key when resp is nil.
+func (s *idempotencyStore) complete(key string, e *idempotencyEntry, resp *idempotentResponse) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	e.resp = resp
+	if resp == nil && s.entries[key] == e {
+		delete(s.entries, key)
+	}
+	close(e.done)
+}
+
+// idempotencyScope returns the scope of the keys of r: the keys of a
+// client are only matched against its own requests to the same method
+// and path, so that a client cannot replay the response served to
+// another. Clients are told apart by the user authenticated by UserAuth,
+// which must run before Idempotency, or else by a hash of their
+// Authorization header; the anonymous requests share a scope.
+func idempotencyScope(r *http.Request) string {
+	client := "anonymous"
+	if u, ok := UserFromContext(r.Context()); ok {
+		client = fmt.Sprintf("user:%d", u.ID)
+	} else if auth := r.Header.Get("Authorization"); auth != "" {
+		client = fmt.Sprintf("credential:%x", sha256.Sum256([]byte(auth)))
+	}
+
+	return client + " " + r.Method + " " + r.URL.Path
+}
+
+// Idempotency makes POST requests carrying an Idempotency-Key header
+// safe to retry: the response of the first request with a key is
+// stored with a fingerprint of the request, and returned to the
+// requests reusing the key until it expires. Keys are scoped by client,
+// method and path (see idempotencyScope). A key reused for another
+// request is rejected with 422, and a retry arriving while the first
+// request is served waits for its response.
+//
+// Server errors (5xx) are not stored, so requests failing on the server
+// side can be retried with the same key.
+func Idempotency(opts IdempotencyOptions) Middleware {
+	if opts.TTL <= 0 {
+		opts.TTL = DefaultIdempotencyTTL
+	}
+	if opts.MaxBodySize <= 0 {
+		opts.MaxBodySize = DefaultIdempotencyMaxBody
+	}
+
+	store := &idempotencyStore{
+		ttl:     opts.TTL,
+		entries: map[string]*idempotencyEntry{},
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(IdempotencyKeyHeader)
+			if r.Method != http.MethodPost || key == "" {
+				next.ServeHTTP(rw, r)
+				return
+			}
+
+			if len(key) > maxIdempotencyKeyLen {
+				http.Error(rw, fmt.Sprintf("%s must be at most %d characters", IdempotencyKeyHeader, maxIdempotencyKeyLen), http.StatusBadRequest)
+				return
+			}
+
+			body, err := io.ReadAll(io.LimitReader(r.Body, opts.MaxBodySize+1))
+			if err != nil {
+				http.Error(rw, "failed to read request body", http.StatusBadRequest)
+				return
+			}
+			if int64(len(body)) > opts.MaxBodySize {
+				http.Error(rw, fmt.Sprintf("requests with an %s are limited to %d bytes", IdempotencyKeyHeader, opts.MaxBodySize), http.StatusRequestEntityTooLarge)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			scoped := idempotencyScope(r) + " " + key
+
+			// the same key may not be used for another request
+			h := sha256.New()
+			fmt.Fprintf(h, "%s %s?%s\n", r.Method, r.URL.Path, r.URL.RawQuery)
+			h.Write(body)
+			var fingerprint [sha256.Size]byte
+			copy(fingerprint[:], h.Sum(nil))
+
+			for {
+				e, created := store.acquire(scoped, fingerprint)
+				if created {
+					serveIdempotent(next, rw, r, opts.MaxBodySize, func(resp *idempotentResponse) {
+						store.complete(scoped, e, resp)
+					})
+					return
+				}
+
+				if e.fingerprint != fingerprint {
+					http.Error(rw, fmt.Sprintf("%s was already used for another request", IdempotencyKeyHeader), http.StatusUnprocessableEntity)
+					return
+				}
+
+				select {
+				case <-e.done:
+				case <-r.Context().Done():
+					return
+				}
+
+				if e.resp != nil {
+					idempotentReplays.Inc()
+					replay(rw, e.resp)
+					return
+				}
+
+				// the first request failed, serve this one instead
+			}
+		})
+	}
+}
+
+// serveIdempotent serves r, recording its response for complete. The
+// response is not stored if it is a server error, larger than maxBody,
+// or the handler panics.
+func serveIdempotent(next http.Handler, rw http.ResponseWriter, r *http.Request, maxBody int64, complete func(*idempotentResponse)) {
+	rec := &recordingWriter{ResponseWriter: rw, max: maxBody}
+
+	stored := false
+	defer func() {
+		if !stored {
+			complete(nil)
+		}
+	}()
+
+	next.ServeHTTP(rec, r)
+
+	if rec.status >= http.StatusInternalServerError || rec.overflow {
+		return
+	}
+
+	status := rec.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	header := rec.header
+	if header == nil {
+		header = rw.Header().Clone()
+	}
+	header.Del(RequestIDHeader)
+
+	complete(&idempotentResponse{status: status, header: header, body: rec.body.Bytes()})
+	stored = true
+}
+
+// replay writes a stored response.
+func replay(rw http.ResponseWriter, resp *idempotentResponse) {
+	for k, v := range resp.header {
+		rw.Header()[k] = append([]string(nil), v...)
+	}
+	rw.Header().Set(IdempotentReplayedHeader, "true")
+
+	rw.WriteHeader(resp.status)
+	rw.Write(resp.body)
+}
+
+// recordingWriter records the response written through it, up to max
+// bytes of body.
+type recordingWriter struct {
+	http.ResponseWriter
+	max int64
+
+	status   int
+	header   http.Header // the headers when the response was sent
+	body     bytes.Buffer
+	overflow bool
+}
+
+func (w *recordingWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+		w.header = w.ResponseWriter.Header().Clone()
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	if !w.overflow {
+		if int64(w.body.Len()+len(b)) > w.max {
+			w.overflow = true
+			w.body.Reset()
+		} else {
+			w.body.Write(b)
+		}
+	}
+
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush allows streaming handlers to flush through the recorder.
+func (w *recordingWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack takes over the connection; the response is then not stored.
+func (w *recordingWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer does not support hijacking")
+	}
+
+	w.overflow = true
+	return h.Hijack()
+}
+
+// Unwrap returns the wrapped response writer for http.ResponseController.
+func (w *recordingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,295 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// counter is a handler creating a resource per request, answering with
+// its number. The requests to /fail fail once, and the ones to /big
+// get a response of 64 bytes.
+type counter struct {
+	mtx    sync.Mutex
+	n      int
+	failed bool
+}
+
+func (c *counter) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	c.mtx.Lock()
+	c.n++
+	n := c.n
+	fail := r.URL.Path == "/fail" && !c.failed
+	c.failed = c.failed || fail
+	c.mtx.Unlock()
+
+	if fail {
+		http.Error(rw, "failed", http.StatusServiceUnavailable)
+		return
+	}
+
+	rw.Header().Set(RequestIDHeader, fmt.Sprint("request-", n))
+	rw.Header().Set("Location", fmt.Sprint("/products/", n))
+	rw.WriteHeader(http.StatusCreated)
+	if r.URL.Path == "/big" {
+		fmt.Fprint(rw, strings.Repeat("x", 64))
+		return
+	}
+	fmt.Fprintf(rw, "%d %s", n, body)
+}
+
+// request is a request of a test of the idempotency, and its expected
+// response.
+type request struct {
+	method, url, key, body string
+
+	// auth is the Authorization header, and user the ID of the user
+	// authenticated, if any
+	auth string
+	user uint64
+
+	// status and body of the response, and whether it is replayed
+	status   int
+	want     string
+	replayed bool
+}
+
+func (req request) do(h http.Handler) *httptest.ResponseRecorder {
+	method := req.method
+	if method == "" {
+		method = http.MethodPost
+	}
+
+	r := httptest.NewRequest(method, req.url, strings.NewReader(req.body))
+	if req.key != "" {
+		r.Header.Set(IdempotencyKeyHeader, req.key)
+	}
+	if req.auth != "" {
+		r.Header.Set("Authorization", req.auth)
+	}
+	if req.user != 0 {
+		r = r.WithContext(context.WithValue(r.Context(), userKey{}, User{ID: req.user}))
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	return rec
+}
+
+func (req request) check(t *testing.T, rec *httptest.ResponseRecorder) {
+	t.Helper()
+
+	if rec.Code != req.status {
+		t.Errorf("%s %s with key %q: got status %d, want %d", req.method, req.url, req.key, rec.Code, req.status)
+	}
+	if req.want != "" && rec.Body.String() != req.want {
+		t.Errorf("%s %s with key %q: got body %q, want %q", req.method, req.url, req.key, rec.Body.String(), req.want)
+	}
+	if replayed := rec.Header().Get(IdempotentReplayedHeader) == "true"; replayed != req.replayed {
+		t.Errorf("%s %s with key %q: got replayed %t, want %t", req.method, req.url, req.key, replayed, req.replayed)
+	}
+}
+
+func TestIdempotency(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     IdempotencyOptions
+		requests []request
+	}{
+		{
+			name: "replayed",
+			requests: []request{
+				{url: "/products", key: "a", body: "x", status: 201, want: "1 x"},
+				{url: "/products", key: "a", body: "x", status: 201, want: "1 x", replayed: true},
+				{url: "/products", key: "b", body: "x", status: 201, want: "2 x"},
+			},
+		},
+		{
+			name: "without a key",
+			requests: []request{
+				{url: "/products", body: "x", status: 201, want: "1 x"},
+				{url: "/products", body: "x", status: 201, want: "2 x"},
+			},
+		},
+		{
+			name: "only POST requests",
+			requests: []request{
+				{method: http.MethodPut, url: "/products/1", key: "a", body: "x", status: 201, want: "1 x"},
+				{method: http.MethodPut, url: "/products/1", key: "a", body: "x", status: 201, want: "2 x"},
+			},
+		},
+		{
+			name: "reused for another body",
+			requests: []request{
+				{url: "/products", key: "a", body: "x", status: 201, want: "1 x"},
+				{url: "/products", key: "a", body: "y", status: 422},
+			},
+		},
+		{
+			name: "reused for another query",
+			requests: []request{
+				{url: "/products?dry_run=true", key: "a", body: "x", status: 201, want: "1 x"},
+				{url: "/products", key: "a", body: "x", status: 422},
+			},
+		},
+		{
+			name: "scoped by path",
+			requests: []request{
+				{url: "/products", key: "a", body: "x", status: 201, want: "1 x"},
+				{url: "/carts", key: "a", body: "x", status: 201, want: "2 x"},
+				{url: "/carts", key: "a", body: "x", status: 201, want: "2 x", replayed: true},
+			},
+		},
+		{
+			name: "scoped by credentials",
+			requests: []request{
+				{url: "/products", key: "a", body: "x", status: 201, want: "1 x"},
+				{url: "/products", key: "a", body: "x", auth: "Bearer one", status: 201, want: "2 x"},
+				{url: "/products", key: "a", body: "x", auth: "Bearer two", status: 201, want: "3 x"},
+				{url: "/products", key: "a", body: "x", auth: "Bearer one", status: 201, want: "2 x", replayed: true},
+			},
+		},
+		{
+			name: "scoped by user",
+			requests: []request{
+				{url: "/products", key: "a", body: "x", auth: "Basic one", user: 1, status: 201, want: "1 x"},
+				{url: "/products", key: "a", body: "x", auth: "Basic two", user: 2, status: 201, want: "2 x"},
+				// the same user with other credentials
+				{url: "/products", key: "a", body: "x", auth: "Bearer three", user: 1, status: 201, want: "1 x", replayed: true},
+			},
+		},
+		{
+			name: "server errors are not stored",
+			requests: []request{
+				{url: "/fail", key: "a", body: "x", status: 503},
+				{url: "/fail", key: "a", body: "x", status: 201, want: "2 x"},
+				{url: "/fail", key: "a", body: "x", status: 201, want: "2 x", replayed: true},
+			},
+		},
+		{
+			name: "large responses are not stored",
+			opts: IdempotencyOptions{MaxBodySize: 16},
+			requests: []request{
+				{url: "/big", key: "a", body: "x", status: 201, want: strings.Repeat("x", 64)},
+				{url: "/big", key: "a", body: "x", status: 201, want: strings.Repeat("x", 64)},
+			},
+		},
+		{
+			name: "large requests are rejected",
+			opts: IdempotencyOptions{MaxBodySize: 16},
+			requests: []request{
+				{url: "/products", key: "a", body: strings.Repeat("x", 17), status: 413},
+				{url: "/products", key: "a", body: strings.Repeat("x", 16), status: 201, want: "1 " + strings.Repeat("x", 16)},
+			},
+		},
+		{
+			name: "long keys are rejected",
+			requests: []request{
+				{url: "/products", key: strings.Repeat("k", 256), body: "x", status: 400},
+				{url: "/products", key: strings.Repeat("k", 255), body: "x", status: 201, want: "1 x"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := Idempotency(tt.opts)(&counter{})
+			for _, req := range tt.requests {
+				req.check(t, req.do(h))
+			}
+		})
+	}
+}
+
+// TestIdempotencyReplayedHeaders checks that the replayed responses have
+// the headers of the first one, but their own request ID.
+func TestIdempotencyReplayedHeaders(t *testing.T) {
+	h := Idempotency(IdempotencyOptions{})(&counter{})
+	req := request{url: "/products", key: "a", body: "x"}
+
+	first := req.do(h)
+	replayed := req.do(h)
+
+	if got := replayed.Header().Get("Location"); got != first.Header().Get("Location") {
+		t.Errorf("got Location %q, want %q", got, first.Header().Get("Location"))
+	}
+	if got := replayed.Header().Get(RequestIDHeader); got != "" {
+		t.Errorf("got the request ID %q of the first request", got)
+	}
+}
+
+func TestIdempotencyExpiry(t *testing.T) {
+	h := Idempotency(IdempotencyOptions{TTL: 20 * time.Millisecond})(&counter{})
+
+	first := request{url: "/products", key: "a", body: "x", status: 201, want: "1 x"}
+	first.check(t, first.do(h))
+	time.Sleep(30 * time.Millisecond)
+
+	// an expired key can be used again, for any request
+	again := request{url: "/products", key: "a", body: "y", status: 201, want: "2 y"}
+	again.check(t, again.do(h))
+}
+
+// TestIdempotencyConcurrentRetry checks that a retry arriving while the
+// first request is served waits for its response, and is served itself
+// when the first request fails.
+func TestIdempotencyConcurrentRetry(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		want   request
+	}{
+		{"replayed", http.StatusCreated, request{status: 201, want: "first", replayed: true}},
+		{"served after a failure", http.StatusInternalServerError, request{status: 201, want: "retry"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			started := make(chan struct{})
+			release := make(chan struct{})
+			calls := 0
+
+			h := Idempotency(IdempotencyOptions{})(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+				calls++
+				if calls == 1 {
+					close(started)
+					<-release
+					rw.WriteHeader(tt.status)
+					fmt.Fprint(rw, "first")
+					return
+				}
+				rw.WriteHeader(http.StatusCreated)
+				fmt.Fprint(rw, "retry")
+			}))
+
+			req := request{url: "/products", key: "a", body: "x"}
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				req.do(h)
+			}()
+			<-started
+
+			retried := make(chan *httptest.ResponseRecorder)
+			go func() { retried <- req.do(h) }()
+
+			select {
+			case <-retried:
+				t.Fatal("the retry was answered before the first request")
+			case <-time.After(20 * time.Millisecond):
+			}
+
+			close(release)
+			<-done
+			tt.want.check(t, <-retried)
+		})
+	}
+}
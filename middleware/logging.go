@@ -0,0 +1,148 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/imariom/products-api/logging"
+	"github.com/imariom/products-api/tracing"
+)
+
+// RequestIDHeader is the header used to receive and return request IDs.
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLen bounds the length of request IDs accepted from clients.
+const maxRequestIDLen = 128
+
+type requestIDKey struct{}
+
+// RequestIDFromContext returns the ID of the request being served.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// ContextWithRequestID returns a copy of ctx carrying the request ID
+// id, e.g. to make changes on behalf of a request after it was served.
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID propagates the X-Request-ID header of the request, or
+// generates a new ID if it is missing or invalid, and returns it in the
+// response.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+
+		rw.Header().Set(RequestIDHeader, id)
+
+		ctx := context.WithValue(r.Context(), requestIDKey{}, id)
+		next.ServeHTTP(rw, r.WithContext(ctx))
+	})
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+
+	return hex.EncodeToString(b)
+}
+
+// validRequestID only accepts short, printable IDs so clients cannot
+// inject anything into logs.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Logging attaches to every request a logger carrying its request ID
+// (see logging.FromContext) and writes an access log line once the
+// request was served.
+func Logging(logger *logging.Logger) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+
+			reqLogger := logger
+			if id := RequestIDFromContext(r.Context()); id != "" {
+				reqLogger = reqLogger.With("request_id", id)
+			}
+
+			if sc := tracing.SpanContextFromContext(r.Context()); sc.IsValid() {
+				reqLogger = reqLogger.With("trace_id", sc.TraceID.String())
+			}
+
+			w := newResponseWriter(rw)
+			next.ServeHTTP(w, r.WithContext(logging.NewContext(r.Context(), reqLogger)))
+
+			kv := []interface{}{
+				"method", r.Method,
+				"route", RouteTemplate(r.URL.Path),
+				"path", r.URL.Path,
+				"status", w.Status(),
+				"bytes", w.bytes,
+				"duration", time.Since(start),
+				"remote", r.RemoteAddr,
+			}
+
+			if r.URL.RawQuery != "" {
+				kv = append(kv, "query", redactQuery(r.URL.Query()))
+			}
+
+			if ua := r.UserAgent(); ua != "" {
+				kv = append(kv, "user_agent", ua)
+			}
+
+			if w.Status() >= http.StatusInternalServerError {
+				reqLogger.Error("request served", kv...)
+			} else {
+				reqLogger.Info("request served", kv...)
+			}
+		})
+	}
+}
+
+// redactQuery encodes the query string hiding sensitive parameters.
+func redactQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		for _, v := range q[k] {
+			if logging.IsSensitive(k) {
+				v = logging.Redacted
+			} else {
+				v = url.QueryEscape(v)
+			}
+			parts = append(parts, url.QueryEscape(k)+"="+v)
+		}
+	}
+
+	return strings.Join(parts, "&")
+}
@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/imariom/products-api/metrics"
+)
+
+var (
+	httpRequests = metrics.NewCounterVec("http_requests_total",
+		"Number of HTTP requests by method, route and status.",
+		"method", "route", "status")
+
+	httpDuration = metrics.NewHistogramVec("http_request_duration_seconds",
+		"Latency of HTTP requests by method, route and status.",
+		nil, "method", "route", "status")
+
+	httpInFlight = metrics.NewGauge("http_requests_in_flight",
+		"Number of HTTP requests currently being served.")
+
+	idempotentReplays = metrics.NewCounter("http_idempotent_replays_total",
+		"Number of responses replayed for a reused Idempotency-Key.")
+)
+
+// Metrics records the count and latency of every request by route (see
+// RouteTemplate and SetRoutes), and the number of requests in flight.
+func Metrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		httpInFlight.Inc()
+		defer httpInFlight.Dec()
+
+		start := time.Now()
+		w := newResponseWriter(rw)
+		next.ServeHTTP(w, r)
+
+		route := RouteTemplate(r.URL.Path)
+		status := strconv.Itoa(w.Status())
+
+		// without the routes set, unknown paths would create a new
+		// series per path requested
+		if !routesSet() && w.Status() == http.StatusNotFound && !strings.HasSuffix(route, "{id}") {
+			route = UnmatchedRoute
+		}
+
+		httpRequests.WithLabelValues(r.Method, route, status).Inc()
+		httpDuration.WithLabelValues(r.Method, route, status).
+			Observe(time.Since(start).Seconds())
+	})
+}
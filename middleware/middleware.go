@@ -0,0 +1,217 @@
+// Package middleware contains the HTTP middlewares shared by every
+// handler of the API.
+package middleware
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Middleware wraps an http.Handler with additional behaviour.
+type Middleware func(http.Handler) http.Handler
+
+// Chain applies the middlewares to h so the first one is the outermost.
+func Chain(h http.Handler, mws ...Middleware) http.Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+
+	return h
+}
+
+// responseWriter records the status code and the number of bytes
+// written by the wrapped handler.
+type responseWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func newResponseWriter(rw http.ResponseWriter) *responseWriter {
+	return &responseWriter{ResponseWriter: rw}
+}
+
+func (w *responseWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += n
+	return n, err
+}
+
+// Status returns the status code sent to the client.
+func (w *responseWriter) Status() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+
+	return w.status
+}
+
+// Flush allows streaming handlers to flush through the wrapper.
+func (w *responseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack allows handlers that take over the connection (e.g. WebSocket
+// upgrades) to work through the wrapper.
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer does not support hijacking")
+	}
+
+	if w.status == 0 {
+		w.status = http.StatusSwitchingProtocols
+	}
+
+	return h.Hijack()
+}
+
+// Unwrap returns the wrapped response writer, so http.ResponseController
+// can reach it (e.g. to clear the write deadline of long-lived streams).
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// idSegmentRe matches path segments that identify a single record.
+var idSegmentRe = regexp.MustCompile(`^\d+$`)
+
+// versionSegmentRe matches the version prefix of versioned routes.
+var versionSegmentRe = regexp.MustCompile(`^v\d+$`)
+
+// UnmatchedRoute is the route template of the paths matching none of
+// the routes set with SetRoutes.
+const UnmatchedRoute = "unmatched"
+
+// route is a route template set with SetRoutes, matched by re. Its
+// weight is the number of literal characters of the template, so the
+// most specific route wins, e.g. /carts/guest over /carts/{id}.
+type route struct {
+	template string
+	re       *regexp.Regexp
+	weight   int
+}
+
+var (
+	routesMtx sync.RWMutex
+	routes    []route
+)
+
+// placeholderRe matches the parameters of route templates, e.g. {id}.
+var placeholderRe = regexp.MustCompile(`\{[^/{}]+\}`)
+
+// SetRoutes sets the templates of the routes served, e.g. /products/{id}
+// or /admin/queue/{id}:retry, a parameter matching any part of a path
+// segment. RouteTemplate then returns the template a path matches, or
+// UnmatchedRoute, so the number of routes reported is bounded whatever
+// the paths requested. It is meant to be called once, before serving;
+// nil unsets the routes.
+func SetRoutes(templates []string) {
+	var rs []route
+	if templates != nil {
+		rs = make([]route, 0, len(templates))
+	}
+	for _, t := range templates {
+		literals := placeholderRe.Split(t, -1)
+		for i, l := range literals {
+			literals[i] = regexp.QuoteMeta(l)
+		}
+
+		rs = append(rs, route{
+			template: t,
+			re:       regexp.MustCompile("^" + strings.Join(literals, "[^/]+") + "/?$"),
+			weight:   len(placeholderRe.ReplaceAllString(t, "")),
+		})
+	}
+
+	routesMtx.Lock()
+	routes = rs
+	routesMtx.Unlock()
+}
+
+// matchRoute returns the most specific route matching path, if routes
+// were set.
+func matchRoute(path string) (template string, ok bool) {
+	routesMtx.RLock()
+	defer routesMtx.RUnlock()
+
+	if routes == nil {
+		return "", false
+	}
+
+	best := -1
+	for i, r := range routes {
+		if r.re.MatchString(path) && (best < 0 || r.weight > routes[best].weight) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return UnmatchedRoute, true
+	}
+
+	return routes[best].template, true
+}
+
+// RouteTemplate returns the route template of a request path so it can
+// be used as a low cardinality label, e.g. "/carts/user/{id}": the
+// route matched when the routes were set with SetRoutes, otherwise the
+// path with its record IDs and query-like segments replaced with
+// placeholders.
+func RouteTemplate(path string) string {
+	if template, ok := matchRoute(path); ok {
+		return template
+	}
+
+	if path == "" || path == "/" {
+		return "/"
+	}
+
+	segments := strings.Split(strings.TrimSuffix(path, "/"), "/")
+
+	// the version prefix of versioned routes (/v2/products) is kept
+	base := 1
+	if len(segments) > 2 && versionSegmentRe.MatchString(segments[1]) {
+		base = 2
+	}
+
+	for i, seg := range segments {
+		switch {
+		case idSegmentRe.MatchString(seg):
+			segments[i] = "{id}"
+
+		case strings.Contains(seg, "="):
+			// e.g. /carts/startdate=2022-01-01&enddate=2022-02-01
+			segments[i] = "{filter}"
+
+		case i == base+2 && segments[base] == "products" && segments[base+1] == "categories":
+			segments[i] = "{category}"
+		}
+	}
+
+	return strings.Join(segments, "/")
+}
+
+// routesSet reports whether the routes were set with SetRoutes.
+func routesSet() bool {
+	routesMtx.RLock()
+	defer routesMtx.RUnlock()
+
+	return routes != nil
+}
@@ -0,0 +1,70 @@
+package middleware
+
+import "testing"
+
+func TestRouteTemplate(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/products", "/products"},
+		{"/products/", "/products"},
+		{"/products/12", "/products/{id}"},
+		{"/products/abc", "/products/{id}"},
+		{"/products/12/history", "/products/{id}/history"},
+		{"/products/categories", "/products/categories"},
+		{"/products/categories/tools", "/products/categories/{category}"},
+		{"/carts/guest", "/carts/guest"},
+		{"/carts/guest:merge", "/carts/guest:merge"},
+		{"/carts/startdate=2022-01-01&enddate=2022-02-01", "/carts/startdate={startdate}&enddate={enddate}"},
+		{"/admin/queue/3:retry", "/admin/queue/{id}:retry"},
+		{"/v1/products/12", "/v1/products/{id}"},
+		{"/v9/products/12", UnmatchedRoute},
+		{"/products/12/unknown", UnmatchedRoute},
+		{"/unknown", UnmatchedRoute},
+		{"/", UnmatchedRoute},
+	}
+
+	SetRoutes([]string{
+		"/products",
+		"/products/{id}",
+		"/products/{id}/history",
+		"/products/categories",
+		"/products/categories/{category}",
+		"/carts/{id}",
+		"/carts/guest",
+		"/carts/guest:merge",
+		"/carts/startdate={startdate}&enddate={enddate}",
+		"/admin/queue/{id}",
+		"/admin/queue/{id}:retry",
+		"/v1/products/{id}",
+	})
+	t.Cleanup(func() { SetRoutes(nil) })
+
+	for _, tt := range tests {
+		if got := RouteTemplate(tt.path); got != tt.want {
+			t.Errorf("RouteTemplate(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+// TestRouteTemplateWithoutRoutes checks the placeholders of the paths
+// when the routes are not set.
+func TestRouteTemplateWithoutRoutes(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/", "/"},
+		{"/products/12", "/products/{id}"},
+		{"/v2/products/12/history", "/v2/products/{id}/history"},
+		{"/products/categories/tools", "/products/categories/{category}"},
+		{"/carts/startdate=2022-01-01", "/carts/{filter}"},
+	}
+
+	for _, tt := range tests {
+		if got := RouteTemplate(tt.path); got != tt.want {
+			t.Errorf("RouteTemplate(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
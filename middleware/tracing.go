@@ -0,0 +1,64 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/imariom/products-api/tracing"
+)
+
+// Tracing starts a server span for every request, continuing the trace
+// of the caller when a traceparent header is received.
+func Tracing(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if sc, ok := tracing.Extract(r.Header); ok {
+			ctx = tracing.ContextWithRemoteSpanContext(ctx, sc)
+		}
+
+		route := RouteTemplate(r.URL.Path)
+		ctx, span := tracing.Start(ctx, fmt.Sprintf("HTTP %s %s", r.Method, route),
+			tracing.WithKind(tracing.KindServer),
+			tracing.WithAttributes(
+				"http.method", r.Method,
+				"http.route", route,
+				"http.target", r.URL.Path,
+				"net.peer.addr", r.RemoteAddr,
+				"http.user_agent", r.UserAgent(),
+			))
+		defer span.End()
+
+		if id := RequestIDFromContext(ctx); id != "" {
+			span.SetAttributes("http.request_id", id)
+		}
+
+		w := newResponseWriter(rw)
+		next.ServeHTTP(w, r.WithContext(ctx))
+
+		span.SetAttributes("http.status_code", w.Status(), "http.response_size", w.bytes)
+		if w.Status() >= http.StatusInternalServerError {
+			span.SetStatus(tracing.StatusError, http.StatusText(w.Status()))
+		}
+	})
+}
+
+// TraceRouting serves requests through mux recording one span for
+// resolving the route and another one for the handler execution.
+func TraceRouting(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		_, routeSpan := tracing.Start(r.Context(), "routing")
+		h, pattern := mux.Handler(r)
+		routeSpan.SetAttributes("http.mux_pattern", pattern)
+		routeSpan.End()
+
+		name := "handler " + pattern
+		if pattern == "" {
+			name = "handler not found"
+		}
+
+		ctx, span := tracing.Start(r.Context(), name)
+		defer span.End()
+
+		h.ServeHTTP(rw, r.WithContext(ctx))
+	})
+}
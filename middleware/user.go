@@ -0,0 +1,47 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+)
+
+// User is the user authenticated by a request.
+type User struct {
+	ID       uint64
+	Username string
+}
+
+type userKey struct{}
+
+// UserFromContext returns the user authenticated by the request being
+// served, if any.
+func UserFromContext(ctx context.Context) (User, bool) {
+	u, ok := ctx.Value(userKey{}).(User)
+	return u, ok
+}
+
+// UserAuth authenticates the users of the requests with the credentials
+// of their "Authorization: Basic" header, checked by authenticate. The
+// requests without credentials are served unauthenticated, and those
+// with invalid ones rejected with 401.
+func UserAuth(authenticate func(username, password string) (User, bool)) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			username, password, ok := r.BasicAuth()
+			if !ok {
+				next.ServeHTTP(rw, r)
+				return
+			}
+
+			u, ok := authenticate(username, password)
+			if !ok {
+				rw.Header().Set("WWW-Authenticate", `Basic realm="store-api"`)
+				http.Error(rw, "invalid username or password", http.StatusUnauthorized)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), userKey{}, u)
+			next.ServeHTTP(rw, r.WithContext(ctx))
+		})
+	}
+}
@@ -0,0 +1,35 @@
+package openapi
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+//go:embed docs.html
+var docsPage []byte
+
+// Handler serves doc as JSON.
+func Handler(doc *Document) http.Handler {
+	body, err := doc.JSON()
+
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if err != nil {
+			http.Error(rw, "failed to encode OpenAPI document", http.StatusInternalServerError)
+			return
+		}
+
+		rw.Header().Set("Content-Type", "application/json")
+		rw.Write(body)
+	})
+}
+
+// DocsHandler serves an interactive documentation page rendering the
+// document published at specURL. The page is self-contained so it also
+// works without internet access.
+func DocsHandler(specURL string) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+		rw.Header().Set("Link", "<"+specURL+">; rel=\"service-desc\"")
+		rw.Write(docsPage)
+	})
+}
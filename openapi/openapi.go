@@ -0,0 +1,382 @@
+// Package openapi builds an OpenAPI 3.1 document from a route table and
+// the Go types used as request and response bodies.
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/imariom/products-api/data"
+)
+
+// Version is the OpenAPI version of the generated documents.
+const Version = "3.1.0"
+
+// Info describes the API.
+type Info struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+// Param describes a query or path parameter. Path parameters are
+// derived from the route path when not listed explicitly.
+type Param struct {
+	Name        string
+	In          string // "query", "path" or "header"
+	Description string
+	Type        string // JSON schema type, "integer" if empty
+	Format      string
+	Enum        []string
+	Required    bool
+}
+
+// Route documents a single endpoint.
+type Route struct {
+	Method  string
+	Path    string // OpenAPI path template, e.g. /products/{id}
+	Tag     string
+	Summary string
+
+	Params []Param
+
+	// Request and Response are (zero) values of the Go types used as
+	// request and response bodies. Either may be nil.
+	Request  interface{}
+	Response interface{}
+
+	// Errors lists the error status codes the endpoint may answer with.
+	Errors []int
+
+	// MediaTypes are the formats of the request and response bodies,
+	// only application/json if empty.
+	MediaTypes []string
+}
+
+// Document is an OpenAPI document.
+type Document struct {
+	OpenAPI    string               `json:"openapi"`
+	Info       Info                 `json:"info"`
+	Paths      map[string]*PathItem `json:"paths"`
+	Components Components           `json:"components"`
+}
+
+// Components holds the reusable schemas of a Document.
+type Components struct {
+	Schemas map[string]*Schema `json:"schemas"`
+}
+
+// PathItem holds the operations on a path.
+type PathItem map[string]*Operation
+
+// Operation describes a single method on a path.
+type Operation struct {
+	Tags        []string             `json:"tags,omitempty"`
+	Summary     string               `json:"summary,omitempty"`
+	OperationID string               `json:"operationId"`
+	Parameters  []*Parameter         `json:"parameters,omitempty"`
+	RequestBody *RequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*Response `json:"responses"`
+}
+
+// Parameter is an OpenAPI parameter object.
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required"`
+	Schema      *Schema `json:"schema"`
+}
+
+// RequestBody is an OpenAPI request body object.
+type RequestBody struct {
+	Required bool                  `json:"required"`
+	Content  map[string]*MediaType `json:"content"`
+}
+
+// Response is an OpenAPI response object.
+type Response struct {
+	Description string                `json:"description"`
+	Content     map[string]*MediaType `json:"content,omitempty"`
+}
+
+// MediaType is an OpenAPI media type object.
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// Schema is a JSON schema.
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+}
+
+var pathParamRe = regexp.MustCompile(`\{(\w+)\}`)
+
+// Build generates the document describing routes.
+func Build(info Info, routes []Route) *Document {
+	doc := &Document{
+		OpenAPI:    Version,
+		Info:       info,
+		Paths:      make(map[string]*PathItem),
+		Components: Components{Schemas: make(map[string]*Schema)},
+	}
+
+	for _, r := range routes {
+		item, ok := doc.Paths[r.Path]
+		if !ok {
+			item = &PathItem{}
+			doc.Paths[r.Path] = item
+		}
+
+		(*item)[strings.ToLower(r.Method)] = doc.operation(r)
+	}
+
+	return doc
+}
+
+func (doc *Document) operation(r Route) *Operation {
+	op := &Operation{
+		Summary:     r.Summary,
+		OperationID: operationID(r),
+		Responses:   make(map[string]*Response),
+	}
+
+	if r.Tag != "" {
+		op.Tags = []string{r.Tag}
+	}
+
+	// path parameters not explicitly documented default to integers
+	documented := make(map[string]bool)
+	for _, p := range r.Params {
+		documented[p.In+":"+p.Name] = true
+	}
+
+	params := append([]Param(nil), r.Params...)
+	for _, m := range pathParamRe.FindAllStringSubmatch(r.Path, -1) {
+		if !documented["path:"+m[1]] {
+			params = append(params, Param{Name: m[1], In: "path", Type: "integer"})
+		}
+	}
+
+	for _, p := range params {
+		typ := p.Type
+		if typ == "" {
+			typ = "integer"
+		}
+
+		op.Parameters = append(op.Parameters, &Parameter{
+			Name:        p.Name,
+			In:          p.In,
+			Description: p.Description,
+			Required:    p.Required || p.In == "path",
+			Schema:      &Schema{Type: typ, Format: p.Format, Enum: p.Enum},
+		})
+	}
+
+	mediaTypes := r.MediaTypes
+	if len(mediaTypes) == 0 {
+		mediaTypes = []string{"application/json"}
+	}
+
+	if r.Request != nil {
+		op.RequestBody = &RequestBody{
+			Required: true,
+			Content:  content(mediaTypes, doc.schemaOf(reflect.TypeOf(r.Request))),
+		}
+	}
+
+	ok := &Response{Description: http.StatusText(http.StatusOK)}
+	if r.Response != nil {
+		ok.Content = content(mediaTypes, doc.schemaOf(reflect.TypeOf(r.Response)))
+	}
+	op.Responses["200"] = ok
+
+	for _, code := range r.Errors {
+		op.Responses[fmt.Sprint(code)] = &Response{
+			Description: http.StatusText(code),
+			Content: map[string]*MediaType{
+				"text/plain": {Schema: &Schema{Type: "string"}},
+			},
+		}
+	}
+
+	return op
+}
+
+// content returns the same schema for every media type.
+func content(mediaTypes []string, schema *Schema) map[string]*MediaType {
+	c := make(map[string]*MediaType, len(mediaTypes))
+	for _, mt := range mediaTypes {
+		c[mt] = &MediaType{Schema: schema}
+	}
+
+	return c
+}
+
+// operationID derives a stable identifier such as "getProductsId".
+func operationID(r Route) string {
+	id := strings.ToLower(r.Method)
+	for _, part := range regexp.MustCompile(`[^A-Za-z0-9]+`).Split(r.Path, -1) {
+		if part == "" {
+			continue
+		}
+		id += strings.ToUpper(part[:1]) + part[1:]
+	}
+
+	return id
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+)
+
+// schemaOf returns the schema of t. Named struct types are added to the
+// components and referenced.
+func (doc *Document) schemaOf(t reflect.Type) *Schema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if t == timeType {
+		return &Schema{Type: "string", Format: "date-time"}
+	}
+
+	// raw JSON may hold any value
+	if t == rawMessageType {
+		return &Schema{}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &Schema{Type: "integer", Format: intFormat(t)}
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		zero := 0.0
+		return &Schema{Type: "integer", Format: intFormat(t), Minimum: &zero}
+
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number", Format: "double"}
+
+	case reflect.String:
+		return &Schema{Type: "string"}
+
+	case reflect.Slice, reflect.Array:
+		return &Schema{Type: "array", Items: doc.schemaOf(t.Elem())}
+
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: doc.schemaOf(t.Elem())}
+
+	case reflect.Struct:
+		if t.Name() == "" {
+			return doc.structSchema(t)
+		}
+
+		if _, ok := doc.Components.Schemas[t.Name()]; !ok {
+			// reserve the name first so recursive types terminate
+			doc.Components.Schemas[t.Name()] = &Schema{}
+			*doc.Components.Schemas[t.Name()] = *doc.structSchema(t)
+		}
+
+		return &Schema{Ref: "#/components/schemas/" + t.Name()}
+	}
+
+	return &Schema{}
+}
+
+func intFormat(t reflect.Type) string {
+	if t.Bits() == 64 {
+		return "int64"
+	}
+
+	return "int32"
+}
+
+// structSchema builds the object schema of a struct following the
+// encoding/json rules, including the validation rules in its tags.
+func (doc *Document) structSchema(t reflect.Type) *Schema {
+	s := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+	doc.addFields(s, t)
+	sort.Strings(s.Required)
+
+	return s
+}
+
+func (doc *Document) addFields(s *Schema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" && !f.Anonymous {
+			continue
+		}
+
+		name := data.JSONName(f)
+		if name == "-" {
+			continue
+		}
+
+		// embedded structs without a JSON name are flattened
+		if f.Anonymous && f.Tag.Get("json") == "" {
+			ft := f.Type
+			for ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				doc.addFields(s, ft)
+				continue
+			}
+		}
+
+		prop := doc.schemaOf(f.Type)
+		for _, rule := range data.ParseRules(f.Tag.Get(data.ValidateTag)) {
+			v := rule.Value
+			n := int(rule.Value)
+
+			switch rule.Name {
+			case "required":
+				s.Required = append(s.Required, name)
+			case "min":
+				prop.Minimum = &v
+			case "max":
+				prop.Maximum = &v
+			case "minlen":
+				prop.MinLength = &n
+			case "maxlen":
+				prop.MaxLength = &n
+			}
+		}
+
+		s.Properties[name] = prop
+	}
+}
+
+// JSON encodes doc with indentation, so the output is stable and easy
+// to diff.
+func (doc *Document) JSON() ([]byte, error) {
+	b, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return append(b, '\n'), nil
+}
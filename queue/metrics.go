@@ -0,0 +1,17 @@
+package queue
+
+import "github.com/imariom/products-api/metrics"
+
+var (
+	jobRuns = metrics.NewCounterVec("queue_job_runs_total",
+		"Number of runs of the queued jobs by kind and result (succeeded, retried or dead).", "kind", "result")
+
+	jobDuration = metrics.NewHistogramVec("queue_job_duration_seconds",
+		"Duration of the runs of the queued jobs.", nil, "kind")
+
+	jobsDeduplicated = metrics.NewCounterVec("queue_jobs_deduplicated_total",
+		"Number of jobs not queued because a job with the same unique key was.", "kind")
+
+	jobsQueued = metrics.NewGauge("queue_jobs_queued",
+		"Number of jobs pending or running.")
+)
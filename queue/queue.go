@@ -0,0 +1,559 @@
+// Package queue runs background jobs asynchronously with a pool of
+// workers. Jobs are persisted to disk until they finish, retried with an
+// exponential backoff when they fail, and moved to the dead letters
+// once they run out of attempts. A job can carry a unique key so that
+// it is not queued again while it waits or runs.
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/imariom/products-api/logging"
+)
+
+// default options of a Queue
+const (
+	DefaultMaxAttempts  = 5
+	DefaultMinBackoff   = time.Second
+	DefaultMaxBackoff   = 10 * time.Minute
+	DefaultTimeout      = 5 * time.Minute
+	DefaultDrainTimeout = 10 * time.Second
+	DefaultWorkers      = 4
+	DefaultLogSize      = 1000
+)
+
+// Job statuses. Dead jobs failed all their attempts, and are kept in
+// the dead letters until they are retried or deleted.
+const (
+	StatusPending   = "pending"
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	StatusDead      = "dead"
+)
+
+var (
+	// ErrNotFound is returned for unknown jobs.
+	ErrNotFound = errors.New("job not found")
+
+	// ErrDuplicate is returned when queueing a job with the unique key
+	// of a job pending or running.
+	ErrDuplicate = errors.New("a job with the same unique key is already queued")
+
+	// ErrRunning is returned when deleting a running job.
+	ErrRunning = errors.New("job is running")
+)
+
+// Handler runs the jobs of a kind. The context is cancelled after the
+// timeout of the queue, or when the queue stops before the job ends;
+// the job is then run again at the next start.
+type Handler func(ctx context.Context, payload json.RawMessage) error
+
+// permanentError is an error not worth retrying.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent wraps err so that the job failing with it is moved to the
+// dead letters without further attempts, e.g. for an invalid payload.
+func Permanent(err error) error {
+	return &permanentError{err}
+}
+
+// JobAttempt is a run of a job.
+type JobAttempt struct {
+	Time     time.Time `json:"time"`
+	Duration float64   `json:"duration_ms"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// Job is a unit of work run by the handler of its kind with its
+// payload.
+type Job struct {
+	ID      uint64          `json:"id"`
+	Kind    string          `json:"kind" validate:"required,maxlen=100"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+
+	// UniqueKey prevents the job from being queued while another job
+	// with the same key is pending or running.
+	UniqueKey string `json:"unique_key,omitempty" validate:"maxlen=200"`
+
+	// MaxAttempts overrides the number of attempts of the queue.
+	MaxAttempts int `json:"max_attempts,omitempty"`
+
+	// TimeoutSeconds overrides the timeout of the attempts of the
+	// queue.
+	TimeoutSeconds int `json:"timeout_seconds,omitempty"`
+
+	Status   string       `json:"status"`
+	Attempts []JobAttempt `json:"attempts"`
+
+	// RunAt is when the job runs next, its first attempt being delayed
+	// until then if set when queued.
+	RunAt *time.Time `json:"run_at,omitempty"`
+
+	// RetryOf is the dead job retried by this one.
+	RetryOf uint64 `json:"retry_of,omitempty"`
+
+	// Traceparent is the W3C trace context of the request that queued
+	// the job, if any, so that its runs are traced as part of it.
+	Traceparent string `json:"traceparent,omitempty" validate:"maxlen=55"`
+
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// ValidationError describes an invalid job.
+type ValidationError struct {
+	Field string
+	Msg   string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid field '%s': %s", e.Field, e.Msg)
+}
+
+// JobFilter selects the jobs returned by Jobs. Zero fields select all
+// the jobs.
+type JobFilter struct {
+	Kind          string
+	Status        string
+	Limit, Offset int
+}
+
+// Options configures a Queue.
+type Options struct {
+	// Path is the file the jobs are persisted to, as a journal of their
+	// changes compacted from time to time. They are only kept in memory
+	// when empty.
+	Path string
+
+	// MaxAttempts is the number of attempts before a job is dead.
+	MaxAttempts int
+
+	// MinBackoff is the delay before the first retry, doubled after each
+	// failed attempt up to MaxBackoff.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// Timeout bounds each attempt.
+	Timeout time.Duration
+
+	// DrainTimeout is the time Stop waits for the running jobs before
+	// cancelling them and queueing them again for the next start.
+	DrainTimeout time.Duration
+
+	// Workers is the number of jobs run concurrently.
+	Workers int
+
+	// LogSize is the number of succeeded jobs kept.
+	LogSize int
+
+	Logger *logging.Logger
+}
+
+// Queue runs the jobs queued with Enqueue. It is created with New, the
+// handlers of the kinds of jobs are registered with Register, and it is
+// started with Start and stopped with Stop.
+type Queue struct {
+	opts   Options
+	logger *logging.Logger
+
+	mtx      sync.Mutex
+	nextID   uint64
+	handlers map[string]Handler
+	jobs     map[uint64]*Job
+	running  map[uint64]bool
+
+	// due orders the pending jobs by their next run. The entries of the
+	// jobs that ran, were rescheduled or deleted meanwhile are stale,
+	// and skipped.
+	due dueJobs
+
+	// succeeded are the IDs of the succeeded jobs, the oldest first
+	succeeded []uint64
+
+	// queued are the IDs of the pending and running jobs by unique key
+	queued map[string]uint64
+
+	// journal is the file the changes of the jobs are appended to, with
+	// the number of entries it holds
+	journal *os.File
+	entries int
+
+	// ctx is the parent context of the running jobs, cancelled when the
+	// queue stops before they end
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	wake    chan struct{}
+	stop    chan struct{}
+	stopped sync.Once
+	wg      sync.WaitGroup
+}
+
+// New creates a queue, loading the jobs persisted to opts.Path. The
+// jobs that were running when it last stopped are pending again.
+func New(opts Options) (*Queue, error) {
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = DefaultMaxAttempts
+	}
+	if opts.MinBackoff <= 0 {
+		opts.MinBackoff = DefaultMinBackoff
+	}
+	if opts.MaxBackoff < opts.MinBackoff {
+		opts.MaxBackoff = DefaultMaxBackoff
+		if opts.MaxBackoff < opts.MinBackoff {
+			opts.MaxBackoff = opts.MinBackoff
+		}
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+	if opts.DrainTimeout <= 0 {
+		opts.DrainTimeout = DefaultDrainTimeout
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = DefaultWorkers
+	}
+	if opts.LogSize <= 0 {
+		opts.LogSize = DefaultLogSize
+	}
+	if opts.Logger == nil {
+		opts.Logger = logging.Default
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	q := &Queue{
+		opts:     opts,
+		logger:   opts.Logger,
+		nextID:   1,
+		handlers: map[string]Handler{},
+		jobs:     map[uint64]*Job{},
+		running:  map[uint64]bool{},
+		queued:   map[string]uint64{},
+		ctx:      ctx,
+		cancel:   cancel,
+		wake:     make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+	}
+
+	if err := q.load(); err != nil {
+		return nil, err
+	}
+
+	return q, nil
+}
+
+// Register sets the handler of the jobs of kind. It must be called
+// before Start.
+func (q *Queue) Register(kind string, h Handler) {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	q.handlers[kind] = h
+}
+
+// Handles reports whether a handler is registered for kind.
+func (q *Queue) Handles(kind string) bool {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	_, ok := q.handlers[kind]
+	return ok
+}
+
+// Start runs the pending jobs with the workers until Stop is called.
+func (q *Queue) Start(ctx context.Context) error {
+	q.wg.Add(q.opts.Workers)
+	for i := 0; i < q.opts.Workers; i++ {
+		go q.work()
+	}
+
+	q.notify()
+	return nil
+}
+
+// Stop stops running new jobs and waits for the running ones for the
+// drain timeout, or until ctx is done. The jobs still running are then
+// cancelled and pending again, to run at the next start, and Stop waits
+// for their handlers to return, or for ctx to be done.
+func (q *Queue) Stop(ctx context.Context) error {
+	q.stopped.Do(func() {
+		close(q.stop)
+	})
+
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+
+	timer := time.NewTimer(q.opts.DrainTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-done:
+		return nil
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+
+	q.cancel()
+	n := q.requeue()
+	q.logger.Warn("stopped the job queue before the running jobs ended, they will run again", "jobs", n)
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// requeue makes the running jobs pending again, and returns their
+// number.
+func (q *Queue) requeue() int {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	now := time.Now().UTC()
+	for id := range q.running {
+		j := q.jobs[id]
+		j.Status = StatusPending
+		j.RunAt = &now
+		q.scheduleLocked(j)
+		q.saveLocked(j)
+	}
+
+	n := len(q.running)
+	q.running = map[uint64]bool{}
+
+	return n
+}
+
+// Enqueue validates and queues j, setting its ID, its status and its
+// creation time. If a job with its unique key is pending or running,
+// j is set to that job and ErrDuplicate is returned.
+func (q *Queue) Enqueue(j *Job) error {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	if _, ok := q.handlers[j.Kind]; !ok {
+		return &ValidationError{"kind", fmt.Sprintf("unknown job kind '%s'", j.Kind)}
+	}
+	if j.MaxAttempts < 0 {
+		return &ValidationError{"max_attempts", "must not be negative"}
+	}
+	if j.TimeoutSeconds < 0 {
+		return &ValidationError{"timeout_seconds", "must not be negative"}
+	}
+
+	if other, ok := q.queuedLocked(j.UniqueKey); ok {
+		*j = copyJob(other)
+		jobsDeduplicated.WithLabelValues(j.Kind).Inc()
+		return ErrDuplicate
+	}
+
+	j.RetryOf = 0
+	if err := q.addJobLocked(j); err != nil {
+		return err
+	}
+	q.notify()
+
+	return nil
+}
+
+// addJobLocked queues j and persists it. The job is not queued if it
+// cannot be persisted.
+func (q *Queue) addJobLocked(j *Job) error {
+	now := time.Now().UTC()
+	if j.RunAt == nil || j.RunAt.Before(now) {
+		j.RunAt = &now
+	}
+
+	j.ID = q.nextID
+	j.Status = StatusPending
+	j.Attempts = []JobAttempt{}
+	j.CreatedAt = now
+	j.FinishedAt = nil
+	q.nextID++
+
+	c := copyJob(j)
+	q.jobs[j.ID] = &c
+	if err := q.saveLocked(&c); err != nil {
+		delete(q.jobs, j.ID)
+		q.nextID--
+		return err
+	}
+	jobsQueued.Inc()
+	q.scheduleLocked(&c)
+	if j.UniqueKey != "" {
+		q.queued[j.UniqueKey] = j.ID
+	}
+
+	return nil
+}
+
+// queuedLocked returns the job pending or running with the unique key,
+// if any.
+func (q *Queue) queuedLocked(key string) (*Job, bool) {
+	if key == "" {
+		return nil, false
+	}
+
+	id, ok := q.queued[key]
+	if !ok {
+		return nil, false
+	}
+
+	return q.jobs[id], true
+}
+
+// notify wakes up a worker to run the jobs due.
+func (q *Queue) notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Jobs returns the jobs selected by filter, the most recent first.
+func (q *Queue) Jobs(filter JobFilter) []Job {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	list := []Job{}
+	for _, j := range q.jobs {
+		if filter.Kind != "" && j.Kind != filter.Kind {
+			continue
+		}
+		if filter.Status != "" && j.Status != filter.Status {
+			continue
+		}
+
+		list = append(list, copyJob(j))
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID > list[j].ID })
+
+	if filter.Offset >= len(list) {
+		return []Job{}
+	}
+	list = list[filter.Offset:]
+	if filter.Limit > 0 && filter.Limit < len(list) {
+		list = list[:filter.Limit]
+	}
+
+	return list
+}
+
+// Job returns the job id.
+func (q *Queue) Job(id uint64) (Job, error) {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	j, ok := q.jobs[id]
+	if !ok {
+		return Job{}, ErrNotFound
+	}
+
+	return copyJob(j), nil
+}
+
+// Retry queues a new job with the kind and the payload of the dead job
+// id, e.g. once the cause of its failures was fixed. The dead job is
+// kept until deleted.
+func (q *Queue) Retry(id uint64) (Job, error) {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	j, ok := q.jobs[id]
+	if !ok {
+		return Job{}, ErrNotFound
+	}
+
+	if j.Status != StatusDead {
+		return Job{}, fmt.Errorf("job %d is %s, only dead jobs can be retried", id, j.Status)
+	}
+
+	retry := &Job{
+		Kind:           j.Kind,
+		Payload:        j.Payload,
+		UniqueKey:      j.UniqueKey,
+		MaxAttempts:    j.MaxAttempts,
+		TimeoutSeconds: j.TimeoutSeconds,
+		RetryOf:        j.ID,
+		Traceparent:    j.Traceparent,
+	}
+
+	if other, ok := q.queuedLocked(retry.UniqueKey); ok {
+		return copyJob(other), ErrDuplicate
+	}
+
+	if err := q.addJobLocked(retry); err != nil {
+		return Job{}, err
+	}
+	q.notify()
+
+	return copyJob(retry), nil
+}
+
+// Delete removes the job id: a pending job is cancelled, and a finished
+// one dropped from the log or the dead letters. Running jobs cannot be
+// deleted.
+func (q *Queue) Delete(id uint64) (Job, error) {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	j, ok := q.jobs[id]
+	if !ok {
+		return Job{}, ErrNotFound
+	}
+
+	if j.Status == StatusRunning {
+		return Job{}, ErrRunning
+	}
+
+	switch j.Status {
+	case StatusPending:
+		jobsQueued.Dec()
+		delete(q.queued, j.UniqueKey)
+	case StatusSucceeded:
+		for i, other := range q.succeeded {
+			if other == id {
+				q.succeeded = append(q.succeeded[:i], q.succeeded[i+1:]...)
+				break
+			}
+		}
+	}
+	delete(q.jobs, id)
+
+	return copyJob(j), q.saveDeletedLocked(id)
+}
+
+type jobKey struct{}
+
+// JobFromContext returns the job run with ctx by its handler.
+func JobFromContext(ctx context.Context) (Job, bool) {
+	j, ok := ctx.Value(jobKey{}).(*Job)
+	if !ok {
+		return Job{}, false
+	}
+
+	return copyJob(j), true
+}
+
+func copyJob(j *Job) Job {
+	c := *j
+	c.Attempts = append([]JobAttempt{}, j.Attempts...)
+	return c
+}
@@ -0,0 +1,610 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/imariom/products-api/logging"
+)
+
+// newQueue returns a queue persisted to path, if set, retrying quickly,
+// with a handler of the kind "test" running fn. It is stopped at the end
+// of the test.
+func newQueue(t *testing.T, path string, opts Options, fn Handler) *Queue {
+	t.Helper()
+
+	opts.Path = path
+	opts.Logger = logging.Discard
+	if opts.MinBackoff == 0 {
+		opts.MinBackoff = time.Millisecond
+		opts.MaxBackoff = 5 * time.Millisecond
+	}
+
+	q, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fn != nil {
+		q.Register("test", fn)
+	}
+	t.Cleanup(func() { q.Stop(context.Background()) })
+
+	return q
+}
+
+// waitStatus waits for the job id to have the status, and returns it.
+func waitStatus(t *testing.T, q *Queue, id uint64, status string) Job {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		j, err := q.Job(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if j.Status == status {
+			return j
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got job %d %s with attempts %+v, want %s", id, j.Status, j.Attempts, status)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// attemptErrors returns the errors of the attempts of j.
+func attemptErrors(j Job) []string {
+	errs := []string{}
+	for _, a := range j.Attempts {
+		errs = append(errs, a.Error)
+	}
+	return errs
+}
+
+// failing returns a handler failing with errs in turn, then succeeding.
+func failing(errs ...error) Handler {
+	var mtx sync.Mutex
+	return func(ctx context.Context, payload json.RawMessage) error {
+		mtx.Lock()
+		defer mtx.Unlock()
+
+		if len(errs) == 0 {
+			return nil
+		}
+		err := errs[0]
+		errs = errs[1:]
+		return err
+	}
+}
+
+func TestRetries(t *testing.T) {
+	failure := errors.New("failure")
+
+	tests := []struct {
+		name        string
+		handler     Handler
+		maxAttempts int
+		status      string
+		errors      []string
+	}{
+		{
+			name:    "succeeded",
+			handler: failing(),
+			status:  StatusSucceeded,
+			errors:  []string{""},
+		},
+		{
+			name:    "retried until succeeded",
+			handler: failing(failure, failure),
+			status:  StatusSucceeded,
+			errors:  []string{"failure", "failure", ""},
+		},
+		{
+			name:        "dead after the last attempt",
+			handler:     failing(failure, failure, failure, failure),
+			maxAttempts: 3,
+			status:      StatusDead,
+			errors:      []string{"failure", "failure", "failure"},
+		},
+		{
+			name:    "dead after a permanent error",
+			handler: failing(Permanent(failure)),
+			status:  StatusDead,
+			errors:  []string{"failure"},
+		},
+		{
+			name: "panics fail the attempt",
+			handler: func(ctx context.Context, payload json.RawMessage) error {
+				panic("boom")
+			},
+			maxAttempts: 2,
+			status:      StatusDead,
+			errors:      []string{"panic: boom", "panic: boom"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newQueue(t, "", Options{}, tt.handler)
+			q.Start(context.Background())
+
+			j := &Job{Kind: "test", MaxAttempts: tt.maxAttempts}
+			if err := q.Enqueue(j); err != nil {
+				t.Fatal(err)
+			}
+
+			got := waitStatus(t, q, j.ID, tt.status)
+			if errs := attemptErrors(got); strings.Join(errs, "|") != strings.Join(tt.errors, "|") {
+				t.Errorf("got attempts %q, want %q", errs, tt.errors)
+			}
+			if got.FinishedAt == nil || got.RunAt != nil {
+				t.Errorf("got finished at %v and run at %v, want finished", got.FinishedAt, got.RunAt)
+			}
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	q := newQueue(t, "", Options{MinBackoff: time.Second, MaxBackoff: 10 * time.Second}, nil)
+
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 8 * time.Second},
+		{5, 10 * time.Second},
+		{50, 10 * time.Second},
+	}
+
+	for _, tt := range tests {
+		for i := 0; i < 100; i++ {
+			// minus up to 10%
+			if got := q.backoff(tt.attempt); got > tt.want || got < tt.want-tt.want/10 {
+				t.Fatalf("got a backoff of %s after attempt %d, want %s minus up to 10%%", got, tt.attempt, tt.want)
+			}
+		}
+	}
+}
+
+// TestRetry checks that the dead jobs are retried by a new job, and
+// only them.
+func TestRetry(t *testing.T) {
+	q := newQueue(t, "", Options{}, failing(Permanent(errors.New("failure"))))
+	q.Start(context.Background())
+
+	j := &Job{Kind: "test", Payload: json.RawMessage(`{"n":1}`), UniqueKey: "key"}
+	if err := q.Enqueue(j); err != nil {
+		t.Fatal(err)
+	}
+	waitStatus(t, q, j.ID, StatusDead)
+
+	retry, err := q.Retry(j.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if retry.ID == j.ID || retry.RetryOf != j.ID || string(retry.Payload) != `{"n":1}` || retry.UniqueKey != "key" {
+		t.Errorf("got retry %+v, want a new job retrying %d", retry, j.ID)
+	}
+
+	waitStatus(t, q, retry.ID, StatusSucceeded)
+	if _, err := q.Retry(retry.ID); err == nil {
+		t.Error("retried a succeeded job")
+	}
+	if _, err := q.Retry(100); !errors.Is(err, ErrNotFound) {
+		t.Errorf("got error %v, want ErrNotFound", err)
+	}
+
+	// the dead job is kept until deleted
+	if got, _ := q.Job(j.ID); got.Status != StatusDead {
+		t.Errorf("got the retried job %s, want it dead", got.Status)
+	}
+}
+
+func TestEnqueue(t *testing.T) {
+	q := newQueue(t, "", Options{}, failing())
+
+	tests := []struct {
+		name string
+		job  Job
+		err  string
+	}{
+		{"unknown kind", Job{Kind: "other"}, "invalid field 'kind': unknown job kind 'other'"},
+		{"negative attempts", Job{Kind: "test", MaxAttempts: -1}, "invalid field 'max_attempts': must not be negative"},
+		{"negative timeout", Job{Kind: "test", TimeoutSeconds: -1}, "invalid field 'timeout_seconds': must not be negative"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ve *ValidationError
+			if err := q.Enqueue(&tt.job); !errors.As(err, &ve) || err.Error() != tt.err {
+				t.Errorf("got error %v, want %q", err, tt.err)
+			}
+		})
+	}
+
+	if jobs := q.Jobs(JobFilter{}); len(jobs) != 0 {
+		t.Errorf("got %d jobs queued, want none", len(jobs))
+	}
+}
+
+// TestEnqueueDuplicate checks that a job is not queued while another
+// job with its unique key is pending or running, but is once it ended.
+func TestEnqueueDuplicate(t *testing.T) {
+	release := make(chan struct{})
+	q := newQueue(t, "", Options{}, func(ctx context.Context, payload json.RawMessage) error {
+		<-release
+		return nil
+	})
+
+	first := &Job{Kind: "test", UniqueKey: "key"}
+	if err := q.Enqueue(first); err != nil {
+		t.Fatal(err)
+	}
+
+	// pending, then running
+	for _, status := range []string{StatusPending, StatusRunning} {
+		if status == StatusRunning {
+			q.Start(context.Background())
+			waitStatus(t, q, first.ID, StatusRunning)
+		}
+
+		j := &Job{Kind: "test", UniqueKey: "key", Payload: json.RawMessage(`{}`)}
+		if err := q.Enqueue(j); !errors.Is(err, ErrDuplicate) {
+			t.Fatalf("got error %v with the first job %s, want ErrDuplicate", err, status)
+		}
+		if j.ID != first.ID || j.Status != status {
+			t.Errorf("got job %d %s, want the first job %d %s", j.ID, j.Status, first.ID, status)
+		}
+	}
+
+	other := &Job{Kind: "test", UniqueKey: "other"}
+	if err := q.Enqueue(other); err != nil {
+		t.Errorf("got error %v for another key", err)
+	}
+
+	close(release)
+	waitStatus(t, q, first.ID, StatusSucceeded)
+
+	again := &Job{Kind: "test", UniqueKey: "key"}
+	if err := q.Enqueue(again); err != nil {
+		t.Errorf("got error %v once the first job ended", err)
+	}
+	if again.ID == first.ID {
+		t.Errorf("got the first job %d, want a new one", again.ID)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	q := newQueue(t, "", Options{Workers: 1}, func(ctx context.Context, payload json.RawMessage) error {
+		<-release
+		return nil
+	})
+
+	running := &Job{Kind: "test"}
+	pending := &Job{Kind: "test", UniqueKey: "key"}
+	for _, j := range []*Job{running, pending} {
+		if err := q.Enqueue(j); err != nil {
+			t.Fatal(err)
+		}
+	}
+	q.Start(context.Background())
+	waitStatus(t, q, running.ID, StatusRunning)
+
+	if _, err := q.Delete(running.ID); !errors.Is(err, ErrRunning) {
+		t.Errorf("got error %v deleting a running job, want ErrRunning", err)
+	}
+
+	if _, err := q.Delete(pending.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.Job(pending.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("got error %v for a deleted job, want ErrNotFound", err)
+	}
+
+	// its unique key is free again
+	if err := q.Enqueue(&Job{Kind: "test", UniqueKey: "key"}); err != nil {
+		t.Errorf("got error %v reusing the key of a deleted job", err)
+	}
+}
+
+// TestLogSize checks that only the last succeeded jobs are kept, and
+// all the dead ones.
+func TestLogSize(t *testing.T) {
+	q := newQueue(t, "", Options{LogSize: 2}, func(ctx context.Context, payload json.RawMessage) error {
+		if string(payload) == `"fail"` {
+			return Permanent(errors.New("failure"))
+		}
+		return nil
+	})
+	q.Start(context.Background())
+
+	var ids []uint64
+	for _, payload := range []string{`"fail"`, `"a"`, `"b"`, `"c"`, `"fail"`} {
+		j := &Job{Kind: "test", Payload: json.RawMessage(payload)}
+		if err := q.Enqueue(j); err != nil {
+			t.Fatal(err)
+		}
+		status := StatusSucceeded
+		if payload == `"fail"` {
+			status = StatusDead
+		}
+		waitStatus(t, q, j.ID, status)
+		ids = append(ids, j.ID)
+	}
+
+	got := []uint64{}
+	for _, j := range q.Jobs(JobFilter{}) {
+		got = append(got, j.ID)
+	}
+	want := []uint64{ids[4], ids[3], ids[2], ids[0]}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got jobs %v, want %v", got, want)
+	}
+}
+
+// TestStop checks that the jobs still running after the drain timeout
+// are cancelled and pending again, without their attempt, and that they
+// run again at the next start from the journal.
+func TestStop(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jobs.json")
+
+	started := make(chan struct{})
+	q := newQueue(t, path, Options{DrainTimeout: 10 * time.Millisecond}, func(ctx context.Context, payload json.RawMessage) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	q.Start(context.Background())
+
+	j := &Job{Kind: "test"}
+	if err := q.Enqueue(j); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+
+	if err := q.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	got, _ := q.Job(j.ID)
+	if got.Status != StatusPending || len(got.Attempts) != 0 {
+		t.Fatalf("got job %s with %d attempts, want it pending again without attempts", got.Status, len(got.Attempts))
+	}
+
+	restarted := newQueue(t, path, Options{}, failing())
+	restarted.Start(context.Background())
+	waitStatus(t, restarted, j.ID, StatusSucceeded)
+}
+
+// TestStopDrains checks that Stop waits for the running jobs to end
+// until the drain timeout.
+func TestStopDrains(t *testing.T) {
+	started := make(chan struct{})
+	q := newQueue(t, "", Options{}, func(ctx context.Context, payload json.RawMessage) error {
+		close(started)
+		time.Sleep(20 * time.Millisecond)
+		return ctx.Err()
+	})
+	q.Start(context.Background())
+
+	j := &Job{Kind: "test"}
+	if err := q.Enqueue(j); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+
+	if err := q.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := q.Job(j.ID); got.Status != StatusSucceeded {
+		t.Errorf("got job %s, want it succeeded", got.Status)
+	}
+}
+
+// TestReplay checks that the jobs are loaded from the journal as they
+// were when the queue stopped, the running ones pending again.
+func TestReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jobs.json")
+
+	q := newQueue(t, path, Options{}, func(ctx context.Context, payload json.RawMessage) error {
+		if string(payload) == `"fail"` {
+			return Permanent(errors.New("failure"))
+		}
+		return nil
+	})
+	q.Start(context.Background())
+
+	succeeded := &Job{Kind: "test", Payload: json.RawMessage(`"ok"`)}
+	dead := &Job{Kind: "test", Payload: json.RawMessage(`"fail"`)}
+	deleted := &Job{Kind: "test"}
+	for _, j := range []*Job{succeeded, dead, deleted} {
+		if err := q.Enqueue(j); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitStatus(t, q, succeeded.ID, StatusSucceeded)
+	waitStatus(t, q, dead.ID, StatusDead)
+	waitStatus(t, q, deleted.ID, StatusSucceeded)
+	if _, err := q.Delete(deleted.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	runAt := time.Now().Add(time.Hour).UTC()
+	pending := &Job{Kind: "test", UniqueKey: "key", RunAt: &runAt}
+	if err := q.Enqueue(pending); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	want := q.Jobs(JobFilter{})
+
+	restarted := newQueue(t, path, Options{}, failing())
+	got := restarted.Jobs(JobFilter{})
+	if len(got) != len(want) {
+		t.Fatalf("got %d jobs, want %d", len(got), len(want))
+	}
+	for i := range want {
+		g, _ := json.Marshal(got[i])
+		w, _ := json.Marshal(want[i])
+		if string(g) != string(w) {
+			t.Errorf("got job %s, want %s", g, w)
+		}
+	}
+
+	// the unique keys and the IDs are kept
+	if err := restarted.Enqueue(&Job{Kind: "test", UniqueKey: "key"}); !errors.Is(err, ErrDuplicate) {
+		t.Errorf("got error %v, want ErrDuplicate", err)
+	}
+	j := &Job{Kind: "test"}
+	if err := restarted.Enqueue(j); err != nil {
+		t.Fatal(err)
+	}
+	if j.ID != pending.ID+1 {
+		t.Errorf("got job ID %d, want %d", j.ID, pending.ID+1)
+	}
+}
+
+// TestReplayRunning checks that the jobs running when the process died
+// are pending again, and run at the next start.
+func TestReplayRunning(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jobs.json")
+
+	started := make(chan struct{})
+	q := newQueue(t, path, Options{DrainTimeout: 10 * time.Millisecond}, func(ctx context.Context, payload json.RawMessage) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	q.Start(context.Background())
+
+	j := &Job{Kind: "test"}
+	if err := q.Enqueue(j); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+
+	// loaded while the job runs, as if the process died meanwhile
+	restarted := newQueue(t, path, Options{}, failing())
+	if got, _ := restarted.Job(j.ID); got.Status != StatusPending {
+		t.Fatalf("got job %s, want it pending again", got.Status)
+	}
+
+	restarted.Start(context.Background())
+	waitStatus(t, restarted, j.ID, StatusSucceeded)
+}
+
+// TestReplayCorrupted checks that a last entry cut short by a crash is
+// ignored, but not an invalid entry before it.
+func TestReplayCorrupted(t *testing.T) {
+	tests := []struct {
+		name string
+		tail string
+		err  bool
+	}{
+		{"last entry cut short", `{"job":{"id":2,"kind":"te`, false},
+		{"invalid entry", "{\"job\":{\"id\":2,\"kind\":\"te\n{\"deleted\":1}\n", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "jobs.json")
+
+			q := newQueue(t, path, Options{}, failing())
+			j := &Job{Kind: "test"}
+			if err := q.Enqueue(j); err != nil {
+				t.Fatal(err)
+			}
+			q.Stop(context.Background())
+
+			f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f.WriteString(tt.tail)
+			f.Close()
+
+			_, err = New(Options{Path: path, Logger: logging.Discard})
+			if tt.err {
+				if err == nil {
+					t.Error("loaded an invalid journal")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			restarted := newQueue(t, path, Options{}, failing())
+			if got := restarted.Jobs(JobFilter{}); len(got) != 1 || got[0].ID != j.ID {
+				t.Errorf("got jobs %+v, want job %d", got, j.ID)
+			}
+		})
+	}
+}
+
+// TestCompaction checks that the journal is compacted once it holds
+// too many stale entries, and at load.
+func TestCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jobs.json")
+	q := newQueue(t, path, Options{}, failing())
+
+	lines := func() int {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.Count(string(b), "\n")
+	}
+
+	kept := &Job{Kind: "test"}
+	if err := q.Enqueue(kept); err != nil {
+		t.Fatal(err)
+	}
+
+	// each job is queued, then deleted
+	for i := 0; i < minCompaction; i++ {
+		j := &Job{Kind: "test"}
+		if err := q.Enqueue(j); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := q.Delete(j.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n := lines(); n > minCompaction {
+		t.Errorf("got %d entries, want the journal compacted", n)
+	}
+
+	q.Stop(context.Background())
+	restarted := newQueue(t, path, Options{}, failing())
+	if n := lines(); n != 1 {
+		t.Errorf("got %d entries after loading, want a snapshot", n)
+	}
+
+	if got := restarted.Jobs(JobFilter{}); len(got) != 1 || got[0].ID != kept.ID {
+		t.Errorf("got jobs %+v, want job %d", got, kept.ID)
+	}
+	j := &Job{Kind: "test"}
+	if err := restarted.Enqueue(j); err != nil {
+		t.Fatal(err)
+	}
+	if want := kept.ID + minCompaction + 1; j.ID != want {
+		t.Errorf("got job ID %d, want %d", j.ID, want)
+	}
+}
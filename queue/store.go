@@ -0,0 +1,207 @@
+package queue
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/imariom/products-api/atomicfile"
+)
+
+// minCompaction is the number of entries the journal may hold beyond
+// twice the number of jobs before it is compacted.
+const minCompaction = 1000
+
+// entry is a line of the journal the queue is persisted to: either a
+// snapshot of the queue, which starts the journal once compacted, a job
+// as it changed, or the ID of a job deleted. The last entry of a job
+// wins.
+type entry struct {
+	NextID  uint64 `json:"next_id,omitempty"`
+	Jobs    []*Job `json:"jobs,omitempty"`
+	Job     *Job   `json:"job,omitempty"`
+	Deleted uint64 `json:"deleted,omitempty"`
+}
+
+// load replays the journal of the queue, if any, and compacts it. Jobs
+// running when it stopped are pending again. A last entry cut short by
+// a crash is ignored.
+func (q *Queue) load() error {
+	if q.opts.Path == "" {
+		return nil
+	}
+
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	f, err := os.Open(q.opts.Path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read jobs file: %w", err)
+	}
+
+	if f != nil {
+		err := q.replay(f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, j := range q.jobs {
+		if j.ID >= q.nextID {
+			q.nextID = j.ID + 1
+		}
+
+		switch j.Status {
+		case StatusRunning:
+			j.Status = StatusPending
+			j.RunAt = &j.CreatedAt
+			fallthrough
+		case StatusPending:
+			jobsQueued.Inc()
+			q.scheduleLocked(j)
+			if j.UniqueKey != "" {
+				q.queued[j.UniqueKey] = j.ID
+			}
+		case StatusSucceeded:
+			q.succeeded = append(q.succeeded, j.ID)
+		}
+	}
+	sort.Slice(q.succeeded, func(i, j int) bool { return q.succeeded[i] < q.succeeded[j] })
+
+	return q.compactLocked()
+}
+
+// replay applies the entries of the journal r.
+func (q *Queue) replay(r io.Reader) error {
+	br := bufio.NewReader(r)
+	for line := 1; ; line++ {
+		b, err := br.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("failed to read jobs file: %w", err)
+		}
+		last := err == io.EOF
+		if !last {
+			_, err := br.Peek(1)
+			last = err == io.EOF
+		}
+
+		if b = bytes.TrimSpace(b); len(b) > 0 {
+			var e entry
+			if err := json.Unmarshal(b, &e); err != nil {
+				if last {
+					q.logger.Warn("ignored the last entry of the jobs file, cut short", "path", q.opts.Path, "line", line)
+					return nil
+				}
+				return fmt.Errorf("failed to decode jobs file %s at line %d: %w", q.opts.Path, line, err)
+			}
+			q.apply(&e)
+		}
+
+		if last {
+			return nil
+		}
+	}
+}
+
+// apply applies the entry e of the journal. The IDs of the jobs deleted
+// since the last snapshot are not given again.
+func (q *Queue) apply(e *entry) {
+	if e.NextID > q.nextID {
+		q.nextID = e.NextID
+	}
+	if e.Jobs != nil {
+		q.jobs = make(map[uint64]*Job, len(e.Jobs))
+		for _, j := range e.Jobs {
+			q.jobs[j.ID] = j
+		}
+	}
+	if e.Job != nil {
+		q.jobs[e.Job.ID] = e.Job
+		if e.Job.ID >= q.nextID {
+			q.nextID = e.Job.ID + 1
+		}
+	}
+	if e.Deleted != 0 {
+		delete(q.jobs, e.Deleted)
+		if e.Deleted >= q.nextID {
+			q.nextID = e.Deleted + 1
+		}
+	}
+}
+
+// saveLocked appends j, as it changed, to the journal.
+func (q *Queue) saveLocked(j *Job) error {
+	return q.appendLocked(&entry{Job: j})
+}
+
+// saveDeletedLocked appends the deletion of the job id to the journal.
+func (q *Queue) saveDeletedLocked(id uint64) error {
+	return q.appendLocked(&entry{Deleted: id})
+}
+
+// appendLocked appends e to the journal, synced so that it survives a
+// crash, and compacts the journal once it holds too many stale entries.
+func (q *Queue) appendLocked(e *entry) error {
+	if q.journal == nil {
+		return nil
+	}
+
+	b, err := json.Marshal(e)
+	if err == nil {
+		_, err = q.journal.Write(append(b, '\n'))
+	}
+	if err == nil {
+		err = q.journal.Sync()
+	}
+	if err != nil {
+		q.logger.Error("failed to persist jobs", "path", q.opts.Path, "error", err)
+		return err
+	}
+
+	q.entries++
+	if q.entries > 2*len(q.jobs)+minCompaction {
+		return q.compactLocked()
+	}
+
+	return nil
+}
+
+// compactLocked replaces the journal with a snapshot of the queue, and
+// reopens it for appending.
+func (q *Queue) compactLocked() error {
+	if q.opts.Path == "" {
+		return nil
+	}
+
+	e := entry{NextID: q.nextID, Jobs: make([]*Job, 0, len(q.jobs))}
+	for _, j := range q.jobs {
+		e.Jobs = append(e.Jobs, j)
+	}
+
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	if err := atomicfile.Write(q.opts.Path, append(b, '\n')); err != nil {
+		q.logger.Error("failed to compact jobs file", "path", q.opts.Path, "error", err)
+		return err
+	}
+
+	if q.journal != nil {
+		q.journal.Close()
+	}
+	q.journal, err = os.OpenFile(q.opts.Path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		q.journal = nil
+		return fmt.Errorf("failed to open jobs file: %w", err)
+	}
+	q.entries = 1
+
+	return nil
+}
@@ -0,0 +1,270 @@
+package queue
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/imariom/products-api/tracing"
+)
+
+// work runs the jobs of the queue as they become due.
+func (q *Queue) work() {
+	defer q.wg.Done()
+
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+
+	for {
+		select {
+		case <-q.stop:
+			return
+		default:
+		}
+
+		j, h, wait := q.next()
+		if j != nil {
+			q.run(j, h)
+			continue
+		}
+
+		if wait > 0 {
+			timer.Reset(wait)
+		}
+
+		select {
+		case <-q.wake:
+		case <-timer.C:
+		case <-q.stop:
+			timer.Stop()
+			return
+		}
+		timer.Stop()
+	}
+}
+
+// next returns the next due job and its handler, marking it running, or
+// the time until the next one is due (zero if none is). The handler is
+// nil for jobs of a kind no longer registered.
+func (q *Queue) next() (*Job, Handler, time.Duration) {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	j := q.peekLocked()
+	if j == nil {
+		return nil, nil, 0
+	}
+
+	if wait := time.Until(*j.RunAt); wait > 0 {
+		return nil, nil, wait
+	}
+	heap.Pop(&q.due)
+
+	// let another worker take the next one
+	if other := q.peekLocked(); other != nil && !other.RunAt.After(time.Now()) {
+		q.notify()
+	}
+
+	j.Status = StatusRunning
+	j.RunAt = nil
+	q.running[j.ID] = true
+	q.saveLocked(j)
+
+	c := copyJob(j)
+	return &c, q.handlers[j.Kind], 0
+}
+
+// peekLocked returns the pending job running next, if any, dropping the
+// stale entries before it.
+func (q *Queue) peekLocked() *Job {
+	for len(q.due) > 0 {
+		d := q.due[0]
+		if j, ok := q.jobs[d.id]; ok && j.Status == StatusPending && j.RunAt.Equal(d.runAt) {
+			return j
+		}
+		heap.Pop(&q.due)
+	}
+
+	return nil
+}
+
+// scheduleLocked schedules the pending job j to run at j.RunAt.
+func (q *Queue) scheduleLocked(j *Job) {
+	heap.Push(&q.due, dueJob{id: j.ID, runAt: *j.RunAt})
+}
+
+// dueJob is a pending job, scheduled to run at runAt.
+type dueJob struct {
+	id    uint64
+	runAt time.Time
+}
+
+// dueJobs is a heap of the pending jobs, the first due at the top.
+type dueJobs []dueJob
+
+func (h dueJobs) Len() int { return len(h) }
+
+func (h dueJobs) Less(i, j int) bool {
+	if !h[i].runAt.Equal(h[j].runAt) {
+		return h[i].runAt.Before(h[j].runAt)
+	}
+	return h[i].id < h[j].id
+}
+
+func (h dueJobs) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *dueJobs) Push(x interface{}) { *h = append(*h, x.(dueJob)) }
+
+func (h *dueJobs) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// run runs j with h and records the outcome. A panicking handler fails
+// the attempt.
+func (q *Queue) run(j *Job, h Handler) {
+	ctx := context.WithValue(q.ctx, jobKey{}, j)
+	if sc, err := tracing.ParseTraceparent(j.Traceparent); err == nil {
+		ctx = tracing.ContextWithRemoteSpanContext(ctx, sc)
+	}
+
+	ctx, span := tracing.Start(ctx, "job",
+		tracing.WithAttributes(
+			"job.id", j.ID,
+			"job.kind", j.Kind,
+			"job.attempt", len(j.Attempts)+1,
+		))
+	defer span.End()
+
+	timeout := q.opts.Timeout
+	if j.TimeoutSeconds > 0 {
+		timeout = time.Duration(j.TimeoutSeconds) * time.Second
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := func() (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("panic: %v", r)
+			}
+		}()
+
+		if h == nil {
+			return Permanent(fmt.Errorf("no handler for job kind '%s'", j.Kind))
+		}
+		return h(ctx, j.Payload)
+	}()
+	elapsed := time.Since(start)
+	jobDuration.WithLabelValues(j.Kind).Observe(elapsed.Seconds())
+
+	a := JobAttempt{
+		Time:     start.UTC(),
+		Duration: float64(elapsed) / float64(time.Millisecond),
+	}
+	if err != nil {
+		a.Error = err.Error()
+		span.RecordError(err)
+	}
+
+	var permanent *permanentError
+	q.record(j.ID, a, errors.As(err, &permanent))
+}
+
+// record adds the attempt a to the job id, finishing it when it
+// succeeded or failed for the last time, or scheduling a retry. The
+// attempts of the jobs queued again by Stop are dropped.
+func (q *Queue) record(id uint64, a JobAttempt, permanent bool) {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	if !q.running[id] {
+		return
+	}
+	delete(q.running, id)
+
+	j := q.jobs[id]
+	j.Attempts = append(j.Attempts, a)
+
+	maxAttempts := q.opts.MaxAttempts
+	if j.MaxAttempts > 0 {
+		maxAttempts = j.MaxAttempts
+	}
+	now := time.Now().UTC()
+
+	switch {
+	case a.Error == "":
+		jobRuns.WithLabelValues(j.Kind, "succeeded").Inc()
+		q.finishLocked(j, StatusSucceeded, now)
+
+	case permanent || len(j.Attempts) >= maxAttempts:
+		jobRuns.WithLabelValues(j.Kind, "dead").Inc()
+		q.finishLocked(j, StatusDead, now)
+		q.logger.Warn("job moved to the dead letters",
+			"job_id", j.ID,
+			"kind", j.Kind,
+			"attempts", len(j.Attempts),
+			"error", a.Error,
+		)
+
+	default:
+		jobRuns.WithLabelValues(j.Kind, "retried").Inc()
+		next := now.Add(q.backoff(len(j.Attempts)))
+		j.Status = StatusPending
+		j.RunAt = &next
+		q.scheduleLocked(j)
+		q.logger.Debug("job will be retried",
+			"job_id", j.ID,
+			"kind", j.Kind,
+			"next_attempt", next,
+			"error", a.Error,
+		)
+	}
+
+	q.saveLocked(j)
+	q.notify()
+}
+
+// backoff returns the delay before the retry following the failed
+// attempt n: MinBackoff doubled after each attempt up to MaxBackoff,
+// minus up to 10% so failed jobs do not retry in lockstep.
+func (q *Queue) backoff(n int) time.Duration {
+	delay := q.opts.MinBackoff
+	for i := 1; i < n && delay < q.opts.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > q.opts.MaxBackoff {
+		delay = q.opts.MaxBackoff
+	}
+
+	return delay - time.Duration(rand.Int63n(int64(delay)/10+1))
+}
+
+// finishLocked sets the final status of j and drops the oldest
+// succeeded jobs beyond the size of the log. Dead jobs are kept.
+func (q *Queue) finishLocked(j *Job, status string, now time.Time) {
+	j.Status = status
+	j.RunAt = nil
+	j.FinishedAt = &now
+	jobsQueued.Dec()
+	delete(q.queued, j.UniqueKey)
+
+	if status != StatusSucceeded {
+		return
+	}
+
+	q.succeeded = append(q.succeeded, j.ID)
+	for len(q.succeeded) > q.opts.LogSize {
+		id := q.succeeded[0]
+		q.succeeded = q.succeeded[1:]
+		delete(q.jobs, id)
+		q.saveDeletedLocked(id)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/imariom/products-api/apiversion"
+	"github.com/imariom/products-api/handlers"
+	"github.com/imariom/products-api/logging"
+	"github.com/imariom/products-api/metrics"
+	"github.com/imariom/products-api/middleware"
+	"github.com/imariom/products-api/openapi"
+)
+
+// apiHandlers are the handlers of the resources of the API.
+type apiHandlers struct {
+	Product   http.Handler
+	Cart      http.Handler
+	GuestCart http.Handler
+	Users     http.Handler
+	Catalog   http.Handler
+	Trash     http.Handler
+	GraphQL   http.Handler
+	Events    http.Handler
+	Webhooks  http.Handler
+	Integrity http.Handler
+	Jobs      http.Handler
+	Queue     http.Handler
+	Audit     http.Handler
+	Health    http.Handler
+}
+
+// routerOptions configures the routing of the API.
+type routerOptions struct {
+	// AdminTokens are the bearer tokens of the admin endpoints, open
+	// when empty, and of the webhooks and the audit log, never open.
+	AdminTokens []string
+
+	// BatchMaxOperations limits the operations of a batch.
+	BatchMaxOperations int
+}
+
+// newRouter returns the handler routing the requests to the handlers
+// of h, on the paths of handlers.Routes, which the OpenAPI document is
+// generated from. The resources are served under the version prefixes
+// too.
+func newRouter(l *logging.Logger, h apiHandlers, opts routerOptions) (http.Handler, error) {
+	mux := http.NewServeMux()
+	mux.Handle("/products/", h.Product)
+	mux.Handle("/products", h.Product)
+	mux.Handle("/products:import", h.Catalog)
+	mux.Handle("/products:import/", h.Catalog)
+	mux.Handle("/products:export", h.Catalog)
+
+	mux.Handle("/carts/", h.Cart)
+	mux.Handle("/carts", h.Cart)
+	mux.Handle("/carts/guest", h.GuestCart)
+	mux.Handle("/carts/guest:merge", h.GuestCart)
+
+	mux.Handle("/users/", h.Users)
+	mux.Handle("/users", h.Users)
+
+	mux.Handle("/trash", h.Trash)
+
+	mux.Handle("/graphql", h.GraphQL)
+
+	mux.Handle("/events", h.Events)
+
+	// admin endpoints
+	adminAuth := middleware.BearerAuth(opts.AdminTokens)
+
+	// the webhooks and the audit log are never open, they require the
+	// admin tokens: subscribers get every change, and the requests to
+	// their URLs come from the server
+	requireAuth := middleware.RequireBearerAuth(opts.AdminTokens)
+	mux.Handle("/admin/webhooks", requireAuth(h.Webhooks))
+	mux.Handle("/admin/webhooks/", requireAuth(h.Webhooks))
+
+	mux.Handle("/admin/integrity", adminAuth(h.Integrity))
+	mux.Handle("/admin/jobs", adminAuth(h.Jobs))
+	mux.Handle("/admin/jobs/", adminAuth(h.Jobs))
+	mux.Handle("/admin/queue", adminAuth(h.Queue))
+	mux.Handle("/admin/queue/", adminAuth(h.Queue))
+
+	mux.Handle("/audit", requireAuth(h.Audit))
+	mux.Handle("/audit:export", requireAuth(h.Audit))
+	mux.Handle("/audit:verify", requireAuth(h.Audit))
+
+	mux.Handle("/healthz", h.Health)
+	mux.Handle("/readyz", h.Health)
+	mux.Handle("/status", h.Health)
+
+	mux.Handle("/metrics", metrics.Handler(metrics.Default))
+
+	mux.Handle("/openapi.json", openapi.Handler(openapi.Build(handlers.APIInfo, handlers.Routes)))
+	mux.Handle("/docs", openapi.DocsHandler("/openapi.json"))
+
+	// serve the API resources under /v1, /v2, ... too
+	versions, err := apiversion.NewRouter(middleware.TraceRouting(mux), apiversion.Options{
+		Versions: handlers.Versions,
+		Default:  handlers.DefaultVersion,
+		Prefixes: handlers.VersionedPrefixes,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// batch operations are served like the other requests, traced,
+	// logged and measured one by one. They run as the user of the batch
+	// request, whose Idempotency-Key covers them all: a key of their
+	// own would replay the operations of the transactions rolled back.
+	operations := middleware.Chain(versions,
+		middleware.Tracing,
+		middleware.Logging(l),
+		middleware.Metrics,
+	)
+	mux.Handle("/batch", handlers.NewBatch(l, operations, handlers.BatchOptions{
+		MaxOperations: opts.BatchMaxOperations,
+	}))
+
+	return versions, nil
+}
+
+// routeTemplates returns the templates of the routes served by the
+// router, the resources under every version prefix too, which the
+// requests are labelled with (see middleware.SetRoutes).
+func routeTemplates() []string {
+	templates := []string{"/docs"}
+	seen := map[string]bool{}
+
+	for _, route := range handlers.Routes {
+		if seen[route.Path] {
+			continue
+		}
+		seen[route.Path] = true
+		templates = append(templates, route.Path)
+
+		for _, prefix := range handlers.VersionedPrefixes {
+			if route.Path != prefix && !strings.HasPrefix(route.Path, prefix+"/") && !strings.HasPrefix(route.Path, prefix+":") {
+				continue
+			}
+
+			for _, v := range handlers.Versions {
+				templates = append(templates, "/"+v.Name+route.Path)
+			}
+			break
+		}
+	}
+
+	return templates
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/imariom/products-api/audit"
+	"github.com/imariom/products-api/handlers"
+	"github.com/imariom/products-api/logging"
+	"github.com/imariom/products-api/middleware"
+	"github.com/imariom/products-api/queue"
+	"github.com/imariom/products-api/scheduler"
+	"github.com/imariom/products-api/webhooks"
+)
+
+// testRouter returns the router of main, with handlers backed by
+// in-memory stores.
+func testRouter(t *testing.T, adminTokens []string) http.Handler {
+	t.Helper()
+
+	l := logging.New(io.Discard, logging.Options{})
+
+	q, err := queue.New(queue.Options{Logger: l})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := webhooks.New(webhooks.Options{Queue: q, Logger: l})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := audit.Open(audit.Options{Logger: l})
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := handlers.NewGraphQL(l, handlers.GraphQLOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router, err := newRouter(l, apiHandlers{
+		Product:   handlers.NewProduct(l),
+		Cart:      handlers.NewCart(l),
+		GuestCart: handlers.NewGuestCart(l, handlers.GuestCartOptions{Key: []byte("test"), TTL: time.Hour}),
+		Users:     handlers.NewUser(l),
+		Catalog:   handlers.NewCatalog(l, q),
+		Trash:     handlers.NewTrash(l),
+		GraphQL:   g,
+		Events:    handlers.NewEvents(l, handlers.EventsOptions{}),
+		Webhooks:  handlers.NewWebhooks(l, d),
+		Integrity: handlers.NewIntegrity(l),
+		Jobs:      handlers.NewJobs(l, scheduler.New(l)),
+		Queue:     handlers.NewQueue(l, q),
+		Audit:     handlers.NewAudit(l, a),
+		Health:    handlers.NewHealth(l, handlers.BuildInfo{}),
+	}, routerOptions{AdminTokens: adminTokens})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return router
+}
+
+// routePath returns the path of a route with its parameters filled in.
+func routePath(path string) string {
+	return strings.NewReplacer(
+		"{startdate}", "2020-01-01",
+		"{enddate}", "2020-12-31",
+		"{category}", "electronics",
+		"{name}", "trash-purge",
+		"{userId}", "1",
+		"{productId}", "1",
+		"{id}", "1",
+	).Replace(path)
+}
+
+// TestRouterServesRoutes checks that the router serves every route of
+// handlers.Routes, which the OpenAPI document is generated from: a
+// route missing from the router would be answered by the 404 of the
+// multiplexer, and a method the handler does not implement with 501.
+func TestRouterServesRoutes(t *testing.T) {
+	router := testRouter(t, []string{"test"})
+
+	for _, route := range handlers.Routes {
+		paths := []string{routePath(route.Path)}
+		for _, prefix := range handlers.VersionedPrefixes {
+			if strings.HasPrefix(route.Path, prefix) {
+				paths = append(paths, "/"+handlers.DefaultVersion+paths[0])
+				break
+			}
+		}
+
+		for _, path := range paths {
+			t.Run(route.Method+" "+path, func(t *testing.T) {
+				// the event streams are served until the request ends
+				ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+				defer cancel()
+
+				r := httptest.NewRequest(route.Method, path, strings.NewReader("{}")).WithContext(ctx)
+				r.Header.Set("Content-Type", "application/json")
+				r.Header.Set("Authorization", "Bearer test")
+				rw := httptest.NewRecorder()
+
+				router.ServeHTTP(rw, r)
+
+				switch {
+				case rw.Code == http.StatusNotFound && rw.Body.String() == "404 page not found\n":
+					t.Errorf("not routed: %d %s", rw.Code, rw.Body)
+				case rw.Code == http.StatusNotImplemented || rw.Code == http.StatusMethodNotAllowed:
+					t.Errorf("method not served: %d %s", rw.Code, rw.Body)
+				}
+			})
+		}
+	}
+}
+
+// TestRouterNotFound checks that the paths missing from the routes are
+// not served, so that the check of TestRouterServesRoutes holds.
+func TestRouterNotFound(t *testing.T) {
+	router := testRouter(t, []string{"test"})
+
+	tests := []struct {
+		method string
+		path   string
+		code   int
+	}{
+		{http.MethodGet, "/unknown", http.StatusNotFound},
+		{http.MethodGet, "/admin/unknown", http.StatusNotFound},
+		{http.MethodPut, "/trash", http.StatusNotImplemented},
+		{http.MethodGet, "/v9/products", http.StatusNotFound},
+		{http.MethodPost, "/carts/18446744073709551616/items", http.StatusNotFound},
+		{http.MethodPatch, "/carts/1/items/18446744073709551616", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.path, nil)
+			rw := httptest.NewRecorder()
+
+			router.ServeHTTP(rw, r)
+
+			if rw.Code != tt.code {
+				t.Errorf("got %d %s, want %d", rw.Code, rw.Body, tt.code)
+			}
+		})
+	}
+}
+
+// TestRouterAdminAuth checks that the admin endpoints require the admin
+// tokens, and that the webhooks and the audit log are refused without
+// any.
+func TestRouterAdminAuth(t *testing.T) {
+	tests := []struct {
+		name   string
+		tokens []string
+		path   string
+		auth   string
+		code   int
+	}{
+		{"admin without token", []string{"test"}, "/admin/jobs", "", http.StatusUnauthorized},
+		{"admin with token", []string{"test"}, "/admin/jobs", "Bearer test", http.StatusOK},
+		{"admin open", nil, "/admin/jobs", "", http.StatusOK},
+		{"audit with token", []string{"test"}, "/audit", "Bearer test", http.StatusOK},
+		{"audit without tokens", nil, "/audit", "", http.StatusUnauthorized},
+		{"webhooks with token", []string{"test"}, "/admin/webhooks", "Bearer test", http.StatusOK},
+		{"webhooks without tokens", nil, "/admin/webhooks", "", http.StatusUnauthorized},
+		{"webhook deliveries without tokens", nil, "/admin/webhooks/deliveries", "", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := testRouter(t, tt.tokens)
+
+			r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.auth != "" {
+				r.Header.Set("Authorization", tt.auth)
+			}
+			rw := httptest.NewRecorder()
+
+			router.ServeHTTP(rw, r)
+
+			if rw.Code != tt.code {
+				t.Errorf("got %d %s, want %d", rw.Code, rw.Body, tt.code)
+			}
+		})
+	}
+}
+
+// TestRouteTemplates checks that the requests are labelled with the
+// routes of handlers.Routes they are served by.
+func TestRouteTemplates(t *testing.T) {
+	middleware.SetRoutes(routeTemplates())
+	t.Cleanup(func() { middleware.SetRoutes(nil) })
+
+	for _, route := range handlers.Routes {
+		templates := []string{route.Path}
+		for _, prefix := range handlers.VersionedPrefixes {
+			if strings.HasPrefix(route.Path, prefix) {
+				templates = append(templates, "/"+handlers.DefaultVersion+route.Path)
+				break
+			}
+		}
+
+		for _, template := range templates {
+			if got := middleware.RouteTemplate(routePath(template)); got != template {
+				t.Errorf("RouteTemplate(%q) = %q, want %q", routePath(template), got, template)
+			}
+		}
+	}
+
+	for _, path := range []string{"/unknown", "/products/1/unknown", "/v9/products"} {
+		if got := middleware.RouteTemplate(path); got != middleware.UnmatchedRoute {
+			t.Errorf("RouteTemplate(%q) = %q, want %q", path, got, middleware.UnmatchedRoute)
+		}
+	}
+}
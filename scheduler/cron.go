@@ -0,0 +1,190 @@
+package scheduler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronMacros are the shorthands of common cron expressions.
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// cronField is the range of the values of a field of a cron expression.
+type cronField struct {
+	name     string
+	min, max int
+}
+
+var cronFields = []cronField{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 6},
+}
+
+// Cron is a parsed cron expression: minute, hour, day of month, month
+// and day of week, each a set of values.
+type Cron struct {
+	spec string
+
+	// a bit is set for each value of the field
+	minute, hour, dom, month, dow uint64
+
+	// a day matches if either the day of month or the day of week does,
+	// when both are restricted
+	domAny, dowAny bool
+}
+
+// ParseCron parses a cron expression with the five standard fields,
+// e.g. "*/15 * * * *" or "0 3 * * 1-5", or one of the macros @hourly,
+// @daily (or @midnight), @weekly, @monthly and @yearly (or @annually).
+// Fields are "*", values, ranges ("1-5") and steps ("*/2", "1-9/2"),
+// separated by commas. Days of week go from 0 (Sunday) to 6, and 7 is
+// Sunday too.
+func ParseCron(spec string) (*Cron, error) {
+	expr := strings.TrimSpace(spec)
+	if m, ok := cronMacros[expr]; ok {
+		expr = m
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != len(cronFields) {
+		return nil, fmt.Errorf("invalid cron expression %q: expected %d fields", spec, len(cronFields))
+	}
+
+	c := &Cron{spec: spec}
+	bits := []*uint64{&c.minute, &c.hour, &c.dom, &c.month, &c.dow}
+	for i, f := range fields {
+		field := cronFields[i]
+		if i == 4 {
+			// 7 is also Sunday
+			field.max = 7
+		}
+
+		b, err := parseCronField(f, field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron expression %q: %v", spec, err)
+		}
+		*bits[i] = b
+	}
+
+	if c.dow&(1<<7) != 0 {
+		c.dow = c.dow&^(1<<7) | 1
+	}
+	c.domAny = strings.HasPrefix(fields[2], "*")
+	c.dowAny = strings.HasPrefix(fields[4], "*")
+
+	return c, nil
+}
+
+// parseCronField returns the values of the field f as a bit set.
+func parseCronField(f string, field cronField) (uint64, error) {
+	var bits uint64
+
+	for _, part := range strings.Split(f, ",") {
+		expr, step := part, 1
+		if i := strings.IndexByte(part, '/'); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step in %s field %q", field.name, part)
+			}
+			expr, step = part[:i], n
+		}
+
+		lo, hi := field.min, field.max
+		switch {
+		case expr == "*":
+		case strings.Contains(expr, "-"):
+			from, to, _ := strings.Cut(expr, "-")
+			var err1, err2 error
+			lo, err1 = strconv.Atoi(from)
+			hi, err2 = strconv.Atoi(to)
+			if err1 != nil || err2 != nil {
+				return 0, fmt.Errorf("invalid range in %s field %q", field.name, part)
+			}
+		default:
+			n, err := strconv.Atoi(expr)
+			if err != nil {
+				return 0, fmt.Errorf("invalid value in %s field %q", field.name, part)
+			}
+			lo = n
+			if step == 1 {
+				hi = n
+			}
+		}
+
+		if lo < field.min || hi > field.max || lo > hi {
+			return 0, fmt.Errorf("%s field %q out of range %d-%d", field.name, part, field.min, field.max)
+		}
+
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+
+	return bits, nil
+}
+
+// String returns the expression c was parsed from.
+func (c *Cron) String() string {
+	return c.spec
+}
+
+// Next returns the first time matching c after t, or the zero time if
+// there is none within five years (e.g. for February 30). Local times
+// skipped by a change to daylight saving time never match, and the ones
+// repeated by a change from it match twice.
+func (c *Cron) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		switch {
+		case c.month&(1<<uint(t.Month())) == 0:
+			t = later(t, time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location()))
+		case !c.matchesDay(t):
+			t = later(t, time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location()))
+		case c.hour&(1<<uint(t.Hour())) == 0:
+			t = later(t, time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location()))
+		case c.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+
+	return time.Time{}
+}
+
+// later returns next, or the minute after t if next is not after it:
+// time.Date moves the local times skipped by a change to daylight saving
+// time back to the previous offset, e.g. 2:00 to 1:00 in New York.
+func later(t, next time.Time) time.Time {
+	if next.After(t) {
+		return next
+	}
+
+	return t.Add(time.Minute)
+}
+
+// matchesDay reports whether the day of t matches the day of month and
+// the day of week of c.
+func (c *Cron) matchesDay(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+
+	if c.domAny || c.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
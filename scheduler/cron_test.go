@@ -0,0 +1,183 @@
+package scheduler
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	date := func(year int, month time.Month, day, hour, min int) time.Time {
+		return time.Date(year, month, day, hour, min, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		spec string
+		from time.Time
+		want time.Time
+	}{
+		{"* * * * *", date(2024, 3, 1, 10, 7), date(2024, 3, 1, 10, 8)},
+		{"*/15 * * * *", date(2024, 3, 1, 10, 7), date(2024, 3, 1, 10, 15)},
+		// seconds are ignored and t itself is never returned
+		{"*/15 * * * *", date(2024, 3, 1, 10, 14).Add(59900 * time.Millisecond), date(2024, 3, 1, 10, 15)},
+		{"*/15 * * * *", date(2024, 3, 1, 10, 15), date(2024, 3, 1, 10, 30)},
+		{"5-9/2 * * * *", date(2024, 3, 1, 10, 5), date(2024, 3, 1, 10, 7)},
+		{"5/20 * * * *", date(2024, 3, 1, 10, 26), date(2024, 3, 1, 10, 45)},
+		{"0,30 9-17 * * *", date(2024, 3, 1, 17, 30), date(2024, 3, 2, 9, 0)},
+		{"@hourly", date(2024, 3, 1, 10, 0), date(2024, 3, 1, 11, 0)},
+		{"@daily", date(2024, 12, 31, 23, 59), date(2025, 1, 1, 0, 0)},
+		{"@yearly", date(2024, 6, 1, 0, 0), date(2025, 1, 1, 0, 0)},
+		{"@monthly", date(2024, 1, 31, 12, 0), date(2024, 2, 1, 0, 0)},
+		// 2024-03-01 is a Friday
+		{"0 3 * * 1-5", date(2024, 3, 1, 4, 0), date(2024, 3, 4, 3, 0)},
+		{"@weekly", date(2024, 3, 4, 0, 0), date(2024, 3, 10, 0, 0)},
+		{"0 0 * * 7", date(2024, 3, 4, 0, 0), date(2024, 3, 10, 0, 0)},
+		{"0 0 * * 5,7", date(2024, 3, 8, 0, 0), date(2024, 3, 10, 0, 0)},
+		// days match either field when both are restricted
+		{"0 0 13 * 5", date(2024, 3, 1, 0, 0), date(2024, 3, 8, 0, 0)},
+		{"0 0 13 * 5", date(2024, 3, 9, 0, 0), date(2024, 3, 13, 0, 0)},
+		// and both when one of them is not
+		{"0 0 */2 * 5", date(2024, 3, 1, 0, 0), date(2024, 3, 15, 0, 0)},
+		{"30 23 31 * *", date(2024, 4, 1, 0, 0), date(2024, 5, 31, 23, 30)},
+		{"0 12 1 1,7 *", date(2024, 2, 1, 0, 0), date(2024, 7, 1, 12, 0)},
+		{"0 0 29 2 *", date(2023, 3, 1, 0, 0), date(2024, 2, 29, 0, 0)},
+		{"0 0 30 2 *", date(2024, 1, 1, 0, 0), time.Time{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec+" "+tt.from.Format(time.RFC3339), func(t *testing.T) {
+			c, err := ParseCron(tt.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := c.Next(tt.from); !got.Equal(tt.want) {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestCronNextDST checks the times skipped and repeated by the changes
+// of daylight saving time.
+func TestCronNextDST(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("no time zone database: %v", err)
+	}
+	santiago, err := time.LoadLocation("America/Santiago")
+	if err != nil {
+		t.Skipf("no time zone database: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		spec string
+		from time.Time
+		want time.Time
+	}{
+		{
+			// 2:00 to 2:59 do not exist on 2024-03-10
+			name: "skipped hour",
+			spec: "30 2 * * *",
+			from: time.Date(2024, 3, 10, 1, 0, 0, 0, newYork),
+			want: time.Date(2024, 3, 11, 2, 30, 0, 0, newYork),
+		},
+		{
+			name: "after the skipped hour",
+			spec: "0 3 * * *",
+			from: time.Date(2024, 3, 10, 1, 0, 0, 0, newYork),
+			want: time.Date(2024, 3, 10, 3, 0, 0, 0, newYork),
+		},
+		{
+			// 1:00 to 1:59 happen twice on 2024-11-03
+			name: "repeated hour",
+			spec: "30 1 * * *",
+			from: time.Date(2024, 11, 3, 0, 0, 0, 0, newYork),
+			want: time.Date(2024, 11, 3, 5, 30, 0, 0, time.UTC),
+		},
+		{
+			name: "second time of the repeated hour",
+			spec: "30 1 * * *",
+			from: time.Date(2024, 11, 3, 5, 30, 0, 0, time.UTC).In(newYork),
+			want: time.Date(2024, 11, 3, 6, 30, 0, 0, time.UTC),
+		},
+		{
+			// midnight does not exist on 2024-09-08 in Santiago
+			name: "skipped midnight",
+			spec: "@daily",
+			from: time.Date(2024, 9, 7, 12, 0, 0, 0, santiago),
+			want: time.Date(2024, 9, 9, 0, 0, 0, 0, santiago),
+		},
+		{
+			name: "after the skipped midnight",
+			spec: "0 1 * * *",
+			from: time.Date(2024, 9, 7, 12, 0, 0, 0, santiago),
+			want: time.Date(2024, 9, 8, 1, 0, 0, 0, santiago),
+		},
+		{
+			name: "after the repeated hour",
+			spec: "0 2 * * *",
+			from: time.Date(2024, 11, 3, 0, 0, 0, 0, newYork),
+			want: time.Date(2024, 11, 3, 2, 0, 0, 0, newYork),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := ParseCron(tt.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := c.Next(tt.from); !got.Equal(tt.want) {
+				t.Errorf("got %s, want %s", got, tt.want.In(tt.from.Location()))
+			}
+		})
+	}
+}
+
+func TestParseCronErrors(t *testing.T) {
+	tests := []struct {
+		spec string
+		want string
+	}{
+		{"", "expected 5 fields"},
+		{"* * * *", "expected 5 fields"},
+		{"* * * * * *", "expected 5 fields"},
+		{"@reboot", "expected 5 fields"},
+		{"60 * * * *", `minute field "60" out of range 0-59`},
+		{"* 24 * * *", `hour field "24" out of range 0-23`},
+		{"* * 0 * *", `day of month field "0" out of range 1-31`},
+		{"* * * 13 *", `month field "13" out of range 1-12`},
+		{"* * * * 8", `day of week field "8" out of range 0-7`},
+		{"5-1 * * * *", `minute field "5-1" out of range 0-59`},
+		{"50-70 * * * *", `minute field "50-70" out of range 0-59`},
+		{"*/0 * * * *", `invalid step in minute field "*/0"`},
+		{"*/x * * * *", `invalid step in minute field "*/x"`},
+		{"1-x * * * *", `invalid range in minute field "1-x"`},
+		{"-1 * * * *", `invalid range in minute field "-1"`},
+		{"x * * * *", `invalid value in minute field "x"`},
+		{"1,,2 * * * *", `invalid value in minute field ""`},
+		{"* * * JAN *", `invalid value in month field "JAN"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			_, err := ParseCron(tt.spec)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v, want %s", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCronString(t *testing.T) {
+	for _, spec := range []string{"*/5 * * * *", "@daily"} {
+		c, err := ParseCron(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.String() != spec {
+			t.Errorf("got %q, want %q", c.String(), spec)
+		}
+	}
+}
@@ -0,0 +1,11 @@
+package scheduler
+
+import "github.com/imariom/products-api/metrics"
+
+var (
+	jobRuns = metrics.NewCounterVec("scheduler_job_runs_total",
+		"Number of runs of the scheduled jobs by job and result (succeeded or failed).", "job", "result")
+
+	jobDuration = metrics.NewHistogramVec("scheduler_job_duration_seconds",
+		"Duration of the runs of the scheduled jobs.", nil, "job")
+)
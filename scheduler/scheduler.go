@@ -0,0 +1,264 @@
+// Package scheduler runs the recurring jobs of the server, such as the
+// purge of the trash, within its lifecycle: they start with the server
+// and the shutdown waits for the running ones to end. Jobs run at fixed
+// intervals or on cron schedules.
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/imariom/products-api/logging"
+)
+
+// Task is the work of a job. The context is cancelled when the
+// scheduler stops.
+type Task func(ctx context.Context) error
+
+// JobStatus is the status of a scheduled job.
+type JobStatus struct {
+	Name      string    `json:"name"`
+	Every     string    `json:"every,omitempty"`
+	Cron      string    `json:"cron,omitempty"`
+	State     string    `json:"state"` // stopped, idle or running
+	LastRun   time.Time `json:"last_run,omitempty"`
+	NextRun   time.Time `json:"next_run,omitempty"`
+	LastError string    `json:"last_error,omitempty"`
+	Runs      uint64    `json:"runs"`
+	Failures  uint64    `json:"failures"`
+}
+
+// job is a task run at the times returned by next.
+type job struct {
+	name string
+	next func(t time.Time) time.Time
+	task Task
+
+	mtx    sync.Mutex
+	status JobStatus
+}
+
+func (j *job) update(fn func(s *JobStatus)) {
+	j.mtx.Lock()
+	fn(&j.status)
+	j.mtx.Unlock()
+}
+
+// Scheduler runs jobs on their schedule, each in its own goroutine so
+// that a slow job does not delay the others. A job is not run again
+// while it is running.
+type Scheduler struct {
+	logger *logging.Logger
+
+	mtx  sync.Mutex
+	jobs map[string]*job
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// New returns a Scheduler without jobs. l defaults to logging.Default.
+func New(l *logging.Logger) *Scheduler {
+	if l == nil {
+		l = logging.Default
+	}
+
+	return &Scheduler{logger: l, jobs: map[string]*job{}}
+}
+
+// Every schedules task to run every interval under name, starting one
+// interval after Start. The interval must be positive. It must be
+// called before Start.
+func (s *Scheduler) Every(name string, interval time.Duration, task Task) error {
+	if interval <= 0 {
+		return fmt.Errorf("invalid interval of job '%s': %s, it must be positive", name, interval)
+	}
+
+	next := func(t time.Time) time.Time {
+		return t.Add(interval)
+	}
+
+	s.add(name, next, task, JobStatus{Every: interval.String()})
+	return nil
+}
+
+// Cron schedules task to run at the times matching the cron expression
+// spec (see ParseCron) under name. It must be called before Start.
+func (s *Scheduler) Cron(name, spec string, task Task) error {
+	c, err := ParseCron(spec)
+	if err != nil {
+		return err
+	}
+
+	s.add(name, c.Next, task, JobStatus{Cron: c.String()})
+	return nil
+}
+
+func (s *Scheduler) add(name string, next func(time.Time) time.Time, task Task, status JobStatus) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	status.Name = name
+	status.State = "stopped"
+	s.jobs[name] = &job{
+		name:   name,
+		next:   next,
+		task:   task,
+		status: status,
+	}
+}
+
+// Start starts running the jobs until Stop is called.
+func (s *Scheduler) Start(ctx context.Context) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if s.cancel != nil {
+		return fmt.Errorf("scheduler already started")
+	}
+
+	runCtx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+
+	now := time.Now()
+	for _, j := range s.jobs {
+		s.wg.Add(1)
+		go s.loop(runCtx, j, j.next(now))
+	}
+
+	return nil
+}
+
+// Stop stops the jobs, cancelling the context of the running ones and
+// waiting for them to return until ctx is done.
+func (s *Scheduler) Stop(ctx context.Context) error {
+	s.mtx.Lock()
+	cancel := s.cancel
+	s.mtx.Unlock()
+
+	if cancel == nil {
+		return nil
+	}
+	cancel()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Jobs returns the status of the jobs, sorted by name.
+func (s *Scheduler) Jobs() []JobStatus {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	jobs := make([]JobStatus, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		j.mtx.Lock()
+		jobs = append(jobs, j.status)
+		j.mtx.Unlock()
+	}
+
+	sort.Slice(jobs, func(a, b int) bool { return jobs[a].Name < jobs[b].Name })
+	return jobs
+}
+
+// Job returns the status of the job name.
+func (s *Scheduler) Job(name string) (JobStatus, bool) {
+	s.mtx.Lock()
+	j, ok := s.jobs[name]
+	s.mtx.Unlock()
+
+	if !ok {
+		return JobStatus{}, false
+	}
+
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+	return j.status, true
+}
+
+// loop runs j at next and the following times of its schedule until ctx
+// is cancelled.
+func (s *Scheduler) loop(ctx context.Context, j *job, next time.Time) {
+	defer s.wg.Done()
+
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+	defer timer.Stop()
+
+	for {
+		j.update(func(st *JobStatus) {
+			st.State = "idle"
+			st.NextRun = next
+		})
+
+		// a cron expression matching no date never runs
+		var due <-chan time.Time
+		if !next.IsZero() {
+			timer.Reset(time.Until(next))
+			due = timer.C
+		}
+
+		select {
+		case <-due:
+			start := time.Now()
+			s.run(ctx, j, start)
+			next = j.next(start)
+		case <-ctx.Done():
+			j.update(func(st *JobStatus) {
+				st.State = "stopped"
+				st.NextRun = time.Time{}
+			})
+			return
+		}
+	}
+}
+
+// run runs the task of j once, recording its outcome. A panicking task
+// fails its run without stopping the job.
+func (s *Scheduler) run(ctx context.Context, j *job, start time.Time) {
+	j.update(func(st *JobStatus) {
+		st.State = "running"
+		st.NextRun = time.Time{}
+	})
+
+	err := func() (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("panic: %v", r)
+			}
+		}()
+		return j.task(ctx)
+	}()
+
+	jobDuration.WithLabelValues(j.name).Observe(time.Since(start).Seconds())
+
+	result := "succeeded"
+	if err != nil {
+		result = "failed"
+		s.logger.Error("scheduled job failed", "job", j.name, "error", err)
+	}
+	jobRuns.WithLabelValues(j.name, result).Inc()
+
+	j.update(func(st *JobStatus) {
+		st.LastRun = start
+		st.Runs++
+		st.LastError = ""
+		if err != nil {
+			st.Failures++
+			st.LastError = err.Error()
+		}
+	})
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	// unixPrefix marks an address as a Unix domain socket path.
+	unixPrefix = "unix:"
+
+	// systemdAddr selects the sockets passed by systemd.
+	systemdAddr = "systemd"
+
+	// systemdFirstFD is the first file descriptor passed by systemd
+	// (SD_LISTEN_FDS_START).
+	systemdFirstFD = 3
+)
+
+// listen opens a listener for every configured address.
+func (s *Server) listen() ([]net.Listener, error) {
+	addrs := make([]string, 0, len(s.opts.Addrs)+1)
+	if s.opts.Addr != "" {
+		addrs = append(addrs, s.opts.Addr)
+	}
+	addrs = append(addrs, s.opts.Addrs...)
+
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("no address to listen on")
+	}
+
+	listeners := make([]net.Listener, 0, len(addrs))
+	for _, addr := range addrs {
+		ls, err := listenAddr(addr)
+		if err != nil {
+			closeListeners(listeners)
+			return nil, err
+		}
+
+		listeners = append(listeners, ls...)
+	}
+
+	return listeners, nil
+}
+
+// listenAddr opens the listener(s) described by a single address.
+func listenAddr(addr string) ([]net.Listener, error) {
+	switch {
+	case addr == systemdAddr:
+		return systemdListeners()
+
+	case strings.HasPrefix(addr, unixPrefix):
+		path := strings.TrimPrefix(addr, unixPrefix)
+
+		// remove a stale socket left by a previous run
+		if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+			os.Remove(path)
+		}
+
+		l, err := net.Listen("unix", path)
+		if err != nil {
+			return nil, err
+		}
+		return []net.Listener{l}, nil
+
+	default:
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		return []net.Listener{l}, nil
+	}
+}
+
+// systemdListeners returns the listeners inherited from systemd socket
+// activation, as described by the LISTEN_PID and LISTEN_FDS variables.
+func systemdListeners() ([]net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, fmt.Errorf("no sockets passed by systemd")
+	}
+
+	nfds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || nfds <= 0 {
+		return nil, fmt.Errorf("no sockets passed by systemd")
+	}
+
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	// the variables must not be inherited by child processes
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	listeners := make([]net.Listener, 0, nfds)
+	for i := 0; i < nfds; i++ {
+		name := "systemd"
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+
+		f := os.NewFile(uintptr(systemdFirstFD+i), name)
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			closeListeners(listeners)
+			return nil, fmt.Errorf("inherited socket %q is not a listener: %v", name, err)
+		}
+
+		listeners = append(listeners, l)
+	}
+
+	return listeners, nil
+}
+
+func closeListeners(listeners []net.Listener) {
+	for _, l := range listeners {
+		l.Close()
+	}
+}
@@ -2,62 +2,462 @@ package server
 
 import (
 	"context"
-	"log"
+	"crypto/tls"
+	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
+	"syscall"
 	"time"
+
+	"github.com/imariom/products-api/logging"
 )
 
+// defaultShutdownTimeout is the time given to pending requests and
+// shutdown hooks before the server is forcefully stopped.
+const defaultShutdownTimeout = 30 * time.Second
+
 // Options is a struct that contains all the options required to config
 // the server
 type Options struct {
-	Logger  *log.Logger
+	Logger  *logging.Logger
 	Addr    string
 	Handler http.Handler
+
+	// Addrs are additional addresses to listen on. Besides "host:port"
+	// TCP addresses it accepts "unix:/path/to/socket" for Unix domain
+	// sockets and "systemd" for the sockets inherited through systemd
+	// socket activation.
+	Addrs []string
+
+	// TLS enables HTTPS (and HTTP/2) when set, otherwise the server
+	// listens over plain HTTP.
+	TLS *TLSOptions
+
+	// HandleSignals makes the server shutdown gracefully when it
+	// receives an interrupt or SIGTERM.
+	HandleSignals bool
+
+	// ShutdownTimeout bounds the graceful shutdown when it is triggered
+	// by a signal or by the context given to Start.
+	ShutdownTimeout time.Duration
+
+	// DrainDelay is the time the server keeps accepting requests after
+	// Shutdown begins, so load balancers polling ShuttingDown (through a
+	// readiness endpoint) stop routing traffic before listeners close.
+	DrainDelay time.Duration
+
+	// GRPCAddr is the address of a separate listener serving
+	// GRPCHandler (e.g. a grpc.Server) over HTTP/2: with TLS when TLS is
+	// set, otherwise over cleartext HTTP/2 (h2c). It is disabled when
+	// empty.
+	GRPCAddr    string
+	GRPCHandler http.Handler
+}
+
+// Hook is a function run by the server at some point of its lifecycle,
+// e.g. to start or drain background workers and flush storage.
+type Hook func(ctx context.Context) error
+
+// Server is an HTTP server with an explicit lifecycle. It is created
+// with New, started with Start and stopped with Shutdown.
+type Server struct {
+	opts       *Options
+	logger     *logging.Logger
+	httpServer *http.Server
+	grpcServer *http.Server
+	reloader   *certReloader
+
+	// streamCtx is the base context of gRPC calls, cancelled when
+	// Shutdown begins so long-lived streams do not hold it off; its
+	// cause is http.ErrServerClosed
+	streamCtx    context.Context
+	cancelStream context.CancelCauseFunc
+
+	mtx           sync.Mutex
+	started       bool
+	shuttingDown  bool
+	listeners     []net.Listener
+	grpcListener  net.Listener
+	startHooks    []Hook
+	shutdownHooks []Hook
+	serveErr      error
+
+	draining chan struct{}
+	done     chan struct{}
+}
+
+// New allocates and configures a new Server from opts. No listener is
+// opened until Start is called.
+func New(opts *Options) (*Server, error) {
+	if opts.Handler == nil {
+		return nil, fmt.Errorf("server handler is required")
+	}
+
+	if opts.GRPCAddr != "" && opts.GRPCHandler == nil {
+		return nil, fmt.Errorf("gRPC handler is required to listen on %s", opts.GRPCAddr)
+	}
+
+	logger := opts.Logger
+	if logger == nil {
+		logger = logging.Default
+	}
+
+	s := &Server{
+		opts:   opts,
+		logger: logger,
+		httpServer: &http.Server{
+			Handler:      opts.Handler,
+			WriteTimeout: 5 * time.Second,
+			ReadTimeout:  10 * time.Second,
+			IdleTimeout:  120 * time.Second,
+			ErrorLog:     logger.StdLogger(logging.LevelWarn),
+		},
+		draining: make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	// config TLS certificates, reloading them when they change on disk
+	if opts.TLS != nil {
+		reloader, err := newCertReloader(opts.TLS, logger)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load TLS certificates: %v", err)
+		}
+		s.reloader = reloader
+
+		s.httpServer.TLSConfig = reloader.tlsConfig()
+		if opts.TLS.DisableHTTP2 {
+			s.httpServer.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+		}
+	}
+
+	if opts.GRPCAddr != "" {
+		s.newGRPCServer()
+	}
+
+	return s, nil
+}
+
+// newGRPCServer configures the server of the gRPC listener, which only
+// speaks HTTP/2. It has no write timeout since streams are long-lived.
+func (s *Server) newGRPCServer() {
+	s.streamCtx, s.cancelStream = context.WithCancelCause(context.Background())
+
+	s.grpcServer = &http.Server{
+		Handler:     s.opts.GRPCHandler,
+		IdleTimeout: 120 * time.Second,
+		ErrorLog:    s.logger.StdLogger(logging.LevelWarn),
+		Protocols:   new(http.Protocols),
+		BaseContext: func(net.Listener) context.Context {
+			return s.streamCtx
+		},
+	}
+
+	if s.reloader != nil {
+		config := s.reloader.tlsConfig()
+		config.NextProtos = []string{"h2"}
+		s.grpcServer.TLSConfig = config
+		s.grpcServer.Protocols.SetHTTP2(true)
+	} else {
+		s.grpcServer.Protocols.SetUnencryptedHTTP2(true)
+	}
 }
 
-// serverCreated is used to guarantee that only one instance
-// of the server is created.
-var serverCreated = false
+// OnStart registers a hook run by Start before the server accepts
+// connections. Hooks run in registration order.
+func (s *Server) OnStart(h Hook) {
+	s.mtx.Lock()
+	s.startHooks = append(s.startHooks, h)
+	s.mtx.Unlock()
+}
+
+// OnShutdown registers a hook run by Shutdown after the server stopped
+// accepting requests. Hooks run in reverse registration order, so
+// something registered early (e.g. storage) is flushed after the
+// things that depend on it (e.g. background workers) were drained.
+func (s *Server) OnShutdown(h Hook) {
+	s.mtx.Lock()
+	s.shutdownHooks = append(s.shutdownHooks, h)
+	s.mtx.Unlock()
+}
+
+// Start opens all listeners, runs the start hooks and serves requests
+// in background goroutines. It returns once the server is accepting
+// connections. When ctx is cancelled the server is shutdown gracefully.
+func (s *Server) Start(ctx context.Context) error {
+	s.mtx.Lock()
+	if s.started {
+		s.mtx.Unlock()
+		return fmt.Errorf("server instance already started")
+	}
+	s.started = true
+	startHooks := append([]Hook(nil), s.startHooks...)
+	s.mtx.Unlock()
+
+	listeners, err := s.listen()
+	if err != nil {
+		s.resetStarted()
+		return err
+	}
+
+	var grpcListener net.Listener
+	if s.grpcServer != nil {
+		grpcListener, err = net.Listen("tcp", s.opts.GRPCAddr)
+		if err != nil {
+			closeListeners(listeners)
+			s.resetStarted()
+			return err
+		}
+	}
+
+	for _, h := range startHooks {
+		if err := h(ctx); err != nil {
+			closeListeners(listeners)
+			if grpcListener != nil {
+				grpcListener.Close()
+			}
+			s.resetStarted()
+			return fmt.Errorf("start hook failed: %v", err)
+		}
+	}
+
+	s.mtx.Lock()
+	s.listeners = listeners
+	s.grpcListener = grpcListener
+	s.mtx.Unlock()
+
+	if s.reloader != nil {
+		go s.reloader.watch()
+	}
 
-func Run(opts *Options) {
-	// reject if server was already created
-	if serverCreated {
-		errMsg := "[ERROR] server instance already running"
-		opts.Logger.Panicln(errMsg)
+	for _, l := range listeners {
+		s.logger.Info("listening", "network", l.Addr().Network(), "addr", l.Addr().String())
+		go s.serve(s.httpServer, l)
 	}
 
-	// Config server options
-	server := &http.Server{
-		Addr:         opts.Addr,
-		Handler:      opts.Handler,
-		WriteTimeout: 5 * time.Second,
-		ReadTimeout:  10 * time.Second,
-		IdleTimeout:  120 * time.Second,
+	if grpcListener != nil {
+		s.logger.Info("listening", "network", "tcp", "addr", grpcListener.Addr().String(), "protocol", "grpc")
+		go s.serve(s.grpcServer, grpcListener)
 	}
 
-	// start server
+	if s.opts.HandleSignals {
+		var stop context.CancelFunc
+		ctx, stop = signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-s.done
+			stop()
+		}()
+	}
+
+	// shutdown once the context is done
 	go func() {
-		serverCreated = true
+		select {
+		case <-ctx.Done():
+			s.logger.Warn("received graceful shutdown - shutting down server", "reason", ctx.Err())
+			s.shutdownWithTimeout()
 
-		err := server.ListenAndServe()
-		if err != http.ErrServerClosed {
-			opts.Logger.Panicln("[PANIC] failed to start server instance:", err.Error())
-			serverCreated = false
+		case <-s.done:
 		}
 	}()
 
-	// config server for listening for shutdown signals (kill or interrupt)
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, os.Kill)
-	signal.Notify(sigChan, os.Interrupt)
+	return nil
+}
+
+// resetStarted allows Start to be retried after it failed.
+func (s *Server) resetStarted() {
+	s.mtx.Lock()
+	s.started = false
+	s.mtx.Unlock()
+}
+
+// serve accepts connections on l with srv until the server is
+// shutdown. If it fails for any other reason the whole server is
+// stopped.
+func (s *Server) serve(srv *http.Server, l net.Listener) {
+	var err error
+	if s.reloader != nil {
+		// certificates are served by the TLS config
+		err = srv.ServeTLS(l, "", "")
+	} else {
+		err = srv.Serve(l)
+	}
+
+	if err == nil || err == http.ErrServerClosed {
+		return
+	}
+
+	s.logger.Error("failed to serve", "addr", l.Addr().String(), "error", err)
+
+	s.mtx.Lock()
+	if s.serveErr == nil {
+		s.serveErr = err
+	}
+	s.mtx.Unlock()
+
+	go s.shutdownWithTimeout()
+}
+
+func (s *Server) shutdownWithTimeout() {
+	timeout := s.opts.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = defaultShutdownTimeout
+	}
+
+	// forcefully shutdown server after the timeout if there are pending jobs
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := s.Shutdown(ctx); err != nil {
+		s.logger.Error("failed to gracefully shutdown server", "error", err)
+	}
+}
+
+// Shutdown gracefully stops the server: it stops accepting connections,
+// waits for in-flight requests and then runs the shutdown hooks. If ctx
+// expires first the remaining connections are closed and its error is
+// returned. Calling Shutdown more than once is a no-op.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mtx.Lock()
+	if !s.started || s.shuttingDown {
+		s.mtx.Unlock()
+		return nil
+	}
+	s.shuttingDown = true
+	shutdownHooks := append([]Hook(nil), s.shutdownHooks...)
+	s.mtx.Unlock()
+
+	defer close(s.done)
+	close(s.draining)
+
+	if s.reloader != nil {
+		s.reloader.stop()
+	}
+
+	// keep serving while load balancers notice the server is draining
+	if s.opts.DrainDelay > 0 {
+		timer := time.NewTimer(s.opts.DrainDelay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+		}
+	}
+
+	var wg sync.WaitGroup
+	var grpcErr error
+	if s.grpcServer != nil {
+		// end the streams so their clients reconnect elsewhere
+		s.cancelStream(http.ErrServerClosed)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			grpcErr = s.grpcServer.Shutdown(ctx)
+			if grpcErr != nil {
+				s.grpcServer.Close()
+			}
+		}()
+	}
+
+	err := s.httpServer.Shutdown(ctx)
+	if err != nil {
+		s.httpServer.Close()
+	}
+
+	wg.Wait()
+	if err == nil {
+		err = grpcErr
+	}
+
+	for i := len(shutdownHooks) - 1; i >= 0; i-- {
+		if hookErr := shutdownHooks[i](ctx); hookErr != nil {
+			s.logger.Error("shutdown hook failed", "error", hookErr)
+			if err == nil {
+				err = hookErr
+			}
+		}
+	}
+
+	return err
+}
+
+// ShuttingDown reports whether Shutdown has begun.
+func (s *Server) ShuttingDown() bool {
+	select {
+	case <-s.draining:
+		return true
+	default:
+		return false
+	}
+}
+
+// Draining returns a channel closed when Shutdown begins.
+func (s *Server) Draining() <-chan struct{} {
+	return s.draining
+}
+
+// Wait blocks until the server is shutdown and returns the error that
+// caused a listener to fail, if any.
+func (s *Server) Wait() error {
+	<-s.done
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	return s.serveErr
+}
+
+// Addr returns the address of the first listener, which is useful to
+// find the port chosen when listening on port 0. It returns nil if the
+// server was not started.
+func (s *Server) Addr() net.Addr {
+	addrs := s.Addrs()
+	if len(addrs) == 0 {
+		return nil
+	}
 
-	// waitisten for gracefull shutdown signals
-	sig := <-sigChan
-	opts.Logger.Println("[WARNING] received graceful shutdown - shuting down server:", sig)
+	return addrs[0]
+}
+
+// Addrs returns the addresses of all listeners.
+func (s *Server) Addrs() []net.Addr {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	addrs := make([]net.Addr, 0, len(s.listeners))
+	for _, l := range s.listeners {
+		addrs = append(addrs, l.Addr())
+	}
+
+	return addrs
+}
+
+// GRPCAddr returns the address of the gRPC listener, nil if there is
+// none or the server was not started.
+func (s *Server) GRPCAddr() net.Addr {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if s.grpcListener == nil {
+		return nil
+	}
+
+	return s.grpcListener.Addr()
+}
+
+// Run creates and starts a server, blocking until it is shutdown.
+func Run(opts *Options) error {
+	s, err := New(opts)
+	if err != nil {
+		return err
+	}
+
+	if err := s.Start(context.Background()); err != nil {
+		return err
+	}
 
-	// forcefully shutdown server after 30 seconds if there are pending jobs
-	ctx, _ := context.WithTimeout(context.Background(), 30*time.Second)
-	server.Shutdown(ctx)
+	return s.Wait()
 }
@@ -0,0 +1,410 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/imariom/products-api/logging"
+)
+
+var hello = http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+	fmt.Fprint(rw, "hello")
+})
+
+func newServer(t *testing.T, opts Options) *Server {
+	t.Helper()
+
+	opts.Logger = logging.Discard
+	if opts.Handler == nil {
+		opts.Handler = hello
+	}
+	if opts.Addr == "" && len(opts.Addrs) == 0 {
+		opts.Addr = "127.0.0.1:0"
+	}
+
+	s, err := New(&opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Shutdown(context.Background()) })
+
+	return s
+}
+
+// get requests / through the listener at addr and returns the body.
+func get(addr net.Addr) (string, error) {
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, addr.Network(), addr.String())
+			},
+		},
+	}
+	defer client.CloseIdleConnections()
+
+	resp, err := client.Get("http://server/")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	return string(b), err
+}
+
+// TestLifecycle checks that a server serves on all of its listeners
+// and runs its hooks in order.
+func TestLifecycle(t *testing.T) {
+	s := newServer(t, Options{
+		Addr:  "127.0.0.1:0",
+		Addrs: []string{"unix:" + filepath.Join(t.TempDir(), "api.sock")},
+	})
+
+	var mtx sync.Mutex
+	var calls []string
+	hook := func(name string) Hook {
+		return func(ctx context.Context) error {
+			mtx.Lock()
+			calls = append(calls, name)
+			mtx.Unlock()
+			return nil
+		}
+	}
+	s.OnStart(hook("start workers"))
+	s.OnStart(hook("start webhooks"))
+	s.OnShutdown(hook("flush storage"))
+	s.OnShutdown(hook("drain workers"))
+
+	if s.Addr() != nil {
+		t.Errorf("got address %s before Start", s.Addr())
+	}
+
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(context.Background()); err == nil {
+		t.Error("started twice")
+	}
+
+	addrs := s.Addrs()
+	if len(addrs) != 2 || addrs[0].Network() != "tcp" || addrs[1].Network() != "unix" {
+		t.Fatalf("got addresses %v, want a TCP and a Unix listener", addrs)
+	}
+	if addr := s.Addr().(*net.TCPAddr); addr.Port == 0 {
+		t.Error("got port 0, want the port chosen")
+	}
+	for _, addr := range addrs {
+		if body, err := get(addr); err != nil || body != "hello" {
+			t.Errorf("%s: got %q, %v", addr, body, err)
+		}
+	}
+
+	if s.ShuttingDown() {
+		t.Error("shutting down before Shutdown")
+	}
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Errorf("got error %v shutting down twice", err)
+	}
+	if err := s.Wait(); err != nil {
+		t.Errorf("got error %v waiting", err)
+	}
+	if !s.ShuttingDown() {
+		t.Error("not shutting down after Shutdown")
+	}
+
+	want := []string{"start workers", "start webhooks", "drain workers", "flush storage"}
+	if strings.Join(calls, ", ") != strings.Join(want, ", ") {
+		t.Errorf("got hooks %v, want %v", calls, want)
+	}
+
+	for _, addr := range addrs {
+		if _, err := get(addr); err == nil {
+			t.Errorf("%s: served after Shutdown", addr)
+		}
+	}
+}
+
+func TestStartErrors(t *testing.T) {
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+
+	failing := func(ctx context.Context) error { return errors.New("no storage") }
+
+	tests := []struct {
+		name string
+		opts Options
+		hook Hook
+		want string
+	}{
+		{"address in use", Options{Addr: busy.Addr().String()}, nil, "address already in use"},
+		{"second address in use", Options{Addrs: []string{"127.0.0.1:0", busy.Addr().String()}}, nil, "address already in use"},
+		{"invalid address", Options{Addr: "127.0.0.1:http2"}, nil, "unknown port"},
+		{"failed hook", Options{}, failing, "start hook failed: no storage"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newServer(t, tt.opts)
+			if tt.hook != nil {
+				s.OnStart(tt.hook)
+			}
+
+			err := s.Start(context.Background())
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("got error %v, want %s", err, tt.want)
+			}
+			if s.Addr() != nil {
+				t.Errorf("got address %s after a failed Start", s.Addr())
+			}
+		})
+	}
+}
+
+// TestStartRetry checks that Start can be called again once it failed,
+// the listeners it opened being closed.
+func TestStartRetry(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	s := newServer(t, Options{Addr: addr})
+	fail := true
+	s.OnStart(func(ctx context.Context) error {
+		if fail {
+			fail = false
+			return errors.New("not ready")
+		}
+		return nil
+	})
+
+	if err := s.Start(context.Background()); err == nil {
+		t.Fatal("the start hook did not fail")
+	}
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatalf("got error %v starting again", err)
+	}
+	if body, err := get(s.Addr()); err != nil || body != "hello" {
+		t.Errorf("got %q, %v", body, err)
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		opts Options
+		want string
+	}{
+		{"no handler", Options{Addr: "127.0.0.1:0"}, "server handler is required"},
+		{"no gRPC handler", Options{Handler: hello, GRPCAddr: "127.0.0.1:0"}, "gRPC handler is required to listen on 127.0.0.1:0"},
+		{"missing certificate", Options{Handler: hello, TLS: &TLSOptions{CertFile: "missing.pem", KeyFile: "missing.pem"}}, "failed to load TLS certificates"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(&tt.opts)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v, want %s", err, tt.want)
+			}
+		})
+	}
+
+	s, err := New(&Options{Handler: hello, Logger: logging.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(context.Background()); err == nil || err.Error() != "no address to listen on" {
+		t.Errorf("got error %v, want no address to listen on", err)
+	}
+}
+
+// TestShutdown checks that the in-flight requests are served before the
+// shutdown hooks run, unless the context of Shutdown expires first.
+func TestShutdown(t *testing.T) {
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		want    error
+	}{
+		{"requests served", time.Second, nil},
+		{"timeout", 20 * time.Millisecond, context.DeadlineExceeded},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			started := make(chan struct{})
+			release := make(chan struct{})
+			s := newServer(t, Options{
+				Handler: http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+					close(started)
+					select {
+					case <-release:
+					case <-r.Context().Done():
+					}
+					fmt.Fprint(rw, "done")
+				}),
+			})
+
+			served := false
+			s.OnShutdown(func(ctx context.Context) error {
+				select {
+				case <-release:
+					served = true
+				default:
+				}
+				return nil
+			})
+
+			if err := s.Start(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+
+			result := make(chan error, 1)
+			go func() {
+				_, err := get(s.Addr())
+				result <- err
+			}()
+			<-started
+
+			if tt.want == nil {
+				time.AfterFunc(20*time.Millisecond, func() { close(release) })
+			} else {
+				defer close(release)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), tt.timeout)
+			defer cancel()
+
+			if err := s.Shutdown(ctx); !errors.Is(err, tt.want) {
+				t.Fatalf("got error %v, want %v", err, tt.want)
+			}
+
+			err := <-result
+			if tt.want == nil && (err != nil || !served) {
+				t.Errorf("got error %v, served before the hooks %t", err, served)
+			}
+			if tt.want != nil && err == nil {
+				t.Error("the request was served after the timeout")
+			}
+		})
+	}
+}
+
+// TestDrainDelay checks that requests are still served while the server
+// is draining.
+func TestDrainDelay(t *testing.T) {
+	s := newServer(t, Options{DrainDelay: 100 * time.Millisecond})
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error)
+	go func() { done <- s.Shutdown(context.Background()) }()
+
+	select {
+	case <-s.Draining():
+	case <-time.After(time.Second):
+		t.Fatal("not draining after Shutdown")
+	}
+	if body, err := get(s.Addr()); err != nil || body != "hello" {
+		t.Errorf("got %q, %v while draining", body, err)
+	}
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestStartContext checks that the server is shutdown when the context
+// given to Start is cancelled.
+func TestStartContext(t *testing.T) {
+	s := newServer(t, Options{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := s.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+
+	done := make(chan error)
+	go func() { done <- s.Wait() }()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("got error %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("not shutdown after the context was cancelled")
+	}
+}
+
+func TestSystemdListenersErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		pid, fds string
+	}{
+		{"not set", "", ""},
+		{"other process", "1", "1"},
+		{"no sockets", "self", "0"},
+		{"invalid count", "self", "x"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pid := tt.pid
+			if pid == "self" {
+				pid = fmt.Sprint(os.Getpid())
+			}
+			t.Setenv("LISTEN_PID", pid)
+			t.Setenv("LISTEN_FDS", tt.fds)
+
+			_, err := listenAddr(systemdAddr)
+			if err == nil || err.Error() != "no sockets passed by systemd" {
+				t.Errorf("got error %v, want no sockets passed by systemd", err)
+			}
+		})
+	}
+}
+
+// TestUnixSocketStale checks that a socket left by a previous run does
+// not prevent listening on its path.
+func TestUnixSocketStale(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "api.sock")
+
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// keep the file as a crashed process would
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	s := newServer(t, Options{Addr: "unix:" + path})
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if body, err := get(s.Addr()); err != nil || body != "hello" {
+		t.Errorf("got %q, %v", body, err)
+	}
+}
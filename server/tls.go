@@ -0,0 +1,221 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/imariom/products-api/logging"
+)
+
+// defaultReloadInterval is how often the certificate files are checked
+// for changes when TLSOptions.ReloadInterval is not set.
+const defaultReloadInterval = 30 * time.Second
+
+// TLSOptions contains the options required to serve the API over HTTPS.
+type TLSOptions struct {
+	// CertFile and KeyFile are the paths to the PEM encoded certificate
+	// (chain) and private key of the server.
+	CertFile string
+	KeyFile  string
+
+	// ClientCAFile is the path to a PEM bundle of certificate authorities
+	// used to verify client certificates. When set mutual TLS is enabled
+	// and every client must present a certificate signed by one of them.
+	ClientCAFile string
+
+	// ReloadInterval is how often the files above are checked for
+	// changes. A reload can also be forced by sending SIGHUP.
+	ReloadInterval time.Duration
+
+	// DisableHTTP2 restricts the server to HTTP/1.1.
+	DisableHTTP2 bool
+}
+
+// certReloader keeps the current certificate and client CA pool in
+// memory and replaces them whenever the files change on disk. New
+// handshakes pick the latest certificate while established connections
+// keep the one they negotiated, so a reload never drops connections.
+type certReloader struct {
+	opts   *TLSOptions
+	logger *logging.Logger
+
+	mtx       sync.RWMutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+	modTimes  map[string]time.Time
+
+	done chan struct{}
+}
+
+func newCertReloader(opts *TLSOptions, logger *logging.Logger) (*certReloader, error) {
+	if opts.CertFile == "" || opts.KeyFile == "" {
+		return nil, fmt.Errorf("both certificate and key files are required")
+	}
+
+	cr := &certReloader{
+		opts:     opts,
+		logger:   logger,
+		modTimes: make(map[string]time.Time),
+		done:     make(chan struct{}),
+	}
+
+	if err := cr.reload(); err != nil {
+		return nil, err
+	}
+
+	return cr, nil
+}
+
+// files returns the paths watched by the reloader.
+func (cr *certReloader) files() []string {
+	files := []string{cr.opts.CertFile, cr.opts.KeyFile}
+	if cr.opts.ClientCAFile != "" {
+		files = append(files, cr.opts.ClientCAFile)
+	}
+
+	return files
+}
+
+// reload loads the certificate, key and client CA bundle from disk and
+// swaps them in. On failure the previous material stays in use.
+func (cr *certReloader) reload() error {
+	modTimes := make(map[string]time.Time)
+	for _, f := range cr.files() {
+		info, err := os.Stat(f)
+		if err != nil {
+			return err
+		}
+		modTimes[f] = info.ModTime()
+	}
+
+	cert, err := tls.LoadX509KeyPair(cr.opts.CertFile, cr.opts.KeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load key pair: %v", err)
+	}
+
+	var pool *x509.CertPool
+	if cr.opts.ClientCAFile != "" {
+		pem, err := os.ReadFile(cr.opts.ClientCAFile)
+		if err != nil {
+			return err
+		}
+
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in %s", cr.opts.ClientCAFile)
+		}
+	}
+
+	cr.mtx.Lock()
+	cr.cert = &cert
+	cr.clientCAs = pool
+	cr.modTimes = modTimes
+	cr.mtx.Unlock()
+
+	return nil
+}
+
+// changed reports whether any of the watched files was modified since
+// the last successful reload.
+func (cr *certReloader) changed() bool {
+	cr.mtx.RLock()
+	defer cr.mtx.RUnlock()
+
+	for _, f := range cr.files() {
+		info, err := os.Stat(f)
+		if err != nil {
+			// the file may be in the middle of being replaced
+			continue
+		}
+
+		if !info.ModTime().Equal(cr.modTimes[f]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// watch polls the files for changes and listens for SIGHUP until stop
+// is called.
+func (cr *certReloader) watch() {
+	interval := cr.opts.ReloadInterval
+	if interval <= 0 {
+		interval = defaultReloadInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGHUP)
+	defer signal.Stop(sigChan)
+
+	for {
+		select {
+		case <-cr.done:
+			return
+
+		case <-ticker.C:
+			if !cr.changed() {
+				continue
+			}
+
+		case <-sigChan:
+		}
+
+		if err := cr.reload(); err != nil {
+			cr.logger.Error("failed to reload TLS certificates", "error", err)
+			continue
+		}
+		cr.logger.Info("reloaded TLS certificates")
+	}
+}
+
+func (cr *certReloader) stop() {
+	close(cr.done)
+}
+
+// getCertificate is used as tls.Config.GetCertificate.
+func (cr *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.mtx.RLock()
+	defer cr.mtx.RUnlock()
+
+	return cr.cert, nil
+}
+
+// tlsConfig builds the server TLS configuration. The client CA pool is
+// resolved per handshake so a reloaded bundle applies to new clients.
+func (cr *certReloader) tlsConfig() *tls.Config {
+	config := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: cr.getCertificate,
+	}
+
+	if !cr.opts.DisableHTTP2 {
+		config.NextProtos = []string{"h2", "http/1.1"}
+	} else {
+		config.NextProtos = []string{"http/1.1"}
+	}
+
+	if cr.opts.ClientCAFile != "" {
+		base := config.Clone()
+		config.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cr.mtx.RLock()
+			defer cr.mtx.RUnlock()
+
+			c := base.Clone()
+			c.ClientAuth = tls.RequireAndVerifyClientCert
+			c.ClientCAs = cr.clientCAs
+			return c, nil
+		}
+	}
+
+	return config
+}
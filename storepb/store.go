@@ -0,0 +1,234 @@
+// Package storepb contains the Go messages of proto/store/v1/store.proto,
+// encoded with the grpc package.
+package storepb
+
+import "github.com/imariom/products-api/grpc"
+
+// the file and package of the messages
+const (
+	FileName = "store/v1/store.proto"
+	Package  = "store.v1"
+)
+
+type Product struct {
+	ID          uint64  `pb:"1"`
+	SKU         string  `pb:"2"`
+	Name        string  `pb:"3"`
+	Description string  `pb:"4"`
+	Category    string  `pb:"5"`
+	Image       string  `pb:"6"`
+	Price       float64 `pb:"7"`
+	MaxQuantity uint64  `pb:"8"`
+}
+
+type Category struct {
+	Name  string `pb:"1"`
+	Count uint32 `pb:"2"`
+}
+
+type Item struct {
+	ProductID   uint64 `pb:"1"`
+	Quantity    uint64 `pb:"2"`
+	Unavailable bool   `pb:"3"`
+}
+
+type Cart struct {
+	ID     uint64  `pb:"1"`
+	UserID uint64  `pb:"2"`
+	Date   string  `pb:"3"`
+	Items  []*Item `pb:"4"`
+}
+
+type Address struct {
+	City    string `pb:"1"`
+	Street  string `pb:"2"`
+	Number  uint64 `pb:"3"`
+	ZipCode string `pb:"4"`
+}
+
+type User struct {
+	ID       uint64   `pb:"1"`
+	Username string   `pb:"2"`
+	Password string   `pb:"3"`
+	Name     string   `pb:"4"`
+	Phone    string   `pb:"5"`
+	Address  *Address `pb:"6"`
+}
+
+type GetProductRequest struct {
+	ID uint64 `pb:"1"`
+}
+
+type ListProductsRequest struct {
+	Limit    int32  `pb:"1"`
+	Offset   int32  `pb:"2"`
+	Sort     string `pb:"3"`
+	Category string `pb:"4"`
+}
+
+type ListProductsResponse struct {
+	Products []*Product `pb:"1"`
+}
+
+type CreateProductRequest struct {
+	Product *Product `pb:"1"`
+}
+
+type ReplaceProductRequest struct {
+	ID      uint64   `pb:"1"`
+	Product *Product `pb:"2"`
+}
+
+type UpdateProductRequest struct {
+	ID      uint64   `pb:"1"`
+	Product *Product `pb:"2"`
+}
+
+type DeleteProductRequest struct {
+	ID uint64 `pb:"1"`
+}
+
+type ListCategoriesRequest struct{}
+
+type ListCategoriesResponse struct {
+	Categories []*Category `pb:"1"`
+}
+
+type WatchProductsRequest struct {
+	Category        string `pb:"1"`
+	IncludeExisting bool   `pb:"2"`
+}
+
+// EventType is the ProductEvent.Type enum.
+type EventType int32
+
+const (
+	EventUnspecified EventType = 0
+	EventExisting    EventType = 1
+	EventCreated     EventType = 2
+	EventUpdated     EventType = 3
+	EventDeleted     EventType = 4
+)
+
+type ProductEvent struct {
+	Type    EventType `pb:"1"`
+	Product *Product  `pb:"2"`
+}
+
+type GetCartRequest struct {
+	ID uint64 `pb:"1"`
+}
+
+type ListCartsRequest struct {
+	Limit  int32  `pb:"1"`
+	Offset int32  `pb:"2"`
+	Sort   string `pb:"3"`
+}
+
+type ListCartsResponse struct {
+	Carts []*Cart `pb:"1"`
+}
+
+type ListUserCartsRequest struct {
+	UserID uint64 `pb:"1"`
+}
+
+type CreateCartRequest struct {
+	Cart *Cart `pb:"1"`
+}
+
+type ReplaceCartRequest struct {
+	ID   uint64 `pb:"1"`
+	Cart *Cart  `pb:"2"`
+}
+
+type UpdateCartRequest struct {
+	ID   uint64 `pb:"1"`
+	Cart *Cart  `pb:"2"`
+}
+
+type DeleteCartRequest struct {
+	ID uint64 `pb:"1"`
+}
+
+type GetUserRequest struct {
+	ID uint64 `pb:"1"`
+}
+
+type ListUsersRequest struct {
+	Limit  int32 `pb:"1"`
+	Offset int32 `pb:"2"`
+}
+
+type ListUsersResponse struct {
+	Users []*User `pb:"1"`
+}
+
+type CreateUserRequest struct {
+	User *User `pb:"1"`
+}
+
+type ReplaceUserRequest struct {
+	ID   uint64 `pb:"1"`
+	User *User  `pb:"2"`
+}
+
+type UpdateUserRequest struct {
+	ID   uint64 `pb:"1"`
+	User *User  `pb:"2"`
+}
+
+type DeleteUserRequest struct {
+	ID uint64 `pb:"1"`
+}
+
+// Messages lists the messages of the file, in declaration order.
+var Messages = []grpc.Message{
+	{Name: "Product", Type: Product{}},
+	{Name: "Category", Type: Category{}},
+	{Name: "Item", Type: Item{}},
+	{Name: "Cart", Type: Cart{}},
+	{Name: "Address", Type: Address{}},
+	{Name: "User", Type: User{}},
+	{Name: "GetProductRequest", Type: GetProductRequest{}},
+	{Name: "ListProductsRequest", Type: ListProductsRequest{}},
+	{Name: "ListProductsResponse", Type: ListProductsResponse{}},
+	{Name: "CreateProductRequest", Type: CreateProductRequest{}},
+	{Name: "ReplaceProductRequest", Type: ReplaceProductRequest{}},
+	{Name: "UpdateProductRequest", Type: UpdateProductRequest{}},
+	{Name: "DeleteProductRequest", Type: DeleteProductRequest{}},
+	{Name: "ListCategoriesRequest", Type: ListCategoriesRequest{}},
+	{Name: "ListCategoriesResponse", Type: ListCategoriesResponse{}},
+	{Name: "WatchProductsRequest", Type: WatchProductsRequest{}},
+	{Name: "ProductEvent", Type: ProductEvent{}},
+	{Name: "GetCartRequest", Type: GetCartRequest{}},
+	{Name: "ListCartsRequest", Type: ListCartsRequest{}},
+	{Name: "ListCartsResponse", Type: ListCartsResponse{}},
+	{Name: "ListUserCartsRequest", Type: ListUserCartsRequest{}},
+	{Name: "CreateCartRequest", Type: CreateCartRequest{}},
+	{Name: "ReplaceCartRequest", Type: ReplaceCartRequest{}},
+	{Name: "UpdateCartRequest", Type: UpdateCartRequest{}},
+	{Name: "DeleteCartRequest", Type: DeleteCartRequest{}},
+	{Name: "GetUserRequest", Type: GetUserRequest{}},
+	{Name: "ListUsersRequest", Type: ListUsersRequest{}},
+	{Name: "ListUsersResponse", Type: ListUsersResponse{}},
+	{Name: "CreateUserRequest", Type: CreateUserRequest{}},
+	{Name: "ReplaceUserRequest", Type: ReplaceUserRequest{}},
+	{Name: "UpdateUserRequest", Type: UpdateUserRequest{}},
+	{Name: "DeleteUserRequest", Type: DeleteUserRequest{}},
+}
+
+// Enums lists the enums of the file.
+var Enums = []grpc.Enum{
+	{
+		Name: "ProductEvent.Type",
+		Type: EventUnspecified,
+		Values: []grpc.EnumValue{
+			{Name: "TYPE_UNSPECIFIED", Number: int32(EventUnspecified)},
+			{Name: "EXISTING", Number: int32(EventExisting)},
+			{Name: "CREATED", Number: int32(EventCreated)},
+			{Name: "UPDATED", Number: int32(EventUpdated)},
+			{Name: "DELETED", Number: int32(EventDeleted)},
+		},
+	},
+}
@@ -0,0 +1,323 @@
+package storepb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/imariom/products-api/grpc"
+)
+
+// protoFile is the file the messages are declared in.
+const protoFile = "../proto/store/v1/store.proto"
+
+// protoField is a field of a message of the .proto file.
+type protoField struct {
+	typ      string
+	name     string
+	num      int
+	repeated bool
+}
+
+// protoDecls are the messages and enums of the .proto file, nested ones
+// being prefixed by the name of their message, in declaration order.
+type protoDecls struct {
+	messages []string
+	fields   map[string][]protoField
+	enums    map[string][]grpc.EnumValue
+}
+
+var (
+	declRE  = regexp.MustCompile(`^(message|enum|service)\s+(\w+)\s*\{\s*(\})?$`)
+	fieldRE = regexp.MustCompile(`^(repeated\s+)?(\w+)\s+(\w+)\s*=\s*(\d+);$`)
+	valueRE = regexp.MustCompile(`^(\w+)\s*=\s*(-?\d+);$`)
+)
+
+// parseProto reads the declarations of the .proto file, which only
+// uses the subset of the language parsed here.
+func parseProto(t *testing.T) *protoDecls {
+	t.Helper()
+
+	f, err := os.Open(protoFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	decls := &protoDecls{
+		fields: map[string][]protoField{},
+		enums:  map[string][]grpc.EnumValue{},
+	}
+
+	type block struct{ kind, name string }
+	var blocks []block
+
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = strings.TrimSpace(line[:i])
+		}
+
+		switch {
+		case line == "" || strings.HasPrefix(line, "syntax") || strings.HasPrefix(line, "package") ||
+			strings.HasPrefix(line, "rpc "):
+			continue
+
+		case line == "}":
+			blocks = blocks[:len(blocks)-1]
+
+		case declRE.MatchString(line):
+			m := declRE.FindStringSubmatch(line)
+			name := m[2]
+			if len(blocks) > 0 {
+				name = blocks[len(blocks)-1].name + "." + name
+			}
+
+			switch m[1] {
+			case "message":
+				decls.messages = append(decls.messages, name)
+				decls.fields[name] = nil
+			case "enum":
+				decls.enums[name] = nil
+			}
+			if m[3] == "" {
+				blocks = append(blocks, block{m[1], name})
+			}
+
+		case len(blocks) > 0 && blocks[len(blocks)-1].kind == "message" && fieldRE.MatchString(line):
+			m := fieldRE.FindStringSubmatch(line)
+			num, _ := strconv.Atoi(m[4])
+			msg := blocks[len(blocks)-1].name
+			decls.fields[msg] = append(decls.fields[msg], protoField{typ: m[2], name: m[3], num: num, repeated: m[1] != ""})
+
+		case len(blocks) > 0 && blocks[len(blocks)-1].kind == "enum" && valueRE.MatchString(line):
+			m := valueRE.FindStringSubmatch(line)
+			num, _ := strconv.Atoi(m[2])
+			enum := blocks[len(blocks)-1].name
+			decls.enums[enum] = append(decls.enums[enum], grpc.EnumValue{Name: m[1], Number: int32(num)})
+
+		default:
+			t.Fatalf("%s:%d: unexpected line %q", protoFile, n, line)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	return decls
+}
+
+// scalarKinds are the Go kinds of the scalar types of the .proto file.
+var scalarKinds = map[string]reflect.Kind{
+	"bool":   reflect.Bool,
+	"int32":  reflect.Int32,
+	"int64":  reflect.Int64,
+	"uint32": reflect.Uint32,
+	"uint64": reflect.Uint64,
+	"float":  reflect.Float32,
+	"double": reflect.Float64,
+	"string": reflect.String,
+}
+
+// wireType returns the wire type of a field of the type typ, with the
+// values of repeated numeric fields packed.
+func wireType(typ string, repeated bool, enum bool) uint64 {
+	switch {
+	case typ == "double":
+		if repeated {
+			return 2
+		}
+		return 1
+	case typ == "float":
+		if repeated {
+			return 2
+		}
+		return 5
+	case typ == "string" || (scalarKinds[typ] == 0 && !enum):
+		return 2
+	case repeated:
+		return 2
+	}
+
+	return 0
+}
+
+// nonZero returns a value of the type t that is encoded.
+func nonZero(t reflect.Type) reflect.Value {
+	v := reflect.New(t).Elem()
+	switch t.Kind() {
+	case reflect.Bool:
+		v.SetBool(true)
+	case reflect.Int32, reflect.Int64:
+		v.SetInt(1)
+	case reflect.Uint32, reflect.Uint64:
+		v.SetUint(1)
+	case reflect.Float32, reflect.Float64:
+		v.SetFloat(1)
+	case reflect.String:
+		v.SetString("x")
+	case reflect.Ptr:
+		v.Set(reflect.New(t.Elem()))
+	case reflect.Slice:
+		v.Set(reflect.Append(v, nonZero(t.Elem())))
+	}
+	return v
+}
+
+// TestMessagesMatchProto checks the Go messages against the .proto file
+// served to the clients: the messages are the same, and their fields
+// have the same names, numbers and types, and are encoded with the wire
+// type of their declaration.
+func TestMessagesMatchProto(t *testing.T) {
+	decls := parseProto(t)
+
+	var names []string
+	goTypes := map[string]reflect.Type{}
+	for _, m := range Messages {
+		names = append(names, m.Name)
+		goTypes[m.Name] = reflect.TypeOf(m.Type)
+	}
+	for _, e := range Enums {
+		goTypes[e.Name] = reflect.TypeOf(e.Type)
+	}
+
+	if !reflect.DeepEqual(names, decls.messages) {
+		t.Fatalf("Messages are %v, the .proto file declares %v", names, decls.messages)
+	}
+
+	for _, msg := range decls.messages {
+		t.Run(msg, func(t *testing.T) {
+			typ := goTypes[msg]
+
+			tagged := map[int]reflect.StructField{}
+			for i := 0; i < typ.NumField(); i++ {
+				sf := typ.Field(i)
+				tag, ok := sf.Tag.Lookup(grpc.ProtoTag)
+				if !ok {
+					continue
+				}
+				num, _ := strconv.Atoi(strings.Split(tag, ",")[0])
+				if _, dup := tagged[num]; dup {
+					t.Errorf("field number %d is used twice", num)
+				}
+				tagged[num] = sf
+			}
+
+			if len(tagged) != len(decls.fields[msg]) {
+				t.Errorf("%d fields, the .proto file declares %d", len(tagged), len(decls.fields[msg]))
+			}
+
+			for _, pf := range decls.fields[msg] {
+				sf, ok := tagged[pf.num]
+				if !ok {
+					t.Errorf("field %s = %d is missing", pf.name, pf.num)
+					continue
+				}
+
+				if name := strings.ReplaceAll(pf.name, "_", ""); !strings.EqualFold(sf.Name, name) {
+					t.Errorf("field %d is %s, the .proto file names it %s", pf.num, sf.Name, pf.name)
+				}
+
+				ft := sf.Type
+				if pf.repeated {
+					if ft.Kind() != reflect.Slice {
+						t.Errorf("field %s is not repeated", sf.Name)
+						continue
+					}
+					ft = ft.Elem()
+				}
+
+				// the types of the file are named relative to the message
+				_, enum := decls.enums[msg+"."+pf.typ]
+				if kind, ok := scalarKinds[pf.typ]; ok {
+					if ft.Kind() != kind {
+						t.Errorf("field %s is %s, the .proto file declares %s", sf.Name, ft, pf.typ)
+					}
+				} else {
+					want := goTypes[msg+"."+pf.typ]
+					if want == nil {
+						want = goTypes[pf.typ]
+					}
+					if !enum {
+						want = reflect.PtrTo(want)
+					}
+					if ft != want {
+						t.Errorf("field %s is %s, the .proto file declares %s", sf.Name, ft, pf.typ)
+					}
+				}
+
+				// encode the field alone and check its key
+				v := reflect.New(typ)
+				v.Elem().FieldByIndex(sf.Index).Set(nonZero(sf.Type))
+				b, err := grpc.Marshal(v.Interface())
+				if err != nil {
+					t.Fatal(err)
+				}
+				key, n := binary.Uvarint(b)
+				if n <= 0 {
+					t.Fatalf("field %s is not encoded", sf.Name)
+				}
+				if num, wire := key>>3, key&7; num != uint64(pf.num) || wire != wireType(pf.typ, pf.repeated, enum) {
+					t.Errorf("field %s is encoded as field %d of wire type %d, want %d of wire type %d",
+						sf.Name, num, wire, pf.num, wireType(pf.typ, pf.repeated, enum))
+				}
+			}
+		})
+	}
+}
+
+// TestEnumsMatchProto checks the Go enums against the .proto file.
+func TestEnumsMatchProto(t *testing.T) {
+	decls := parseProto(t)
+
+	if len(Enums) != len(decls.enums) {
+		t.Errorf("%d enums, the .proto file declares %d", len(Enums), len(decls.enums))
+	}
+
+	for _, e := range Enums {
+		want, ok := decls.enums[e.Name]
+		if !ok {
+			t.Errorf("enum %s is not declared by the .proto file", e.Name)
+			continue
+		}
+		if !reflect.DeepEqual(e.Values, want) {
+			t.Errorf("enum %s has the values %v, the .proto file declares %v", e.Name, e.Values, want)
+		}
+	}
+}
+
+// TestRoundTrip checks that messages are decoded as they were encoded.
+func TestRoundTrip(t *testing.T) {
+	tests := []interface{}{
+		&Product{ID: 1, SKU: "SKU-1", Name: "Backpack", Description: "Fits 15\" laptops", Category: "bags", Image: "https://example.com/1.png", Price: 109.95, MaxQuantity: 5},
+		&Cart{ID: 2, UserID: 3, Date: "2020-03-02T00:00:00Z", Items: []*Item{{ProductID: 1, Quantity: 4}, {ProductID: 2, Unavailable: true}}},
+		&User{ID: 1, Username: "johnd", Name: "John Doe", Phone: "1-570-236-7033", Address: &Address{City: "kilcoole", Street: "new road", Number: 7682, ZipCode: "12926-3874"}},
+		&ProductEvent{Type: EventDeleted, Product: &Product{ID: 1}},
+		&ListProductsRequest{Limit: 10, Offset: -1, Sort: "desc", Category: "bags"},
+		&ListCategoriesResponse{Categories: []*Category{{Name: "bags", Count: 2}, {Name: "shoes"}}},
+		&ListCategoriesRequest{},
+	}
+
+	for _, msg := range tests {
+		t.Run(reflect.TypeOf(msg).Elem().Name(), func(t *testing.T) {
+			b, err := grpc.Marshal(msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := reflect.New(reflect.TypeOf(msg).Elem()).Interface()
+			if err := grpc.Unmarshal(b, got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, msg) {
+				t.Errorf("got %+v, want %+v", got, msg)
+			}
+		})
+	}
+}
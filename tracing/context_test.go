@@ -0,0 +1,85 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	const (
+		traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+		spanID  = "00f067aa0ba902b7"
+	)
+
+	tests := []struct {
+		name    string
+		value   string
+		sampled bool
+		err     string
+	}{
+		{"sampled", "00-" + traceID + "-" + spanID + "-01", true, ""},
+		{"not sampled", "00-" + traceID + "-" + spanID + "-00", false, ""},
+		{"spaces", " 00-" + traceID + "-" + spanID + "-01 ", true, ""},
+		{"future version", "01-" + traceID + "-" + spanID + "-01-extra", true, ""},
+		{"extra field", "00-" + traceID + "-" + spanID + "-01-extra", false, "invalid traceparent"},
+		{"invalid version", "ff-" + traceID + "-" + spanID + "-01", false, "invalid traceparent"},
+		{"long version", "000-" + traceID + "-" + spanID + "-01", false, "invalid traceparent"},
+		{"missing field", "00-" + traceID + "-" + spanID, false, "invalid traceparent"},
+		{"uppercase trace id", "00-4BF92F3577B34DA6A3CE929D0E0E4736-" + spanID + "-01", false, "invalid trace id: expected 32 lowercase hex characters"},
+		{"short trace id", "00-4bf92f35-" + spanID + "-01", false, "invalid trace id: expected 32 lowercase hex characters"},
+		{"short span id", "00-" + traceID + "-00f067aa-01", false, "invalid span id: expected 16 lowercase hex characters"},
+		{"invalid flags", "00-" + traceID + "-" + spanID + "-0x", false, "invalid trace flags: encoding/hex: invalid byte: U+0078 'x'"},
+		{"zero trace id", "00-00000000000000000000000000000000-" + spanID + "-01", false, "invalid traceparent"},
+		{"zero span id", "00-" + traceID + "-0000000000000000-01", false, "invalid traceparent"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc, err := ParseTraceparent(tt.value)
+			if tt.err != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), tt.err) {
+					t.Errorf("got error %v, want %s", err, tt.err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sc.TraceID.String() != traceID || sc.SpanID.String() != spanID || !sc.Remote || sc.IsSampled() != tt.sampled {
+				t.Errorf("got %+v", sc)
+			}
+		})
+	}
+}
+
+func TestPropagation(t *testing.T) {
+	sc := SpanContext{TraceID: newTraceID(), SpanID: newSpanID(), Flags: flagSampled}
+
+	h := http.Header{}
+	Inject(ContextWithRemoteSpanContext(context.Background(), sc), h)
+	if want := "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-01"; h.Get(TraceparentHeader) != want {
+		t.Fatalf("got traceparent %q, want %q", h.Get(TraceparentHeader), want)
+	}
+
+	got, ok := Extract(h)
+	sc.Remote = true
+	if !ok || got != sc {
+		t.Errorf("got %+v, want %+v", got, sc)
+	}
+
+	// nothing is propagated without a valid span context
+	h = http.Header{}
+	Inject(context.Background(), h)
+	if h.Get(TraceparentHeader) != "" {
+		t.Errorf("got traceparent %q without a span", h.Get(TraceparentHeader))
+	}
+
+	for _, v := range []string{"", "00-invalid"} {
+		if _, ok := Extract(http.Header{TraceparentHeader: {v}}); ok {
+			t.Errorf("extracted a span context from %q", v)
+		}
+	}
+}
@@ -0,0 +1,449 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// maxQueueSize is the number of finished spans buffered before new
+	// ones are dropped.
+	maxQueueSize = 2048
+
+	// maxBatchSize is the number of spans sent in a single export.
+	maxBatchSize = 512
+
+	// batchTimeout is the maximum time a finished span waits to be
+	// exported.
+	batchTimeout = 5 * time.Second
+
+	// instrumentationScope names the instrumentation in OTLP payloads.
+	instrumentationScope = "github.com/imariom/products-api/tracing"
+)
+
+// Exporter sends finished spans to a backend.
+type Exporter interface {
+	ExportSpans(ctx context.Context, serviceName string, spans []*SpanData) error
+	Shutdown(ctx context.Context) error
+}
+
+// batchProcessor queues finished spans and exports them in batches
+// from a background goroutine.
+type batchProcessor struct {
+	exporter    Exporter
+	serviceName string
+
+	queue chan *SpanData
+	flush chan chan struct{}
+	done  chan struct{}
+	once  sync.Once
+}
+
+func newBatchProcessor(exporter Exporter, serviceName string) *batchProcessor {
+	bp := &batchProcessor{
+		exporter:    exporter,
+		serviceName: serviceName,
+		queue:       make(chan *SpanData, maxQueueSize),
+		flush:       make(chan chan struct{}),
+		done:        make(chan struct{}),
+	}
+
+	if exporter != nil {
+		go bp.run()
+	}
+
+	return bp
+}
+
+func (bp *batchProcessor) onEnd(d *SpanData) {
+	if bp.exporter == nil {
+		return
+	}
+
+	select {
+	case bp.queue <- d:
+	default:
+		// drop the span rather than blocking the request
+	}
+}
+
+func (bp *batchProcessor) run() {
+	ticker := time.NewTicker(batchTimeout)
+	defer ticker.Stop()
+
+	batch := make([]*SpanData, 0, maxBatchSize)
+	export := func() {
+		if len(batch) == 0 {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), batchTimeout)
+		bp.exporter.ExportSpans(ctx, bp.serviceName, batch)
+		cancel()
+
+		batch = make([]*SpanData, 0, maxBatchSize)
+	}
+
+	for {
+		select {
+		case d := <-bp.queue:
+			batch = append(batch, d)
+			if len(batch) >= maxBatchSize {
+				export()
+			}
+
+		case <-ticker.C:
+			export()
+
+		case flushed := <-bp.flush:
+			// drain what was queued before the flush was requested
+			for drained := false; !drained; {
+				select {
+				case d := <-bp.queue:
+					batch = append(batch, d)
+				default:
+					drained = true
+				}
+			}
+			export()
+			close(flushed)
+
+		case <-bp.done:
+			return
+		}
+	}
+}
+
+// shutdown exports the queued spans and stops the processor.
+func (bp *batchProcessor) shutdown(ctx context.Context) error {
+	if bp.exporter == nil {
+		return nil
+	}
+
+	var err error
+	bp.once.Do(func() {
+		flushed := make(chan struct{})
+		select {
+		case bp.flush <- flushed:
+			select {
+			case <-flushed:
+			case <-ctx.Done():
+				err = ctx.Err()
+			}
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+
+		close(bp.done)
+
+		if shutdownErr := bp.exporter.Shutdown(ctx); err == nil {
+			err = shutdownErr
+		}
+	})
+
+	return err
+}
+
+// WriterExporter writes every span as a JSON line, which is handy to
+// inspect traces in tests or on the console without a collector.
+type WriterExporter struct {
+	mtx sync.Mutex
+	w   io.Writer
+}
+
+// NewWriterExporter creates an exporter writing JSON lines to w.
+func NewWriterExporter(w io.Writer) *WriterExporter {
+	return &WriterExporter{w: w}
+}
+
+// jsonSpan is the JSON representation of a span written by
+// WriterExporter.
+type jsonSpan struct {
+	Service       string                 `json:"service"`
+	TraceID       string                 `json:"trace_id"`
+	SpanID        string                 `json:"span_id"`
+	ParentSpanID  string                 `json:"parent_span_id,omitempty"`
+	Name          string                 `json:"name"`
+	Kind          string                 `json:"kind"`
+	Start         time.Time              `json:"start"`
+	End           time.Time              `json:"end"`
+	Duration      string                 `json:"duration"`
+	Attributes    map[string]interface{} `json:"attributes,omitempty"`
+	Events        []jsonEvent            `json:"events,omitempty"`
+	Status        string                 `json:"status"`
+	StatusMessage string                 `json:"status_message,omitempty"`
+}
+
+type jsonEvent struct {
+	Name       string                 `json:"name"`
+	Time       time.Time              `json:"time"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+}
+
+func attributeMap(attrs []Attribute) map[string]interface{} {
+	if len(attrs) == 0 {
+		return nil
+	}
+
+	m := make(map[string]interface{}, len(attrs))
+	for _, a := range attrs {
+		m[a.Key] = a.Value
+	}
+
+	return m
+}
+
+// ExportSpans writes spans as JSON lines.
+func (e *WriterExporter) ExportSpans(ctx context.Context, serviceName string, spans []*SpanData) error {
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+
+	for _, d := range spans {
+		js := jsonSpan{
+			Service:       serviceName,
+			TraceID:       d.SpanContext.TraceID.String(),
+			SpanID:        d.SpanContext.SpanID.String(),
+			Name:          d.Name,
+			Kind:          d.Kind.String(),
+			Start:         d.Start,
+			End:           d.End,
+			Duration:      d.End.Sub(d.Start).String(),
+			Attributes:    attributeMap(d.Attributes),
+			Status:        [...]string{"unset", "ok", "error"}[d.StatusCode],
+			StatusMessage: d.StatusMessage,
+		}
+
+		if d.Parent.IsValid() {
+			js.ParentSpanID = d.Parent.String()
+		}
+
+		for _, ev := range d.Events {
+			js.Events = append(js.Events, jsonEvent{ev.Name, ev.Time, attributeMap(ev.Attributes)})
+		}
+
+		if err := enc.Encode(js); err != nil {
+			return err
+		}
+	}
+
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+
+	_, err := e.w.Write(buf.Bytes())
+	return err
+}
+
+// Shutdown flushes w if it supports it.
+func (e *WriterExporter) Shutdown(ctx context.Context) error {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+
+	if s, ok := e.w.(interface{ Sync() error }); ok {
+		return s.Sync()
+	}
+
+	return nil
+}
+
+// DefaultOTLPEndpoint is the default OTLP/HTTP traces endpoint of a
+// local collector.
+const DefaultOTLPEndpoint = "http://127.0.0.1:4318/v1/traces"
+
+// OTLPExporter sends spans to an OpenTelemetry collector using the
+// OTLP/HTTP protocol with JSON encoding.
+type OTLPExporter struct {
+	endpoint string
+	client   *http.Client
+	headers  map[string]string
+}
+
+// NewOTLPExporter creates an exporter posting to endpoint, e.g.
+// DefaultOTLPEndpoint. headers are added to every request (e.g. for
+// authentication).
+func NewOTLPExporter(endpoint string, headers map[string]string) *OTLPExporter {
+	if endpoint == "" {
+		endpoint = DefaultOTLPEndpoint
+	}
+
+	return &OTLPExporter{
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		headers:  headers,
+	}
+}
+
+// OTLP JSON payload, see opentelemetry-proto/collector/trace/v1.
+type (
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+
+	otlpResource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	}
+
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+
+	otlpSpan struct {
+		TraceID           string         `json:"traceId"`
+		SpanID            string         `json:"spanId"`
+		ParentSpanID      string         `json:"parentSpanId,omitempty"`
+		Name              string         `json:"name"`
+		Kind              int            `json:"kind"`
+		StartTimeUnixNano string         `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+		Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+		Events            []otlpEvent    `json:"events,omitempty"`
+		Status            otlpStatus     `json:"status"`
+	}
+
+	otlpEvent struct {
+		TimeUnixNano string         `json:"timeUnixNano"`
+		Name         string         `json:"name"`
+		Attributes   []otlpKeyValue `json:"attributes,omitempty"`
+	}
+
+	otlpStatus struct {
+		Code    int    `json:"code,omitempty"`
+		Message string `json:"message,omitempty"`
+	}
+
+	otlpKeyValue struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+
+	otlpValue struct {
+		StringValue *string  `json:"stringValue,omitempty"`
+		BoolValue   *bool    `json:"boolValue,omitempty"`
+		IntValue    *string  `json:"intValue,omitempty"`
+		DoubleValue *float64 `json:"doubleValue,omitempty"`
+	}
+)
+
+func otlpAttributes(attrs []Attribute) []otlpKeyValue {
+	kvs := make([]otlpKeyValue, 0, len(attrs))
+	for _, a := range attrs {
+		v := otlpValue{}
+
+		switch val := a.Value.(type) {
+		case string:
+			v.StringValue = &val
+		case bool:
+			v.BoolValue = &val
+		case int:
+			s := strconv.FormatInt(int64(val), 10)
+			v.IntValue = &s
+		case int64:
+			s := strconv.FormatInt(val, 10)
+			v.IntValue = &s
+		case uint64:
+			s := strconv.FormatUint(val, 10)
+			v.IntValue = &s
+		case float64:
+			v.DoubleValue = &val
+		default:
+			s := fmt.Sprint(val)
+			v.StringValue = &s
+		}
+
+		kvs = append(kvs, otlpKeyValue{a.Key, v})
+	}
+
+	return kvs
+}
+
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+// ExportSpans posts spans to the collector.
+func (e *OTLPExporter) ExportSpans(ctx context.Context, serviceName string, spans []*SpanData) error {
+	otlpSpans := make([]otlpSpan, 0, len(spans))
+	for _, d := range spans {
+		s := otlpSpan{
+			TraceID:           d.SpanContext.TraceID.String(),
+			SpanID:            d.SpanContext.SpanID.String(),
+			Name:              d.Name,
+			Kind:              int(d.Kind),
+			StartTimeUnixNano: unixNano(d.Start),
+			EndTimeUnixNano:   unixNano(d.End),
+			Attributes:        otlpAttributes(d.Attributes),
+			Status:            otlpStatus{int(d.StatusCode), d.StatusMessage},
+		}
+
+		if d.Parent.IsValid() {
+			s.ParentSpanID = d.Parent.String()
+		}
+
+		for _, ev := range d.Events {
+			s.Events = append(s.Events, otlpEvent{unixNano(ev.Time), ev.Name, otlpAttributes(ev.Attributes)})
+		}
+
+		otlpSpans = append(otlpSpans, s)
+	}
+
+	payload := otlpRequest{
+		ResourceSpans: []otlpResourceSpans{{
+			Resource: otlpResource{
+				Attributes: otlpAttributes([]Attribute{{"service.name", serviceName}}),
+			},
+			ScopeSpans: []otlpScopeSpans{{
+				Scope: otlpScope{Name: instrumentationScope},
+				Spans: otlpSpans,
+			}},
+		}},
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+
+	res, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, res.Body)
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("collector responded with %s", res.Status)
+	}
+
+	return nil
+}
+
+// Shutdown closes idle connections to the collector.
+func (e *OTLPExporter) Shutdown(ctx context.Context) error {
+	e.client.CloseIdleConnections()
+	return nil
+}
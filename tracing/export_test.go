@@ -0,0 +1,144 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testSpans returns a server span and the child span of a failed call.
+func testSpans() []*SpanData {
+	start := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	root := SpanContext{TraceID: TraceID{1}, SpanID: SpanID{2}, Flags: flagSampled}
+
+	return []*SpanData{
+		{
+			SpanContext: root,
+			Name:        "GET /products",
+			Kind:        KindServer,
+			Start:       start,
+			End:         start.Add(3 * time.Millisecond),
+			Attributes:  []Attribute{{"http.status_code", 200}},
+			StatusCode:  StatusOK,
+		},
+		{
+			SpanContext:   SpanContext{TraceID: root.TraceID, SpanID: SpanID{3}, Flags: flagSampled},
+			Parent:        root.SpanID,
+			Name:          "data.GetProduct",
+			Kind:          KindInternal,
+			Start:         start.Add(time.Millisecond),
+			End:           start.Add(2 * time.Millisecond),
+			Attributes:    []Attribute{{"product.id", uint64(7)}, {"cached", false}, {"ratio", 0.5}, {"tags", []string{"a"}}},
+			Events:        []Event{{"exception", start.Add(2 * time.Millisecond), []Attribute{{"exception.message", "not found"}}}},
+			StatusCode:    StatusError,
+			StatusMessage: "not found",
+		},
+	}
+}
+
+func TestWriterExporter(t *testing.T) {
+	buf := &bytes.Buffer{}
+	e := NewWriterExporter(buf)
+
+	if err := e.ExportSpans(context.Background(), "store-api", testSpans()); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `{"service":"store-api","trace_id":"01000000000000000000000000000000","span_id":"0200000000000000","name":"GET /products","kind":"server","start":"2024-03-01T10:00:00Z","end":"2024-03-01T10:00:00.003Z","duration":"3ms","attributes":{"http.status_code":200},"status":"ok"}
+{"service":"store-api","trace_id":"01000000000000000000000000000000","span_id":"0300000000000000","parent_span_id":"0200000000000000","name":"data.GetProduct","kind":"internal","start":"2024-03-01T10:00:00.001Z","end":"2024-03-01T10:00:00.002Z","duration":"1ms","attributes":{"cached":false,"product.id":7,"ratio":0.5,"tags":["a"]},"events":[{"name":"exception","time":"2024-03-01T10:00:00.002Z","attributes":{"exception.message":"not found"}}],"status":"error","status_message":"not found"}
+`
+	if buf.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", buf, want)
+	}
+}
+
+func TestOTLPExporter(t *testing.T) {
+	var got otlpRequest
+	collector := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" || r.Header.Get("Authorization") != "Bearer token" {
+			t.Errorf("got %s %s with headers %v", r.Method, r.URL, r.Header)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer collector.Close()
+
+	e := NewOTLPExporter(collector.URL+"/v1/traces", map[string]string{"Authorization": "Bearer token"})
+	if err := e.ExportSpans(context.Background(), "store-api", testSpans()); err != nil {
+		t.Fatal(err)
+	}
+	e.Shutdown(context.Background())
+
+	b, _ := json.Marshal(got)
+	want := `{"resourceSpans":[{"resource":{"attributes":[{"key":"service.name","value":{"stringValue":"store-api"}}]},"scopeSpans":[{"scope":{"name":"github.com/imariom/products-api/tracing"},"spans":[` +
+		`{"traceId":"01000000000000000000000000000000","spanId":"0200000000000000","name":"GET /products","kind":2,"startTimeUnixNano":"1709287200000000000","endTimeUnixNano":"1709287200003000000","attributes":[{"key":"http.status_code","value":{"intValue":"200"}}],"status":{"code":1}},` +
+		`{"traceId":"01000000000000000000000000000000","spanId":"0300000000000000","parentSpanId":"0200000000000000","name":"data.GetProduct","kind":1,"startTimeUnixNano":"1709287200001000000","endTimeUnixNano":"1709287200002000000",` +
+		`"attributes":[{"key":"product.id","value":{"intValue":"7"}},{"key":"cached","value":{"boolValue":false}},{"key":"ratio","value":{"doubleValue":0.5}},{"key":"tags","value":{"stringValue":"[a]"}}],` +
+		`"events":[{"timeUnixNano":"1709287200002000000","name":"exception","attributes":[{"key":"exception.message","value":{"stringValue":"not found"}}]}],"status":{"code":2,"message":"not found"}}]}]}]}`
+	if string(b) != want {
+		t.Errorf("got\n%s\nwant\n%s", b, want)
+	}
+}
+
+func TestOTLPExporterErrors(t *testing.T) {
+	collector := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		http.Error(rw, "overloaded", http.StatusServiceUnavailable)
+	}))
+	defer collector.Close()
+
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	tests := []struct {
+		name     string
+		endpoint string
+		want     string
+	}{
+		{"error status", collector.URL, "collector responded with 503 Service Unavailable"},
+		{"unreachable", closed.URL, "connection refused"},
+		{"invalid endpoint", "http://[::1", "missing ']' in host"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := NewOTLPExporter(tt.endpoint, nil).ExportSpans(context.Background(), "store-api", testSpans())
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v, want %s", err, tt.want)
+			}
+		})
+	}
+}
+
+// blocked is an exporter blocking until its context is done.
+type blocked struct{}
+
+func (blocked) ExportSpans(ctx context.Context, serviceName string, spans []*SpanData) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (blocked) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+// TestShutdownTimeout checks that the tracer does not wait for a stuck
+// exporter longer than the context of Shutdown.
+func TestShutdownTimeout(t *testing.T) {
+	tracer := NewTracer(Options{Exporter: blocked{}, SampleRatio: 1})
+	_, span := tracer.Start(context.Background(), "span")
+	span.End()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if err := tracer.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got error %v, want %v", err, context.DeadlineExceeded)
+	}
+}
@@ -0,0 +1,47 @@
+package tracing
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Transport is an http.RoundTripper that records a client span for
+// every outbound request and propagates it with the traceparent header.
+type Transport struct {
+	// Base is the underlying transport, http.DefaultTransport if nil.
+	Base http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	ctx, span := Start(r.Context(), fmt.Sprintf("HTTP %s", r.Method),
+		WithKind(KindClient),
+		WithAttributes(
+			"http.method", r.Method,
+			"http.url", r.URL.Redacted(),
+			"net.peer.name", r.URL.Hostname(),
+		))
+	defer span.End()
+
+	// requests must not be modified by round trippers
+	r = r.Clone(ctx)
+	Inject(ctx, r.Header)
+
+	res, err := base.RoundTrip(r)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	span.SetAttributes("http.status_code", res.StatusCode)
+	if res.StatusCode >= http.StatusInternalServerError {
+		span.SetStatus(StatusError, res.Status)
+	}
+
+	return res, nil
+}
@@ -0,0 +1,77 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// failing is a transport failing every request.
+type failing struct{}
+
+func (failing) RoundTrip(*http.Request) (*http.Response, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestTransport(t *testing.T) {
+	var traceparent string
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get(TraceparentHeader)
+		if r.URL.Path == "/fail" {
+			rw.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name   string
+		path   string
+		base   http.RoundTripper
+		status StatusCode
+		err    bool
+	}{
+		{"ok", "/hooks", nil, StatusUnset, false},
+		{"server error", "/fail", nil, StatusError, false},
+		{"transport error", "/hooks", failing{}, StatusError, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracer, flush := newTracer(t, 1)
+			SetDefault(tracer)
+			t.Cleanup(func() { SetDefault(nil) })
+			traceparent = ""
+
+			ctx, parent := tracer.Start(context.Background(), "deliver")
+			r, _ := http.NewRequestWithContext(ctx, http.MethodPost, srv.URL+tt.path, nil)
+			client := &http.Client{Transport: &Transport{Base: tt.base}}
+
+			res, err := client.Do(r)
+			if (err != nil) != tt.err {
+				t.Fatalf("got error %v", err)
+			}
+			if res != nil {
+				res.Body.Close()
+			}
+			if r.Header.Get(TraceparentHeader) != "" {
+				t.Error("the request was modified")
+			}
+			parent.End()
+
+			spans := flush()
+			if len(spans) != 2 {
+				t.Fatalf("got %d spans, want 2", len(spans))
+			}
+			span := spans[0]
+
+			if span.Name != "HTTP POST" || span.Kind != KindClient || span.Parent != parent.SpanContext().SpanID || span.StatusCode != tt.status {
+				t.Errorf("got span %+v", span)
+			}
+			if !tt.err && traceparent != span.SpanContext.Traceparent() {
+				t.Errorf("got traceparent %q, want the one of the client span %q", traceparent, span.SpanContext.Traceparent())
+			}
+		})
+	}
+}
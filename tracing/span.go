@@ -0,0 +1,294 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// SpanKind describes the relationship between a span and its parent,
+// using the values of the OpenTelemetry protocol.
+type SpanKind int
+
+const (
+	KindInternal SpanKind = 1
+	KindServer   SpanKind = 2
+	KindClient   SpanKind = 3
+)
+
+func (k SpanKind) String() string {
+	switch k {
+	case KindServer:
+		return "server"
+	case KindClient:
+		return "client"
+	}
+
+	return "internal"
+}
+
+// StatusCode is the status of a finished span, using the values of the
+// OpenTelemetry protocol.
+type StatusCode int
+
+const (
+	StatusUnset StatusCode = 0
+	StatusOK    StatusCode = 1
+	StatusError StatusCode = 2
+)
+
+// Attribute is a key-value pair describing a span.
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// Event is something that happened during a span.
+type Event struct {
+	Name       string
+	Time       time.Time
+	Attributes []Attribute
+}
+
+// SpanData is the immutable snapshot of a finished span handed to
+// exporters.
+type SpanData struct {
+	SpanContext   SpanContext
+	Parent        SpanID
+	Name          string
+	Kind          SpanKind
+	Start         time.Time
+	End           time.Time
+	Attributes    []Attribute
+	Events        []Event
+	StatusCode    StatusCode
+	StatusMessage string
+}
+
+// Span is a single operation within a trace. A nil *Span is valid and
+// records nothing, so callers never need to check for it.
+type Span struct {
+	tracer *Tracer
+	sc     SpanContext
+
+	mtx   sync.Mutex
+	data  SpanData
+	ended bool
+}
+
+// SpanContext returns the propagated identity of the span.
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+
+	return s.sc
+}
+
+// IsRecording reports whether the span will be exported.
+func (s *Span) IsRecording() bool {
+	return s != nil && s.tracer != nil
+}
+
+// SetAttributes adds attributes given as alternating keys and values.
+func (s *Span) SetAttributes(kv ...interface{}) {
+	if !s.IsRecording() {
+		return
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.data.Attributes = append(s.data.Attributes, toAttributes(kv)...)
+}
+
+// AddEvent records an event with attributes given as alternating keys
+// and values.
+func (s *Span) AddEvent(name string, kv ...interface{}) {
+	if !s.IsRecording() {
+		return
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.data.Events = append(s.data.Events, Event{name, time.Now(), toAttributes(kv)})
+}
+
+// RecordError records err as an exception event and marks the span as
+// failed.
+func (s *Span) RecordError(err error) {
+	if err == nil || !s.IsRecording() {
+		return
+	}
+
+	s.AddEvent("exception", "exception.message", err.Error())
+	s.SetStatus(StatusError, err.Error())
+}
+
+// SetStatus sets the status of the span.
+func (s *Span) SetStatus(code StatusCode, msg string) {
+	if !s.IsRecording() {
+		return
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.data.StatusCode = code
+	s.data.StatusMessage = msg
+}
+
+// End finishes the span and queues it for export. Calling End more than
+// once has no effect.
+func (s *Span) End() {
+	if !s.IsRecording() {
+		return
+	}
+
+	s.mtx.Lock()
+	if s.ended {
+		s.mtx.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	data := s.data
+	s.mtx.Unlock()
+
+	s.tracer.processor.onEnd(&data)
+}
+
+func toAttributes(kv []interface{}) []Attribute {
+	attrs := make([]Attribute, 0, len(kv)/2)
+	for i := 0; i+1 < len(kv); i += 2 {
+		key, ok := kv[i].(string)
+		if !ok {
+			key = fmt.Sprint(kv[i])
+		}
+
+		attrs = append(attrs, Attribute{key, kv[i+1]})
+	}
+
+	return attrs
+}
+
+// Options configures a Tracer.
+type Options struct {
+	// ServiceName is reported as the service.name resource attribute.
+	ServiceName string
+
+	// Exporter receives the finished spans.
+	Exporter Exporter
+
+	// SampleRatio is the fraction of new traces recorded, between 0 and
+	// 1. Traces started by a caller follow the caller's decision.
+	SampleRatio float64
+}
+
+// Tracer starts spans and hands them to its exporter once finished.
+type Tracer struct {
+	opts      Options
+	processor *batchProcessor
+}
+
+// NewTracer creates a Tracer exporting to opts.Exporter in batches.
+// Shutdown must be called to flush the pending spans.
+func NewTracer(opts Options) *Tracer {
+	if opts.ServiceName == "" {
+		opts.ServiceName = "store-api"
+	}
+
+	t := &Tracer{opts: opts}
+	t.processor = newBatchProcessor(opts.Exporter, opts.ServiceName)
+
+	return t
+}
+
+// Shutdown exports the pending spans and stops the tracer.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+
+	return t.processor.shutdown(ctx)
+}
+
+// StartOption configures a span when it is started.
+type StartOption func(*SpanData)
+
+// WithKind sets the kind of the span.
+func WithKind(kind SpanKind) StartOption {
+	return func(d *SpanData) {
+		d.Kind = kind
+	}
+}
+
+// WithAttributes sets attributes given as alternating keys and values.
+func WithAttributes(kv ...interface{}) StartOption {
+	return func(d *SpanData) {
+		d.Attributes = append(d.Attributes, toAttributes(kv)...)
+	}
+}
+
+// Start starts a span as a child of the span carried by ctx, returning
+// a context carrying the new span.
+func (t *Tracer) Start(ctx context.Context, name string, opts ...StartOption) (context.Context, *Span) {
+	parent := SpanContextFromContext(ctx)
+
+	sc := SpanContext{SpanID: newSpanID()}
+	if parent.IsValid() {
+		sc.TraceID = parent.TraceID
+		sc.Flags = parent.Flags
+	} else {
+		sc.TraceID = newTraceID()
+		if t != nil && traceIDRatio(sc.TraceID) < t.opts.SampleRatio {
+			sc.Flags = flagSampled
+		}
+	}
+
+	span := &Span{sc: sc}
+	if t != nil && sc.IsSampled() {
+		span.tracer = t
+		span.data = SpanData{
+			SpanContext: sc,
+			Parent:      parent.SpanID,
+			Name:        name,
+			Kind:        KindInternal,
+			Start:       time.Now(),
+		}
+
+		for _, opt := range opts {
+			opt(&span.data)
+		}
+	}
+
+	return ContextWithSpan(ctx, span), span
+}
+
+var (
+	defaultMtx    sync.RWMutex
+	defaultTracer *Tracer
+)
+
+// SetDefault sets the tracer used by the package level Start function.
+func SetDefault(t *Tracer) {
+	defaultMtx.Lock()
+	defaultTracer = t
+	defaultMtx.Unlock()
+}
+
+// Default returns the tracer set by SetDefault, or nil. A nil tracer
+// still propagates trace context but records nothing.
+func Default() *Tracer {
+	defaultMtx.RLock()
+	defer defaultMtx.RUnlock()
+
+	return defaultTracer
+}
+
+// Start starts a span with the default tracer.
+func Start(ctx context.Context, name string, opts ...StartOption) (context.Context, *Span) {
+	return Default().Start(ctx, name, opts...)
+}
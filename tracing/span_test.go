@@ -0,0 +1,179 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// recorder is an exporter keeping the spans it receives.
+type recorder struct {
+	mtx      sync.Mutex
+	spans    []*SpanData
+	shutdown bool
+}
+
+func (r *recorder) ExportSpans(ctx context.Context, serviceName string, spans []*SpanData) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	r.spans = append(r.spans, spans...)
+	return nil
+}
+
+func (r *recorder) Shutdown(ctx context.Context) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	r.shutdown = true
+	return nil
+}
+
+// newTracer returns a tracer recording every trace, and a function
+// flushing it and returning the spans exported.
+func newTracer(t *testing.T, ratio float64) (*Tracer, func() []*SpanData) {
+	t.Helper()
+
+	r := &recorder{}
+	tracer := NewTracer(Options{Exporter: r, SampleRatio: ratio})
+
+	return tracer, func() []*SpanData {
+		if err := tracer.Shutdown(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if !r.shutdown {
+			t.Error("the exporter was not shutdown")
+		}
+
+		return r.spans
+	}
+}
+
+func TestSpans(t *testing.T) {
+	tracer, flush := newTracer(t, 1)
+
+	ctx, root := tracer.Start(context.Background(), "GET /products", WithKind(KindServer), WithAttributes("http.method", "GET"))
+	_, child := tracer.Start(ctx, "data.GetProducts")
+	// a key without value is ignored
+	child.SetAttributes("products", 3, "dangling")
+	child.RecordError(errors.New("not found"))
+	child.End()
+	child.End()
+	root.SetStatus(StatusOK, "")
+	root.End()
+
+	spans := flush()
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want 2", len(spans))
+	}
+	c, r := spans[0], spans[1]
+
+	if r.Name != "GET /products" || r.Kind != KindServer || r.Parent.IsValid() || r.StatusCode != StatusOK {
+		t.Errorf("got root span %+v", r)
+	}
+	if !reflect.DeepEqual(r.Attributes, []Attribute{{"http.method", "GET"}}) {
+		t.Errorf("got root attributes %v", r.Attributes)
+	}
+
+	if c.SpanContext.TraceID != r.SpanContext.TraceID || c.Parent != r.SpanContext.SpanID || c.Kind != KindInternal {
+		t.Errorf("got child span %+v of %+v", c, r.SpanContext)
+	}
+	if !reflect.DeepEqual(c.Attributes, []Attribute{{"products", 3}}) {
+		t.Errorf("got child attributes %v", c.Attributes)
+	}
+	if c.StatusCode != StatusError || c.StatusMessage != "not found" || len(c.Events) != 1 || c.Events[0].Name != "exception" {
+		t.Errorf("got child status %d %q and events %v", c.StatusCode, c.StatusMessage, c.Events)
+	}
+	if c.End.Before(c.Start) || r.End.Before(c.End) {
+		t.Errorf("got child from %s to %s in root from %s to %s", c.Start, c.End, r.Start, r.End)
+	}
+
+	// spans ended after the shutdown are not exported
+	_, late := tracer.Start(context.Background(), "late")
+	late.End()
+}
+
+func TestSampling(t *testing.T) {
+	remote := func(flags byte) context.Context {
+		sc := SpanContext{TraceID: newTraceID(), SpanID: newSpanID(), Flags: flags, Remote: true}
+		return ContextWithRemoteSpanContext(context.Background(), sc)
+	}
+
+	tests := []struct {
+		name  string
+		ratio float64
+		ctx   context.Context
+		want  bool
+	}{
+		{"always", 1, context.Background(), true},
+		{"never", 0, context.Background(), false},
+		{"sampled by the caller", 0, remote(flagSampled), true},
+		{"not sampled by the caller", 1, remote(0), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracer, flush := newTracer(t, tt.ratio)
+
+			ctx, span := tracer.Start(tt.ctx, "span")
+			span.End()
+
+			parent := SpanContextFromContext(tt.ctx)
+			sc := span.SpanContext()
+			if !sc.IsValid() || SpanFromContext(ctx) != span || parent.IsValid() && sc.TraceID != parent.TraceID {
+				t.Errorf("got span context %+v from %+v", sc, parent)
+			}
+			if span.IsRecording() != tt.want || sc.IsSampled() != tt.want {
+				t.Errorf("got recording %t, sampled %t, want %t", span.IsRecording(), sc.IsSampled(), tt.want)
+			}
+			if spans := flush(); (len(spans) == 1) != tt.want {
+				t.Errorf("got %d spans exported", len(spans))
+			}
+		})
+	}
+}
+
+// TestTraceIDRatio checks that sampling keeps about the given fraction
+// of the traces.
+func TestTraceIDRatio(t *testing.T) {
+	sampled := 0
+	for i := 0; i < 10000; i++ {
+		if traceIDRatio(newTraceID()) < 0.25 {
+			sampled++
+		}
+	}
+
+	if sampled < 2200 || sampled > 2800 {
+		t.Errorf("sampled %d of 10000 traces, want about 2500", sampled)
+	}
+}
+
+// TestNilTracer checks that a nil tracer propagates the trace context
+// without recording anything.
+func TestNilTracer(t *testing.T) {
+	var tracer *Tracer
+
+	ctx, parent := tracer.Start(context.Background(), "parent")
+	_, child := tracer.Start(ctx, "child")
+
+	if !parent.SpanContext().IsValid() || child.SpanContext().TraceID != parent.SpanContext().TraceID {
+		t.Errorf("got child %+v of %+v", child.SpanContext(), parent.SpanContext())
+	}
+	if child.IsRecording() {
+		t.Error("a span of a nil tracer is recording")
+	}
+
+	// none of this panics
+	child.SetAttributes("key", "value")
+	child.RecordError(errors.New("failed"))
+	child.End()
+
+	var span *Span
+	span.AddEvent("event")
+	span.End()
+	if span.SpanContext().IsValid() || tracer.Shutdown(context.Background()) != nil {
+		t.Error("a nil span has a span context")
+	}
+}
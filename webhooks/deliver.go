@@ -0,0 +1,101 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/imariom/products-api/queue"
+	"github.com/imariom/products-api/tracing"
+)
+
+// maxResponseSize limits the part of the responses of the receivers
+// read before closing them.
+const maxResponseSize = 64 << 10
+
+// deliver is the handler of the delivery jobs. It sends the event to
+// the URL of the subscription, failing the job for good when the
+// subscription was removed or disabled meanwhile.
+func (d *Dispatcher) deliver(ctx context.Context, payload json.RawMessage) error {
+	var dj deliveryJob
+	if err := json.Unmarshal(payload, &dj); err != nil {
+		return queue.Permanent(fmt.Errorf("invalid delivery: %w", err))
+	}
+
+	d.mtx.Lock()
+	s, ok := d.subscriptions[dj.SubscriptionID]
+	var sub Subscription
+	if ok {
+		sub = *s
+	}
+	d.mtx.Unlock()
+
+	switch {
+	case !ok:
+		return queue.Permanent(fmt.Errorf("subscription %d no longer exists", dj.SubscriptionID))
+	case sub.Disabled:
+		return queue.Permanent(fmt.Errorf("subscription %d is disabled", dj.SubscriptionID))
+	}
+
+	j, _ := queue.JobFromContext(ctx)
+
+	ctx, span := tracing.Start(ctx, "webhook delivery",
+		tracing.WithAttributes(
+			"webhook.subscription_id", sub.ID,
+			"webhook.delivery_id", j.ID,
+			"webhook.event_type", dj.EventType,
+			"webhook.attempt", len(j.Attempts)+1,
+		))
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, d.opts.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := d.post(ctx, j.ID, &dj, &sub)
+	deliveryDuration.Observe(time.Since(start).Seconds())
+
+	if err != nil {
+		deliveryAttempts.WithLabelValues("failed").Inc()
+		span.RecordError(err)
+		return err
+	}
+
+	deliveryAttempts.WithLabelValues("succeeded").Inc()
+	return nil
+}
+
+// post sends the payload of the delivery id, signed with the secret of
+// s. It returns an error unless the receiver answers with a 2xx.
+func (d *Dispatcher) post(ctx context.Context, id uint64, dj *deliveryJob, s *Subscription) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(dj.Payload))
+	if err != nil {
+		return queue.Permanent(err)
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "store-api-webhooks")
+	req.Header.Set(HeaderID, strconv.FormatUint(id, 10))
+	req.Header.Set(HeaderEvent, dj.EventType)
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, Sign(s.Secret, timestamp, dj.Payload))
+
+	res, err := d.opts.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, io.LimitReader(res.Body, maxResponseSize))
+	res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("receiver answered %s", res.Status)
+	}
+
+	return nil
+}
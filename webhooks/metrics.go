@@ -0,0 +1,14 @@
+package webhooks
+
+import "github.com/imariom/products-api/metrics"
+
+var (
+	deliveryAttempts = metrics.NewCounterVec("webhook_delivery_attempts_total",
+		"Number of webhook delivery attempts by result (succeeded or failed).", "result")
+
+	deliveryDuration = metrics.NewHistogram("webhook_delivery_duration_seconds",
+		"Duration of the webhook delivery attempts.", nil)
+
+	deliveriesLost = metrics.NewCounter("webhook_deliveries_lost_total",
+		"Number of webhook deliveries of events that could not be queued.")
+)
@@ -0,0 +1,69 @@
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// the headers sent with every delivery
+const (
+	HeaderID        = "X-Webhook-Id"
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+// signaturePrefix identifies the algorithm of the signature header.
+const signaturePrefix = "sha256="
+
+// Sign returns the signature of a delivery: the hex encoded HMAC-SHA256
+// of "<timestamp>.<body>" keyed with the secret of the subscription,
+// prefixed with "sha256=". Signing the timestamp lets receivers reject
+// replayed deliveries.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the signature of a delivery received with header and
+// body, and that it was signed less than tolerance ago (any time when
+// zero). Receivers must verify the raw body, before decoding it.
+func Verify(secret string, header http.Header, body []byte, tolerance time.Duration) error {
+	timestamp := header.Get(HeaderTimestamp)
+	signature := header.Get(HeaderSignature)
+	if timestamp == "" || signature == "" {
+		return fmt.Errorf("missing %s or %s header", HeaderTimestamp, HeaderSignature)
+	}
+
+	if !strings.HasPrefix(signature, signaturePrefix) {
+		return fmt.Errorf("unsupported signature algorithm")
+	}
+
+	if !hmac.Equal([]byte(signature), []byte(Sign(secret, timestamp, body))) {
+		return fmt.Errorf("invalid signature")
+	}
+
+	if tolerance > 0 {
+		sec, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid timestamp")
+		}
+
+		age := time.Since(time.Unix(sec, 0))
+		if age > tolerance || age < -tolerance {
+			return fmt.Errorf("timestamp outside of the tolerance")
+		}
+	}
+
+	return nil
+}
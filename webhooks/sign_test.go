@@ -0,0 +1,75 @@
+package webhooks
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSign(t *testing.T) {
+	tests := []struct {
+		secret, timestamp, body string
+		want                    string
+	}{
+		{"whsec", "1700000000", `{"id":1}`, "sha256=e79220cb981f992adbc8b93ac6d46028b0217ea19327d27dc9d18bf334403bde"},
+		{"whsec", "1700000000", "", "sha256=ab5fdf6f7cdf5f7abf2f4d61c6b0376dc6bf75beafc17135e5fd06513ee7afd8"},
+	}
+
+	for _, tt := range tests {
+		if got := Sign(tt.secret, tt.timestamp, []byte(tt.body)); got != tt.want {
+			t.Errorf("Sign(%q, %q, %q) = %s, want %s", tt.secret, tt.timestamp, tt.body, got, tt.want)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	body := []byte(`{"id":1,"type":"product.created"}`)
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	old := strconv.FormatInt(time.Now().Add(-10*time.Minute).Unix(), 10)
+	future := strconv.FormatInt(time.Now().Add(10*time.Minute).Unix(), 10)
+
+	signed := func(secret, timestamp string) http.Header {
+		return http.Header{
+			HeaderTimestamp: {timestamp},
+			HeaderSignature: {Sign(secret, timestamp, body)},
+		}
+	}
+
+	tests := []struct {
+		name      string
+		header    http.Header
+		body      []byte
+		tolerance time.Duration
+		want      string
+	}{
+		{"valid", signed("whsec", now), body, 5 * time.Minute, ""},
+		{"old without tolerance", signed("whsec", old), body, 0, ""},
+		{"missing timestamp", http.Header{HeaderSignature: {Sign("whsec", now, body)}}, body, 0, "missing X-Webhook-Timestamp or X-Webhook-Signature header"},
+		{"missing signature", http.Header{HeaderTimestamp: {now}}, body, 0, "missing X-Webhook-Timestamp or X-Webhook-Signature header"},
+		{"other algorithm", http.Header{HeaderTimestamp: {now}, HeaderSignature: {"sha1=abc"}}, body, 0, "unsupported signature algorithm"},
+		{"other secret", signed("other", now), body, 0, "invalid signature"},
+		{"changed body", signed("whsec", now), []byte(`{"id":2,"type":"product.created"}`), 0, "invalid signature"},
+		{
+			name:   "changed timestamp",
+			header: http.Header{HeaderTimestamp: {future}, HeaderSignature: {Sign("whsec", now, body)}},
+			body:   body,
+			want:   "invalid signature",
+		},
+		{"too old", signed("whsec", old), body, 5 * time.Minute, "timestamp outside of the tolerance"},
+		{"too far in the future", signed("whsec", future), body, 5 * time.Minute, "timestamp outside of the tolerance"},
+		{"invalid timestamp", signed("whsec", "yesterday"), body, 5 * time.Minute, "invalid timestamp"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Verify("whsec", tt.header, tt.body, tt.tolerance)
+			switch {
+			case tt.want == "" && err != nil:
+				t.Errorf("got error %v, want none", err)
+			case tt.want != "" && (err == nil || err.Error() != tt.want):
+				t.Errorf("got error %v, want %s", err, tt.want)
+			}
+		})
+	}
+}
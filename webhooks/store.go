@@ -0,0 +1,105 @@
+package webhooks
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/imariom/products-api/atomicfile"
+)
+
+// state is the content of the file the dispatcher is persisted to.
+type state struct {
+	NextSubscriptionID uint64          `json:"next_subscription_id"`
+	Subscriptions      []*Subscription `json:"subscriptions"`
+
+	// Deliveries is the outbox of the former versions, which kept the
+	// deliveries along with the subscriptions. The pending ones are
+	// queued when loaded.
+	Deliveries []*legacyDelivery `json:"deliveries,omitempty"`
+}
+
+// legacyDelivery is a delivery of the former outbox.
+type legacyDelivery struct {
+	SubscriptionID uint64          `json:"subscription_id"`
+	EventID        uint64          `json:"event_id"`
+	EventType      string          `json:"event_type"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+}
+
+// load restores the subscriptions persisted to the file of the
+// dispatcher, if any.
+func (d *Dispatcher) load() error {
+	if d.opts.Path == "" {
+		return nil
+	}
+
+	b, err := os.ReadFile(d.opts.Path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read webhooks file: %w", err)
+	}
+
+	var st state
+	if err := json.Unmarshal(b, &st); err != nil {
+		return fmt.Errorf("failed to decode webhooks file %s: %w", d.opts.Path, err)
+	}
+
+	if st.NextSubscriptionID > d.nextSubID {
+		d.nextSubID = st.NextSubscriptionID
+	}
+
+	for _, s := range st.Subscriptions {
+		d.subscriptions[s.ID] = s
+	}
+
+	if len(st.Deliveries) == 0 {
+		return nil
+	}
+
+	for _, dl := range st.Deliveries {
+		if dl.Status != StatusPending {
+			continue
+		}
+
+		_, err := d.enqueueDelivery(deliveryJob{
+			SubscriptionID: dl.SubscriptionID,
+			EventID:        dl.EventID,
+			EventType:      dl.EventType,
+			Payload:        dl.Payload,
+		}, "")
+		if err != nil {
+			return fmt.Errorf("failed to queue the deliveries of webhooks file %s: %w", d.opts.Path, err)
+		}
+	}
+
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	return d.saveLocked()
+}
+
+// saveLocked persists the subscriptions.
+func (d *Dispatcher) saveLocked() error {
+	if d.opts.Path == "" {
+		return nil
+	}
+
+	st := state{
+		NextSubscriptionID: d.nextSubID,
+		Subscriptions:      make([]*Subscription, 0, len(d.subscriptions)),
+	}
+	for _, s := range d.subscriptions {
+		st.Subscriptions = append(st.Subscriptions, s)
+	}
+
+	err := atomicfile.WriteJSON(d.opts.Path, st)
+	if err != nil {
+		d.logger.Error("failed to persist webhooks", "path", d.opts.Path, "error", err)
+	}
+
+	return err
+}
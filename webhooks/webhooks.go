@@ -0,0 +1,587 @@
+// Package webhooks delivers the events of the data store to the HTTP
+// endpoints subscribed to them. Deliveries are signed (see Sign) and
+// run as jobs of a queue.Queue, which persists them until they succeed,
+// retries them with an exponential backoff, and keeps the failed ones
+// in its dead letters.
+package webhooks
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/imariom/products-api/events"
+	"github.com/imariom/products-api/logging"
+	"github.com/imariom/products-api/queue"
+	"github.com/imariom/products-api/tracing"
+)
+
+// DeliveryKind is the kind of the queued jobs delivering the events.
+const DeliveryKind = "webhooks.deliver"
+
+// default options of a Dispatcher
+const (
+	DefaultMaxAttempts = 8
+	DefaultTimeout     = 10 * time.Second
+)
+
+// Delivery statuses.
+const (
+	StatusPending   = "pending"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+)
+
+// ErrNotFound is returned for unknown subscriptions and deliveries.
+var ErrNotFound = errors.New("not found")
+
+// eventTypeRe matches the event types subscriptions may select, e.g.
+// product.created or cart.updated.
+var eventTypeRe = regexp.MustCompile(`^[a-z]+\.[a-z_]+$`)
+
+// Subscription sends the events of the selected types to an URL.
+type Subscription struct {
+	ID  uint64 `json:"id"`
+	URL string `json:"url" validate:"required,maxlen=2048"`
+
+	// Events are the types of the events delivered, e.g.
+	// product.created, or "*" for all of them.
+	Events []string `json:"events"`
+
+	// Secret signs the deliveries. It is generated when empty and only
+	// returned when the subscription is created.
+	Secret string `json:"secret,omitempty" validate:"maxlen=200"`
+
+	Description string `json:"description,omitempty" validate:"maxlen=200"`
+
+	// Disabled subscriptions get no new deliveries, and their pending
+	// ones fail. They can be redelivered once it is enabled again.
+	Disabled bool `json:"disabled"`
+
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ValidationError describes an invalid subscription.
+type ValidationError struct {
+	Field string
+	Msg   string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid field '%s': %s", e.Field, e.Msg)
+}
+
+// validate checks the fields not covered by the validate tags.
+func (s *Subscription) validate() error {
+	u, err := url.Parse(s.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return &ValidationError{"url", "must be an absolute http or https URL"}
+	}
+
+	if len(s.Events) == 0 {
+		return &ValidationError{"events", "at least one event type is required"}
+	}
+
+	for _, e := range s.Events {
+		if e != "*" && !eventTypeRe.MatchString(e) {
+			return &ValidationError{"events", fmt.Sprintf("invalid event type '%s'", e)}
+		}
+	}
+
+	return nil
+}
+
+// selects reports whether events of type typ are delivered to s.
+func (s *Subscription) selects(typ string) bool {
+	for _, e := range s.Events {
+		if e == "*" || e == typ {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Delivery is the delivery of an event to a subscription, a view of the
+// queued job delivering it. Its ID is the ID of the job.
+type Delivery struct {
+	ID             uint64 `json:"id"`
+	SubscriptionID uint64 `json:"subscription_id"`
+	EventID        uint64 `json:"event_id"`
+	EventType      string `json:"event_type"`
+
+	// Payload is the body sent to the receiver, the JSON encoded event.
+	Payload json.RawMessage `json:"payload"`
+
+	Status      string             `json:"status"`
+	Attempts    []queue.JobAttempt `json:"attempts"`
+	NextAttempt *time.Time         `json:"next_attempt,omitempty"`
+
+	// RedeliveryOf is the delivery manually redelivered by this one.
+	RedeliveryOf uint64 `json:"redelivery_of,omitempty"`
+
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// deliveryJob is the payload of the jobs delivering the events.
+type deliveryJob struct {
+	SubscriptionID uint64          `json:"subscription_id"`
+	EventID        uint64          `json:"event_id"`
+	EventType      string          `json:"event_type"`
+	Payload        json.RawMessage `json:"payload"`
+	RedeliveryOf   uint64          `json:"redelivery_of,omitempty"`
+}
+
+// DeliveryFilter selects the deliveries returned by Deliveries. Zero
+// fields select all the deliveries.
+type DeliveryFilter struct {
+	SubscriptionID uint64
+	EventType      string
+	Status         string
+	Limit, Offset  int
+}
+
+// Options configures a Dispatcher.
+type Options struct {
+	// Path is the file the subscriptions are persisted to. They are only
+	// kept in memory when empty.
+	Path string
+
+	// Queue runs the deliveries. It is required.
+	Queue *queue.Queue
+
+	// MaxAttempts is the number of attempts before a delivery fails.
+	MaxAttempts int
+
+	// Timeout bounds each attempt.
+	Timeout time.Duration
+
+	// Client sends the deliveries, by default a client tracing the
+	// requests with tracing.Transport.
+	Client *http.Client
+
+	Logger *logging.Logger
+}
+
+// Dispatcher delivers the events published on a bus to the webhook
+// subscriptions. It is created with New, started with Start and stopped
+// with Stop.
+type Dispatcher struct {
+	opts   Options
+	logger *logging.Logger
+	queue  *queue.Queue
+
+	mtx           sync.Mutex
+	nextSubID     uint64
+	subscriptions map[uint64]*Subscription
+
+	// pending are the events published and not yet queued. Publishers
+	// may hold the locks of the data stores, so they only hand the
+	// events over to the goroutine started by Start, woken by wake,
+	// which queues their deliveries
+	pendingMtx sync.Mutex
+	pending    []events.Event
+	wake       chan struct{}
+
+	// unhandle stops handling the events of the bus once started, and
+	// stop stops the goroutine queueing them, which closes done
+	unhandle func()
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// New creates a dispatcher, loading the subscriptions persisted to
+// opts.Path, and registers the deliveries on opts.Queue.
+func New(opts Options) (*Dispatcher, error) {
+	if opts.Queue == nil {
+		return nil, fmt.Errorf("webhooks: a queue is required")
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = DefaultMaxAttempts
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+	if opts.Client == nil {
+		opts.Client = &http.Client{
+			Transport: &tracing.Transport{},
+			// redirects are not followed, the receiver must be
+			// subscribed with its final URL
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		}
+	}
+	if opts.Logger == nil {
+		opts.Logger = logging.Default
+	}
+
+	d := &Dispatcher{
+		opts:          opts,
+		logger:        opts.Logger,
+		queue:         opts.Queue,
+		nextSubID:     1,
+		subscriptions: map[uint64]*Subscription{},
+		wake:          make(chan struct{}, 1),
+	}
+
+	d.queue.Register(DeliveryKind, d.deliver)
+
+	if err := d.load(); err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+// Start delivers the events published on bus from now on. The
+// deliveries of an event are queued by a goroutine as soon as it is
+// published, so that publishers never wait for the queue to persist
+// them, and none is missed however many events are published.
+func (d *Dispatcher) Start(bus *events.Bus) {
+	stop, done := make(chan struct{}), make(chan struct{})
+	go d.run(stop, done)
+
+	remove := bus.Handle(d.hold)
+
+	d.mtx.Lock()
+	d.unhandle = remove
+	d.stop, d.done = stop, done
+	d.mtx.Unlock()
+}
+
+// Stop stops delivering the events published, and waits for the
+// deliveries of the events already published to be queued, or for ctx
+// to be done. The pending deliveries stay in the queue.
+func (d *Dispatcher) Stop(ctx context.Context) error {
+	d.mtx.Lock()
+	remove, stop, done := d.unhandle, d.stop, d.done
+	d.unhandle, d.stop, d.done = nil, nil, nil
+	d.mtx.Unlock()
+
+	if remove == nil {
+		return nil
+	}
+	remove()
+	close(stop)
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// hold keeps e until its deliveries are queued by run. It is called by
+// Publish and does not wait for the queue.
+func (d *Dispatcher) hold(e events.Event) {
+	d.pendingMtx.Lock()
+	d.pending = append(d.pending, e)
+	d.pendingMtx.Unlock()
+
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run queues the deliveries of the events held as they are published,
+// until stop is closed, and then those of the events still held.
+func (d *Dispatcher) run(stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+
+	for {
+		select {
+		case <-d.wake:
+			d.flush()
+		case <-stop:
+			d.flush()
+			return
+		}
+	}
+}
+
+// flush queues the deliveries of the events held, in the order they
+// were published.
+func (d *Dispatcher) flush() {
+	d.pendingMtx.Lock()
+	pending := d.pending
+	d.pending = nil
+	d.pendingMtx.Unlock()
+
+	for _, e := range pending {
+		d.enqueue(e)
+	}
+}
+
+// enqueue queues the deliveries of e.
+func (d *Dispatcher) enqueue(e events.Event) {
+	d.mtx.Lock()
+	var subs []uint64
+	for _, s := range d.subscriptions {
+		if !s.Disabled && s.selects(e.Type) {
+			subs = append(subs, s.ID)
+		}
+	}
+	d.mtx.Unlock()
+
+	if len(subs) == 0 {
+		return
+	}
+	sort.Slice(subs, func(i, j int) bool { return subs[i] < subs[j] })
+
+	payload, err := json.Marshal(e)
+	if err != nil {
+		deliveriesLost.Add(float64(len(subs)))
+		d.logger.Error("failed to encode webhook event", "event_id", e.ID, "error", err)
+		return
+	}
+
+	for _, id := range subs {
+		dj := deliveryJob{
+			SubscriptionID: id,
+			EventID:        e.ID,
+			EventType:      e.Type,
+			Payload:        payload,
+		}
+		if _, err := d.enqueueDelivery(dj, e.Traceparent); err != nil {
+			deliveriesLost.Inc()
+			d.logger.Error("failed to queue webhook delivery",
+				"event_id", e.ID, "subscription_id", id, "error", err)
+		}
+	}
+}
+
+// enqueueDelivery queues the job of dj and returns its delivery. The
+// job carries on the trace of traceparent, if set: that of the event.
+func (d *Dispatcher) enqueueDelivery(dj deliveryJob, traceparent string) (Delivery, error) {
+	b, err := json.Marshal(dj)
+	if err != nil {
+		return Delivery{}, err
+	}
+
+	j := &queue.Job{Kind: DeliveryKind, Payload: b, MaxAttempts: d.opts.MaxAttempts, Traceparent: traceparent}
+	if err := d.queue.Enqueue(j); err != nil {
+		return Delivery{}, err
+	}
+
+	return toDelivery(*j)
+}
+
+// Subscriptions returns the subscriptions, without their secret.
+func (d *Dispatcher) Subscriptions() []Subscription {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	subs := make([]Subscription, 0, len(d.subscriptions))
+	for _, s := range d.subscriptions {
+		subs = append(subs, redact(s))
+	}
+	sort.Slice(subs, func(i, j int) bool { return subs[i].ID < subs[j].ID })
+
+	return subs
+}
+
+// Subscription returns the subscription id, without its secret.
+func (d *Dispatcher) Subscription(id uint64) (Subscription, error) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	s, ok := d.subscriptions[id]
+	if !ok {
+		return Subscription{}, ErrNotFound
+	}
+
+	return redact(s), nil
+}
+
+// AddSubscription validates and adds s, setting its ID, its creation
+// time and its secret when empty.
+func (d *Dispatcher) AddSubscription(s *Subscription) error {
+	if err := s.validate(); err != nil {
+		return err
+	}
+
+	if s.Secret == "" {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return err
+		}
+		s.Secret = hex.EncodeToString(b)
+	}
+
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	s.ID = d.nextSubID
+	s.CreatedAt = time.Now().UTC()
+	d.nextSubID++
+
+	c := *s
+	c.Events = append([]string(nil), s.Events...)
+	d.subscriptions[s.ID] = &c
+
+	return d.saveLocked()
+}
+
+// SetSubscription replaces the subscription s.ID. Its secret is kept
+// when s.Secret is empty.
+func (d *Dispatcher) SetSubscription(s *Subscription) error {
+	if err := s.validate(); err != nil {
+		return err
+	}
+
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	old, ok := d.subscriptions[s.ID]
+	if !ok {
+		return ErrNotFound
+	}
+
+	c := *s
+	c.Events = append([]string(nil), s.Events...)
+	c.CreatedAt = old.CreatedAt
+	if c.Secret == "" {
+		c.Secret = old.Secret
+	}
+	d.subscriptions[s.ID] = &c
+
+	*s = redact(&c)
+
+	return d.saveLocked()
+}
+
+// RemoveSubscription removes the subscription id. Its pending
+// deliveries fail.
+func (d *Dispatcher) RemoveSubscription(id uint64) (Subscription, error) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	s, ok := d.subscriptions[id]
+	if !ok {
+		return Subscription{}, ErrNotFound
+	}
+	delete(d.subscriptions, id)
+
+	return redact(s), d.saveLocked()
+}
+
+// Deliveries returns the deliveries selected by filter, the most recent
+// first.
+func (d *Dispatcher) Deliveries(filter DeliveryFilter) []Delivery {
+	list := []Delivery{}
+	for _, j := range d.queue.Jobs(queue.JobFilter{Kind: DeliveryKind}) {
+		dl, err := toDelivery(j)
+		if err != nil {
+			continue
+		}
+
+		if filter.SubscriptionID != 0 && dl.SubscriptionID != filter.SubscriptionID {
+			continue
+		}
+		if filter.EventType != "" && dl.EventType != filter.EventType {
+			continue
+		}
+		if filter.Status != "" && dl.Status != filter.Status {
+			continue
+		}
+
+		list = append(list, dl)
+	}
+
+	if filter.Offset >= len(list) {
+		return []Delivery{}
+	}
+	list = list[filter.Offset:]
+	if filter.Limit > 0 && filter.Limit < len(list) {
+		list = list[:filter.Limit]
+	}
+
+	return list
+}
+
+// Delivery returns the delivery id.
+func (d *Dispatcher) Delivery(id uint64) (Delivery, error) {
+	j, err := d.queue.Job(id)
+	if err != nil || j.Kind != DeliveryKind {
+		return Delivery{}, ErrNotFound
+	}
+
+	return toDelivery(j)
+}
+
+// Redeliver queues a new delivery of the payload of the delivery id,
+// e.g. once the receiver of a failed delivery was fixed.
+func (d *Dispatcher) Redeliver(id uint64) (Delivery, error) {
+	dl, err := d.Delivery(id)
+	if err != nil {
+		return Delivery{}, err
+	}
+
+	d.mtx.Lock()
+	_, ok := d.subscriptions[dl.SubscriptionID]
+	d.mtx.Unlock()
+
+	if !ok {
+		return Delivery{}, fmt.Errorf("subscription %d no longer exists", dl.SubscriptionID)
+	}
+
+	return d.enqueueDelivery(deliveryJob{
+		SubscriptionID: dl.SubscriptionID,
+		EventID:        dl.EventID,
+		EventType:      dl.EventType,
+		Payload:        dl.Payload,
+		RedeliveryOf:   dl.ID,
+	}, "")
+}
+
+// toDelivery returns the delivery run by the job j.
+func toDelivery(j queue.Job) (Delivery, error) {
+	var dj deliveryJob
+	if err := json.Unmarshal(j.Payload, &dj); err != nil {
+		return Delivery{}, err
+	}
+
+	dl := Delivery{
+		ID:             j.ID,
+		SubscriptionID: dj.SubscriptionID,
+		EventID:        dj.EventID,
+		EventType:      dj.EventType,
+		Payload:        dj.Payload,
+		Status:         StatusPending,
+		Attempts:       j.Attempts,
+		NextAttempt:    j.RunAt,
+		RedeliveryOf:   dj.RedeliveryOf,
+		CreatedAt:      j.CreatedAt,
+		FinishedAt:     j.FinishedAt,
+	}
+
+	switch j.Status {
+	case queue.StatusSucceeded:
+		dl.Status = StatusSucceeded
+	case queue.StatusDead:
+		dl.Status = StatusFailed
+	}
+
+	return dl, nil
+}
+
+// redact returns a copy of s without its secret.
+func redact(s *Subscription) Subscription {
+	c := *s
+	c.Events = append([]string(nil), s.Events...)
+	c.Secret = ""
+	return c
+}
@@ -0,0 +1,578 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/imariom/products-api/events"
+	"github.com/imariom/products-api/logging"
+	"github.com/imariom/products-api/queue"
+	"github.com/imariom/products-api/tracing"
+)
+
+const testSecret = "whsec"
+
+// receiver is a webhook endpoint answering the deliveries with the
+// given statuses in turn, the last one repeated. It fails the test for
+// the deliveries that are not properly signed.
+type receiver struct {
+	*httptest.Server
+
+	mtx      sync.Mutex
+	statuses []int
+	bodies   [][]byte
+}
+
+func newReceiver(t *testing.T, statuses ...int) *receiver {
+	t.Helper()
+
+	rc := &receiver{statuses: statuses}
+	rc.Server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("got a %s request of %q, want a JSON POST", r.Method, r.Header.Get("Content-Type"))
+		}
+		if err := Verify(testSecret, r.Header, body, time.Minute); err != nil {
+			t.Errorf("failed to verify delivery: %v", err)
+		}
+
+		var e events.Event
+		if err := json.Unmarshal(body, &e); err != nil || e.Type != r.Header.Get(HeaderEvent) {
+			t.Errorf("got event %s of type %q, want the type of %s header", body, e.Type, HeaderEvent)
+		}
+		if _, err := strconv.ParseUint(r.Header.Get(HeaderID), 10, 64); err != nil {
+			t.Errorf("got delivery ID %q", r.Header.Get(HeaderID))
+		}
+
+		rc.mtx.Lock()
+		status := rc.statuses[0]
+		if len(rc.statuses) > 1 {
+			rc.statuses = rc.statuses[1:]
+		}
+		rc.bodies = append(rc.bodies, body)
+		rc.mtx.Unlock()
+
+		if status == http.StatusFound {
+			rw.Header().Set("Location", "/elsewhere")
+		}
+		rw.WriteHeader(status)
+	}))
+	t.Cleanup(rc.Close)
+
+	return rc
+}
+
+func (rc *receiver) deliveries() int {
+	rc.mtx.Lock()
+	defer rc.mtx.Unlock()
+	return len(rc.bodies)
+}
+
+// newDispatcher returns a dispatcher of the events of bus, with a queue
+// retrying quickly, which is started unless stopped is set.
+func newDispatcher(t *testing.T, opts Options, stopped bool) (*Dispatcher, *events.Bus, *queue.Queue) {
+	t.Helper()
+
+	q, err := queue.New(queue.Options{
+		MinBackoff: 5 * time.Millisecond,
+		MaxBackoff: 20 * time.Millisecond,
+		Logger:     logging.Discard,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts.Queue = q
+	opts.Logger = logging.Discard
+	d, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bus := events.NewBus(0)
+	d.Start(bus)
+	if !stopped {
+		q.Start(context.Background())
+	}
+
+	t.Cleanup(func() {
+		d.Stop(context.Background())
+		q.Stop(context.Background())
+	})
+
+	return d, bus, q
+}
+
+// waitQueued waits for n deliveries to be queued, and returns them.
+func waitQueued(t *testing.T, d *Dispatcher, n int) []Delivery {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		list := d.Deliveries(DeliveryFilter{})
+		if len(list) >= n || time.Now().After(deadline) {
+			if len(list) != n {
+				t.Fatalf("got %d deliveries, want %d", len(list), n)
+			}
+			return list
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// waitDelivery waits for the delivery id to end, and returns it.
+func waitDelivery(t *testing.T, d *Dispatcher, id uint64) Delivery {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		dl, err := d.Delivery(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dl.Status != StatusPending || time.Now().After(deadline) {
+			return dl
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// attemptErrors returns the errors of the attempts of dl.
+func attemptErrors(dl Delivery) []string {
+	errs := []string{}
+	for _, a := range dl.Attempts {
+		errs = append(errs, a.Error)
+	}
+	return errs
+}
+
+func TestDelivery(t *testing.T) {
+	tests := []struct {
+		name        string
+		statuses    []int
+		maxAttempts int
+		status      string
+		errors      []string
+	}{
+		{
+			name:     "delivered",
+			statuses: []int{http.StatusOK},
+			status:   StatusSucceeded,
+			errors:   []string{""},
+		},
+		{
+			name:     "retried until delivered",
+			statuses: []int{http.StatusInternalServerError, http.StatusServiceUnavailable, http.StatusNoContent},
+			status:   StatusSucceeded,
+			errors: []string{
+				"receiver answered 500 Internal Server Error",
+				"receiver answered 503 Service Unavailable",
+				"",
+			},
+		},
+		{
+			name:        "failed after the last attempt",
+			statuses:    []int{http.StatusBadGateway},
+			maxAttempts: 3,
+			status:      StatusFailed,
+			errors: []string{
+				"receiver answered 502 Bad Gateway",
+				"receiver answered 502 Bad Gateway",
+				"receiver answered 502 Bad Gateway",
+			},
+		},
+		{
+			name:        "redirects are not followed",
+			statuses:    []int{http.StatusFound},
+			maxAttempts: 1,
+			status:      StatusFailed,
+			errors:      []string{"receiver answered 302 Found"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rc := newReceiver(t, tt.statuses...)
+			d, bus, _ := newDispatcher(t, Options{MaxAttempts: tt.maxAttempts}, false)
+
+			s := &Subscription{URL: rc.URL, Events: []string{"product.created"}, Secret: testSecret}
+			if err := d.AddSubscription(s); err != nil {
+				t.Fatal(err)
+			}
+
+			e := bus.Publish("product.created", []string{"products", "products:1"}, map[string]interface{}{"id": 1}, nil)
+
+			list := waitQueued(t, d, 1)
+			if dl := list[0]; dl.SubscriptionID != s.ID || dl.EventID != e.ID || dl.EventType != e.Type {
+				t.Errorf("got delivery %+v, want event %d of subscription %d", dl, e.ID, s.ID)
+			}
+
+			dl := waitDelivery(t, d, list[0].ID)
+			if dl.Status != tt.status {
+				t.Fatalf("got status %s, want %s", dl.Status, tt.status)
+			}
+			if got := attemptErrors(dl); strings.Join(got, "|") != strings.Join(tt.errors, "|") {
+				t.Errorf("got attempts %q, want %q", got, tt.errors)
+			}
+			if dl.FinishedAt == nil || dl.NextAttempt != nil {
+				t.Errorf("got finished at %v and next attempt %v, want finished", dl.FinishedAt, dl.NextAttempt)
+			}
+			if n := rc.deliveries(); n != len(tt.errors) {
+				t.Errorf("the receiver got %d deliveries, want %d", n, len(tt.errors))
+			}
+		})
+	}
+}
+
+// TestDeliverySelection checks that events are delivered to the enabled
+// subscriptions selecting their type only.
+func TestDeliverySelection(t *testing.T) {
+	rc := newReceiver(t, http.StatusOK)
+	d, bus, _ := newDispatcher(t, Options{}, true)
+
+	subs := []*Subscription{
+		{URL: rc.URL, Events: []string{"product.created", "product.deleted"}},
+		{URL: rc.URL, Events: []string{"*"}},
+		{URL: rc.URL, Events: []string{"cart.updated"}},
+		{URL: rc.URL, Events: []string{"*"}, Disabled: true},
+	}
+	for _, s := range subs {
+		if err := d.AddSubscription(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	bus.Publish("product.created", []string{"products"}, nil, nil)
+	bus.Publish("cart.created", []string{"carts"}, nil, nil)
+	waitQueued(t, d, 3)
+
+	// the deliveries are listed the most recent first
+	tests := []struct {
+		filter DeliveryFilter
+		want   []uint64
+	}{
+		{DeliveryFilter{SubscriptionID: subs[0].ID}, []uint64{1}},
+		{DeliveryFilter{SubscriptionID: subs[1].ID}, []uint64{2, 1}},
+		{DeliveryFilter{SubscriptionID: subs[2].ID}, nil},
+		{DeliveryFilter{SubscriptionID: subs[3].ID}, nil},
+		{DeliveryFilter{EventType: "product.created"}, []uint64{1, 1}},
+		{DeliveryFilter{Status: StatusPending, Limit: 1}, []uint64{2}},
+		{DeliveryFilter{Offset: 1, Limit: 1}, []uint64{1}},
+		{DeliveryFilter{Offset: 3}, nil},
+	}
+
+	for _, tt := range tests {
+		var got []uint64
+		for _, dl := range d.Deliveries(tt.filter) {
+			got = append(got, dl.EventID)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Deliveries(%+v) are of the events %v, want %v", tt.filter, got, tt.want)
+		}
+	}
+}
+
+// TestRedeliver checks that a failed delivery is delivered again once
+// redelivered.
+func TestRedeliver(t *testing.T) {
+	rc := newReceiver(t, http.StatusInternalServerError, http.StatusOK)
+	d, bus, _ := newDispatcher(t, Options{MaxAttempts: 1}, false)
+
+	if err := d.AddSubscription(&Subscription{URL: rc.URL, Events: []string{"*"}, Secret: testSecret}); err != nil {
+		t.Fatal(err)
+	}
+	bus.Publish("product.updated", []string{"products"}, map[string]interface{}{"id": 1}, nil)
+
+	failed := waitDelivery(t, d, waitQueued(t, d, 1)[0].ID)
+	if failed.Status != StatusFailed {
+		t.Fatalf("got status %s, want %s", failed.Status, StatusFailed)
+	}
+
+	redelivery, err := d.Redeliver(failed.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if redelivery.RedeliveryOf != failed.ID || string(redelivery.Payload) != string(failed.Payload) {
+		t.Errorf("got redelivery %+v, want the payload of delivery %d", redelivery, failed.ID)
+	}
+
+	if dl := waitDelivery(t, d, redelivery.ID); dl.Status != StatusSucceeded {
+		t.Errorf("got status %s, want %s", dl.Status, StatusSucceeded)
+	}
+	if _, err := d.Redeliver(12345); !errors.Is(err, ErrNotFound) {
+		t.Errorf("got error %v redelivering an unknown delivery, want ErrNotFound", err)
+	}
+}
+
+// TestDeliveryOfChangedSubscription checks that the pending deliveries
+// of the subscriptions removed or disabled meanwhile fail at once.
+func TestDeliveryOfChangedSubscription(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(d *Dispatcher, s *Subscription) error
+		want   string
+	}{
+		{
+			name: "removed",
+			change: func(d *Dispatcher, s *Subscription) error {
+				_, err := d.RemoveSubscription(s.ID)
+				return err
+			},
+			want: "subscription 1 no longer exists",
+		},
+		{
+			name: "disabled",
+			change: func(d *Dispatcher, s *Subscription) error {
+				s.Disabled = true
+				return d.SetSubscription(s)
+			},
+			want: "subscription 1 is disabled",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rc := newReceiver(t, http.StatusOK)
+			d, bus, q := newDispatcher(t, Options{}, true)
+
+			s := &Subscription{URL: rc.URL, Events: []string{"*"}}
+			if err := d.AddSubscription(s); err != nil {
+				t.Fatal(err)
+			}
+			bus.Publish("product.created", []string{"products"}, nil, nil)
+			queued := waitQueued(t, d, 1)
+
+			if err := tt.change(d, s); err != nil {
+				t.Fatal(err)
+			}
+			q.Start(context.Background())
+
+			dl := waitDelivery(t, d, queued[0].ID)
+			if got := attemptErrors(dl); dl.Status != StatusFailed || len(got) != 1 || got[0] != tt.want {
+				t.Errorf("got status %s with attempts %q, want %s after %q", dl.Status, got, StatusFailed, tt.want)
+			}
+			if rc.deliveries() != 0 {
+				t.Errorf("the receiver got %d deliveries, want none", rc.deliveries())
+			}
+		})
+	}
+}
+
+func TestDeliveryTimeout(t *testing.T) {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer slow.Close()
+	defer close(release)
+
+	d, bus, _ := newDispatcher(t, Options{MaxAttempts: 1, Timeout: 20 * time.Millisecond}, false)
+	if err := d.AddSubscription(&Subscription{URL: slow.URL, Events: []string{"*"}}); err != nil {
+		t.Fatal(err)
+	}
+	bus.Publish("product.created", []string{"products"}, nil, nil)
+
+	dl := waitDelivery(t, d, waitQueued(t, d, 1)[0].ID)
+	if got := attemptErrors(dl); dl.Status != StatusFailed || len(got) != 1 || !strings.Contains(got[0], "context deadline exceeded") {
+		t.Errorf("got status %s with attempts %q, want a failed delivery after the deadline", dl.Status, got)
+	}
+}
+
+// TestDeliveryTrace checks that the deliveries carry on the trace the
+// event was published in.
+func TestDeliveryTrace(t *testing.T) {
+	d, bus, q := newDispatcher(t, Options{}, true)
+	if err := d.AddSubscription(&Subscription{URL: "http://127.0.0.1:1", Events: []string{"*"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, err := tracing.ParseTraceparent(traceparent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := tracing.ContextWithRemoteSpanContext(context.Background(), sc)
+
+	bus.PublishContext(ctx, "product.created", []string{"products"}, nil, nil)
+	bus.Publish("product.updated", []string{"products"}, nil, nil)
+
+	list := waitQueued(t, d, 2)
+	for _, dl := range list {
+		j, err := q.Job(dl.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := ""
+		if dl.EventType == "product.created" {
+			want = traceparent
+		}
+		if j.Traceparent != want {
+			t.Errorf("got the traceparent %q for %s, want %q", j.Traceparent, dl.EventType, want)
+		}
+		if strings.Contains(string(dl.Payload), "traceparent") {
+			t.Errorf("got the payload %s, want no traceparent", dl.Payload)
+		}
+	}
+}
+
+// TestStop checks that the deliveries of the events published before
+// Stop are queued, and those of the events published after it are not.
+func TestStop(t *testing.T) {
+	d, bus, _ := newDispatcher(t, Options{}, true)
+	if err := d.AddSubscription(&Subscription{URL: "http://127.0.0.1:1", Events: []string{"*"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 100; i++ {
+		bus.Publish("product.created", []string{"products"}, nil, nil)
+	}
+	if err := d.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	bus.Publish("product.created", []string{"products"}, nil, nil)
+
+	list := d.Deliveries(DeliveryFilter{})
+	if len(list) != 100 {
+		t.Fatalf("got %d deliveries, want 100", len(list))
+	}
+	for i, dl := range list {
+		if dl.EventID != uint64(100-i) {
+			t.Fatalf("got the delivery of event %d at %d, want the events in the order they were published", dl.EventID, i)
+		}
+	}
+}
+
+// TestPublishDoesNotWait checks that publishing does not wait for the
+// deliveries to be queued, as publishers hold the locks of the stores.
+func TestPublishDoesNotWait(t *testing.T) {
+	d, bus, _ := newDispatcher(t, Options{}, true)
+	if err := d.AddSubscription(&Subscription{URL: "http://127.0.0.1:1", Events: []string{"*"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// keep the dispatcher from queueing the deliveries
+	locked := make(chan struct{})
+	release := make(chan struct{})
+	go func() {
+		d.mtx.Lock()
+		close(locked)
+		<-release
+		d.mtx.Unlock()
+	}()
+	<-locked
+
+	published := make(chan struct{})
+	go func() {
+		bus.Publish("product.created", []string{"products"}, nil, nil)
+		close(published)
+	}()
+
+	select {
+	case <-published:
+	case <-time.After(time.Second):
+		t.Error("Publish waited for the dispatcher")
+	}
+	close(release)
+	waitQueued(t, d, 1)
+}
+
+func TestAddSubscriptionErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		s    Subscription
+		want string
+	}{
+		{"relative URL", Subscription{URL: "/hooks", Events: []string{"*"}}, "invalid field 'url': must be an absolute http or https URL"},
+		{"other scheme", Subscription{URL: "ftp://example.com/hooks", Events: []string{"*"}}, "invalid field 'url': must be an absolute http or https URL"},
+		{"no events", Subscription{URL: "https://example.com/hooks"}, "invalid field 'events': at least one event type is required"},
+		{"invalid event", Subscription{URL: "https://example.com/hooks", Events: []string{"product.created", "Product"}}, "invalid field 'events': invalid event type 'Product'"},
+	}
+
+	d, _, _ := newDispatcher(t, Options{}, true)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := d.AddSubscription(&tt.s)
+			var verr *ValidationError
+			if !errors.As(err, &verr) || err.Error() != tt.want {
+				t.Errorf("got error %v, want %s", err, tt.want)
+			}
+		})
+	}
+
+	if len(d.Subscriptions()) != 0 {
+		t.Errorf("got %d subscriptions, want none", len(d.Subscriptions()))
+	}
+}
+
+// TestSubscriptions checks that the secrets are generated, never
+// returned, kept when not replaced, and persisted.
+func TestSubscriptions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "webhooks.json")
+	d, _, _ := newDispatcher(t, Options{Path: path}, true)
+
+	s := &Subscription{URL: "https://example.com/hooks", Events: []string{"*"}}
+	if err := d.AddSubscription(s); err != nil {
+		t.Fatal(err)
+	}
+	if s.ID != 1 || len(s.Secret) != 64 || s.CreatedAt.IsZero() {
+		t.Fatalf("got subscription %+v, want ID 1 with a generated secret", s)
+	}
+	secret := s.Secret
+
+	got, err := d.Subscription(s.ID)
+	if err != nil || got.Secret != "" || got.URL != s.URL {
+		t.Errorf("got subscription %+v (%v), want it without its secret", got, err)
+	}
+
+	update := &Subscription{ID: s.ID, URL: "https://example.com/v2/hooks", Events: []string{"cart.updated"}}
+	if err := d.SetSubscription(update); err != nil {
+		t.Fatal(err)
+	}
+	if update.Secret != "" || !update.CreatedAt.Equal(s.CreatedAt) {
+		t.Errorf("got updated subscription %+v, want it without its secret and created at %s", update, s.CreatedAt)
+	}
+
+	// a dispatcher of the same file has the subscriptions and secrets
+	reloaded, _, _ := newDispatcher(t, Options{Path: path}, true)
+	subs := reloaded.Subscriptions()
+	if len(subs) != 1 || subs[0].URL != update.URL || subs[0].Events[0] != "cart.updated" {
+		t.Fatalf("got subscriptions %+v after reloading, want %+v", subs, update)
+	}
+	if reloaded.subscriptions[s.ID].Secret != secret {
+		t.Error("the secret changed when reloaded")
+	}
+
+	next := &Subscription{URL: "https://example.com/hooks", Events: []string{"*"}}
+	if err := reloaded.AddSubscription(next); err != nil || next.ID != 2 {
+		t.Errorf("got subscription %d (%v) after reloading, want 2", next.ID, err)
+	}
+
+	if _, err := reloaded.RemoveSubscription(s.ID); err != nil {
+		t.Fatal(err)
+	}
+	for _, fn := range []func() error{
+		func() error { _, err := reloaded.Subscription(s.ID); return err },
+		func() error { _, err := reloaded.RemoveSubscription(s.ID); return err },
+		func() error { return reloaded.SetSubscription(update) },
+	} {
+		if err := fn(); !errors.Is(err, ErrNotFound) {
+			t.Errorf("got error %v for a removed subscription, want ErrNotFound", err)
+		}
+	}
+}
@@ -0,0 +1,321 @@
+// Package websocket implements the server side of the WebSocket
+// protocol (RFC 6455) needed to push messages to clients: the opening
+// handshake, text messages, pings and the closing handshake. Extensions
+// and fragmented messages sent by the server are not supported.
+package websocket
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the GUID appended to the key of the client to compute the accept key
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// DefaultMaxMessageSize limits the size of the messages read by default.
+const DefaultMaxMessageSize = 64 << 10
+
+// frame opcodes
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+)
+
+// Close status codes.
+const (
+	CloseNormal          = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	CloseUnsupportedData = 1003
+	CloseMessageTooBig   = 1009
+	CloseInternalError   = 1011
+	CloseTryAgainLater   = 1013
+)
+
+// CloseError is returned by ReadMessage once the peer closed the
+// connection.
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket: connection closed (%d %s)", e.Code, e.Reason)
+}
+
+// ErrMessageTooBig is returned by ReadMessage for messages larger than
+// the maximum message size of the connection.
+var ErrMessageTooBig = errors.New("websocket: message too big")
+
+// IsUpgrade reports whether r asks to upgrade the connection to the
+// WebSocket protocol.
+func IsUpgrade(r *http.Request) bool {
+	return headerContains(r.Header, "Connection", "upgrade") &&
+		headerContains(r.Header, "Upgrade", "websocket")
+}
+
+// Upgrade completes the opening handshake of r and takes over its
+// connection. On failure an error response is sent to the client.
+func Upgrade(rw http.ResponseWriter, r *http.Request) (*Conn, error) {
+	if r.Method != http.MethodGet || !IsUpgrade(r) {
+		http.Error(rw, "expected a WebSocket upgrade request", http.StatusBadRequest)
+		return nil, errors.New("websocket: not an upgrade request")
+	}
+
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		rw.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(rw, "unsupported WebSocket version", http.StatusUpgradeRequired)
+		return nil, errors.New("websocket: unsupported version")
+	}
+
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		http.Error(rw, "missing Sec-WebSocket-Key", http.StatusBadRequest)
+		return nil, errors.New("websocket: missing key")
+	}
+
+	netConn, brw, err := http.NewResponseController(rw).Hijack()
+	if err != nil {
+		http.Error(rw, "the connection cannot be upgraded", http.StatusInternalServerError)
+		return nil, err
+	}
+
+	// the deadlines of the server no longer apply to the connection
+	netConn.SetDeadline(time.Time{})
+
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	fmt.Fprintf(brw, "HTTP/1.1 101 Switching Protocols\r\n"+
+		"Upgrade: websocket\r\n"+
+		"Connection: Upgrade\r\n"+
+		"Sec-WebSocket-Accept: %s\r\n\r\n", base64.StdEncoding.EncodeToString(sum[:]))
+	if err := brw.Flush(); err != nil {
+		netConn.Close()
+		return nil, err
+	}
+
+	return &Conn{
+		conn:           netConn,
+		br:             brw.Reader,
+		MaxMessageSize: DefaultMaxMessageSize,
+	}, nil
+}
+
+// Conn is a server side WebSocket connection. ReadMessage must be
+// called from a single goroutine; the write methods may be called
+// concurrently.
+type Conn struct {
+	// MaxMessageSize limits the size of the messages read.
+	MaxMessageSize int
+
+	conn net.Conn
+	br   *bufio.Reader
+
+	writeMtx  sync.Mutex
+	closeSent bool
+}
+
+// ReadMessage returns the next text or binary message of the peer,
+// answering pings meanwhile. It returns a *CloseError once the peer
+// closed the connection.
+func (c *Conn) ReadMessage() (text bool, msg []byte, err error) {
+	var (
+		started bool
+		opcode  byte
+	)
+
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			return false, nil, err
+		}
+
+		switch op {
+		case opPing:
+			if err := c.writeFrame(opPong, payload, time.Time{}); err != nil {
+				return false, nil, err
+			}
+			continue
+
+		case opPong:
+			continue
+
+		case opClose:
+			ce := &CloseError{Code: CloseNormal}
+			if len(payload) >= 2 {
+				ce.Code = int(binary.BigEndian.Uint16(payload))
+				ce.Reason = string(payload[2:])
+			}
+			c.Close(ce.Code, "")
+			return false, nil, ce
+
+		case opText, opBinary:
+			if started {
+				c.Close(CloseProtocolError, "expected a continuation frame")
+				return false, nil, errors.New("websocket: unexpected data frame")
+			}
+			started, opcode = true, op
+
+		case opContinuation:
+			if !started {
+				c.Close(CloseProtocolError, "unexpected continuation frame")
+				return false, nil, errors.New("websocket: unexpected continuation frame")
+			}
+
+		default:
+			c.Close(CloseProtocolError, "unknown opcode")
+			return false, nil, fmt.Errorf("websocket: unknown opcode %d", op)
+		}
+
+		if len(msg)+len(payload) > c.MaxMessageSize {
+			c.Close(CloseMessageTooBig, "")
+			return false, nil, ErrMessageTooBig
+		}
+		msg = append(msg, payload...)
+
+		if fin {
+			return opcode == opText, msg, nil
+		}
+	}
+}
+
+// readFrame reads a frame of the client, which must be masked.
+func (c *Conn) readFrame() (fin bool, op byte, payload []byte, err error) {
+	var head [2]byte
+	if _, err := io.ReadFull(c.br, head[:]); err != nil {
+		return false, 0, nil, err
+	}
+
+	fin = head[0]&0x80 != 0
+	op = head[0] & 0x0f
+	if head[0]&0x70 != 0 {
+		c.Close(CloseProtocolError, "unexpected reserved bits")
+		return false, 0, nil, errors.New("websocket: unexpected reserved bits")
+	}
+	if head[1]&0x80 == 0 {
+		c.Close(CloseProtocolError, "unmasked frame")
+		return false, 0, nil, errors.New("websocket: unmasked client frame")
+	}
+
+	length := uint64(head[1] & 0x7f)
+	switch length {
+	case 126:
+		var b [2]byte
+		if _, err := io.ReadFull(c.br, b[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(b[:]))
+	case 127:
+		var b [8]byte
+		if _, err := io.ReadFull(c.br, b[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(b[:])
+	}
+
+	if op >= opClose && (length > 125 || !fin) {
+		c.Close(CloseProtocolError, "invalid control frame")
+		return false, 0, nil, errors.New("websocket: invalid control frame")
+	}
+	if length > uint64(c.MaxMessageSize) {
+		c.Close(CloseMessageTooBig, "")
+		return false, 0, nil, ErrMessageTooBig
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+
+	payload = make([]byte, length)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+
+	return fin, op, payload, nil
+}
+
+// WriteText sends msg as a text message, waiting at most until
+// deadline (no limit when zero).
+func (c *Conn) WriteText(msg []byte, deadline time.Time) error {
+	return c.writeFrame(opText, msg, deadline)
+}
+
+// Ping sends a ping to keep the connection alive through proxies.
+func (c *Conn) Ping(deadline time.Time) error {
+	return c.writeFrame(opPing, nil, deadline)
+}
+
+// Close starts the closing handshake with code and reason, then closes
+// the connection. It may be called more than once.
+func (c *Conn) Close(code int, reason string) error {
+	if len(reason) > 123 {
+		reason = reason[:123]
+	}
+
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	payload = append(payload, reason...)
+
+	c.writeFrame(opClose, payload, time.Now().Add(time.Second))
+	return c.conn.Close()
+}
+
+func (c *Conn) writeFrame(op byte, payload []byte, deadline time.Time) error {
+	c.writeMtx.Lock()
+	defer c.writeMtx.Unlock()
+
+	if c.closeSent {
+		return net.ErrClosed
+	}
+	if op == opClose {
+		c.closeSent = true
+	}
+
+	frame := make([]byte, 0, 10+len(payload))
+	frame = append(frame, 0x80|op)
+	switch n := len(payload); {
+	case n <= 125:
+		frame = append(frame, byte(n))
+	case n <= 0xffff:
+		frame = append(frame, 126, byte(n>>8), byte(n))
+	default:
+		frame = append(frame, 127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+	frame = append(frame, payload...)
+
+	c.conn.SetWriteDeadline(deadline)
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+// headerContains reports whether the comma separated values of the
+// header name contain value, ignoring case.
+func headerContains(h http.Header, name, value string) bool {
+	for _, v := range h.Values(name) {
+		for _, s := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(s), value) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,524 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// frame is a frame sent by the client, masked unless unmasked is set,
+// or read from the server. rsv holds the reserved bits of the first byte.
+type frame struct {
+	fin      bool
+	rsv      byte
+	op       byte
+	payload  []byte
+	unmasked bool
+}
+
+func (f frame) bytes() []byte {
+	b := []byte{f.rsv | f.op}
+	if f.fin {
+		b[0] |= 0x80
+	}
+
+	mask := byte(0x80)
+	if f.unmasked {
+		mask = 0
+	}
+	switch n := len(f.payload); {
+	case n <= 125:
+		b = append(b, mask|byte(n))
+	case n <= 0xffff:
+		b = append(b, mask|126, byte(n>>8), byte(n))
+	default:
+		b = append(b, mask|127)
+		b = binary.BigEndian.AppendUint64(b, uint64(n))
+	}
+
+	if f.unmasked {
+		return append(b, f.payload...)
+	}
+
+	key := [4]byte{0x37, 0xfa, 0x21, 0x3d}
+	b = append(b, key[:]...)
+	for i, c := range f.payload {
+		b = append(b, c^key[i%4])
+	}
+	return b
+}
+
+// readFrame reads a frame of the server, which must not be masked.
+func readFrame(t *testing.T, r io.Reader) frame {
+	t.Helper()
+
+	var head [2]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		t.Fatalf("failed to read frame: %v", err)
+	}
+	if head[1]&0x80 != 0 {
+		t.Fatal("the server masked a frame")
+	}
+
+	n := uint64(head[1] & 0x7f)
+	switch n {
+	case 126:
+		var b [2]byte
+		io.ReadFull(r, b[:])
+		n = uint64(binary.BigEndian.Uint16(b[:]))
+	case 127:
+		var b [8]byte
+		io.ReadFull(r, b[:])
+		n = binary.BigEndian.Uint64(b[:])
+	}
+
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		t.Fatalf("failed to read frame payload: %v", err)
+	}
+
+	return frame{fin: head[0]&0x80 != 0, rsv: head[0] & 0x70, op: head[0] & 0x0f, payload: payload}
+}
+
+// closePayload returns the payload of a close frame.
+func closePayload(code int, reason string) []byte {
+	b := binary.BigEndian.AppendUint16(nil, uint16(code))
+	return append(b, reason...)
+}
+
+// pipe returns the server side connection of a TCP connection over the
+// loopback interface, whose writes do not wait for the reads, and the
+// client side.
+func pipe(t *testing.T) (*Conn, net.Conn) {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	client, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	server.SetDeadline(time.Now().Add(5 * time.Second))
+
+	return &Conn{conn: server, br: bufio.NewReader(server), MaxMessageSize: 1024}, client
+}
+
+func TestReadMessage(t *testing.T) {
+	big := bytes.Repeat([]byte("a"), 300)
+
+	tests := []struct {
+		name   string
+		frames []frame
+		text   bool
+		msg    []byte
+		// frames the server sends back while reading the message
+		replies []frame
+	}{
+		{
+			name:   "text",
+			frames: []frame{{fin: true, op: opText, payload: []byte("hello")}},
+			text:   true,
+			msg:    []byte("hello"),
+		},
+		{
+			name:   "binary",
+			frames: []frame{{fin: true, op: opBinary, payload: []byte{0, 1, 2}}},
+			msg:    []byte{0, 1, 2},
+		},
+		{
+			name:   "empty",
+			frames: []frame{{fin: true, op: opText}},
+			text:   true,
+			msg:    []byte{},
+		},
+		{
+			name:   "16-bit length",
+			frames: []frame{{fin: true, op: opText, payload: big}},
+			text:   true,
+			msg:    big,
+		},
+		{
+			name: "fragmented",
+			frames: []frame{
+				{op: opText, payload: []byte("hel")},
+				{op: opContinuation, payload: []byte("l")},
+				{fin: true, op: opContinuation, payload: []byte("o")},
+			},
+			text: true,
+			msg:  []byte("hello"),
+		},
+		{
+			name: "pings are answered between fragments",
+			frames: []frame{
+				{fin: true, op: opPing, payload: []byte("1")},
+				{op: opText, payload: []byte("hel")},
+				{fin: true, op: opPing, payload: []byte("2")},
+				{fin: true, op: opPong, payload: []byte("ignored")},
+				{fin: true, op: opContinuation, payload: []byte("lo")},
+			},
+			text: true,
+			msg:  []byte("hello"),
+			replies: []frame{
+				{fin: true, op: opPong, payload: []byte("1")},
+				{fin: true, op: opPong, payload: []byte("2")},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, client := pipe(t)
+
+			for _, f := range tt.frames {
+				client.Write(f.bytes())
+			}
+
+			text, msg, err := c.ReadMessage()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if text != tt.text || !bytes.Equal(msg, tt.msg) {
+				t.Errorf("got text %t %q, want text %t %q", text, msg, tt.text, tt.msg)
+			}
+
+			for _, want := range tt.replies {
+				if got := readFrame(t, client); got.op != want.op || !bytes.Equal(got.payload, want.payload) || !got.fin {
+					t.Errorf("got reply %+v, want %+v", got, want)
+				}
+			}
+		})
+	}
+}
+
+// TestReadMessageErrors checks that the frames breaking the protocol
+// fail the connection with the close code of the error.
+func TestReadMessageErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		frames []frame
+		err    error
+		code   int
+	}{
+		{
+			name:   "unmasked frame",
+			frames: []frame{{fin: true, op: opText, payload: []byte("x"), unmasked: true}},
+			code:   CloseProtocolError,
+		},
+		{
+			name:   "reserved bits",
+			frames: []frame{{fin: true, rsv: 0x40, op: opText, payload: []byte("x")}},
+			code:   CloseProtocolError,
+		},
+		{
+			name:   "unknown opcode",
+			frames: []frame{{fin: true, op: 0x3, payload: []byte("x")}},
+			code:   CloseProtocolError,
+		},
+		{
+			name:   "fragmented control frame",
+			frames: []frame{{op: opPing, payload: []byte("x")}},
+			code:   CloseProtocolError,
+		},
+		{
+			name:   "control frame too long",
+			frames: []frame{{fin: true, op: opPing, payload: bytes.Repeat([]byte("x"), 126)}},
+			code:   CloseProtocolError,
+		},
+		{
+			name:   "continuation without a message",
+			frames: []frame{{fin: true, op: opContinuation, payload: []byte("x")}},
+			code:   CloseProtocolError,
+		},
+		{
+			name: "message within a fragmented message",
+			frames: []frame{
+				{op: opText, payload: []byte("x")},
+				{fin: true, op: opText, payload: []byte("y")},
+			},
+			code: CloseProtocolError,
+		},
+		{
+			name:   "frame too big",
+			frames: []frame{{fin: true, op: opBinary, payload: make([]byte, 70000)}},
+			err:    ErrMessageTooBig,
+			code:   CloseMessageTooBig,
+		},
+		{
+			name: "fragmented message too big",
+			frames: []frame{
+				{op: opBinary, payload: make([]byte, 1000)},
+				{fin: true, op: opContinuation, payload: make([]byte, 25)},
+			},
+			err:  ErrMessageTooBig,
+			code: CloseMessageTooBig,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, client := pipe(t)
+
+			go func() {
+				for _, f := range tt.frames {
+					client.Write(f.bytes())
+				}
+			}()
+
+			_, _, err := c.ReadMessage()
+			if err == nil {
+				t.Fatal("read a message, want an error")
+			}
+			if tt.err != nil && !errors.Is(err, tt.err) {
+				t.Errorf("got error %v, want %v", err, tt.err)
+			}
+
+			f := readFrame(t, client)
+			if f.op != opClose || len(f.payload) < 2 {
+				t.Fatalf("got frame %+v, want a close frame", f)
+			}
+			if code := int(binary.BigEndian.Uint16(f.payload)); code != tt.code {
+				t.Errorf("closed with %d, want %d", code, tt.code)
+			}
+
+			// the server closed the connection after the close frame
+			if n, err := client.Read(make([]byte, 1)); err == nil {
+				t.Errorf("read %d bytes after the close frame, want the connection closed", n)
+			}
+		})
+	}
+}
+
+// TestClosingHandshake checks that the close frames of the client are
+// answered with their code before the connection is closed.
+func TestClosingHandshake(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload []byte
+		want    CloseError
+	}{
+		{"code and reason", closePayload(CloseGoingAway, "bye"), CloseError{Code: CloseGoingAway, Reason: "bye"}},
+		{"code", closePayload(CloseNormal, ""), CloseError{Code: CloseNormal}},
+		{"no code", nil, CloseError{Code: CloseNormal}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, client := pipe(t)
+			client.Write(frame{fin: true, op: opClose, payload: tt.payload}.bytes())
+
+			_, _, err := c.ReadMessage()
+			var ce *CloseError
+			if !errors.As(err, &ce) || *ce != tt.want {
+				t.Fatalf("got error %v, want %v", err, &tt.want)
+			}
+
+			f := readFrame(t, client)
+			if f.op != opClose || !bytes.Equal(f.payload, closePayload(tt.want.Code, "")) {
+				t.Errorf("got frame %+v, want a close frame with %d", f, tt.want.Code)
+			}
+
+			if err := c.WriteText([]byte("late"), time.Time{}); !errors.Is(err, net.ErrClosed) {
+				t.Errorf("got %v writing after the close, want net.ErrClosed", err)
+			}
+		})
+	}
+}
+
+// TestWriteFrames checks the frames written by the server: final,
+// unmasked, with the shortest length encoding.
+func TestWriteFrames(t *testing.T) {
+	tests := []struct {
+		name   string
+		write  func(c *Conn) error
+		header []byte
+		size   int
+	}{
+		{"empty text", func(c *Conn) error { return c.WriteText(nil, time.Time{}) }, []byte{0x81, 0}, 0},
+		{"7-bit length", func(c *Conn) error { return c.WriteText(make([]byte, 125), time.Time{}) }, []byte{0x81, 125}, 125},
+		{"16-bit length", func(c *Conn) error { return c.WriteText(make([]byte, 126), time.Time{}) }, []byte{0x81, 126, 0, 126}, 126},
+		{"largest 16-bit length", func(c *Conn) error { return c.WriteText(make([]byte, 0xffff), time.Time{}) }, []byte{0x81, 126, 0xff, 0xff}, 0xffff},
+		{"64-bit length", func(c *Conn) error { return c.WriteText(make([]byte, 0x10000), time.Time{}) }, []byte{0x81, 127, 0, 0, 0, 0, 0, 1, 0, 0}, 0x10000},
+		{"ping", func(c *Conn) error { return c.Ping(time.Time{}) }, []byte{0x89, 0}, 0},
+		{"close", func(c *Conn) error { return c.Close(CloseGoingAway, "") }, []byte{0x88, 2, 0x03, 0xe9}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, client := pipe(t)
+
+			errc := make(chan error, 1)
+			go func() { errc <- tt.write(c) }()
+
+			header := make([]byte, len(tt.header))
+			if _, err := io.ReadFull(client, header); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(header, tt.header) {
+				t.Errorf("got header % x, want % x", header, tt.header)
+			}
+
+			payload := make([]byte, tt.size)
+			if _, err := io.ReadFull(client, payload); err != nil {
+				t.Fatal(err)
+			}
+			if err := <-errc; err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestCloseTruncatesReason(t *testing.T) {
+	c, client := pipe(t)
+	go c.Close(CloseNormal, strings.Repeat("r", 200))
+
+	// control frames are limited to 125 bytes
+	f := readFrame(t, client)
+	if len(f.payload) != 125 || !bytes.Equal(f.payload, closePayload(CloseNormal, strings.Repeat("r", 123))) {
+		t.Errorf("got a close payload of %d bytes, want 125", len(f.payload))
+	}
+}
+
+func TestUpgrade(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		c, err := Upgrade(rw, r)
+		if err != nil {
+			return
+		}
+		defer c.Close(CloseNormal, "")
+
+		// echo the messages
+		for {
+			_, msg, err := c.ReadMessage()
+			if err != nil {
+				return
+			}
+			c.WriteText(msg, time.Time{})
+		}
+	}))
+	defer srv.Close()
+
+	handshake := func(t *testing.T, method string, header http.Header) (*http.Response, net.Conn, *bufio.Reader) {
+		conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+		req, _ := http.NewRequest(method, srv.URL+"/", nil)
+		req.Header = header
+		if err := req.Write(conn); err != nil {
+			t.Fatal(err)
+		}
+
+		br := bufio.NewReader(conn)
+		res, err := http.ReadResponse(br, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res, conn, br
+	}
+
+	valid := func() http.Header {
+		return http.Header{
+			"Connection":            {"keep-alive, Upgrade"},
+			"Upgrade":               {"websocket"},
+			"Sec-Websocket-Version": {"13"},
+			// the key of the example of RFC 6455, section 1.3
+			"Sec-Websocket-Key": {"dGhlIHNhbXBsZSBub25jZQ=="},
+		}
+	}
+
+	t.Run("handshake", func(t *testing.T) {
+		res, conn, br := handshake(t, http.MethodGet, valid())
+
+		if res.StatusCode != http.StatusSwitchingProtocols {
+			t.Fatalf("got status %d, want 101", res.StatusCode)
+		}
+		if got := res.Header.Get("Sec-WebSocket-Accept"); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+			t.Errorf("got accept key %q, want the key of RFC 6455", got)
+		}
+		if !headerContains(res.Header, "Upgrade", "websocket") || !headerContains(res.Header, "Connection", "upgrade") {
+			t.Errorf("got headers %v, want an upgrade", res.Header)
+		}
+
+		conn.Write(frame{fin: true, op: opText, payload: []byte("echo")}.bytes())
+		if f := readFrame(t, br); f.op != opText || string(f.payload) != "echo" {
+			t.Errorf("got frame %+v, want the echo", f)
+		}
+	})
+
+	tests := []struct {
+		name   string
+		method string
+		header func(h http.Header)
+		status int
+	}{
+		{"not a GET", http.MethodPost, func(h http.Header) {}, http.StatusBadRequest},
+		{"no upgrade", http.MethodGet, func(h http.Header) { h.Del("Upgrade") }, http.StatusBadRequest},
+		{"no connection upgrade", http.MethodGet, func(h http.Header) { h.Set("Connection", "keep-alive") }, http.StatusBadRequest},
+		{"unsupported version", http.MethodGet, func(h http.Header) { h.Set("Sec-Websocket-Version", "8") }, http.StatusUpgradeRequired},
+		{"no key", http.MethodGet, func(h http.Header) { h.Del("Sec-Websocket-Key") }, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := valid()
+			tt.header(h)
+
+			res, _, _ := handshake(t, tt.method, h)
+			if res.StatusCode != tt.status {
+				t.Errorf("got status %d, want %d", res.StatusCode, tt.status)
+			}
+			if tt.status == http.StatusUpgradeRequired && res.Header.Get("Sec-WebSocket-Version") != "13" {
+				t.Errorf("got Sec-WebSocket-Version %q, want 13", res.Header.Get("Sec-WebSocket-Version"))
+			}
+		})
+	}
+}
+
+func TestIsUpgrade(t *testing.T) {
+	tests := []struct {
+		connection, upgrade string
+		want                bool
+	}{
+		{"Upgrade", "websocket", true},
+		{"keep-alive, upgrade", "WebSocket", true},
+		{"keep-alive", "websocket", false},
+		{"Upgrade", "h2c", false},
+		{"", "", false},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Connection", tt.connection)
+		r.Header.Set("Upgrade", tt.upgrade)
+
+		if got := IsUpgrade(r); got != tt.want {
+			t.Errorf("IsUpgrade(Connection: %q, Upgrade: %q) = %t, want %t", tt.connection, tt.upgrade, got, tt.want)
+		}
+	}
+}